@@ -0,0 +1,200 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Audit
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	logging "github.com/linuxdeepin/deepin-network-proxy/logging"
+)
+
+// audit log keeps one json line per tunneled connection, so that which app reached which host is known.
+// file is rotated when it grows over max size or when day changes,
+// rotated files older than max age are removed.
+
+var logger *logging.Logger
+
+// default dir of audit log
+const DefaultDir = "/var/log/deepin-proxy"
+
+// default rotation
+const (
+	DefaultMaxSize = 10 * 1024 * 1024
+	DefaultMaxAge  = 30 * 24 * time.Hour
+)
+
+// name of file written, rotated files are named audit-<time>.log
+const (
+	fileName     = "audit.log"
+	rotatePrefix = "audit-"
+	rotateSuffix = ".log"
+	rotateLayout = "20060102T150405.000"
+)
+
+// result of connection
+const (
+	ResultClosed = "closed"
+	ResultFailed = "failed"
+)
+
+// one tunneled connection
+type Record struct {
+	Time        time.Time `json:"time"` // time connection is captured
+	Scope       string    `json:"scope"`
+	App         string    `json:"app"`
+	Pid         string    `json:"pid"`
+	Source      string    `json:"src"`
+	Destination string    `json:"dst"`   // original destination, domain if known
+	Proxy       string    `json:"proxy"` // proto/name, or direct
+	Sent        uint64    `json:"sent"`
+	Received    uint64    `json:"received"`
+	DurationMs  int64     `json:"duration_ms"`
+	Result      string    `json:"result"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// writer of audit log, safe for concurrent use
+type Writer struct {
+	dir     string
+	maxSize int64
+	maxAge  time.Duration
+
+	lock   sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time // day of file is the day it is opened
+}
+
+// zero max size or max age uses default
+func NewWriter(dir string, maxSize int64, maxAge time.Duration) *Writer {
+	if dir == "" {
+		dir = DefaultDir
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	if maxAge <= 0 {
+		maxAge = DefaultMaxAge
+	}
+	return &Writer{
+		dir:     dir,
+		maxSize: maxSize,
+		maxAge:  maxAge,
+	}
+}
+
+// append record as one line, file is rotated before if needed
+func (w *Writer) Write(rec Record) error {
+	buf, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	buf = append(buf, '\n')
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	now := time.Now()
+	if w.file != nil && (w.size+int64(len(buf)) > w.maxSize || !sameDay(w.opened, now)) {
+		err = w.rotate(now)
+		if err != nil {
+			logger.Warningf("[audit] rotate audit log failed, err: %v", err)
+		}
+	}
+	if w.file == nil {
+		err = w.open(now)
+		if err != nil {
+			return err
+		}
+	}
+	n, err := w.file.Write(buf)
+	w.size += int64(n)
+	return err
+}
+
+// open file to append, day of file existed is the day it is modified last
+func (w *Writer) open(now time.Time) error {
+	err := os.MkdirAll(w.dir, 0750)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(w.dir, fileName)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	w.file = file
+	w.size = info.Size()
+	w.opened = now
+	if w.size > 0 {
+		w.opened = info.ModTime()
+	}
+	return nil
+}
+
+// rename file in use and remove expired ones
+func (w *Writer) rotate(now time.Time) error {
+	_ = w.file.Close()
+	w.file = nil
+	w.size = 0
+	path := filepath.Join(w.dir, fileName)
+	err := os.Rename(path, filepath.Join(w.dir, rotatePrefix+now.Format(rotateLayout)+rotateSuffix))
+	if err != nil {
+		return err
+	}
+	w.prune(now)
+	return nil
+}
+
+// remove rotated files older than max age
+func (w *Writer) prune(now time.Time) {
+	names, err := filepath.Glob(filepath.Join(w.dir, rotatePrefix+"*"+rotateSuffix))
+	if err != nil {
+		return
+	}
+	for _, name := range names {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(name), rotatePrefix), rotateSuffix)
+		rotated, err := time.ParseInLocation(rotateLayout, stamp, now.Location())
+		if err != nil {
+			continue
+		}
+		if now.Sub(rotated) > w.maxAge {
+			err = os.Remove(name)
+			if err != nil {
+				logger.Warningf("[audit] remove expired audit log failed, err: %v", err)
+			}
+		}
+	}
+}
+
+// close file in use, file is opened again by next write
+func (w *Writer) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+func sameDay(a time.Time, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.In(a.Location()).Date()
+	return ay == by && am == bm && ad == bd
+}
+
+func init() {
+	logger = logging.New("proxy/audit")
+}
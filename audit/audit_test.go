@@ -0,0 +1,90 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Audit
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriterLine(t *testing.T) {
+	dir := t.TempDir()
+	w := NewWriter(dir, 0, 0)
+	defer w.Close()
+	rec := Record{
+		Time:        time.Now(),
+		Scope:       "app",
+		App:         "/usr/bin/curl",
+		Pid:         "1234",
+		Source:      "10.0.0.2:40000",
+		Destination: "example.com:443",
+		Proxy:       "http/office",
+		Sent:        100,
+		Received:    2000,
+		DurationMs:  1500,
+		Result:      ResultClosed,
+	}
+	if err := w.Write(rec); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := ioutil.ReadFile(filepath.Join(dir, fileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf, &line); err != nil {
+		t.Fatalf("line is not json, err: %v, line: %s", err, buf)
+	}
+	if line["app"] != "/usr/bin/curl" || line["dst"] != "example.com:443" || line["received"] != float64(2000) {
+		t.Errorf("line got %v", line)
+	}
+	if _, ok := line["error"]; ok {
+		t.Errorf("empty error should be omitted: %s", buf)
+	}
+}
+
+func TestWriterRotate(t *testing.T) {
+	dir := t.TempDir()
+	// rotated long ago, removed by next rotation
+	expired := filepath.Join(dir, rotatePrefix+time.Now().AddDate(0, 0, -40).Format(rotateLayout)+rotateSuffix)
+	if err := ioutil.WriteFile(expired, []byte("old\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	w := NewWriter(dir, 300, 0)
+	defer w.Close()
+	for index := 0; index < 5; index++ {
+		err := w.Write(Record{Time: time.Now(), App: "/usr/bin/wget", Destination: "example.com:80", Result: ResultClosed})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// names of rotated files are unique by milliseconds
+		time.Sleep(2 * time.Millisecond)
+	}
+	if _, err := os.Stat(expired); !os.IsNotExist(err) {
+		t.Errorf("expired audit log is kept, err: %v", err)
+	}
+	names, _ := filepath.Glob(filepath.Join(dir, rotatePrefix+"*"))
+	if len(names) == 0 {
+		t.Fatal("audit log is not rotated")
+	}
+	for _, name := range append(names, filepath.Join(dir, fileName)) {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > 300 {
+			t.Errorf("%s got size %d over max size", name, info.Size())
+		}
+	}
+	buf, _ := ioutil.ReadFile(filepath.Join(dir, fileName))
+	if !strings.HasSuffix(string(buf), "\n") {
+		t.Errorf("line is not complete: %s", buf)
+	}
+}
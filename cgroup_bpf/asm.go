@@ -0,0 +1,224 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package CGroupBPF
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// programs are tiny, so they are assembled here instead of compiled by clang,
+// no bpf toolchain or object file is needed at build time.
+
+// size of one instruction
+const insnSize = 8
+
+// registers
+const (
+	r0 uint8 = iota
+	r1
+	r2
+	r3
+	r4
+	r5
+	r6
+	r7
+	r8
+	r9
+	r10 // read only frame pointer
+)
+
+// instruction class
+const (
+	classLD    = 0x00
+	classLDX   = 0x01
+	classST    = 0x02
+	classSTX   = 0x03
+	classJMP   = 0x05
+	classALU64 = 0x07
+)
+
+// operand size
+const (
+	sizeW  = 0x00
+	sizeDW = 0x18
+)
+
+// operand mode
+const (
+	modeIMM = 0x00
+	modeMEM = 0x60
+)
+
+// alu and jump operation, source is imm or register
+const (
+	opAdd  = 0x00
+	opAnd  = 0x50
+	opRsh  = 0x70
+	opMov  = 0xb0
+	opJa   = 0x00
+	opJeq  = 0x10
+	opJne  = 0x50
+	opCall = 0x80
+	opExit = 0x90
+	srcK   = 0x00
+	srcX   = 0x08
+)
+
+// helper functions called by programs
+const (
+	fnMapLookupElem   = 1
+	fnMapUpdateElem   = 2
+	fnMapDeleteElem   = 3
+	fnGetSocketCookie = 46
+)
+
+// src register of 64 bit load means imm is map fd
+const pseudoMapFd = 1
+
+// one instruction, label marks position and occupies no slot, jump to target is resolved by assemble
+type insn struct {
+	code   uint8
+	dst    uint8
+	src    uint8
+	off    int16
+	imm    int32
+	label  string
+	target string
+}
+
+// dst = src
+func movReg(dst uint8, src uint8) insn {
+	return insn{code: classALU64 | opMov | srcX, dst: dst, src: src}
+}
+
+// dst = imm
+func movImm(dst uint8, imm int32) insn {
+	return insn{code: classALU64 | opMov | srcK, dst: dst, imm: imm}
+}
+
+// dst += imm
+func addImm(dst uint8, imm int32) insn {
+	return insn{code: classALU64 | opAdd | srcK, dst: dst, imm: imm}
+}
+
+// dst &= imm
+func andImm(dst uint8, imm int32) insn {
+	return insn{code: classALU64 | opAnd | srcK, dst: dst, imm: imm}
+}
+
+// dst >>= imm
+func rshImm(dst uint8, imm int32) insn {
+	return insn{code: classALU64 | opRsh | srcK, dst: dst, imm: imm}
+}
+
+// dst = *(u32 *)(src + off)
+func loadW(dst uint8, src uint8, off int16) insn {
+	return insn{code: classLDX | modeMEM | sizeW, dst: dst, src: src, off: off}
+}
+
+// *(u32 *)(dst + off) = src
+func storeW(dst uint8, off int16, src uint8) insn {
+	return insn{code: classSTX | modeMEM | sizeW, dst: dst, src: src, off: off}
+}
+
+// *(u64 *)(dst + off) = src
+func storeDW(dst uint8, off int16, src uint8) insn {
+	return insn{code: classSTX | modeMEM | sizeDW, dst: dst, src: src, off: off}
+}
+
+// *(u32 *)(dst + off) = imm, not allowed on context
+func storeImmW(dst uint8, off int16, imm int32) insn {
+	return insn{code: classST | modeMEM | sizeW, dst: dst, off: off, imm: imm}
+}
+
+// dst = map of fd, takes two slots
+func loadMapFd(dst uint8, fd int) insn {
+	return insn{code: classLD | modeIMM | sizeDW, dst: dst, src: pseudoMapFd, imm: int32(fd)}
+}
+
+// goto target
+func jump(target string) insn {
+	return insn{code: classJMP | opJa, target: target}
+}
+
+// if dst == imm goto target
+func jeqImm(dst uint8, imm int32, target string) insn {
+	return insn{code: classJMP | opJeq | srcK, dst: dst, imm: imm, target: target}
+}
+
+// if dst != imm goto target
+func jneImm(dst uint8, imm int32, target string) insn {
+	return insn{code: classJMP | opJne | srcK, dst: dst, imm: imm, target: target}
+}
+
+// r0 = fn(r1, r2, r3, r4, r5)
+func call(fn int32) insn {
+	return insn{code: classJMP | opCall, imm: fn}
+}
+
+// return r0
+func exit() insn {
+	return insn{code: classJMP | opExit}
+}
+
+// mark position of next instruction
+func label(name string) insn {
+	return insn{label: name}
+}
+
+// slots taken by instruction
+func (i insn) slots() int {
+	switch {
+	case i.label != "":
+		return 0
+	case i.code == classLD|modeIMM|sizeDW:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// encode instructions for little endian host, jumps are relative to next slot
+func assemble(prog []insn) ([]byte, error) {
+	labels := make(map[string]int)
+	var count int
+	for _, i := range prog {
+		if i.label != "" {
+			labels[i.label] = count
+		}
+		count += i.slots()
+	}
+	buf := make([]byte, 0, count*insnSize)
+	var pos int
+	for _, i := range prog {
+		if i.label != "" {
+			continue
+		}
+		if i.target != "" {
+			index, ok := labels[i.target]
+			if !ok {
+				return nil, fmt.Errorf("label %s not found", i.target)
+			}
+			i.off = int16(index - pos - 1)
+		}
+		buf = appendInsn(buf, i.code, i.dst|i.src<<4, i.off, i.imm)
+		// upper 32 bits of imm64 are zero
+		if i.slots() == 2 {
+			buf = appendInsn(buf, 0, 0, 0, 0)
+		}
+		pos += i.slots()
+	}
+	return buf, nil
+}
+
+func appendInsn(buf []byte, code uint8, regs uint8, off int16, imm int32) []byte {
+	var slot [insnSize]byte
+	slot[0] = code
+	slot[1] = regs
+	binary.LittleEndian.PutUint16(slot[2:], uint16(off))
+	binary.LittleEndian.PutUint32(slot[4:], uint32(imm))
+	return append(buf, slot[:]...)
+}
@@ -0,0 +1,227 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package CGroupBPF
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// oldest kernel supported, helpers used by programs are all available since
+const (
+	minKernelMajor = 5
+	minKernelMinor = 6
+)
+
+// length of program name, include tailing zero
+const objNameLen = 16
+
+// attach flag, allow programs of other owners on the same cgroup
+const flagAllowMulti = 1 << 1
+
+// size of verifier log, only used when load failed
+const verifierLogSize = 64 * 1024
+
+// attr of BPF_MAP_CREATE
+type mapCreateAttr struct {
+	mapType    uint32
+	keySize    uint32
+	valueSize  uint32
+	maxEntries uint32
+	mapFlags   uint32
+}
+
+// attr of BPF_MAP_*_ELEM
+type mapElemAttr struct {
+	mapFd uint32
+	_     uint32
+	key   uint64
+	value uint64
+	flags uint64
+}
+
+// attr of BPF_PROG_LOAD
+type progLoadAttr struct {
+	progType           uint32
+	insnCnt            uint32
+	insns              uint64
+	license            uint64
+	logLevel           uint32
+	logSize            uint32
+	logBuf             uint64
+	kernVersion        uint32
+	progFlags          uint32
+	progName           [objNameLen]byte
+	progIfindex        uint32
+	expectedAttachType uint32
+}
+
+// attr of BPF_PROG_ATTACH and BPF_PROG_DETACH
+type progAttachAttr struct {
+	targetFd    uint32
+	attachBpfFd uint32
+	attachType  uint32
+	attachFlags uint32
+}
+
+func bpf(cmd int, attr unsafe.Pointer, size uintptr) (int, error) {
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, uintptr(cmd), uintptr(attr), size)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(fd), nil
+}
+
+// check if kernel supports redirect by cgroup programs
+func Supported() error {
+	var uts unix.Utsname
+	err := unix.Uname(&uts)
+	if err != nil {
+		return err
+	}
+	release := unix.ByteSliceToString(uts.Release[:])
+	major, minor, err := parseRelease(release)
+	if err != nil {
+		return err
+	}
+	if major < minKernelMajor || (major == minKernelMajor && minor < minKernelMinor) {
+		return fmt.Errorf("kernel %s is older than %d.%d", release, minKernelMajor, minKernelMinor)
+	}
+	return nil
+}
+
+// major and minor of kernel release,   5.10.0-amd64-desktop
+func parseRelease(release string) (int, int, error) {
+	fields := strings.SplitN(release, ".", 3)
+	if len(fields) < 2 {
+		return 0, 0, fmt.Errorf("kernel release %s is invalid", release)
+	}
+	major, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("kernel release %s is invalid", release)
+	}
+	// minor may be followed by suffix when patch is absent,   5.6-rc1
+	minor := fields[1]
+	if index := strings.IndexFunc(minor, func(r rune) bool { return r < '0' || r > '9' }); index >= 0 {
+		minor = minor[:index]
+	}
+	num, err := strconv.Atoi(minor)
+	if err != nil {
+		return 0, 0, fmt.Errorf("kernel release %s is invalid", release)
+	}
+	return major, num, nil
+}
+
+// memory of maps is charged to memlock before 5.11
+func raiseMemlock() {
+	limit := &unix.Rlimit{Cur: unix.RLIM_INFINITY, Max: unix.RLIM_INFINITY}
+	err := unix.Setrlimit(unix.RLIMIT_MEMLOCK, limit)
+	if err != nil {
+		logger.Debugf("raise memlock limit failed, err: %v", err)
+	}
+}
+
+// create map, return fd
+func createMap(mapType uint32, keySize uint32, valueSize uint32, maxEntries uint32) (int, error) {
+	attr := mapCreateAttr{
+		mapType:    mapType,
+		keySize:    keySize,
+		valueSize:  valueSize,
+		maxEntries: maxEntries,
+	}
+	return bpf(unix.BPF_MAP_CREATE, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+}
+
+// copy value of key out of map
+func lookupElem(fd int, key []byte, value []byte) error {
+	attr := mapElemAttr{
+		mapFd: uint32(fd),
+		key:   uint64(uintptr(unsafe.Pointer(&key[0]))),
+		value: uint64(uintptr(unsafe.Pointer(&value[0]))),
+	}
+	_, err := bpf(unix.BPF_MAP_LOOKUP_ELEM, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(key)
+	runtime.KeepAlive(value)
+	return err
+}
+
+// delete key from map
+func deleteElem(fd int, key []byte) error {
+	attr := mapElemAttr{
+		mapFd: uint32(fd),
+		key:   uint64(uintptr(unsafe.Pointer(&key[0]))),
+	}
+	_, err := bpf(unix.BPF_MAP_DELETE_ELEM, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(key)
+	return err
+}
+
+// load program, verifier log is returned in error if load failed
+func loadProg(progType uint32, attachType uint32, name string, prog []insn) (int, error) {
+	code, err := assemble(prog)
+	if err != nil {
+		return -1, err
+	}
+	license := []byte("GPL\x00")
+	attr := progLoadAttr{
+		progType:           progType,
+		insnCnt:            uint32(len(code) / insnSize),
+		insns:              uint64(uintptr(unsafe.Pointer(&code[0]))),
+		license:            uint64(uintptr(unsafe.Pointer(&license[0]))),
+		expectedAttachType: attachType,
+	}
+	copy(attr.progName[:objNameLen-1], name)
+	fd, err := bpf(unix.BPF_PROG_LOAD, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	if err == nil {
+		runtime.KeepAlive(code)
+		runtime.KeepAlive(license)
+		return fd, nil
+	}
+	// load again with log, so that reason of verifier is known
+	log := make([]byte, verifierLogSize)
+	attr.logLevel = 1
+	attr.logSize = uint32(len(log))
+	attr.logBuf = uint64(uintptr(unsafe.Pointer(&log[0])))
+	fd, lErr := bpf(unix.BPF_PROG_LOAD, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(code)
+	runtime.KeepAlive(license)
+	runtime.KeepAlive(log)
+	if lErr == nil {
+		return fd, nil
+	}
+	msg := strings.TrimSpace(unix.ByteSliceToString(log))
+	if msg == "" {
+		return -1, err
+	}
+	return -1, fmt.Errorf("%v, verifier: %s", err, msg)
+}
+
+// attach program to cgroup of fd, programs of other owners such as systemd are kept
+func attachProg(cgroupFd int, progFd int, attachType uint32) error {
+	attr := progAttachAttr{
+		targetFd:    uint32(cgroupFd),
+		attachBpfFd: uint32(progFd),
+		attachType:  attachType,
+		attachFlags: flagAllowMulti,
+	}
+	_, err := bpf(unix.BPF_PROG_ATTACH, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	return err
+}
+
+// detach program from cgroup of fd
+func detachProg(cgroupFd int, progFd int, attachType uint32) error {
+	attr := progAttachAttr{
+		targetFd:    uint32(cgroupFd),
+		attachBpfFd: uint32(progFd),
+		attachType:  attachType,
+	}
+	_, err := bpf(unix.BPF_PROG_DETACH, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	return err
+}
@@ -0,0 +1,349 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package CGroupBPF
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+
+	logging "github.com/linuxdeepin/deepin-network-proxy/logging"
+	"golang.org/x/sys/unix"
+)
+
+var logger *logging.Logger
+
+// connect of proc in cgroup is redirected to t-proxy listener on loopback without any iptables rule.
+// cgroup/connect4 and cgroup/connect6 save origin destination by socket cookie and rewrite destination,
+// sockops moves origin destination to local port when connect starts, so that proxy finds it by peer port.
+// udp can not be redirected this way, as origin destination of each datagram is lost after sendmsg rewrite.
+
+// max entries of maps, entries of failed connect are evicted by lru
+const mapEntries = 65536
+
+// value of maps is u32 family, u32 port in network order and u8 ip[16], as they are in context
+const (
+	dstSize       = 24
+	dstFamilyOff  = 0
+	dstPortOff    = 4
+	dstIPOff      = 8
+	dstStackStart = -32 // value on stack of program, key is above
+)
+
+// offsets of struct bpf_sock_addr
+const (
+	sockAddrUserIP4  = 4
+	sockAddrUserIP6  = 8
+	sockAddrUserPort = 24
+	sockAddrType     = 32
+)
+
+// offsets of struct bpf_sock_ops
+const (
+	sockOpsOp        = 0
+	sockOpsLocalPort = 68
+)
+
+// loopback addresses as u32 loaded from context on little endian host
+const (
+	loopback4     = 0x0100007f // 127.0.0.1
+	loopback6Tail = 0x01000000 // last word of ::1
+	mapped6Word   = 0xffff     // third word of ::ffff:0:0/96 shifted right 16
+)
+
+// redirect connect of procs in one cgroup to t-proxy port
+type Redirector struct {
+	port     int
+	cookies  int // origin destination by socket cookie
+	ports    int // origin destination by local port
+	progs    []program
+	cgroupFd int
+}
+
+// loaded program and where it is attached
+type program struct {
+	fd         int
+	attachType uint32
+}
+
+// create maps and load programs, programs are not attached until Attach,
+// connect of family not redirected is not hooked, as nothing listens port of it
+func NewRedirector(port int, ipv4 bool, ipv6 bool) (*Redirector, error) {
+	raiseMemlock()
+	r := &Redirector{port: port, cookies: -1, ports: -1, cgroupFd: -1}
+	var err error
+	r.cookies, err = createMap(unix.BPF_MAP_TYPE_LRU_HASH, 8, dstSize, mapEntries)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	r.ports, err = createMap(unix.BPF_MAP_TYPE_LRU_HASH, 4, dstSize, mapEntries)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	type progLoad struct {
+		progType   uint32
+		attachType uint32
+		name       string
+		insns      []insn
+	}
+	var loads []progLoad
+	if ipv4 {
+		loads = append(loads, progLoad{unix.BPF_PROG_TYPE_CGROUP_SOCK_ADDR, unix.BPF_CGROUP_INET4_CONNECT, "proxy_connect4", r.connect4()})
+	}
+	if ipv6 {
+		loads = append(loads, progLoad{unix.BPF_PROG_TYPE_CGROUP_SOCK_ADDR, unix.BPF_CGROUP_INET6_CONNECT, "proxy_connect6", r.connect6()})
+	}
+	loads = append(loads, progLoad{unix.BPF_PROG_TYPE_SOCK_OPS, unix.BPF_CGROUP_SOCK_OPS, "proxy_sockops", r.sockOps()})
+	for _, load := range loads {
+		fd, err := loadProg(load.progType, load.attachType, load.name, load.insns)
+		if err != nil {
+			logger.Warningf("load bpf program %s failed, err: %v", load.name, err)
+			r.Close()
+			return nil, err
+		}
+		r.progs = append(r.progs, program{fd: fd, attachType: load.attachType})
+	}
+	return r, nil
+}
+
+// t-proxy port in network order, as user_port in context
+func (r *Redirector) portImm() int32 {
+	var buf [4]byte
+	binary.BigEndian.PutUint16(buf[:], uint16(r.port))
+	return int32(binary.LittleEndian.Uint32(buf[:]))
+}
+
+// save origin destination to cookies map, r6 is context, jump to out if not saved
+func (r *Redirector) saveDst(out string) []insn {
+	return []insn{
+		movReg(r1, r6),
+		call(fnGetSocketCookie),
+		storeDW(r10, -8, r0),
+		loadMapFd(r1, r.cookies),
+		movReg(r2, r10),
+		addImm(r2, -8),
+		movReg(r3, r10),
+		addImm(r3, dstStackStart),
+		movImm(r4, unix.BPF_ANY),
+		call(fnMapUpdateElem),
+		jneImm(r0, 0, out),
+	}
+}
+
+// return 1, connect goes on
+func allow() []insn {
+	return []insn{
+		label("out"),
+		movImm(r0, 1),
+		exit(),
+	}
+}
+
+// cgroup/connect4, tcp to non loopback is redirected to 127.0.0.1:port
+func (r *Redirector) connect4() []insn {
+	prog := []insn{
+		movReg(r6, r1),
+		loadW(r2, r6, sockAddrType),
+		jneImm(r2, unix.SOCK_STREAM, "out"),
+		loadW(r7, r6, sockAddrUserIP4),
+		movReg(r2, r7),
+		andImm(r2, 0xff),
+		jeqImm(r2, 127, "out"),
+		storeImmW(r10, dstStackStart+dstFamilyOff, unix.AF_INET),
+		loadW(r2, r6, sockAddrUserPort),
+		storeW(r10, dstStackStart+dstPortOff, r2),
+		storeW(r10, dstStackStart+dstIPOff, r7),
+		storeImmW(r10, dstStackStart+dstIPOff+4, 0),
+		storeImmW(r10, dstStackStart+dstIPOff+8, 0),
+		storeImmW(r10, dstStackStart+dstIPOff+12, 0),
+	}
+	prog = append(prog, r.saveDst("out")...)
+	prog = append(prog,
+		movImm(r2, loopback4),
+		storeW(r6, sockAddrUserIP4, r2),
+		movImm(r2, r.portImm()),
+		storeW(r6, sockAddrUserPort, r2),
+	)
+	return append(prog, allow()...)
+}
+
+// cgroup/connect6, tcp to non loopback is redirected to [::1]:port, include v4 mapped address
+func (r *Redirector) connect6() []insn {
+	prog := []insn{
+		movReg(r6, r1),
+		loadW(r2, r6, sockAddrType),
+		jneImm(r2, unix.SOCK_STREAM, "out"),
+		// ::1 and ::ffff:127.0.0.0/104 are loopback
+		loadW(r2, r6, sockAddrUserIP6),
+		jneImm(r2, 0, "save"),
+		loadW(r2, r6, sockAddrUserIP6+4),
+		jneImm(r2, 0, "save"),
+		loadW(r2, r6, sockAddrUserIP6+8),
+		jeqImm(r2, 0, "loopback6"),
+		rshImm(r2, 16),
+		jneImm(r2, mapped6Word, "save"),
+		loadW(r2, r6, sockAddrUserIP6+12),
+		andImm(r2, 0xff),
+		jeqImm(r2, 127, "out"),
+		jump("save"),
+		label("loopback6"),
+		loadW(r2, r6, sockAddrUserIP6+12),
+		jeqImm(r2, loopback6Tail, "out"),
+		label("save"),
+		storeImmW(r10, dstStackStart+dstFamilyOff, unix.AF_INET6),
+		loadW(r2, r6, sockAddrUserPort),
+		storeW(r10, dstStackStart+dstPortOff, r2),
+	}
+	for off := int16(0); off < net.IPv6len; off += 4 {
+		prog = append(prog,
+			loadW(r2, r6, sockAddrUserIP6+off),
+			storeW(r10, dstStackStart+dstIPOff+off, r2),
+		)
+	}
+	prog = append(prog, r.saveDst("out")...)
+	prog = append(prog,
+		movImm(r2, 0),
+		storeW(r6, sockAddrUserIP6, r2),
+		storeW(r6, sockAddrUserIP6+4, r2),
+		storeW(r6, sockAddrUserIP6+8, r2),
+		movImm(r2, loopback6Tail),
+		storeW(r6, sockAddrUserIP6+12, r2),
+		movImm(r2, r.portImm()),
+		storeW(r6, sockAddrUserPort, r2),
+	)
+	return append(prog, allow()...)
+}
+
+// sockops, when connect starts, origin destination is moved from cookie to local port
+func (r *Redirector) sockOps() []insn {
+	prog := []insn{
+		movReg(r6, r1),
+		loadW(r2, r6, sockOpsOp),
+		jneImm(r2, unix.BPF_SOCK_OPS_TCP_CONNECT_CB, "out"),
+		movReg(r1, r6),
+		call(fnGetSocketCookie),
+		storeDW(r10, -8, r0),
+		loadMapFd(r1, r.cookies),
+		movReg(r2, r10),
+		addImm(r2, -8),
+		call(fnMapLookupElem),
+		jeqImm(r0, 0, "out"),
+		movReg(r7, r0),
+		loadW(r2, r6, sockOpsLocalPort),
+		storeW(r10, -16, r2),
+		loadMapFd(r1, r.ports),
+		movReg(r2, r10),
+		addImm(r2, -16),
+		movReg(r3, r7),
+		movImm(r4, unix.BPF_ANY),
+		call(fnMapUpdateElem),
+		loadMapFd(r1, r.cookies),
+		movReg(r2, r10),
+		addImm(r2, -8),
+		call(fnMapDeleteElem),
+	}
+	return append(prog, allow()...)
+}
+
+// attach programs to cgroup dir
+func (r *Redirector) Attach(dir string) error {
+	if r.cgroupFd >= 0 {
+		return errors.New("programs are already attached")
+	}
+	fd, err := unix.Open(dir, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	for index, prog := range r.progs {
+		err = attachProg(fd, prog.fd, prog.attachType)
+		if err == nil {
+			continue
+		}
+		// attached ones are detached, procs are never half redirected
+		for _, attached := range r.progs[:index] {
+			_ = detachProg(fd, attached.fd, attached.attachType)
+		}
+		_ = unix.Close(fd)
+		return err
+	}
+	r.cgroupFd = fd
+	logger.Debugf("attach bpf programs to %s, port: %d", dir, r.port)
+	return nil
+}
+
+// detach programs from cgroup, programs are detached by kernel if cgroup is removed
+func (r *Redirector) Detach() error {
+	if r.cgroupFd < 0 {
+		return nil
+	}
+	var first error
+	for _, prog := range r.progs {
+		err := detachProg(r.cgroupFd, prog.fd, prog.attachType)
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	_ = unix.Close(r.cgroupFd)
+	r.cgroupFd = -1
+	return first
+}
+
+// detach programs and free maps and programs
+func (r *Redirector) Close() {
+	_ = r.Detach()
+	for _, prog := range r.progs {
+		_ = unix.Close(prog.fd)
+	}
+	r.progs = nil
+	for _, fd := range []int{r.cookies, r.ports} {
+		if fd >= 0 {
+			_ = unix.Close(fd)
+		}
+	}
+	r.cookies = -1
+	r.ports = -1
+}
+
+// origin destination of conn accepted by t-proxy listener, found by port of peer, entry is removed once read
+func (r *Redirector) OriginalDst(peer net.Addr) (net.Addr, error) {
+	tcpAddr, ok := peer.(*net.TCPAddr)
+	if !ok {
+		return nil, errors.New("peer is not tcp addr")
+	}
+	key := make([]byte, 4)
+	binary.LittleEndian.PutUint32(key, uint32(tcpAddr.Port))
+	value := make([]byte, dstSize)
+	err := lookupElem(r.ports, key, value)
+	if err != nil {
+		return nil, err
+	}
+	_ = deleteElem(r.ports, key)
+	return decodeDst(value)
+}
+
+// decode map value to tcp addr
+func decodeDst(value []byte) (net.Addr, error) {
+	if len(value) != dstSize {
+		return nil, errors.New("destination size is invalid")
+	}
+	addr := &net.TCPAddr{
+		Port: int(binary.BigEndian.Uint16(value[dstPortOff:])),
+	}
+	switch binary.LittleEndian.Uint32(value[dstFamilyOff:]) {
+	case unix.AF_INET:
+		addr.IP = net.IP(append([]byte(nil), value[dstIPOff:dstIPOff+net.IPv4len]...))
+	case unix.AF_INET6:
+		addr.IP = net.IP(append([]byte(nil), value[dstIPOff:dstIPOff+net.IPv6len]...))
+	default:
+		return nil, errors.New("destination family is invalid")
+	}
+	return addr, nil
+}
+
+func init() {
+	logger = logging.New("proxy/bpf")
+}
@@ -0,0 +1,96 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package CGroupBPF
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseRelease(t *testing.T) {
+	tests := []struct {
+		release string
+		major   int
+		minor   int
+		valid   bool
+	}{
+		{"5.10.0-amd64-desktop", 5, 10, true},
+		{"4.19.90-2102.2.0.0062.ctl2.x86_64", 4, 19, true},
+		{"5.6-rc1", 5, 6, true},
+		{"invalid", 0, 0, false},
+	}
+	for _, test := range tests {
+		major, minor, err := parseRelease(test.release)
+		if (err == nil) != test.valid {
+			t.Fatalf("parse %s, err: %v", test.release, err)
+		}
+		if major != test.major || minor != test.minor {
+			t.Errorf("parse %s, got %d.%d", test.release, major, minor)
+		}
+	}
+}
+
+func TestAssemble(t *testing.T) {
+	code, err := assemble([]insn{
+		jeqImm(r1, 0, "out"),
+		loadMapFd(r1, 3),
+		label("out"),
+		movImm(r0, 1),
+		exit(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// map fd takes two slots
+	if len(code) != 5*insnSize {
+		t.Fatalf("code size %d", len(code))
+	}
+	// jump over two slots of map fd
+	if code[2] != 2 || code[3] != 0 {
+		t.Errorf("jump offset %v", code[2:4])
+	}
+	// src of map fd load is pseudo map fd
+	if code[insnSize+1] != r1|pseudoMapFd<<4 || code[insnSize+4] != 3 {
+		t.Errorf("map fd load %v", code[insnSize:insnSize*2])
+	}
+	_, err = assemble([]insn{jump("missing")})
+	if err == nil {
+		t.Error("missing label should fail")
+	}
+}
+
+func TestDecodeDst(t *testing.T) {
+	r := &Redirector{port: 8080}
+	// 8080 is 0x1f90, network order in low bytes
+	if r.portImm() != 0x901f {
+		t.Errorf("port imm %#x", r.portImm())
+	}
+	value := make([]byte, dstSize)
+	value[dstFamilyOff] = 2
+	value[dstPortOff] = 0x01
+	value[dstPortOff+1] = 0xbb
+	copy(value[dstIPOff:], net.ParseIP("1.2.3.4").To4())
+	addr, err := decodeDst(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr.String() != "1.2.3.4:443" {
+		t.Errorf("decode v4 got %s", addr)
+	}
+	value[dstFamilyOff] = 10
+	copy(value[dstIPOff:], net.ParseIP("2001:db8::1"))
+	addr, err = decodeDst(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr.String() != "[2001:db8::1]:443" {
+		t.Errorf("decode v6 got %s", addr)
+	}
+	value[dstFamilyOff] = 0
+	_, err = decodeDst(value)
+	if err == nil {
+		t.Error("invalid family should fail")
+	}
+}
@@ -0,0 +1,81 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Com
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// usage above ratio of limit is reported as leak suspect
+const fdWarnRatio = 0.8
+
+// dir of fds opened by current process
+const selfFdDir = "/proc/self/fd"
+
+// fd usage of current process, used to find fd leak
+type FdUsage struct {
+	Open    uint32 // fds opened
+	Sockets uint32 // fds of sockets
+	Limit   uint64 // soft limit of RLIMIT_NOFILE
+	High    bool   // open fds exceed warn ratio of limit
+}
+
+// count fds of current process
+func GetFdUsage() (FdUsage, error) {
+	var usage FdUsage
+	infos, err := ioutil.ReadDir(selfFdDir)
+	if err != nil {
+		return usage, err
+	}
+	for _, info := range infos {
+		// fd may be closed during scan
+		target, err := os.Readlink(filepath.Join(selfFdDir, info.Name()))
+		if err != nil {
+			continue
+		}
+		usage.Open++
+		if strings.HasPrefix(target, "socket:") {
+			usage.Sockets++
+		}
+	}
+	var limit syscall.Rlimit
+	err = syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit)
+	if err != nil {
+		return usage, err
+	}
+	usage.Limit = limit.Cur
+	usage.High = float64(usage.Open) > float64(usage.Limit)*fdWarnRatio
+	return usage, nil
+}
+
+// raise soft limit of RLIMIT_NOFILE to ceiling, hard limit is raised too if process is allowed,
+// otherwise soft limit is raised up to hard limit. limit above ceiling is kept, soft limit in effect is returned
+func RaiseFdLimit(ceiling uint64) (uint64, error) {
+	var limit syscall.Rlimit
+	err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit)
+	if err != nil {
+		return 0, err
+	}
+	if limit.Cur >= ceiling {
+		return limit.Cur, nil
+	}
+	want := syscall.Rlimit{Cur: ceiling, Max: limit.Max}
+	if want.Max < ceiling {
+		want.Max = ceiling
+	}
+	err = syscall.Setrlimit(syscall.RLIMIT_NOFILE, &want)
+	if err != nil && limit.Max > limit.Cur {
+		want = syscall.Rlimit{Cur: limit.Max, Max: limit.Max}
+		err = syscall.Setrlimit(syscall.RLIMIT_NOFILE, &want)
+	}
+	if err != nil {
+		return limit.Cur, err
+	}
+	return want.Cur, nil
+}
@@ -0,0 +1,39 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Com
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestRaiseFdLimit(t *testing.T) {
+	var limit syscall.Rlimit
+	err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = syscall.Setrlimit(syscall.RLIMIT_NOFILE, &limit)
+	}()
+	// limit above ceiling is kept
+	cur, err := RaiseFdLimit(1)
+	if err != nil || cur != limit.Cur {
+		t.Fatalf("limit should be kept, got %d, err: %v", cur, err)
+	}
+	// soft limit is raised up to hard limit at least
+	lowered := syscall.Rlimit{Cur: limit.Cur / 2, Max: limit.Max}
+	if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &lowered); err != nil {
+		t.Skip(err)
+	}
+	cur, err = RaiseFdLimit(limit.Cur)
+	if err != nil || cur != limit.Cur {
+		t.Errorf("limit should be raised to %d, got %d, err: %v", limit.Cur, cur, err)
+	}
+	usage, err := GetFdUsage()
+	if err != nil || usage.Limit != cur || usage.Open == 0 {
+		t.Errorf("fd usage got %+v, err: %v", usage, err)
+	}
+}
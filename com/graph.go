@@ -0,0 +1,151 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Com
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// steps of setup start as soon as steps they depend on finish, independent steps run at the same time,
+// such as chains before rules and cgroup dirs before procs are attached.
+// failed step stops steps after it unless it is optional, cost of every step is reported.
+
+// step of graph
+type Step struct {
+	Name     string
+	After    []string // names of steps should finish before
+	Optional bool     // failure is reported, but steps after still run
+	Run      func() error
+}
+
+// cost and result of step, step not run has zero cost
+type StepCost struct {
+	Name    string
+	Cost    time.Duration
+	Err     error
+	Skipped bool // step it depends on failed
+}
+
+// report of graph, steps are in order of graph
+type GraphReport struct {
+	Total time.Duration
+	Steps []StepCost
+}
+
+// format cost of steps, like "total 120ms, mark 1ms, iptables 80ms"
+func (r GraphReport) String() string {
+	parts := []string{"total " + r.Total.Round(time.Microsecond).String()}
+	for _, step := range r.Steps {
+		switch {
+		case step.Skipped:
+			parts = append(parts, step.Name+" skipped")
+		case step.Err != nil:
+			parts = append(parts, step.Name+" failed")
+		default:
+			parts = append(parts, step.Name+" "+step.Cost.Round(time.Microsecond).String())
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// run steps by dependency, first error of required step in order of graph is returned
+func RunGraph(steps []Step) (GraphReport, error) {
+	report := GraphReport{Steps: make([]StepCost, len(steps))}
+	index := make(map[string]int)
+	for i, step := range steps {
+		if _, ok := index[step.Name]; ok {
+			return report, fmt.Errorf("step %s is duplicated", step.Name)
+		}
+		index[step.Name] = i
+		report.Steps[i].Name = step.Name
+	}
+	for _, step := range steps {
+		for _, dep := range step.After {
+			if _, ok := index[dep]; !ok {
+				return report, fmt.Errorf("step %s depends on unknown step %s", step.Name, dep)
+			}
+		}
+	}
+	err := checkCycle(steps, index)
+	if err != nil {
+		return report, err
+	}
+
+	// closed when step finishes, failed is set before
+	done := make([]chan struct{}, len(steps))
+	failed := make([]bool, len(steps))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+	start := time.Now()
+	var wg sync.WaitGroup
+	wg.Add(len(steps))
+	for i := range steps {
+		go func(i int) {
+			defer wg.Done()
+			defer close(done[i])
+			step := steps[i]
+			for _, dep := range step.After {
+				<-done[index[dep]]
+				if failed[index[dep]] {
+					report.Steps[i].Skipped = true
+				}
+			}
+			if report.Steps[i].Skipped {
+				failed[i] = true
+				return
+			}
+			begin := time.Now()
+			err := step.Run()
+			report.Steps[i].Cost = time.Since(begin)
+			report.Steps[i].Err = err
+			failed[i] = err != nil && !step.Optional
+		}(i)
+	}
+	wg.Wait()
+	report.Total = time.Since(start)
+
+	for i, step := range steps {
+		cost := report.Steps[i]
+		if cost.Err != nil && !step.Optional {
+			return report, fmt.Errorf("step %s failed: %w", step.Name, cost.Err)
+		}
+	}
+	return report, nil
+}
+
+// steps depend on each other in circle never run
+func checkCycle(steps []Step, index map[string]int) error {
+	// 0 not visited, 1 visiting, 2 visited
+	state := make([]int, len(steps))
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch state[i] {
+		case 1:
+			return fmt.Errorf("steps depend on each other in circle at %s", steps[i].Name)
+		case 2:
+			return nil
+		}
+		state[i] = 1
+		for _, dep := range steps[i].After {
+			err := visit(index[dep])
+			if err != nil {
+				return err
+			}
+		}
+		state[i] = 2
+		return nil
+	}
+	for i := range steps {
+		err := visit(i)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Com
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRunGraph(t *testing.T) {
+	var lock sync.Mutex
+	var order []string
+	record := func(name string, sleep time.Duration, err error) func() error {
+		return func() error {
+			time.Sleep(sleep)
+			lock.Lock()
+			order = append(order, name)
+			lock.Unlock()
+			return err
+		}
+	}
+	report, err := RunGraph([]Step{
+		{Name: "rules", After: []string{"chains", "cgroup"}, Run: record("rules", 0, nil)},
+		{Name: "chains", Run: record("chains", 50*time.Millisecond, nil)},
+		{Name: "cgroup", Run: record("cgroup", 50*time.Millisecond, nil)},
+		{Name: "attach", After: []string{"cgroup"}, Optional: true, Run: record("attach", 0, errors.New("busy"))},
+		{Name: "watch", After: []string{"attach"}, Run: record("watch", 0, nil)},
+	})
+	if err != nil {
+		t.Fatalf("optional step should not fail graph, err: %v", err)
+	}
+	if len(order) != 5 {
+		t.Errorf("all steps should run: %v", order)
+	}
+	for i, name := range order {
+		if name == "rules" && i < 2 {
+			t.Errorf("rules should run after chains and cgroup: %v", order)
+		}
+	}
+	// independent steps run at the same time
+	if report.Total >= 100*time.Millisecond {
+		t.Errorf("chains and cgroup should run at the same time, total: %v", report.Total)
+	}
+
+	// steps after failed step are skipped
+	report, err = RunGraph([]Step{
+		{Name: "mark", Run: record("mark", 0, errors.New("no mark"))},
+		{Name: "rules", After: []string{"mark"}, Run: record("rules", 0, nil)},
+	})
+	if err == nil || !report.Steps[1].Skipped {
+		t.Errorf("rules should be skipped after mark failed, err: %v, report: %s", err, report)
+	}
+
+	// circle is found before any step runs
+	_, err = RunGraph([]Step{
+		{Name: "a", After: []string{"b"}, Run: record("a", 0, nil)},
+		{Name: "b", After: []string{"a"}, Run: record("b", 0, nil)},
+	})
+	if err == nil {
+		t.Error("circle should be rejected")
+	}
+}
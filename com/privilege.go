@@ -0,0 +1,128 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Com
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus"
+	polkit "github.com/linuxdeepin/go-dbus-factory/org.freedesktop.policykit1"
+)
+
+// result of polkit is cached, denied result expires soon so that user can retry after auth
+const (
+	privilegeAllowTTL = 5 * time.Minute
+	privilegeDenyTTL  = 5 * time.Second
+)
+
+// same pid with different start time is another proc, never shares result
+type privilegeKey struct {
+	actionId  string
+	uid       uint32
+	pid       uint32
+	startTime uint64
+}
+
+type privilegeResult struct {
+	authorized bool
+	expire     time.Time
+}
+
+// cache of polkit result
+type privilegeCache struct {
+	lock    sync.Mutex
+	results map[privilegeKey]privilegeResult
+}
+
+var privileges = &privilegeCache{results: make(map[privilegeKey]privilegeResult)}
+
+// check authorization by polkit, replaced in test
+var checkAuthorization = polkitCheck
+
+// get result not expired
+func (c *privilegeCache) get(key privilegeKey, now time.Time) (bool, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	result, ok := c.results[key]
+	if !ok || now.After(result.expire) {
+		return false, false
+	}
+	return result.authorized, true
+}
+
+// save result, expired ones are removed at the same time
+func (c *privilegeCache) set(key privilegeKey, authorized bool, now time.Time) {
+	ttl := privilegeDenyTTL
+	if authorized {
+		ttl = privilegeAllowTTL
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for elem, result := range c.results {
+		if now.After(result.expire) {
+			delete(c.results, elem)
+		}
+	}
+	c.results[key] = privilegeResult{authorized: authorized, expire: now.Add(ttl)}
+}
+
+// check if proc is authorized of action, interactive allows polkit agent to prompt user,
+// start time is read from /proc if 0
+func PromotePrivilege(actionId string, uid uint32, pid uint32, startTime uint64, interactive bool) error {
+	if startTime == 0 {
+		var err error
+		startTime, err = GetProcStartTime(pid)
+		if err != nil {
+			return err
+		}
+	}
+	key := privilegeKey{actionId: actionId, uid: uid, pid: pid, startTime: startTime}
+	authorized, ok := privileges.get(key, time.Now())
+	if !ok {
+		var err error
+		authorized, err = checkAuthorization(key, interactive)
+		if err != nil {
+			return err
+		}
+		privileges.set(key, authorized, time.Now())
+	}
+	if !authorized {
+		return errors.New("authorized failed")
+	}
+	// auth success
+	return nil
+}
+
+// check authorization of unix process subject by polkit
+func polkitCheck(key privilegeKey, interactive bool) (bool, error) {
+	// get system bus
+	systemBus, err := dbus.SystemBus()
+	if err != nil {
+		return false, err
+	}
+	// auth body
+	authority := polkit.NewAuthority(systemBus)
+	// add uid pid and start-time to polkit request, types are those polkit expects
+	subject := polkit.MakeSubject(polkit.SubjectKindUnixProcess)
+	subject.SetDetail("uid", int32(key.uid))
+	subject.SetDetail("pid", key.pid)
+	subject.SetDetail("start-time", key.startTime)
+	flags := uint32(polkit.CheckAuthorizationFlagsNone)
+	if interactive {
+		flags = polkit.CheckAuthorizationFlagsAllowUserInteraction
+	}
+	// start auth to promote privilege
+	ret, err := authority.CheckAuthorization(0, subject, key.actionId, nil, flags, "")
+	if err != nil {
+		return false, err
+	}
+	// prompt is not allowed, user has to auth first
+	if !ret.IsAuthorized && ret.IsChallenge {
+		return false, errors.New("authentication is required")
+	}
+	return ret.IsAuthorized, nil
+}
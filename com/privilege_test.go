@@ -0,0 +1,45 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Com
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPromotePrivilegeCache(t *testing.T) {
+	calls := 0
+	checkAuthorization = func(key privilegeKey, interactive bool) (bool, error) {
+		calls++
+		return key.uid == 0, nil
+	}
+	defer func() { checkAuthorization = polkitCheck }()
+
+	for i := 0; i < 3; i++ {
+		err := PromotePrivilege("com.deepin.proxy", 0, 100, 1, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("authorized result should be cached, calls: %d", calls)
+	}
+	// another start time is another proc
+	_ = PromotePrivilege("com.deepin.proxy", 0, 100, 2, false)
+	if calls != 2 {
+		t.Errorf("start time should be part of key, calls: %d", calls)
+	}
+	err := PromotePrivilege("com.deepin.proxy", 1000, 101, 1, false)
+	if err == nil {
+		t.Error("uid 1000 should be denied")
+	}
+
+	// expired result is checked again
+	key := privilegeKey{actionId: "com.deepin.proxy", uid: 1000, pid: 101, startTime: 1}
+	_, ok := privileges.get(key, time.Now().Add(privilegeDenyTTL+time.Second))
+	if ok {
+		t.Error("denied result should expire")
+	}
+}
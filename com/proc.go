@@ -0,0 +1,203 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Com
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// proc file system root
+const ProcRoot = "/proc"
+
+// files of /proc/pid to read, only fields of requested files are filled
+type ProcField uint32
+
+const (
+	ProcStat    ProcField = 1 << iota // comm, ppid and start time
+	ProcStatus                        // ppid and uid
+	ProcExe                           // exe path
+	ProcCmdline                       // args
+	ProcCGroup                        // cgroup v2 path
+)
+
+// message of proc read from /proc/pid
+type ProcInfo struct {
+	Pid        string
+	PPid       string
+	Comm       string
+	StartTicks uint64   // clock ticks after boot
+	Uid        string   // real uid, empty if unknown
+	ExecPath   string   // " (deleted)" is trimmed
+	Cmdline    []string // args
+	CGroup     string   // cgroup v2 path relative to root of hierarchy,   /user.slice/user-1000.slice
+}
+
+// read message of pid under proc root, error if any requested file can not be read or parsed
+func ReadProcInfo(root string, pid string, fields ProcField) (*ProcInfo, error) {
+	if !IsPid(pid) {
+		return nil, errors.New("pid is invalid")
+	}
+	dir := filepath.Join(root, pid)
+	info := &ProcInfo{Pid: pid}
+	if fields&ProcStat != 0 {
+		buf, err := ioutil.ReadFile(filepath.Join(dir, "stat"))
+		if err != nil {
+			return nil, err
+		}
+		err = info.parseStat(buf)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if fields&ProcStatus != 0 {
+		buf, err := ioutil.ReadFile(filepath.Join(dir, "status"))
+		if err != nil {
+			return nil, err
+		}
+		err = info.parseStatus(buf)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if fields&ProcExe != 0 {
+		// kernel thread has no exe
+		exe, err := os.Readlink(filepath.Join(dir, "exe"))
+		if err != nil {
+			return nil, err
+		}
+		info.ExecPath = strings.TrimSuffix(exe, " (deleted)")
+	}
+	if fields&ProcCmdline != 0 {
+		buf, err := ioutil.ReadFile(filepath.Join(dir, "cmdline"))
+		if err != nil {
+			return nil, err
+		}
+		info.Cmdline = parseCmdline(buf)
+	}
+	if fields&ProcCGroup != 0 {
+		buf, err := ioutil.ReadFile(filepath.Join(dir, "cgroup"))
+		if err != nil {
+			return nil, err
+		}
+		info.CGroup, err = parseCGroup2(buf)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return info, nil
+}
+
+// args joined by space
+func (info *ProcInfo) CmdlineString() string {
+	return strings.Join(info.Cmdline, " ")
+}
+
+// 100 (foo (bar)) S 1 100 ..., comm may contain space and brackets, fields start after the last bracket
+func (info *ProcInfo) parseStat(buf []byte) error {
+	stat := string(bytes.TrimSpace(buf))
+	begin := strings.IndexByte(stat, '(')
+	end := strings.LastIndexByte(stat, ')')
+	if begin < 0 || end < begin {
+		return errors.New("stat format is invalid")
+	}
+	info.Comm = stat[begin+1 : end]
+	fields := strings.Fields(stat[end+1:])
+	// fields begin with state, the 3rd field, start time is the 22nd
+	if len(fields) < 20 {
+		return errors.New("stat format is invalid")
+	}
+	info.PPid = fields[1]
+	ticks, err := strconv.ParseUint(fields[19], 10, 64)
+	if err != nil {
+		return err
+	}
+	info.StartTicks = ticks
+	return nil
+}
+
+// Name:	foo
+// PPid:	1
+// Uid:	1000	1000	1000	1000
+func (info *ProcInfo) parseStatus(buf []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "PPid":
+			info.PPid = strings.TrimSpace(value)
+		case "Uid":
+			// real, effective, saved and fs uid
+			fields := strings.Fields(value)
+			if len(fields) == 0 || !isNum(fields[0]) {
+				return errors.New("uid format is invalid")
+			}
+			info.Uid = fields[0]
+		}
+	}
+	return scanner.Err()
+}
+
+func isNum(str string) bool {
+	_, err := strconv.ParseUint(str, 10, 32)
+	return err == nil
+}
+
+// args are split by zero, cmdline of kernel thread is empty
+func parseCmdline(buf []byte) []string {
+	buf = bytes.TrimRight(buf, "\x00")
+	if len(buf) == 0 {
+		return nil
+	}
+	var args []string
+	for _, arg := range bytes.Split(buf, []byte{0}) {
+		args = append(args, string(arg))
+	}
+	return args
+}
+
+// 0::/user.slice/user-1000.slice/session-2.scope, lines of cgroup v1 are ignored,
+// empty if proc is not in cgroup v2 hierarchy
+func parseCGroup2(buf []byte) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "0::") {
+			return strings.TrimPrefix(line, "0::"), nil
+		}
+	}
+	return "", scanner.Err()
+}
+
+// check if proc holds socket of inode, fd links of proc are like socket:[12345]
+func ProcHasSocket(root string, pid string, inode uint32) bool {
+	dir := filepath.Join(root, pid, "fd")
+	file, err := os.Open(dir)
+	if err != nil {
+		return false
+	}
+	names, err := file.Readdirnames(-1)
+	_ = file.Close()
+	if err != nil {
+		return false
+	}
+	target := "socket:[" + strconv.FormatUint(uint64(inode), 10) + "]"
+	for _, name := range names {
+		link, err := os.Readlink(filepath.Join(dir, name))
+		if err == nil && link == target {
+			return true
+		}
+	}
+	return false
+}
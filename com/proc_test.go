@@ -0,0 +1,88 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Com
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadProcInfo(t *testing.T) {
+	root, err := ioutil.TempDir("", "proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	dir := filepath.Join(root, "100")
+	_ = os.MkdirAll(dir, 0755)
+	_ = os.Symlink("/usr/bin/foo (deleted)", filepath.Join(dir, "exe"))
+	// comm with space and brackets, ppid is 1, starttime is 12345 ticks
+	stat := "100 (a) b (c) S 1 100 100 0 -1 4194560 1 0 0 0 0 0 0 0 20 0 1 0 12345 1000 10\n"
+	_ = ioutil.WriteFile(filepath.Join(dir, "stat"), []byte(stat), 0644)
+	_ = ioutil.WriteFile(filepath.Join(dir, "status"), []byte("Name:\ta) b (c\nPPid:\t1\nUid:\t1000\t0\t0\t0\n"), 0644)
+	_ = ioutil.WriteFile(filepath.Join(dir, "cmdline"), []byte("python3\x00main.py\x00--name=a b\x00"), 0644)
+	_ = ioutil.WriteFile(filepath.Join(dir, "cgroup"), []byte("1:name=systemd:/\n0::/user.slice/user-1000.slice\n"), 0644)
+
+	info, err := ReadProcInfo(root, "100", ProcStat|ProcStatus|ProcExe|ProcCmdline|ProcCGroup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &ProcInfo{
+		Pid:        "100",
+		PPid:       "1",
+		Comm:       "a) b (c",
+		StartTicks: 12345,
+		Uid:        "1000",
+		ExecPath:   "/usr/bin/foo",
+		Cmdline:    []string{"python3", "main.py", "--name=a b"},
+		CGroup:     "/user.slice/user-1000.slice",
+	}
+	if !reflect.DeepEqual(info, want) {
+		t.Errorf("read proc info got %+v", info)
+	}
+	if info.CmdlineString() != "python3 main.py --name=a b" {
+		t.Errorf("cmdline string got %s", info.CmdlineString())
+	}
+
+	// only requested files are read
+	info, err = ReadProcInfo(root, "100", ProcExe)
+	if err != nil || info.Comm != "" || info.ExecPath != "/usr/bin/foo" {
+		t.Errorf("read exe only got %+v, err: %v", info, err)
+	}
+	_, err = ReadProcInfo(root, "200", ProcExe)
+	if err == nil {
+		t.Error("read not exist proc should fail")
+	}
+	_, err = ReadProcInfo(root, "../100", ProcExe)
+	if err == nil {
+		t.Error("invalid pid should fail")
+	}
+	_ = ioutil.WriteFile(filepath.Join(dir, "stat"), []byte("100 (foo S 1"), 0644)
+	_, err = ReadProcInfo(root, "100", ProcStat)
+	if err == nil {
+		t.Error("invalid stat should fail")
+	}
+}
+
+func TestProcHasSocket(t *testing.T) {
+	root, err := ioutil.TempDir("", "proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	dir := filepath.Join(root, "100", "fd")
+	_ = os.MkdirAll(dir, 0755)
+	_ = os.Symlink("/dev/null", filepath.Join(dir, "0"))
+	_ = os.Symlink("socket:[12345]", filepath.Join(dir, "3"))
+	if !ProcHasSocket(root, "100", 12345) {
+		t.Error("proc should hold socket 12345")
+	}
+	if ProcHasSocket(root, "100", 1234) || ProcHasSocket(root, "200", 12345) {
+		t.Error("proc should not hold socket")
+	}
+}
@@ -0,0 +1,99 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Com
+
+import (
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// relay parses data from network, syscalls of daemon are restricted by seccomp after init,
+// so that exploit in relay can not load modules, mount, ptrace and so on.
+// filter is synced to all threads and inherited by commands executed such as iptables,
+// syscalls not allowed fail with EPERM instead of killing daemon.
+
+// from linux/seccomp.h
+const (
+	seccompSetModeFilter   = 1
+	seccompFlagTsync       = 1
+	seccompRetAllow        = 0x7fff0000
+	seccompRetErrno        = 0x00050000
+	seccompDataNrOffset    = 0
+	seccompDataArchOffset  = 4
+	auditArchX86_64        = 0xc000003e
+	auditArchAARCH64       = 0xc00000b7
+	seccompMaxJumpDistance = 255
+)
+
+// classic bpf
+const (
+	bpfLdWAbs = 0x00 | 0x00 | 0x20
+	bpfJeqK   = 0x05 | 0x10 | 0x00
+	bpfRetK   = 0x06 | 0x00
+)
+
+// build filter allows syscalls of arch only
+func seccompFilter(arch uint32, syscalls []uintptr) ([]unix.SockFilter, error) {
+	// remove duplicates, arch list may contain common ones
+	seen := make(map[uintptr]bool)
+	var allowed []uintptr
+	for _, nr := range syscalls {
+		if seen[nr] {
+			continue
+		}
+		seen[nr] = true
+		allowed = append(allowed, nr)
+	}
+	// every jeq jumps to allow at the end
+	if len(allowed) > seccompMaxJumpDistance {
+		return nil, fmt.Errorf("too many syscalls allowed: %d", len(allowed))
+	}
+	deny := uint32(seccompRetErrno | uint32(unix.EPERM))
+	filter := []unix.SockFilter{
+		{Code: bpfLdWAbs, K: seccompDataArchOffset},
+		{Code: bpfJeqK, Jt: 1, K: arch},
+		{Code: bpfRetK, K: deny},
+		{Code: bpfLdWAbs, K: seccompDataNrOffset},
+	}
+	for index, nr := range allowed {
+		filter = append(filter, unix.SockFilter{Code: bpfJeqK, Jt: uint8(len(allowed) - index), K: uint32(nr)})
+	}
+	filter = append(filter,
+		unix.SockFilter{Code: bpfRetK, K: deny},
+		unix.SockFilter{Code: bpfRetK, K: seccompRetAllow},
+	)
+	return filter, nil
+}
+
+// restrict syscalls of all threads
+func ApplySeccomp() error {
+	if seccompArch == 0 {
+		return fmt.Errorf("seccomp filter is not supported on %s", runtime.GOARCH)
+	}
+	filter, err := seccompFilter(seccompArch, seccompSyscalls)
+	if err != nil {
+		return err
+	}
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	// no new privs is needed without CAP_SYS_ADMIN, and is synced to other threads with filter
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	err = unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0)
+	if err != nil {
+		return err
+	}
+	tid, _, errno := unix.Syscall(unix.SYS_SECCOMP, seccompSetModeFilter, seccompFlagTsync, uintptr(unsafe.Pointer(&prog)))
+	if errno != 0 {
+		return errno
+	}
+	// id of thread can not be synced is returned
+	if tid != 0 {
+		return fmt.Errorf("sync seccomp filter to thread %d failed", tid)
+	}
+	return nil
+}
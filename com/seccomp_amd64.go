@@ -0,0 +1,19 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Com
+
+import "golang.org/x/sys/unix"
+
+const seccompArch = auditArchX86_64
+
+// legacy syscalls still used by libc of commands
+var seccompSyscalls = append([]uintptr{
+	unix.SYS_ARCH_PRCTL, unix.SYS_OPEN, unix.SYS_STAT, unix.SYS_LSTAT, unix.SYS_NEWFSTATAT,
+	unix.SYS_ACCESS, unix.SYS_READLINK, unix.SYS_GETDENTS, unix.SYS_MKDIR, unix.SYS_RMDIR,
+	unix.SYS_UNLINK, unix.SYS_RENAME, unix.SYS_CHMOD, unix.SYS_CHOWN, unix.SYS_LCHOWN,
+	unix.SYS_PIPE, unix.SYS_DUP2, unix.SYS_POLL, unix.SYS_SELECT, unix.SYS_EPOLL_CREATE,
+	unix.SYS_EPOLL_WAIT, unix.SYS_INOTIFY_INIT, unix.SYS_EVENTFD, unix.SYS_FORK, unix.SYS_VFORK,
+	unix.SYS_GETPGRP, unix.SYS_TIME, unix.SYS_ALARM,
+}, commonSyscalls...)
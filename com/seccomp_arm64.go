@@ -0,0 +1,13 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Com
+
+import "golang.org/x/sys/unix"
+
+const seccompArch = auditArchAARCH64
+
+var seccompSyscalls = append([]uintptr{
+	unix.SYS_FSTATAT,
+}, commonSyscalls...)
@@ -0,0 +1,16 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+//go:build iouring && (amd64 || arm64)
+// +build iouring
+// +build amd64 arm64
+
+package Com
+
+import "golang.org/x/sys/unix"
+
+// experimental relay built with iouring tag copies tunnels by io_uring
+func init() {
+	seccompSyscalls = append(seccompSyscalls, unix.SYS_IO_URING_SETUP, unix.SYS_IO_URING_ENTER)
+}
@@ -0,0 +1,13 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+//go:build !amd64 && !arm64
+// +build !amd64,!arm64
+
+package Com
+
+// syscall list is not maintained for other arches, filter is not applied
+const seccompArch = 0
+
+var seccompSyscalls []uintptr
@@ -0,0 +1,106 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Com
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestSeccompFilter(t *testing.T) {
+	filter, err := seccompFilter(auditArchX86_64, []uintptr{0, 1, 0, 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// arch check, load nr, 3 jeq, deny, allow
+	if len(filter) != 9 {
+		t.Fatalf("len of filter got %d, want 9", len(filter))
+	}
+	last := len(filter) - 1
+	for index := 4; index < 7; index++ {
+		if target := index + 1 + int(filter[index].Jt); target != last {
+			t.Errorf("jeq %d jumps to %d, want %d", index, target, last)
+		}
+	}
+	if filter[last].K != seccompRetAllow || filter[last-1].K != seccompRetErrno|uint32(unix.EPERM) {
+		t.Errorf("returns got %v %v", filter[last-1], filter[last])
+	}
+	_, err = seccompFilter(auditArchX86_64, make256())
+	if err == nil {
+		t.Error("too many syscalls is allowed")
+	}
+}
+
+func make256() []uintptr {
+	syscalls := make([]uintptr, 256)
+	for index := range syscalls {
+		syscalls[index] = uintptr(index)
+	}
+	return syscalls
+}
+
+// filter can not be removed, so it is applied in child process
+func TestApplySeccomp(t *testing.T) {
+	if seccompArch == 0 {
+		t.Skip("seccomp not supported")
+	}
+	if os.Getenv("COM_SECCOMP_CHILD") == "" {
+		cmd := exec.Command(os.Args[0], "-test.run=TestApplySeccomp")
+		cmd.Env = append(os.Environ(), "COM_SECCOMP_CHILD=1")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("child failed, err: %v, out: %s", err, out)
+		}
+		return
+	}
+	err := ApplySeccomp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// personality is not needed by daemon
+	_, _, errno := unix.Syscall(unix.SYS_PERSONALITY, 0xffffffff, 0, 0)
+	if errno != unix.EPERM {
+		t.Errorf("personality got %v, want EPERM", errno)
+	}
+	// capabilities are dropped before init, and no command runs as saved uid
+	for _, nr := range []uintptr{unix.SYS_CAPSET, unix.SYS_SETRESUID, unix.SYS_EXECVEAT} {
+		_, _, errno = unix.Syscall(nr, 0, 0, 0)
+		if errno != unix.EPERM {
+			t.Errorf("syscall %d got %v, want EPERM", nr, errno)
+		}
+	}
+	// relay still works
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			_, _ = conn.Write([]byte("ok"))
+			_ = conn.Close()
+		}
+	}()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 2)
+	_, err = conn.Read(buf)
+	_ = conn.Close()
+	if err != nil || string(buf) != "ok" {
+		t.Errorf("relay got %q, err: %v", buf, err)
+	}
+	// commands such as iptables run under filter
+	err = exec.Command("sh", "-c", "ls / > /dev/null").Run()
+	if err != nil {
+		t.Errorf("run command failed, err: %v", err)
+	}
+}
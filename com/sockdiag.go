@@ -0,0 +1,116 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Com
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// inode and uid of socket are looked up by sock_diag netlink, exact 4-tuple is requested,
+// so that kernel finds the socket in hash table instead of dumping all sockets.
+// https://man7.org/linux/man-pages/man7/sock_diag.7.html
+
+const (
+	netlinkSockDiag  = 4  // NETLINK_SOCK_DIAG
+	sockDiagByFamily = 20 // SOCK_DIAG_BY_FAMILY
+
+	inetDiagReqLen = 56 // inet_diag_req_v2
+	inetDiagMsgLen = 72 // inet_diag_msg
+)
+
+// owner of socket
+type SockInfo struct {
+	Inode uint32
+	Uid   uint32
+}
+
+// look up tcp socket connected from local to remote, local is addr of socket owner
+func LookupTcpSocket(local *net.TCPAddr, remote *net.TCPAddr) (SockInfo, error) {
+	req, err := buildDiagReq(syscall.IPPROTO_TCP, local.IP, local.Port, remote.IP, remote.Port)
+	if err != nil {
+		return SockInfo{}, err
+	}
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, netlinkSockDiag)
+	if err != nil {
+		return SockInfo{}, err
+	}
+	defer syscall.Close(fd)
+	err = syscall.Sendto(fd, req, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK})
+	if err != nil {
+		return SockInfo{}, err
+	}
+	buf := make([]byte, 4096)
+	n, _, err := syscall.Recvfrom(fd, buf, 0)
+	if err != nil {
+		return SockInfo{}, err
+	}
+	return parseDiagResp(buf[:n])
+}
+
+// nlmsghdr + inet_diag_req_v2, addrs in network order, header in host order
+func buildDiagReq(proto uint8, srcIP net.IP, srcPort int, dstIP net.IP, dstPort int) ([]byte, error) {
+	family := uint8(syscall.AF_INET)
+	src, dst := srcIP.To4(), dstIP.To4()
+	if src == nil || dst == nil {
+		family = syscall.AF_INET6
+		src, dst = srcIP.To16(), dstIP.To16()
+	}
+	if src == nil || dst == nil {
+		return nil, fmt.Errorf("addr is invalid, src: %v, dst: %v", srcIP, dstIP)
+	}
+	buf := make([]byte, syscall.NLMSG_HDRLEN+inetDiagReqLen)
+	binary.LittleEndian.PutUint32(buf[0:], uint32(len(buf)))
+	binary.LittleEndian.PutUint16(buf[4:], sockDiagByFamily)
+	binary.LittleEndian.PutUint16(buf[6:], syscall.NLM_F_REQUEST)
+	req := buf[syscall.NLMSG_HDRLEN:]
+	req[0] = family
+	req[1] = proto
+	// all states
+	binary.LittleEndian.PutUint32(req[4:], 0xffffffff)
+	// inet_diag_sockid
+	id := req[8:]
+	binary.BigEndian.PutUint16(id[0:], uint16(srcPort))
+	binary.BigEndian.PutUint16(id[2:], uint16(dstPort))
+	copy(id[4:20], src)
+	copy(id[20:36], dst)
+	// no cookie
+	binary.LittleEndian.PutUint32(id[40:], 0xffffffff)
+	binary.LittleEndian.PutUint32(id[44:], 0xffffffff)
+	return buf, nil
+}
+
+// nlmsghdr + inet_diag_msg, or nlmsgerr if socket not found
+func parseDiagResp(buf []byte) (SockInfo, error) {
+	if len(buf) < syscall.NLMSG_HDRLEN {
+		return SockInfo{}, errors.New("sock diag response is too short")
+	}
+	typ := binary.LittleEndian.Uint16(buf[4:])
+	body := buf[syscall.NLMSG_HDRLEN:]
+	switch typ {
+	case syscall.NLMSG_ERROR:
+		if len(body) < 4 {
+			return SockInfo{}, errors.New("sock diag error is too short")
+		}
+		errno := int32(binary.LittleEndian.Uint32(body))
+		if errno == 0 {
+			return SockInfo{}, errors.New("socket not found")
+		}
+		return SockInfo{}, syscall.Errno(-errno)
+	case sockDiagByFamily:
+		if len(body) < inetDiagMsgLen {
+			return SockInfo{}, errors.New("sock diag message is too short")
+		}
+		return SockInfo{
+			Uid:   binary.LittleEndian.Uint32(body[64:]),
+			Inode: binary.LittleEndian.Uint32(body[68:]),
+		}, nil
+	default:
+		return SockInfo{}, fmt.Errorf("sock diag response type %d is unknown", typ)
+	}
+}
@@ -0,0 +1,65 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Com
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+	"testing"
+)
+
+func TestBuildDiagReq(t *testing.T) {
+	req, err := buildDiagReq(syscall.IPPROTO_TCP, net.ParseIP("10.0.0.2"), 40000, net.ParseIP("1.1.1.1"), 443)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(req) != 72 || binary.LittleEndian.Uint32(req) != 72 {
+		t.Fatalf("request length wrong, len: %d", len(req))
+	}
+	body := req[syscall.NLMSG_HDRLEN:]
+	if body[0] != syscall.AF_INET || body[1] != syscall.IPPROTO_TCP {
+		t.Errorf("family or proto wrong, %v", body[:2])
+	}
+	id := body[8:]
+	if binary.BigEndian.Uint16(id) != 40000 || binary.BigEndian.Uint16(id[2:]) != 443 {
+		t.Errorf("ports wrong, %v", id[:4])
+	}
+	if !net.IP(id[4:8]).Equal(net.ParseIP("10.0.0.2")) || !net.IP(id[20:24]).Equal(net.ParseIP("1.1.1.1")) {
+		t.Errorf("addrs wrong, %v", id[4:36])
+	}
+
+	req, err = buildDiagReq(syscall.IPPROTO_TCP, net.ParseIP("fd00::2"), 40000, net.ParseIP("2001:db8::1"), 443)
+	if err != nil || req[syscall.NLMSG_HDRLEN] != syscall.AF_INET6 {
+		t.Errorf("ipv6 request wrong, err: %v", err)
+	}
+	_, err = buildDiagReq(syscall.IPPROTO_TCP, nil, 40000, net.ParseIP("1.1.1.1"), 443)
+	if err == nil {
+		t.Error("nil addr should fail")
+	}
+}
+
+func TestParseDiagResp(t *testing.T) {
+	buf := make([]byte, syscall.NLMSG_HDRLEN+inetDiagMsgLen)
+	binary.LittleEndian.PutUint16(buf[4:], sockDiagByFamily)
+	binary.LittleEndian.PutUint32(buf[syscall.NLMSG_HDRLEN+64:], 1000)
+	binary.LittleEndian.PutUint32(buf[syscall.NLMSG_HDRLEN+68:], 12345)
+	info, err := parseDiagResp(buf)
+	if err != nil || info.Uid != 1000 || info.Inode != 12345 {
+		t.Errorf("parse response got %+v, err: %v", info, err)
+	}
+
+	// socket not found
+	binary.LittleEndian.PutUint16(buf[4:], syscall.NLMSG_ERROR)
+	binary.LittleEndian.PutUint32(buf[syscall.NLMSG_HDRLEN:], uint32(0x100000000-int64(syscall.ENOENT)))
+	_, err = parseDiagResp(buf)
+	if err != syscall.ENOENT {
+		t.Errorf("parse error got %v", err)
+	}
+	_, err = parseDiagResp(buf[:4])
+	if err == nil {
+		t.Error("short response should fail")
+	}
+}
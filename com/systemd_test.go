@@ -0,0 +1,68 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Com
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSdNotify(t *testing.T) {
+	// not started by systemd
+	os.Unsetenv("NOTIFY_SOCKET")
+	if err := SdNotify(SdReady); err != nil {
+		t.Fatalf("notify without socket, err: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "sdnotify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	os.Setenv("NOTIFY_SOCKET", path)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+	if err := SdNotify(SdWatchdog); err != nil {
+		t.Fatalf("notify failed, err: %v", err)
+	}
+	buf := make([]byte, 64)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != SdWatchdog {
+		t.Errorf("state got %q, want %q", buf[:n], SdWatchdog)
+	}
+}
+
+func TestSdWatchdogTimeout(t *testing.T) {
+	defer os.Unsetenv("WATCHDOG_USEC")
+	defer os.Unsetenv("WATCHDOG_PID")
+	os.Unsetenv("WATCHDOG_USEC")
+	if timeout := SdWatchdogTimeout(); timeout != 0 {
+		t.Errorf("watchdog disabled, got %v", timeout)
+	}
+	os.Setenv("WATCHDOG_USEC", "60000000")
+	os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	if timeout := SdWatchdogTimeout(); timeout != time.Minute {
+		t.Errorf("timeout got %v, want 1m", timeout)
+	}
+	// watchdog of other process
+	os.Setenv("WATCHDOG_PID", "1")
+	if timeout := SdWatchdogTimeout(); timeout != 0 {
+		t.Errorf("watchdog of other pid, got %v", timeout)
+	}
+}
@@ -5,8 +5,8 @@
 package Com
 
 import (
-	"bufio"
 	"bytes"
+	"context"
 	"encoding/binary"
 	"encoding/json"
 	"errors"
@@ -22,9 +22,9 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
+	"unsafe"
 
-	"github.com/godbus/dbus"
-	polkit "github.com/linuxdeepin/go-dbus-factory/org.freedesktop.policykit1"
 	"golang.org/x/sys/unix"
 )
 
@@ -37,20 +37,29 @@ const (
 	cgroupSuffix     = "cgroup.procs"
 )
 
-// get origin destination addr
+// get origin destination addr, conn accepted by ipv6 listener reads ipv6 origin destination
 func GetTcpRemoteAddr(conn *net.TCPConn) (*net.TCPAddr, error) {
-	// get file descriptor
-	file, err := conn.File()
+	// read option on fd of conn, File() duplicates fd and turns conn into blocking mode
+	rawConn, err := conn.SyscallConn()
 	if err != nil {
 		return nil, err
 	}
-	fd := int(file.Fd())
-
-	// from linux/include/uapi/linux/netfilter_ipv4.h
-	req, err := unix.GetsockoptIPv6Mreq(fd, syscall.IPPROTO_IP, SoOriginalDst)
+	local, ok := conn.LocalAddr().(*net.TCPAddr)
+	if ok && local.IP.To4() == nil {
+		return getTcp6RemoteAddr(rawConn)
+	}
+	var req *unix.IPv6Mreq
+	var optErr error
+	err = rawConn.Control(func(fd uintptr) {
+		// from linux/include/uapi/linux/netfilter_ipv4.h
+		req, optErr = unix.GetsockoptIPv6Mreq(int(fd), syscall.IPPROTO_IP, SoOriginalDst)
+	})
 	if err != nil {
 		return nil, err
 	}
+	if optErr != nil {
+		return nil, optErr
+	}
 
 	// struct tcp addr
 	tcpAddr := &net.TCPAddr{
@@ -60,37 +69,53 @@ func GetTcpRemoteAddr(conn *net.TCPConn) (*net.TCPAddr, error) {
 	return tcpAddr, nil
 }
 
+// get ipv6 origin destination, struct sockaddr_in6 is read into struct ip6_mtuinfo which begins with it
+func getTcp6RemoteAddr(rawConn syscall.RawConn) (*net.TCPAddr, error) {
+	var info *unix.IPv6MTUInfo
+	var optErr error
+	err := rawConn.Control(func(fd uintptr) {
+		info, optErr = unix.GetsockoptIPv6MTUInfo(int(fd), syscall.IPPROTO_IPV6, Ip6SoOriginalDst)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if optErr != nil {
+		return nil, optErr
+	}
+	// port is in network order
+	port := (*[2]byte)(unsafe.Pointer(&info.Addr.Port))
+	tcpAddr := &net.TCPAddr{
+		IP:   net.IP(append([]byte(nil), info.Addr.Addr[:]...)),
+		Port: int(port[0])<<8 + int(port[1]),
+	}
+	return tcpAddr, nil
+}
+
 // set conn opt transparent
 func SetConnOptTrn(conn net.Conn) error {
-	// check if is the same type, udp addr can not dial tcp addr
-	if reflect.TypeOf(conn) != reflect.TypeOf(&net.UDPConn{}) && reflect.TypeOf(conn) != reflect.TypeOf(&net.TCPConn{}) {
+	// udp conn and tcp conn have all raw conn, option is set on fd of conn, no fd is duplicated
+	var sysConn syscall.Conn
+	switch c := conn.(type) {
+	case *net.UDPConn:
+		sysConn = c
+	case *net.TCPConn:
+		sysConn = c
+	default:
 		return errors.New("conn type is not udp conn and tcp conn")
 	}
-	/*
-		udp conn and tcp conn have all File() method
-			type conn struct {
-				fd *netFD
-			}
-			func (c *conn) File() (f *os.File, err error)
-	*/
-	// call File() method
-	value := reflect.ValueOf(conn)
-	call := value.MethodByName("File").Call(nil)
-	if len(call) != 2 {
-		return errors.New("return of file method is not match")
-	}
-	// check err
-	if err, ok := call[1].Interface().(error); ok {
+	rawConn, err := sysConn.SyscallConn()
+	if err != nil {
 		return err
 	}
-	// convert file
-	file, ok := call[0].Interface().(*os.File)
-	if !ok {
-		return errors.New("convert file failed")
-	}
-	// defer file.Close()
 	// set sock opt trn
-	return SetSockOptTrn(int(file.Fd()))
+	var optErr error
+	err = rawConn.Control(func(fd uintptr) {
+		optErr = SetSockOptTrn(int(fd))
+	})
+	if err != nil {
+		return err
+	}
+	return optErr
 }
 
 // set socket transparent
@@ -113,11 +138,30 @@ func SetSockOptTrn(fd int) error {
 	if err != nil {
 		return err
 	}
+	// ipv6 socket is transparent by its own option
+	domain, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, unix.SO_DOMAIN)
+	if err != nil {
+		return err
+	}
+	if domain == syscall.AF_INET6 {
+		err = syscall.SetsockoptInt(fd, syscall.SOL_IPV6, unix.IPV6_TRANSPARENT, 1)
+		if err != nil {
+			return err
+		}
+	}
+	// origin destination of tcp is local addr of conn, only udp receives it by msg_hdr,
+	// new kernel refuses recv_origin_dst on tcp socket
+	if soTyp != syscall.SOCK_DGRAM {
+		return nil
+	}
 	// set ip recv_origin_dst
 	err = syscall.SetsockoptInt(fd, syscall.SOL_IP, syscall.IP_RECVORIGDSTADDR, 1)
 	if err != nil {
 		return err
 	}
+	if domain == syscall.AF_INET6 {
+		return syscall.SetsockoptInt(fd, syscall.SOL_IPV6, unix.IPV6_RECVORIGDSTADDR, 1)
+	}
 	return nil
 }
 
@@ -146,7 +190,7 @@ func ParseRemoteAddrFromMsgHdr(buf []byte) (*BaseAddr, error) {
 				IP:   msg.Data[4:8],
 				Port: int(binary.BigEndian.Uint16(msg.Data[2:4])),
 			}
-		} else if msg.Header.Level == syscall.SOL_IPV6 && msg.Header.Type == syscall.IP_RECVORIGDSTADDR {
+		} else if msg.Header.Level == syscall.SOL_IPV6 && msg.Header.Type == unix.IPV6_ORIGDSTADDR {
 			addr = &BaseAddr{
 				IP:   msg.Data[8:24],
 				Port: int(binary.BigEndian.Uint16(msg.Data[2:4])),
@@ -160,19 +204,148 @@ func ParseRemoteAddrFromMsgHdr(buf []byte) (*BaseAddr, error) {
 	return addr, err
 }
 
+// mark of sockets created by daemon, traffic of daemon returns by one rule of this mark,
+// so that dial of proxy, dns and health check never loops back to daemon itself
+const SelfMark uint32 = 0xdee9
+
+// options of outbound socket, zero value field is not set, SelfMark is set if mark is zero
+type DialOpt struct {
+	Device string // SO_BINDTODEVICE
+	Mark   uint32 // SO_MARK
+	// options below are only set on tcp socket
+	MSS               int  // TCP_MAXSEG
+	KeepAliveIdle     int  // TCP_KEEPIDLE in seconds
+	KeepAliveInterval int  // TCP_KEEPINTVL in seconds
+	KeepAliveCount    int  // TCP_KEEPCNT
+	FastOpen          bool // TCP_FASTOPEN_CONNECT
+	SndBuf            int  // SO_SNDBUF
+	RcvBuf            int  // SO_RCVBUF
+}
+
+// dialer of daemon, socket is marked by SelfMark
+func NewDialer(timeout time.Duration) *net.Dialer {
+	return DialOpt{}.Dialer(timeout)
+}
+
+// dialer sets options, keepalive of go runtime overrides options set by control, so it is disabled if any is set
+func (opt DialOpt) Dialer(timeout time.Duration) *net.Dialer {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: opt.Control,
+	}
+	if opt.keepAlive() {
+		dialer.KeepAlive = -1
+	}
+	return dialer
+}
+
+// resolver of daemon, queries are sent from sockets marked by SelfMark,
+// default resolver is replaced at start so that lookup inside libraries is marked too
+var SelfResolver = &net.Resolver{
+	PreferGo: true,
+	Dial: func(ctx context.Context, network string, address string) (net.Conn, error) {
+		return NewDialer(0).DialContext(ctx, network, address)
+	},
+}
+
+// int option of socket, zero value is not set
+type sockOpt struct {
+	level int
+	name  int
+	value int
+}
+
+// check if any keepalive option is set
+func (opt DialOpt) keepAlive() bool {
+	return opt.KeepAliveIdle != 0 || opt.KeepAliveInterval != 0 || opt.KeepAliveCount != 0
+}
+
+// set options on socket
+func (opt DialOpt) apply(fd int) error {
+	if opt.Device != "" {
+		err := syscall.BindToDevice(fd, opt.Device)
+		if err != nil {
+			return err
+		}
+	}
+	mark := opt.Mark
+	if mark == 0 {
+		mark = SelfMark
+	}
+	err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_MARK, int(mark))
+	if err != nil {
+		return err
+	}
+	// options are shared by udp socket of the same proxy
+	soTyp, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_TYPE)
+	if err != nil || soTyp != syscall.SOCK_STREAM {
+		return err
+	}
+	// all are set before connect, mss announced by syn is clamped, and window scale follows receive buffer
+	opts := []sockOpt{
+		{syscall.IPPROTO_TCP, syscall.TCP_MAXSEG, opt.MSS},
+		{syscall.SOL_SOCKET, syscall.SO_SNDBUF, opt.SndBuf},
+		{syscall.SOL_SOCKET, syscall.SO_RCVBUF, opt.RcvBuf},
+		{syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE, opt.KeepAliveIdle},
+		{syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL, opt.KeepAliveInterval},
+		{syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT, opt.KeepAliveCount},
+	}
+	if opt.keepAlive() {
+		opts = append(opts, sockOpt{syscall.SOL_SOCKET, syscall.SO_KEEPALIVE, 1})
+	}
+	// connect returns at once, syn is sent with first write
+	if opt.FastOpen {
+		opts = append(opts, sockOpt{syscall.IPPROTO_TCP, unix.TCP_FASTOPEN_CONNECT, 1})
+	}
+	for _, elem := range opts {
+		if elem.value == 0 {
+			continue
+		}
+		err = syscall.SetsockoptInt(fd, elem.level, elem.name, elem.value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// control of net.Dialer, set options before connect
+func (opt DialOpt) Control(network string, address string, c syscall.RawConn) error {
+	var optErr error
+	err := c.Control(func(fd uintptr) {
+		optErr = opt.apply(int(fd))
+	})
+	if err != nil {
+		return err
+	}
+	return optErr
+}
+
+// timeout of mega dial without context
+const megaDialTimeout = 3 * time.Second
+
+// deadline in the past, interrupts waiting connect at once
+var aLongTimeAgo = time.Unix(1, 0)
+
 // mega dial try to transparent connect, privilege should be needed
-func MegaDial(network string, lAddr net.Addr, rAddr net.Addr) (net.Conn, error) {
+func MegaDial(network string, lAddr net.Addr, rAddr net.Addr, opts ...DialOpt) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), megaDialTimeout)
+	defer cancel()
+	return MegaDialContext(ctx, network, lAddr, rAddr, opts...)
+}
+
+// mega dial with context, connect is interrupted when context is done
+func MegaDialContext(ctx context.Context, network string, lAddr net.Addr, rAddr net.Addr, opts ...DialOpt) (net.Conn, error) {
 	// check if is the same type, udp addr can not dial tcp addr
-	if reflect.TypeOf(lAddr) != reflect.TypeOf(rAddr) {
+	if !sameAddrType(lAddr, rAddr) {
 		return nil, errors.New("dial local addr is not match with remote addr")
 	}
 	// get domain
 	var domain int
-	// net.addr is pointer, cannot get field by name directly
-	addrPtr := reflect.ValueOf(lAddr)
-	addrValue := reflect.Indirect(addrPtr)
-	// get ip message
-	var ip net.IP = addrValue.FieldByName("IP").Bytes()
+	ip, _, err := addrIPPort(lAddr)
+	if err != nil {
+		return nil, err
+	}
 	if ip.To4() != nil {
 		domain = syscall.AF_INET
 	} else if ip.To16() != nil {
@@ -182,19 +355,39 @@ func MegaDial(network string, lAddr net.Addr, rAddr net.Addr) (net.Conn, error)
 	}
 	// get typ
 	var typ int
+	var name string
 	if network == "tcp" {
 		typ = syscall.SOCK_STREAM
+		name = "tcp_handler_%v"
 	} else if network == "udp" {
 		typ = syscall.SOCK_DGRAM
+		name = "udp_handler_%v"
 	}
-	fd, err := syscall.Socket(domain, typ, 0)
+	// non block fd is added to runtime poller, so that connect can be waited with deadline
+	fd, err := syscall.Socket(domain, typ|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, 0)
 	if err != nil {
 		return nil, err
 	}
+	// file owns fd, fd is closed when dial failed, conn holds its own copy of fd
+	file := os.NewFile(uintptr(fd), fmt.Sprintf(name, fd))
+	if file == nil {
+		_ = syscall.Close(fd)
+		return nil, errors.New("create new file is nil")
+	}
+	defer file.Close()
 	// set transparent
 	if err = SetSockOptTrn(fd); err != nil {
 		return nil, err
 	}
+	// bind to device and mark, reply socket is marked as self too
+	if len(opts) == 0 {
+		opts = []DialOpt{{}}
+	}
+	for _, opt := range opts {
+		if err = opt.apply(fd); err != nil {
+			return nil, err
+		}
+	}
 	// convert addr
 	lSockAddr, err := convertAddrToSockAddr(lAddr)
 	if err != nil {
@@ -208,22 +401,10 @@ func MegaDial(network string, lAddr net.Addr, rAddr net.Addr) (net.Conn, error)
 	if err = syscall.Bind(fd, lSockAddr); err != nil {
 		return nil, err
 	}
-	// bind addr
-	if err = syscall.Connect(fd, rSockAddr); err != nil {
+	// connect remote addr
+	if err = connectContext(ctx, file, rSockAddr); err != nil {
 		return nil, err
 	}
-	// create new file
-	var name string
-	if network == "tcp" {
-		name = "tcp_handler_%v"
-	} else if network == "udp" {
-		name = "udp_handler_%v"
-	}
-
-	file := os.NewFile(uintptr(fd), fmt.Sprintf(name, fd))
-	if file == nil {
-		return nil, errors.New("create new file is nil")
-	}
 	// create file conn
 	conn, err := net.FileConn(file)
 	if err != nil {
@@ -232,31 +413,112 @@ func MegaDial(network string, lAddr net.Addr, rAddr net.Addr) (net.Conn, error)
 	return conn, nil
 }
 
+// connect non block socket of file, wait until connected or context is done
+func connectContext(ctx context.Context, file *os.File, sa syscall.Sockaddr) error {
+	rawConn, err := file.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var connErr error
+	err = rawConn.Control(func(fd uintptr) {
+		connErr = syscall.Connect(int(fd), sa)
+	})
+	if err != nil {
+		return err
+	}
+	// udp connects at once
+	if connErr != syscall.EINPROGRESS {
+		return connErr
+	}
+	// deadline and cancel of context both interrupt waiting
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = file.SetWriteDeadline(deadline)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = file.SetWriteDeadline(aLongTimeAgo)
+		case <-stop:
+		}
+	}()
+	// socket is writable when connect finished, result is in SO_ERROR
+	err = rawConn.Write(func(fd uintptr) bool {
+		var soErr int
+		soErr, connErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_ERROR)
+		if connErr != nil {
+			return true
+		}
+		if soErr != 0 {
+			connErr = syscall.Errno(soErr)
+			return true
+		}
+		// still connecting if peer is unknown
+		_, connErr = syscall.Getpeername(int(fd))
+		if connErr == syscall.ENOTCONN {
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	return connErr
+}
+
+// check if both addr are tcp addr or udp addr
+func sameAddrType(lAddr net.Addr, rAddr net.Addr) bool {
+	switch lAddr.(type) {
+	case *net.TCPAddr:
+		_, ok := rAddr.(*net.TCPAddr)
+		return ok
+	case *net.UDPAddr:
+		_, ok := rAddr.(*net.UDPAddr)
+		return ok
+	default:
+		return false
+	}
+}
+
+// ip and port of tcp addr or udp addr
+func addrIPPort(addr net.Addr) (net.IP, int, error) {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		if a != nil {
+			return a.IP, a.Port, nil
+		}
+	case *net.UDPAddr:
+		if a != nil {
+			return a.IP, a.Port, nil
+		}
+	}
+	return nil, 0, errors.New("addr typ is not tcp addr or udp addr")
+}
+
 // convert addr to sock addr
 func convertAddrToSockAddr(addr net.Addr) (syscall.Sockaddr, error) {
-	// check if addr can convert to udp addr and tcp addr, if not return as error
-	if !reflect.TypeOf(addr).ConvertibleTo(reflect.TypeOf(&net.UDPAddr{})) &&
-		!reflect.TypeOf(addr).ConvertibleTo(reflect.TypeOf(&net.TCPAddr{})) {
-		return nil, errors.New("addr typ is not tcp addr or udp addr")
-	}
 	// convert net addr to sock_addr
-	valuePtr := reflect.ValueOf(addr)
-	value := reflect.Indirect(valuePtr)
-	var ip net.IP = value.FieldByName("IP").Bytes()
-	port := value.FieldByName("Port").Int()
+	ip, port, err := addrIPPort(addr)
+	if err != nil {
+		return nil, err
+	}
 	if port == 0 {
 		port = 80
 	}
 	// convert addr and port
 	if ip.To4() != nil {
 		inet4 := &syscall.SockaddrInet4{
-			Port: int(port),
+			Port: port,
 		}
 		copy(inet4.Addr[:], ip.To4())
 		return inet4, nil
 	} else if ip.To16() != nil {
 		inet6 := &syscall.SockaddrInet6{
-			Port: int(port),
+			Port: port,
 		}
 		copy(inet6.Addr[:], ip.To16())
 		return inet6, nil
@@ -269,70 +531,142 @@ type DataPackage struct {
 	Data []byte
 }
 
-// marshal data, now only useful for udp
+// addr type of socks5 udp package
+const (
+	atypIPv4   = 1
+	atypDomain = 3
+	atypIPv6   = 4
+)
+
+// max length of domain in socks5, length is one byte
+const maxDomainLen = 255
+
+// domain addr carried by socks5 udp package, which is not resolved
+type DomainAddr struct {
+	Domain string
+	Port   int
+}
+
+func (a *DomainAddr) Network() string {
+	return "udp"
+}
+
+func (a *DomainAddr) String() string {
+	return net.JoinHostPort(a.Domain, strconv.Itoa(a.Port))
+}
+
+// marshal data, now only useful for udp, return nil if addr can not be carried
 func MarshalPackage(pkg DataPackage, proto string) []byte {
 	/*
 			sock5 udp data
-		   +----+------+--------+----------+----------+------+
-		   |RSV | FRAG |  ATYP  | DST.ADDR | DST.PORT | DATA |
-		   +----+------+--------+------+----------+----------+
-		   | 1  |  0   |    1   | Variable | Variable | Data |
-		   +----+------+--------+----------+----------+------+
+		   +----+------+------+----------+----------+----------+
+		   |RSV | FRAG | ATYP | DST.ADDR | DST.PORT |   DATA   |
+		   +----+------+------+----------+----------+----------+
+		   | 2  |  1   |  1   | Variable |    2     | Variable |
+		   +----+------+------+----------+----------+----------+
 	*/
-	// message
-	addr := pkg.Addr
-	valuePtr := reflect.ValueOf(addr)
-	value := reflect.Indirect(valuePtr)
-	var ip net.IP = value.FieldByName("IP").Bytes()
-	netPort := value.FieldByName("Port").Int()
-	data := pkg.Data
-	// udp message protocol
-	buf := make([]byte, 4)
-	buf[0] = 0
 	// only udp is valid
-	switch proto {
-	case "tcp":
+	if proto != "udp" || pkg.Addr == nil {
 		return nil
-	case "udp":
-		buf[1] = 0
+	}
+	// RSV FRAG, fragment is never sent
+	buf := make([]byte, 3, 4+1+maxDomainLen+2+len(pkg.Data))
+	var ip net.IP
+	var port int
+	var domain string
+	switch addr := pkg.Addr.(type) {
+	case *net.UDPAddr, *net.TCPAddr:
+		ip, port, _ = addrIPPort(addr)
+	case *DomainAddr:
+		domain, port = addr.Domain, addr.Port
 	default:
-		return nil
+		// other addr, such as domain addr of t-proxy, is parsed from string
+		host, portStr, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			return nil
+		}
+		port, err = strconv.Atoi(portStr)
+		if err != nil {
+			return nil
+		}
+		ip = net.ParseIP(host)
+		if ip == nil {
+			domain = host
+		}
 	}
-	buf[1] = 0
-	buf[2] = 0
-	if ip.To4() != nil {
-		buf[3] = 1
+	switch {
+	case ip == nil:
+		if domain == "" || len(domain) > maxDomainLen {
+			return nil
+		}
+		buf = append(buf, atypDomain, byte(len(domain)))
+		buf = append(buf, domain...)
+	case ip.To4() != nil:
+		buf = append(buf, atypIPv4)
 		buf = append(buf, ip.To4()...)
-	} else if ip.To16() != nil {
-		buf[3] = 1
+	case ip.To16() != nil:
+		buf = append(buf, atypIPv6)
 		buf = append(buf, ip.To16()...)
-	} else {
-		buf[3] = 3
-		buf = append(buf, ip...)
+	default:
+		return nil
 	}
-	// convert port 2 byte
-
-	port := make([]byte, 2)
-	binary.BigEndian.PutUint16(port, uint16(netPort))
-	buf = append(buf, port...)
+	if port < 0 || port > 65535 {
+		return nil
+	}
+	portBuf := make([]byte, 2)
+	binary.BigEndian.PutUint16(portBuf, uint16(port))
+	buf = append(buf, portBuf...)
 	// add data
-	buf = append(buf, data...)
+	buf = append(buf, pkg.Data...)
 	return buf
 }
 
-// unmarshal data
-func UnMarshalPackage(msg []byte) DataPackage {
-	addr := msg[4:8]
-	port := binary.BigEndian.Uint16(msg[8:10])
-	data := msg[10:]
-
-	return DataPackage{
-		Addr: &net.UDPAddr{
-			IP:   addr[:],
-			Port: int(port),
-		},
-		Data: data,
+// unmarshal data, addr is udp addr or domain addr, data refers to msg
+func UnMarshalPackage(msg []byte) (DataPackage, error) {
+	// RSV FRAG ATYP
+	if len(msg) < 4 {
+		return DataPackage{}, errors.New("package is too short")
+	}
+	// fragment is not supported, package should be dropped as rfc 1928 says
+	if msg[2] != 0 {
+		return DataPackage{}, errors.New("fragment is not supported")
+	}
+	offset := 4
+	var addrLen int
+	switch msg[3] {
+	case atypIPv4:
+		addrLen = net.IPv4len
+	case atypIPv6:
+		addrLen = net.IPv6len
+	case atypDomain:
+		if len(msg) < offset+1 {
+			return DataPackage{}, errors.New("package is too short")
+		}
+		addrLen = int(msg[offset])
+		offset++
+		if addrLen == 0 {
+			return DataPackage{}, errors.New("domain is empty")
+		}
+	default:
+		return DataPackage{}, fmt.Errorf("addr type %d is invalid", msg[3])
+	}
+	if len(msg) < offset+addrLen+2 {
+		return DataPackage{}, errors.New("package is too short")
+	}
+	port := int(binary.BigEndian.Uint16(msg[offset+addrLen:]))
+	var addr net.Addr
+	if msg[3] == atypDomain {
+		addr = &DomainAddr{Domain: string(msg[offset : offset+addrLen]), Port: port}
+	} else {
+		addr = &net.UDPAddr{
+			IP:   net.IP(append([]byte(nil), msg[offset:offset+addrLen]...)),
+			Port: port,
+		}
 	}
+	return DataPackage{
+		Addr: addr,
+		Data: msg[offset+addrLen+2:],
+	}, nil
 }
 
 // get home dir
@@ -359,253 +693,94 @@ func GuaranteeDir(path string) error {
 	return nil
 }
 
-func PromotePrivilege(actionId string, uid uint32, pid uint32, time uint64) error {
-	// get system bus
-	systemBus, err := dbus.SystemBus()
-	if err != nil {
-		return err
-	}
-	// auth body
-	authority := polkit.NewAuthority(systemBus)
-	// add uid pid and start-time to polkit request
-	subject := polkit.MakeSubject(polkit.SubjectKindUnixProcess)
-	subject.SetDetail("uid", pid)
-	subject.SetDetail("pid", pid)
-	subject.SetDetail("start-time", time)
-	// start auth to promote privilege
-	ret, err := authority.CheckAuthorization(0, subject, actionId, nil, polkit.CheckAuthorizationFlagsNone, "")
-	if err != nil {
-		return err
-	}
-	// check if return success
-	if !ret.IsAuthorized {
-		return errors.New("authorized failed")
-	}
-	// auth success
-	return nil
-}
-
-// get start time from /proc/pid/stat
+// get start time from /proc/pid/stat, in clock ticks after boot
 func GetProcStartTime(pid uint32) (uint64, error) {
-	// proc path
-	procPath := fmt.Sprintf("/proc/%v/stat", pid)
-	if _, err := os.Stat(procPath); err != nil {
-		return 0, errors.New("proc not exist")
-	}
-	// read stat message
-	stat, err := ioutil.ReadFile(procPath)
+	info, err := ReadProcInfo(ProcRoot, strconv.FormatUint(uint64(pid), 10), ProcStat)
 	if err != nil {
 		return 0, err
 	}
-	// split all message
-	// https://man7.org/linux/man-pages/man5/procfs.5.html
-	statSl := strings.Split(string(stat), " ")
-	// actually len is 52, according to doc, but 22 is enough here
-	if len(statSl) < 22 {
-		return 0, errors.New("proc split is not larger than 22")
-	}
-	// index 21 is the start time
-	timeStr := statSl[21]
-	// convert to int
-	time, err := strconv.Atoi(timeStr)
+	return info.StartTicks, nil
+}
+
+// get environment of proc from /proc/pid/environ, such as KEY=value
+func GetProcEnviron(pid uint32) ([]string, error) {
+	buf, err := ioutil.ReadFile(fmt.Sprintf("/proc/%v/environ", pid))
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	return uint64(time), nil
-}
-
-// use to mega add elem to slice and map     result add err
-func MegaAdd(src interface{}, tgt interface{}) (interface{}, bool, error) {
-	// check kind, only map and slice support mega del
-	srcTyp := reflect.TypeOf(src)
-	if srcTyp.Kind() != reflect.Slice && srcTyp.Kind() != reflect.Map {
-		return nil, false, errors.New("source type is not slice or map")
-	}
-	// check if elem type is the same with target
-	//elem := srcTyp.Elem()
-	if srcTyp.Elem() != reflect.TypeOf(tgt) {
-		return nil, false, errors.New("src base typ is not same with target")
-	}
-	// check if slice
-	if srcTyp.Kind() == reflect.Slice {
-		values := reflect.ValueOf(src)
-		// cycle
-		var index = 0
-		for ; index < values.Len(); index++ {
-			// convert index to interface
-			cmpValue := values.Index(index).Interface()
-			// check if elem equal with target
-			if reflect.DeepEqual(cmpValue, tgt) {
-				break
-			}
-		}
-		// if already exist
-		if index != values.Len() {
-			return src, false, nil
-		}
-		// append to last
-		result := reflect.Append(values, reflect.ValueOf(tgt))
-		return result.Interface(), true, nil
-	}
-	return nil, false, nil
-}
-
-// mega insert elem to slice
-func MegaInsert(src interface{}, tgt interface{}, index int) (interface{}, bool, error) {
-	// check kind, only map and slice support mega del
-	srcTyp := reflect.TypeOf(src)
-	if srcTyp.Kind() != reflect.Slice && srcTyp.Kind() != reflect.Map {
-		return nil, false, errors.New("source type is not slice or map")
-	}
-	// check if elem type is the same with target
-	//elem := srcTyp.Elem()
-	if srcTyp.Elem() != reflect.TypeOf(tgt) {
-		return nil, false, errors.New("src base typ is not same with target")
-	}
-	// check if slice
-	if srcTyp.Kind() == reflect.Slice {
-		values := reflect.ValueOf(src)
-		tgtValue := reflect.ValueOf(tgt)
-		// check range
-		if values.Len() < index {
-			return nil, false, errors.New("insert index out of range")
-		}
-		front := values.Slice(0, index)
-		result := reflect.Append(front, tgtValue)
-		// insert at last index
-		if index == values.Len()-1 {
-			return result.Interface(), true, nil
-		}
-		// insert the central or beginning
-		back := values.Slice(index, values.Len())
-		result = reflect.AppendSlice(result, back)
-		return result.Interface(), true, nil
-	}
-	return nil, false, nil
-}
-
-// use to mega del elem from slice and map      result del err
-func MegaDel(src interface{}, tgt interface{}) (interface{}, bool, error) {
-	// check kind, only map and slice support mega del
-	srcTyp := reflect.TypeOf(src)
-	if srcTyp.Kind() != reflect.Slice && srcTyp.Kind() != reflect.Map {
-		return nil, false, errors.New("source type is not slice or map")
-	}
-	// check if elem type is the same with target
-	//elem := srcTyp.Elem()
-	if srcTyp.Elem() != reflect.TypeOf(tgt) {
-		return nil, false, errors.New("src base typ is not same with target")
-	}
-	// check if slice
-	if srcTyp.Kind() == reflect.Slice {
-		values := reflect.ValueOf(src)
-		// cycle
-		var index = 0
-		for ; index < values.Len(); index++ {
-			// convert index to interface
-			cmpValue := values.Index(index).Interface()
-			// check if elem equal with target
-			if reflect.DeepEqual(cmpValue, tgt) {
-				break
-			}
+	// entries are split by zero
+	var env []string
+	for _, elem := range bytes.Split(buf, []byte{0}) {
+		if len(elem) != 0 {
+			env = append(env, string(elem))
 		}
-		// not exist
-		if index == values.Len() {
-			return src, false, nil
-		}
-		front := values.Slice(0, index)
-		// check special pos, if is the last elem
-		if index == values.Len()-1 {
-			return front.Interface(), true, nil
-		}
-		// if not the last elem, including the first one
-		back := values.Slice(index+1, values.Len())
-		result := reflect.AppendSlice(front, back)
-		return result.Interface(), true, nil
 	}
-	return nil, false, nil
+	return env, nil
 }
 
-// check if target exist in slice
-func MegaExist(src interface{}, tgt interface{}) bool {
-	// check kind, only map and slice support mega exist
-	srcTyp := reflect.TypeOf(src)
-	if srcTyp.Kind() != reflect.Slice && srcTyp.Kind() != reflect.Map {
-		return false
-	}
-	// check if elem type is the same with target
-	if srcTyp.Elem() != reflect.TypeOf(tgt) {
-		return false
-	}
-	// if kind is slice
-	if srcTyp.Kind() == reflect.Slice {
-		values := reflect.ValueOf(src)
-		// search
-		for index := 0; index < values.Len(); index++ {
-			// convert index to interface
-			cmpValue := values.Index(index).Interface()
-			// check if elem equal with target
-			if reflect.DeepEqual(cmpValue, tgt) {
-				return true
-			}
-		}
-		return false
+// add elem to slice if not exist, return result and if added
+func MegaAdd[T comparable](src []T, tgt T) ([]T, bool) {
+	if MegaExist(src, tgt) {
+		return src, false
 	}
-	return false
+	return append(src, tgt), true
 }
 
-// pid must be num
-var pidRegexp = regexp.MustCompile("^[0-9]*[1-9][0-9]*$")
+// insert elem to slice at index, index equal to len appends to last
+func MegaInsert[T any](src []T, tgt T, index int) ([]T, error) {
+	// check range
+	if index < 0 || index > len(src) {
+		return src, errors.New("insert index out of range")
+	}
+	var zero T
+	src = append(src, zero)
+	copy(src[index+1:], src[index:])
+	src[index] = tgt
+	return src, nil
+}
 
-func IsPid(pid string) bool {
-	return pidRegexp.MatchString(pid)
+// del first elem equal to target from slice, return result and if deleted
+func MegaDel[T comparable](src []T, tgt T) ([]T, bool) {
+	return MegaDelFunc(src, func(elem T) bool { return elem == tgt })
 }
 
-// parse cgroup v2 message from /proc/pid/cgroup
-func ParseCGroup2FromBuf(in []byte) string {
-	byt := bytes.NewBuffer(in)
-	reader := bufio.NewReader(byt)
+// del first elem matched from slice, return result and if deleted
+func MegaDelFunc[T any](src []T, match func(T) bool) ([]T, bool) {
+	index := MegaIndexFunc(src, match)
+	if index < 0 {
+		return src, false
+	}
+	// result never shares tail with source, source may still be iterated by caller
+	result := make([]T, 0, len(src)-1)
+	result = append(result, src[:index]...)
+	return append(result, src[index+1:]...), true
+}
 
-	for {
-		// read line
-		buf, _, err := reader.ReadLine()
-		// dont care about if error if EOF
-		if err != nil {
-			return ""
-		}
-		// cgroup v2 message
-		// https://www.kernel.org/doc/Documentation/cgroup-v2.txt
-		if bytes.HasPrefix(buf, []byte("0::")) {
-			backPath := bytes.TrimPrefix(buf, []byte("0::"))
-			fullPath := filepath.Join(cgroupPrefix, string(backPath), cgroupSuffix)
-			return fullPath
+// check if target exist in slice
+func MegaExist[T comparable](src []T, tgt T) bool {
+	for _, elem := range src {
+		if elem == tgt {
+			return true
 		}
 	}
+	return false
 }
 
-// parse
-func ParsePPidFromBuf(in []byte) string {
-	byt := bytes.NewBuffer(in)
-	reader := bufio.NewReader(byt)
-
-	for {
-		// read line
-		buf, _, err := reader.ReadLine()
-		// dont care about if error if EOF
-		if err != nil {
-			return ""
-		}
-		// cgroup v2 message
-		// https://www.kernel.org/doc/Documentation/cgroup-v2.txt
-		ppidMsg := string(buf)
-		if strings.HasPrefix(ppidMsg, "PPid:") {
-			path := strings.Split(ppidMsg, "\t")
-			if len(path) < 2 {
-				return ""
-			}
-			return path[1]
+// index of first elem matched, -1 if not found
+func MegaIndexFunc[T any](src []T, match func(T) bool) int {
+	for index, elem := range src {
+		if match(elem) {
+			return index
 		}
 	}
+	return -1
+}
+
+// pid must be num
+var pidRegexp = regexp.MustCompile("^[0-9]*[1-9][0-9]*$")
+
+func IsPid(pid string) bool {
+	return pidRegexp.MatchString(pid)
 }
 
 // run script
@@ -661,3 +836,20 @@ func CloseBlockListener(listener net.Listener) error {
 
 	return errors.New("cant match listener type")
 }
+
+// check if ip is addr of local host, loopback, unspecified or addr of any interface
+func IsLocalIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() {
+		return true
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
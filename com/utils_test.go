@@ -0,0 +1,308 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Com
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestMarshalPackage(t *testing.T) {
+	tests := []struct {
+		addr net.Addr
+		atyp byte
+	}{
+		{&net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 53}, atypIPv4},
+		{&net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443}, atypIPv6},
+		{&net.TCPAddr{IP: net.ParseIP("::ffff:10.0.0.1"), Port: 80}, atypIPv4},
+		{&DomainAddr{Domain: "example.com", Port: 3478}, atypDomain},
+	}
+	for _, test := range tests {
+		msg := MarshalPackage(DataPackage{Addr: test.addr, Data: []byte("data")}, "udp")
+		if len(msg) < 4 || msg[3] != test.atyp {
+			t.Fatalf("marshal %s, got %v", test.addr, msg)
+		}
+		pkg, err := UnMarshalPackage(msg)
+		if err != nil {
+			t.Fatalf("unmarshal %s failed, err: %v", test.addr, err)
+		}
+		if pkg.Addr.String() != strings.Replace(test.addr.String(), "::ffff:", "", 1) {
+			t.Errorf("unmarshal addr %s, got %s", test.addr, pkg.Addr)
+		}
+		if !bytes.Equal(pkg.Data, []byte("data")) {
+			t.Errorf("unmarshal data %s", pkg.Data)
+		}
+	}
+	// tcp and too long domain can not be marshaled
+	if MarshalPackage(DataPackage{Addr: tests[0].addr}, "tcp") != nil {
+		t.Error("tcp should not be marshaled")
+	}
+	long := &DomainAddr{Domain: strings.Repeat("a", maxDomainLen+1), Port: 80}
+	if MarshalPackage(DataPackage{Addr: long}, "udp") != nil {
+		t.Error("too long domain should not be marshaled")
+	}
+}
+
+func TestUnMarshalPackageInvalid(t *testing.T) {
+	tests := [][]byte{
+		nil,
+		{0, 0, 0},
+		{0, 0, 1, atypIPv4, 1, 2, 3, 4, 0, 80},
+		{0, 0, 0, atypIPv4, 1, 2, 3, 4, 0},
+		{0, 0, 0, atypIPv6, 1, 2, 3, 4, 0, 80},
+		{0, 0, 0, atypDomain},
+		{0, 0, 0, atypDomain, 0, 0, 80},
+		{0, 0, 0, atypDomain, 3, 'a', 'b', 0, 80},
+		{0, 0, 0, 2, 1, 2, 3, 4, 0, 80},
+	}
+	for _, msg := range tests {
+		_, err := UnMarshalPackage(msg)
+		if err == nil {
+			t.Errorf("unmarshal %v should fail", msg)
+		}
+	}
+}
+
+func FuzzUnMarshalPackage(f *testing.F) {
+	f.Add([]byte{0, 0, 0, atypIPv4, 1, 2, 3, 4, 0, 80, 'a'})
+	f.Add([]byte{0, 0, 0, atypDomain, 1, 'a', 0, 80})
+	f.Add(MarshalPackage(DataPackage{Addr: &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1}}, "udp"))
+	f.Fuzz(func(t *testing.T, msg []byte) {
+		pkg, err := UnMarshalPackage(msg)
+		if err != nil {
+			return
+		}
+		// package which is parsed can be marshaled again
+		out := MarshalPackage(pkg, "udp")
+		if out == nil {
+			t.Fatalf("marshal %s again failed", pkg.Addr)
+		}
+		again, err := UnMarshalPackage(out)
+		if err != nil {
+			t.Fatalf("unmarshal again failed, err: %v", err)
+		}
+		if again.Addr.String() != pkg.Addr.String() || !bytes.Equal(again.Data, pkg.Data) {
+			t.Fatalf("round trip %s, got %s", pkg.Addr, again.Addr)
+		}
+	})
+}
+
+func TestMegaSlice(t *testing.T) {
+	sl, added := MegaAdd([]string{"a", "b"}, "c")
+	if !added || !reflect.DeepEqual(sl, []string{"a", "b", "c"}) {
+		t.Errorf("add got %v %v", sl, added)
+	}
+	sl, added = MegaAdd(sl, "a")
+	if added || len(sl) != 3 {
+		t.Errorf("add exist got %v %v", sl, added)
+	}
+	for index, want := range [][]string{
+		{"x", "a", "b", "c"},
+		{"a", "x", "b", "c"},
+		{"a", "b", "x", "c"},
+		{"a", "b", "c", "x"},
+	} {
+		got, err := MegaInsert(append([]string(nil), sl...), "x", index)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("insert at %d got %v, err: %v", index, got, err)
+		}
+	}
+	_, err := MegaInsert(sl, "x", 4)
+	if err == nil {
+		t.Error("insert out of range should fail")
+	}
+	sl, deleted := MegaDel(sl, "b")
+	if !deleted || !reflect.DeepEqual(sl, []string{"a", "c"}) {
+		t.Errorf("del got %v %v", sl, deleted)
+	}
+	_, deleted = MegaDel(sl, "b")
+	if deleted {
+		t.Error("del not exist should not delete")
+	}
+	if !MegaExist(sl, "c") || MegaExist(sl, "b") {
+		t.Errorf("exist incorrect, %v", sl)
+	}
+}
+
+func TestDialSelfMark(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	conn, err := NewDialer(time.Second).Dial("tcp", listener.Addr().String())
+	if errors.Is(err, syscall.EPERM) {
+		t.Skip("set mark needs CAP_NET_ADMIN")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	rawConn, err := conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mark int
+	err = rawConn.Control(func(fd uintptr) {
+		mark, err = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_MARK)
+	})
+	if err != nil || uint32(mark) != SelfMark {
+		t.Errorf("mark of socket got %#x, want %#x, err: %v", mark, SelfMark, err)
+	}
+}
+
+func TestDialMSS(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	dialer := net.Dialer{Timeout: time.Second, Control: DialOpt{MSS: 1200}.Control}
+	conn, err := dialer.Dial("tcp", listener.Addr().String())
+	if errors.Is(err, syscall.EPERM) {
+		t.Skip("set mark needs CAP_NET_ADMIN")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	rawConn, err := conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mss int
+	err = rawConn.Control(func(fd uintptr) {
+		mss, err = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_MAXSEG)
+	})
+	if err != nil || mss > 1200 {
+		t.Errorf("mss of socket got %d, want no more than 1200, err: %v", mss, err)
+	}
+	// udp socket ignores mss
+	udpConn, err := dialer.Dial("udp", "127.0.0.1:53")
+	if err != nil {
+		t.Fatalf("dial udp with mss failed, err: %v", err)
+	}
+	_ = udpConn.Close()
+}
+
+func TestDialTuning(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	opt := DialOpt{KeepAliveIdle: 30, KeepAliveInterval: 5, KeepAliveCount: 4, SndBuf: 65536, RcvBuf: 65536}
+	conn, err := opt.Dialer(time.Second).Dial("tcp", listener.Addr().String())
+	if errors.Is(err, syscall.EPERM) {
+		t.Skip("set mark needs CAP_NET_ADMIN")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	rawConn, err := conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []sockOpt{
+		{syscall.SOL_SOCKET, syscall.SO_KEEPALIVE, 1},
+		{syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE, 30},
+		{syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL, 5},
+		{syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT, 4},
+	}
+	for _, elem := range want {
+		var value int
+		err = rawConn.Control(func(fd uintptr) {
+			value, err = syscall.GetsockoptInt(int(fd), elem.level, elem.name)
+		})
+		if err != nil || value != elem.value {
+			t.Errorf("option %d of socket got %d, want %d, err: %v", elem.name, value, elem.value, err)
+		}
+	}
+	// kernel doubles buffer size for bookkeeping
+	var sndBuf int
+	err = rawConn.Control(func(fd uintptr) {
+		sndBuf, err = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF)
+	})
+	if err != nil || sndBuf < 65536 {
+		t.Errorf("send buffer of socket got %d, want at least 65536, err: %v", sndBuf, err)
+	}
+}
+
+func TestIsLocalIP(t *testing.T) {
+	for _, ip := range []string{"127.0.0.1", "::1", "0.0.0.0"} {
+		if !IsLocalIP(net.ParseIP(ip)) {
+			t.Errorf("%s should be local", ip)
+		}
+	}
+	if IsLocalIP(net.ParseIP("192.0.2.1")) {
+		t.Error("192.0.2.1 should not be local")
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil || len(addrs) == 0 {
+		t.Skip("no interface addr")
+	}
+	if ipNet, ok := addrs[0].(*net.IPNet); ok && !IsLocalIP(ipNet.IP) {
+		t.Errorf("addr %s of interface should be local", ipNet.IP)
+	}
+}
+
+// control message of origin destination, level and type are in header, sockaddr is data
+func origDstMsg(level int32, typ int32, sockaddr []byte) []byte {
+	buf := make([]byte, syscall.CmsgSpace(len(sockaddr)))
+	hdr := (*syscall.Cmsghdr)(unsafe.Pointer(&buf[0]))
+	hdr.Level = level
+	hdr.Type = typ
+	hdr.SetLen(syscall.CmsgLen(len(sockaddr)))
+	copy(buf[syscall.CmsgLen(0):], sockaddr)
+	return buf
+}
+
+func TestParseRemoteAddrFromMsgHdr(t *testing.T) {
+	// struct sockaddr_in, family port addr
+	sockaddr4 := []byte{syscall.AF_INET, 0, 0x01, 0xbb, 192, 0, 2, 1, 0, 0, 0, 0, 0, 0, 0, 0}
+	// struct sockaddr_in6, family port flowinfo addr scope
+	sockaddr6 := make([]byte, syscall.SizeofSockaddrInet6)
+	sockaddr6[0], sockaddr6[2], sockaddr6[3] = syscall.AF_INET6, 0x00, 0x35
+	copy(sockaddr6[8:], net.ParseIP("2001:db8::1"))
+	cases := []struct {
+		msg  []byte
+		want string
+	}{
+		{origDstMsg(syscall.SOL_IP, syscall.IP_RECVORIGDSTADDR, sockaddr4), "192.0.2.1:443"},
+		{origDstMsg(syscall.SOL_IPV6, unix.IPV6_ORIGDSTADDR, sockaddr6), "[2001:db8::1]:53"},
+	}
+	for _, c := range cases {
+		addr, err := ParseRemoteAddrFromMsgHdr(c.msg)
+		if err != nil {
+			t.Errorf("parse %s failed, err: %v", c.want, err)
+			continue
+		}
+		got := net.JoinHostPort(addr.IP.String(), strconv.Itoa(addr.Port))
+		if got != c.want {
+			t.Errorf("origin destination got %s, want %s", got, c.want)
+		}
+	}
+}
+
+func BenchmarkMegaExist(b *testing.B) {
+	sl := make([]string, 64)
+	for index := range sl {
+		sl[index] = strconv.Itoa(index)
+	}
+	for i := 0; i < b.N; i++ {
+		MegaExist(sl, "63")
+	}
+}
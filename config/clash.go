@@ -0,0 +1,148 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Config
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+/*
+	clash config, only fields used are parsed
+	proxies:
+	  - {name: office, type: http, server: 10.0.0.1, port: 8080, username: uos, password: pass}
+	  - {name: home, type: socks5, server: 10.0.0.2, port: 1080}
+	rules:
+	  - DOMAIN-SUFFIX,cn,DIRECT
+	  - IP-CIDR,10.0.0.0/8,DIRECT,no-resolve
+	  - GEOIP,CN,DIRECT
+	  - MATCH,Proxy
+*/
+
+// target of clash rule sending traffic direct
+const clashDirect = "DIRECT"
+
+type clashConfig struct {
+	Proxies     []clashProxy             `yaml:"proxies"`
+	ProxyGroups []map[string]interface{} `yaml:"proxy-groups"`
+	Rules       []string                 `yaml:"rules"`
+}
+
+type clashProxy struct {
+	Name     string `yaml:"name"`
+	Type     string `yaml:"type"`
+	Server   string `yaml:"server"`
+	Port     int    `yaml:"port"`
+	UserName string `yaml:"username"`
+	Password string `yaml:"password"`
+	TLS      bool   `yaml:"tls"`
+}
+
+// convert proxies and rules of clash config
+func ImportClash(data []byte, withRules bool) (*ImportResult, error) {
+	var cfg clashConfig
+	err := yaml.Unmarshal(data, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal clash config failed, err: %v", err)
+	}
+	result := &ImportResult{}
+	for _, proxy := range cfg.Proxies {
+		var proto string
+		switch strings.ToLower(proxy.Type) {
+		case "http":
+			proto = "http"
+		case "socks5":
+			proto = "socks5"
+		default:
+			result.unsupported("proxy %s: type %s is not supported", proxy.Name, proxy.Type)
+			continue
+		}
+		if proxy.TLS {
+			result.unsupported("proxy %s: %s over tls is not supported", proxy.Name, proxy.Type)
+			continue
+		}
+		imported, err := proxyOfHost(joinHostPort(proxy.Server, proxy.Port), proxy.Name)
+		if err != nil {
+			result.unsupported("proxy %s: %v", proxy.Name, err)
+			continue
+		}
+		imported.ProtoType = proto
+		imported.UserName = proxy.UserName
+		imported.Password = proxy.Password
+		result.Proxies = append(result.Proxies, ImportedProxy{Proto: proto, Proxy: imported})
+	}
+	if len(cfg.ProxyGroups) != 0 {
+		result.unsupported("proxy-groups: %d groups are ignored, scope uses the proxy it starts with", len(cfg.ProxyGroups))
+	}
+	if !withRules {
+		return result, nil
+	}
+	var proxied int
+	for _, line := range cfg.Rules {
+		if convertClashRule(result, line) {
+			proxied++
+		}
+	}
+	if proxied != 0 {
+		result.unsupported("rules: %d rules to proxies are ignored, traffic not bypassed is proxied", proxied)
+	}
+	return result, nil
+}
+
+// DOMAIN-SUFFIX,cn,DIRECT, return true if rule routes traffic to proxy
+func convertClashRule(result *ImportResult, line string) bool {
+	fields := strings.Split(line, ",")
+	for index := range fields {
+		fields[index] = strings.TrimSpace(fields[index])
+	}
+	typ := strings.ToUpper(fields[0])
+	if typ == "MATCH" || typ == "FINAL" {
+		if len(fields) > 1 && strings.ToUpper(fields[1]) == clashDirect {
+			result.unsupported("rule %s: traffic is direct by default, scope proxies all traffic not bypassed", line)
+		}
+		return false
+	}
+	if len(fields) < 3 {
+		result.unsupported("rule %s: format is invalid", line)
+		return false
+	}
+	value, target := fields[1], strings.ToUpper(fields[2])
+	if target == "REJECT" || target == "REJECT-DROP" {
+		result.unsupported("rule %s: reject is not supported", line)
+		return false
+	}
+	if target != clashDirect {
+		return true
+	}
+	switch typ {
+	case "DOMAIN-SUFFIX":
+		result.bypass(line, value)
+	case "DOMAIN":
+		// bypass domain also matches sub domains
+		result.bypass(line, value)
+	case "IP-CIDR", "IP-CIDR6":
+		result.bypass(line, value)
+	case "GEOIP":
+		if strings.EqualFold(value, "LAN") {
+			result.bypass(line, privateCIDRs...)
+			break
+		}
+		result.bypass(line, "geoip:"+strings.ToUpper(value))
+	case "DST-PORT":
+		result.bypass(line, "port:"+value)
+	default:
+		result.unsupported("rule %s: type %s is not supported", line, typ)
+	}
+	return false
+}
+
+// host may be ipv6 with or without brackets
+func joinHostPort(host string, port int) string {
+	return net.JoinHostPort(strings.Trim(host, "[]"), strconv.Itoa(port))
+}
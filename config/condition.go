@@ -0,0 +1,150 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+/*
+	conditions are checked in order, profile of the first matched one is activated,
+	all fields set should match, any item of list matches
+	auto-profiles:
+	  - {profile: office, ssids: [corp-wifi], connections: [Wired connection 1]}
+	  - {profile: office, vpn: true}
+	  - {profile: home, time: "18:00-09:00", weekdays: [sat, sun]}
+*/
+
+// condition to activate profile, empty field matches any
+type ProfileCondition struct {
+	Profile     string   `yaml:"profile"`
+	SSIDs       []string `yaml:"ssids"`       // ssid of connected wifi
+	Connections []string `yaml:"connections"` // id of active connection of NetworkManager, like ethernet connection
+	Time        string   `yaml:"time"`        // local time window like 09:00-18:00, crosses midnight if begin is later than end
+	Weekdays    []string `yaml:"weekdays"`    // mon tue wed thu fri sat sun
+	VPN         *bool    `yaml:"vpn"`         // vpn is active or not
+}
+
+// network state conditions are checked against
+type NetworkState struct {
+	SSIDs       []string
+	Connections []string
+	VPN         bool
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// profile of first condition matched, empty if none matches
+func (p *ProxyConfig) MatchProfile(state NetworkState, now time.Time) string {
+	for _, cond := range p.AutoProfiles {
+		if cond.Match(state, now) {
+			return cond.Profile
+		}
+	}
+	return ""
+}
+
+// check if condition matches state and time
+func (cond *ProfileCondition) Match(state NetworkState, now time.Time) bool {
+	if len(cond.SSIDs) != 0 && !anyIn(cond.SSIDs, state.SSIDs) {
+		return false
+	}
+	if len(cond.Connections) != 0 && !anyIn(cond.Connections, state.Connections) {
+		return false
+	}
+	if cond.VPN != nil && *cond.VPN != state.VPN {
+		return false
+	}
+	if len(cond.Weekdays) != 0 {
+		var matched bool
+		for _, day := range cond.Weekdays {
+			if weekdays[strings.ToLower(day)] == now.Weekday() {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if cond.Time != "" {
+		begin, end, err := parseTimeWindow(cond.Time)
+		if err != nil {
+			return false
+		}
+		minute := now.Hour()*60 + now.Minute()
+		if begin <= end {
+			return minute >= begin && minute < end
+		}
+		return minute >= begin || minute < end
+	}
+	return true
+}
+
+// 09:00-18:00 to minutes of day
+func parseTimeWindow(window string) (int, int, error) {
+	beginStr, endStr, ok := strings.Cut(window, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("time window should be like 09:00-18:00, got %q", window)
+	}
+	begin, err := parseClock(strings.TrimSpace(beginStr))
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := parseClock(strings.TrimSpace(endStr))
+	if err != nil {
+		return 0, 0, err
+	}
+	return begin, end, nil
+}
+
+func parseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, fmt.Errorf("time should be like 09:00, got %q", clock)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func anyIn(want []string, have []string) bool {
+	for _, elem := range want {
+		for _, other := range have {
+			if elem == other {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// check conditions of auto profiles
+func (p *ProxyConfig) validateConditions(v *validator) {
+	for index, cond := range p.AutoProfiles {
+		path := fmt.Sprintf("auto-profiles[%d]", index)
+		if _, ok := p.Profiles[cond.Profile]; !ok {
+			v.add(path+".profile", "profile %s not found", cond.Profile)
+		}
+		if cond.Time != "" {
+			if _, _, err := parseTimeWindow(cond.Time); err != nil {
+				v.add(path+".time", "%v", err)
+			}
+		}
+		for dayIndex, day := range cond.Weekdays {
+			if _, ok := weekdays[strings.ToLower(day)]; !ok {
+				v.add(fmt.Sprintf("%s.weekdays[%d]", path, dayIndex), "should be mon to sun, got %q", day)
+			}
+		}
+	}
+}
@@ -0,0 +1,51 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProxyConfig_MatchProfile(t *testing.T) {
+	vpn := true
+	cfg := NewProxyCfg()
+	cfg.Profiles = map[string]Profile{"office": {}, "home": {}, "night": {}}
+	cfg.AutoProfiles = []ProfileCondition{
+		{Profile: "office", SSIDs: []string{"corp"}, Weekdays: []string{"Mon", "tue", "wed", "thu", "fri"}},
+		{Profile: "office", VPN: &vpn},
+		{Profile: "night", Time: "22:00-06:00"},
+		{Profile: "home", Connections: []string{"Wired connection 1"}},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	// 2022-06-01 is wednesday
+	noon := time.Date(2022, 6, 1, 12, 0, 0, 0, time.Local)
+	midnight := time.Date(2022, 6, 1, 23, 30, 0, 0, time.Local)
+	saturday := time.Date(2022, 6, 4, 12, 0, 0, 0, time.Local)
+	cases := []struct {
+		state NetworkState
+		now   time.Time
+		want  string
+	}{
+		{NetworkState{SSIDs: []string{"corp"}}, noon, "office"},
+		{NetworkState{SSIDs: []string{"corp"}}, saturday, ""},
+		{NetworkState{SSIDs: []string{"cafe"}, VPN: true}, saturday, "office"},
+		{NetworkState{SSIDs: []string{"cafe"}}, midnight, "night"},
+		{NetworkState{Connections: []string{"Wired connection 1"}}, noon, "home"},
+		{NetworkState{}, noon, ""},
+	}
+	for _, c := range cases {
+		if got := cfg.MatchProfile(c.state, c.now); got != c.want {
+			t.Errorf("match %+v at %v got %q, want %q", c.state, c.now, got, c.want)
+		}
+	}
+
+	cfg.AutoProfiles = []ProfileCondition{{Profile: "travel", Time: "25:00-06:00", Weekdays: []string{"someday"}}}
+	if errs := FieldErrors(cfg.Validate()); len(errs) != 3 {
+		t.Errorf("validate conditions got %v", errs)
+	}
+}
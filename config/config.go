@@ -105,6 +105,42 @@ type Proxy struct {
 	// auth message
 	UserName string `yaml:"username"`
 	Password string `yaml:"password"`
+	// id of password in keyring of user, password is fetched when proxy starts
+	SecretID string `yaml:"secret-id,omitempty"`
+
+	// retry policy when create tunnel failed
+	Retry RetryPolicy `yaml:"retry,omitempty"`
+
+	// options of sockets connect to proxy server
+	Dial DialPolicy `yaml:"dial,omitempty"`
+}
+
+// dial policy, zero value field is not set
+type DialPolicy struct {
+	Device string `yaml:"device"` // uplink interface socket is bound to, such as eth0
+	Mark   uint32 `yaml:"mark"`   // fwmark of socket, traffic with mark returns from scope chain, should differ from mark of scope, self mark of daemon if 0
+	MSS    int    `yaml:"mss"`    // max segment size of tcp to proxy server, syn of it is clamped too, fixes pmtu blackhole of tunnel, not clamped if 0
+	// keepalive of long-lived tunnel, in seconds, default of system is used if all are 0
+	KeepAliveIdle     int `yaml:"keepalive-idle"`     // idle time before first probe
+	KeepAliveInterval int `yaml:"keepalive-interval"` // time between probes
+	KeepAliveCount    int `yaml:"keepalive-count"`    // probes lost before connection is dropped
+	// nagle is disabled by default, enable it to merge small writes
+	Nagle bool `yaml:"nagle"`
+	// send first write of tunnel in syn, needs tcp_fastopen of kernel
+	FastOpen bool `yaml:"fast-open"`
+	// socket buffer size in bytes, default of system if 0
+	SndBuf int `yaml:"sndbuf"`
+	RcvBuf int `yaml:"rcvbuf"`
+	// ip of server pinned, server is not resolved if set, addresses are raced as resolved ones
+	Addrs []string `yaml:"addrs,omitempty"`
+}
+
+// retry policy, never retry if attempts is not set, other fields not set use default value
+type RetryPolicy struct {
+	Attempts   *int     `yaml:"attempts"`    // max attempts include the first one, 0 or 1 means never retry
+	Backoff    *int     `yaml:"backoff"`     // backoff before first retry in millisecond, doubled every retry
+	MaxBackoff *int     `yaml:"max-backoff"` // max backoff in millisecond
+	Jitter     *float64 `yaml:"jitter"`      // random factor of backoff, range [0,1], 0 means off
 }
 
 // scope proxy
@@ -115,11 +151,75 @@ type ScopeProxies struct {
 	NoProxyProgram []string `yaml:"no-proxy-program"` // app proxy will ignore
 
 	// white list
-	WhiteList []string `yaml:"whitelist"` // white site dont use proxy, not use this time
+	WhiteList []string `yaml:"whitelist"` // destination dont use proxy, support cidr, domain suffix, port:begin-end and geoip:CN
+	GeoIPDB   string   `yaml:"geoip-db"`  // maxmind format geoip database, used by geoip rule
 	TPort     int      `yaml:"t-port"`
 	DNSPort   int      `yaml:"dns-port"`
 
-	UseFakeIP bool `yaml:"use-fake-ip"`
+	UseFakeIP   bool   `yaml:"use-fake-ip"`
+	FakeIPRange string `yaml:"fake-ip-range"` // cidr of fake ip pool, default 198.18.0.0/16
+	// resolve dns query of proxy app by remote dns server through proxy, in case dns leak
+	ProxyDNS  bool   `yaml:"proxy-dns"`
+	RemoteDNS string `yaml:"remote-dns"` // remote dns server, default 8.8.8.8:53
+	// upstream of dns query which is not proxied, support https:// tls:// udp://
+	DNSUpstreams []string `yaml:"dns-upstreams"`
+	// sniff tls sni and http host, send domain instead of ip to proxy server
+	SniffDomain bool `yaml:"sniff-domain"`
+	// drop traffic of proxy app when proxy server is unreachable, in case traffic leak without proxy
+	KillSwitch bool `yaml:"kill-switch"`
+	// seconds to wait for established tunnels when stop proxy, 0 means close them at once
+	DrainTimeout int `yaml:"drain-timeout"`
+	// save mark to conntrack, so that related flows like ftp data and icmp error follow proxy path
+	ConnMark bool `yaml:"conn-mark"`
+	// only proxy traffic leaving these interfaces, empty means all interfaces
+	Interfaces []string `yaml:"interfaces"`
+	// traffic leaving these interfaces is not proxied
+	ExcludeInterfaces []string `yaml:"exclude-interfaces"`
+	// match procs by cmdline, user and unit, for apps share the same exe like python apps
+	MatchSpecs []MatchSpec `yaml:"match-specs"`
+	// tcp of app uses its own proxy instead of the one scope starts with, map[exe path or app id]proto/name,
+	// udp and dns of app still use proxy of scope
+	AppProxies map[string]string `yaml:"app-proxies,omitempty"`
+	// url or path of proxy auto-config file, proxy of connection is chosen by pac, proxy of scope is used if pac fails
+	PAC string `yaml:"pac"`
+	// discover pac by dhcp and dns when pac is empty, pac is discovered again when network changes
+	WPAD bool `yaml:"wpad"`
+	// write one line per tunneled connection to audit log
+	Audit bool `yaml:"audit"`
+	// how tcp chooses proxy, empty means proxy scope starts with,
+	// least-latency chooses proxy of the same proto with least handshake latency
+	Strategy string `yaml:"strategy"`
+	// proxy apps in other network namespaces like containers, their traffic is redirected when it enters host,
+	// all tcp of one namespace is proxied if any proc of scope runs in it
+	NetNS bool `yaml:"netns"`
+	// transparent listeners of ipv4 and ipv6 feed the same handlers, both families are listened by default,
+	// family disabled is neither listened nor intercepted, so its traffic connects directly
+	DisableIPv4 bool `yaml:"disable-ipv4"`
+	DisableIPv6 bool `yaml:"disable-ipv6"`
+	// max tunnels of scope at the same time, 0 means unlimited
+	MaxTunnels int `yaml:"max-tunnels"`
+	// what to do with connection when tunnels are full, queue waits for free tunnel and stops accepting meanwhile,
+	// reject closes connection, direct connects without proxy, empty means queue
+	Overflow string `yaml:"overflow"`
+}
+
+// strategy to choose proxy of least handshake latency
+const StrategyLeastLatency = "least-latency"
+
+// policies when tunnels are full
+const (
+	OverflowQueue  = "queue"
+	OverflowReject = "reject"
+	OverflowDirect = "direct"
+)
+
+// spec to match proc, empty field matches any, all fields set should match
+type MatchSpec struct {
+	Exec    string `yaml:"exec"`    // exe path, like /usr/bin/python3
+	Cmdline string `yaml:"cmdline"` // regexp of cmdline joined by space
+	User    string `yaml:"user"`    // user name or uid of proc
+	Unit    string `yaml:"unit"`    // systemd unit or container id in cgroup path
+	App     string `yaml:"app"`     // flatpak app id or snap name, like org.mozilla.firefox
 }
 
 func (p *ScopeProxies) GetProxy(proto string, name string) (Proxy, error) {
@@ -178,6 +278,44 @@ func (p *ScopeProxies) SetProxy(proto string, name string, proxy Proxy) {
 // proxy config
 type ProxyConfig struct {
 	AllProxies map[string]ScopeProxies `yaml:"all-proxies"` // map[global,app]ScopeProxies
+	// prefix of iptables chains, avoid collision with chains of other services
+	ChainPrefix string `yaml:"chain-prefix"`
+	// how traffic is intercepted, iptables or bpf, empty means iptables
+	InterceptBackend string `yaml:"intercept-backend"`
+	// named setups like office and home, profile is copied to all proxies when activated
+	Profiles      map[string]Profile `yaml:"profiles,omitempty"`
+	ActiveProfile string             `yaml:"active-profile,omitempty"`
+	// profile of first matched condition is activated automatically
+	AutoProfiles []ProfileCondition `yaml:"auto-profiles,omitempty"`
+	// programs never proxied by any scope, connections return before chains of app and global proxy
+	DirectProgram []string `yaml:"direct-program"`
+	// publish proxy of active profile to gsettings and environment of user sessions
+	SystemProxy bool `yaml:"system-proxy"`
+	// count traffic through proxy by app and proxy, app of each connection is looked up by socket
+	Stats bool `yaml:"stats"`
+	// loopback addr serves prometheus metrics at /metrics, like 127.0.0.1:9464, empty means disabled
+	MetricsListen string `yaml:"metrics-listen"`
+	// where and how audit log of scopes is rotated
+	AuditLog AuditLog `yaml:"audit-log"`
+	// max tunnels of all scopes at the same time, 0 means unlimited, overflow policy of scope applies
+	MaxTunnels int `yaml:"max-tunnels"`
+	// soft limit of open files daemon raises to at start, 0 means 65536
+	MaxOpenFiles int `yaml:"max-open-files"`
+	// how data of tcp tunnels is copied, goroutine per direction or one epoll thread for all, empty means goroutine
+	RelayEngine string `yaml:"relay-engine"`
+}
+
+// engines of relay
+const (
+	RelayGoroutine = "goroutine"
+	RelayEpoll     = "epoll"
+)
+
+// rotation of audit log
+type AuditLog struct {
+	Dir     string `yaml:"dir"`      // empty means /var/log/deepin-proxy
+	MaxSize int    `yaml:"max-size"` // megabytes of file before rotated, 0 means 10
+	MaxAge  int    `yaml:"max-age"`  // days rotated files are kept, 0 means 30
 }
 
 // create new
@@ -0,0 +1,95 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Config
+
+import (
+	"sort"
+
+	define "github.com/linuxdeepin/deepin-network-proxy/define"
+)
+
+// proc is in cgroup of only one scope, exe listed by several scopes is a conflict.
+// precedence policy: scope with higher priority wins, that is Direct before App before Global,
+// exe in direct-program is never proxied, exe in proxy-program of app proxy is proxied
+// even it is in no-proxy-program of global proxy, the same order controllers are searched by exe path.
+
+// scopes which control exe, in order of precedence
+var conflictScopes = []define.Scope{define.Direct, define.App, define.Global}
+
+// exe listed in several scopes
+type Conflict struct {
+	Exe    string
+	Scopes []string // scopes list exe, in order of precedence
+	Winner string   // scope exe is controlled by
+}
+
+// programs controlled by cgroup of scope, app proxy controls proxy-program, global proxy controls no-proxy-program
+func (p *ScopeProxies) ControlPrograms(scope define.Scope) []string {
+	switch scope {
+	case define.App:
+		return p.ProxyProgram
+	case define.Global:
+		return p.NoProxyProgram
+	default:
+		return nil
+	}
+}
+
+// programs controlled by cgroup of scope in config, direct scope has no proxies
+func (p *ProxyConfig) controlPrograms(scope define.Scope) []string {
+	if scope == define.Direct {
+		return p.DirectProgram
+	}
+	proxies, ok := p.AllProxies[scope.String()]
+	if !ok {
+		return nil
+	}
+	return proxies.ControlPrograms(scope)
+}
+
+// scopes list exe, in order of precedence
+func (p *ProxyConfig) ScopesOf(exe string) []string {
+	var scopes []string
+	for _, scope := range conflictScopes {
+		for _, program := range p.controlPrograms(scope) {
+			if program == exe {
+				scopes = append(scopes, scope.String())
+				break
+			}
+		}
+	}
+	return scopes
+}
+
+// scope which controls exe, empty if exe is not listed
+func (p *ProxyConfig) WinnerScope(exe string) string {
+	scopes := p.ScopesOf(exe)
+	if len(scopes) == 0 {
+		return ""
+	}
+	return scopes[0]
+}
+
+// all conflicts, sorted by exe
+func (p *ProxyConfig) Conflicts() []Conflict {
+	exes := make(map[string]bool)
+	for _, scope := range conflictScopes {
+		for _, program := range p.controlPrograms(scope) {
+			exes[program] = true
+		}
+	}
+	var conflicts []Conflict
+	for exe := range exes {
+		scopes := p.ScopesOf(exe)
+		if len(scopes) < 2 {
+			continue
+		}
+		conflicts = append(conflicts, Conflict{Exe: exe, Scopes: scopes, Winner: scopes[0]})
+	}
+	sort.Slice(conflicts, func(i, j int) bool {
+		return conflicts[i].Exe < conflicts[j].Exe
+	})
+	return conflicts
+}
@@ -0,0 +1,48 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProxyConfig_Conflicts(t *testing.T) {
+	cfg := NewProxyCfg()
+	cfg.AllProxies["App"] = ScopeProxies{
+		ProxyProgram:   []string{"/usr/bin/apt", "/usr/bin/curl"},
+		NoProxyProgram: []string{"/usr/bin/wget"},
+	}
+	cfg.AllProxies["Global"] = ScopeProxies{
+		ProxyProgram:   []string{"/usr/bin/wget"},
+		NoProxyProgram: []string{"/usr/bin/curl", "/usr/bin/ssh"},
+	}
+	expect := []Conflict{{Exe: "/usr/bin/curl", Scopes: []string{"App", "Global"}, Winner: "App"}}
+	if conflicts := cfg.Conflicts(); !reflect.DeepEqual(conflicts, expect) {
+		t.Errorf("conflicts wrong, conflicts: %v", conflicts)
+	}
+
+	// direct program is never proxied
+	cfg.DirectProgram = []string{"/usr/bin/apt", "/usr/bin/bank"}
+	expect = []Conflict{
+		{Exe: "/usr/bin/apt", Scopes: []string{"Direct", "App"}, Winner: "Direct"},
+		{Exe: "/usr/bin/curl", Scopes: []string{"App", "Global"}, Winner: "App"},
+	}
+	if conflicts := cfg.Conflicts(); !reflect.DeepEqual(conflicts, expect) {
+		t.Errorf("conflicts wrong, conflicts: %v", conflicts)
+	}
+	cases := map[string]string{
+		"/usr/bin/curl": "App",
+		"/usr/bin/apt":  "Direct",
+		"/usr/bin/bank": "Direct",
+		"/usr/bin/ssh":  "Global",
+		"/usr/bin/wget": "",
+	}
+	for exe, scope := range cases {
+		if winner := cfg.WinnerScope(exe); winner != scope {
+			t.Errorf("winner of %s should be %q, winner: %q", exe, scope, winner)
+		}
+	}
+}
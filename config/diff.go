@@ -0,0 +1,159 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Config
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	define "github.com/linuxdeepin/deepin-network-proxy/define"
+)
+
+// config changed on disk is compared with config in use, and only changed parts are applied,
+// fields not listed in diff are read when proxy starts, and take effect after proxy restarts.
+
+// changes of scope proxies
+type ScopeDiff struct {
+	Proxies         []string // proto/name of proxies added, removed or modified
+	AddedPrograms   []string // programs controlled by cgroup of scope
+	RemovedPrograms []string
+	Bypass          bool     // whitelist or geoip db
+	Interfaces      bool     // interfaces or exclude interfaces
+	MatchSpecs      bool     // specs to match proc
+	AppProxies      bool     // proxies assigned to apps
+	PAC             bool     // proxy auto-config file or wpad
+	Restart         []string // yaml name of other fields changed
+}
+
+// fields applied incrementally, the others need restart
+var liveFields = map[string]bool{
+	"Proxies":           true,
+	"ProxyProgram":      true,
+	"NoProxyProgram":    true,
+	"WhiteList":         true,
+	"GeoIPDB":           true,
+	"Interfaces":        true,
+	"ExcludeInterfaces": true,
+	"MatchSpecs":        true,
+	"AppProxies":        true,
+	"PAC":               true,
+	"WPAD":              true,
+	"Strategy":          true,
+	// read when tunnel is created or proxy stops
+	"SniffDomain":  true,
+	"DrainTimeout": true,
+	"Audit":        true,
+	"MaxTunnels":   true,
+	"Overflow":     true,
+	// namespaces are synced periodically
+	"NetNS": true,
+}
+
+// check if nothing changed
+func (d ScopeDiff) Empty() bool {
+	return len(d.Proxies) == 0 && len(d.AddedPrograms) == 0 && len(d.RemovedPrograms) == 0 &&
+		!d.Bypass && !d.Interfaces && !d.MatchSpecs && !d.AppProxies && !d.PAC && len(d.Restart) == 0
+}
+
+// key of proxy in diff
+func ProxyKey(proto string, name string) string {
+	return proto + "/" + name
+}
+
+// compare scope proxies in use with new one
+func DiffScope(scope define.Scope, old ScopeProxies, cur ScopeProxies) ScopeDiff {
+	var diff ScopeDiff
+	diff.Proxies = diffProxies(old.Proxies, cur.Proxies)
+	diff.AddedPrograms, diff.RemovedPrograms = diffStrings(old.ControlPrograms(scope), cur.ControlPrograms(scope))
+	diff.Bypass = !equalStrings(old.WhiteList, cur.WhiteList) || old.GeoIPDB != cur.GeoIPDB
+	diff.Interfaces = !equalStrings(old.Interfaces, cur.Interfaces) || !equalStrings(old.ExcludeInterfaces, cur.ExcludeInterfaces)
+	diff.MatchSpecs = !reflect.DeepEqual(old.MatchSpecs, cur.MatchSpecs) && (len(old.MatchSpecs) != 0 || len(cur.MatchSpecs) != 0)
+	diff.AppProxies = !reflect.DeepEqual(old.AppProxies, cur.AppProxies) && (len(old.AppProxies) != 0 || len(cur.AppProxies) != 0)
+	diff.PAC = old.PAC != cur.PAC || old.WPAD != cur.WPAD
+	oldValue := reflect.ValueOf(old)
+	curValue := reflect.ValueOf(cur)
+	typ := oldValue.Type()
+	for index := 0; index < typ.NumField(); index++ {
+		field := typ.Field(index)
+		if liveFields[field.Name] {
+			continue
+		}
+		if !reflect.DeepEqual(oldValue.Field(index).Interface(), curValue.Field(index).Interface()) {
+			diff.Restart = append(diff.Restart, strings.Split(field.Tag.Get("yaml"), ",")[0])
+		}
+	}
+	return diff
+}
+
+// proxies added, removed or modified, sorted
+func diffProxies(old map[string][]Proxy, cur map[string][]Proxy) []string {
+	index := func(all map[string][]Proxy) map[string]Proxy {
+		result := make(map[string]Proxy)
+		for proto, proxies := range all {
+			for _, proxy := range proxies {
+				result[ProxyKey(proto, proxy.Name)] = proxy
+			}
+		}
+		return result
+	}
+	oldIndex := index(old)
+	curIndex := index(cur)
+	var keys []string
+	for key, proxy := range curIndex {
+		if exist, ok := oldIndex[key]; !ok || !reflect.DeepEqual(exist, proxy) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range oldIndex {
+		if _, ok := curIndex[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// programs added to and removed from list
+func DiffPrograms(old []string, cur []string) ([]string, []string) {
+	return diffStrings(old, cur)
+}
+
+// elems added and removed, order is kept
+func diffStrings(old []string, cur []string) ([]string, []string) {
+	oldSet := make(map[string]bool)
+	for _, elem := range old {
+		oldSet[elem] = true
+	}
+	curSet := make(map[string]bool)
+	for _, elem := range cur {
+		curSet[elem] = true
+	}
+	var added, removed []string
+	for _, elem := range cur {
+		if !oldSet[elem] {
+			added = append(added, elem)
+		}
+	}
+	for _, elem := range old {
+		if !curSet[elem] {
+			removed = append(removed, elem)
+		}
+	}
+	return added, removed
+}
+
+// nil and empty slice are the same
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for index := range a {
+		if a[index] != b[index] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,53 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Config
+
+import (
+	"reflect"
+	"testing"
+
+	define "github.com/linuxdeepin/deepin-network-proxy/define"
+)
+
+func TestDiffScope(t *testing.T) {
+	old := ScopeProxies{
+		Proxies: map[string][]Proxy{
+			"http":  {{Name: "a", Server: "1.1.1.1", Port: 80}, {Name: "b", Server: "2.2.2.2", Port: 80}},
+			"sock5": {{Name: "c", Server: "3.3.3.3", Port: 1080}},
+		},
+		ProxyProgram: []string{"/usr/bin/curl", "/usr/bin/wget"},
+		WhiteList:    []string{"10.0.0.0/8"},
+		TPort:        8090,
+	}
+	cur := ScopeProxies{
+		Proxies: map[string][]Proxy{
+			"http":  {{Name: "a", Server: "1.1.1.1", Port: 8080}, {Name: "b", Server: "2.2.2.2", Port: 80}},
+			"sock5": {{Name: "d", Server: "4.4.4.4", Port: 1080}},
+		},
+		ProxyProgram: []string{"/usr/bin/wget", "/usr/bin/apt"},
+		WhiteList:    []string{"10.0.0.0/8"},
+		TPort:        8091,
+		SniffDomain:  true,
+	}
+	diff := DiffScope(define.App, old, cur)
+	want := ScopeDiff{
+		Proxies:         []string{"http/a", "sock5/c", "sock5/d"},
+		AddedPrograms:   []string{"/usr/bin/apt"},
+		RemovedPrograms: []string{"/usr/bin/curl"},
+		Restart:         []string{"t-port"},
+	}
+	if !reflect.DeepEqual(diff, want) {
+		t.Errorf("diff got %+v", diff)
+	}
+	// global controls no proxy program
+	if diff = DiffScope(define.Global, old, cur); len(diff.AddedPrograms) != 0 {
+		t.Errorf("global diff got %+v", diff)
+	}
+	// nil and empty are the same
+	old.Interfaces = []string{}
+	if diff = DiffScope(define.App, old, old); !diff.Empty() {
+		t.Errorf("same config diff got %+v", diff)
+	}
+}
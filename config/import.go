@@ -0,0 +1,78 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Config
+
+import (
+	"fmt"
+	"strings"
+
+	rule "github.com/linuxdeepin/deepin-network-proxy/rule"
+)
+
+// proxies and rules of other clients are converted to one scope,
+// only rules sending traffic direct become bypass rules, traffic not bypassed is proxied by scope,
+// features can not be expressed by scope are reported instead of dropped silently.
+
+// formats of other clients
+const (
+	FormatClash = "clash"
+	FormatV2Ray = "v2ray"
+)
+
+// private networks of geoip:private and no-resolve private rules
+var privateCIDRs = []string{
+	"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "127.0.0.0/8", "169.254.0.0/16", "fc00::/7", "fe80::/10",
+}
+
+// result of converting config of other client
+type ImportResult struct {
+	Proxies     []ImportedProxy
+	Bypass      []string // bypass rules converted from direct rules
+	Unsupported []string // features can not be converted
+}
+
+func (r *ImportResult) unsupported(format string, args ...interface{}) {
+	r.Unsupported = append(r.Unsupported, fmt.Sprintf(format, args...))
+}
+
+// add bypass rule, invalid rule is reported
+func (r *ImportResult) bypass(origin string, rules ...string) {
+	for _, bypass := range rules {
+		if err := rule.CheckRule(bypass); err != nil {
+			r.unsupported("rule %s: %v", origin, err)
+			continue
+		}
+		r.Bypass = append(r.Bypass, bypass)
+	}
+}
+
+// convert config of format, rules are converted only if withRules
+func ImportClientConfig(format string, data []byte, withRules bool) (*ImportResult, error) {
+	switch strings.ToLower(format) {
+	case FormatClash:
+		return ImportClash(data, withRules)
+	case FormatV2Ray:
+		return ImportV2Ray(data, withRules)
+	default:
+		return nil, fmt.Errorf("config format %q is not supported, should be clash or v2ray", format)
+	}
+}
+
+// add proxies and bypass rules to scope, bypass rules already listed are skipped, keys of proxies are returned
+func (p *ScopeProxies) ImportResult(result *ImportResult) []string {
+	keys := p.ImportProxies(result.Proxies)
+	listed := make(map[string]bool)
+	for _, bypass := range p.WhiteList {
+		listed[bypass] = true
+	}
+	for _, bypass := range result.Bypass {
+		if listed[bypass] {
+			continue
+		}
+		listed[bypass] = true
+		p.WhiteList = append(p.WhiteList, bypass)
+	}
+	return keys
+}
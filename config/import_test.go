@@ -0,0 +1,101 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestImportClash(t *testing.T) {
+	data := `
+proxies:
+  - {name: office, type: http, server: 10.0.0.1, port: 8080, username: uos, password: pass}
+  - {name: home, type: socks5, server: "::1", port: 1080}
+  - {name: vm, type: vmess, server: 10.0.0.3, port: 443}
+proxy-groups:
+  - {name: Proxy, type: select, proxies: [office, home]}
+rules:
+  - DOMAIN-SUFFIX,cn,DIRECT
+  - DOMAIN-KEYWORD,google,DIRECT
+  - IP-CIDR,10.0.0.0/8,DIRECT,no-resolve
+  - GEOIP,cn,DIRECT
+  - DST-PORT,22,DIRECT
+  - DOMAIN,ads.example.com,REJECT
+  - DOMAIN-SUFFIX,google.com,Proxy
+  - MATCH,Proxy
+`
+	result, err := ImportClash([]byte(data), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ImportedProxy{
+		{"http", Proxy{ProtoType: "http", Name: "office", Server: "10.0.0.1", Port: 8080, UserName: "uos", Password: "pass"}},
+		{"socks5", Proxy{ProtoType: "socks5", Name: "home", Server: "::1", Port: 1080}},
+	}
+	if !reflect.DeepEqual(result.Proxies, want) {
+		t.Errorf("proxies got %+v", result.Proxies)
+	}
+	if !reflect.DeepEqual(result.Bypass, []string{"cn", "10.0.0.0/8", "geoip:CN", "port:22"}) {
+		t.Errorf("bypass got %v", result.Bypass)
+	}
+	// vmess, proxy groups, keyword, reject and rules to proxies
+	if len(result.Unsupported) != 5 {
+		t.Errorf("unsupported got %v", result.Unsupported)
+	}
+
+	result, err = ImportClash([]byte(data), false)
+	if err != nil || len(result.Bypass) != 0 || len(result.Unsupported) != 2 {
+		t.Errorf("import without rules got %+v, err: %v", result, err)
+	}
+}
+
+func TestImportV2Ray(t *testing.T) {
+	data := `{
+  "outbounds": [
+    {"tag": "office", "protocol": "http", "settings": {"servers": [{"address": "10.0.0.1", "port": 8080, "users": [{"user": "uos", "pass": "pass"}]}]}},
+    {"tag": "s", "protocol": "socks", "settings": {"servers": [{"address": "10.0.0.2", "port": 1080}, {"address": "10.0.0.3", "port": 1080}]}},
+    {"tag": "vl", "protocol": "vless"},
+    {"tag": "direct", "protocol": "freedom"}
+  ],
+  "routing": {"rules": [
+    {"type": "field", "domain": ["domain:cn", "full:www.example.com", "geosite:cn"], "outboundTag": "direct"},
+    {"type": "field", "ip": ["geoip:private", "geoip:cn"], "outboundTag": "direct"},
+    {"type": "field", "port": "22,8000-9000", "outboundTag": "direct"},
+    {"type": "field", "port": 53, "network": "udp", "outboundTag": "direct"},
+    {"type": "field", "domain": ["domain:google.com"], "outboundTag": "office"}
+  ]}
+}`
+	result, err := ImportV2Ray([]byte(data), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, imported := range result.Proxies {
+		keys = append(keys, ProxyKey(imported.Proto, imported.Proxy.Name))
+	}
+	if !reflect.DeepEqual(keys, []string{"http/office", "socks5/s-0", "socks5/s-1"}) || result.Proxies[0].Proxy.Password != "pass" {
+		t.Errorf("proxies got %+v", result.Proxies)
+	}
+	want := append([]string{"cn", "www.example.com"}, privateCIDRs...)
+	want = append(want, "geoip:CN", "port:22", "port:8000-9000")
+	if !reflect.DeepEqual(result.Bypass, want) {
+		t.Errorf("bypass got %v", result.Bypass)
+	}
+	// vless, geosite, combined conditions and rules to proxies
+	if len(result.Unsupported) != 4 {
+		t.Errorf("unsupported got %v", result.Unsupported)
+	}
+
+	var scope ScopeProxies
+	scope.WhiteList = []string{"cn"}
+	scope.ImportResult(result)
+	if len(scope.WhiteList) != len(want) {
+		t.Errorf("whitelist got %v", scope.WhiteList)
+	}
+	if _, err = ImportClientConfig("surge", []byte(data), true); err == nil {
+		t.Error("unknown format should fail")
+	}
+}
@@ -0,0 +1,103 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Config
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// config is searched in order, the first existing one wins:
+//  1. $XDG_CONFIG_HOME/deepin-proxy of caller, ~/.config/deepin-proxy if XDG_CONFIG_HOME is unset
+//  2. /etc/deepin-proxy, system wide config
+//  3. /etc/deepin/deepin-proxy, where older version stores config
+// system daemon serves all users, so home and environment are those of dbus caller, not of daemon.
+
+// dir name under config home of user
+const userConfigDir = "deepin-proxy"
+
+// system wide config dirs in precedence, the last is default if none exists
+var SystemConfigDirs = []string{"/etc/deepin-proxy", "/etc/deepin/deepin-proxy"}
+
+// resolve config file of user and system
+type Locator struct {
+	name       string
+	systemDirs []string
+	// home dir of uid
+	homeDir func(uid uint32) (string, error)
+	// check if file exists
+	exist func(path string) bool
+}
+
+// create locator of config file name
+func NewLocator(name string) *Locator {
+	return &Locator{
+		name:       name,
+		systemDirs: SystemConfigDirs,
+		homeDir:    lookupHome,
+		exist:      fileExist,
+	}
+}
+
+// home dir from passwd
+func lookupHome(uid uint32) (string, error) {
+	usr, err := user.LookupId(strconv.Itoa(int(uid)))
+	if err != nil {
+		return "", err
+	}
+	return usr.HomeDir, nil
+}
+
+func fileExist(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// get value of key in environ, such as KEY=value
+func lookupEnv(env []string, key string) string {
+	for _, elem := range env {
+		if strings.HasPrefix(elem, key+"=") {
+			return elem[len(key)+1:]
+		}
+	}
+	return ""
+}
+
+// config path of user, env is environment of caller process
+func (l *Locator) UserPath(uid uint32, env []string) (string, error) {
+	// relative path is invalid and should be ignored, as xdg base dir spec says
+	base := lookupEnv(env, "XDG_CONFIG_HOME")
+	if !filepath.IsAbs(base) {
+		home, err := l.homeDir(uid)
+		if err != nil {
+			return "", err
+		}
+		base = filepath.Join(home, ".config")
+	}
+	return filepath.Join(base, userConfigDir, l.name), nil
+}
+
+// system config path, the first existing one, the last one if none exists
+func (l *Locator) SystemPath() string {
+	for _, dir := range l.systemDirs {
+		path := filepath.Join(dir, l.name)
+		if l.exist(path) {
+			return path
+		}
+	}
+	return filepath.Join(l.systemDirs[len(l.systemDirs)-1], l.name)
+}
+
+// locate config for caller, return path and if it belongs to user
+func (l *Locator) Locate(uid uint32, env []string) (string, bool) {
+	path, err := l.UserPath(uid, env)
+	if err == nil && l.exist(path) {
+		return path, true
+	}
+	return l.SystemPath(), false
+}
@@ -0,0 +1,52 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Config
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLocator_Locate(t *testing.T) {
+	files := make(map[string]bool)
+	l := &Locator{
+		name:       "proxy.yaml",
+		systemDirs: []string{"/etc/deepin-proxy", "/etc/deepin/deepin-proxy"},
+		homeDir: func(uid uint32) (string, error) {
+			if uid != 1000 {
+				return "", errors.New("unknown user")
+			}
+			return "/home/uos", nil
+		},
+		exist: func(path string) bool { return files[path] },
+	}
+	tests := []struct {
+		files []string
+		uid   uint32
+		env   []string
+		path  string
+		user  bool
+	}{
+		// nothing exists, default to old system path
+		{nil, 1000, nil, "/etc/deepin/deepin-proxy/proxy.yaml", false},
+		{[]string{"/etc/deepin-proxy/proxy.yaml", "/etc/deepin/deepin-proxy/proxy.yaml"}, 1000, nil, "/etc/deepin-proxy/proxy.yaml", false},
+		{[]string{"/home/uos/.config/deepin-proxy/proxy.yaml", "/etc/deepin-proxy/proxy.yaml"}, 1000, nil, "/home/uos/.config/deepin-proxy/proxy.yaml", true},
+		{[]string{"/xdg/deepin-proxy/proxy.yaml"}, 1000, []string{"HOME=/home/uos", "XDG_CONFIG_HOME=/xdg"}, "/xdg/deepin-proxy/proxy.yaml", true},
+		// relative xdg config home is ignored
+		{[]string{"/home/uos/.config/deepin-proxy/proxy.yaml"}, 1000, []string{"XDG_CONFIG_HOME=xdg"}, "/home/uos/.config/deepin-proxy/proxy.yaml", true},
+		// unknown user falls back to system
+		{[]string{"/etc/deepin-proxy/proxy.yaml"}, 1001, nil, "/etc/deepin-proxy/proxy.yaml", false},
+	}
+	for _, test := range tests {
+		files = make(map[string]bool)
+		for _, file := range test.files {
+			files[file] = true
+		}
+		path, user := l.Locate(test.uid, test.env)
+		if path != test.path || user != test.user {
+			t.Errorf("locate %v %v, got %s %v, want %s %v", test.files, test.env, path, user, test.path, test.user)
+		}
+	}
+}
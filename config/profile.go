@@ -0,0 +1,153 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	define "github.com/linuxdeepin/deepin-network-proxy/define"
+)
+
+/*
+	profile replaces proxies, programs and bypass rules of scopes at once,
+	other settings of scope like t-port are kept
+	profiles:
+	  office:
+	    App:
+	      proxies:
+	        http:
+	          - {name: office, server: 10.0.0.1, port: 8080}
+	      proxy-program: [/usr/bin/git]
+	      whitelist: [10.0.0.0/8]
+	      proxy: http/office
+	active-profile: office
+*/
+
+// profile, map[App,Global]ProfileScope
+type Profile map[string]ProfileScope
+
+// fields of scope replaced by profile
+type ProfileScope struct {
+	Proxies        map[string][]Proxy `yaml:"proxies"`
+	ProxyProgram   []string           `yaml:"proxy-program"`
+	NoProxyProgram []string           `yaml:"no-proxy-program"`
+	WhiteList      []string           `yaml:"whitelist"`
+	AppProxies     map[string]string  `yaml:"app-proxies,omitempty"`
+	// proto/name of proxy running scope switches to, empty keeps the one in use
+	Proxy string `yaml:"proxy,omitempty"`
+}
+
+// sorted names of profiles
+func (p *ProxyConfig) ProfileNames() []string {
+	var names []string
+	for name := range p.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// copy of config with profile applied, config itself is not changed
+func (p *ProxyConfig) WithProfile(name string) (*ProxyConfig, error) {
+	profile, ok := p.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("profile %s not found", name)
+	}
+	cfg := *p
+	cfg.AllProxies = make(map[string]ScopeProxies)
+	for scope, proxies := range p.AllProxies {
+		cfg.AllProxies[scope] = proxies
+	}
+	for scope, ps := range profile {
+		cfg.AllProxies[scope] = ps.apply(cfg.AllProxies[scope])
+	}
+	cfg.ActiveProfile = name
+	return &cfg, nil
+}
+
+// proto and name of proxy scope switches to, empty if not set
+func (p *ProxyConfig) ProfileProxy(name string, scope define.Scope) (string, string) {
+	ps, ok := p.Profiles[name][scope.String()]
+	if !ok || ps.Proxy == "" {
+		return "", ""
+	}
+	proto, proxyName, _ := strings.Cut(ps.Proxy, "/")
+	return proto, proxyName
+}
+
+// proxy of active profile published as system proxy, proxy of Global is preferred,
+// proto is empty if profile sets no proxy
+func (p *ProxyConfig) ActiveProfileProxy() (string, Proxy, []string) {
+	profile, ok := p.Profiles[p.ActiveProfile]
+	if !ok {
+		return "", Proxy{}, nil
+	}
+	for _, scope := range []define.Scope{define.Global, define.App} {
+		ps, ok := profile[scope.String()]
+		if !ok || ps.Proxy == "" {
+			continue
+		}
+		proto, name, _ := strings.Cut(ps.Proxy, "/")
+		proxies := ps.apply(ScopeProxies{})
+		proxy, err := proxies.GetProxy(proto, name)
+		if err != nil {
+			continue
+		}
+		return proto, proxy, ps.WhiteList
+	}
+	return "", Proxy{}, nil
+}
+
+// replace fields of scope, slices are copied, in case profile is changed by scope
+func (ps ProfileScope) apply(proxies ScopeProxies) ScopeProxies {
+	proxies.Proxies = make(map[string][]Proxy)
+	for proto, list := range ps.Proxies {
+		proxies.Proxies[proto] = append([]Proxy(nil), list...)
+	}
+	proxies.ProxyProgram = append([]string{}, ps.ProxyProgram...)
+	proxies.NoProxyProgram = append([]string{}, ps.NoProxyProgram...)
+	proxies.WhiteList = append([]string{}, ps.WhiteList...)
+	proxies.AppProxies = nil
+	if len(ps.AppProxies) != 0 {
+		proxies.AppProxies = make(map[string]string)
+		for app, key := range ps.AppProxies {
+			proxies.AppProxies[app] = key
+		}
+	}
+	return proxies
+}
+
+// check profiles, fields of scope are checked as scope proxies
+func (p *ProxyConfig) validateProfiles(v *validator) {
+	for name, profile := range p.Profiles {
+		path := "profiles." + name
+		if name == "" {
+			v.add("profiles", "name of profile is empty")
+		}
+		for scope, ps := range profile {
+			scopePath := path + "." + scope
+			if scope != define.App.String() && scope != define.Global.String() {
+				v.add(scopePath, "unknown scope, should be App or Global")
+				continue
+			}
+			proxies := ps.apply(ScopeProxies{})
+			proxies.validate(v, scopePath)
+			if ps.Proxy == "" {
+				continue
+			}
+			proto, proxyName, _ := strings.Cut(ps.Proxy, "/")
+			if _, err := proxies.GetProxy(proto, proxyName); err != nil {
+				v.add(scopePath+".proxy", "proxy %q is not in proxies of profile", ps.Proxy)
+			}
+		}
+	}
+	if p.ActiveProfile != "" {
+		if _, ok := p.Profiles[p.ActiveProfile]; !ok {
+			v.add("active-profile", "profile %s not found", p.ActiveProfile)
+		}
+	}
+}
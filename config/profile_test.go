@@ -0,0 +1,83 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Config
+
+import (
+	"reflect"
+	"testing"
+
+	define "github.com/linuxdeepin/deepin-network-proxy/define"
+)
+
+func TestProxyConfig_WithProfile(t *testing.T) {
+	cfg := NewProxyCfg()
+	cfg.AllProxies["App"] = ScopeProxies{
+		Proxies:      map[string][]Proxy{"http": {{Name: "home", Server: "192.168.1.1", Port: 8080}}},
+		ProxyProgram: []string{"/usr/bin/curl"},
+		WhiteList:    []string{"192.168.0.0/16"},
+		TPort:        8090,
+	}
+	cfg.Profiles = map[string]Profile{
+		"office": {"App": {
+			Proxies:      map[string][]Proxy{"http": {{Name: "office", Server: "10.0.0.1", Port: 8080}}},
+			ProxyProgram: []string{"/usr/bin/git"},
+			WhiteList:    []string{"10.0.0.0/8"},
+			Proxy:        "http/office",
+		}},
+		"home": {},
+	}
+	if names := cfg.ProfileNames(); !reflect.DeepEqual(names, []string{"home", "office"}) {
+		t.Errorf("profile names got %v", names)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	office, err := cfg.WithProfile("office")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := office.AllProxies["App"]
+	if app.TPort != 8090 || !reflect.DeepEqual(app.ProxyProgram, []string{"/usr/bin/git"}) ||
+		!reflect.DeepEqual(app.WhiteList, []string{"10.0.0.0/8"}) || len(app.Proxies["http"]) != 1 {
+		t.Errorf("apply profile got %+v", app)
+	}
+	if office.ActiveProfile != "office" || cfg.ActiveProfile != "" {
+		t.Errorf("active profile got %s, origin %s", office.ActiveProfile, cfg.ActiveProfile)
+	}
+	// origin config is not changed
+	if cfg.AllProxies["App"].ProxyProgram[0] != "/usr/bin/curl" {
+		t.Error("origin config should not be changed")
+	}
+	if proto, name := office.ProfileProxy("office", define.App); proto != "http" || name != "office" {
+		t.Errorf("profile proxy got %s/%s", proto, name)
+	}
+	if proto, _ := office.ProfileProxy("office", define.Global); proto != "" {
+		t.Errorf("global has no profile proxy, got %s", proto)
+	}
+	// app proxy is published when global sets none
+	if proto, proxy, whitelist := office.ActiveProfileProxy(); proto != "http" || proxy.Server != "10.0.0.1" || len(whitelist) != 1 {
+		t.Errorf("active profile proxy got %s %+v %v", proto, proxy, whitelist)
+	}
+	if proto, _, _ := cfg.ActiveProfileProxy(); proto != "" {
+		t.Errorf("no profile is active, got %s", proto)
+	}
+	_, err = cfg.WithProfile("travel")
+	if err == nil {
+		t.Error("not exist profile should fail")
+	}
+
+	// proxy of profile should be in proxies of profile
+	cfg.Profiles["home"] = Profile{"App": {Proxy: "http/home"}, "Local": {}}
+	cfg.ActiveProfile = "travel"
+	errs := FieldErrors(cfg.Validate())
+	paths := make(map[string]bool)
+	for _, fieldErr := range errs {
+		paths[fieldErr.Path] = true
+	}
+	if len(errs) != 3 || !paths["profiles.home.App.proxy"] || !paths["profiles.home.Local"] || !paths["active-profile"] {
+		t.Errorf("validate profiles got %v", errs)
+	}
+}
@@ -0,0 +1,46 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Config
+
+import (
+	"fmt"
+
+	define "github.com/linuxdeepin/deepin-network-proxy/define"
+)
+
+// password of proxy is kept in keyring and referenced by secret-id,
+// plaintext password in config is moved to keyring by migration.
+
+// secret id of proxy, App/sock5/sock5_1
+func SecretID(scope define.Scope, proto string, name string) string {
+	return scope.String() + "/" + ProxyKey(proto, name)
+}
+
+// move plaintext passwords of scope to keyring, passwords are cleared and secret ids are set,
+// proxy failed to store keeps password, count of proxies migrated is returned
+func (p *ScopeProxies) MigratePasswords(scope define.Scope, store func(id string, label string, password string) error) (int, error) {
+	var count int
+	var firstErr error
+	for proto, proxies := range p.Proxies {
+		for index, proxy := range proxies {
+			if proxy.Password == "" || proxy.SecretID != "" {
+				continue
+			}
+			id := SecretID(scope, proto, proxy.Name)
+			label := fmt.Sprintf("proxy %s of %s", ProxyKey(proto, proxy.Name), scope)
+			err := store(id, label, proxy.Password)
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			proxies[index].Password = ""
+			proxies[index].SecretID = id
+			count++
+		}
+	}
+	return count, firstErr
+}
@@ -0,0 +1,44 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Config
+
+import (
+	"errors"
+	"testing"
+
+	define "github.com/linuxdeepin/deepin-network-proxy/define"
+)
+
+func TestScopeProxies_MigratePasswords(t *testing.T) {
+	proxies := ScopeProxies{
+		Proxies: map[string][]Proxy{
+			"sock5": {
+				{Name: "a", Password: "pa"},
+				{Name: "b", Password: "pb"},
+				{Name: "c"},
+				{Name: "d", SecretID: "App/sock5/d"},
+			},
+		},
+	}
+	stored := make(map[string]string)
+	count, err := proxies.MigratePasswords(define.App, func(id string, label string, password string) error {
+		if id == "App/sock5/b" {
+			return errors.New("keyring is locked")
+		}
+		stored[id] = password
+		return nil
+	})
+	if count != 1 || err == nil {
+		t.Errorf("migrate got %d, err: %v", count, err)
+	}
+	if len(stored) != 1 || stored["App/sock5/a"] != "pa" {
+		t.Errorf("stored got %v", stored)
+	}
+	got := proxies.Proxies["sock5"]
+	// proxy failed to store keeps password
+	if got[0].Password != "" || got[0].SecretID != "App/sock5/a" || got[1].Password != "pb" || got[1].SecretID != "" {
+		t.Errorf("proxies got %+v", got)
+	}
+}
@@ -0,0 +1,191 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+	v2ray config, only fields used are parsed
+	{
+	  "outbounds": [
+	    {"tag": "office", "protocol": "http", "settings": {"servers": [{"address": "10.0.0.1", "port": 8080}]}},
+	    {"tag": "direct", "protocol": "freedom"}
+	  ],
+	  "routing": {"rules": [
+	    {"type": "field", "domain": ["domain:cn"], "outboundTag": "direct"},
+	    {"type": "field", "ip": ["geoip:private", "geoip:cn"], "port": "22,8000-9000", "outboundTag": "direct"}
+	  ]}
+	}
+*/
+
+type v2rayConfig struct {
+	Outbounds []v2rayOutbound `json:"outbounds"`
+	Routing   struct {
+		Rules []v2rayRule `json:"rules"`
+	} `json:"routing"`
+}
+
+type v2rayOutbound struct {
+	Tag      string `json:"tag"`
+	Protocol string `json:"protocol"`
+	Settings struct {
+		Servers []struct {
+			Address string `json:"address"`
+			Port    int    `json:"port"`
+			Users   []struct {
+				User string `json:"user"`
+				Pass string `json:"pass"`
+			} `json:"users"`
+		} `json:"servers"`
+		// socks version, 4, 4a or 5
+		Version string `json:"version"`
+	} `json:"settings"`
+	StreamSettings struct {
+		Security string `json:"security"`
+	} `json:"streamSettings"`
+}
+
+type v2rayRule struct {
+	Domain      []string    `json:"domain"`
+	IP          []string    `json:"ip"`
+	Port        interface{} `json:"port"` // number or string like 22,8000-9000
+	Network     string      `json:"network"`
+	InboundTag  []string    `json:"inboundTag"`
+	Protocol    []string    `json:"protocol"`
+	OutboundTag string      `json:"outboundTag"`
+	BalancerTag string      `json:"balancerTag"`
+}
+
+// convert outbounds and routing rules of v2ray config
+func ImportV2Ray(data []byte, withRules bool) (*ImportResult, error) {
+	var cfg v2rayConfig
+	err := json.Unmarshal(data, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal v2ray config failed, err: %v", err)
+	}
+	result := &ImportResult{}
+	direct := make(map[string]bool)
+	for _, outbound := range cfg.Outbounds {
+		var proto string
+		switch outbound.Protocol {
+		case "http":
+			proto = "http"
+		case "socks":
+			proto = "socks5"
+			if strings.HasPrefix(outbound.Settings.Version, "4") {
+				proto = "socks4"
+			}
+		case "freedom":
+			direct[outbound.Tag] = true
+			continue
+		case "blackhole", "dns":
+			continue
+		default:
+			result.unsupported("outbound %s: protocol %s is not supported", outbound.Tag, outbound.Protocol)
+			continue
+		}
+		if outbound.StreamSettings.Security != "" && outbound.StreamSettings.Security != "none" {
+			result.unsupported("outbound %s: security %s is not supported", outbound.Tag, outbound.StreamSettings.Security)
+			continue
+		}
+		servers := outbound.Settings.Servers
+		for index, server := range servers {
+			name := outbound.Tag
+			if len(servers) > 1 || name == "" {
+				name = strings.TrimPrefix(fmt.Sprintf("%s-%d", outbound.Tag, index), "-")
+			}
+			imported, err := proxyOfHost(joinHostPort(server.Address, server.Port), name)
+			if err != nil {
+				result.unsupported("outbound %s: %v", outbound.Tag, err)
+				continue
+			}
+			imported.ProtoType = proto
+			if len(server.Users) != 0 {
+				imported.UserName = server.Users[0].User
+				imported.Password = server.Users[0].Pass
+			}
+			result.Proxies = append(result.Proxies, ImportedProxy{Proto: proto, Proxy: imported})
+		}
+	}
+	if !withRules {
+		return result, nil
+	}
+	var proxied int
+	for index, r := range cfg.Routing.Rules {
+		if r.BalancerTag != "" || !direct[r.OutboundTag] {
+			proxied++
+			continue
+		}
+		convertV2RayRule(result, fmt.Sprintf("routing.rules[%d]", index), r)
+	}
+	if proxied != 0 {
+		result.unsupported("routing: %d rules to proxies are ignored, traffic not bypassed is proxied", proxied)
+	}
+	return result, nil
+}
+
+// conditions of one rule are all matched, bypass rules are matched by any,
+// so only rule with one kind of condition is converted
+func convertV2RayRule(result *ImportResult, origin string, r v2rayRule) {
+	port := v2rayPort(r.Port)
+	var kinds int
+	for _, set := range []bool{len(r.Domain) != 0, len(r.IP) != 0, port != ""} {
+		if set {
+			kinds++
+		}
+	}
+	if kinds != 1 || r.Network != "" || len(r.InboundTag) != 0 || len(r.Protocol) != 0 {
+		result.unsupported("%s: rule with combined conditions is not supported", origin)
+		return
+	}
+	for _, domain := range r.Domain {
+		switch {
+		case strings.HasPrefix(domain, "domain:"):
+			result.bypass(origin, strings.TrimPrefix(domain, "domain:"))
+		case strings.HasPrefix(domain, "full:"):
+			// bypass domain also matches sub domains
+			result.bypass(origin, strings.TrimPrefix(domain, "full:"))
+		default:
+			// plain keyword, regexp: and geosite:
+			result.unsupported("%s: domain %s is not supported", origin, domain)
+		}
+	}
+	for _, ip := range r.IP {
+		switch {
+		case ip == "geoip:private":
+			result.bypass(origin, privateCIDRs...)
+		case strings.HasPrefix(ip, "geoip:!"):
+			result.unsupported("%s: ip %s is not supported", origin, ip)
+		case strings.HasPrefix(ip, "geoip:"):
+			result.bypass(origin, "geoip:"+strings.ToUpper(strings.TrimPrefix(ip, "geoip:")))
+		case strings.HasPrefix(ip, "ext:"):
+			result.unsupported("%s: ip %s is not supported", origin, ip)
+		default:
+			result.bypass(origin, ip)
+		}
+	}
+	for _, rg := range strings.Split(port, ",") {
+		if rg = strings.TrimSpace(rg); rg != "" {
+			result.bypass(origin, "port:"+rg)
+		}
+	}
+}
+
+// port is number or string
+func v2rayPort(port interface{}) string {
+	switch value := port.(type) {
+	case float64:
+		return strconv.Itoa(int(value))
+	case string:
+		return value
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,469 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	define "github.com/linuxdeepin/deepin-network-proxy/define"
+	resolver "github.com/linuxdeepin/deepin-network-proxy/resolver"
+	rule "github.com/linuxdeepin/deepin-network-proxy/rule"
+	"gopkg.in/yaml.v2"
+)
+
+// config is checked as a whole, every invalid field is reported with key path,
+// so that front end can point out which field is wrong instead of ignoring it.
+// unknown keys and invalid fields are reported when config is loaded, config is still used,
+// only config which cant be parsed is rejected.
+
+// protos of proxies map, the same as proto of StartProxy
+var proxyProtos = map[string]bool{
+	define.HTTP:  true,
+	define.SOCK4: true,
+	define.SOCK5: true,
+	"socks4":     true,
+	"socks5":     true,
+	"socks5-tcp": true,
+	"socks5-udp": true,
+	"masque":     true,
+	"masque-tcp": true,
+	"masque-udp": true,
+}
+
+// max length of interface name, IFNAMSIZ include tail zero
+const maxIfNameLen = 15
+
+// range of tcp max segment size, min is TCP_MIN_MSS of kernel
+const (
+	minMSS = 88
+	maxMSS = 65495
+)
+
+// max keepalive time in seconds and probes, MAX_TCP_KEEPIDLE and MAX_TCP_KEEPCNT of kernel
+const (
+	maxKeepAlive      = 32767
+	maxKeepAliveCount = 127
+)
+
+// error of one field
+type FieldError struct {
+	File   string // empty if config is not read from file
+	Line   int32  // line in file, 0 if unknown
+	Path   string // key path, like all-proxies.App.proxies.http[0].port
+	Reason string
+}
+
+func (e FieldError) Error() string {
+	var pos string
+	if e.File != "" {
+		pos = e.File + ":"
+	}
+	if e.Line > 0 {
+		pos += strconv.Itoa(int(e.Line)) + ":"
+	}
+	if pos != "" {
+		pos += " "
+	}
+	if e.Path == "" {
+		return pos + e.Reason
+	}
+	return pos + e.Path + ": " + e.Reason
+}
+
+// all invalid fields of config
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	var msgs []string
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("config has %d invalid fields: %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+// field errors of err, err not from validation is reported as error without key path
+func FieldErrors(err error) []FieldError {
+	if err == nil {
+		return nil
+	}
+	if verr, ok := err.(*ValidationError); ok {
+		return verr.Errors
+	}
+	return []FieldError{{Reason: err.Error()}}
+}
+
+// collect errors of fields
+type validator struct {
+	errs []FieldError
+}
+
+func (v *validator) add(path string, format string, args ...interface{}) {
+	v.errs = append(v.errs, FieldError{Path: path, Reason: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return &ValidationError{Errors: v.errs}
+}
+
+// read config file and check it, the same policy for loading at start and reloading:
+// err is returned if file cant be read or parsed, config with unknown keys or invalid fields is still usable,
+// and fields are returned to be reported
+func ParsePxyCfg(path string) (*ProxyConfig, []FieldError, error) {
+	cfg := NewProxyCfg()
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cfg, nil, &ValidationError{Errors: []FieldError{{File: path, Reason: err.Error()}}}
+	}
+	var errs []FieldError
+	// unknown keys are only reported by strict mode, and decode stops at first syntax error
+	err = yaml.UnmarshalStrict(buf, NewProxyCfg())
+	if err != nil {
+		errs = append(errs, yamlErrors(err)...)
+	}
+	err = yaml.Unmarshal(buf, cfg)
+	if err != nil {
+		// syntax error is already reported, nothing to check
+		return cfg, nil, &ValidationError{Errors: withFile(path, errs)}
+	}
+	errs = append(errs, FieldErrors(cfg.Validate())...)
+	return cfg, withFile(path, errs), nil
+}
+
+// read config file and check it, config is returned even it is invalid
+func CheckPxyCfg(path string) (*ProxyConfig, error) {
+	cfg, errs, err := ParsePxyCfg(path)
+	if err != nil {
+		return cfg, err
+	}
+	if len(errs) == 0 {
+		return cfg, nil
+	}
+	return cfg, &ValidationError{Errors: errs}
+}
+
+func withFile(path string, errs []FieldError) []FieldError {
+	for index := range errs {
+		errs[index].File = path
+	}
+	return errs
+}
+
+var (
+	yamlLineRegexp  = regexp.MustCompile(`^(?:yaml: )?line ([0-9]+): (.*)$`)
+	yamlFieldRegexp = regexp.MustCompile(`^field (\S+) not found in type (\S+)$`)
+)
+
+// line 5: field foo not found in type Config.Proxy
+// yaml: line 3: mapping values are not allowed in this context
+func yamlErrors(err error) []FieldError {
+	msgs := []string{err.Error()}
+	if terr, ok := err.(*yaml.TypeError); ok {
+		msgs = terr.Errors
+	}
+	var errs []FieldError
+	for _, msg := range msgs {
+		fieldErr := FieldError{Reason: msg}
+		if match := yamlLineRegexp.FindStringSubmatch(msg); match != nil {
+			line, _ := strconv.Atoi(match[1])
+			fieldErr.Line = int32(line)
+			fieldErr.Reason = match[2]
+		}
+		if match := yamlFieldRegexp.FindStringSubmatch(fieldErr.Reason); match != nil {
+			fieldErr.Path = match[1]
+			fieldErr.Reason = fmt.Sprintf("unknown key in %s", match[2])
+		}
+		errs = append(errs, fieldErr)
+	}
+	return errs
+}
+
+// check config before it is applied, config with any invalid field is rejected as a whole,
+// error is *ValidationError which lists all invalid fields
+func (p *ProxyConfig) Validate() error {
+	v := &validator{}
+	if p.AllProxies == nil {
+		v.add("all-proxies", "all proxies is nil")
+		return v.err()
+	}
+	switch p.InterceptBackend {
+	case "", "iptables", "bpf":
+	default:
+		v.add("intercept-backend", "should be iptables or bpf, got %q", p.InterceptBackend)
+	}
+	if p.MetricsListen != "" && !isLoopbackAddr(p.MetricsListen) {
+		v.add("metrics-listen", "should be loopback addr like 127.0.0.1:9464, got %q", p.MetricsListen)
+	}
+	if p.AuditLog.Dir != "" && !filepath.IsAbs(p.AuditLog.Dir) {
+		v.add("audit-log.dir", "should be absolute path, got %q", p.AuditLog.Dir)
+	}
+	if p.AuditLog.MaxSize < 0 {
+		v.add("audit-log.max-size", "should not be negative, got %d", p.AuditLog.MaxSize)
+	}
+	if p.AuditLog.MaxAge < 0 {
+		v.add("audit-log.max-age", "should not be negative, got %d", p.AuditLog.MaxAge)
+	}
+	if p.MaxTunnels < 0 {
+		v.add("max-tunnels", "should not be negative, got %d", p.MaxTunnels)
+	}
+	if p.MaxOpenFiles < 0 {
+		v.add("max-open-files", "should not be negative, got %d", p.MaxOpenFiles)
+	}
+	switch p.RelayEngine {
+	case "", RelayGoroutine, RelayEpoll:
+	default:
+		v.add("relay-engine", "should be goroutine or epoll, got %q", p.RelayEngine)
+	}
+	validatePrograms(v, "direct-program", p.DirectProgram)
+	ports := make(map[int]string)
+	for _, scope := range []define.Scope{define.App, define.Global} {
+		proxies, ok := p.AllProxies[scope.String()]
+		if !ok {
+			continue
+		}
+		path := "all-proxies." + scope.String()
+		if proxies.TPort != 0 {
+			if other, ok := ports[proxies.TPort]; ok {
+				v.add(path+".t-port", "%d is used by %s", proxies.TPort, other)
+			}
+			ports[proxies.TPort] = scope.String()
+		}
+		proxies.validate(v, path)
+	}
+	for scope := range p.AllProxies {
+		if scope != define.App.String() && scope != define.Global.String() {
+			v.add("all-proxies."+scope, "unknown scope, should be App or Global")
+		}
+	}
+	p.validateProfiles(v)
+	p.validateConditions(v)
+	return v.err()
+}
+
+// check fields of scope proxies
+func (p *ScopeProxies) validate(v *validator, path string) {
+	if p.TPort < 0 || p.TPort > 65535 {
+		v.add(path+".t-port", "%d is out of range [0,65535]", p.TPort)
+	}
+	if p.DNSPort < 0 || p.DNSPort > 65535 {
+		v.add(path+".dns-port", "%d is out of range [0,65535]", p.DNSPort)
+	}
+	for proto, list := range p.Proxies {
+		protoPath := path + ".proxies." + proto
+		if !proxyProtos[proto] {
+			v.add(protoPath, "unknown proxy type %q", proto)
+		}
+		names := make(map[string]bool)
+		for index, proxy := range list {
+			proxy.validate(v, fmt.Sprintf("%s[%d]", protoPath, index), proto)
+			if proxy.Name != "" && names[proxy.Name] {
+				v.add(fmt.Sprintf("%s[%d].name", protoPath, index), "proxy %s is duplicated", ProxyKey(proto, proxy.Name))
+			}
+			names[proxy.Name] = true
+		}
+	}
+	validatePrograms(v, path+".proxy-program", p.ProxyProgram)
+	validatePrograms(v, path+".no-proxy-program", p.NoProxyProgram)
+	for index, bypass := range p.WhiteList {
+		if err := rule.CheckRule(bypass); err != nil {
+			v.add(fmt.Sprintf("%s.whitelist[%d]", path, index), "%v", err)
+		}
+	}
+	if p.FakeIPRange != "" {
+		if _, _, err := net.ParseCIDR(p.FakeIPRange); err != nil {
+			v.add(path+".fake-ip-range", "should be cidr, got %q", p.FakeIPRange)
+		}
+	}
+	if p.RemoteDNS != "" {
+		host, port, err := net.SplitHostPort(p.RemoteDNS)
+		if err != nil || net.ParseIP(host) == nil || !isPort(port) {
+			v.add(path+".remote-dns", "should be ip:port, got %q", p.RemoteDNS)
+		}
+	}
+	for index, upstream := range p.DNSUpstreams {
+		if _, err := resolver.NewUpstream(upstream); err != nil {
+			v.add(fmt.Sprintf("%s.dns-upstreams[%d]", path, index), "%v", err)
+		}
+	}
+	if p.DisableIPv4 && p.DisableIPv6 {
+		v.add(path+".disable-ipv6", "ipv4 is disabled too, no listener is left")
+	}
+	if p.DrainTimeout < 0 {
+		v.add(path+".drain-timeout", "should not be negative, got %d", p.DrainTimeout)
+	}
+	if p.Strategy != "" && p.Strategy != StrategyLeastLatency {
+		v.add(path+".strategy", "should be empty or %s, got %q", StrategyLeastLatency, p.Strategy)
+	}
+	if p.MaxTunnels < 0 {
+		v.add(path+".max-tunnels", "should not be negative, got %d", p.MaxTunnels)
+	}
+	switch p.Overflow {
+	case "", OverflowQueue, OverflowReject, OverflowDirect:
+	default:
+		v.add(path+".overflow", "should be %s, %s or %s, got %q", OverflowQueue, OverflowReject, OverflowDirect, p.Overflow)
+	}
+	if p.PAC != "" && !isPACLocation(p.PAC) {
+		v.add(path+".pac", "should be http url, https url or absolute path, got %q", p.PAC)
+	}
+	validateInterfaces(v, path+".interfaces", p.Interfaces)
+	validateInterfaces(v, path+".exclude-interfaces", p.ExcludeInterfaces)
+	for app, key := range p.AppProxies {
+		proto, name, _ := strings.Cut(key, "/")
+		if app == "" {
+			v.add(path+".app-proxies", "app of proxy %s is empty", key)
+		} else if _, err := p.GetProxy(proto, name); err != nil {
+			v.add(path+".app-proxies."+app, "proxy %q is not in proxies of scope", key)
+		}
+	}
+	for index, spec := range p.MatchSpecs {
+		specPath := fmt.Sprintf("%s.match-specs[%d]", path, index)
+		if spec == (MatchSpec{}) {
+			v.add(specPath, "spec matches every proc, at least one field should be set")
+		}
+		if spec.Cmdline == "" {
+			continue
+		}
+		if _, err := regexp.Compile(spec.Cmdline); err != nil {
+			v.add(specPath+".cmdline", "invalid regexp, %v", err)
+		}
+	}
+}
+
+// check fields of proxy
+func (p *Proxy) validate(v *validator, path string, proto string) {
+	if p.ProtoType != "" && p.ProtoType != proto {
+		v.add(path+".prototype", "%q differs from proxy type %q", p.ProtoType, proto)
+	}
+	if p.Name == "" {
+		v.add(path+".name", "name is empty")
+	}
+	if p.Server == "" {
+		v.add(path+".server", "server is empty")
+	} else if !isHost(p.Server) {
+		v.add(path+".server", "should be ip or domain without scheme and port, got %q", p.Server)
+	}
+	if p.Port <= 0 || p.Port > 65535 {
+		v.add(path+".port", "%d is out of range [1,65535]", p.Port)
+	}
+	if p.SecretID != "" && p.Password != "" {
+		v.add(path+".password", "password and secret-id are both set, remove plaintext password")
+	}
+	if p.Retry.Attempts != nil && *p.Retry.Attempts < 0 {
+		v.add(path+".retry.attempts", "should not be negative, got %d", *p.Retry.Attempts)
+	}
+	if (p.Retry.Backoff != nil && *p.Retry.Backoff < 0) || (p.Retry.MaxBackoff != nil && *p.Retry.MaxBackoff < 0) {
+		v.add(path+".retry", "backoff should not be negative")
+	}
+	if p.Retry.Jitter != nil && (*p.Retry.Jitter < 0 || *p.Retry.Jitter > 1) {
+		v.add(path+".retry.jitter", "%v is out of range [0,1]", *p.Retry.Jitter)
+	}
+	if len(p.Dial.Device) > maxIfNameLen {
+		v.add(path+".dial.device", "interface name %q is too long", p.Dial.Device)
+	}
+	if p.Dial.MSS != 0 && (p.Dial.MSS < minMSS || p.Dial.MSS > maxMSS) {
+		v.add(path+".dial.mss", "%d is out of range [%d,%d]", p.Dial.MSS, minMSS, maxMSS)
+	}
+	if p.Dial.KeepAliveIdle < 0 || p.Dial.KeepAliveIdle > maxKeepAlive {
+		v.add(path+".dial.keepalive-idle", "%d is out of range [0,%d]", p.Dial.KeepAliveIdle, maxKeepAlive)
+	}
+	if p.Dial.KeepAliveInterval < 0 || p.Dial.KeepAliveInterval > maxKeepAlive {
+		v.add(path+".dial.keepalive-interval", "%d is out of range [0,%d]", p.Dial.KeepAliveInterval, maxKeepAlive)
+	}
+	if p.Dial.KeepAliveCount < 0 || p.Dial.KeepAliveCount > maxKeepAliveCount {
+		v.add(path+".dial.keepalive-count", "%d is out of range [0,%d]", p.Dial.KeepAliveCount, maxKeepAliveCount)
+	}
+	if p.Dial.SndBuf < 0 {
+		v.add(path+".dial.sndbuf", "should not be negative, got %d", p.Dial.SndBuf)
+	}
+	if p.Dial.RcvBuf < 0 {
+		v.add(path+".dial.rcvbuf", "should not be negative, got %d", p.Dial.RcvBuf)
+	}
+	for index, addr := range p.Dial.Addrs {
+		if net.ParseIP(addr) == nil {
+			v.add(fmt.Sprintf("%s.dial.addrs[%d]", path, index), "should be ip, got %q", addr)
+		}
+	}
+}
+
+// programs should not be empty or listed twice
+func validatePrograms(v *validator, path string, programs []string) {
+	listed := make(map[string]int)
+	for index, program := range programs {
+		if strings.TrimSpace(program) == "" {
+			v.add(fmt.Sprintf("%s[%d]", path, index), "program is empty")
+			continue
+		}
+		if first, ok := listed[program]; ok {
+			v.add(fmt.Sprintf("%s[%d]", path, index), "%s is already listed at index %d", program, first)
+			continue
+		}
+		listed[program] = index
+	}
+}
+
+func validateInterfaces(v *validator, path string, ifaces []string) {
+	for index, iface := range ifaces {
+		if iface == "" || len(iface) > maxIfNameLen || strings.ContainsAny(iface, "/ ") {
+			v.add(fmt.Sprintf("%s[%d]", path, index), "invalid interface name %q", iface)
+		}
+	}
+}
+
+func isPort(port string) bool {
+	num, err := strconv.Atoi(port)
+	return err == nil && num > 0 && num <= 65535
+}
+
+var hostLabelRegexp = regexp.MustCompile(`^[A-Za-z0-9_]([A-Za-z0-9_-]{0,61}[A-Za-z0-9_])?$`)
+
+// ip or domain name
+func isHost(host string) bool {
+	if net.ParseIP(strings.Trim(host, "[]")) != nil {
+		return true
+	}
+	host = strings.TrimSuffix(host, ".")
+	if host == "" || len(host) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(host, ".") {
+		if !hostLabelRegexp.MatchString(label) {
+			return false
+		}
+	}
+	return true
+}
+
+// pac is downloaded by http or read from local file
+func isPACLocation(location string) bool {
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		u, err := url.Parse(location)
+		return err == nil && u.Host != ""
+	}
+	return filepath.IsAbs(strings.TrimPrefix(location, "file://"))
+}
+
+// metrics are served to local scrapers only, traffic of apps should not leak to network
+func isLoopbackAddr(addr string) bool {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || !isPort(port) {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
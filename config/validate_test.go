@@ -0,0 +1,149 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProxyConfig_Validate(t *testing.T) {
+	valid := func() *ProxyConfig {
+		cfg := NewProxyCfg()
+		cfg.AllProxies["App"] = ScopeProxies{
+			Proxies:      map[string][]Proxy{"http": {{Name: "a", Server: "proxy.example.com", Port: 80}}},
+			WhiteList:    []string{"10.0.0.0/8", "port:22"},
+			DNSUpstreams: []string{"tls://dns.google@8.8.8.8"},
+			TPort:        8090,
+		}
+		cfg.AllProxies["Global"] = ScopeProxies{TPort: 8080}
+		return cfg
+	}
+	if err := valid().Validate(); err != nil {
+		t.Fatal(err)
+	}
+	invalids := map[string]func(cfg *ProxyConfig){
+		"all-proxies.Global.t-port": func(cfg *ProxyConfig) { cfg.AllProxies["Global"] = ScopeProxies{TPort: 8090} },
+		"all-proxies.Local":         func(cfg *ProxyConfig) { cfg.AllProxies["Local"] = ScopeProxies{} },
+		"intercept-backend":         func(cfg *ProxyConfig) { cfg.InterceptBackend = "nft" },
+		"metrics-listen":            func(cfg *ProxyConfig) { cfg.MetricsListen = "0.0.0.0:9464" },
+		"audit-log.max-size":        func(cfg *ProxyConfig) { cfg.AuditLog.MaxSize = -1 },
+		"max-tunnels":               func(cfg *ProxyConfig) { cfg.MaxTunnels = -1 },
+		"max-open-files":            func(cfg *ProxyConfig) { cfg.MaxOpenFiles = -1 },
+		"relay-engine":              func(cfg *ProxyConfig) { cfg.RelayEngine = "uring" },
+		"all-proxies.Global.proxies.http[0].server": func(cfg *ProxyConfig) {
+			cfg.AllProxies["Global"] = ScopeProxies{Proxies: map[string][]Proxy{"http": {{Name: "a", Server: "http://1.1.1.1", Port: 80}}}}
+		},
+		"all-proxies.Global.proxies.http[1].name": func(cfg *ProxyConfig) {
+			cfg.AllProxies["Global"] = ScopeProxies{Proxies: map[string][]Proxy{"http": {
+				{Name: "a", Server: "1.1.1.1", Port: 80}, {Name: "a", Server: "2.2.2.2", Port: 80}}}}
+		},
+		"all-proxies.Global.proxies.http[0].dial.mss": func(cfg *ProxyConfig) {
+			cfg.AllProxies["Global"] = ScopeProxies{Proxies: map[string][]Proxy{"http": {{Name: "a", Server: "1.1.1.1", Port: 80, Dial: DialPolicy{MSS: 40}}}}}
+		},
+		"all-proxies.Global.proxies.http[0].dial.keepalive-count": func(cfg *ProxyConfig) {
+			cfg.AllProxies["Global"] = ScopeProxies{Proxies: map[string][]Proxy{"http": {{Name: "a", Server: "1.1.1.1", Port: 80, Dial: DialPolicy{KeepAliveCount: 200}}}}}
+		},
+		"all-proxies.Global.proxies.http[0].dial.addrs[1]": func(cfg *ProxyConfig) {
+			cfg.AllProxies["Global"] = ScopeProxies{Proxies: map[string][]Proxy{"http": {{Name: "a", Server: "proxy.deepin.org", Port: 80,
+				Dial: DialPolicy{Addrs: []string{"1.1.1.1", "proxy.deepin.org"}}}}}}
+		},
+		"all-proxies.Global.proxies.ftp": func(cfg *ProxyConfig) {
+			cfg.AllProxies["Global"] = ScopeProxies{Proxies: map[string][]Proxy{"ftp": nil}}
+		},
+		"all-proxies.Global.match-specs[0].cmdline": func(cfg *ProxyConfig) {
+			cfg.AllProxies["Global"] = ScopeProxies{MatchSpecs: []MatchSpec{{Cmdline: "("}}}
+		},
+		"all-proxies.Global.no-proxy-program[1]": func(cfg *ProxyConfig) {
+			cfg.AllProxies["Global"] = ScopeProxies{NoProxyProgram: []string{"/usr/bin/apt", "/usr/bin/apt"}}
+		},
+		"all-proxies.Global.whitelist[0]": func(cfg *ProxyConfig) {
+			cfg.AllProxies["Global"] = ScopeProxies{WhiteList: []string{"port:90000"}}
+		},
+		"all-proxies.Global.dns-upstreams[0]": func(cfg *ProxyConfig) {
+			cfg.AllProxies["Global"] = ScopeProxies{DNSUpstreams: []string{"quic://1.1.1.1"}}
+		},
+		"all-proxies.Global.strategy": func(cfg *ProxyConfig) {
+			cfg.AllProxies["Global"] = ScopeProxies{Strategy: "round-robin"}
+		},
+		"all-proxies.Global.overflow": func(cfg *ProxyConfig) {
+			cfg.AllProxies["Global"] = ScopeProxies{MaxTunnels: 512, Overflow: "drop"}
+		},
+		"all-proxies.Global.pac": func(cfg *ProxyConfig) {
+			cfg.AllProxies["Global"] = ScopeProxies{PAC: "proxy.pac"}
+		},
+		"all-proxies.Global.disable-ipv6": func(cfg *ProxyConfig) {
+			cfg.AllProxies["Global"] = ScopeProxies{DisableIPv4: true, DisableIPv6: true}
+		},
+		"all-proxies.App.app-proxies./usr/bin/curl": func(cfg *ProxyConfig) {
+			proxies := cfg.AllProxies["App"]
+			proxies.AppProxies = map[string]string{"/usr/bin/curl": "http/b", "org.gnome.Maps": "http/a"}
+			cfg.AllProxies["App"] = proxies
+		},
+	}
+	for path, invalid := range invalids {
+		cfg := valid()
+		invalid(cfg)
+		errs := FieldErrors(cfg.Validate())
+		if len(errs) != 1 || errs[0].Path != path {
+			t.Errorf("%s should be invalid, got %v", path, errs)
+		}
+	}
+
+	// all invalid fields are reported
+	cfg := valid()
+	cfg.AllProxies["Global"] = ScopeProxies{TPort: 70000, DNSPort: -1}
+	if errs := FieldErrors(cfg.Validate()); len(errs) != 2 {
+		t.Errorf("validate got %v", errs)
+	}
+}
+
+func TestCheckPxyCfg(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "proxy.yaml")
+	content := `all-proxies:
+  App:
+    proxies:
+      http:
+      - name: a
+        server: 1.1.1.1
+        port: 0
+    t-prot: 8090
+`
+	_ = ioutil.WriteFile(path, []byte(content), 0644)
+	_, err = CheckPxyCfg(path)
+	errs := FieldErrors(err)
+	if len(errs) != 2 {
+		t.Fatalf("check config got %v", errs)
+	}
+	// unknown key has line, invalid field has key path
+	if errs[0].File != path || errs[0].Line != 8 || errs[0].Path != "t-prot" {
+		t.Errorf("unknown key got %+v", errs[0])
+	}
+	if errs[1].Path != "all-proxies.App.proxies.http[0].port" {
+		t.Errorf("invalid port got %+v", errs[1])
+	}
+	// config with invalid fields is still loaded, fields are reported
+	cfg, errs, err := ParsePxyCfg(path)
+	if err != nil || len(errs) != 2 || len(cfg.AllProxies["App"].Proxies["http"]) != 1 {
+		t.Errorf("parse config got %v %v", errs, err)
+	}
+
+	_ = ioutil.WriteFile(path, []byte("all-proxies:\n  App: [\n"), 0644)
+	_, err = CheckPxyCfg(path)
+	if errs = FieldErrors(err); len(errs) != 1 || errs[0].Line == 0 {
+		t.Errorf("syntax error got %v", errs)
+	}
+	// config cant be parsed is rejected
+	if _, _, err = ParsePxyCfg(path); err == nil {
+		t.Error("parse config with syntax error should fail")
+	}
+}
@@ -5,9 +5,15 @@
 package DBus
 
 import (
+	"net"
+
 	"github.com/godbus/dbus"
+	com "github.com/linuxdeepin/deepin-network-proxy/com"
 	config "github.com/linuxdeepin/deepin-network-proxy/config"
 	define "github.com/linuxdeepin/deepin-network-proxy/define"
+	newCGroups "github.com/linuxdeepin/deepin-network-proxy/new_cgroups"
+	newIptables "github.com/linuxdeepin/deepin-network-proxy/new_iptables"
+	tProxy "github.com/linuxdeepin/deepin-network-proxy/tproxy"
 	"github.com/linuxdeepin/go-lib/dbusutil"
 )
 
@@ -16,15 +22,46 @@ import (
 type BaseProxy interface {
 	// DBus method
 	StartProxy(sender dbus.Sender, proto string, name string, udp bool) *dbus.Error
-	StopProxy() *dbus.Error
-	SetProxies(proxies config.ScopeProxies) *dbus.Error
-	ClearProxy() *dbus.Error
+	StopProxy(sender dbus.Sender) *dbus.Error
+	SetProxies(sender dbus.Sender, proxies config.ScopeProxies) *dbus.Error
+	ClearProxy(sender dbus.Sender) *dbus.Error
 	GetProxy() (string, *dbus.Error)
-	AddProxy(proto string, name string, jsonProxy []byte) *dbus.Error
+	AddProxy(sender dbus.Sender, proto string, name string, jsonProxy []byte) *dbus.Error
 	GetCGroups() (string, *dbus.Error)
+	AddBypass(sender dbus.Sender, rules []string) *dbus.Error
+	RemoveBypass(sender dbus.Sender, rules []string) *dbus.Error
+	PreviewRules(proxies config.ScopeProxies) ([]string, *dbus.Error)
+	GetMark() (uint32, *dbus.Error)
+	SetInterfaces(sender dbus.Sender, include []string, exclude []string) *dbus.Error
+	SaveRuleSnapshot() (string, *dbus.Error)
+	LoadRuleSnapshot(sender dbus.Sender, data string) *dbus.Error
+	GetRuleCounters() ([]newIptables.RuleCounter, *dbus.Error)
+	GetTrackCounters() (newCGroups.TrackCounters, *dbus.Error)
+	GetFdUsage() (com.FdUsage, *dbus.Error)
+	GetProxiedProcesses() ([]newCGroups.ProxiedProc, *dbus.Error)
+	GetWinnerScope(exe string) (string, *dbus.Error)
+	CheckConfig() ([]config.FieldError, *dbus.Error)
+	MigrateSecrets(sender dbus.Sender) (int32, *dbus.Error)
+	ImportProxyURI(sender dbus.Sender, uris string) ([]string, []string, *dbus.Error)
+	ImportClientConfig(sender dbus.Sender, format string, data string, withRules bool) ([]string, []string, *dbus.Error)
+	SetAppProxy(sender dbus.Sender, app string, key string) *dbus.Error
+	ListProfiles() ([]string, string, *dbus.Error)
+	ActivateProfile(sender dbus.Sender, name string) *dbus.Error
+	WatchConnections(sender dbus.Sender) *dbus.Error
+	UnwatchConnections(sender dbus.Sender) *dbus.Error
+	GetProxyLatency() ([]tProxy.LatencyStats, *dbus.Error)
+	DumpConnections(sender dbus.Sender) ([]tProxy.ConnEntry, *dbus.Error)
+	TestProxy(sender dbus.Sender, name string) (tProxy.ProbeReport, *dbus.Error)
 
 	// manager
 	loadConfig()
+	stopProxy() *dbus.Error
+	finishDrain(cut bool)
+	applyConfig(proxies config.ScopeProxies, diff config.ScopeDiff) error
+	switchProxyTo(proto string, name string)
+	rediscoverPAC()
+	endSession(path dbus.ObjectPath)
+	linkChanged(prev linkState, cur linkState, first bool)
 	saveManager(manager *Manager)
 
 	// getScope() tProxy.ProxyScope
@@ -34,6 +71,29 @@ type BaseProxy interface {
 	// get cgroup v2 level
 	getCGroupPriority() define.Priority
 
+	// warn exe listed in several scopes
+	emitConflict(conflict config.Conflict)
+	// warn config rejected
+	emitConfigInvalid(errs []config.FieldError)
+	// notify profile switched
+	emitProfileActivated(name string, auto bool)
+	// state exposed by control
+	scopeState() ScopeState
+	// established tunnels
+	tunnelCount() int
+	// dead handlers collected from connection table
+	collectedHandlers() uint64
+	// self check of scope
+	doctor() []DoctorItem
+	// fds held by modules of scope
+	fdModules() map[string]uint32
+	// explain decision of connection intercepted by scope
+	explain(dst net.Addr, app string, exp *Explanation)
+	// reopen listener closed unexpectedly
+	healListener() (bool, error)
+	// redirect traffic of apps in other network namespaces
+	syncNetNS()
+
 	//// cgroup v2
 	//addCGroupExes(procs []string)
 	//delCGroupExes(procs []string)
@@ -31,6 +31,69 @@ type GlobalProxy struct {
 		GetCGroups func() `out:"cgroups"`
 		AddProc    func() `in:"pid" out:"success"`
 
+		// bypass rules
+		AddBypass    func() `in:"rules" out:"err"`
+		RemoveBypass func() `in:"rules" out:"err"`
+
+		// preview iptables commands
+		PreviewRules func() `in:"proxies" out:"cmds"`
+
+		// fwmark of scope
+		GetMark func() `out:"mark"`
+
+		// restrict proxy to interfaces
+		SetInterfaces func() `in:"include,exclude" out:"err"`
+
+		// json snapshot of rule tree
+		SaveRuleSnapshot func() `out:"data"`
+		LoadRuleSnapshot func() `in:"data" out:"err"`
+
+		// packet and byte counters of rules
+		GetRuleCounters func() `out:"counters"`
+
+		// counters of fork tracking and audit
+		GetTrackCounters func() `out:"counters"`
+
+		// fd usage of daemon
+		GetFdUsage func() `out:"usage"`
+
+		// procs in cgroup of scope
+		GetProxiedProcesses func() `out:"procs"`
+
+		// scope which controls exe
+		GetWinnerScope func() `in:"exe" out:"scope"`
+
+		// invalid fields of config in use
+		CheckConfig func() `out:"errors"`
+
+		// move plaintext passwords to keyring of caller
+		MigrateSecrets func() `out:"count"`
+
+		// import proxies from share uris or subscription
+		ImportProxyURI func() `in:"uris" out:"keys,skipped"`
+		// import proxies and direct rules from clash or v2ray config
+		ImportClientConfig func() `in:"format,data,withRules" out:"keys,unsupported"`
+
+		// proxy of app by exe path or app id
+		SetAppProxy func() `in:"app,key" out:"err"`
+
+		// named profiles of proxies, programs and bypass rules
+		ListProfiles    func() `out:"profiles,active"`
+		ActivateProfile func() `in:"name" out:"err"`
+
+		// signals of connections are emitted while caller watches
+		WatchConnections   func() `out:"err"`
+		UnwatchConnections func() `out:"err"`
+
+		// percentiles of handshake and time to first byte by proxy
+		GetProxyLatency func() `out:"latency"`
+
+		// handlers in connection table
+		DumpConnections func() `out:"conns"`
+
+		// test proxy stage by stage
+		TestProxy func() `in:"name" out:"report"`
+
 		// diff method
 		IgnoreProxyApps   func() `in:"app" out:"err"`
 		UnIgnoreProxyApps func() `in:"app" out:"err"`
@@ -41,6 +104,69 @@ type GlobalProxy struct {
 		Proxy struct {
 			proxy config.Proxy
 		}
+		// established tunnels cut when stop proxy
+		Drained struct {
+			cut int32
+		}
+		// new tunnels are refused, open fds are near limit
+		FdLimitReached struct {
+			open  uint32
+			limit uint64
+		}
+		// exe listed in several scopes
+		Conflict struct {
+			exe    string
+			scopes []string
+			winner string
+		}
+		// config edited by user is rejected
+		ConfigInvalid struct {
+			errors []config.FieldError
+		}
+		// profile activated by hand or conditions
+		ProfileActivated struct {
+			name string
+			auto bool
+		}
+		// interception is paused while user logs in captive portal
+		InterceptionPaused struct {
+			paused bool
+		}
+		// proxy server dialed again after link changed
+		UpstreamChecked struct {
+			addr      string
+			reachable bool
+			reason    string
+		}
+		// tcp connection is proxied, proxy is direct if bypassed
+		NewProxiedConnection struct {
+			id          uint64
+			exe         string
+			destination string
+			proxy       string
+		}
+		// tcp connection closed with bytes sent to and received from remote
+		ConnectionClosed struct {
+			id          uint64
+			exe         string
+			destination string
+			proxy       string
+			sent        uint64
+			received    uint64
+		}
+		// tunnel to proxy server failed to create
+		HandshakeFailed struct {
+			exe         string
+			destination string
+			proxy       string
+			reason      string
+		}
+		// target exe added or removed when proxy is running, procs are moved in or out
+		TargetChanged struct {
+			exe   string
+			added bool
+			procs int32
+		}
 	}
 }
 
@@ -65,98 +191,63 @@ func (mgr *GlobalProxy) export(service *dbusutil.Service) error {
 }
 
 // add proxy app
-func (mgr *GlobalProxy) IgnoreProxyApps(apps []string) *dbus.Error {
+func (mgr *GlobalProxy) IgnoreProxyApps(sender dbus.Sender, apps []string) *dbus.Error {
+	dErr := mgr.authorize(sender, actionConfig)
+	if dErr != nil {
+		return dErr
+	}
 	go func() {
 		_ = mgr.ignoreProxyApps(apps)
+		mgr.sweepCGroups()
 	}()
 	return nil
 }
 
 func (mgr *GlobalProxy) ignoreProxyApps(apps []string) error {
-	// get all procs message
-	procsMap, err := mgr.manager.GetAllProcs()
-	if err != nil {
-		return err
-	}
-
-	// add app
+	var changed bool
 	for _, app := range apps {
 		// check if already exist
 		if com.MegaExist(mgr.Proxies.NoProxyProgram, app) {
-			return nil
+			continue
 		}
 		mgr.Proxies.NoProxyProgram = append(mgr.Proxies.NoProxyProgram, app)
-		_ = mgr.writeConfig()
-		// check if is in proxying
-		if !mgr.Enabled {
-			return nil
-		}
-		// get origin controller
-		controller := mgr.manager.controllerMgr.GetControllerByCtlPath(app)
-		if controller == nil {
-			// add path
-			mgr.controller.AddCtlAppPath(app)
-			// get proc message
-			procSl, ok := procsMap[app]
-			if !ok {
-				continue
-			}
-			// if not empty, move in
-			err := mgr.controller.MoveIn(app, procSl)
-			if err != nil {
-				logger.Warningf("[%s] add procs %s at add proxy apps failed, err: %v", mgr.scope, app, err)
-				continue
-			}
-			logger.Debugf("[%s] add procs %s at add proxy apps success", mgr.scope, app)
-		} else {
-			err := mgr.controller.UpdateFromManager(app)
-			if err != nil {
-				logger.Warningf("[%s] add proc %s from %s at add proxy apps failed, err: %v", mgr.scope, app, controller.Name, err)
-			} else {
-				logger.Debugf("[%s] add proc %s from %s at add proxy apps success", mgr.scope, app, controller.Name)
-			}
-			mgr.controller.AddCtlAppPath(app)
-		}
+		changed = true
+		// procs are moved in at once if proxy is running
+		mgr.addTarget(app)
+	}
+	if !changed {
 		return nil
 	}
-	return nil
+	return mgr.writeConfig()
 }
 
 // delete proxy app
-func (mgr *GlobalProxy) UnIgnoreProxyApps(apps []string) *dbus.Error {
+func (mgr *GlobalProxy) UnIgnoreProxyApps(sender dbus.Sender, apps []string) *dbus.Error {
+	dErr := mgr.authorize(sender, actionConfig)
+	if dErr != nil {
+		return dErr
+	}
 	go func() {
 		_ = mgr.unIgnoreProxyApps(apps)
+		mgr.sweepCGroups()
 	}()
 	return nil
 }
 
 func (mgr *GlobalProxy) unIgnoreProxyApps(apps []string) error {
-	// add app
+	var changed bool
 	for _, app := range apps {
 		// check if already exist
 		if !com.MegaExist(mgr.Proxies.NoProxyProgram, app) {
-			return nil
-		}
-		ifc, _, err := com.MegaDel(mgr.Proxies.NoProxyProgram, app)
-		if err != nil {
-			logger.Warningf("[%s] del proxy app %s failed, err: %v", mgr.scope, app, err)
-			return err
-		}
-		temp, ok := ifc.([]string)
-		if !ok {
-			return nil
-		}
-		mgr.Proxies.NoProxyProgram = temp
-		_ = mgr.writeConfig()
-		if !mgr.Enabled {
-			return nil
-		}
-		// controller
-		err = mgr.controller.ReleaseToManager(app)
-		if err != nil {
-			return dbusutil.ToError(err)
+			continue
 		}
+		mgr.Proxies.NoProxyProgram = removeProgram(mgr.Proxies.NoProxyProgram, app)
+		changed = true
+		// procs are moved out at once if proxy is running
+		mgr.removeTarget(app)
+	}
+	if !changed {
 		return nil
 	}
-	return nil
+	return mgr.writeConfig()
 }
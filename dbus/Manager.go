@@ -5,21 +5,40 @@
 package DBus
 
 import (
-	"os"
+	"fmt"
+	"net/http"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	audit "github.com/linuxdeepin/deepin-network-proxy/audit"
 	com "github.com/linuxdeepin/deepin-network-proxy/com"
-	"github.com/linuxdeepin/go-lib/log"
+	logging "github.com/linuxdeepin/deepin-network-proxy/logging"
 
+	"github.com/godbus/dbus"
+	cgroupBPF "github.com/linuxdeepin/deepin-network-proxy/cgroup_bpf"
 	config "github.com/linuxdeepin/deepin-network-proxy/config"
 	define "github.com/linuxdeepin/deepin-network-proxy/define"
 	route "github.com/linuxdeepin/deepin-network-proxy/ip_route"
+	procNetlink "github.com/linuxdeepin/deepin-network-proxy/netlink"
 	newCGroups "github.com/linuxdeepin/deepin-network-proxy/new_cgroups"
 	newIptables "github.com/linuxdeepin/deepin-network-proxy/new_iptables"
+	stats "github.com/linuxdeepin/deepin-network-proxy/stats"
+	sysProxy "github.com/linuxdeepin/deepin-network-proxy/sysproxy"
+	tProxy "github.com/linuxdeepin/deepin-network-proxy/tproxy"
 	"github.com/linuxdeepin/go-lib/dbusutil"
 )
 
+// interval to reconcile iptables rules against kernel
+const reconcileInterval = 30 * time.Second
+
+// interval to audit controlled procs against cgroups
+const auditInterval = 30 * time.Second
+
+// policy route table of fwmark rules
+const RouteTable = "100"
+
 // manage all proxy handler
 type Manager struct {
 
@@ -31,21 +50,91 @@ type Manager struct {
 
 	// proxy handler
 	handler []BaseProxy
+	// control of all scopes at bus path
+	control *Control
 
 	// cgroup manager
 	mainController *newCGroups.Controller
-	controllerMgr  *newCGroups.Manager
+	// programs never proxied
+	directController *newCGroups.Controller
+	controllerMgr    *newCGroups.Manager
 
 	// config
-	config *config.ProxyConfig
+	config  *config.ProxyConfig
+	locator *config.Locator
+	// path config is loaded from, user config or system config
+	configPath string
+	// reload config edited by user
+	configWatcher *configWatcher
+	// stop watching network and time
+	networkStop chan bool
+	// stop watching sessions of logind
+	sessionStop chan bool
+	// profile matched last time
+	autoProfile string
+	// link state of NetworkManager read last time
+	linkLock  sync.Mutex
+	link      linkState
+	linkKnown bool
+	// system proxy published to users
+	sysProxyLock sync.Mutex
+	sysProxy     sysProxy.Settings
 
 	// iptables manager
 	mainChain   *newIptables.Chain // main attach chain
 	iptablesMgr *newIptables.Manager
-
-	// route manager
-	mainRoute *route.Route
-	routeMgr  *route.Manager
+	// rules of ipv6 are applied by ip6tables, nil if ip6tables is not available
+	mainChain6   *newIptables.Chain
+	iptablesMgr6 *newIptables.Manager
+	// kernel lacks tproxy target, intercept by nat redirect instead
+	redirectMode bool
+	probeOnce    sync.Once
+	// intercept by cgroup bpf instead of iptables
+	bpfMode     bool
+	bpfOnce     sync.Once
+	bpfAttached int // scopes attached with bpf redirect
+	// stop reconciling iptables rules, and count of reconcile failed in a row
+	reconcileStop     chan bool
+	reconcileFailures int32
+	// stop auditing controlled procs
+	auditStop chan bool
+	// stop watching firewall reload
+	firewallStop chan bool
+	// stop finding network namespaces
+	netnsStop chan bool
+	// traffic by app and proxy, and stop flushing it
+	stats     *stats.Recorder
+	statsStop chan bool
+	// serve prometheus metrics
+	metricsServer *http.Server
+	// audit log of tunneled connections
+	connAudit *audit.Writer
+	// stop checking health of daemon
+	watchdogStop chan bool
+
+	// fwmark of each scope
+	markAllocator *MarkAllocator
+	// tunnels of all scopes
+	tunnelLimit *tProxy.TunnelLimiter
+	// relays tcp tunnels of all scopes if epoll engine is chosen
+	relay *tProxy.EpollRelay
+	// open fds counted last time
+	fdSampler fdSampler
+
+	// source of proc events
+	procSource   string
+	procListener *procNetlink.ProcListener
+
+	// conflicts of exe already warned
+	conflicts map[string]bool
+
+	// how cgroups are created, directly or delegated by systemd
+	cgroupMode string
+
+	// route manager, local route of ipv6 is nil if ipv6 is disabled
+	mainRoute  *route.Route
+	mainRoute6 *route.Route
+	routeMgr   *route.Manager
 
 	// if current listening
 	runOnce *sync.Once
@@ -53,7 +142,13 @@ type Manager struct {
 
 // make manager
 func NewManager() *Manager {
-	manager := &Manager{}
+	manager := &Manager{
+		markAllocator: NewMarkAllocator(),
+		tunnelLimit:   tProxy.NewTunnelLimiter(0),
+		locator:       config.NewLocator(define.ConfigName),
+		procSource:    ProcSourceDBus,
+		cgroupMode:    CGroupModeDirect,
+	}
 	return manager
 }
 
@@ -67,6 +162,13 @@ func (m *Manager) Init() error {
 	}
 	// store service
 	m.sysService = sysService
+	// remove rules left by last run if daemon crashed
+	_ = newIptables.Recover(newIptables.DefaultJournalPath)
+	_ = route.Recover(RouteTable)
+	CleanOrphans()
+	// traffic counted before restart
+	m.stats = stats.NewRecorder(stats.DefaultPath)
+	_ = m.stats.Load()
 	// attach dbus objects
 	// m.procsService = netlink.NewProcs(sysService.Conn())
 	// m.sigLoop = dbusutil.NewSignalLoop(sysService.Conn(), 10)
@@ -75,35 +177,57 @@ func (m *Manager) Init() error {
 
 // load config
 func (m *Manager) LoadConfig() error {
-	// get effective user config dir
-	path, err := com.GetConfigDir()
-	if err != nil {
-		logger.Warningf("failed to get user home dir, user:%v, err: %v", os.Geteuid(), err)
-		return err
-	}
-	path = filepath.Join(path, define.ConfigName)
+	// no caller yet, use system config
+	path := m.locator.SystemPath()
 	// config
 	m.config = config.NewProxyCfg()
-	err = m.config.LoadPxyCfg(path)
+	m.configPath = path
+	// invalid config is still used as before, front end gets errors by CheckConfig
+	cfg, err := m.parseConfig(path)
 	if err != nil {
 		logger.Warningf("load config failed, path: %s, err: %v", path, err)
 		return err
 	}
+	m.config = cfg
+	m.updateTunnelLimit()
 	return nil
 }
 
+// switch to config of dbus caller, config of user takes precedence over system config
+func (m *Manager) loadCallerConfig(uid uint32, pid uint32) (bool, error) {
+	// environment is only used for XDG_CONFIG_HOME, home of user is used if unreadable
+	env, err := com.GetProcEnviron(pid)
+	if err != nil {
+		logger.Debugf("[manager] read environ of caller %d failed, err: %v", pid, err)
+	}
+	path, userConfig := m.locator.Locate(uid, env)
+	if path == m.configPath {
+		return false, nil
+	}
+	cfg, err := m.parseConfig(path)
+	if err != nil {
+		logger.Warningf("[manager] load config of uid %d failed, path: %s, err: %v", uid, path, err)
+		return false, err
+	}
+	m.config = cfg
+	m.configPath = path
+	m.updateTunnelLimit()
+	m.checkConflicts()
+	m.startWatchConfig()
+	logger.Infof("[manager] switch config to %s, uid: %d, user config: %v", path, uid, userConfig)
+	return true, nil
+}
+
 // write config
 func (m *Manager) WriteConfig() error {
-	// get config path
-	path, err := com.GetConfigDir()
-	if err != nil {
-		logger.Warningf("[manager] get user home dir failed, user:%v, err: %v", os.Geteuid(), err)
-		return err
+	// write back where config is loaded, file of user keeps owner when truncated
+	path := m.configPath
+	if path == "" {
+		path = m.locator.SystemPath()
 	}
-	path = filepath.Join(path, define.ConfigName)
-	err = m.config.WritePxyCfg(path)
+	err := m.config.WritePxyCfg(path)
 	if err != nil {
-		logger.Warningf("[manager] write config file failed, err: %v", err)
+		logger.Warningf("[manager] write config file failed, path: %s, err: %v", path, err)
 		return err
 	}
 	return nil
@@ -125,19 +249,41 @@ func (m *Manager) Export() error {
 	}
 	m.handler = append(m.handler, appProxy)
 
-	//// global
-	//globalProxy := newProxy(define.Global)
-	//// save manager
-	//globalProxy.saveManager(m)
-	//// load config
-	//globalProxy.loadConfig()
-	//// export
-	//err = globalProxy.export(m.sysService)
-	//if err != nil {
-	//	logger.Warningf("export app proxy failed, err: %v", err)
-	//	return err
-	//}
-	// m.handler = append(m.handler, globalProxy)
+	// global
+	globalProxy := newProxy(define.Global)
+	// save manager
+	globalProxy.saveManager(m)
+	// load config
+	globalProxy.loadConfig()
+	// export
+	err = globalProxy.export(m.sysService)
+	if err != nil {
+		logger.Warningf("create global proxy controller failed, err: %v", err)
+		return err
+	}
+	m.handler = append(m.handler, globalProxy)
+
+	// control of all scopes
+	m.control = newControl(m)
+	err = m.sysService.Export(dbus.ObjectPath(BusPath), m.control)
+	if err != nil {
+		logger.Warningf("export proxy control failed, err: %v", err)
+		return err
+	}
+	m.notifyState()
+
+	// warn exe listed in several scopes
+	m.checkConflicts()
+	m.publishSystemProxy()
+	// apply config edited by user
+	m.startWatchConfig()
+	m.startWatchNetwork()
+	// stop proxies of users logged out
+	m.startWatchSessions()
+	m.startFlushStats()
+	m.startMetrics()
+	m.startConnAudit()
+	m.startRelay()
 
 	// request dbus service
 	err = m.sysService.RequestName(BusServiceName)
@@ -145,6 +291,12 @@ func (m *Manager) Export() error {
 		logger.Warningf("request service name failed, err: %v", err)
 		return err
 	}
+	// tell systemd service is ready, and ping it while healthy
+	err = com.SdNotify(com.SdReady)
+	if err != nil {
+		logger.Warningf("notify systemd ready failed, err: %v", err)
+	}
+	m.startWatchdog()
 	return nil
 }
 
@@ -165,11 +317,28 @@ func (m *Manager) Start() {
 		// init cgroups
 		_ = m.initCGroups()
 
+		// capture procs started later
+		_ = m.Listen()
+		// attach escaped descendants back
+		m.startAudit()
+
+		// bpf backend needs no iptables rule and policy route
+		if m.isBPFMode() {
+			return
+		}
+
 		// iptables init
 		_ = m.initIptables()
+		_ = m.initIptables6()
 
 		// init route
 		_ = m.initRoute()
+
+		// repair iptables rules and routes flushed by other tools
+		m.startReconcile()
+		m.startWatchFirewall()
+		// redirect apps in other network namespaces
+		m.startWatchNetNS()
 	})
 }
 
@@ -178,15 +347,222 @@ func (m *Manager) initIptables() error {
 	var err error
 	m.iptablesMgr = newIptables.NewManager()
 	m.iptablesMgr.Init()
-	// get mangle output chain
-	outputChain := m.iptablesMgr.GetChain("mangle", "OUTPUT")
+	// persist applied rules, in case daemon crashes
+	m.iptablesMgr.SetJournal(newIptables.NewJournal(newIptables.DefaultJournalPath))
+	// tag rules, so that chain owner and orphans can be found
+	m.iptablesMgr.SetComment(newIptables.Comment)
+	name := m.chainName(define.Main)
+	err = checkChain(m.mainTable(), name)
+	if err != nil {
+		logger.Warningf("init iptables failed, err: %v", err)
+		return err
+	}
+	// build main chain in memory, apply in one iptables-restore
+	m.iptablesMgr.Begin()
+	m.mainChain, err = initMainChain(m.iptablesMgr, m.mainTable(), name, m.directPath())
+	if err != nil {
+		logger.Warningf("init iptables failed, err: %v", err)
+		// rules built in memory are never applied, later commands should run at once
+		m.iptablesMgr.Rollback()
+		m.mainChain = nil
+		return err
+	}
+	// old rules are removed by first clean, keep rules of other tools
+	err = m.iptablesMgr.Commit(true)
+	if err != nil {
+		logger.Warningf("init iptables failed, err: %v", err)
+		// tree is not in kernel
+		m.iptablesMgr.Rollback()
+		m.mainChain = nil
+		return err
+	}
+	logger.Debug("init iptables success")
+	return err
+}
+
+// init main chain of ip6tables, ipv6 is optional, scopes intercept ipv4 only if it fails.
+// rules of ipv6 are not journaled, orphans left by crash are found by comment
+func (m *Manager) initIptables6() error {
+	iptablesMgr := newIptables.NewManager6()
+	iptablesMgr.Init()
+	iptablesMgr.SetComment(newIptables.Comment)
+	iptablesMgr.Begin()
+	mainChain, err := initMainChain(iptablesMgr, m.mainTable(), m.chainName(define.Main), m.directPath())
+	if err == nil {
+		err = iptablesMgr.Commit(true)
+	}
+	if err != nil {
+		logger.Warningf("init ip6tables failed, ipv6 is not proxied, err: %v", err)
+		iptablesMgr.Rollback()
+		return err
+	}
+	m.iptablesMgr6 = iptablesMgr
+	m.mainChain6 = mainChain
+	logger.Debug("init ip6tables success")
+	return nil
+}
+
+// lock rule tree of iptables, so that steps of caller are atomic to dbus calls and tickers changing rules,
+// return unlock
+func (m *Manager) lockRules() func() {
+	iptablesMgr := m.iptablesMgr
+	if iptablesMgr == nil {
+		return func() {}
+	}
+	iptablesMgr.Lock()
+	return iptablesMgr.Unlock
+}
+
+// reconcile iptables rules against kernel periodically
+func (m *Manager) startReconcile() {
+	iptablesMgr := m.iptablesMgr
+	routes := m.routes()
+	stop := make(chan bool)
+	m.reconcileStop = stop
+	go func() {
+		ticker := time.NewTicker(reconcileInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				for _, rt := range routes {
+					count, err := rt.Reconcile()
+					if err == nil && count != 0 {
+						logger.Warningf("[manager] ip rules and routes drift from kernel, repaired: %d", count)
+					}
+				}
+				count, err := iptablesMgr.Reconcile()
+				// failures in a row are checked by watchdog
+				if err != nil {
+					atomic.AddInt32(&m.reconcileFailures, 1)
+					continue
+				}
+				atomic.StoreInt32(&m.reconcileFailures, 0)
+				if count != 0 {
+					logger.Warningf("[manager] iptables rules drift from kernel, repaired: %d", count)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// stop reconcile iptables rules
+func (m *Manager) stopReconcile() {
+	if m.reconcileStop == nil {
+		return
+	}
+	close(m.reconcileStop)
+	m.reconcileStop = nil
+}
+
+// audit controlled procs against cgroups periodically
+func (m *Manager) startAudit() {
+	controllerMgr := m.controllerMgr
+	if controllerMgr == nil {
+		return
+	}
+	stop := make(chan bool)
+	m.auditStop = stop
+	go func() {
+		ticker := time.NewTicker(auditInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				count := controllerMgr.Audit()
+				if count != 0 {
+					logger.Warningf("[manager] procs escape from cgroups, attached back: %d", count)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// stop audit controlled procs
+func (m *Manager) stopAudit() {
+	if m.auditStop == nil {
+		return
+	}
+	close(m.auditStop)
+	m.auditStop = nil
+}
+
+// probe if fall back to nat redirect once, kernel support wont change
+func (m *Manager) isRedirectMode() bool {
+	m.probeOnce.Do(func() {
+		m.redirectMode = !probeTProxy()
+		if m.redirectMode {
+			logger.Warning("[manager] tproxy target is not supported, use nat redirect mode")
+		}
+	})
+	return m.redirectMode
+}
+
+// check if intercept by cgroup bpf once, fall back to iptables if kernel is too old
+func (m *Manager) isBPFMode() bool {
+	m.bpfOnce.Do(func() {
+		if m.config == nil || m.config.InterceptBackend != InterceptBPF {
+			return
+		}
+		err := cgroupBPF.Supported()
+		if err != nil {
+			logger.Warningf("[manager] bpf intercept is not supported, use iptables, err: %v", err)
+			return
+		}
+		m.bpfMode = true
+	})
+	return m.bpfMode
+}
+
+// table of main chain, nat is used in redirect mode
+func (m *Manager) mainTable() string {
+	if m.isRedirectMode() {
+		return "nat"
+	}
+	return "mangle"
+}
+
+// check if kernel supports tproxy target
+// iptables -t mangle -A Probe -j TPROXY -p tcp --on-port 1
+func probeTProxy() bool {
+	cpl := &newIptables.CompleteRule{
+		Action: newIptables.TPROXY,
+		ExtendsSl: []newIptables.ExtendsRule{
+			{
+				Match: "p",
+				Elem: newIptables.ExtendsElem{
+					Match: "tcp",
+					Base:  newIptables.BaseRule{Match: "on-port", Param: "1"},
+				},
+			},
+		},
+	}
+	return newIptables.ProbeRule("mangle", cpl)
+}
+
+// create main chain to manager all children chain, directPath is cgroup of programs never proxied
+func initMainChain(iptablesMgr *newIptables.Manager, table string, name string, directPath string) (*newIptables.Chain, error) {
+	// get output chain of mangle or nat
+	outputChain := iptablesMgr.GetChain(table, "OUTPUT")
 	// create main chain to manager all children chain
 	// sudo iptables -t mangle -N Main
 	// sudo iptables -t mangle -A OUTPUT -j Main
-	m.mainChain, err = outputChain.CreateChild(define.Main.String(), 0, &newIptables.CompleteRule{Action: define.Main.String()})
+	mainChain, err := outputChain.CreateChild(name, 0, &newIptables.CompleteRule{Action: name})
 	if err != nil {
-		logger.Warningf("init iptables failed, err: %v", err)
-		return err
+		return nil, err
+	}
+	// dont proxy sockets of daemon itself, dial of proxy, dns and health check
+	// sudo iptables -t mangle -A Main -m mark --mark $SelfMark -j RETURN
+	err = mainChain.AppendRule(&newIptables.CompleteRule{
+		Action:    newIptables.RETURN,
+		ExtendsSl: []newIptables.ExtendsRule{newIptables.MarkRule(com.SelfMark, false)},
+	})
+	if err != nil {
+		return nil, err
 	}
 	// dont proxy local lo
 	// sudo iptables -t mangle -A Main 1 -o lo -j RETURN
@@ -202,10 +578,9 @@ func (m *Manager) initIptables() error {
 		ExtendsSl: nil,
 	}
 	// append rule
-	err = m.mainChain.AppendRule(cpl)
+	err = mainChain.AppendRule(cpl)
 	if err != nil {
-		logger.Warningf("init iptables failed, err: %v", err)
-		return err
+		return nil, err
 	}
 
 	// mainChain add default rule
@@ -232,18 +607,46 @@ func (m *Manager) initIptables() error {
 		ExtendsSl: []newIptables.ExtendsRule{extends},
 	}
 	// append rule
-	err = m.mainChain.AppendRule(cpl)
+	err = mainChain.AppendRule(cpl)
 	if err != nil {
-		logger.Warningf("init iptables failed, err: %v", err)
-		return err
+		return nil, err
+	}
+	// programs never proxied return before chains of scopes
+	// iptables -t mangle -A Main -m cgroup --path Direct.slice -j RETURN
+	err = mainChain.AppendRule(directReturnRule(directPath))
+	if err != nil {
+		return nil, err
+	}
+	return mainChain, nil
+}
+
+// how cgroups of controllers are created
+const (
+	CGroupModeDirect  = "direct"  // under cgroup root
+	CGroupModeSystemd = "systemd" // under subtree delegated by systemd
+)
+
+// select cgroup mode, should be called before start
+func (m *Manager) SetCGroupMode(mode string) error {
+	switch mode {
+	case CGroupModeDirect, CGroupModeSystemd:
+		m.cgroupMode = mode
+		return nil
+	default:
+		return fmt.Errorf("cgroup mode %s not support", mode)
 	}
-	logger.Debug("init iptables success")
-	return err
 }
 
 // init cgroups
 func (m *Manager) initCGroups() error {
 	m.controllerMgr = newCGroups.NewManager()
+	// cooperate with systemd, fall back to create cgroups directly
+	if m.cgroupMode == CGroupModeSystemd {
+		err := m.controllerMgr.Delegate(m.sysService.Conn())
+		if err != nil {
+			logger.Warningf("request delegated cgroups from systemd failed, create directly, err: %v", err)
+		}
+	}
 	// create controller
 	var err error
 	m.mainController, err = m.controllerMgr.CreatePriorityController(define.Main, 0, 0, define.MainPriority)
@@ -251,6 +654,10 @@ func (m *Manager) initCGroups() error {
 		logger.Warningf("init cgroup failed, err: %v", err)
 		return err
 	}
+	err = m.initDirectController()
+	if err != nil {
+		return err
+	}
 	logger.Debug("init cgroup success")
 	return nil
 }
@@ -266,49 +673,45 @@ func (m *Manager) initRoute() error {
 	info := route.RouteInfoSpec{
 		Dev: "lo",
 	}
-	m.mainRoute, err = m.routeMgr.CreateRoute("100", node, info)
+	m.mainRoute, err = m.routeMgr.CreateRoute(RouteTable, node, info)
 	if err != nil {
 		logger.Warningf("init route failed, err: %v", err)
 		return err
 	}
+	// ip -6 route add local ::/0 dev lo table 100
+	node.Prefix = "::/0"
+	m.mainRoute6, err = m.routeMgr.CreateRoute(RouteTable, node, info)
+	if err != nil {
+		logger.Warningf("init ipv6 route failed, ipv6 is not proxied, err: %v", err)
+		m.mainRoute6 = nil
+	}
 	logger.Debug("init route success")
 	return nil
 }
 
+// local routes of all families
+func (m *Manager) routes() []*route.Route {
+	var routes []*route.Route
+	for _, rt := range []*route.Route{m.mainRoute, m.mainRoute6} {
+		if rt != nil {
+			routes = append(routes, rt)
+		}
+	}
+	return routes
+}
+
 // format current procs
 func (m *Manager) GetAllProcs() (map[string]newCGroups.ControlProcSl, error) {
-	// check service
-	//if m.procsService == nil {
-	//	logger.Warning("[manager] get procs failed, service not init")
-	//	return nil, errors.New("service not init")
-	//}
-	// get procs message
-	// map[pid]{pid exec cgroups}
-	//procs, err := m.procsService.Procs().Get(0)
-	//if err != nil {
-	//	logger.Warningf("[%s] get procs failed, err: %v", "manager", err)
-	//	return nil, err
-	//}
 	// map[exec][pid exec cgroups]
-	//ctrlProcMap := make(map[string]newCGroups.ControlProcSl)
-	//for _, proc := range procs {
-	//	execPath := proc.ExecPath
-	//	ctrlProcSl, ok := ctrlProcMap[execPath]
-	//	// if not exist, add one
-	//	if !ok {
-	//		ctrlProcSl = newCGroups.ControlProcSl{}
-	//		// ctrlProcMap[execPath] = ctrlProcSl
-	//	}
-	//	// append
-	//	var temp netlink.ProcMessage = proc
-	//	ctrlProcSl = append(ctrlProcSl, &temp)
-	//	ctrlProcMap[execPath] = ctrlProcSl
-	//}
-	return nil, nil
+	return newCGroups.ScanProcs(newCGroups.ProcRoot, nil)
 }
 
 // start listen
 func (m *Manager) Listen() error {
+	// netlink source needs no procs service
+	if m.procSource == ProcSourceNetlink {
+		return m.startProcListener()
+	}
 	//m.sigLoop.Start()
 	//m.procsService.InitSignalExt(m.sigLoop, true)
 	//_, err := m.procsService.ConnectExecProc(func(execPath string, cgroupPath string, pid string, ppid string) {
@@ -375,10 +778,45 @@ func (m *Manager) Listen() error {
 	return nil
 }
 
+// stop all proxies and release cgroups, called when daemon exits
+func (m *Manager) Shutdown() {
+	_ = com.SdNotify(com.SdStopping)
+	m.stopWatchdog()
+	m.stopWatchConfig()
+	m.stopWatchNetwork()
+	m.stopWatchSessions()
+	for _, handler := range m.handler {
+		dErr := handler.stopProxy()
+		if dErr != nil {
+			logger.Warningf("[manager] stop proxy failed, err: %v", dErr)
+		}
+	}
+	// tunnels draining are waited until drain timeout before rules released
+	for _, handler := range m.handler {
+		handler.finishDrain(false)
+	}
+	// counters are written before daemon exits
+	m.stopFlushStats()
+	m.stopMetrics()
+	m.stopConnAudit()
+	m.stopRelay()
+	// cgroups are left if manager not started or not all proxies stopped
+	if m.controllerMgr == nil {
+		return
+	}
+	err := m.controllerMgr.ReleaseAll()
+	if err != nil {
+		logger.Warningf("[manager] release cgroups failed, err: %v", err)
+	}
+}
+
 // release all source
 func (m *Manager) release() error {
 	// check if all app and global proxy has stopped
-	if m.mainChain.GetChildrenCount() != 0 {
+	if m.mainChain != nil && m.mainChain.GetChildrenCount() != 0 {
+		return nil
+	}
+	if m.bpfAttached != 0 {
 		return nil
 	}
 	// remove all handler
@@ -386,28 +824,58 @@ func (m *Manager) release() error {
 	// stop loop
 	// m.sigLoop.Stop()
 
-	// remove chain
-	err := m.mainChain.Remove()
-	if err != nil {
-		logger.Warningf("[manager] remove main chain failed, err: %v", err)
-		return err
+	// stop reconcile before rules are removed
+	m.stopReconcile()
+	m.stopWatchFirewall()
+	m.stopWatchNetNS()
+	m.stopProcListener()
+	m.stopAudit()
+
+	// remove chain, bpf backend has no chain
+	if m.mainChain != nil {
+		err := m.mainChain.Remove()
+		if err != nil {
+			logger.Warningf("[manager] remove main chain failed, err: %v", err)
+			return err
+		}
+		m.mainChain = nil
 	}
 	m.iptablesMgr = nil
+	if m.mainChain6 != nil {
+		err := m.mainChain6.Remove()
+		if err != nil {
+			logger.Warningf("[manager] remove ipv6 main chain failed, err: %v", err)
+		}
+		m.mainChain6 = nil
+	}
+	m.iptablesMgr6 = nil
 
-	//// release all control procs
-	//err = m.mainController.ReleaseAll()
-	//if err != nil {
-	//	logger.Warning("[manager] release all control procs failed, err:", err)
-	//	return err
-	//}
-	//m.controllerMgr = nil
-
-	// remove all route
-	err = m.mainRoute.Remove()
+	// release all control procs and remove cgroups
+	err := m.controllerMgr.ReleaseAll()
 	if err != nil {
-		logger.Warning("[manager] remove all route failed, err:", err)
+		logger.Warningf("[manager] release all control procs failed, err: %v", err)
 		return err
 	}
+	m.mainController = nil
+	m.directController = nil
+
+	// remove all route
+	if m.mainRoute != nil {
+		err = m.mainRoute.Remove()
+		if err != nil {
+			logger.Warning("[manager] remove all route failed, err:", err)
+			return err
+		}
+		m.mainRoute = nil
+	}
+	if m.mainRoute6 != nil {
+		err = m.mainRoute6.Remove()
+		if err != nil {
+			logger.Warning("[manager] remove ipv6 route failed, err:", err)
+			return err
+		}
+		m.mainRoute6 = nil
+	}
 	m.routeMgr = nil
 
 	// reset once
@@ -458,14 +926,14 @@ func (m *Manager) firstAdjustCGroups() error {
 			m.mainController.AddCtlAppPath(path)
 			err := m.mainController.MoveIn(path, procSl)
 			if err != nil {
-				logger.Warning("[%s] add procs %s at first failed, err: %v", "manager", path, err)
+				logger.Warningf("[%s] add procs %s at first failed, err: %v", "manager", path, err)
 				continue
 			}
 			logger.Debugf("[%s] add procs %s at first success", "manager", path)
 		} else {
 			err = m.mainController.UpdateFromManager(path)
 			if err != nil {
-				logger.Warning("[%s] add proc %s from %s at first failed, err: %v", "manager", path, controller.Name, err)
+				logger.Warningf("[%s] add proc %s from %s at first failed, err: %v", "manager", path, controller.Name, err)
 			} else {
 				logger.Debugf("[%s] add proc %s from %s at first failed", "manager", path, controller.Name)
 			}
@@ -476,5 +944,5 @@ func (m *Manager) firstAdjustCGroups() error {
 }
 
 func init() {
-	logger = log.NewLogger("proxy/dbus")
+	logger = logging.New("proxy/dbus")
 }
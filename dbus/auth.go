@@ -0,0 +1,54 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"fmt"
+
+	"github.com/godbus/dbus"
+	com "github.com/linuxdeepin/deepin-network-proxy/com"
+	define "github.com/linuxdeepin/deepin-network-proxy/define"
+	"github.com/linuxdeepin/go-lib/dbusutil"
+)
+
+// every method changes state checks caller by polkit, uid and pid of caller are read from bus,
+// so that app run by user can not reroute traffic silently. result is cached by com.
+// actions are defined in misc/proxy/com.deepin.system.proxy.policy.
+const (
+	actionStartStop = "com.deepin.system.proxy.start-stop"
+	actionConfig    = "com.deepin.system.proxy.config"
+	actionGlobal    = "com.deepin.system.proxy.global"
+	actionAudit     = "com.deepin.system.proxy.audit"
+)
+
+// check if caller of method is authorized of action, user may be prompted by polkit agent
+func (m *Manager) authorize(sender dbus.Sender, actionId string) error {
+	uid, err := m.sysService.GetConnUID(string(sender))
+	if err != nil {
+		return err
+	}
+	pid, err := m.sysService.GetConnPID(string(sender))
+	if err != nil {
+		return err
+	}
+	err = com.PromotePrivilege(actionId, uid, pid, 0, true)
+	if err != nil {
+		logger.Warningf("[auth] %s of uid %d pid %d denied, err: %v", actionId, uid, pid, err)
+		return fmt.Errorf("%s is not authorized: %v", actionId, err)
+	}
+	return nil
+}
+
+// start, stop and config of global scope reroute all traffic of user, need action of global
+func (mgr *proxyPrv) authorize(sender dbus.Sender, actionId string) *dbus.Error {
+	if mgr.scope == define.Global && actionId != actionAudit {
+		actionId = actionGlobal
+	}
+	err := mgr.manager.authorize(sender, actionId)
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"fmt"
+
+	define "github.com/linuxdeepin/deepin-network-proxy/define"
+	newIptables "github.com/linuxdeepin/deepin-network-proxy/new_iptables"
+)
+
+// tables may have rules of daemon
+var cleanTables = []string{"mangle", "nat", "filter"}
+
+// chain name of scope, with prefix in config
+func (m *Manager) chainName(scope define.Scope) string {
+	if m == nil || m.config == nil {
+		return scope.String()
+	}
+	return m.config.ChainPrefix + scope.String()
+}
+
+// chain name of self scope
+func (mgr *proxyPrv) chainName() string {
+	return mgr.manager.chainName(mgr.scope)
+}
+
+// check chain name is valid and not used by other services
+func checkChain(table string, name string) error {
+	err := newIptables.CheckChainName(name)
+	if err != nil {
+		return err
+	}
+	exist, owned, err := newIptables.ChainOwner(table, name, newIptables.Comment)
+	if err != nil {
+		// cant check, iptables reports error when create if chain exist
+		return nil
+	}
+	if exist && !owned {
+		return fmt.Errorf("chain %s of table %s is used by other service, set chain-prefix in config", name, table)
+	}
+	return nil
+}
+
+// remove rules and chains tagged by daemon, left by last run
+func CleanOrphans() {
+	for _, table := range cleanTables {
+		_, err := newIptables.CleanOrphans(table, newIptables.Comment)
+		if err != nil {
+			logger.Warningf("[manager] clean orphan rules of %s failed, err: %v", table, err)
+		}
+		// ipv6 is optional, ip6tables may be missing
+		_, err = newIptables.CleanOrphans6(table, newIptables.Comment)
+		if err != nil {
+			logger.Debugf("[manager] clean orphan ipv6 rules of %s failed, err: %v", table, err)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"github.com/godbus/dbus"
+	config "github.com/linuxdeepin/deepin-network-proxy/config"
+)
+
+// invalid fields of config are reported to front end with file, line and key path,
+// config edited by user and rejected is warned by ConfigInvalid signal.
+
+// log invalid fields and warn front end
+func (m *Manager) reportConfigErrors(err error) {
+	errs := config.FieldErrors(err)
+	for _, fieldErr := range errs {
+		logger.Warningf("[config] %v", fieldErr)
+	}
+	for _, handler := range m.handler {
+		handler.emitConfigInvalid(errs)
+	}
+}
+
+// parse config file, config with invalid fields is used at start and reload alike,
+// fields are logged and reported to front end, err only if config cant be parsed
+func (m *Manager) parseConfig(path string) (*config.ProxyConfig, error) {
+	cfg, errs, err := config.ParsePxyCfg(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(errs) != 0 {
+		m.reportConfigErrors(&config.ValidationError{Errors: errs})
+	}
+	return cfg, nil
+}
+
+// invalid fields of config in use, empty if config is valid
+func (mgr *proxyPrv) CheckConfig() ([]config.FieldError, *dbus.Error) {
+	if mgr.manager == nil || mgr.manager.configPath == "" {
+		return nil, nil
+	}
+	_, err := config.CheckPxyCfg(mgr.manager.configPath)
+	return config.FieldErrors(err), nil
+}
+
+// emit invalid fields of config rejected
+func (mgr *proxyPrv) emitConfigInvalid(errs []config.FieldError) {
+	if mgr.manager == nil || mgr.manager.sysService == nil {
+		return
+	}
+	err := mgr.manager.sysService.Conn().Emit(mgr.getDBusPath(), mgr.GetInterfaceName()+".ConfigInvalid", errs)
+	if err != nil {
+		logger.Warningf("[%s] emit config invalid signal failed, err: %v", mgr.scope, err)
+	}
+}
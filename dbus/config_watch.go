@@ -0,0 +1,199 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+	"unsafe"
+
+	config "github.com/linuxdeepin/deepin-network-proxy/config"
+)
+
+// config file edited by user is reloaded, validated and applied incrementally,
+// tunnels of proxies not changed are kept, tunnels already created keep their proxy server.
+// dir of config is watched, editors save file by rename, which replaces watched inode.
+
+// events are merged in this period, editors write file several times when saving
+const configSettle = 500 * time.Millisecond
+
+// watcher of config file
+type configWatcher struct {
+	path   string
+	file   *os.File
+	notify chan bool
+	stop   chan bool
+}
+
+// watch config in use, watcher of old path is stopped
+func (m *Manager) startWatchConfig() {
+	m.stopWatchConfig()
+	if m.configPath == "" {
+		return
+	}
+	fd, err := syscall.InotifyInit1(syscall.IN_NONBLOCK | syscall.IN_CLOEXEC)
+	if err != nil {
+		logger.Warningf("[config] inotify init failed, err: %v", err)
+		return
+	}
+	dir := filepath.Dir(m.configPath)
+	_, err = syscall.InotifyAddWatch(fd, dir, syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO|syscall.IN_CREATE)
+	if err != nil {
+		logger.Warningf("[config] watch %s failed, err: %v", dir, err)
+		_ = syscall.Close(fd)
+		return
+	}
+	w := &configWatcher{
+		path:   m.configPath,
+		file:   os.NewFile(uintptr(fd), "inotify"),
+		notify: make(chan bool, 1),
+		stop:   make(chan bool),
+	}
+	m.configWatcher = w
+	go w.readEvents()
+	go m.runWatchConfig(w)
+	logger.Debugf("[config] watch config %s", w.path)
+}
+
+// stop watching config
+func (m *Manager) stopWatchConfig() {
+	if m.configWatcher == nil {
+		return
+	}
+	close(m.configWatcher.stop)
+	m.configWatcher = nil
+}
+
+// read inotify events of dir, only events of config file are notified
+func (w *configWatcher) readEvents() {
+	buf := make([]byte, syscall.SizeofInotifyEvent*16+syscall.NAME_MAX+1)
+	name := filepath.Base(w.path)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			return
+		}
+		var matched bool
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			end := offset + syscall.SizeofInotifyEvent + int(event.Len)
+			if end > n {
+				break
+			}
+			if trimNul(buf[offset+syscall.SizeofInotifyEvent:end]) == name {
+				matched = true
+			}
+			offset = end
+		}
+		if !matched {
+			continue
+		}
+		select {
+		case w.notify <- true:
+		default:
+		}
+	}
+}
+
+// name of inotify event is padded with zero
+func trimNul(buf []byte) string {
+	for index, b := range buf {
+		if b == 0 {
+			return string(buf[:index])
+		}
+	}
+	return string(buf)
+}
+
+// reload config after events settle
+func (m *Manager) runWatchConfig(w *configWatcher) {
+	defer w.file.Close()
+	var settle <-chan time.Time
+	for {
+		select {
+		case <-w.notify:
+			settle = time.After(configSettle)
+		case <-settle:
+			settle = nil
+			m.reloadConfig(w.path)
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// load config from path and apply changes, config in use is kept if new config cant be parsed
+func (m *Manager) reloadConfig(path string) {
+	if path != m.configPath {
+		return
+	}
+	cfg, err := m.parseConfig(path)
+	if err != nil {
+		logger.Warningf("[config] config cant be parsed, changes are ignored")
+		m.reportConfigErrors(err)
+		return
+	}
+	old := m.config
+	if old.ChainPrefix != cfg.ChainPrefix || old.InterceptBackend != cfg.InterceptBackend {
+		logger.Warningf("[config] chain prefix and intercept backend take effect after daemon restarts")
+	}
+	if old.MetricsListen != cfg.MetricsListen {
+		logger.Warningf("[config] metrics listen takes effect after daemon restarts")
+	}
+	if old.AuditLog != cfg.AuditLog {
+		logger.Warningf("[config] audit log takes effect after daemon restarts")
+	}
+	if old.RelayEngine != cfg.RelayEngine {
+		logger.Warningf("[config] relay engine takes effect after daemon restarts")
+	}
+	old.Stats = cfg.Stats
+	// tunnels over new max keep running
+	old.MaxTunnels = cfg.MaxTunnels
+	m.updateTunnelLimit()
+	if old.MaxOpenFiles != cfg.MaxOpenFiles {
+		old.MaxOpenFiles = cfg.MaxOpenFiles
+		m.RaiseFdLimit()
+	}
+	if old.SystemProxy != cfg.SystemProxy {
+		old.SystemProxy = cfg.SystemProxy
+		m.publishSystemProxy()
+	}
+	changed := false
+	added, removed := config.DiffPrograms(old.DirectProgram, cfg.DirectProgram)
+	if len(added) != 0 || len(removed) != 0 {
+		logger.Infof("[config] direct programs changed, added: %v, removed: %v", added, removed)
+		old.DirectProgram = cfg.DirectProgram
+		m.removeDirectTargets(removed)
+		m.addDirectTargets(added)
+		changed = true
+	}
+	for _, handler := range m.handler {
+		scope := handler.getScope()
+		oldProxies, _ := old.GetScopeProxies(scope)
+		curProxies, err := cfg.GetScopeProxies(scope)
+		if err != nil {
+			// scope removed from config, proxies in use are kept
+			continue
+		}
+		diff := config.DiffScope(scope, oldProxies, curProxies)
+		if diff.Empty() {
+			continue
+		}
+		logger.Infof("[config] [%s] config changed, diff: %+v", scope, diff)
+		err = handler.applyConfig(curProxies, diff)
+		if err != nil {
+			logger.Warningf("[config] [%s] apply config failed, err: %v", scope, err)
+			continue
+		}
+		old.SetScopeProxies(scope, curProxies)
+		changed = true
+	}
+	// config written by daemon itself makes no diff
+	if changed {
+		m.checkConflicts()
+	}
+}
@@ -0,0 +1,62 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"strings"
+
+	"github.com/godbus/dbus"
+	config "github.com/linuxdeepin/deepin-network-proxy/config"
+)
+
+// exe listed in several scopes is controlled by scope of higher priority,
+// see config.Conflicts for precedence, front end is warned by Conflict signal.
+
+// check conflicts of config, new conflicts are warned once
+func (m *Manager) checkConflicts() {
+	if m.config == nil {
+		return
+	}
+	reported := make(map[string]bool)
+	for _, conflict := range m.config.Conflicts() {
+		key := conflict.Exe + "|" + strings.Join(conflict.Scopes, ",")
+		reported[key] = true
+		if m.conflicts[key] {
+			continue
+		}
+		logger.Warningf("[manager] %s is listed in scopes %v, controlled by %s", conflict.Exe, conflict.Scopes, conflict.Winner)
+		for _, handler := range m.handler {
+			handler.emitConflict(conflict)
+		}
+	}
+	// conflict resolved and listed again is warned again
+	m.conflicts = reported
+}
+
+// emit conflict on scope which lists exe
+func (mgr *proxyPrv) emitConflict(conflict config.Conflict) {
+	var listed bool
+	for _, scope := range conflict.Scopes {
+		if scope == mgr.scope.String() {
+			listed = true
+		}
+	}
+	if !listed || mgr.manager == nil || mgr.manager.sysService == nil {
+		return
+	}
+	err := mgr.manager.sysService.Conn().Emit(mgr.getDBusPath(), mgr.GetInterfaceName()+".Conflict",
+		conflict.Exe, conflict.Scopes, conflict.Winner)
+	if err != nil {
+		logger.Warningf("[%s] emit conflict signal failed, err: %v", mgr.scope, err)
+	}
+}
+
+// scope which controls exe, empty if exe is not listed by any scope
+func (mgr *proxyPrv) GetWinnerScope(exe string) (string, *dbus.Error) {
+	if mgr.manager == nil || mgr.manager.config == nil {
+		return "", nil
+	}
+	return mgr.manager.config.WinnerScope(exe), nil
+}
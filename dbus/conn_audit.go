@@ -0,0 +1,66 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"time"
+
+	audit "github.com/linuxdeepin/deepin-network-proxy/audit"
+)
+
+// scopes with audit enabled share one audit log, file is opened at first connection
+
+// create writer of audit log by rotation of config
+func (m *Manager) startConnAudit() {
+	if m.config == nil || m.connAudit != nil {
+		return
+	}
+	cfg := m.config.AuditLog
+	m.connAudit = audit.NewWriter(cfg.Dir, int64(cfg.MaxSize)*1024*1024, time.Duration(cfg.MaxAge)*24*time.Hour)
+}
+
+// close audit log
+func (m *Manager) stopConnAudit() {
+	if m.connAudit == nil {
+		return
+	}
+	err := m.connAudit.Close()
+	if err != nil {
+		logger.Warningf("[audit] close audit log failed, err: %v", err)
+	}
+}
+
+// writer of audit log, nil if scope does not audit
+func (mgr *proxyPrv) auditLog() *audit.Writer {
+	if !mgr.Proxies.Audit || mgr.manager == nil {
+		return nil
+	}
+	return mgr.manager.connAudit
+}
+
+// write line of connection closed or failed
+func (mgr *proxyPrv) writeAudit(w *audit.Writer, event connEvent, sent int64, received int64, err error) {
+	rec := audit.Record{
+		Time:        event.start,
+		Scope:       mgr.scope.String(),
+		App:         event.exe,
+		Pid:         event.pid,
+		Source:      event.src,
+		Destination: event.destination,
+		Proxy:       event.proxy,
+		Sent:        uint64(sent),
+		Received:    uint64(received),
+		DurationMs:  time.Since(event.start).Milliseconds(),
+		Result:      audit.ResultClosed,
+	}
+	if err != nil {
+		rec.Result = audit.ResultFailed
+		rec.Error = err.Error()
+	}
+	err = w.Write(rec)
+	if err != nil {
+		logger.Warningf("[%s] write audit log failed, err: %v", mgr.scope, err)
+	}
+}
@@ -0,0 +1,282 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/godbus/dbus"
+	com "github.com/linuxdeepin/deepin-network-proxy/com"
+	config "github.com/linuxdeepin/deepin-network-proxy/config"
+	define "github.com/linuxdeepin/deepin-network-proxy/define"
+	logging "github.com/linuxdeepin/deepin-network-proxy/logging"
+	"github.com/linuxdeepin/go-lib/dbusutil"
+)
+
+// control exported at bus path drives all scopes, so that desktop and scripts need not know
+// object of each scope, state of scopes is exposed as properties.
+
+// state of scope returned by GetProxyState
+type ScopeState struct {
+	Scope   string
+	Enabled bool
+	Proxy   string // proto/name in use, empty if stopped
+	Server  string
+	Port    int
+	PAC     bool   // proxy of connection is chosen by pac
+	Uid     uint32 // user started proxy
+}
+
+// state of all scopes returned by GetProxyState
+type ProxyState struct {
+	ActiveProfile string
+	Scopes        []ScopeState
+}
+
+type Control struct {
+	manager *Manager
+
+	PropsMu sync.RWMutex
+	// running state of scopes, map[scope]enabled
+	States map[string]bool
+	// proxy in use of scopes, map[scope]proto/name
+	Proxies map[string]string
+	// profile activated last time
+	ActiveProfile string
+
+	// methods
+	methods *struct {
+		StartProxy    func() `in:"scope,proxyName" out:"err"`
+		StopProxy     func() `in:"scope" out:"err"`
+		SetProxies    func() `in:"proxies" out:"err"`
+		GetProxyState func() `out:"state"`
+
+		// traffic through proxy by app and proxy
+		GetAppStats func() `in:"period" out:"apps,proxies"`
+		ResetStats  func() `out:"err"`
+
+		// level of all loggers, debug, info, warning or error
+		SetLogLevel func() `in:"level" out:"err"`
+		GetLogLevel func() `out:"level"`
+
+		// self check of the whole stack, json report
+		Doctor func() `out:"report"`
+
+		// json proxies of scope, and result of dialing their servers
+		ListProxies func() `in:"scope" out:"proxies"`
+		TestProxies func() `in:"scope" out:"results"`
+
+		// programs never proxied by any scope
+		AddDirectApps func() `in:"apps" out:"err"`
+		DelDirectApps func() `in:"apps" out:"err"`
+		GetDirectApps func() `out:"apps"`
+
+		// json open fds of daemon by module
+		GetFdBudget func() `out:"budget"`
+
+		// json decision of connection of app to destination, no connection is made
+		Explain func() `in:"destination,app" out:"explanation"`
+	}
+}
+
+func newControl(manager *Manager) *Control {
+	return &Control{
+		manager: manager,
+		States:  make(map[string]bool),
+		Proxies: make(map[string]string),
+	}
+}
+
+// interface path
+func (c *Control) GetInterfaceName() string {
+	return BusInterface
+}
+
+// start proxy of scope with proxy named proto/name, udp is proxied if proto supports
+func (c *Control) StartProxy(sender dbus.Sender, scope string, proxyName string) *dbus.Error {
+	handler, err := c.manager.handlerOf(scope)
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+	proto, name, ok := strings.Cut(proxyName, "/")
+	if !ok {
+		return dbusutil.ToError(fmt.Errorf("proxy name %s is not proto/name", proxyName))
+	}
+	return handler.StartProxy(sender, proto, name, true)
+}
+
+// stop proxy of scope
+func (c *Control) StopProxy(sender dbus.Sender, scope string) *dbus.Error {
+	handler, err := c.manager.handlerOf(scope)
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+	return handler.StopProxy(sender)
+}
+
+// replace config of scopes by json map[scope]proxies, scopes not in map are kept,
+// running scopes apply changes at once
+func (c *Control) SetProxies(sender dbus.Sender, proxies string) *dbus.Error {
+	var scopes map[string]config.ScopeProxies
+	err := json.Unmarshal([]byte(proxies), &scopes)
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+	// config of global scope needs more privilege
+	actionId := actionConfig
+	if _, ok := scopes[define.Global.String()]; ok {
+		actionId = actionGlobal
+	}
+	err = c.manager.authorize(sender, actionId)
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+	err = c.manager.setProxies(scopes)
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+	return nil
+}
+
+// json state of all scopes
+func (c *Control) GetProxyState() (string, *dbus.Error) {
+	buf, err := com.MarshalJson(c.manager.proxyState())
+	if err != nil {
+		logger.Warningf("[control] get proxy state failed, err: %v", err)
+		return "", dbusutil.ToError(err)
+	}
+	return buf, nil
+}
+
+// change level of all loggers at runtime, not saved
+func (c *Control) SetLogLevel(sender dbus.Sender, level string) *dbus.Error {
+	err := c.manager.authorize(sender, actionConfig)
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+	lv, err := logging.ParseLevel(level)
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+	logging.SetLevel(lv)
+	logger.Infof("[control] log level is set to %s", lv)
+	return nil
+}
+
+// level of all loggers
+func (c *Control) GetLogLevel() (string, *dbus.Error) {
+	return logging.GetLevel().String(), nil
+}
+
+// handler of scope name
+func (m *Manager) handlerOf(scope string) (BaseProxy, error) {
+	for _, handler := range m.handler {
+		if handler.getScope().String() == scope {
+			return handler, nil
+		}
+	}
+	return nil, fmt.Errorf("scope %s not found", scope)
+}
+
+// state of all scopes
+func (m *Manager) proxyState() ProxyState {
+	state := ProxyState{Scopes: []ScopeState{}}
+	if m.config != nil {
+		state.ActiveProfile = m.config.ActiveProfile
+	}
+	for _, handler := range m.handler {
+		state.Scopes = append(state.Scopes, handler.scopeState())
+	}
+	return state
+}
+
+// replace config of scopes, the whole config is validated before any scope is touched
+func (m *Manager) setProxies(scopes map[string]config.ScopeProxies) error {
+	cfg := *m.config
+	cfg.AllProxies = make(map[string]config.ScopeProxies)
+	for scope, proxies := range m.config.AllProxies {
+		cfg.AllProxies[scope] = proxies
+	}
+	for scope, proxies := range scopes {
+		if _, err := m.handlerOf(scope); err != nil {
+			return err
+		}
+		cfg.AllProxies[scope] = proxies
+	}
+	err := cfg.Validate()
+	if err != nil {
+		m.reportConfigErrors(err)
+		return err
+	}
+	err = m.applyAll(&cfg, "control")
+	if err != nil {
+		return err
+	}
+	m.config = &cfg
+	m.updateTunnelLimit()
+	m.checkConflicts()
+	m.notifyState()
+	return m.WriteConfig()
+}
+
+// emit properties changed if state of scopes differs from properties
+func (m *Manager) notifyState() {
+	if m == nil || m.control == nil || m.sysService == nil {
+		return
+	}
+	c := m.control
+	state := m.proxyState()
+	states := make(map[string]bool)
+	proxies := make(map[string]string)
+	for _, scope := range state.Scopes {
+		states[scope.Scope] = scope.Enabled
+		proxies[scope.Scope] = scope.Proxy
+	}
+	changed := make(map[string]interface{})
+	c.PropsMu.Lock()
+	if !reflect.DeepEqual(c.States, states) {
+		c.States = states
+		changed["States"] = states
+	}
+	if !reflect.DeepEqual(c.Proxies, proxies) {
+		c.Proxies = proxies
+		changed["Proxies"] = proxies
+	}
+	if c.ActiveProfile != state.ActiveProfile {
+		c.ActiveProfile = state.ActiveProfile
+		changed["ActiveProfile"] = state.ActiveProfile
+	}
+	c.PropsMu.Unlock()
+	if len(changed) == 0 {
+		return
+	}
+	err := m.sysService.EmitPropertiesChanged(c, changed)
+	if err != nil {
+		logger.Warningf("[control] emit state changed failed, err: %v", err)
+	}
+}
+
+// state of scope
+func (mgr *proxyPrv) scopeState() ScopeState {
+	state := ScopeState{
+		Scope:   mgr.scope.String(),
+		Enabled: mgr.Enabled,
+	}
+	if !mgr.Enabled {
+		return state
+	}
+	mgr.proxyLock.Lock()
+	defer mgr.proxyLock.Unlock()
+	state.Proxy = config.ProxyKey(mgr.proto, mgr.Proxy.Name)
+	state.Server = mgr.Proxy.Server
+	state.Port = mgr.Proxy.Port
+	state.PAC = mgr.pac != nil
+	state.Uid = mgr.uid
+	return state
+}
@@ -0,0 +1,136 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus"
+	com "github.com/linuxdeepin/deepin-network-proxy/com"
+	config "github.com/linuxdeepin/deepin-network-proxy/config"
+	define "github.com/linuxdeepin/deepin-network-proxy/define"
+	"github.com/linuxdeepin/go-lib/dbusutil"
+)
+
+// proxies of scope are listed and tested by control for command line client,
+// server is dialed by daemon with socket options of proxy, so that test goes the same path as tunnels.
+
+// proxy of scope returned by ListProxies, password is never exposed
+type ProxyInfo struct {
+	Key    string // proto/name
+	Server string
+	Port   int
+	Active bool // scope is running with it
+}
+
+// result of dialing proxy server returned by TestProxies
+type ProxyTest struct {
+	Key       string
+	Addr      string
+	Reachable bool
+	Latency   int64  // milliseconds to connect
+	Error     string // empty if reachable
+}
+
+// json proxies of scope sorted by key
+func (c *Control) ListProxies(scope string) (string, *dbus.Error) {
+	handler, err := c.manager.handlerOf(scope)
+	if err != nil {
+		return "", dbusutil.ToError(err)
+	}
+	proxies := c.manager.scopeProxies(handler.getScope())
+	active := handler.scopeState().Proxy
+	infos := []ProxyInfo{}
+	for _, key := range proxyKeys(proxies) {
+		proxy := proxies.Proxies[key.proto][key.index]
+		infos = append(infos, ProxyInfo{
+			Key:    key.key,
+			Server: proxy.Server,
+			Port:   proxy.Port,
+			Active: key.key == active,
+		})
+	}
+	buf, err := com.MarshalJson(infos)
+	if err != nil {
+		return "", dbusutil.ToError(err)
+	}
+	return buf, nil
+}
+
+// dial servers of all proxies of scope at the same time, json results sorted by key
+func (c *Control) TestProxies(scope string) (string, *dbus.Error) {
+	handler, err := c.manager.handlerOf(scope)
+	if err != nil {
+		return "", dbusutil.ToError(err)
+	}
+	proxies := c.manager.scopeProxies(handler.getScope())
+	keys := proxyKeys(proxies)
+	results := make([]ProxyTest, len(keys))
+	var wg sync.WaitGroup
+	for index, key := range keys {
+		wg.Add(1)
+		go func(index int, key proxyKey) {
+			defer wg.Done()
+			results[index] = testProxy(key.key, proxies.Proxies[key.proto][key.index])
+		}(index, key)
+	}
+	wg.Wait()
+	buf, err := com.MarshalJson(results)
+	if err != nil {
+		return "", dbusutil.ToError(err)
+	}
+	return buf, nil
+}
+
+// proxies of scope in config, empty if config is not loaded
+func (m *Manager) scopeProxies(scope define.Scope) config.ScopeProxies {
+	if m.config == nil {
+		return config.ScopeProxies{}
+	}
+	proxies, _ := m.config.GetScopeProxies(scope)
+	return proxies
+}
+
+// position of proxy in config
+type proxyKey struct {
+	key   string
+	proto string
+	index int
+}
+
+// keys of all proxies of scope, sorted
+func proxyKeys(proxies config.ScopeProxies) []proxyKey {
+	var keys []proxyKey
+	for proto, list := range proxies.Proxies {
+		for index, proxy := range list {
+			keys = append(keys, proxyKey{key: config.ProxyKey(proto, proxy.Name), proto: proto, index: index})
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].key < keys[j].key
+	})
+	return keys
+}
+
+// connect server of proxy, handshake of proto is not tried
+func testProxy(key string, proxy config.Proxy) ProxyTest {
+	addr := net.JoinHostPort(proxy.Server, strconv.Itoa(proxy.Port))
+	result := ProxyTest{Key: key, Addr: addr}
+	opt := com.DialOpt{Device: proxy.Dial.Device, Mark: proxy.Dial.Mark}
+	start := time.Now()
+	conn, err := opt.Dialer(doctorTimeout).Dial("tcp", addr)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	_ = conn.Close()
+	result.Reachable = true
+	result.Latency = time.Since(start).Milliseconds()
+	return result
+}
@@ -0,0 +1,152 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"github.com/godbus/dbus"
+	com "github.com/linuxdeepin/deepin-network-proxy/com"
+	define "github.com/linuxdeepin/deepin-network-proxy/define"
+	newIptables "github.com/linuxdeepin/deepin-network-proxy/new_iptables"
+	"github.com/linuxdeepin/go-lib/dbusutil"
+)
+
+// programs of direct scope are never proxied, like banking apps and lan tools.
+// direct scope has no proxy and no dbus object of its own, its cgroup has priority right after daemon itself,
+// so exe listed by app or global proxy as well is still moved into direct cgroup.
+// connections of direct cgroup return at head of main chain before chains of app and global proxy,
+// rules of global proxy outside main chain, dns redirect and kill switch, skip direct cgroup too.
+
+// create controller of direct scope and move procs of direct programs in
+func (m *Manager) initDirectController() error {
+	controller, err := m.controllerMgr.CreatePriorityController(define.Direct, 0, 0, define.DirectPriority)
+	if err != nil {
+		logger.Warningf("[direct] init cgroup failed, err: %v", err)
+		return err
+	}
+	m.directController = controller
+	if m.config != nil {
+		m.addDirectTargets(m.config.DirectProgram)
+	}
+	return nil
+}
+
+// cgroup path of direct scope relative to cgroup root
+func (m *Manager) directPath() string {
+	if m != nil && m.directController != nil {
+		return m.directController.GetRelPath()
+	}
+	return define.Direct.String() + ".slice"
+}
+
+// iptables -t mangle -A Main -m cgroup --path Direct.slice -j RETURN
+func directReturnRule(directPath string) *newIptables.CompleteRule {
+	return &newIptables.CompleteRule{
+		Action:    newIptables.RETURN,
+		ExtendsSl: []newIptables.ExtendsRule{directCGroupRule(directPath, false)},
+	}
+}
+
+// -m cgroup --path Direct.slice
+func directCGroupRule(directPath string, not bool) newIptables.ExtendsRule {
+	return newIptables.ExtendsRule{
+		Match: "m",
+		Elem: newIptables.ExtendsElem{
+			Match: "cgroup",
+			Base:  newIptables.BaseRule{Not: not, Match: "path", Param: directPath},
+		},
+	}
+}
+
+// -m cgroup ! --path Direct.slice, for rules of global scope outside main chain
+func (mgr *proxyPrv) directExemptRules() []newIptables.ExtendsRule {
+	if mgr.scope != define.Global {
+		return nil
+	}
+	return []newIptables.ExtendsRule{directCGroupRule(mgr.manager.directPath(), true)}
+}
+
+// move procs of programs into direct cgroup, nothing is done before manager starts
+func (m *Manager) addDirectTargets(programs []string) {
+	if m.directController == nil {
+		return
+	}
+	for _, program := range programs {
+		count, err := m.directController.AddTgtExe(program)
+		if err != nil {
+			logger.Warningf("[direct] add program %s failed, err: %v", program, err)
+			continue
+		}
+		logger.Debugf("[direct] add program %s, procs: %d", program, count)
+	}
+}
+
+// move procs of programs back to scope of lower priority or origin cgroup
+func (m *Manager) removeDirectTargets(programs []string) {
+	if m.directController == nil {
+		return
+	}
+	for _, program := range programs {
+		_, err := m.directController.RemoveTgtExe(program)
+		if err != nil {
+			logger.Warningf("[direct] remove program %s failed, err: %v", program, err)
+		}
+	}
+}
+
+// add programs never proxied, saved to config
+func (c *Control) AddDirectApps(sender dbus.Sender, apps []string) *dbus.Error {
+	err := c.manager.authorize(sender, actionConfig)
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+	m := c.manager
+	var added []string
+	for _, app := range apps {
+		if com.MegaExist(m.config.DirectProgram, app) {
+			continue
+		}
+		m.config.DirectProgram = append(m.config.DirectProgram, app)
+		added = append(added, app)
+	}
+	m.addDirectTargets(added)
+	return m.saveDirectPrograms()
+}
+
+// remove programs from direct scope, saved to config
+func (c *Control) DelDirectApps(sender dbus.Sender, apps []string) *dbus.Error {
+	err := c.manager.authorize(sender, actionConfig)
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+	m := c.manager
+	var kept []string
+	for _, program := range m.config.DirectProgram {
+		if com.MegaExist(apps, program) {
+			continue
+		}
+		kept = append(kept, program)
+	}
+	m.config.DirectProgram = kept
+	m.removeDirectTargets(apps)
+	return m.saveDirectPrograms()
+}
+
+// programs never proxied
+func (c *Control) GetDirectApps() ([]string, *dbus.Error) {
+	if c.manager.config == nil {
+		return []string{}, nil
+	}
+	return append([]string{}, c.manager.config.DirectProgram...), nil
+}
+
+// write direct programs to config, conflicts with other scopes are warned
+func (m *Manager) saveDirectPrograms() *dbus.Error {
+	m.checkConflicts()
+	err := m.WriteConfig()
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/godbus/dbus"
+	com "github.com/linuxdeepin/deepin-network-proxy/com"
+	route "github.com/linuxdeepin/deepin-network-proxy/ip_route"
+	newCGroups "github.com/linuxdeepin/deepin-network-proxy/new_cgroups"
+	"github.com/linuxdeepin/go-lib/dbusutil"
+	"github.com/miekg/dns"
+)
+
+// doctor checks the whole stack the way traffic goes, kernel support first, then each running scope,
+// report is attached to bug reports, so that support need not ask users to run commands.
+
+// timeout of each network check
+const doctorTimeout = 3 * time.Second
+
+// domain resolved to check dns path
+const doctorDomain = "www.deepin.org"
+
+// result of one check
+type DoctorItem struct {
+	Check  string
+	Scope  string // empty if not belongs to scope
+	Passed bool
+	Detail string
+	Hint   string // how to fix, empty if passed
+}
+
+// report returned by Doctor
+type DoctorReport struct {
+	Passed bool // all items passed
+	Items  []DoctorItem
+}
+
+func passItem(check string, scope string, detail string) DoctorItem {
+	return DoctorItem{Check: check, Scope: scope, Passed: true, Detail: detail}
+}
+
+func failItem(check string, scope string, detail string, hint string) DoctorItem {
+	return DoctorItem{Check: check, Scope: scope, Detail: detail, Hint: hint}
+}
+
+// check the whole stack, json report of pass and fail items
+func (c *Control) Doctor() (string, *dbus.Error) {
+	buf, err := com.MarshalJson(c.manager.doctor())
+	if err != nil {
+		logger.Warningf("[doctor] marshal report failed, err: %v", err)
+		return "", dbusutil.ToError(err)
+	}
+	return buf, nil
+}
+
+func (m *Manager) doctor() DoctorReport {
+	var items []DoctorItem
+	if m.isBPFMode() {
+		items = append(items, passItem("tproxy-target", "", "bpf backend intercepts traffic, tproxy target is not needed"))
+	} else if probeTProxy() {
+		items = append(items, passItem("tproxy-target", "", "kernel supports TPROXY target"))
+	} else {
+		items = append(items, failItem("tproxy-target", "", "kernel does not support TPROXY target, nat redirect is used and udp is not proxied",
+			"load module by modprobe xt_TPROXY, or install kernel with CONFIG_NETFILTER_XT_TARGET_TPROXY"))
+	}
+	err := newCGroups.CheckMount()
+	if err != nil {
+		items = append(items, failItem("cgroup-mount", "", err.Error(),
+			"mount cgroup2 at /sys/fs/cgroup/unified, or boot with systemd.unified_cgroup_hierarchy=1"))
+	} else {
+		items = append(items, passItem("cgroup-mount", "", "cgroup2 is mounted"))
+	}
+	for _, handler := range m.handler {
+		items = append(items, handler.doctor()...)
+	}
+	report := DoctorReport{Passed: true, Items: items}
+	for _, item := range items {
+		if !item.Passed {
+			report.Passed = false
+			logger.Infof("[doctor] [%s] check %s failed, %s", item.Scope, item.Check, item.Detail)
+		}
+	}
+	return report
+}
+
+// check running scope, nothing is checked if stopped
+func (mgr *proxyPrv) doctor() []DoctorItem {
+	scope := mgr.scope.String()
+	if !mgr.Enabled {
+		return []DoctorItem{passItem("running", scope, "proxy is stopped, scope is not checked")}
+	}
+	var items []DoctorItem
+	items = append(items, mgr.doctorIpRule())
+
+	if len(mgr.tcpHandlers) != 0 {
+		var addrs []string
+		for _, listen := range mgr.tcpHandlers {
+			addrs = append(addrs, listen.Addr().String())
+		}
+		items = append(items, passItem("listener", scope, fmt.Sprintf("tcp listens at %s", strings.Join(addrs, " "))))
+	} else {
+		items = append(items, failItem("listener", scope, "tcp listener is not bound",
+			fmt.Sprintf("t-port %d may be used by other program, change t-port and start proxy again", mgr.Proxies.TPort)))
+	}
+
+	state := mgr.scopeState()
+	if state.Server != "" {
+		addr := net.JoinHostPort(state.Server, strconv.Itoa(state.Port))
+		conn, err := com.NewDialer(doctorTimeout).Dial("tcp", addr)
+		if err != nil {
+			items = append(items, failItem("upstream", scope, fmt.Sprintf("proxy %s at %s is unreachable, err: %v", state.Proxy, addr, err),
+				"check server and port of proxy, and network to proxy server"))
+		} else {
+			_ = conn.Close()
+			items = append(items, passItem("upstream", scope, fmt.Sprintf("proxy %s at %s is reachable", state.Proxy, addr)))
+		}
+	}
+
+	items = append(items, mgr.doctorDNS())
+	return items
+}
+
+// ip rule routes marked packets to tproxy listener, nat redirect and bpf need no rule
+func (mgr *proxyPrv) doctorIpRule() DoctorItem {
+	scope := mgr.scope.String()
+	if mgr.bpfMode() || mgr.redirectMode() {
+		return passItem("ip-rule", scope, "ip rule is not needed")
+	}
+	mark, err := strconv.ParseUint(mgr.fwmark(), 10, 32)
+	if err != nil {
+		return failItem("ip-rule", scope, fmt.Sprintf("fwmark %s is invalid", mgr.fwmark()), "start proxy again")
+	}
+	marks, err := route.UsedMarks()
+	if err != nil {
+		return failItem("ip-rule", scope, fmt.Sprintf("list ip rules failed, err: %v", err), "run ip rule list to check rules")
+	}
+	for _, used := range marks {
+		if used == uint32(mark) {
+			return passItem("ip-rule", scope, fmt.Sprintf("ip rule of fwmark %d exists", mark))
+		}
+	}
+	return failItem("ip-rule", scope, fmt.Sprintf("ip rule of fwmark %d is missing", mark),
+		"other tools like vpn may flush ip rules, stop and start proxy again")
+}
+
+// query goes to dns proxy if running, otherwise to system resolver
+func (mgr *proxyPrv) doctorDNS() DoctorItem {
+	scope := mgr.scope.String()
+	addr := ""
+	if mgr.dnsProxy != nil {
+		addr = mgr.dnsProxy.listenAddr()
+	}
+	if addr == "" {
+		ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+		defer cancel()
+		ips, err := com.SelfResolver.LookupHost(ctx, doctorDomain)
+		if err != nil || len(ips) == 0 {
+			return failItem("dns", scope, fmt.Sprintf("system resolver failed to resolve %s, err: %v", doctorDomain, err),
+				"check nameserver of /etc/resolv.conf and network")
+		}
+		return passItem("dns", scope, fmt.Sprintf("system resolver resolves %s", doctorDomain))
+	}
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(doctorDomain), dns.TypeA)
+	client := &dns.Client{Net: "udp", Timeout: doctorTimeout, Dialer: com.NewDialer(doctorTimeout)}
+	resp, _, err := client.Exchange(msg, addr)
+	if err != nil || resp.Rcode != dns.RcodeSuccess || len(resp.Answer) == 0 {
+		detail := fmt.Sprintf("dns proxy at %s failed to resolve %s, err: %v", addr, doctorDomain, err)
+		if err == nil {
+			detail = fmt.Sprintf("dns proxy at %s answers %s with %s", addr, doctorDomain, dns.RcodeToString[resp.Rcode])
+		}
+		return failItem("dns", scope, detail, "check dns-upstreams and remote-dns, and if proxy forwards dns")
+	}
+	return passItem("dns", scope, fmt.Sprintf("dns proxy at %s resolves %s", addr, doctorDomain))
+}
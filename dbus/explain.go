@@ -0,0 +1,185 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/godbus/dbus"
+	com "github.com/linuxdeepin/deepin-network-proxy/com"
+	config "github.com/linuxdeepin/deepin-network-proxy/config"
+	define "github.com/linuxdeepin/deepin-network-proxy/define"
+	newCGroups "github.com/linuxdeepin/deepin-network-proxy/new_cgroups"
+	tProxy "github.com/linuxdeepin/deepin-network-proxy/tproxy"
+	"github.com/linuxdeepin/go-lib/dbusutil"
+)
+
+// decision of tcp connection is explained stage by stage the same order as tunnels are built,
+// no connection is made, pac is evaluated and proxy of least latency is picked from stats collected.
+// stages: scope which controls app, exemption of global scope, bypass rules and geoip, own proxy of app,
+// pac, least latency and proxy in use. first stage which decides ends explanation.
+// domain is taken as sniffed from connection, exemptions only match ip as kernel does.
+
+// verdicts of explanation
+const (
+	verdictProxy          = "proxy"
+	verdictDirect         = "direct"
+	verdictNotIntercepted = "not-intercepted"
+)
+
+// stages of decision
+const (
+	stageMain      = "main"
+	stageScope     = "scope"
+	stagePaused    = "paused"
+	stageExemption = "exemption"
+	stageBypass    = "bypass"
+	stageAppProxy  = "app-proxy"
+	stagePAC       = "pac"
+	stageBalanced  = "balanced"
+	stageDefault   = "default"
+)
+
+// decision of connection returned by Explain
+type Explanation struct {
+	Destination string
+	App         string // exe path or app id
+	Scope       string // scope intercepts connection, empty if none
+	Verdict     string // proxy, direct or not-intercepted
+	Stage       string // stage decided verdict
+	Rule        string // rule matched at stage
+	Proxy       string `json:",omitempty"` // proto/name used, only if proxied
+}
+
+// set verdict decided at stage
+func (exp *Explanation) decide(verdict string, stage string, rule string) {
+	exp.Verdict = verdict
+	exp.Stage = stage
+	exp.Rule = rule
+}
+
+// set proxy chosen at stage, pac may choose direct
+func (exp *Explanation) choose(stage string, rule string, chosen appProxy) {
+	if chosen.proxyTyp == tProxy.NoneProto {
+		exp.decide(verdictDirect, stage, rule)
+		return
+	}
+	exp.decide(verdictProxy, stage, rule)
+	exp.Proxy = proxyLabel(chosen.proxyTyp, chosen.proxy)
+}
+
+// explain how tcp connection of app to destination is handled, destination is host:port,
+// app is exe path or app id, empty app is any program not listed in config
+func (c *Control) Explain(destination string, app string) (string, *dbus.Error) {
+	exp, err := c.manager.explain(destination, app)
+	if err != nil {
+		return "", dbusutil.ToError(err)
+	}
+	buf, err := com.MarshalJson(exp)
+	if err != nil {
+		return "", dbusutil.ToError(err)
+	}
+	return buf, nil
+}
+
+// find scope which controls app, then let scope explain the rest
+func (m *Manager) explain(destination string, app string) (Explanation, error) {
+	dst, err := explainAddr(destination)
+	if err != nil {
+		return Explanation{}, err
+	}
+	exp := Explanation{Destination: destination, App: app}
+	if com.MegaExist(mainProxy, app) {
+		exp.decide(verdictNotIntercepted, stageMain, app)
+		return exp, nil
+	}
+	var winner string
+	if m.config != nil && app != "" {
+		winner = m.config.WinnerScope(app)
+	}
+	scope := define.Global.String()
+	switch winner {
+	case define.Direct.String():
+		exp.Scope = winner
+		exp.decide(verdictDirect, stageScope, "direct-program")
+		return exp, nil
+	case define.Global.String():
+		exp.Scope = winner
+		exp.decide(verdictDirect, stageScope, "no-proxy-program")
+		return exp, nil
+	case define.App.String():
+		scope = winner
+	}
+	handler, err := m.handlerOf(scope)
+	if err != nil {
+		exp.decide(verdictNotIntercepted, stageScope, err.Error())
+		return exp, nil
+	}
+	handler.explain(dst, app, &exp)
+	return exp, nil
+}
+
+// addr of destination, domain is kept as sniffed one
+func explainAddr(destination string) (net.Addr, error) {
+	host, portStr, err := net.SplitHostPort(destination)
+	if err != nil {
+		return nil, fmt.Errorf("destination %s should be host:port", destination)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return nil, fmt.Errorf("port of destination %s is invalid", destination)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return &net.TCPAddr{IP: ip, Port: port}, nil
+	}
+	return tProxy.NewDomainAddr("tcp", host, port), nil
+}
+
+// explain connection intercepted by scope
+func (mgr *proxyPrv) explain(dst net.Addr, app string, exp *Explanation) {
+	exp.Scope = mgr.scope.String()
+	if !mgr.Enabled {
+		exp.decide(verdictNotIntercepted, stageScope, "scope is stopped")
+		return
+	}
+	if mgr.isPaused() {
+		exp.decide(verdictNotIntercepted, stagePaused, "captive portal")
+		return
+	}
+	if addr, ok := dst.(*net.TCPAddr); ok {
+		for _, ex := range mgr.exemptions() {
+			if ex.matchTCP(addr.IP, addr.Port) {
+				exp.decide(verdictDirect, stageExemption, ex.name)
+				return
+			}
+		}
+	}
+	// bypass overrides proxy chosen by later stages
+	if matched := mgr.matchedBypass(dst); matched != "" {
+		exp.decide(verdictDirect, stageBypass, matched)
+		return
+	}
+	if app != "" {
+		if chosen, ok := mgr.appProxyOf(&newCGroups.SocketOwner{ExecPath: app, AppID: app}); ok {
+			exp.choose(stageAppProxy, app, chosen)
+			return
+		}
+	}
+	if chosen, directive, ok := mgr.pacProxyOf(dst); ok {
+		exp.choose(stagePAC, directive, chosen)
+		return
+	}
+	if chosen, ok := mgr.balancedProxy(); ok {
+		exp.choose(stageBalanced, "least-latency", chosen)
+		return
+	}
+	mgr.proxyLock.Lock()
+	proto, name := mgr.proto, mgr.Proxy.Name
+	mgr.proxyLock.Unlock()
+	exp.decide(verdictProxy, stageDefault, "")
+	exp.Proxy = config.ProxyKey(proto, name)
+}
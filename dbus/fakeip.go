@@ -5,9 +5,13 @@
 package DBus
 
 import (
+	"fmt"
 	"net"
 )
 
+// default fake ip range, reserved for benchmark by RFC 2544, never conflict with lan
+const defaultFakeIPRange = "198.18.0.0/16"
+
 type fakeIP struct {
 	start uint32
 	end   uint32
@@ -31,8 +35,9 @@ func newFakeIP(ip net.IP, prefix uint32) fakeIP {
 	ipUint := ipToUint(ip)
 	mask := prefixToMask(prefix)
 
-	start := ipUint & mask
-	end := ipUint | ^mask
+	// network and broadcast address are never allocated
+	start := ipUint&mask + 1
+	end := ipUint | ^mask - 1
 
 	return fakeIP{
 		start: start,
@@ -40,10 +45,42 @@ func newFakeIP(ip net.IP, prefix uint32) fakeIP {
 	}
 }
 
+// parse fake ip range from cidr, such as 198.18.0.0/16
+func parseFakeIP(cidr string) (fakeIP, error) {
+	ip, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return fakeIP{}, err
+	}
+	if ip.To4() == nil {
+		return fakeIP{}, fmt.Errorf("fake ip range only support ipv4, range: %s", cidr)
+	}
+	prefix, _ := ipNet.Mask.Size()
+	if prefix > 30 {
+		return fakeIP{}, fmt.Errorf("fake ip range is too small, range: %s", cidr)
+	}
+	return newFakeIP(ip, uint32(prefix)), nil
+}
+
+// count of ip in range
+func (i *fakeIP) size() int {
+	return int(i.end - i.start + 1)
+}
+
+// check if ip is allocated from this range
+func (i *fakeIP) contains(ip net.IP) bool {
+	if ip.To4() == nil {
+		return false
+	}
+	ipUint := ipToUint(ip)
+	return ipUint >= i.start && ipUint <= i.end
+}
+
+// allocate next ip, reuse from start when range is exhausted
 func (i *fakeIP) new() net.IP {
 	current := i.start + i.index
 	if current > i.end {
-		// todo:
+		i.index = 0
+		current = i.start
 	}
 
 	i.index++
@@ -11,7 +11,7 @@ import (
 	"github.com/golang/groupcache/lru"
 )
 
-const cacheMaxSize = 1000
+const cacheMaxSize = 65536
 
 type fakeIPCache struct {
 	domainCache *lru.Cache
@@ -20,10 +20,13 @@ type fakeIPCache struct {
 	mut sync.Mutex
 }
 
-func newFakeIPCache() *fakeIPCache {
+func newFakeIPCache(size int) *fakeIPCache {
+	if size <= 0 || size > cacheMaxSize {
+		size = cacheMaxSize
+	}
 	f := new(fakeIPCache)
-	f.domainCache = lru.New(cacheMaxSize)
-	f.ipCache = lru.New(cacheMaxSize)
+	f.domainCache = lru.New(size)
+	f.ipCache = lru.New(size)
 
 	return f
 }
@@ -33,6 +36,10 @@ func (f *fakeIPCache) Add(domain string, ip net.IP) {
 
 	ipUint := ipToUint(ip)
 
+	// ip is reused after range exhausted, remove the stale domain
+	if oldDomain, ok := f.ipCache.Get(ipUint); ok {
+		f.domainCache.Remove(oldDomain)
+	}
 	f.domainCache.Add(domain, ipUint)
 	f.ipCache.Add(ipUint, domain)
 
@@ -50,7 +57,7 @@ func (f *fakeIPCache) GetByDomain(domain string) (net.IP, bool) {
 		return net.IP{}, false
 	}
 
-	f.domainCache.Get(ipUintI)
+	f.ipCache.Get(ipUintI)
 
 	ipUint := ipUintI.(uint32)
 	return uintToIP(ipUint), true
@@ -65,7 +72,7 @@ func (f *fakeIPCache) GetByIP(ip net.IP) (domain string, ok bool) {
 
 	domainIfc, ok := f.ipCache.Get(ipUint)
 	if !ok {
-		logger.Info("ip not found")
+		logger.Debug("ip not found")
 		return
 	}
 
@@ -0,0 +1,166 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus"
+	com "github.com/linuxdeepin/deepin-network-proxy/com"
+	"github.com/linuxdeepin/go-lib/dbusutil"
+)
+
+// each tunnel holds fds of app side and remote side, daemon refuses new tunnels before it runs out of fds,
+// so that dbus, config and iptables of daemon itself still work. open fds are counted from proc at most
+// once per interval, tunnels created since are added by estimate, so that accept loop stays cheap.
+
+// soft limit of open files raised to at start if config sets none
+const defaultMaxOpenFiles = 65536
+
+// fds kept for daemon itself, tunnels are refused within them
+const fdReserve = 64
+
+// fds of one tunnel, local and remote conn
+const fdsPerTunnel = 2
+
+// interval to count open fds again, and to emit signal again while refusing
+const (
+	fdSampleInterval = time.Second
+	fdSignalInterval = 10 * time.Second
+)
+
+// budget of fds returned by GetFdBudget
+type FdBudget struct {
+	Open    uint32
+	Sockets uint32
+	Limit   uint64
+	Reserve uint32
+	// fds held by modules, handlers, listeners and cgroup-watchers, rest of open fds is other
+	Modules map[string]uint32
+}
+
+// last count of open fds
+type fdSampler struct {
+	lock    sync.Mutex
+	at      time.Time
+	open    uint32
+	limit   uint64
+	tunnels int // tunnels of all scopes when counted
+	emitted time.Time
+}
+
+// raise soft limit of open files to ceiling of config
+func (m *Manager) RaiseFdLimit() {
+	ceiling := uint64(defaultMaxOpenFiles)
+	if m.config != nil && m.config.MaxOpenFiles > 0 {
+		ceiling = uint64(m.config.MaxOpenFiles)
+	}
+	limit, err := com.RaiseFdLimit(ceiling)
+	if err != nil {
+		logger.Warningf("[fd] raise limit of open files to %d failed, limit: %d, err: %v", ceiling, limit, err)
+		return
+	}
+	if limit < ceiling {
+		logger.Warningf("[fd] limit of open files is %d, hard limit is under %d", limit, ceiling)
+		return
+	}
+	logger.Infof("[fd] limit of open files is %d", limit)
+}
+
+// check if one more tunnel keeps fds out of reserve, open fds and limit are returned for report
+func (m *Manager) fdAvailable() (bool, uint32, uint64) {
+	m.fdSampler.lock.Lock()
+	defer m.fdSampler.lock.Unlock()
+	s := &m.fdSampler
+	tunnels := m.tunnelLimit.InUse()
+	if time.Since(s.at) >= fdSampleInterval {
+		usage, err := com.GetFdUsage()
+		if err != nil {
+			// unknown usage never refuses tunnels
+			logger.Debugf("[fd] count open fds failed, err: %v", err)
+			return true, 0, 0
+		}
+		s.at = time.Now()
+		s.open = usage.Open
+		s.limit = usage.Limit
+		s.tunnels = tunnels
+	}
+	estimate := int64(s.open) + int64(tunnels-s.tunnels)*fdsPerTunnel
+	if estimate < 0 {
+		estimate = 0
+	}
+	return uint64(estimate)+fdsPerTunnel+fdReserve <= s.limit, uint32(estimate), s.limit
+}
+
+// check if signal of refused tunnels should be emitted, once per interval
+func (m *Manager) fdShouldEmit() bool {
+	m.fdSampler.lock.Lock()
+	defer m.fdSampler.lock.Unlock()
+	if time.Since(m.fdSampler.emitted) < fdSignalInterval {
+		return false
+	}
+	m.fdSampler.emitted = time.Now()
+	return true
+}
+
+// fds held by modules of all scopes
+func (m *Manager) fdModules() map[string]uint32 {
+	modules := map[string]uint32{"handlers": 0, "listeners": 0, "cgroup-watchers": 0}
+	for _, handler := range m.handler {
+		for module, count := range handler.fdModules() {
+			modules[module] += count
+		}
+	}
+	return modules
+}
+
+// fds held by modules of scope
+func (mgr *proxyPrv) fdModules() map[string]uint32 {
+	return map[string]uint32{
+		"handlers":        uint32(mgr.handlerMgr.Count() * fdsPerTunnel),
+		"listeners":       uint32(len(mgr.tcpHandlers) + len(mgr.udpHandlers)),
+		"cgroup-watchers": uint32(mgr.cgroupWatcher.Fds()),
+	}
+}
+
+// emit signal on scope when tunnels are refused for fds, at most once per interval
+func (mgr *proxyPrv) emitFdLimitReached(open uint32, limit uint64) {
+	if mgr.manager == nil || mgr.manager.sysService == nil || !mgr.manager.fdShouldEmit() {
+		return
+	}
+	err := mgr.manager.sysService.Conn().Emit(mgr.getDBusPath(), mgr.GetInterfaceName()+".FdLimitReached", open, limit)
+	if err != nil {
+		logger.Warningf("[%s] emit fd limit signal failed, err: %v", mgr.scope, err)
+	}
+}
+
+// json fd budget of daemon by module
+func (c *Control) GetFdBudget() (string, *dbus.Error) {
+	usage, err := com.GetFdUsage()
+	if err != nil {
+		logger.Warningf("[fd] get fd usage failed, err: %v", err)
+		return "", dbusutil.ToError(err)
+	}
+	budget := FdBudget{
+		Open:    usage.Open,
+		Sockets: usage.Sockets,
+		Limit:   usage.Limit,
+		Reserve: fdReserve,
+		Modules: c.manager.fdModules(),
+	}
+	var known uint32
+	for _, count := range budget.Modules {
+		known += count
+	}
+	if usage.Open > known {
+		budget.Modules["other"] = usage.Open - known
+	}
+	buf, err := com.MarshalJson(budget)
+	if err != nil {
+		return "", dbusutil.ToError(err)
+	}
+	return buf, nil
+}
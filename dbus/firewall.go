@@ -0,0 +1,109 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	"github.com/godbus/dbus"
+)
+
+// firewalld with iptables backend flushes all tables when reloads, and ufw flushes its chains,
+// rules of proxy are re-applied by reconcile at once when firewalld reloaded,
+// reload of ufw is repaired by periodic reconcile, as ufw has no notification.
+const (
+	firewalldName      = "org.fedoraproject.FirewallD1"
+	firewalldInterface = "org.fedoraproject.FirewallD1"
+	firewalldReloaded  = "Reloaded"
+	ufwConfPath        = "/etc/ufw/ufw.conf"
+)
+
+// check if firewalld is running
+func firewalldActive(conn *dbus.Conn) bool {
+	var has bool
+	err := conn.BusObject().Call("org.freedesktop.DBus.NameHasOwner", 0, firewalldName).Store(&has)
+	if err != nil {
+		logger.Debugf("[firewall] check firewalld failed, err: %v", err)
+		return false
+	}
+	return has
+}
+
+// check if ufw is enabled
+func ufwActive() bool {
+	file, err := os.Open(ufwConfPath)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "ENABLED=") {
+			value := strings.Trim(strings.TrimPrefix(line, "ENABLED="), "\"'")
+			return strings.EqualFold(value, "yes")
+		}
+	}
+	return false
+}
+
+// watch reload of firewalld, re-apply rules which may be flushed
+func (m *Manager) startWatchFirewall() {
+	if m.sysService == nil {
+		return
+	}
+	conn := m.sysService.Conn()
+	if ufwActive() {
+		logger.Info("[firewall] ufw is enabled, rules flushed by ufw are repaired by reconcile")
+	}
+	if firewalldActive(conn) {
+		logger.Info("[firewall] firewalld is running, rules are re-applied when firewalld reloads")
+	}
+	// watch even if firewalld is not running, it may start later
+	match := "type='signal',interface='" + firewalldInterface + "',member='" + firewalldReloaded + "'"
+	err := conn.BusObject().Call("org.freedesktop.DBus.AddMatch", 0, match).Err
+	if err != nil {
+		logger.Warningf("[firewall] add match of firewalld failed, err: %v", err)
+		return
+	}
+	ch := make(chan *dbus.Signal, 10)
+	conn.Signal(ch)
+	stop := make(chan bool)
+	m.firewallStop = stop
+	iptablesMgr := m.iptablesMgr
+	go func() {
+		defer func() {
+			conn.RemoveSignal(ch)
+			_ = conn.BusObject().Call("org.freedesktop.DBus.RemoveMatch", 0, match).Err
+		}()
+		for {
+			select {
+			case sig := <-ch:
+				if sig == nil || sig.Name != firewalldInterface+"."+firewalldReloaded {
+					continue
+				}
+				count, err := iptablesMgr.Reconcile()
+				if err != nil {
+					logger.Warningf("[firewall] firewalld reloaded, reconcile failed, err: %v", err)
+					continue
+				}
+				logger.Infof("[firewall] firewalld reloaded, repaired: %d", count)
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// stop watching firewall
+func (m *Manager) stopWatchFirewall() {
+	if m.firewallStop == nil {
+		return
+	}
+	close(m.firewallStop)
+	m.firewallStop = nil
+}
@@ -0,0 +1,93 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"errors"
+	"sync"
+
+	com "github.com/linuxdeepin/deepin-network-proxy/com"
+	define "github.com/linuxdeepin/deepin-network-proxy/define"
+	route "github.com/linuxdeepin/deepin-network-proxy/ip_route"
+	newIptables "github.com/linuxdeepin/deepin-network-proxy/new_iptables"
+)
+
+// count of candidate marks after preferred one
+const markRange = 1024
+
+// allocate fwmark of each scope, marks used by vpn and other tools are avoided
+type MarkAllocator struct {
+	lock  sync.Mutex
+	marks map[define.Scope]uint32
+}
+
+func NewMarkAllocator() *MarkAllocator {
+	return &MarkAllocator{
+		marks: make(map[define.Scope]uint32),
+	}
+}
+
+// allocate mark for scope, preferred mark is used if it is free
+func (alloc *MarkAllocator) Alloc(scope define.Scope, prefer uint32) (uint32, error) {
+	// sockets of daemon are marked by self mark
+	used := map[uint32]bool{com.SelfMark: true}
+	for _, mark := range scanMarks() {
+		used[mark] = true
+	}
+	alloc.lock.Lock()
+	defer alloc.lock.Unlock()
+	// old mark of scope is not used any more
+	delete(alloc.marks, scope)
+	for _, mark := range alloc.marks {
+		used[mark] = true
+	}
+	return alloc.pick(scope, prefer, used)
+}
+
+// pick first free mark from preferred one
+func (alloc *MarkAllocator) pick(scope define.Scope, prefer uint32, used map[uint32]bool) (uint32, error) {
+	for mark := prefer; mark < prefer+markRange; mark++ {
+		if mark == 0 || used[mark] {
+			continue
+		}
+		alloc.marks[scope] = mark
+		if mark != prefer {
+			logger.Warningf("[%s] mark %d is used by others, use %d instead", scope, prefer, mark)
+		}
+		return mark, nil
+	}
+	return 0, errors.New("no free mark")
+}
+
+// release mark of scope
+func (alloc *MarkAllocator) Release(scope define.Scope) {
+	alloc.lock.Lock()
+	defer alloc.lock.Unlock()
+	delete(alloc.marks, scope)
+}
+
+// allocated mark of scope
+func (alloc *MarkAllocator) Get(scope define.Scope) (uint32, bool) {
+	alloc.lock.Lock()
+	defer alloc.lock.Unlock()
+	mark, ok := alloc.marks[scope]
+	return mark, ok
+}
+
+// marks used by ip rules and iptables rules, scan failure only loses part of marks
+func scanMarks() []uint32 {
+	marks, err := route.UsedMarks()
+	if err != nil {
+		logger.Warningf("[mark] scan marks of ip rule failed, err: %v", err)
+	}
+	for _, table := range []string{"mangle", "nat", "filter"} {
+		tableMarks, err := newIptables.UsedMarks(table)
+		if err != nil {
+			continue
+		}
+		marks = append(marks, tableMarks...)
+	}
+	return marks
+}
@@ -0,0 +1,112 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"context"
+	"time"
+
+	logging "github.com/linuxdeepin/deepin-network-proxy/logging"
+	metrics "github.com/linuxdeepin/deepin-network-proxy/metrics"
+	newCGroups "github.com/linuxdeepin/deepin-network-proxy/new_cgroups"
+	newIptables "github.com/linuxdeepin/deepin-network-proxy/new_iptables"
+)
+
+// metrics of tunnels are always counted, they are only served when metrics-listen is set
+
+// bounds of handshake latency in seconds
+var handshakeBuckets = []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+var (
+	handshakeSeconds = metrics.NewHistogramVec("deepin_proxy_handshake_seconds",
+		"Time to create tunnel through proxy.", handshakeBuckets, "proxy")
+	handshakeFailures = metrics.NewCounterVec("deepin_proxy_handshake_failures_total",
+		"Tunnels failed to create.", "proxy")
+	firstByteSeconds = metrics.NewHistogramVec("deepin_proxy_first_byte_seconds",
+		"Time from tunnel started to first byte received from remote.", handshakeBuckets, "proxy")
+	relayedBytes = metrics.NewCounterVec("deepin_proxy_relayed_bytes_total",
+		"Bytes relayed by closed tunnels, direction is sent or received.", "proxy", "direction")
+	tunnelOverflows = metrics.NewCounterVec("deepin_proxy_tunnel_overflows_total",
+		"Connections over max tunnels, policy is queue, reject or direct.", "scope", "policy")
+	fdRefusals = metrics.NewCounterVec("deepin_proxy_fd_refusals_total",
+		"Connections refused because open fds are near limit.", "scope")
+	setupSeconds = metrics.NewHistogramVec("deepin_proxy_setup_seconds",
+		"Time to set up redirect of scope by step, step total is whole setup.", handshakeBuckets, "step")
+)
+
+// serve metrics at loopback addr of config
+func (m *Manager) startMetrics() {
+	if m.config == nil || m.config.MetricsListen == "" || m.metricsServer != nil {
+		return
+	}
+	registry := metrics.NewRegistry()
+	registry.Register(
+		metrics.NewGaugeFunc("deepin_proxy_active_tunnels", "Established tunnels of scope.", "scope", m.activeTunnels),
+		handshakeSeconds,
+		handshakeFailures,
+		firstByteSeconds,
+		relayedBytes,
+		tunnelOverflows,
+		fdRefusals,
+		setupSeconds,
+		metrics.NewGaugeFunc("deepin_proxy_open_fds", "Fds held by modules of daemon.", "module", m.openFds),
+		metrics.NewGaugeFunc("deepin_proxy_relay_tunnels", "Tunnels relayed by epoll engine.", "engine", m.relayTunnels),
+		metrics.NewCounterFunc("deepin_proxy_handlers_collected_total", "Dead handlers dropped from connection tables.", m.collectedHandlers),
+		metrics.NewCounterFunc("deepin_proxy_iptables_failures_total", "Iptables commands failed to change rules.", func() float64 {
+			return float64(newIptables.CommandFailures())
+		}),
+		metrics.NewCounterFunc("deepin_proxy_cgroup_attach_errors_total", "Procs failed to attach to cgroups.", func() float64 {
+			return float64(newCGroups.AttachFailures())
+		}),
+		metrics.NewCounterFunc("deepin_proxy_log_suppressed_total", "Repeated log lines dropped by rate limit.", func() float64 {
+			return float64(logging.Suppressed())
+		}),
+	)
+	server, err := metrics.Serve(m.config.MetricsListen, registry)
+	if err != nil {
+		logger.Warningf("[metrics] listen %s failed, err: %v", m.config.MetricsListen, err)
+		return
+	}
+	m.metricsServer = server
+	logger.Infof("[metrics] serve metrics at http://%s/metrics", m.config.MetricsListen)
+}
+
+// stop serving metrics
+func (m *Manager) stopMetrics() {
+	if m.metricsServer == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_ = m.metricsServer.Shutdown(ctx)
+	m.metricsServer = nil
+}
+
+// established tunnels by scope
+func (m *Manager) activeTunnels() map[string]float64 {
+	tunnels := make(map[string]float64)
+	for _, handler := range m.handler {
+		tunnels[handler.getScope().String()] = float64(handler.tunnelCount())
+	}
+	return tunnels
+}
+
+// dead handlers collected of all scopes
+func (m *Manager) collectedHandlers() float64 {
+	var collected uint64
+	for _, handler := range m.handler {
+		collected += handler.collectedHandlers()
+	}
+	return float64(collected)
+}
+
+// fds held by modules
+func (m *Manager) openFds() map[string]float64 {
+	fds := make(map[string]float64)
+	for module, count := range m.fdModules() {
+		fds[module] = float64(count)
+	}
+	return fds
+}
@@ -0,0 +1,225 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"time"
+
+	"github.com/godbus/dbus"
+	config "github.com/linuxdeepin/deepin-network-proxy/config"
+)
+
+// state of network is read from NetworkManager, and read again when NetworkManager changes,
+// profile conditions and wpad depend on it, and running scopes react to link changes.
+const (
+	nmName                 = "org.freedesktop.NetworkManager"
+	nmPath                 = "/org/freedesktop/NetworkManager"
+	nmActiveInterface      = nmName + ".Connection.Active"
+	nmAccessPointIface     = nmName + ".AccessPoint"
+	nmDHCP4Interface       = nmName + ".DHCP4Config"
+	nmActiveStateActivated = 2 // NM_ACTIVE_CONNECTION_STATE_ACTIVATED
+	nmConnectivityPortal   = 2 // NM_CONNECTIVITY_PORTAL
+)
+
+// link state scopes react to, default route follows primary connection
+type linkState struct {
+	primary      dbus.ObjectPath
+	vpn          bool
+	connectivity uint32
+}
+
+// check if user should log in captive portal
+func (s linkState) portal() bool {
+	return s.connectivity == nmConnectivityPortal
+}
+
+// events are merged in this period, connection is activated in several steps
+const networkSettle = 2 * time.Second
+
+// watch NetworkManager and time
+func (m *Manager) startWatchNetwork() {
+	if m.sysService == nil {
+		return
+	}
+	conn := m.sysService.Conn()
+	match := "type='signal',sender='" + nmName + "',path='" + nmPath + "',interface='org.freedesktop.DBus.Properties',member='PropertiesChanged'"
+	err := conn.BusObject().Call("org.freedesktop.DBus.AddMatch", 0, match).Err
+	if err != nil {
+		logger.Warningf("[network] add match of NetworkManager failed, err: %v", err)
+	}
+	ch := make(chan *dbus.Signal, 10)
+	conn.Signal(ch)
+	stop := make(chan bool)
+	m.networkStop = stop
+	go func() {
+		defer func() {
+			conn.RemoveSignal(ch)
+			_ = conn.BusObject().Call("org.freedesktop.DBus.RemoveMatch", 0, match).Err
+		}()
+		ticker := time.NewTicker(autoProfileInterval)
+		defer ticker.Stop()
+		settle := time.After(0)
+		for {
+			select {
+			case sig := <-ch:
+				if sig == nil || sig.Path != nmPath || sig.Name != "org.freedesktop.DBus.Properties.PropertiesChanged" {
+					continue
+				}
+				settle = time.After(networkSettle)
+			case <-settle:
+				settle = nil
+				m.checkAutoProfile(conn)
+				m.checkLink(conn)
+				for _, handler := range m.handler {
+					handler.rediscoverPAC()
+				}
+			case <-ticker.C:
+				m.checkAutoProfile(conn)
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// stop watching network and time
+func (m *Manager) stopWatchNetwork() {
+	if m.networkStop == nil {
+		return
+	}
+	close(m.networkStop)
+	m.networkStop = nil
+}
+
+// notify scopes if link state changes, first state read is only recorded
+func (m *Manager) checkLink(conn *dbus.Conn) {
+	state, err := readLinkState(conn)
+	if err != nil {
+		logger.Debugf("[network] get link state failed, err: %v", err)
+		return
+	}
+	m.linkLock.Lock()
+	prev, known := m.link, m.linkKnown
+	m.link, m.linkKnown = state, true
+	m.linkLock.Unlock()
+	if known && prev == state {
+		return
+	}
+	logger.Infof("[network] link changed, primary: %s, vpn: %v, connectivity: %d", state.primary, state.vpn, state.connectivity)
+	for _, handler := range m.handler {
+		handler.linkChanged(prev, state, !known)
+	}
+}
+
+// link state read last time
+func (m *Manager) linkState() linkState {
+	m.linkLock.Lock()
+	defer m.linkLock.Unlock()
+	return m.link
+}
+
+// primary connection, vpn and connectivity of NetworkManager
+func readLinkState(conn *dbus.Conn) (linkState, error) {
+	var state linkState
+	obj := conn.Object(nmName, nmPath)
+	value, err := obj.GetProperty(nmName + ".PrimaryConnection")
+	if err != nil {
+		return state, err
+	}
+	state.primary, _ = value.Value().(dbus.ObjectPath)
+	value, err = obj.GetProperty(nmName + ".Connectivity")
+	if err != nil {
+		return state, err
+	}
+	state.connectivity, _ = value.Value().(uint32)
+	network, err := networkState(conn)
+	if err != nil {
+		return state, err
+	}
+	state.vpn = network.VPN
+	return state, nil
+}
+
+// active connections of NetworkManager
+func networkState(conn *dbus.Conn) (config.NetworkState, error) {
+	var state config.NetworkState
+	variant, err := conn.Object(nmName, nmPath).GetProperty(nmName + ".ActiveConnections")
+	if err != nil {
+		return state, err
+	}
+	paths, _ := variant.Value().([]dbus.ObjectPath)
+	for _, path := range paths {
+		obj := conn.Object(nmName, path)
+		if value, err := obj.GetProperty(nmActiveInterface + ".State"); err != nil || value.Value() != uint32(nmActiveStateActivated) {
+			continue
+		}
+		var id, typ string
+		var vpn bool
+		if value, err := obj.GetProperty(nmActiveInterface + ".Id"); err == nil {
+			id, _ = value.Value().(string)
+		}
+		if value, err := obj.GetProperty(nmActiveInterface + ".Type"); err == nil {
+			typ, _ = value.Value().(string)
+		}
+		if value, err := obj.GetProperty(nmActiveInterface + ".Vpn"); err == nil {
+			vpn, _ = value.Value().(bool)
+		}
+		state.Connections = append(state.Connections, id)
+		if vpn || typ == "wireguard" {
+			state.VPN = true
+		}
+		if typ == "802-11-wireless" {
+			if ssid := accessPointSSID(conn, obj); ssid != "" {
+				state.SSIDs = append(state.SSIDs, ssid)
+			}
+		}
+	}
+	return state, nil
+}
+
+// ssid of access point wifi connection is activated on
+func accessPointSSID(conn *dbus.Conn, active dbus.BusObject) string {
+	value, err := active.GetProperty(nmActiveInterface + ".SpecificObject")
+	if err != nil {
+		return ""
+	}
+	path, ok := value.Value().(dbus.ObjectPath)
+	if !ok || path == "/" {
+		return ""
+	}
+	value, err = conn.Object(nmName, path).GetProperty(nmAccessPointIface + ".Ssid")
+	if err != nil {
+		return ""
+	}
+	ssid, _ := value.Value().([]byte)
+	return string(ssid)
+}
+
+// wpad url of dhcp option 252 got by primary connection, empty if not offered
+func dhcpWPAD(conn *dbus.Conn) string {
+	value, err := conn.Object(nmName, nmPath).GetProperty(nmName + ".PrimaryConnection")
+	if err != nil {
+		return ""
+	}
+	path, ok := value.Value().(dbus.ObjectPath)
+	if !ok || path == "/" {
+		return ""
+	}
+	value, err = conn.Object(nmName, path).GetProperty(nmActiveInterface + ".Dhcp4Config")
+	if err != nil {
+		return ""
+	}
+	path, ok = value.Value().(dbus.ObjectPath)
+	if !ok || path == "/" {
+		return ""
+	}
+	value, err = conn.Object(nmName, path).GetProperty(nmDHCP4Interface + ".Options")
+	if err != nil {
+		return ""
+	}
+	options, _ := value.Value().(map[string]dbus.Variant)
+	wpad, _ := options["wpad"].Value().(string)
+	return wpad
+}
@@ -0,0 +1,108 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"fmt"
+
+	procNetlink "github.com/linuxdeepin/deepin-network-proxy/netlink"
+	newCGroups "github.com/linuxdeepin/deepin-network-proxy/new_cgroups"
+	procs "github.com/linuxdeepin/go-dbus-factory/com.deepin.system.procs"
+)
+
+// source of proc exec and exit events
+const (
+	ProcSourceDBus    = "dbus"    // com.deepin.system.procs service
+	ProcSourceNetlink = "netlink" // kernel proc connector in process
+)
+
+// select source of proc events, should be called before start
+func (m *Manager) SetProcSource(source string) error {
+	switch source {
+	case ProcSourceDBus, ProcSourceNetlink:
+		m.procSource = source
+		return nil
+	default:
+		return fmt.Errorf("proc source %s not support", source)
+	}
+}
+
+// start listening proc events of netlink source
+func (m *Manager) startProcListener() error {
+	if m.procSource != ProcSourceNetlink {
+		return nil
+	}
+	listener, err := procNetlink.NewProcListener()
+	if err != nil {
+		logger.Warningf("[manager] create proc listener failed, err: %v", err)
+		return err
+	}
+	m.procListener = listener
+	go listener.Run(procNetlink.ProcHandler{
+		OnFork: m.onForkProc,
+		OnExec: m.onExecProc,
+		OnExit: m.onExitProc,
+	})
+	logger.Debug("[manager] start proc listener success")
+	return nil
+}
+
+// stop listening proc events
+func (m *Manager) stopProcListener() {
+	if m.procListener == nil {
+		return
+	}
+	m.procListener.Close()
+	m.procListener = nil
+}
+
+// track child forked by controlled proc
+func (m *Manager) onForkProc(ppid string, pid string) {
+	controller := m.controllerMgr.GetControllerByCtrlByPPid(ppid)
+	if controller == nil {
+		return
+	}
+	controller.TrackFork(ppid, pid)
+}
+
+// move new proc to cgroup of controller
+func (m *Manager) onExecProc(msg procNetlink.ProcMessage) {
+	proc := &procs.ProcMessage{
+		ExecPath:   msg.ExecPath,
+		CGroupPath: msg.Cgroup2Path,
+		Pid:        msg.Pid,
+		PPid:       msg.PPid,
+	}
+	// child of controlled proc is already in cgroup
+	if controller := m.controllerMgr.GetControllerByCtrlByPPid(proc.PPid); controller != nil {
+		return
+	}
+	// search controller according to exe path and matchers
+	controller, path := m.controllerMgr.GetControllerByProc(proc)
+	if controller == nil {
+		return
+	}
+	logger.Debugf("[%s] exec proc %s need add to proxy", controller.Name, proc.ExecPath)
+	err := controller.MoveIn(path, newCGroups.ControlProcSl{proc})
+	if err != nil {
+		logger.Warningf("[%s] add exec %s to cgroups failed, err: %v", controller.Name, proc.ExecPath, err)
+	}
+}
+
+// forget exited proc
+func (m *Manager) onExitProc(pid string) {
+	controller := m.controllerMgr.GetControllerByCtrlByPPid(pid)
+	if controller == nil {
+		return
+	}
+	proc := controller.CheckCtrlPid(pid)
+	if proc == nil {
+		return
+	}
+	err := controller.DelCtlProc(proc)
+	if err != nil {
+		logger.Warningf("[%s] del exit proc %s failed, err: %v", controller.Name, pid, err)
+	}
+}
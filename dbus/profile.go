@@ -0,0 +1,120 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"github.com/godbus/dbus"
+	config "github.com/linuxdeepin/deepin-network-proxy/config"
+	"github.com/linuxdeepin/go-lib/dbusutil"
+)
+
+// profile is applied to all scopes as reloaded config, only changed parts are touched,
+// scopes already applied are rolled back if one scope fails.
+// the same way is used by control to replace config of scopes.
+
+// activate profile and write config, auto is true if activated by conditions
+func (m *Manager) activateProfile(name string, auto bool) error {
+	cfg, err := m.config.WithProfile(name)
+	if err != nil {
+		return err
+	}
+	err = cfg.Validate()
+	if err != nil {
+		m.reportConfigErrors(err)
+		return err
+	}
+	err = m.applyAll(cfg, "profile "+name)
+	if err != nil {
+		return err
+	}
+	for _, handler := range m.handler {
+		proto, proxyName := cfg.ProfileProxy(name, handler.getScope())
+		if proto != "" {
+			handler.switchProxyTo(proto, proxyName)
+		}
+	}
+	m.config = cfg
+	m.updateTunnelLimit()
+	m.checkConflicts()
+	m.publishSystemProxy()
+	logger.Infof("[profile] profile %s is activated, auto: %v", name, auto)
+	for _, handler := range m.handler {
+		handler.emitProfileActivated(name, auto)
+	}
+	m.notifyState()
+	return m.WriteConfig()
+}
+
+// apply config to all scopes, scopes already applied are rolled back if one scope fails
+func (m *Manager) applyAll(cfg *config.ProxyConfig, what string) error {
+	old := m.config
+	var applied []BaseProxy
+	for _, handler := range m.handler {
+		scope := handler.getScope()
+		oldProxies, _ := old.GetScopeProxies(scope)
+		curProxies, err := cfg.GetScopeProxies(scope)
+		if err != nil {
+			continue
+		}
+		diff := config.DiffScope(scope, oldProxies, curProxies)
+		if diff.Empty() {
+			continue
+		}
+		err = handler.applyConfig(curProxies, diff)
+		if err != nil {
+			logger.Warningf("[%s] [%s] apply config failed, err: %v", what, scope, err)
+			m.rollbackAll(applied, old, cfg)
+			return err
+		}
+		applied = append(applied, handler)
+	}
+	return nil
+}
+
+// restore scopes already applied
+func (m *Manager) rollbackAll(applied []BaseProxy, old *config.ProxyConfig, cur *config.ProxyConfig) {
+	for _, handler := range applied {
+		scope := handler.getScope()
+		oldProxies, _ := old.GetScopeProxies(scope)
+		curProxies, _ := cur.GetScopeProxies(scope)
+		err := handler.applyConfig(oldProxies, config.DiffScope(scope, curProxies, oldProxies))
+		if err != nil {
+			logger.Warningf("[%s] roll back failed, err: %v", scope, err)
+		}
+	}
+}
+
+// names of profiles and the active one
+func (mgr *proxyPrv) ListProfiles() ([]string, string, *dbus.Error) {
+	if mgr.manager == nil || mgr.manager.config == nil {
+		return nil, "", nil
+	}
+	cfg := mgr.manager.config
+	return cfg.ProfileNames(), cfg.ActiveProfile, nil
+}
+
+// swap proxies, programs and bypass rules of all scopes to profile
+func (mgr *proxyPrv) ActivateProfile(sender dbus.Sender, name string) *dbus.Error {
+	dErr := mgr.authorize(sender, actionConfig)
+	if dErr != nil {
+		return dErr
+	}
+	err := mgr.manager.activateProfile(name, false)
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+	return nil
+}
+
+// emit profile activated by hand or conditions
+func (mgr *proxyPrv) emitProfileActivated(name string, auto bool) {
+	if mgr.manager == nil || mgr.manager.sysService == nil {
+		return
+	}
+	err := mgr.manager.sysService.Conn().Emit(mgr.getDBusPath(), mgr.GetInterfaceName()+".ProfileActivated", name, auto)
+	if err != nil {
+		logger.Warningf("[%s] emit profile activated signal failed, err: %v", mgr.scope, err)
+	}
+}
@@ -0,0 +1,43 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"time"
+
+	"github.com/godbus/dbus"
+)
+
+// profile of first matched condition is activated when network or time changes,
+// profile activated by hand is kept until matched profile changes.
+
+// time window is checked every minute
+const autoProfileInterval = time.Minute
+
+// activate profile matched, if it differs from the one matched last time
+func (m *Manager) checkAutoProfile(conn *dbus.Conn) {
+	cfg := m.config
+	if cfg == nil || len(cfg.AutoProfiles) == 0 {
+		return
+	}
+	// time conditions still work without NetworkManager
+	state, err := networkState(conn)
+	if err != nil {
+		logger.Debugf("[profile] get network state failed, err: %v", err)
+	}
+	name := cfg.MatchProfile(state, time.Now())
+	if name == m.autoProfile {
+		return
+	}
+	m.autoProfile = name
+	if name == "" || name == cfg.ActiveProfile {
+		return
+	}
+	logger.Infof("[profile] conditions of profile %s matched, network: %+v", name, state)
+	err = m.activateProfile(name, true)
+	if err != nil {
+		logger.Warningf("[profile] activate profile %s failed, err: %v", name, err)
+	}
+}
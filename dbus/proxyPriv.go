@@ -10,20 +10,24 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"sync"
 
 	"github.com/godbus/dbus"
+	cgroupBPF "github.com/linuxdeepin/deepin-network-proxy/cgroup_bpf"
 	com "github.com/linuxdeepin/deepin-network-proxy/com"
 	config "github.com/linuxdeepin/deepin-network-proxy/config"
 	define "github.com/linuxdeepin/deepin-network-proxy/define"
 	IpRoute "github.com/linuxdeepin/deepin-network-proxy/ip_route"
+	logging "github.com/linuxdeepin/deepin-network-proxy/logging"
 	newCGroups "github.com/linuxdeepin/deepin-network-proxy/new_cgroups"
 	newIptables "github.com/linuxdeepin/deepin-network-proxy/new_iptables"
+	pac "github.com/linuxdeepin/deepin-network-proxy/pac"
+	rule "github.com/linuxdeepin/deepin-network-proxy/rule"
 	tProxy "github.com/linuxdeepin/deepin-network-proxy/tproxy"
 	"github.com/linuxdeepin/go-lib/dbusutil"
-	"github.com/linuxdeepin/go-lib/log"
 )
 
-var logger *log.Logger
+var logger *logging.Logger
 
 const (
 	BusServiceName = "com.deepin.system.proxy"
@@ -44,6 +48,18 @@ type proxyPrv struct {
 	// proxy message
 	Proxies config.ScopeProxies
 	Proxy   config.Proxy // current proxy
+	// proto of current proxy, proxy is replaced when config reloaded
+	proto     string
+	proxyLock sync.Mutex
+	// proxies of apps, map[exe path or app id]
+	appProxies map[string]appProxy
+	// proxies chosen by least latency strategy, by proto/name
+	balanced map[string]appProxy
+	// pid of last app found by socket
+	ownerHint string
+	// proxy auto-config and proxies of config by server addr
+	pac        *pac.Script
+	pacProxies map[string]config.Proxy
 
 	// if proxy opened
 	Enabled bool
@@ -51,27 +67,79 @@ type proxyPrv struct {
 	// handler manager
 	manager *Manager
 
-	// listener
-	tcpHandler net.Listener
-	udpHandler net.PacketConn
+	// listeners of each family, and if tcp listener is closed unexpectedly
+	tcpHandlers  []net.Listener
+	udpHandlers  []net.PacketConn
+	listenBroken int32
 
 	// cgroup controller
 	controller *newCGroups.Controller
+	// watch membership of cgroup changed by others
+	cgroupWatcher *newCGroups.Watcher
 
 	// iptables chain rule slice[3]
 	chains [2]*newIptables.Chain
-
-	// route rule
-	ipRule *IpRoute.Rule
+	// the same chains of ip6tables, nil if ipv6 is not proxied
+	chains6 [2]*newIptables.Chain
+	// mss clamp rule installed
+	mssRule *newIptables.CompleteRule
+	// chain redirects network namespaces of procs, and addrs of them
+	netnsLock    sync.Mutex
+	netnsChain   *newIptables.Chain
+	netnsSources []string
+	// cgroup programs of bpf backend
+	bpfRedirector *cgroupBPF.Redirector
+
+	// route rule of ipv4 and ipv6
+	ipRule  *IpRoute.Rule
+	ipRule6 *IpRoute.Rule
 
 	// handler manager
 	handlerMgr *tProxy.HandlerMgr
+	// tunnels of scope
+	tunnelLimit *tProxy.TunnelLimiter
 
 	dnsProxy *proxyDNS
 
-	// handler
-	uid uint32
-	gid uint32
+	// full cone nat of socks5 udp, replaced by start and closed after drain
+	udpLock sync.Mutex
+	udpNat  *tProxy.UdpNatTable
+	// workers relay packages of nat sessions
+	udpPool *tProxy.UdpWorkerPool
+	// workers relay packages of flows bypassed and of masque
+	udpFlowPool *tProxy.UdpWorkerPool
+	// packages of flows waiting tunnel created, key is flow
+	udpPendingLock sync.Mutex
+	udpPending     map[tProxy.HandlerKey][][]byte
+	// drain running in background after stop, and close cut to cut tunnels at once
+	drainLock sync.Mutex
+	drainDone chan struct{}
+	drainCut  chan struct{}
+
+	// destinations dont use proxy
+	ruleLock  sync.Mutex
+	bypass    *rule.Bypass
+	geoIP     *rule.GeoIP
+	geoIPPath string
+	// cidr of bypass rules matched by kernel, nil if ipset not support
+	bypassSet *newIptables.IPSet
+
+	// drop traffic when proxy server is unreachable
+	killLock   sync.Mutex
+	killSwitch bool
+	// interception is paused while user logs in captive portal
+	pauseLock sync.Mutex
+	paused    int32
+
+	// clients watch signals of connections, and id of last connection reported
+	watchLock    sync.Mutex
+	connWatchers map[string]bool
+	connSeq      uint64
+
+	// user started proxy, and logind session or user of it
+	uid     uint32
+	gid     uint32
+	session dbus.ObjectPath
 
 	// stop chan
 	// stop bool
@@ -80,10 +148,13 @@ type proxyPrv struct {
 // init proxy private
 func initProxyPrv(scope define.Scope, priority define.Priority) *proxyPrv {
 	prv := &proxyPrv{
-		scope:      scope,
-		priority:   priority,
-		handlerMgr: tProxy.NewHandlerMgr(scope),
+		scope:       scope,
+		priority:    priority,
+		handlerMgr:  tProxy.NewHandlerMgr(scope),
+		tunnelLimit: tProxy.NewTunnelLimiter(0),
 		// stop:       true,
+		connWatchers: make(map[string]bool),
+		udpPending:   make(map[tProxy.HandlerKey][][]byte),
 		Proxies: config.ScopeProxies{
 			Proxies:      make(map[string][]config.Proxy),
 			ProxyProgram: []string{},
@@ -103,63 +174,120 @@ func (mgr *proxyPrv) startRedirect() error {
 	// make sure manager start init
 	mgr.manager.Start()
 
-	// create cgroups
-	err := mgr.createCGroupController()
+	// steps not depending on each other run at the same time
+	report, err := com.RunGraph(mgr.redirectSteps())
+	mgr.observeSetup(report)
 	if err != nil {
-		logger.Warning("[%s] create cgroup failed, err: %v", mgr.scope, err)
+		logger.Warningf("[%s] start redirect failed, %s, err: %v", mgr.scope, report, err)
+		return err
 	}
+	logger.Infof("[%s] start redirect success, %s", mgr.scope, report)
+	return nil
+}
 
-	// create iptables
-	err = mgr.createTable()
-	if err != nil {
-		logger.Warning("[%s] create iptables failed, err: %v", mgr.scope, err)
-		return err
+// steps of redirect, rules resolve cgroup path and mark, procs are moved in once redirect is ready
+func (mgr *proxyPrv) redirectSteps() []com.Step {
+	steps := []com.Step{
+		// procs are not proxied if cgroup failed, but rules still work for others
+		{Name: "cgroup", Optional: true, Run: mgr.createCGroupController},
 	}
-	err = mgr.appendRule()
-	if err != nil {
-		logger.Warning("[%s] append iptables failed, err: %v", mgr.scope, err)
-		return err
+	procsAfter := []string{"cgroup"}
+	if mgr.bpfMode() {
+		// programs are attached before procs are moved in, bpf backend uses no mark
+		steps = append(steps, com.Step{Name: "bpf", After: []string{"cgroup"}, Run: mgr.attachBPF})
+		procsAfter = append(procsAfter, "bpf")
+	} else {
+		steps = append(steps,
+			// mark of vpn and other tools should not be used
+			com.Step{Name: "mark", Run: mgr.allocMark},
+			// cidr of bypass is returned by kernel, proxy still checks bypass if set failed
+			com.Step{Name: "bypass-set", Run: func() error {
+				mgr.createBypassSet()
+				return nil
+			}},
+			com.Step{Name: "iptables", After: []string{"mark", "cgroup", "bypass-set"}, Run: func() error {
+				err := mgr.setupScope()
+				if err != nil {
+					mgr.destroyBypassSet()
+				}
+				return err
+			}},
+		)
+		procsAfter = append(procsAfter, "iptables")
+		// nat redirect needs no policy route
+		if !mgr.redirectMode() {
+			steps = append(steps, com.Step{Name: "ip-rule", After: []string{"mark", "iptables"}, Run: mgr.createIpRule})
+		}
 	}
+	// procs started before proxy are moved in
+	steps = append(steps, com.Step{Name: "procs", After: procsAfter, Optional: true, Run: mgr.adoptProcs})
+	return steps
+}
 
-	err = mgr.createIpRule()
-	if err != nil {
-		logger.Warning("[%s] create ip rule failed, err: %v", err)
-		return err
+// move running procs in and watch new ones
+func (mgr *proxyPrv) adoptProcs() error {
+	if mgr.controller == nil {
+		return nil
 	}
-	logger.Debugf("[%s] start tproxy iptables cgroups ipRule success", mgr.scope)
-
-	//// first adjust cgroups
-	//err = mgr.firstAdjustCGroups()
-	//if err != nil {
-	//	logger.Warningf("[%s] first adjust controller failed, err: %v", mgr.scope, err)
-	//	return err
-	//}
-	//logger.Debugf("[%s] first adjust controller success", mgr.scope)
-	return nil
+	err := mgr.firstAdjustCGroups()
+	mgr.loadMatchers()
+	mgr.startWatchCGroup()
+	return err
+}
+
+// log failed optional steps and record cost of steps
+func (mgr *proxyPrv) observeSetup(report com.GraphReport) {
+	for _, step := range report.Steps {
+		if step.Skipped {
+			continue
+		}
+		if step.Err != nil {
+			logger.Warningf("[%s] redirect step %s failed, err: %v", mgr.scope, step.Name, step.Err)
+		}
+		setupSeconds.Observe(step.Cost.Seconds(), step.Name)
+	}
+	setupSeconds.Observe(report.Total.Seconds(), "total")
 }
 
 //
 func (mgr *proxyPrv) stopRedirect() error {
-	// release iptables rules
-	err := mgr.releaseRule()
-	if err != nil {
-		logger.Warningf("[%s] release iptables failed, err: %v", mgr.scope, err)
-		return err
+	if mgr.bpfMode() {
+		// detach programs, connect is not redirected any more
+		err := mgr.detachBPF()
+		if err != nil {
+			logger.Warningf("[%s] detach bpf redirect failed, err: %v", mgr.scope, err)
+		}
+	} else {
+		// release iptables rules
+		err := mgr.releaseRule()
+		if err != nil {
+			logger.Warningf("[%s] release iptables failed, err: %v", mgr.scope, err)
+			return err
+		}
+
+		// set is in use until rules are removed
+		mgr.destroyBypassSet()
 	}
 
 	_ = mgr.attachBackUser()
 
 	// release cgroups
-	err = mgr.releaseController()
+	mgr.stopWatchCGroup()
+	err := mgr.releaseController()
 	if err != nil {
 		logger.Warningf("[%s] release controller failed, err: %v", mgr.scope, err)
 		return err
 	}
 
-	err = mgr.releaseIpRule()
-	if err != nil {
-		logger.Warningf("[%s] release ipRule failed, err: %v", mgr.scope, err)
+	if mgr.ipRule != nil || mgr.ipRule6 != nil {
+		err = mgr.releaseIpRule()
+		if err != nil {
+			logger.Warningf("[%s] release ipRule failed, err: %v", mgr.scope, err)
+		}
+		mgr.ipRule = nil
+		mgr.ipRule6 = nil
 	}
+	mgr.manager.markAllocator.Release(mgr.scope)
 
 	// try to release manager
 	err = mgr.manager.release()
@@ -176,6 +304,7 @@ func (mgr *proxyPrv) stopRedirect() error {
 func (mgr *proxyPrv) loadConfig() {
 	// load proxy from manager
 	mgr.Proxies, _ = mgr.manager.config.GetScopeProxies(mgr.scope)
+	mgr.updateTunnelLimit()
 	logger.Debugf("[%s] load config success, config: %v", mgr.scope, mgr.Proxies)
 }
 
@@ -185,8 +314,12 @@ func (mgr *proxyPrv) saveManager(manager *Manager) {
 
 // write config
 func (mgr *proxyPrv) writeConfig() error {
-	// set and write config
-	mgr.manager.config.SetScopeProxies(mgr.scope, mgr.Proxies)
+	// set and write config, white list is changed under proxy lock
+	mgr.proxyLock.Lock()
+	proxies := mgr.Proxies
+	mgr.proxyLock.Unlock()
+	mgr.manager.config.SetScopeProxies(mgr.scope, proxies)
+	mgr.manager.checkConflicts()
 	err := mgr.manager.WriteConfig()
 	if err != nil {
 		logger.Warning("[%s] write config failed, err:%v", mgr.scope, err)
@@ -231,7 +364,11 @@ func (mgr *proxyPrv) GetCGroups() (string, *dbus.Error) {
 }
 
 // add pid to proc
-func (mgr *proxyPrv) AddProc(pid int32) *dbus.Error {
+func (mgr *proxyPrv) AddProc(sender dbus.Sender, pid int32) *dbus.Error {
+	dErr := mgr.authorize(sender, actionConfig)
+	if dErr != nil {
+		return dErr
+	}
 	// controller
 	if mgr.controller == nil {
 		return dbusutil.ToError(errors.New("controller not exist"))
@@ -242,10 +379,24 @@ func (mgr *proxyPrv) AddProc(pid int32) *dbus.Error {
 		logger.Debugf("attach %d to %s failed, err: %v", pid, mgr.controller.GetControlPath(), err)
 		return dbusutil.ToError(err)
 	}
+	mgr.controller.MarkAttached(strconv.Itoa(int(pid)))
 	logger.Debugf("attach %d to %s success", pid, mgr.controller.GetControlPath())
 	return nil
 }
 
+// procs in cgroup of scope, with exe path, pid, start and attach time
+func (mgr *proxyPrv) GetProxiedProcesses() ([]newCGroups.ProxiedProc, *dbus.Error) {
+	if mgr.controller == nil {
+		return []newCGroups.ProxiedProc{}, nil
+	}
+	procs, err := mgr.controller.ListProcs()
+	if err != nil {
+		logger.Warningf("[%s] list proxied procs failed, err: %v", mgr.scope, err)
+		return nil, dbusutil.ToError(err)
+	}
+	return procs, nil
+}
+
 //func (mgr *proxyPrv) CreateCGroups(sender dbus.Sender, cgroup string) *dbus.Error {
 //	con, err := dbusutil.NewSystemService()
 //	if err != nil {
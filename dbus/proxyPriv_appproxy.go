@@ -0,0 +1,153 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"net"
+	"strings"
+
+	"github.com/godbus/dbus"
+	com "github.com/linuxdeepin/deepin-network-proxy/com"
+	config "github.com/linuxdeepin/deepin-network-proxy/config"
+	define "github.com/linuxdeepin/deepin-network-proxy/define"
+	newCGroups "github.com/linuxdeepin/deepin-network-proxy/new_cgroups"
+	tProxy "github.com/linuxdeepin/deepin-network-proxy/tproxy"
+	"github.com/linuxdeepin/go-lib/dbusutil"
+)
+
+// tcp of app listed in app-proxies dials its own proxy, app is found by socket of connection,
+// exe path is matched first, then flatpak app id or snap name.
+// connection of app not found or not listed uses proxy of scope.
+
+// proxy assigned to app
+type appProxy struct {
+	proxyTyp tProxy.ProtoTyp
+	proxy    config.Proxy
+}
+
+// proto of proxies map to handler type, the same as StartProxy
+func buildProxyProto(proto string) (tProxy.ProtoTyp, error) {
+	if proto == "socks5" {
+		return tProxy.SOCKS5TCP, nil
+	}
+	return tProxy.BuildProto(proto)
+}
+
+// build proxies of apps from config, app with invalid proxy uses proxy of scope
+func (mgr *proxyPrv) loadAppProxies() {
+	table := make(map[string]appProxy)
+	for app, key := range mgr.Proxies.AppProxies {
+		proto, name, _ := strings.Cut(key, "/")
+		proxyTyp, err := buildProxyProto(proto)
+		if err != nil {
+			logger.Warningf("[%s] proxy %s of app %s is invalid, err: %v", mgr.scope, key, app, err)
+			continue
+		}
+		proxy, err := mgr.Proxies.GetProxy(proto, name)
+		if err != nil {
+			logger.Warningf("[%s] proxy %s of app %s not found, err: %v", mgr.scope, key, app, err)
+			continue
+		}
+		proxy, err = mgr.resolveSecret(proxy)
+		if err != nil {
+			logger.Warningf("[%s] get password of proxy %s failed, err: %v", mgr.scope, key, err)
+			continue
+		}
+		table[app] = appProxy{proxyTyp: proxyTyp, proxy: proxy}
+	}
+	mgr.proxyLock.Lock()
+	mgr.appProxies = table
+	mgr.proxyLock.Unlock()
+	logger.Debugf("[%s] load %d app proxies", mgr.scope, len(table))
+}
+
+// app which makes connection from local to peer, nil if not found,
+// owner is only looked up when apps have own proxies, connections are watched or counted
+func (mgr *proxyPrv) connOwner(local net.Addr, peer net.Addr) *newCGroups.SocketOwner {
+	mgr.proxyLock.Lock()
+	count, hint := len(mgr.appProxies), mgr.ownerHint
+	mgr.proxyLock.Unlock()
+	if count == 0 && !mgr.watchingConns() && mgr.manager.statsRecorder() == nil && mgr.auditLog() == nil {
+		return nil
+	}
+	lAddr, ok := local.(*net.TCPAddr)
+	if !ok {
+		return nil
+	}
+	rAddr, ok := peer.(*net.TCPAddr)
+	if !ok {
+		return nil
+	}
+	info, err := com.LookupTcpSocket(lAddr, rAddr)
+	if err != nil {
+		logger.Debugf("[%s] look up socket of %s failed, err: %v", mgr.scope, lAddr, err)
+		return nil
+	}
+	var owner *newCGroups.SocketOwner
+	if mgr.scope == define.Global || mgr.controller == nil {
+		owner, err = newCGroups.FindSocketOwner(newCGroups.ProcRoot, info.Inode, hint)
+	} else {
+		owner, err = mgr.controller.FindSocketOwner(newCGroups.ProcRoot, info.Inode, hint)
+	}
+	if err != nil {
+		logger.Debugf("[%s] find owner of socket %d failed, err: %v", mgr.scope, info.Inode, err)
+		return nil
+	}
+	mgr.proxyLock.Lock()
+	mgr.ownerHint = owner.Pid
+	mgr.proxyLock.Unlock()
+	return owner
+}
+
+// proxy of app, false if app has no own proxy
+func (mgr *proxyPrv) appProxyOf(owner *newCGroups.SocketOwner) (appProxy, bool) {
+	if owner == nil {
+		return appProxy{}, false
+	}
+	mgr.proxyLock.Lock()
+	defer mgr.proxyLock.Unlock()
+	if proxy, ok := mgr.appProxies[owner.ExecPath]; ok {
+		return proxy, true
+	}
+	if owner.AppID != "" {
+		proxy, ok := mgr.appProxies[owner.AppID]
+		return proxy, ok
+	}
+	return appProxy{}, false
+}
+
+// assign proxy to app by exe path or app id, empty key removes assignment
+func (mgr *proxyPrv) SetAppProxy(sender dbus.Sender, app string, key string) *dbus.Error {
+	dErr := mgr.authorize(sender, actionConfig)
+	if dErr != nil {
+		return dErr
+	}
+	proxies := mgr.Proxies
+	appProxies := make(map[string]string)
+	for k, v := range proxies.AppProxies {
+		appProxies[k] = v
+	}
+	if key == "" {
+		delete(appProxies, app)
+	} else {
+		appProxies[app] = key
+	}
+	proxies.AppProxies = appProxies
+	cfg := &config.ProxyConfig{AllProxies: map[string]config.ScopeProxies{mgr.scope.String(): proxies}}
+	err := cfg.Validate()
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+	mgr.Proxies = proxies
+	mgr.updateTunnelLimit()
+	if mgr.Enabled {
+		mgr.loadAppProxies()
+	}
+	err = mgr.writeConfig()
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+	return nil
+}
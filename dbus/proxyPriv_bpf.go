@@ -0,0 +1,68 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"errors"
+	"net"
+
+	cgroupBPF "github.com/linuxdeepin/deepin-network-proxy/cgroup_bpf"
+)
+
+// bpf backend redirects connect of procs in scope cgroup to t-proxy listener by programs attached to cgroup,
+// no iptables rule or policy route is added, origin destination is kept in bpf map.
+// only tcp is redirected, kill switch and dns redirect depend on iptables and are not available.
+
+// how traffic is intercepted
+const (
+	InterceptIptables = "iptables" // tproxy or nat redirect rules
+	InterceptBPF      = "bpf"      // cgroup connect programs, kernel 5.6 or later
+)
+
+// check if intercept by cgroup bpf
+func (mgr *proxyPrv) bpfMode() bool {
+	return mgr.manager != nil && mgr.manager.isBPFMode()
+}
+
+// load programs and attach to cgroup of scope
+func (mgr *proxyPrv) attachBPF() error {
+	if mgr.controller == nil {
+		return errors.New("cgroup controller is not created")
+	}
+	redirector, err := cgroupBPF.NewRedirector(mgr.Proxies.TPort, !mgr.Proxies.DisableIPv4, !mgr.Proxies.DisableIPv6)
+	if err != nil {
+		return err
+	}
+	err = redirector.Attach(mgr.controller.GetCGroupPath())
+	if err != nil {
+		redirector.Close()
+		return err
+	}
+	mgr.bpfRedirector = redirector
+	mgr.manager.bpfAttached++
+	logger.Debugf("[%s] attach bpf redirect success", mgr.scope)
+	return nil
+}
+
+// detach programs from cgroup of scope and free them
+func (mgr *proxyPrv) detachBPF() error {
+	if mgr.bpfRedirector == nil {
+		return nil
+	}
+	err := mgr.bpfRedirector.Detach()
+	mgr.bpfRedirector.Close()
+	mgr.bpfRedirector = nil
+	mgr.manager.bpfAttached--
+	return err
+}
+
+// get origin destination of conn redirected by bpf
+func (mgr *proxyPrv) bpfOriginalDst(lConn net.Conn) (net.Addr, error) {
+	redirector := mgr.bpfRedirector
+	if redirector == nil {
+		return nil, errors.New("bpf redirect is not attached")
+	}
+	return redirector.OriginalDst(lConn.RemoteAddr())
+}
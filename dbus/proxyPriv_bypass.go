@@ -0,0 +1,207 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"net"
+
+	"github.com/godbus/dbus"
+	com "github.com/linuxdeepin/deepin-network-proxy/com"
+	newIptables "github.com/linuxdeepin/deepin-network-proxy/new_iptables"
+	rule "github.com/linuxdeepin/deepin-network-proxy/rule"
+	tProxy "github.com/linuxdeepin/deepin-network-proxy/tproxy"
+	"github.com/linuxdeepin/go-lib/dbusutil"
+)
+
+// rules of white list, list is changed by dbus calls at the same time, read it under proxy lock
+func (mgr *proxyPrv) whiteList() []string {
+	mgr.proxyLock.Lock()
+	defer mgr.proxyLock.Unlock()
+	return mgr.Proxies.WhiteList
+}
+
+// build bypass rules from white list
+func (mgr *proxyPrv) loadBypass() error {
+	bypass, err := rule.NewBypass(mgr.whiteList())
+	if err != nil {
+		logger.Warningf("[%s] parse bypass rules failed, err: %v", mgr.scope, err)
+		return err
+	}
+	// geoip database is opened only when geoip rule exist
+	if bypass.NeedGeoIP() {
+		geoIP, err := mgr.openGeoIP()
+		if err != nil {
+			logger.Warningf("[%s] open geoip database failed, geoip rule is ignored, err: %v", mgr.scope, err)
+		}
+		bypass.SetGeoIP(geoIP)
+	}
+	mgr.ruleLock.Lock()
+	mgr.bypass = bypass
+	bypassSet := mgr.bypassSet
+	mgr.ruleLock.Unlock()
+	// only changed cidr is updated
+	if bypassSet != nil {
+		err = bypassSet.Sync(bypass.IPv4CIDRs())
+		if err != nil {
+			logger.Warningf("[%s] sync bypass set failed, err: %v", mgr.scope, err)
+		}
+	}
+	return nil
+}
+
+// name of bypass set, the same prefix as scope chain
+func (mgr *proxyPrv) bypassSetName() string {
+	return mgr.chainName() + "_bypass"
+}
+
+// create bypass set and fill with cidr of bypass rules
+func (mgr *proxyPrv) createBypassSet() {
+	if !newIptables.IPSetSupported() {
+		logger.Debugf("[%s] ipset not support, bypass is checked by proxy only", mgr.scope)
+		return
+	}
+	bypassSet, err := newIptables.CreateIPSet(mgr.bypassSetName())
+	if err != nil {
+		logger.Warningf("[%s] create bypass set failed, err: %v", mgr.scope, err)
+		return
+	}
+	mgr.ruleLock.Lock()
+	bypass := mgr.bypass
+	mgr.bypassSet = bypassSet
+	mgr.ruleLock.Unlock()
+	if bypass != nil {
+		err = bypassSet.Sync(bypass.IPv4CIDRs())
+		if err != nil {
+			logger.Warningf("[%s] sync bypass set failed, err: %v", mgr.scope, err)
+		}
+	}
+}
+
+// destroy bypass set, should be called after scope chain is removed
+func (mgr *proxyPrv) destroyBypassSet() {
+	mgr.ruleLock.Lock()
+	bypassSet := mgr.bypassSet
+	mgr.bypassSet = nil
+	mgr.ruleLock.Unlock()
+	if bypassSet == nil {
+		return
+	}
+	_ = bypassSet.Destroy()
+}
+
+// iptables -t mangle -A App -m set --match-set App_bypass dst -j RETURN
+func (mgr *proxyPrv) bypassSetRule() *newIptables.CompleteRule {
+	return &newIptables.CompleteRule{
+		Action:    newIptables.RETURN,
+		ExtendsSl: []newIptables.ExtendsRule{newIptables.SetRule(mgr.bypassSetName(), false)},
+	}
+}
+
+// open geoip database, database is reused until path is changed
+func (mgr *proxyPrv) openGeoIP() (*rule.GeoIP, error) {
+	path := mgr.Proxies.GeoIPDB
+	if path == "" {
+		path = rule.DefaultGeoIPPath
+	}
+	mgr.ruleLock.Lock()
+	defer mgr.ruleLock.Unlock()
+	if mgr.geoIP != nil && mgr.geoIPPath == path {
+		return mgr.geoIP, nil
+	}
+	geoIP, err := rule.OpenGeoIP(path)
+	if err != nil {
+		return nil, err
+	}
+	// old database may still be used by running connection, dont close it
+	mgr.geoIP = geoIP
+	mgr.geoIPPath = path
+	return geoIP, nil
+}
+
+// check if remote addr should connect directly
+func (mgr *proxyPrv) isBypass(addr net.Addr) bool {
+	return mgr.matchedBypass(addr) != ""
+}
+
+// bypass rule matched by remote addr, empty if none
+func (mgr *proxyPrv) matchedBypass(addr net.Addr) string {
+	mgr.ruleLock.Lock()
+	bypass := mgr.bypass
+	mgr.ruleLock.Unlock()
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return bypass.MatchedIP(addr.IP, addr.Port)
+	case *net.UDPAddr:
+		return bypass.MatchedIP(addr.IP, addr.Port)
+	case *tProxy.DomainAddr:
+		return bypass.MatchedDomain(addr.Domain, addr.Port)
+	default:
+		return ""
+	}
+}
+
+// add bypass rules, such as 10.0.0.0/8 baidu.com port:22
+func (mgr *proxyPrv) AddBypass(sender dbus.Sender, rules []string) *dbus.Error {
+	dErr := mgr.authorize(sender, actionConfig)
+	if dErr != nil {
+		return dErr
+	}
+	// check all rules first
+	for _, elem := range rules {
+		err := rule.CheckRule(elem)
+		if err != nil {
+			logger.Warningf("[%s] bypass rule %s is invalid, err: %v", mgr.scope, elem, err)
+			return dbusutil.ToError(err)
+		}
+	}
+	// list is replaced instead of appended, readers may still hold old one
+	mgr.proxyLock.Lock()
+	whiteList := append([]string{}, mgr.Proxies.WhiteList...)
+	for _, elem := range rules {
+		// check if already exist
+		if com.MegaExist(whiteList, elem) {
+			continue
+		}
+		whiteList = append(whiteList, elem)
+	}
+	mgr.Proxies.WhiteList = whiteList
+	mgr.proxyLock.Unlock()
+	err := mgr.loadBypass()
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+	err = mgr.writeConfig()
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+	return nil
+}
+
+// remove bypass rules
+func (mgr *proxyPrv) RemoveBypass(sender dbus.Sender, rules []string) *dbus.Error {
+	dErr := mgr.authorize(sender, actionConfig)
+	if dErr != nil {
+		return dErr
+	}
+	mgr.proxyLock.Lock()
+	var whiteList []string
+	for _, elem := range mgr.Proxies.WhiteList {
+		if com.MegaExist(rules, elem) {
+			continue
+		}
+		whiteList = append(whiteList, elem)
+	}
+	mgr.Proxies.WhiteList = whiteList
+	mgr.proxyLock.Unlock()
+	err := mgr.loadBypass()
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+	err = mgr.writeConfig()
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+	return nil
+}
@@ -5,7 +5,9 @@
 package DBus
 
 import (
+	com "github.com/linuxdeepin/deepin-network-proxy/com"
 	define "github.com/linuxdeepin/deepin-network-proxy/define"
+	newCGroups "github.com/linuxdeepin/deepin-network-proxy/new_cgroups"
 )
 
 func (mgr *proxyPrv) getCGroupPriority() define.Priority {
@@ -30,6 +32,8 @@ func (mgr *proxyPrv) firstAdjustCGroups() error {
 		return err
 	}
 
+	// procs of paths not in other controllers, attached together
+	fresh := make(map[string]newCGroups.ControlProcSl)
 	// range map
 	for _, path := range mgr.Proxies.ProxyProgram {
 		// check if already exist
@@ -43,22 +47,147 @@ func (mgr *proxyPrv) firstAdjustCGroups() error {
 				logger.Debugf("[%s] add proc %s from %s at first failed", mgr.scope, path, controller.Name)
 			}
 
-		} else {
-			// not exist
-			procSl, ok := procsMap[path]
-			// if has current proc slice
-			if ok {
-				err := mgr.controller.MoveIn(path, procSl)
-				if err != nil {
-					logger.Warning("[%s] add procs %s at first failed, err: %v", mgr.scope, path, err)
-					continue
-				}
-				logger.Debugf("[%s] add procs %s at first success", mgr.scope, path)
-			}
+		} else if procSl, ok := procsMap[path]; ok {
+			// not exist, if has current proc slice
+			fresh[path] = procSl
 		}
 		// add path to path slice
 		mgr.controller.AddCtlAppPath(path)
 	}
 
+	err = mgr.controller.MoveInAll(fresh)
+	if err != nil {
+		logger.Warningf("[%s] add procs of %d paths at first failed, err: %v", mgr.scope, len(fresh), err)
+		return nil
+	}
+	logger.Debugf("[%s] add procs of %d paths at first success", mgr.scope, len(fresh))
 	return nil
 }
+
+// move running procs of control paths in, called when control paths change
+func (mgr *proxyPrv) sweepCGroups() {
+	if !mgr.Enabled || mgr.controller == nil {
+		return
+	}
+	err := mgr.controller.Sweep()
+	if err != nil {
+		logger.Warningf("[%s] sweep procs failed, err: %v", mgr.scope, err)
+	}
+}
+
+// add match specs to controller and move procs matched in
+func (mgr *proxyPrv) loadMatchers() {
+	for _, spec := range mgr.Proxies.MatchSpecs {
+		matcher, err := newCGroups.NewProcMatcher(spec)
+		if err != nil {
+			logger.Warningf("[%s] match spec %v is invalid, err: %v", mgr.scope, spec, err)
+			continue
+		}
+		mgr.controller.AddMatcher(matcher)
+	}
+	err := mgr.controller.Sweep()
+	if err != nil {
+		logger.Warningf("[%s] sweep procs failed, err: %v", mgr.scope, err)
+	}
+}
+
+// watch cgroup of scope, membership changed by other agents is repaired
+func (mgr *proxyPrv) startWatchCGroup() {
+	if mgr.controller == nil {
+		return
+	}
+	watcher, err := mgr.controller.Watch(newCGroups.WatchHandler{
+		OnChange:  mgr.onCGroupChange,
+		OnRemoved: mgr.onCGroupRemoved,
+	})
+	if err != nil {
+		logger.Warningf("[%s] watch cgroups failed, err: %v", mgr.scope, err)
+		return
+	}
+	mgr.cgroupWatcher = watcher
+}
+
+// stop watch cgroup, before cgroup is removed by daemon
+func (mgr *proxyPrv) stopWatchCGroup() {
+	if mgr.cgroupWatcher == nil {
+		return
+	}
+	mgr.cgroupWatcher.Close()
+	mgr.cgroupWatcher = nil
+}
+
+// procs moved out by others are attached back, exited procs are dropped
+func (mgr *proxyPrv) onCGroupChange(added []string, removed []string) {
+	if len(removed) == 0 || mgr.controller == nil {
+		return
+	}
+	_, err := mgr.controller.Audit()
+	if err != nil {
+		logger.Warningf("[%s] audit cgroups failed, err: %v", mgr.scope, err)
+	}
+}
+
+// cgroup removed by others is created again, iptables resolves cgroup path when rule is added,
+// so rules match cgroup are added again, programs of bpf backend are detached with cgroup and attached again
+func (mgr *proxyPrv) onCGroupRemoved() {
+	if !mgr.Enabled || mgr.controller == nil {
+		return
+	}
+	err := com.GuaranteeDir(mgr.controller.GetControlPath())
+	if err != nil {
+		logger.Warningf("[%s] create cgroups again failed, err: %v", mgr.scope, err)
+		return
+	}
+	_, err = mgr.controller.Audit()
+	if err != nil {
+		logger.Warningf("[%s] audit cgroups failed, err: %v", mgr.scope, err)
+	}
+	if mgr.bpfMode() {
+		_ = mgr.detachBPF()
+		err = mgr.attachBPF()
+	} else {
+		_ = mgr.releaseRule()
+		err = mgr.setupScope()
+	}
+	if err != nil {
+		logger.Warningf("[%s] add rules of cgroups again failed, err: %v", mgr.scope, err)
+		return
+	}
+	logger.Infof("[%s] cgroups and rules are restored", mgr.scope)
+}
+
+// move running procs of exe in, when proxy is running
+func (mgr *proxyPrv) addTarget(exe string) {
+	if !mgr.Enabled || mgr.controller == nil {
+		return
+	}
+	count, err := mgr.controller.AddTgtExe(exe)
+	if err != nil {
+		logger.Warningf("[%s] add target %s failed, err: %v", mgr.scope, exe, err)
+	}
+	mgr.emitTargetChanged(exe, true, count)
+}
+
+// move running procs of exe out, when proxy is running
+func (mgr *proxyPrv) removeTarget(exe string) {
+	if !mgr.Enabled || mgr.controller == nil {
+		return
+	}
+	count, err := mgr.controller.RemoveTgtExe(exe)
+	if err != nil {
+		logger.Warningf("[%s] remove target %s failed, err: %v", mgr.scope, exe, err)
+	}
+	mgr.emitTargetChanged(exe, false, count)
+}
+
+// report target change to front end, count is procs moved in or out
+func (mgr *proxyPrv) emitTargetChanged(exe string, added bool, count int) {
+	if mgr.manager == nil || mgr.manager.sysService == nil {
+		return
+	}
+	err := mgr.manager.sysService.Conn().Emit(mgr.getDBusPath(), mgr.GetInterfaceName()+".TargetChanged",
+		exe, added, int32(count))
+	if err != nil {
+		logger.Warningf("[%s] emit target changed signal failed, err: %v", mgr.scope, err)
+	}
+}
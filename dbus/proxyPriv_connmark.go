@@ -0,0 +1,67 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"errors"
+
+	newIptables "github.com/linuxdeepin/deepin-network-proxy/new_iptables"
+)
+
+// mark of proxy flow is saved to conntrack, and restored for reply and related packages,
+// related flow like ftp data and icmp error inherits conntrack mark and is routed to t-proxy too.
+
+// iptables -t mangle -A App -j CONNMARK --save-mark
+func (mgr *proxyPrv) connMarkSaveRule() *newIptables.CompleteRule {
+	return &newIptables.CompleteRule{
+		Action: newIptables.CONNMARK,
+		BaseSl: []newIptables.BaseRule{
+			{
+				Match: "-save-mark",
+			},
+		},
+	}
+}
+
+// iptables -t mangle -I OUTPUT -m connmark --mark $Mark -j CONNMARK --restore-mark
+func (mgr *proxyPrv) connMarkRestoreRule() *newIptables.CompleteRule {
+	return &newIptables.CompleteRule{
+		Action: newIptables.CONNMARK,
+		BaseSl: []newIptables.BaseRule{
+			{
+				Match: "-restore-mark",
+			},
+		},
+		ExtendsSl: []newIptables.ExtendsRule{
+			{
+				Match: "m",
+				Elem: newIptables.ExtendsElem{
+					Match: "connmark",
+					Base:  newIptables.BaseRule{Match: "mark", Param: mgr.fwmark()},
+				},
+			},
+		},
+	}
+}
+
+// restore mark before main chain, so that packages not matched by scope chain are marked too
+func (mgr *proxyPrv) addConnMarkRestore(iptablesMgr *newIptables.Manager) error {
+	chain := iptablesMgr.GetChain("mangle", "OUTPUT")
+	if chain == nil {
+		logger.Warningf("[%s] has no mangle OUTPUT chain", mgr.scope)
+		return errors.New("has no mangle OUTPUT chain")
+	}
+	return chain.InsertRule(0, mgr.connMarkRestoreRule())
+}
+
+// delete restore rule, save rule is removed with scope chain
+func (mgr *proxyPrv) delConnMarkRestore() error {
+	chain := mgr.manager.iptablesMgr.GetChain("mangle", "OUTPUT")
+	if chain == nil {
+		logger.Warningf("[%s] has no mangle OUTPUT chain", mgr.scope)
+		return errors.New("has no mangle OUTPUT chain")
+	}
+	return chain.DelRule(mgr.connMarkRestoreRule())
+}
@@ -0,0 +1,28 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"github.com/godbus/dbus"
+	tProxy "github.com/linuxdeepin/deepin-network-proxy/tproxy"
+)
+
+// handlers of tunnels are kept in connection table of handler manager, dead ones are collected
+// while proxy is started, so that table keeps bounded if close paths are missed.
+
+// handlers in connection table of scope with references, age and idle seconds, oldest first,
+// destinations of all users are listed, so caller is audited as watching connections
+func (mgr *proxyPrv) DumpConnections(sender dbus.Sender) ([]tProxy.ConnEntry, *dbus.Error) {
+	dErr := mgr.authorize(sender, actionAudit)
+	if dErr != nil {
+		return nil, dErr
+	}
+	return mgr.handlerMgr.Dump(), nil
+}
+
+// dead handlers dropped by collecting
+func (mgr *proxyPrv) collectedHandlers() uint64 {
+	return mgr.handlerMgr.Collected()
+}
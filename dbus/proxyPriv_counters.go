@@ -0,0 +1,53 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"errors"
+
+	"github.com/godbus/dbus"
+	com "github.com/linuxdeepin/deepin-network-proxy/com"
+	newCGroups "github.com/linuxdeepin/deepin-network-proxy/new_cgroups"
+	newIptables "github.com/linuxdeepin/deepin-network-proxy/new_iptables"
+	"github.com/linuxdeepin/go-lib/dbusutil"
+)
+
+// packet and byte counters of rules created by daemon, used to verify traffic is matched
+func (mgr *proxyPrv) GetRuleCounters() ([]newIptables.RuleCounter, *dbus.Error) {
+	if mgr.manager.iptablesMgr == nil {
+		return nil, dbusutil.ToError(errors.New("iptables is not initialized"))
+	}
+	counters, err := mgr.manager.iptablesMgr.Counters()
+	if err != nil {
+		logger.Warningf("[%s] get rule counters failed, err: %v", mgr.scope, err)
+		return nil, dbusutil.ToError(err)
+	}
+	// dbus array can not be nil
+	if counters == nil {
+		counters = []newIptables.RuleCounter{}
+	}
+	return counters, nil
+}
+
+// counters of fork tracking and audit of scope cgroup, used to verify children stay in proxy
+func (mgr *proxyPrv) GetTrackCounters() (newCGroups.TrackCounters, *dbus.Error) {
+	if mgr.controller == nil {
+		return newCGroups.TrackCounters{}, dbusutil.ToError(errors.New("controller not exist"))
+	}
+	return mgr.controller.Counters(), nil
+}
+
+// fd usage of daemon, fds leaked by connections show up as growing sockets
+func (mgr *proxyPrv) GetFdUsage() (com.FdUsage, *dbus.Error) {
+	usage, err := com.GetFdUsage()
+	if err != nil {
+		logger.Warningf("[%s] get fd usage failed, err: %v", mgr.scope, err)
+		return usage, dbusutil.ToError(err)
+	}
+	if usage.High {
+		logger.Warningf("[%s] fd usage is high, open: %d, sockets: %d, limit: %d", mgr.scope, usage.Open, usage.Sockets, usage.Limit)
+	}
+	return usage, nil
+}
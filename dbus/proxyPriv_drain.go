@@ -0,0 +1,174 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"errors"
+	"time"
+
+	newIptables "github.com/linuxdeepin/deepin-network-proxy/new_iptables"
+	tProxy "github.com/linuxdeepin/deepin-network-proxy/tproxy"
+)
+
+const (
+	// interval to check if all tunnels finished
+	drainInterval = 200 * time.Millisecond
+	// udp flow has no end, flow without packages for this long does not hold drain
+	drainUdpIdle = 5 * time.Second
+)
+
+// iptables -t mangle -I App -m conntrack --ctstate NEW -j RETURN
+// new connection is not marked any more, while packages of established tunnels are still redirected
+func (mgr *proxyPrv) drainRule() *newIptables.CompleteRule {
+	return &newIptables.CompleteRule{
+		Action: newIptables.RETURN,
+		ExtendsSl: []newIptables.ExtendsRule{
+			{
+				Match: "m",
+				Elem: newIptables.ExtendsElem{
+					Match: "conntrack",
+					Base:  newIptables.BaseRule{Match: "ctstate", Param: "NEW"},
+				},
+			},
+		},
+	}
+}
+
+// stop redirecting new connection
+func (mgr *proxyPrv) stopNewRedirect() error {
+	selfChain := mgr.chains[1]
+	if selfChain == nil {
+		logger.Warningf("[%s] cant add drain rule, chain is nil", mgr.scope)
+		return errors.New("chain is nil")
+	}
+	mgr.insertScopeRule6(mgr.drainRule())
+	return selfChain.InsertRule(0, mgr.drainRule())
+}
+
+// stop redirecting new connection if tunnels are alive, should be called before listeners close,
+// so that new connection is not redirected to port closed. return false if nothing to wait
+func (mgr *proxyPrv) startDrain() bool {
+	timeout := time.Duration(mgr.Proxies.DrainTimeout) * time.Second
+	if timeout <= 0 || mgr.liveTunnels() == 0 {
+		return false
+	}
+	err := mgr.stopNewRedirect()
+	if err != nil {
+		logger.Warningf("[%s] stop redirect new connection failed, err: %v", mgr.scope, err)
+		return false
+	}
+	logger.Debugf("[%s] start drain, tunnels: %d, timeout: %v", mgr.scope, mgr.liveTunnels(), timeout)
+	return true
+}
+
+// wait alive tunnels finish in background until drain timeout, then release proxy,
+// dbus call stopping proxy returns at once
+func (mgr *proxyPrv) drainAsync() {
+	timeout := time.Duration(mgr.Proxies.DrainTimeout) * time.Second
+	done := make(chan struct{})
+	cut := make(chan struct{})
+	mgr.drainLock.Lock()
+	mgr.drainDone = done
+	mgr.drainCut = cut
+	mgr.drainLock.Unlock()
+	go func() {
+		defer close(done)
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		ticker := time.NewTicker(drainInterval)
+		defer ticker.Stop()
+	wait:
+		for mgr.liveTunnels() > 0 {
+			select {
+			case <-ticker.C:
+			case <-timer.C:
+				break wait
+			case <-cut:
+				break wait
+			}
+		}
+		dErr := mgr.releaseProxy()
+		if dErr != nil {
+			logger.Warningf("[%s] release proxy after drain failed, err: %v", mgr.scope, dErr)
+		}
+		mgr.manager.notifyState()
+	}()
+}
+
+// wait drain running finish, tunnels are cut at once if cut,
+// called before proxy starts again, or daemon exits
+func (mgr *proxyPrv) finishDrain(cut bool) {
+	mgr.drainLock.Lock()
+	done, cutCh := mgr.drainDone, mgr.drainCut
+	mgr.drainDone = nil
+	mgr.drainCut = nil
+	mgr.drainLock.Unlock()
+	if done == nil {
+		return
+	}
+	if cut {
+		close(cutCh)
+	}
+	<-done
+}
+
+// close tunnels left after drain, report count of tunnels cut
+func (mgr *proxyPrv) cutTunnels() int {
+	cut := mgr.tunnelCount()
+	mgr.handlerMgr.CloseAll()
+	mgr.handlerMgr.StopCollect()
+	mgr.udpLock.Lock()
+	udpPool, udpFlowPool, udpNat := mgr.udpPool, mgr.udpFlowPool, mgr.udpNat
+	mgr.udpPool = nil
+	mgr.udpFlowPool = nil
+	mgr.udpNat = nil
+	mgr.udpLock.Unlock()
+	if udpFlowPool != nil {
+		udpFlowPool.Close()
+	}
+	if udpPool != nil {
+		udpPool.Close()
+		if dropped := udpPool.Dropped(); dropped > 0 {
+			logger.Infof("[%s] udp packages dropped as relay queue full: %d", mgr.scope, dropped)
+		}
+	}
+	if udpNat != nil {
+		udpNat.Close()
+	}
+	logger.Infof("[%s] drain finished, tunnels cut: %d", mgr.scope, cut)
+	// report to front end
+	if mgr.manager != nil && mgr.manager.sysService != nil {
+		err := mgr.manager.sysService.Conn().Emit(mgr.getDBusPath(), mgr.GetInterfaceName()+".Drained", int32(cut))
+		if err != nil {
+			logger.Warningf("[%s] emit drained signal failed, err: %v", mgr.scope, err)
+		}
+	}
+	return cut
+}
+
+// count of established tunnels
+func (mgr *proxyPrv) tunnelCount() int {
+	count := mgr.handlerMgr.Count()
+	if udpNat := mgr.natTable(); udpNat != nil {
+		count += udpNat.Count()
+	}
+	return count
+}
+
+// count of tunnels drain waits, idle udp flows are not counted
+func (mgr *proxyPrv) liveTunnels() int {
+	count := mgr.handlerMgr.LiveCount(drainUdpIdle)
+	if udpNat := mgr.natTable(); udpNat != nil {
+		count += udpNat.LiveCount(drainUdpIdle)
+	}
+	return count
+}
+
+// nat table of socks5 udp, nil if udp is not proxied
+func (mgr *proxyPrv) natTable() *tProxy.UdpNatTable {
+	mgr.udpLock.Lock()
+	defer mgr.udpLock.Unlock()
+	return mgr.udpNat
+}
@@ -0,0 +1,190 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/godbus/dbus"
+	config "github.com/linuxdeepin/deepin-network-proxy/config"
+	newCGroups "github.com/linuxdeepin/deepin-network-proxy/new_cgroups"
+	stats "github.com/linuxdeepin/deepin-network-proxy/stats"
+	tProxy "github.com/linuxdeepin/deepin-network-proxy/tproxy"
+)
+
+// signals of tcp connections are only emitted when some client watches them,
+// looking up app of each connection scans procs, which is too heavy to do for nobody.
+// id of connection pairs NewProxiedConnection with ConnectionClosed, and is carried by log lines of connection.
+// the same events are counted by stats if enabled, and always by metrics, and written to audit log if scope audits.
+
+// connection reported by signals
+type connEvent struct {
+	id          uint64
+	exe         string
+	pid         string
+	src         string
+	destination string
+	proxy       string
+	start       time.Time // time tunnel starts to create
+	release     func()    // give back slot of tunnel limit
+}
+
+func newConnEvent(owner *newCGroups.SocketOwner, rAddr net.Addr, proxyTyp tProxy.ProtoTyp, proxy config.Proxy) connEvent {
+	event := connEvent{
+		destination: rAddr.String(),
+		proxy:       proxyLabel(proxyTyp, proxy),
+	}
+	if owner != nil {
+		event.exe = owner.ExecPath
+		event.pid = owner.Pid
+	}
+	return event
+}
+
+// proto/name of proxy, proxy chosen by pac may not be in config
+func proxyLabel(proxyTyp tProxy.ProtoTyp, proxy config.Proxy) string {
+	if proxyTyp == tProxy.NoneProto {
+		return "direct"
+	}
+	if proxy.Name != "" {
+		return config.ProxyKey(proxyTyp.String(), proxy.Name)
+	}
+	return config.ProxyKey(proxyTyp.String(), net.JoinHostPort(proxy.Server, strconv.Itoa(proxy.Port)))
+}
+
+// emit signals of connections to caller until unwatch
+func (mgr *proxyPrv) WatchConnections(sender dbus.Sender) *dbus.Error {
+	dErr := mgr.authorize(sender, actionAudit)
+	if dErr != nil {
+		return dErr
+	}
+	mgr.watchLock.Lock()
+	defer mgr.watchLock.Unlock()
+	// clients exited without unwatch are dropped
+	for name := range mgr.connWatchers {
+		if !mgr.nameHasOwner(name) {
+			delete(mgr.connWatchers, name)
+		}
+	}
+	mgr.connWatchers[string(sender)] = true
+	logger.Debugf("[%s] %s watches connections, watchers: %d", mgr.scope, sender, len(mgr.connWatchers))
+	return nil
+}
+
+// stop emitting signals of connections to caller
+func (mgr *proxyPrv) UnwatchConnections(sender dbus.Sender) *dbus.Error {
+	mgr.watchLock.Lock()
+	defer mgr.watchLock.Unlock()
+	delete(mgr.connWatchers, string(sender))
+	return nil
+}
+
+func (mgr *proxyPrv) nameHasOwner(name string) bool {
+	if mgr.manager == nil || mgr.manager.sysService == nil {
+		return false
+	}
+	has, err := mgr.manager.sysService.NameHasOwner(name)
+	return err == nil && has
+}
+
+// id of new connection, unique in scope
+func (mgr *proxyPrv) nextConnID() uint64 {
+	mgr.watchLock.Lock()
+	defer mgr.watchLock.Unlock()
+	mgr.connSeq++
+	return mgr.connSeq
+}
+
+// if any client watches connections
+func (mgr *proxyPrv) watchingConns() bool {
+	mgr.watchLock.Lock()
+	defer mgr.watchLock.Unlock()
+	return len(mgr.connWatchers) > 0
+}
+
+// report and count tunnel established, and closed with bytes relayed
+func (mgr *proxyPrv) trackTunnel(handler tProxy.BaseHandler, event connEvent) {
+	watching := mgr.watchingConns()
+	recorder := mgr.manager.statsRecorder()
+	auditLog := mgr.auditLog()
+	if watching {
+		mgr.emitConnEvent("NewProxiedConnection", event.id, event.exe, event.destination, event.proxy)
+	}
+	if recorder != nil {
+		recorder.Add(time.Now(), event.exe, event.proxy, stats.Counter{Connections: 1})
+	}
+	handler.OnClose(func(sent int64, received int64) {
+		if event.release != nil {
+			event.release()
+		}
+		relayedBytes.Add(float64(sent), event.proxy, "sent")
+		relayedBytes.Add(float64(received), event.proxy, "received")
+		if watching {
+			mgr.emitConnEvent("ConnectionClosed", event.id, event.exe, event.destination, event.proxy, uint64(sent), uint64(received))
+		}
+		if recorder != nil {
+			recorder.Add(time.Now(), event.exe, event.proxy, stats.Counter{Sent: uint64(sent), Received: uint64(received)})
+		}
+		if auditLog != nil {
+			mgr.writeAudit(auditLog, event, sent, received, nil)
+		}
+	})
+}
+
+// report and count tunnel failed to create
+func (mgr *proxyPrv) tunnelFailed(event connEvent, err error) {
+	handshakeFailures.Add(1, event.proxy)
+	if recorder := mgr.manager.statsRecorder(); recorder != nil {
+		recorder.Add(time.Now(), event.exe, event.proxy, stats.Counter{Failures: 1})
+	}
+	if auditLog := mgr.auditLog(); auditLog != nil {
+		mgr.writeAudit(auditLog, event, 0, 0, err)
+	}
+	if mgr.watchingConns() {
+		mgr.emitConnEvent("HandshakeFailed", event.exe, event.destination, event.proxy, err.Error())
+	}
+}
+
+// signals of connections carry exe and destination of every user, so they are sent to each watcher
+// audited instead of broadcast, which any client on system bus could match
+func (mgr *proxyPrv) emitConnEvent(name string, values ...interface{}) {
+	if mgr.manager == nil || mgr.manager.sysService == nil {
+		return
+	}
+	mgr.watchLock.Lock()
+	watchers := make([]string, 0, len(mgr.connWatchers))
+	for watcher := range mgr.connWatchers {
+		watchers = append(watchers, watcher)
+	}
+	mgr.watchLock.Unlock()
+	conn := mgr.manager.sysService.Conn()
+	for _, watcher := range watchers {
+		msg := newConnSignal(mgr.getDBusPath(), mgr.GetInterfaceName(), name, watcher, values...)
+		call := conn.Send(msg, nil)
+		if call.Err != nil {
+			logger.Warningf("[%s] emit %s signal to %s failed, err: %v", mgr.scope, name, watcher, call.Err)
+		}
+	}
+}
+
+// signal of connection sent to destination only
+func newConnSignal(path dbus.ObjectPath, iface string, name string, destination string, values ...interface{}) *dbus.Message {
+	msg := &dbus.Message{
+		Type: dbus.TypeSignal,
+		Headers: map[dbus.HeaderField]dbus.Variant{
+			dbus.FieldPath:        dbus.MakeVariant(path),
+			dbus.FieldInterface:   dbus.MakeVariant(iface),
+			dbus.FieldMember:      dbus.MakeVariant(name),
+			dbus.FieldDestination: dbus.MakeVariant(destination),
+		},
+		Body: values,
+	}
+	if len(values) > 0 {
+		msg.Headers[dbus.FieldSignature] = dbus.MakeVariant(dbus.SignatureOf(values...))
+	}
+	return msg
+}
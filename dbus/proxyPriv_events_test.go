@@ -0,0 +1,26 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"testing"
+
+	"github.com/godbus/dbus"
+)
+
+func TestNewConnSignal(t *testing.T) {
+	msg := newConnSignal("/com/deepin/system/proxy/App", "com.deepin.system.proxy.App", "NewProxiedConnection",
+		":1.42", uint64(1), "/usr/bin/curl", "example.com:443", "proxy")
+	// signal is sent to watcher only
+	if destination, _ := msg.Headers[dbus.FieldDestination].Value().(string); destination != ":1.42" {
+		t.Errorf("destination got %q, want :1.42", destination)
+	}
+	if sig := msg.Headers[dbus.FieldSignature].Value().(dbus.Signature); sig.String() != "tsss" {
+		t.Errorf("signature got %s, want tsss", sig)
+	}
+	if err := msg.IsValid(); err != nil {
+		t.Errorf("signal is invalid, err: %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	com "github.com/linuxdeepin/deepin-network-proxy/com"
+	define "github.com/linuxdeepin/deepin-network-proxy/define"
+	newIptables "github.com/linuxdeepin/deepin-network-proxy/new_iptables"
+)
+
+// global scope intercepts all outbound traffic of system, traffic which should never leave through proxy
+// returns first by rules built from template below, so that PreviewRules shows every exemption.
+// scope chain only sees tcp now, udp entries keep dhcp and ntp out if udp is intercepted.
+
+// timeout to resolve proxy server when rules are built
+const exemptResolveTimeout = 2 * time.Second
+
+// one entry of template, cidr, port or mark is matched
+type exemption struct {
+	name  string
+	cidr  string
+	proto string
+	port  string
+	mark  uint32
+}
+
+// exemptions of global scope of ipv4
+var globalExemptions = []exemption{
+	{name: "loopback", cidr: "127.0.0.0/8"},
+	{name: "lan", cidr: "10.0.0.0/8"},
+	{name: "lan", cidr: "172.16.0.0/12"},
+	{name: "lan", cidr: "192.168.0.0/16"},
+	{name: "link-local", cidr: "169.254.0.0/16"},
+	{name: "multicast", cidr: "224.0.0.0/4"},
+	{name: "broadcast", cidr: "255.255.255.255/32"},
+	{name: "dhcp", proto: "udp", port: "67:68"},
+	{name: "ntp", proto: "udp", port: "123"},
+	{name: "daemon", mark: com.SelfMark},
+}
+
+// exemptions of global scope of ipv6
+var globalExemptions6 = []exemption{
+	{name: "loopback", cidr: "::1/128"},
+	{name: "lan", cidr: "fc00::/7"},
+	{name: "link-local", cidr: "fe80::/10"},
+	{name: "multicast", cidr: "ff00::/8"},
+	{name: "dhcp", proto: "udp", port: "546:547"},
+	{name: "ntp", proto: "udp", port: "123"},
+	{name: "daemon", mark: com.SelfMark},
+}
+
+// iptables -t mangle -A Global -d 10.0.0.0/8 -j RETURN
+// iptables -t mangle -A Global -p udp --dport 123 -j RETURN
+// iptables -t mangle -A Global -m mark --mark $SelfMark -j RETURN
+func (ex exemption) rule() *newIptables.CompleteRule {
+	cpl := &newIptables.CompleteRule{Action: newIptables.RETURN}
+	if ex.cidr != "" {
+		cpl.BaseSl = append(cpl.BaseSl, newIptables.BaseRule{Match: "d", Param: ex.cidr})
+	}
+	if ex.port != "" {
+		cpl.BaseSl = append(cpl.BaseSl, newIptables.BaseRule{Match: "p", Param: ex.proto}, newIptables.BaseRule{Match: "-dport", Param: ex.port})
+	}
+	if ex.mark != 0 {
+		cpl.ExtendsSl = append(cpl.ExtendsSl, newIptables.MarkRule(ex.mark, false))
+	}
+	return cpl
+}
+
+// check if tcp connection to ip and port returns by entry, mark of daemon is not known by destination
+func (ex exemption) matchTCP(ip net.IP, port int) bool {
+	if ex.cidr == "" && ex.port == "" {
+		return false
+	}
+	if ex.cidr != "" {
+		_, ipNet, err := net.ParseCIDR(ex.cidr)
+		if err != nil || !ipNet.Contains(ip) {
+			return false
+		}
+	}
+	if ex.port != "" {
+		if ex.proto != "tcp" {
+			return false
+		}
+		begin, end, found := strings.Cut(ex.port, ":")
+		if !found {
+			end = begin
+		}
+		low, _ := strconv.Atoi(begin)
+		high, _ := strconv.Atoi(end)
+		if port < low || port > high {
+			return false
+		}
+	}
+	return true
+}
+
+// exemptions of scope, proxy server of scope is added after template, app scope has none
+func (mgr *proxyPrv) exemptions() []exemption {
+	return mgr.familyExemptions(false)
+}
+
+// exemptions of scope of family
+func (mgr *proxyPrv) familyExemptions(ipv6 bool) []exemption {
+	if mgr.scope != define.Global {
+		return nil
+	}
+	template, bits := globalExemptions, "/32"
+	if ipv6 {
+		template, bits = globalExemptions6, "/128"
+	}
+	exemptions := append([]exemption{}, template...)
+	mgr.proxyLock.Lock()
+	proxy := mgr.Proxy
+	mgr.proxyLock.Unlock()
+	port := strconv.Itoa(proxy.Port)
+	for _, ip := range mgr.proxyServerIPs(proxy.Server, ipv6) {
+		exemptions = append(exemptions, exemption{name: "proxy-server", cidr: ip.String() + bits, proto: "tcp", port: port})
+	}
+	return exemptions
+}
+
+// iptables -t mangle -A Global -d $Server/32 -p tcp --dport $Port -j RETURN
+func (mgr *proxyPrv) exemptRules() []*newIptables.CompleteRule {
+	var cpls []*newIptables.CompleteRule
+	for _, ex := range mgr.exemptions() {
+		cpls = append(cpls, ex.rule())
+	}
+	return cpls
+}
+
+// ip6tables -t mangle -A Global -d $Server/128 -p tcp --dport $Port -j RETURN
+func (mgr *proxyPrv) exemptRules6() []*newIptables.CompleteRule {
+	var cpls []*newIptables.CompleteRule
+	for _, ex := range mgr.familyExemptions(true) {
+		cpls = append(cpls, ex.rule())
+	}
+	return cpls
+}
+
+// addrs of proxy server of family, server not resolved is not exempted,
+// sockets of daemon are still exempted by mark
+func (mgr *proxyPrv) proxyServerIPs(server string, ipv6 bool) []net.IP {
+	if server == "" {
+		return nil
+	}
+	if ip := net.ParseIP(server); ip != nil {
+		return familyIPs([]net.IP{ip}, ipv6)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), exemptResolveTimeout)
+	defer cancel()
+	addrs, err := com.SelfResolver.LookupIPAddr(ctx, server)
+	if err != nil {
+		logger.Warningf("[%s] resolve proxy server %s failed, it is not exempted, err: %v", mgr.scope, server, err)
+		return nil
+	}
+	var ips []net.IP
+	for _, addr := range addrs {
+		ips = append(ips, addr.IP)
+	}
+	return familyIPs(ips, ipv6)
+}
+
+// ips of family, ipv4 is in 4 bytes form
+func familyIPs(ips []net.IP, ipv6 bool) []net.IP {
+	var result []net.IP
+	for _, ip := range ips {
+		ip4 := ip.To4()
+		switch {
+		case ipv6 && ip4 == nil:
+			result = append(result, ip)
+		case !ipv6 && ip4 != nil:
+			result = append(result, ip4)
+		}
+	}
+	return result
+}
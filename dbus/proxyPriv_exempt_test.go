@@ -0,0 +1,23 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"net"
+	"testing"
+)
+
+func TestFamilyIPs(t *testing.T) {
+	ips := []net.IP{net.ParseIP("1.2.3.4"), net.ParseIP("2001:db8::1"), net.ParseIP("::ffff:5.6.7.8")}
+	// mapped ipv4 is ipv4, exempted by iptables
+	ips4 := familyIPs(ips, false)
+	if len(ips4) != 2 || len(ips4[0]) != net.IPv4len || !ips4[1].Equal(net.ParseIP("5.6.7.8")) {
+		t.Errorf("ipv4 got %v", ips4)
+	}
+	ips6 := familyIPs(ips, true)
+	if len(ips6) != 1 || !ips6[0].Equal(net.ParseIP("2001:db8::1")) {
+		t.Errorf("ipv6 got %v", ips6)
+	}
+}
@@ -0,0 +1,77 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"fmt"
+	"net"
+
+	com "github.com/linuxdeepin/deepin-network-proxy/com"
+	define "github.com/linuxdeepin/deepin-network-proxy/define"
+	newCGroups "github.com/linuxdeepin/deepin-network-proxy/new_cgroups"
+)
+
+// transparent listener is bound to all addrs, local app could connect it directly and use daemon as open proxy.
+// connection is accepted only if it is redirected by rules of scope: origin destination is never the listener itself,
+// and proc holds the socket is in cgroup of scope, so that socket marked by scope mark manually is rejected too.
+// conn redirected by bpf is checked by bpf map already, udp has no owner lookup and only destination is checked,
+// conn from network namespace redirected is checked by its source addr.
+
+// check if addr is listener of scope, connection to it is not redirected
+func (mgr *proxyPrv) isListenerAddr(addr net.Addr) bool {
+	var ip net.IP
+	var port int
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		ip, port = addr.IP, addr.Port
+	case *net.UDPAddr:
+		ip, port = addr.IP, addr.Port
+	default:
+		return false
+	}
+	return port == mgr.Proxies.TPort && com.IsLocalIP(ip)
+}
+
+// check if connection from local to dst is redirected by rules of scope, peer is addr socket of app connects to.
+// owner found in cgroup of scope is returned, nil owner is looked up later if needed
+func (mgr *proxyPrv) guardConn(local net.Addr, dst net.Addr, peer net.Addr) (*newCGroups.SocketOwner, error) {
+	if mgr.isListenerAddr(dst) {
+		return nil, fmt.Errorf("connection to listener %s is not redirected", dst)
+	}
+	// socket of app in other network namespace is not found in host
+	if mgr.controller == nil || mgr.fromNetNS(local) {
+		return nil, nil
+	}
+	lAddr, ok := local.(*net.TCPAddr)
+	if !ok {
+		return nil, nil
+	}
+	rAddr, ok := peer.(*net.TCPAddr)
+	if !ok {
+		return nil, nil
+	}
+	info, err := com.LookupTcpSocket(lAddr, rAddr)
+	if err != nil {
+		return nil, fmt.Errorf("look up socket of %s failed: %v", lAddr, err)
+	}
+	mgr.proxyLock.Lock()
+	hint := mgr.ownerHint
+	mgr.proxyLock.Unlock()
+	owner, err := mgr.controller.FindSocketOwner(newCGroups.ProcRoot, info.Inode, hint)
+	// procs in cgroup of global are ignored apps, the others are proxied
+	if mgr.scope == define.Global {
+		if err == nil {
+			return nil, fmt.Errorf("proc %s %s is ignored by scope", owner.Pid, owner.ExecPath)
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("owner of socket %d is not in cgroup of scope: %v", info.Inode, err)
+	}
+	mgr.proxyLock.Lock()
+	mgr.ownerHint = owner.Pid
+	mgr.proxyLock.Unlock()
+	return owner, nil
+}
@@ -0,0 +1,68 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/godbus/dbus"
+	config "github.com/linuxdeepin/deepin-network-proxy/config"
+	"github.com/linuxdeepin/go-lib/dbusutil"
+)
+
+// import proxies from share uri or subscription content, see config.ParseProxyURI for formats,
+// proxies imported are saved to config, entries can not be imported are returned as skipped
+func (mgr *proxyPrv) ImportProxyURI(sender dbus.Sender, uris string) ([]string, []string, *dbus.Error) {
+	dErr := mgr.authorize(sender, actionConfig)
+	if dErr != nil {
+		return nil, nil, dErr
+	}
+	proxies, errs := config.ParseSubscription([]byte(uris))
+	var skipped []string
+	for _, err := range errs {
+		logger.Warningf("[%s] skip proxy uri, err: %v", mgr.scope, err)
+		skipped = append(skipped, err.Error())
+	}
+	if len(proxies) == 0 {
+		// reply of error has no out args, reasons are carried by error
+		return nil, skipped, dbusutil.ToError(fmt.Errorf("no proxy can be imported: %s", strings.Join(skipped, "; ")))
+	}
+	keys := mgr.Proxies.ImportProxies(proxies)
+	err := mgr.writeConfig()
+	if err != nil {
+		return nil, skipped, dbusutil.ToError(err)
+	}
+	logger.Infof("[%s] import proxies %v", mgr.scope, keys)
+	return keys, skipped, nil
+}
+
+// import proxies and direct rules from clash or v2ray config, features can not be converted are returned
+func (mgr *proxyPrv) ImportClientConfig(sender dbus.Sender, format string, data string, withRules bool) ([]string, []string, *dbus.Error) {
+	dErr := mgr.authorize(sender, actionConfig)
+	if dErr != nil {
+		return nil, nil, dErr
+	}
+	result, err := config.ImportClientConfig(format, []byte(data), withRules)
+	if err != nil {
+		return nil, nil, dbusutil.ToError(err)
+	}
+	for _, msg := range result.Unsupported {
+		logger.Debugf("[%s] import %s config, %s", mgr.scope, format, msg)
+	}
+	if len(result.Proxies) == 0 && len(result.Bypass) == 0 {
+		return nil, result.Unsupported, dbusutil.ToError(fmt.Errorf("nothing can be imported: %s", strings.Join(result.Unsupported, "; ")))
+	}
+	keys := mgr.Proxies.ImportResult(result)
+	if len(result.Bypass) != 0 {
+		_ = mgr.loadBypass()
+	}
+	err = mgr.writeConfig()
+	if err != nil {
+		return nil, result.Unsupported, dbusutil.ToError(err)
+	}
+	logger.Infof("[%s] import %s config, proxies %v, %d bypass rules", mgr.scope, format, keys, len(result.Bypass))
+	return keys, result.Unsupported, nil
+}
@@ -0,0 +1,118 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/godbus/dbus"
+	newIptables "github.com/linuxdeepin/deepin-network-proxy/new_iptables"
+	"github.com/linuxdeepin/go-lib/dbusutil"
+)
+
+// interfaces are matched by name with -o, rules keep working when interface disappears and appears again,
+// name ends with + matches all interfaces with the prefix, like wlan+.
+
+// max length of interface name, IFNAMSIZ - 1
+const ifNameMax = 15
+
+// check interface name for iptables
+func checkInterface(name string) error {
+	if name == "" || len(name) > ifNameMax {
+		return fmt.Errorf("interface name %q length is invalid", name)
+	}
+	if strings.ContainsAny(name, " /!") {
+		return fmt.Errorf("interface name %q is invalid", name)
+	}
+	return nil
+}
+
+// iptables -t mangle -A App -o eth0 -j RETURN
+func (mgr *proxyPrv) excludeInterfaceRules() []*newIptables.CompleteRule {
+	var cpls []*newIptables.CompleteRule
+	for _, ifc := range mgr.Proxies.ExcludeInterfaces {
+		cpls = append(cpls, &newIptables.CompleteRule{
+			Action: newIptables.RETURN,
+			BaseSl: []newIptables.BaseRule{{Match: "o", Param: ifc}},
+		})
+	}
+	return cpls
+}
+
+// mark or redirect rule, one rule for each interface if proxy is restricted to interfaces
+// iptables -t mangle -A App -o wlan0 -j MARK --set-mark $Mark
+func (mgr *proxyPrv) interceptRules() []*newIptables.CompleteRule {
+	newRule := mgr.markRule
+	if mgr.redirectMode() {
+		newRule = mgr.redirectRule
+	}
+	if len(mgr.Proxies.Interfaces) == 0 {
+		return []*newIptables.CompleteRule{newRule()}
+	}
+	var cpls []*newIptables.CompleteRule
+	for _, ifc := range mgr.Proxies.Interfaces {
+		cpl := newRule()
+		cpl.BaseSl = append(cpl.BaseSl, newIptables.BaseRule{Match: "o", Param: ifc})
+		cpls = append(cpls, cpl)
+	}
+	return cpls
+}
+
+// restrict proxy to interfaces, empty include means all interfaces, rules are rebuilt if proxy is running
+func (mgr *proxyPrv) SetInterfaces(sender dbus.Sender, include []string, exclude []string) *dbus.Error {
+	dErr := mgr.authorize(sender, actionConfig)
+	if dErr != nil {
+		return dErr
+	}
+	for _, ifc := range append(append([]string{}, include...), exclude...) {
+		err := checkInterface(ifc)
+		if err != nil {
+			logger.Warningf("[%s] set interfaces failed, err: %v", mgr.scope, err)
+			return dbusutil.ToError(err)
+		}
+	}
+	mgr.Proxies.Interfaces = include
+	mgr.Proxies.ExcludeInterfaces = exclude
+	if mgr.Enabled {
+		err := mgr.rebuildScopeRules()
+		if err != nil {
+			logger.Warningf("[%s] rebuild rules of interfaces failed, err: %v", mgr.scope, err)
+			return dbusutil.ToError(err)
+		}
+	}
+	err := mgr.writeConfig()
+	if err != nil {
+		logger.Warningf("[%s] write config failed, err: %v", mgr.scope, err)
+		return dbusutil.ToError(err)
+	}
+	return nil
+}
+
+// flush scope chain and add rules again
+func (mgr *proxyPrv) rebuildScopeRules() error {
+	selfChain := mgr.chains[1]
+	if selfChain == nil {
+		return errors.New("chain is nil")
+	}
+	err := selfChain.Clear()
+	if err != nil {
+		return err
+	}
+	err = mgr.buildScopeRules(selfChain)
+	if err != nil {
+		return err
+	}
+	// ipv6 is optional
+	if err := mgr.rebuildScopeRules6(); err != nil {
+		logger.Warningf("[%s] rebuild rules of ipv6 failed, err: %v", mgr.scope, err)
+	}
+	// pause rule is flushed with chain
+	if mgr.isPaused() {
+		return selfChain.InsertRule(0, mgr.pauseRule())
+	}
+	return nil
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"strconv"
 
+	com "github.com/linuxdeepin/deepin-network-proxy/com"
 	define "github.com/linuxdeepin/deepin-network-proxy/define"
 	newIptables "github.com/linuxdeepin/deepin-network-proxy/new_iptables"
 )
@@ -18,33 +19,92 @@ func (mgr *proxyPrv) createTable() error {
 	// start manager to init iptables and cgroups once
 	mgr.manager.Start()
 
+	// chain of other service may have the same name
+	err := checkChain(mgr.mainTable(), mgr.chainName())
+	if err != nil {
+		return err
+	}
+	// main chain is dropped if init fails
+	if mgr.manager.mainChain == nil {
+		return errors.New("main chain of iptables is not initialized")
+	}
+	chains, err := mgr.buildTable(mgr.manager.iptablesMgr, mgr.manager.mainChain)
+	// save chain
+	mgr.chains = chains
+	return err
+}
+
+// create scope chain under main chain, return mangle PREROUTING and scope chain
+func (mgr *proxyPrv) buildTable(iptablesMgr *newIptables.Manager, mainChain *newIptables.Chain) ([2]*newIptables.Chain, error) {
+	var chains [2]*newIptables.Chain
 	// all app or global proxy has the mangle PREROUTING chain
-	chain := mgr.manager.iptablesMgr.GetChain("mangle", "PREROUTING")
+	chain := iptablesMgr.GetChain("mangle", "PREROUTING")
 	if chain == nil {
 		logger.Warningf("[%s] has no mangle PREROUTING chain", mgr.scope)
-		return errors.New("has no mangle PREROUTING chain")
+		return chains, errors.New("has no mangle PREROUTING chain")
+	}
+	chains[0] = chain
+
+	// child chain
+	childChain, err := mainChain.CreateChild(mgr.chainName(), mgr.scopeIndex(mainChain), mgr.scopeJumpRule())
+	if err != nil {
+		return chains, err
+	}
+	chains[1] = childChain
+
+	// restore conntrack mark for reply and related packages
+	if mgr.useConnMark() {
+		err := mgr.addConnMarkRestore(iptablesMgr)
+		if err != nil {
+			return chains, err
+		}
+	}
+
+	// clamp syn of connections to proxy server
+	err = mgr.addMSSClamp(iptablesMgr)
+	if err != nil {
+		return chains, err
+	}
+
+	// redirect dns query to fake ip or proxy dns server
+	if mgr.useDNSProxy() {
+		chain := iptablesMgr.GetChain("nat", "OUTPUT")
+		if chain == nil {
+			logger.Warningf("[%s] has no nat OUTPUT chain", mgr.scope)
+			return chains, errors.New("has no nat OUTPUT chain")
+		}
+		for _, cpl := range mgr.dnsRedirectRules() {
+			err := chain.AppendRule(cpl)
+			if err != nil {
+				return chains, err
+			}
+		}
 	}
-	mgr.chains[0] = chain
 
-	// get index, default append at last
-	index := mgr.manager.mainChain.GetRulesCount()
-	// correct index when is app proxy
+	return chains, nil
+}
+
+// index of scope chain in main chain, app scope is before global scope, default append at last
+func (mgr *proxyPrv) scopeIndex(mainChain *newIptables.Chain) int {
+	index := mainChain.GetRulesCount()
 	if mgr.scope == define.App {
-		pos, exist := mgr.manager.mainChain.GetCreateChildIndex(define.Global.String())
+		pos, exist := mainChain.GetCreateChildIndex(mgr.manager.chainName(define.Global))
 		if exist {
 			index = pos
 		}
 	}
+	return index
+}
+
+// iptables -t mangle -I main $1 -p tcp -m cgroup --path app.slice/global.slice -j app/global
+func (mgr *proxyPrv) scopeJumpRule() *newIptables.CompleteRule {
 	var mark bool
 	if mgr.scope == define.Global {
 		mark = true
 	}
-
-	// command line
-	// iptables -t mangle -I main $1 -p tcp -m cgroup --path app.slice/global.slice -j app/global
 	cpl := &newIptables.CompleteRule{
 		// -j app/global
-		Action: mgr.scope.String(),
+		Action: mgr.chainName(),
 		// base rules slice         -p tcp
 		BaseSl: []newIptables.BaseRule{
 			{
@@ -58,103 +118,205 @@ func (mgr *proxyPrv) createTable() error {
 				Match: "m",
 				Elem: newIptables.ExtendsElem{
 					Match: "cgroup",
-					Base:  newIptables.BaseRule{Not: mark, Match: "path", Param: mgr.controller.GetName()},
+					Base:  newIptables.BaseRule{Not: mark, Match: "path", Param: mgr.cgroupPath()},
 				},
 			},
 		},
 	}
-	// child chain
-	childChain, err := mgr.manager.mainChain.CreateChild(mgr.scope.String(), index, cpl)
-	if err != nil {
-		return err
-	}
+	cpl.ExtendsSl = append(cpl.ExtendsSl, mgr.ownerRules()...)
+	return cpl
+}
 
-	// save chain
-	mgr.chains[1] = childChain
+// query falls back to tcp when response is truncated, or resolver uses tcp only, both are redirected
+func (mgr *proxyPrv) dnsRedirectRules() []*newIptables.CompleteRule {
+	return []*newIptables.CompleteRule{mgr.dnsRedirectRule("udp"), mgr.dnsRedirectRule("tcp")}
+}
 
-	if mgr.Proxies.DNSPort != 0 {
-		chain := mgr.manager.iptablesMgr.GetChain("nat", "OUTPUT")
-		if chain == nil {
-			logger.Warningf("[%s] has no nat OUTPUT chain", mgr.scope)
-			return errors.New("has no nat OUTPUT chain")
-		}
-		cpl := &newIptables.CompleteRule{
-			Action: newIptables.REDIRECT,
-			BaseSl: []newIptables.BaseRule{
-				{
-					Match: "p",
-					Param: "udp",
-				},
-				{
-					Match: "-dport",
-					Param: "53",
-				},
-				{
-					Match: "-to-ports",
-					Param: strconv.Itoa(mgr.Proxies.DNSPort),
-				},
+// iptables -t nat -A OUTPUT -p udp --dport 53 --to-ports $DNSPort -m cgroup --path app.slice -m mark ! --mark $SelfMark -j REDIRECT
+// rule is not under main chain, query of daemon is excluded here
+func (mgr *proxyPrv) dnsRedirectRule(proto string) *newIptables.CompleteRule {
+	var mark bool
+	if mgr.scope == define.Global {
+		mark = true
+	}
+	cpl := &newIptables.CompleteRule{
+		Action: newIptables.REDIRECT,
+		BaseSl: []newIptables.BaseRule{
+			{
+				Match: "p",
+				Param: proto,
+			},
+			{
+				Match: "-dport",
+				Param: "53",
 			},
-			ExtendsSl: []newIptables.ExtendsRule{
-				{
-					Match: "m",
-					Elem: newIptables.ExtendsElem{
-						Match: "cgroup",
-						Base:  newIptables.BaseRule{Not: mark, Match: "path", Param: mgr.controller.GetName()},
-					},
+			{
+				Match: "-to-ports",
+				Param: strconv.Itoa(mgr.Proxies.DNSPort),
+			},
+		},
+		ExtendsSl: []newIptables.ExtendsRule{
+			{
+				Match: "m",
+				Elem: newIptables.ExtendsElem{
+					Match: "cgroup",
+					Base:  newIptables.BaseRule{Not: mark, Match: "path", Param: mgr.cgroupPath()},
 				},
 			},
-		}
-
-		err := chain.AppendRule(cpl)
-		if err != nil {
-			return err
-		}
+			newIptables.MarkRule(com.SelfMark, true),
+		},
 	}
+	cpl.ExtendsSl = append(cpl.ExtendsSl, mgr.ownerRules()...)
+	cpl.ExtendsSl = append(cpl.ExtendsSl, mgr.directExemptRules()...)
+	return cpl
+}
 
-	return nil
+// -m owner --uid-owner $uid, app scope only captures apps of user started it,
+// the same app run by other users keeps its own path. global scope is system wide, bpf has no owner match
+func (mgr *proxyPrv) ownerRules() []newIptables.ExtendsRule {
+	if mgr.scope != define.App {
+		return nil
+	}
+	return []newIptables.ExtendsRule{newIptables.OwnerUidRule(strconv.FormatUint(uint64(mgr.uid), 10), false)}
 }
 
 // add rule at App_Proxy or mangle OUTPUT
 func (mgr *proxyPrv) appendRule() error {
+	return mgr.buildRule(mgr.chains)
+}
+
+// add mark rule to scope chain and tproxy rule to mangle PREROUTING
+func (mgr *proxyPrv) buildRule(chains [2]*newIptables.Chain) error {
 	// get chain
-	selfChain := mgr.chains[1]
+	selfChain := chains[1]
 	if selfChain == nil {
 		logger.Warningf("[%s] cant add rule, chain is nil", mgr.scope)
 		return errors.New("chain is nil")
 	}
-	// iptables -t mangle -A App_Proxy -j MARK --set-mark $2
-	base := newIptables.BaseRule{
-		Match: "-set-mark",
-		Param: strconv.Itoa(mgr.Proxies.TPort),
-	}
-	// one complete rule
-	cpl := &newIptables.CompleteRule{
-		// -j MARK
-		Action: newIptables.MARK,
-		// --set-mark $2
-		BaseSl: []newIptables.BaseRule{base},
-	}
-	// append
-	err := selfChain.AppendRule(cpl)
+	err := mgr.buildScopeRules(selfChain)
 	if err != nil {
 		return err
 	}
+	// nat redirect to listener, no tproxy rule
+	if mgr.redirectMode() {
+		return nil
+	}
 
 	// default chain
-	defChain := mgr.chains[0]
+	defChain := chains[0]
 	if defChain == nil {
 		logger.Warningf("[%s] cant add rule, chain is nil", mgr.scope)
 		return errors.New("chain is nil")
 	}
 	// iptables -t mangle -A PREROUTING -j TPROXY -m mark --mark $2 --on-port 8080
+	err = defChain.AppendRule(mgr.tproxyRule())
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// add interface rules and mark or redirect rules to scope chain
+func (mgr *proxyPrv) buildScopeRules(selfChain *newIptables.Chain) error {
+	// local, lan and proxy server are never proxied by global scope
+	for _, cpl := range mgr.exemptRules() {
+		err := selfChain.AppendRule(cpl)
+		if err != nil {
+			return err
+		}
+	}
+	// excluded interfaces return first
+	for _, cpl := range mgr.excludeInterfaceRules() {
+		err := selfChain.AppendRule(cpl)
+		if err != nil {
+			return err
+		}
+	}
+	// connections of daemon to proxy server return by dial mark
+	if cpl := mgr.dialMarkRule(); cpl != nil {
+		err := selfChain.AppendRule(cpl)
+		if err != nil {
+			return err
+		}
+	}
+	// cidr of bypass returns before mark
+	if mgr.bypassSet != nil {
+		err := selfChain.AppendRule(mgr.bypassSetRule())
+		if err != nil {
+			return err
+		}
+	}
+	// ipv4 not listened is not intercepted, it connects directly
+	if mgr.Proxies.DisableIPv4 {
+		return nil
+	}
+	for _, cpl := range mgr.interceptRules() {
+		err := selfChain.AppendRule(cpl)
+		if err != nil {
+			return err
+		}
+	}
+	// save mark to conntrack after mark is set
+	if mgr.useConnMark() {
+		err := selfChain.AppendRule(mgr.connMarkSaveRule())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// iptables -t mangle -A App_Proxy -m mark --mark $DialMark -j RETURN
+func (mgr *proxyPrv) dialMarkRule() *newIptables.CompleteRule {
+	mark := mgr.Proxy.Dial.Mark
+	if mark == 0 {
+		return nil
+	}
+	param := strconv.FormatUint(uint64(mark), 10)
+	if param == mgr.fwmark() {
+		logger.Warningf("[%s] dial mark %s is the same as mark of scope", mgr.scope, param)
+	}
+	return &newIptables.CompleteRule{
+		// -j RETURN
+		Action: newIptables.RETURN,
+		// -m mark --mark $DialMark
+		ExtendsSl: []newIptables.ExtendsRule{
+			{
+				Match: "m",
+				Elem: newIptables.ExtendsElem{
+					Match: "mark",
+					Base:  newIptables.BaseRule{Match: "mark", Param: param},
+				},
+			},
+		},
+	}
+}
+
+// iptables -t mangle -A App_Proxy -j MARK --set-mark $2
+func (mgr *proxyPrv) markRule() *newIptables.CompleteRule {
+	base := newIptables.BaseRule{
+		Match: "-set-mark",
+		Param: mgr.fwmark(),
+	}
+	// one complete rule
+	return &newIptables.CompleteRule{
+		// -j MARK
+		Action: newIptables.MARK,
+		// --set-mark $2
+		BaseSl: []newIptables.BaseRule{base},
+	}
+}
+
+// iptables -t mangle -A PREROUTING -j TPROXY -p tcp --on-port 8080 -m mark --mark $2
+func (mgr *proxyPrv) tproxyRule() *newIptables.CompleteRule {
 	protoExtends := newIptables.ExtendsRule{
-		// -m
+		// -p
 		Match: "p",
-		// mark --mark $2
+		// tcp --on-port 8080
 		Elem: newIptables.ExtendsElem{
-			// mark
+			// tcp
 			Match: "tcp",
-			// --mark $2
+			// --on-port 8080
 			Base: newIptables.BaseRule{
 				Match: "on-port", Param: strconv.Itoa(mgr.Proxies.TPort),
 			},
@@ -169,29 +331,94 @@ func (mgr *proxyPrv) appendRule() error {
 			Match: "mark",
 			// --mark $2
 			Base: newIptables.BaseRule{
-				Match: "mark", Param: strconv.Itoa(mgr.Proxies.TPort),
+				Match: "mark", Param: mgr.fwmark(),
 			},
 		},
 	}
 	// one complete rule
-	cpl = &newIptables.CompleteRule{
+	return &newIptables.CompleteRule{
 		// -j TPROXY
 		Action: newIptables.TPROXY,
 		BaseSl: nil,
-		// -m mark --mark $2
+		// -p tcp --on-port 8080 -m mark --mark $2
 		ExtendsSl: []newIptables.ExtendsRule{protoExtends, markExtends},
 	}
-	// append
-	err = defChain.AppendRule(cpl)
+}
+
+// create scope chain and rules in one transaction, rules added are removed if any step fails
+func (mgr *proxyPrv) setupScope() error {
+	// start manager to init iptables and cgroups once
+	mgr.manager.Start()
+	if mgr.manager.iptablesMgr == nil {
+		return errors.New("iptables is not initialized")
+	}
+	// scope chain is filled before tproxy rule is added, packets are never redirected without mark
+	err := mgr.manager.iptablesMgr.Transaction(func() error {
+		err := mgr.createTable()
+		if err != nil {
+			return err
+		}
+		return mgr.appendRule()
+	})
 	if err != nil {
+		mgr.chains = [2]*newIptables.Chain{}
+		mgr.mssRule = nil
 		return err
 	}
+	// ipv6 is optional, not in transaction of ipv4
+	mgr.setupScope6()
 	return nil
 }
 
-// delete chain and remove from parent
+// delete rules of all tables in reverse order of setup, entry rules first,
+// so that no packet is redirected to chain being removed, continue if one step fails
 func (mgr *proxyPrv) releaseRule() error {
-	// clear self chain
+	var steps []func() error
+	// traffic of network namespaces enters before tproxy rule
+	steps = append(steps, mgr.releaseNetNS)
+	// tproxy rule and scope chain of ipv6
+	steps = append(steps, mgr.releaseRule6)
+	// tproxy rule of mangle PREROUTING, redirect mode has no tproxy and conn mark rule
+	if !mgr.redirectMode() {
+		steps = append(steps, mgr.releaseTProxyRule)
+	}
+	// dns redirect of nat OUTPUT
+	steps = append(steps, mgr.releaseDNSRule)
+	// mss clamp of mangle POSTROUTING
+	steps = append(steps, mgr.delMSSClamp)
+	// scope chain and jump rule from main chain
+	steps = append(steps, mgr.releaseScopeChain)
+	// conn mark restore of mangle OUTPUT
+	if !mgr.redirectMode() && mgr.useConnMark() {
+		steps = append(steps, mgr.delConnMarkRestore)
+	}
+	var first error
+	for _, step := range steps {
+		err := step()
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// delete tproxy rule
+func (mgr *proxyPrv) releaseTProxyRule() error {
+	defChain := mgr.chains[0]
+	if defChain == nil {
+		logger.Warningf("[%s] default chain is nil", mgr.scope)
+		return fmt.Errorf("[%s] default chain is nil", mgr.scope)
+	}
+	err := defChain.DelRule(mgr.tproxyRule())
+	if err != nil {
+		logger.Warningf("[%s] delete rule failed, err: %v", mgr.scope, err)
+		return err
+	}
+	return nil
+}
+
+// delete chain and remove from parent
+func (mgr *proxyPrv) releaseScopeChain() error {
 	selfChain := mgr.chains[1]
 	if selfChain == nil {
 		logger.Warningf("[%s] self create chain is nil", mgr.scope)
@@ -202,57 +429,47 @@ func (mgr *proxyPrv) releaseRule() error {
 		logger.Warningf("[%s] remove self create chain failed, err: %v", mgr.scope, err)
 		return err
 	}
+	return nil
+}
 
-	// delete default chain from
-	defChain := mgr.chains[0]
-	if defChain == nil {
-		logger.Warningf("[%s] default chain is nil", mgr.scope)
-		return fmt.Errorf("[%s] default chain is nil", mgr.scope)
+// delete dns redirect rule
+func (mgr *proxyPrv) releaseDNSRule() error {
+	if !mgr.useDNSProxy() {
+		return nil
 	}
-	// iptables -t mangle -D PREROUTING -j TPROXY -m mark --mark $2 --on-port 8080
-	protoExtends := newIptables.ExtendsRule{
-		// -m
-		Match: "p",
-		// mark --mark $2
-		Elem: newIptables.ExtendsElem{
-			// mark
-			Match: "tcp",
-			// --mark $2
-			Base: newIptables.BaseRule{
-				Match: "on-port", Param: strconv.Itoa(mgr.Proxies.TPort),
-			},
-		},
+	natChain := mgr.manager.iptablesMgr.GetChain("nat", "OUTPUT")
+	if natChain == nil {
+		logger.Warningf("[%s] has no nat OUTPUT chain", mgr.scope)
+		return errors.New("has no nat OUTPUT chain")
 	}
-	markExtends := newIptables.ExtendsRule{
-		// -m
-		Match: "m",
-		// mark --mark $2
-		Elem: newIptables.ExtendsElem{
-			// mark
-			Match: "mark",
-			// --mark $2
-			Base: newIptables.BaseRule{
-				Match: "mark", Param: strconv.Itoa(mgr.Proxies.TPort),
-			},
-		},
+	var lastErr error
+	for _, cpl := range mgr.dnsRedirectRules() {
+		err := natChain.DelRule(cpl)
+		if err != nil {
+			logger.Warningf("[%s] delete dns redirect rule failed, err: %v", mgr.scope, err)
+			lastErr = err
+		}
 	}
-	// one complete rule
-	cpl := &newIptables.CompleteRule{
-		// -j TPROXY
-		Action: newIptables.TPROXY,
-		BaseSl: nil,
-		// -m mark --mark $2
-		ExtendsSl: []newIptables.ExtendsRule{protoExtends, markExtends},
+	return lastErr
+}
+
+// cgroup path of scope relative to cgroup root
+func (mgr *proxyPrv) cgroupPath() string {
+	if mgr.controller != nil {
+		return mgr.controller.GetRelPath()
 	}
-	err = defChain.DelRule(cpl)
+	return mgr.scope.String() + ".slice"
+}
+
+// release procs of controller back and remove its cgroup
+func (mgr *proxyPrv) releaseController() error {
+	if mgr.controller == nil {
+		return nil
+	}
+	err := mgr.manager.controllerMgr.Release(mgr.scope)
 	if err != nil {
-		logger.Warningf("[%s] delete rule failed, err: %v", mgr.scope, err)
 		return err
 	}
+	mgr.controller = nil
 	return nil
 }
-
-// release controller
-func (mgr *proxyPrv) releaseController() error {
-	return mgr.controller.ReleaseAll()
-}
@@ -0,0 +1,140 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"errors"
+
+	newIptables "github.com/linuxdeepin/deepin-network-proxy/new_iptables"
+)
+
+// ipv6 is intercepted by the same scope chain and tproxy rule built by ip6tables, packets marked are routed
+// to local by ip -6 rule and local route of ipv6, then received by tcp6 listener.
+// ipv6 is optional, scope keeps working on ipv4 if any rule of ipv6 fails, and rules of ipv6 are not reconciled.
+// bypass set, conn mark, mss clamp and dns redirect are ipv4 only, bypass rules are still checked by handlers.
+
+// check if ipv6 of scope is intercepted by ip6tables
+func (mgr *proxyPrv) useIPv6() bool {
+	return mgr.manager.iptablesMgr6 != nil && mgr.manager.mainChain6 != nil && !mgr.Proxies.DisableIPv6
+}
+
+// create scope chain and rules of ip6tables in one transaction, nothing is left if it fails
+func (mgr *proxyPrv) setupScope6() {
+	if !mgr.useIPv6() {
+		return
+	}
+	iptablesMgr := mgr.manager.iptablesMgr6
+	mainChain := mgr.manager.mainChain6
+	err := iptablesMgr.Transaction(func() error {
+		chain := iptablesMgr.GetChain("mangle", "PREROUTING")
+		if chain == nil {
+			return errors.New("has no mangle PREROUTING chain of ipv6")
+		}
+		childChain, err := mainChain.CreateChild(mgr.chainName(), mgr.scopeIndex(mainChain), mgr.scopeJumpRule())
+		if err != nil {
+			return err
+		}
+		mgr.chains6 = [2]*newIptables.Chain{chain, childChain}
+		err = mgr.buildScopeRules6(childChain)
+		if err != nil {
+			return err
+		}
+		// nat redirect to listener, no tproxy rule
+		if mgr.redirectMode() {
+			return nil
+		}
+		// ip6tables -t mangle -A PREROUTING -j TPROXY -p tcp --on-port 8080 -m mark --mark $2
+		return chain.AppendRule(mgr.tproxyRule())
+	})
+	if err != nil {
+		logger.Warningf("[%s] setup rules of ipv6 failed, ipv6 is not proxied, err: %v", mgr.scope, err)
+		mgr.chains6 = [2]*newIptables.Chain{}
+	}
+}
+
+// add exemptions, interface rules and mark or redirect rules to scope chain of ipv6
+func (mgr *proxyPrv) buildScopeRules6(selfChain *newIptables.Chain) error {
+	var cpls []*newIptables.CompleteRule
+	cpls = append(cpls, mgr.exemptRules6()...)
+	cpls = append(cpls, mgr.excludeInterfaceRules()...)
+	if cpl := mgr.dialMarkRule(); cpl != nil {
+		cpls = append(cpls, cpl)
+	}
+	cpls = append(cpls, mgr.interceptRules()...)
+	for _, cpl := range cpls {
+		err := selfChain.AppendRule(cpl)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flush scope chain of ipv6 and add rules again, pause rule is kept as ipv4 does
+func (mgr *proxyPrv) rebuildScopeRules6() error {
+	selfChain := mgr.chains6[1]
+	if selfChain == nil {
+		return nil
+	}
+	err := selfChain.Clear()
+	if err != nil {
+		return err
+	}
+	err = mgr.buildScopeRules6(selfChain)
+	if err != nil {
+		return err
+	}
+	if mgr.isPaused() {
+		return selfChain.InsertRule(0, mgr.pauseRule())
+	}
+	return nil
+}
+
+// insert rule to head of scope chain of ipv6, such as pause and drain rule
+func (mgr *proxyPrv) insertScopeRule6(cpl *newIptables.CompleteRule) {
+	selfChain := mgr.chains6[1]
+	if selfChain == nil {
+		return
+	}
+	err := selfChain.InsertRule(0, cpl)
+	if err != nil {
+		logger.Warningf("[%s] insert rule of ipv6 failed, err: %v", mgr.scope, err)
+	}
+}
+
+// delete rule of scope chain of ipv6
+func (mgr *proxyPrv) delScopeRule6(cpl *newIptables.CompleteRule) {
+	selfChain := mgr.chains6[1]
+	if selfChain == nil {
+		return
+	}
+	err := selfChain.DelRule(cpl)
+	if err != nil {
+		logger.Warningf("[%s] delete rule of ipv6 failed, err: %v", mgr.scope, err)
+	}
+}
+
+// delete tproxy rule and scope chain of ipv6, entry rule first
+func (mgr *proxyPrv) releaseRule6() error {
+	chains := mgr.chains6
+	mgr.chains6 = [2]*newIptables.Chain{}
+	var first error
+	if chains[0] != nil && !mgr.redirectMode() {
+		first = chains[0].DelRule(mgr.tproxyRule())
+		if first != nil {
+			logger.Warningf("[%s] delete tproxy rule of ipv6 failed, err: %v", mgr.scope, first)
+		}
+	}
+	if chains[1] != nil {
+		err := chains[1].Remove()
+		if err != nil {
+			logger.Warningf("[%s] remove chain of ipv6 failed, err: %v", mgr.scope, err)
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
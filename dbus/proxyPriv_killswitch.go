@@ -0,0 +1,114 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"errors"
+
+	define "github.com/linuxdeepin/deepin-network-proxy/define"
+	newIptables "github.com/linuxdeepin/deepin-network-proxy/new_iptables"
+	tProxy "github.com/linuxdeepin/deepin-network-proxy/tproxy"
+)
+
+// kill switch is engaged when tunnel failed because proxy server is unreachable,
+// traffic of proxy app which is not redirected to t-proxy is dropped until any tunnel is created again.
+// tcp and udp captured by t-proxy is still marked and routed to lo, so tunnel is still tried and switch can be released.
+
+// iptables -t filter -I OUTPUT ! -o lo -m cgroup --path app.slice -m mark ! --mark $Mark -j DROP
+func (mgr *proxyPrv) killSwitchRule() *newIptables.CompleteRule {
+	var mark bool
+	if mgr.scope == define.Global {
+		mark = true
+	}
+	cpl := &newIptables.CompleteRule{
+		Action: newIptables.DROP,
+		BaseSl: []newIptables.BaseRule{
+			{
+				Not:   true,
+				Match: "o",
+				Param: "lo",
+			},
+		},
+		ExtendsSl: []newIptables.ExtendsRule{
+			{
+				Match: "m",
+				Elem: newIptables.ExtendsElem{
+					Match: "cgroup",
+					Base:  newIptables.BaseRule{Not: mark, Match: "path", Param: mgr.cgroupPath()},
+				},
+			},
+			{
+				Match: "m",
+				Elem: newIptables.ExtendsElem{
+					Match: "mark",
+					Base:  newIptables.BaseRule{Not: true, Match: "mark", Param: mgr.fwmark()},
+				},
+			},
+		},
+	}
+	// programs never proxied are not dropped by global scope
+	cpl.ExtendsSl = append(cpl.ExtendsSl, mgr.directExemptRules()...)
+	return cpl
+}
+
+// update kill switch by tunnel result
+func (mgr *proxyPrv) checkKillSwitch(proxyTyp tProxy.ProtoTyp, err error) {
+	// direct connection says nothing about proxy server
+	if !mgr.Proxies.KillSwitch || proxyTyp == tProxy.NoneProto {
+		return
+	}
+	if err == nil {
+		_ = mgr.releaseKillSwitch()
+		return
+	}
+	if tProxy.IsUnreachable(err) {
+		_ = mgr.engageKillSwitch()
+	}
+}
+
+// drop traffic of proxy app
+func (mgr *proxyPrv) engageKillSwitch() error {
+	mgr.killLock.Lock()
+	defer mgr.killLock.Unlock()
+	// kill switch is iptables rule, not available in bpf backend, and should not block captive portal login
+	if mgr.killSwitch || !mgr.Enabled || mgr.controller == nil || mgr.bpfMode() || mgr.isPaused() {
+		return nil
+	}
+	chain := mgr.manager.iptablesMgr.GetChain("filter", "OUTPUT")
+	if chain == nil {
+		logger.Warningf("[%s] has no filter OUTPUT chain", mgr.scope)
+		return errors.New("has no filter OUTPUT chain")
+	}
+	err := chain.InsertRule(0, mgr.killSwitchRule())
+	if err != nil {
+		logger.Warningf("[%s] engage kill switch failed, err: %v", mgr.scope, err)
+		return err
+	}
+	mgr.killSwitch = true
+	logger.Warningf("[%s] proxy server is unreachable, kill switch engaged", mgr.scope)
+	return nil
+}
+
+// stop dropping traffic of proxy app
+func (mgr *proxyPrv) releaseKillSwitch() error {
+	mgr.killLock.Lock()
+	defer mgr.killLock.Unlock()
+	if !mgr.killSwitch {
+		return nil
+	}
+	chain := mgr.manager.iptablesMgr.GetChain("filter", "OUTPUT")
+	if chain == nil {
+		logger.Warningf("[%s] has no filter OUTPUT chain", mgr.scope)
+		return errors.New("has no filter OUTPUT chain")
+	}
+	err := chain.DelRule(mgr.killSwitchRule())
+	if err != nil {
+		logger.Warningf("[%s] release kill switch failed, err: %v", mgr.scope, err)
+		return err
+	}
+	mgr.killSwitch = false
+	logger.Infof("[%s] kill switch released", mgr.scope)
+	return nil
+}
@@ -0,0 +1,86 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"sort"
+	"time"
+
+	"github.com/godbus/dbus"
+	config "github.com/linuxdeepin/deepin-network-proxy/config"
+	tProxy "github.com/linuxdeepin/deepin-network-proxy/tproxy"
+)
+
+// latency of tunnels is kept by handler manager of scope, by proto/name of proxy.
+// with least-latency strategy, tcp not assigned by app proxies or pac dials proxy of the same proto
+// whose median handshake is least, udp and dns still use proxy scope starts with.
+
+// percentiles of handshake and time to first byte by proxy
+func (mgr *proxyPrv) GetProxyLatency() ([]tProxy.LatencyStats, *dbus.Error) {
+	return mgr.handlerMgr.Latency(), nil
+}
+
+// build proxies of proto in use for least latency strategy
+func (mgr *proxyPrv) loadBalanced() {
+	var table map[string]appProxy
+	if mgr.Proxies.Strategy == config.StrategyLeastLatency {
+		mgr.proxyLock.Lock()
+		proto := mgr.proto
+		mgr.proxyLock.Unlock()
+		proxyTyp, err := buildProxyProto(proto)
+		if err != nil {
+			logger.Warningf("[%s] proto %s can not be balanced, err: %v", mgr.scope, proto, err)
+			return
+		}
+		table = make(map[string]appProxy)
+		for _, proxy := range mgr.Proxies.Proxies[proto] {
+			key := config.ProxyKey(proto, proxy.Name)
+			proxy, err = mgr.resolveSecret(proxy)
+			if err != nil {
+				logger.Warningf("[%s] get password of proxy %s failed, err: %v", mgr.scope, key, err)
+				continue
+			}
+			table[proxyLabel(proxyTyp, proxy)] = appProxy{proxyTyp: proxyTyp, proxy: proxy}
+		}
+		logger.Debugf("[%s] load %d proxies of least latency", mgr.scope, len(table))
+	}
+	mgr.proxyLock.Lock()
+	mgr.balanced = table
+	mgr.proxyLock.Unlock()
+}
+
+// proxy of least latency, false if strategy is not used
+func (mgr *proxyPrv) balancedProxy() (appProxy, bool) {
+	mgr.proxyLock.Lock()
+	labels := make([]string, 0, len(mgr.balanced))
+	for label := range mgr.balanced {
+		labels = append(labels, label)
+	}
+	table := mgr.balanced
+	mgr.proxyLock.Unlock()
+	sort.Strings(labels)
+	label, ok := mgr.handlerMgr.LeastLatency(labels)
+	if !ok {
+		return appProxy{}, false
+	}
+	return table[label], true
+}
+
+// record handshake of tunnel created, and first byte received later
+func (mgr *proxyPrv) trackLatency(handler tProxy.BaseHandler, event connEvent) {
+	elapsed := time.Since(event.start)
+	handshakeSeconds.Observe(elapsed.Seconds(), event.proxy)
+	mgr.handlerMgr.RecordHandshake(event.proxy, elapsed)
+	handler.OnFirstByte(func() {
+		elapsed := time.Since(event.start)
+		firstByteSeconds.Observe(elapsed.Seconds(), event.proxy)
+		mgr.handlerMgr.RecordFirstByte(event.proxy, elapsed)
+	})
+}
+
+// failed handshake makes proxy less likely to be chosen
+func (mgr *proxyPrv) recordHandshakeFailure(event connEvent) {
+	mgr.handlerMgr.RecordHandshakeFailure(event.proxy, time.Since(event.start))
+}
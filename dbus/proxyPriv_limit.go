@@ -0,0 +1,81 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"time"
+
+	config "github.com/linuxdeepin/deepin-network-proxy/config"
+	tProxy "github.com/linuxdeepin/deepin-network-proxy/tproxy"
+)
+
+// slot of tunnel is taken in accept loop before connection is handled, so that queue policy
+// stops accepting while tunnels are full, and kernel backlog pushes back on apps.
+// max of scope and of all scopes is set to limiters when config changes, connections read it under lock of limiter.
+
+// time connection waits for free tunnel in queue policy, closed after that
+const overflowQueueTimeout = 10 * time.Second
+
+// slot taken for connection
+type tunnelSlot struct {
+	release func()
+	direct  bool // tunnels are full, connect without proxy
+}
+
+// max of all scopes follows config, called when config is loaded or changed
+func (m *Manager) updateTunnelLimit() {
+	if m.config != nil {
+		m.tunnelLimit.SetMax(m.config.MaxTunnels)
+	}
+}
+
+// max of scope follows config, called when proxies of scope are changed
+func (mgr *proxyPrv) updateTunnelLimit() {
+	mgr.tunnelLimit.SetMax(mgr.Proxies.MaxTunnels)
+}
+
+// take slot of scope and of all scopes for connection, false if connection should be closed
+func (mgr *proxyPrv) acquireTunnel() (tunnelSlot, bool) {
+	// tunnel connecting directly holds fds too, so no policy applies
+	if ok, open, limit := mgr.manager.fdAvailable(); !ok {
+		fdRefusals.Add(1, mgr.scope.String())
+		logger.Warningf("[%s] open fds %d are near limit %d, new tunnel is refused", mgr.scope, open, limit)
+		mgr.emitFdLimitReached(open, limit)
+		return tunnelSlot{}, false
+	}
+	policy := mgr.Proxies.Overflow
+	if policy == "" {
+		policy = config.OverflowQueue
+	}
+	var timeout time.Duration
+	if policy == config.OverflowQueue {
+		timeout = overflowQueueTimeout
+	}
+	release, ok := tProxy.AcquireTunnel(timeout, mgr.tunnelLimit, mgr.manager.tunnelLimit)
+	if ok {
+		return tunnelSlot{release: release}, true
+	}
+	tunnelOverflows.Add(1, mgr.scope.String(), policy)
+	logger.Warningf("[%s] tunnels are full, %d of scope and %d of all scopes, overflow policy: %s",
+		mgr.scope, mgr.tunnelLimit.InUse(), mgr.manager.tunnelLimit.InUse(), policy)
+	// kill switch never lets traffic of scope leave without proxy
+	if policy == config.OverflowDirect && !mgr.Proxies.KillSwitch {
+		return tunnelSlot{release: func() {}, direct: true}, true
+	}
+	return tunnelSlot{}, false
+}
+
+// take slot for udp nat session, which relays by proxy only, so session over limit is closed
+func (mgr *proxyPrv) acquireUdpTunnel() (func(), bool) {
+	slot, ok := mgr.acquireTunnel()
+	if !ok {
+		return nil, false
+	}
+	if slot.direct {
+		slot.release()
+		return nil, false
+	}
+	return slot.release, true
+}
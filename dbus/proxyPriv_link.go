@@ -0,0 +1,191 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	com "github.com/linuxdeepin/deepin-network-proxy/com"
+	newIptables "github.com/linuxdeepin/deepin-network-proxy/new_iptables"
+)
+
+// when default route, vpn or connectivity of NetworkManager changes, running scope rebuilds rules matched by interface,
+// and dials proxy server again, kill switch follows the result instead of waiting next tunnel.
+// captive portal can not be logged in through proxy, interception and dns redirect are paused until portal is passed,
+// kill switch is released meanwhile. bpf backend can not be paused, procs keep being redirected.
+
+// timeout to dial proxy server after link changed
+const linkCheckTimeout = 3 * time.Second
+
+// react to link change, state is only checked for portal when read first time
+func (mgr *proxyPrv) linkChanged(prev linkState, cur linkState, first bool) {
+	if !mgr.Enabled {
+		return
+	}
+	if cur.portal() {
+		mgr.pauseInterception()
+		return
+	}
+	mgr.resumeInterception()
+	if first {
+		return
+	}
+	// interface of default route changed, or vpn device appears
+	if prev.primary != cur.primary || prev.vpn != cur.vpn {
+		mgr.reapplyInterfaceRules()
+	}
+	go mgr.checkUpstream()
+}
+
+// rebuild rules of scope restricted to interfaces
+func (mgr *proxyPrv) reapplyInterfaceRules() {
+	if len(mgr.Proxies.Interfaces) == 0 && len(mgr.Proxies.ExcludeInterfaces) == 0 || mgr.bpfMode() {
+		return
+	}
+	err := mgr.rebuildScopeRules()
+	if err != nil {
+		logger.Warningf("[%s] rebuild rules of interfaces after link changed failed, err: %v", mgr.scope, err)
+		return
+	}
+	logger.Infof("[%s] rules of interfaces rebuilt after link changed", mgr.scope)
+}
+
+// dial proxy server of scope, engage or release kill switch by result
+func (mgr *proxyPrv) checkUpstream() {
+	mgr.proxyLock.Lock()
+	proxy := mgr.Proxy
+	mgr.proxyLock.Unlock()
+	if proxy.Server == "" {
+		return
+	}
+	addr := net.JoinHostPort(proxy.Server, strconv.Itoa(proxy.Port))
+	opt := com.DialOpt{Device: proxy.Dial.Device, Mark: proxy.Dial.Mark}
+	conn, err := opt.Dialer(linkCheckTimeout).Dial("tcp", addr)
+	if err != nil {
+		logger.Warningf("[%s] proxy server %s is unreachable after link changed, err: %v", mgr.scope, addr, err)
+		mgr.emitUpstreamChecked(addr, false, err.Error())
+		if mgr.Proxies.KillSwitch {
+			_ = mgr.engageKillSwitch()
+		}
+		return
+	}
+	_ = conn.Close()
+	logger.Debugf("[%s] proxy server %s is reachable after link changed", mgr.scope, addr)
+	mgr.emitUpstreamChecked(addr, true, "")
+	if mgr.Proxies.KillSwitch {
+		_ = mgr.releaseKillSwitch()
+	}
+}
+
+// iptables -t mangle -I App -j RETURN
+func (mgr *proxyPrv) pauseRule() *newIptables.CompleteRule {
+	return &newIptables.CompleteRule{Action: newIptables.RETURN}
+}
+
+// check if interception is paused
+func (mgr *proxyPrv) isPaused() bool {
+	return atomic.LoadInt32(&mgr.paused) == 1
+}
+
+// stop redirecting traffic of scope, so that user can log in captive portal
+func (mgr *proxyPrv) pauseInterception() {
+	mgr.pauseLock.Lock()
+	defer mgr.pauseLock.Unlock()
+	if mgr.isPaused() || mgr.bpfMode() {
+		return
+	}
+	selfChain := mgr.chains[1]
+	if selfChain == nil {
+		logger.Warningf("[%s] cant pause interception, chain is nil", mgr.scope)
+		return
+	}
+	err := selfChain.InsertRule(0, mgr.pauseRule())
+	if err != nil {
+		logger.Warningf("[%s] pause interception failed, err: %v", mgr.scope, err)
+		return
+	}
+	mgr.insertScopeRule6(mgr.pauseRule())
+	// portal hijacks dns, query should reach it
+	err = mgr.releaseDNSRule()
+	if err != nil {
+		logger.Warningf("[%s] pause dns redirect failed, err: %v", mgr.scope, err)
+	}
+	atomic.StoreInt32(&mgr.paused, 1)
+	err = mgr.releaseKillSwitch()
+	if err != nil {
+		logger.Warningf("[%s] release kill switch for captive portal failed, err: %v", mgr.scope, err)
+	}
+	logger.Infof("[%s] captive portal detected, interception paused", mgr.scope)
+	mgr.emitInterceptionPaused(true)
+}
+
+// redirect traffic of scope again
+func (mgr *proxyPrv) resumeInterception() {
+	mgr.pauseLock.Lock()
+	defer mgr.pauseLock.Unlock()
+	if !mgr.isPaused() {
+		return
+	}
+	atomic.StoreInt32(&mgr.paused, 0)
+	if err := mgr.resumeRules(); err != nil {
+		logger.Warningf("[%s] resume interception failed, err: %v", mgr.scope, err)
+		return
+	}
+	logger.Infof("[%s] captive portal passed, interception resumed", mgr.scope)
+	mgr.emitInterceptionPaused(false)
+}
+
+// delete pause rule and add dns redirect back
+func (mgr *proxyPrv) resumeRules() error {
+	selfChain := mgr.chains[1]
+	if selfChain == nil {
+		return errors.New("chain is nil")
+	}
+	err := selfChain.DelRule(mgr.pauseRule())
+	if err != nil {
+		return err
+	}
+	mgr.delScopeRule6(mgr.pauseRule())
+	if !mgr.useDNSProxy() {
+		return nil
+	}
+	natChain := mgr.manager.iptablesMgr.GetChain("nat", "OUTPUT")
+	if natChain == nil {
+		return errors.New("has no nat OUTPUT chain")
+	}
+	for _, cpl := range mgr.dnsRedirectRules() {
+		err = natChain.AppendRule(cpl)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notify front end interception is paused or resumed
+func (mgr *proxyPrv) emitInterceptionPaused(paused bool) {
+	if mgr.manager == nil || mgr.manager.sysService == nil {
+		return
+	}
+	err := mgr.manager.sysService.Conn().Emit(mgr.getDBusPath(), mgr.GetInterfaceName()+".InterceptionPaused", paused)
+	if err != nil {
+		logger.Warningf("[%s] emit interception paused signal failed, err: %v", mgr.scope, err)
+	}
+}
+
+// notify front end result of dialing proxy server after link changed
+func (mgr *proxyPrv) emitUpstreamChecked(addr string, reachable bool, reason string) {
+	if mgr.manager == nil || mgr.manager.sysService == nil {
+		return
+	}
+	err := mgr.manager.sysService.Conn().Emit(mgr.getDBusPath(), mgr.GetInterfaceName()+".UpstreamChecked", addr, reachable, reason)
+	if err != nil {
+		logger.Warningf("[%s] emit upstream checked signal failed, err: %v", mgr.scope, err)
+	}
+}
@@ -0,0 +1,72 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"errors"
+	"strconv"
+
+	com "github.com/linuxdeepin/deepin-network-proxy/com"
+	newIptables "github.com/linuxdeepin/deepin-network-proxy/new_iptables"
+)
+
+// tunnel to proxy server adds headers, some servers sit behind path dropping icmp, so large segments are lost silently.
+// when mss of proxy is set, sockets to proxy server set TCP_MAXSEG, and syn of them is clamped in mangle POSTROUTING,
+// sockets are found by dial mark, or self mark of daemon if dial mark is not set.
+
+// iptables -t mangle -A POSTROUTING -p tcp --tcp-flags SYN,RST SYN -m mark --mark $DialMark -j TCPMSS --set-mss $MSS
+func (mgr *proxyPrv) mssClampRule() *newIptables.CompleteRule {
+	mss := mgr.Proxy.Dial.MSS
+	if mss == 0 {
+		return nil
+	}
+	mark := mgr.Proxy.Dial.Mark
+	if mark == 0 {
+		mark = com.SelfMark
+	}
+	return &newIptables.CompleteRule{
+		Action:    newIptables.TCPMSS,
+		BaseSl:    []newIptables.BaseRule{{Match: "-set-mss", Param: strconv.Itoa(mss)}},
+		ExtendsSl: []newIptables.ExtendsRule{newIptables.SynRule(), newIptables.MarkRule(mark, false)},
+	}
+}
+
+// add clamp rule, rule is kept so that the same one is deleted after proxy is reloaded
+func (mgr *proxyPrv) addMSSClamp(iptablesMgr *newIptables.Manager) error {
+	cpl := mgr.mssClampRule()
+	if cpl == nil {
+		return nil
+	}
+	chain := iptablesMgr.GetChain("mangle", "POSTROUTING")
+	if chain == nil {
+		logger.Warningf("[%s] has no mangle POSTROUTING chain", mgr.scope)
+		return errors.New("has no mangle POSTROUTING chain")
+	}
+	err := chain.AppendRule(cpl)
+	if err != nil {
+		return err
+	}
+	mgr.mssRule = cpl
+	return nil
+}
+
+// delete clamp rule
+func (mgr *proxyPrv) delMSSClamp() error {
+	if mgr.mssRule == nil {
+		return nil
+	}
+	chain := mgr.manager.iptablesMgr.GetChain("mangle", "POSTROUTING")
+	if chain == nil {
+		logger.Warningf("[%s] has no mangle POSTROUTING chain", mgr.scope)
+		return errors.New("has no mangle POSTROUTING chain")
+	}
+	err := chain.DelRule(mgr.mssRule)
+	if err != nil {
+		logger.Warningf("[%s] delete mss clamp rule failed, err: %v", mgr.scope, err)
+		return err
+	}
+	mgr.mssRule = nil
+	return nil
+}
@@ -0,0 +1,234 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	define "github.com/linuxdeepin/deepin-network-proxy/define"
+	newCGroups "github.com/linuxdeepin/deepin-network-proxy/new_cgroups"
+	newIptables "github.com/linuxdeepin/deepin-network-proxy/new_iptables"
+)
+
+// apps in containers and sandboxes with own network namespace are not seen by rules of host OUTPUT,
+// their traffic enters host from veth as forwarded packets. when netns is enabled, namespaces of procs of scope
+// are found periodically, and tcp from addrs of them is marked in mangle PREROUTING, so that tproxy rule of scope
+// redirects it to listener, or redirected to listener in nat PREROUTING in redirect mode.
+// daemon never enters namespaces, bpf mode is not supported as programs redirect to loopback of namespace.
+
+// interval to find namespaces of procs
+const netnsInterval = 10 * time.Second
+
+// find namespaces periodically
+func (m *Manager) startWatchNetNS() {
+	stop := make(chan bool)
+	m.netnsStop = stop
+	go func() {
+		ticker := time.NewTicker(netnsInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				for _, handler := range m.handler {
+					handler.syncNetNS()
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// stop finding namespaces
+func (m *Manager) stopWatchNetNS() {
+	if m.netnsStop == nil {
+		return
+	}
+	close(m.netnsStop)
+	m.netnsStop = nil
+}
+
+// chain of namespaces of scope
+func (mgr *proxyPrv) netnsChainName() string {
+	return mgr.chainName() + "_NetNS"
+}
+
+// addrs of namespaces procs of scope run in, sorted
+func (mgr *proxyPrv) netnsAddrs() ([]string, error) {
+	var nsSl []newCGroups.NetNS
+	var err error
+	if mgr.scope == define.Global {
+		// procs in cgroup of global are ignored apps
+		nsSl, err = newCGroups.FindNetNamespaces(mgr.controller)
+	} else if mgr.controller != nil {
+		nsSl, err = mgr.controller.NetNamespaces()
+	}
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var addrs []string
+	for _, ns := range nsSl {
+		for _, ip := range ns.Addrs {
+			if !seen[ip.String()] {
+				seen[ip.String()] = true
+				addrs = append(addrs, ip.String())
+			}
+		}
+	}
+	return addrs, nil
+}
+
+// redirect traffic of namespaces procs of scope run in, chain is rebuilt when addrs change
+func (mgr *proxyPrv) syncNetNS() {
+	var addrs []string
+	if mgr.Enabled && mgr.Proxies.NetNS && !mgr.bpfMode() {
+		var err error
+		addrs, err = mgr.netnsAddrs()
+		if err != nil {
+			logger.Warningf("[%s] find network namespaces failed, err: %v", mgr.scope, err)
+			return
+		}
+	}
+	// proxy may stop while finding namespaces, chain created after rules released is never removed
+	unlock := mgr.manager.lockRules()
+	defer unlock()
+	if !mgr.Enabled {
+		addrs = nil
+	}
+	mgr.netnsLock.Lock()
+	defer mgr.netnsLock.Unlock()
+	if strings.Join(addrs, ",") == strings.Join(mgr.netnsSources, ",") {
+		return
+	}
+	if mgr.manager.iptablesMgr == nil {
+		return
+	}
+	err := mgr.manager.iptablesMgr.Transaction(func() error {
+		err := mgr.releaseNetNSChain()
+		if err != nil {
+			return err
+		}
+		return mgr.createNetNSChain(addrs)
+	})
+	if err != nil {
+		logger.Warningf("[%s] redirect network namespaces failed, err: %v", mgr.scope, err)
+		mgr.netnsChain = nil
+		mgr.netnsSources = nil
+		return
+	}
+	mgr.netnsSources = addrs
+	logger.Infof("[%s] redirect network namespaces of addrs %v", mgr.scope, addrs)
+}
+
+// iptables -t mangle -I PREROUTING -p tcp -j App_NetNS
+func (mgr *proxyPrv) createNetNSChain(addrs []string) error {
+	if len(addrs) == 0 {
+		return nil
+	}
+	table := "mangle"
+	if mgr.redirectMode() {
+		table = "nat"
+	}
+	parent := mgr.manager.iptablesMgr.GetChain(table, "PREROUTING")
+	if parent == nil {
+		return errors.New("has no PREROUTING chain of " + table)
+	}
+	name := mgr.netnsChainName()
+	err := checkChain(table, name)
+	if err != nil {
+		return err
+	}
+	// before tproxy rule, which matches mark set here
+	chain, err := parent.CreateChild(name, 0, &newIptables.CompleteRule{
+		Action: name,
+		BaseSl: []newIptables.BaseRule{{Match: "p", Param: "tcp"}},
+	})
+	if err != nil {
+		return err
+	}
+	mgr.netnsChain = chain
+	// cidr of bypass returns before mark
+	if mgr.bypassSet != nil {
+		err = chain.AppendRule(mgr.bypassSetRule())
+		if err != nil {
+			return err
+		}
+	}
+	for _, addr := range addrs {
+		err = chain.AppendRule(mgr.netnsRule(addr))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// iptables -t mangle -A App_NetNS -s $addr -m addrtype ! --dst-type LOCAL -j MARK --set-mark $2
+// iptables -t nat -A App_NetNS -s $addr -m addrtype ! --dst-type LOCAL -j REDIRECT --to-ports $TPort
+func (mgr *proxyPrv) netnsRule(addr string) *newIptables.CompleteRule {
+	cpl := mgr.markRule()
+	if mgr.redirectMode() {
+		cpl = &newIptables.CompleteRule{
+			Action: newIptables.REDIRECT,
+			BaseSl: []newIptables.BaseRule{{Match: "-to-ports", Param: strconv.Itoa(mgr.Proxies.TPort)}},
+		}
+	}
+	cpl.BaseSl = append([]newIptables.BaseRule{{Match: "s", Param: addr}}, cpl.BaseSl...)
+	// traffic to host itself is not proxied, the same as lo of host
+	cpl.ExtendsSl = append(cpl.ExtendsSl, newIptables.ExtendsRule{
+		Match: "m",
+		Elem: newIptables.ExtendsElem{
+			Match: "addrtype",
+			Base:  newIptables.BaseRule{Not: true, Match: "dst-type", Param: "LOCAL"},
+		},
+	})
+	return cpl
+}
+
+// remove chain of namespaces
+func (mgr *proxyPrv) releaseNetNSChain() error {
+	if mgr.netnsChain == nil {
+		return nil
+	}
+	err := mgr.netnsChain.Remove()
+	if err != nil {
+		logger.Warningf("[%s] remove chain of network namespaces failed, err: %v", mgr.scope, err)
+		return err
+	}
+	mgr.netnsChain = nil
+	return nil
+}
+
+// remove chain of namespaces when proxy stops
+func (mgr *proxyPrv) releaseNetNS() error {
+	// the same order as sync, rule tree first
+	unlock := mgr.manager.lockRules()
+	defer unlock()
+	mgr.netnsLock.Lock()
+	defer mgr.netnsLock.Unlock()
+	mgr.netnsSources = nil
+	return mgr.releaseNetNSChain()
+}
+
+// check if connection comes from namespace redirected, its socket is not in host and has no owner
+func (mgr *proxyPrv) fromNetNS(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	mgr.netnsLock.Lock()
+	defer mgr.netnsLock.Unlock()
+	for _, source := range mgr.netnsSources {
+		if source == tcpAddr.IP.String() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"net"
+	"strconv"
+
+	config "github.com/linuxdeepin/deepin-network-proxy/config"
+	pac "github.com/linuxdeepin/deepin-network-proxy/pac"
+	tProxy "github.com/linuxdeepin/deepin-network-proxy/tproxy"
+)
+
+// proxy of connection is chosen by FindProxyForURL of pac, pac is set by url or discovered by wpad,
+// host is domain sniffed or resolved by fake ip, proxy in pac result listed in proxies of scope
+// uses its auth, the others are dialed without auth.
+// connection falls back to proxy of scope if pac fails.
+
+// load pac of scope, pac in use is kept if new one fails
+func (mgr *proxyPrv) loadPAC() error {
+	location := mgr.Proxies.PAC
+	if location == "" && mgr.Proxies.WPAD {
+		return mgr.discoverPAC()
+	}
+	if location == "" {
+		mgr.setPAC(nil)
+		return nil
+	}
+	script, err := pac.LoadScript(location)
+	if err != nil {
+		logger.Warningf("[%s] load pac %s failed, err: %v", mgr.scope, location, err)
+		return err
+	}
+	mgr.setPAC(script)
+	logger.Infof("[%s] load pac %s success", mgr.scope, location)
+	return nil
+}
+
+// discover pac by wpad, pac of last network is dropped if none found
+func (mgr *proxyPrv) discoverPAC() error {
+	var dhcpURL string
+	if mgr.manager != nil && mgr.manager.sysService != nil {
+		dhcpURL = dhcpWPAD(mgr.manager.sysService.Conn())
+	}
+	urls := pac.Candidates(dhcpURL, pac.SearchDomains(pac.ResolvConfPath))
+	script, url, err := pac.Discover(urls)
+	mgr.setPAC(script)
+	if err != nil {
+		logger.Warningf("[%s] discover pac by wpad failed, use proxy of scope, err: %v", mgr.scope, err)
+		return err
+	}
+	logger.Infof("[%s] discover pac %s by wpad success", mgr.scope, url)
+	return nil
+}
+
+// discover pac again when network changes
+func (mgr *proxyPrv) rediscoverPAC() {
+	if !mgr.Enabled || mgr.Proxies.PAC != "" || !mgr.Proxies.WPAD {
+		return
+	}
+	_ = mgr.discoverPAC()
+}
+
+// replace pac in use, nil disables pac
+func (mgr *proxyPrv) setPAC(script *pac.Script) {
+	mgr.proxyLock.Lock()
+	mgr.pac = script
+	mgr.proxyLock.Unlock()
+	if script != nil {
+		mgr.loadPACProxies()
+	}
+}
+
+// auth of proxies in config by server addr
+func (mgr *proxyPrv) loadPACProxies() {
+	table := make(map[string]config.Proxy)
+	for _, proxies := range mgr.Proxies.Proxies {
+		for _, proxy := range proxies {
+			proxy, err := mgr.resolveSecret(proxy)
+			if err != nil {
+				logger.Warningf("[%s] get password of proxy %s failed, err: %v", mgr.scope, proxy.Name, err)
+			}
+			table[net.JoinHostPort(proxy.Server, strconv.Itoa(proxy.Port))] = proxy
+		}
+	}
+	mgr.proxyLock.Lock()
+	mgr.pacProxies = table
+	mgr.proxyLock.Unlock()
+}
+
+// proxy chosen by pac for remote and directive used, false if no pac or pac fails
+func (mgr *proxyPrv) pacProxyOf(rAddr net.Addr) (appProxy, string, bool) {
+	mgr.proxyLock.Lock()
+	script, table := mgr.pac, mgr.pacProxies
+	mgr.proxyLock.Unlock()
+	if script == nil {
+		return appProxy{}, "", false
+	}
+	var host string
+	var port int
+	switch addr := rAddr.(type) {
+	case *tProxy.DomainAddr:
+		host, port = addr.Domain, addr.Port
+	case *net.TCPAddr:
+		host, port = addr.IP.String(), addr.Port
+	default:
+		return appProxy{}, "", false
+	}
+	result, err := script.FindProxy(pac.URLOf(host, port), host)
+	if err != nil {
+		logger.Warningf("[%s] evaluate pac for %s failed, err: %v", mgr.scope, host, err)
+		return appProxy{}, "", false
+	}
+	directives, err := pac.ParseResult(result)
+	if err != nil {
+		logger.Warningf("[%s] %v", mgr.scope, err)
+		return appProxy{}, "", false
+	}
+	// first directive supported is used, proxy failed is not retried with next one
+	for _, directive := range directives {
+		var proxyTyp tProxy.ProtoTyp
+		var proto string
+		switch directive.Type {
+		case pac.Direct:
+			return appProxy{proxyTyp: tProxy.NoneProto}, directive.String(), true
+		case pac.Proxy, pac.HTTP:
+			proxyTyp, proto = tProxy.HTTP, "http"
+		case pac.Socks, pac.Socks5:
+			proxyTyp, proto = tProxy.SOCKS5TCP, "socks5"
+		case pac.Socks4:
+			proxyTyp, proto = tProxy.SOCKS4, "socks4"
+		default:
+			// proxy over tls is not supported
+			continue
+		}
+		proxy, ok := table[net.JoinHostPort(directive.Host, strconv.Itoa(directive.Port))]
+		if !ok {
+			proxy = config.Proxy{Name: directive.String(), Server: directive.Host, Port: directive.Port}
+		}
+		proxy.ProtoType = proto
+		return appProxy{proxyTyp: proxyTyp, proxy: proxy}, directive.String(), true
+	}
+	logger.Debugf("[%s] pac result %s of %s is not supported", mgr.scope, result, host)
+	return appProxy{}, "", false
+}
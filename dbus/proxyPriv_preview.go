@@ -0,0 +1,55 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"github.com/godbus/dbus"
+	config "github.com/linuxdeepin/deepin-network-proxy/config"
+	define "github.com/linuxdeepin/deepin-network-proxy/define"
+	newIptables "github.com/linuxdeepin/deepin-network-proxy/new_iptables"
+	"github.com/linuxdeepin/go-lib/dbusutil"
+)
+
+// preview iptables commands which will be run when start proxy with proxies, nothing is changed
+func (mgr *proxyPrv) PreviewRules(proxies config.ScopeProxies) ([]string, *dbus.Error) {
+	lines, err := mgr.previewRules(proxies)
+	if err != nil {
+		logger.Warningf("[%s] preview rules failed, err: %v", mgr.scope, err)
+		return nil, dbusutil.ToError(err)
+	}
+	return lines, nil
+}
+
+// build rules on a dry run iptables manager, return command lines
+func (mgr *proxyPrv) previewRules(proxies config.ScopeProxies) ([]string, error) {
+	lines := []string{}
+	iptablesMgr := newIptables.NewManager()
+	iptablesMgr.Init()
+	iptablesMgr.SetDryRun(&lines)
+	iptablesMgr.SetComment(newIptables.Comment)
+	// rules only depends on scope, proxies, proxy in use and redirect mode
+	prv := &proxyPrv{
+		scope:   mgr.scope,
+		Proxies: proxies,
+		manager: mgr.manager,
+		// proxy server in use is exempted by global scope
+		Proxy: mgr.activeProxy(),
+		// set is created when start
+		bypassSet: mgr.bypassSet,
+	}
+	mainChain, err := initMainChain(iptablesMgr, prv.mainTable(), prv.manager.chainName(define.Main), prv.manager.directPath())
+	if err != nil {
+		return nil, err
+	}
+	chains, err := prv.buildTable(iptablesMgr, mainChain)
+	if err != nil {
+		return nil, err
+	}
+	err = prv.buildRule(chains)
+	if err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
@@ -0,0 +1,57 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/godbus/dbus"
+	tProxy "github.com/linuxdeepin/deepin-network-proxy/tproxy"
+	"github.com/linuxdeepin/go-lib/dbusutil"
+)
+
+// proxy is tested stage by stage with credentials in config, so caller needs action of config,
+// otherwise test tells whether password guessed is right. secret of proxy is looked up in keyring of caller,
+// so that proxy can be tested before scope is started.
+
+// test proxy of scope by name as proto/name, report tells latency of every stage and stage failed
+func (mgr *proxyPrv) TestProxy(sender dbus.Sender, name string) (tProxy.ProbeReport, *dbus.Error) {
+	dErr := mgr.authorize(sender, actionConfig)
+	if dErr != nil {
+		return tProxy.ProbeReport{}, dErr
+	}
+	proto, proxyName, ok := strings.Cut(name, "/")
+	if !ok {
+		return tProxy.ProbeReport{}, dbusutil.ToError(fmt.Errorf("proxy %s is not proto/name", name))
+	}
+	proxyTyp, err := buildProxyProto(proto)
+	if err != nil {
+		return tProxy.ProbeReport{}, dbusutil.ToError(err)
+	}
+	proxy, err := mgr.Proxies.GetProxy(proto, proxyName)
+	if err != nil {
+		return tProxy.ProbeReport{}, dbusutil.ToError(err)
+	}
+	uid, gid, err := mgr.callerIDs(sender)
+	if err != nil {
+		return tProxy.ProbeReport{}, dbusutil.ToError(err)
+	}
+	proxy, err = resolveSecretOf(uid, gid, proxy)
+	if err != nil {
+		return tProxy.ProbeReport{}, dbusutil.ToError(err)
+	}
+	report, err := tProxy.ProbeProxy(proxyTyp, proxy, tProxy.ProbeTarget)
+	if err != nil {
+		return tProxy.ProbeReport{}, dbusutil.ToError(err)
+	}
+	report.Proxy = name
+	if report.Passed {
+		logger.Infof("[%s] test of proxy %s passed", mgr.scope, report.Proxy)
+	} else {
+		logger.Warningf("[%s] test of proxy %s failed at %s", mgr.scope, report.Proxy, report.FailedStage)
+	}
+	return report, nil
+}
@@ -7,17 +7,21 @@ package DBus
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"io/ioutil"
 	"net"
 	"os"
 	"os/user"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/godbus/dbus"
 	com "github.com/linuxdeepin/deepin-network-proxy/com"
 	config "github.com/linuxdeepin/deepin-network-proxy/config"
+	logging "github.com/linuxdeepin/deepin-network-proxy/logging"
 	newCGroups "github.com/linuxdeepin/deepin-network-proxy/new_cgroups"
 	tProxy "github.com/linuxdeepin/deepin-network-proxy/tproxy"
 	"github.com/linuxdeepin/go-lib/dbusutil"
@@ -33,7 +37,12 @@ func (mgr *proxyPrv) GetProxy() (string, *dbus.Error) {
 	if mgr.Proxy.ProtoType == "" {
 		return "", nil
 	}
-	buf, err := com.MarshalJson(mgr.Proxy)
+	proxy := mgr.activeProxy()
+	// password from keyring is never exposed
+	if proxy.SecretID != "" {
+		proxy.Password = ""
+	}
+	buf, err := com.MarshalJson(proxy)
 	if err != nil {
 		logger.Warningf("[%s] get proxy failed, err: %v", mgr.scope, err)
 		return "", dbusutil.ToError(err)
@@ -43,17 +52,23 @@ func (mgr *proxyPrv) GetProxy() (string, *dbus.Error) {
 
 // start proxy
 func (mgr *proxyPrv) StartProxy(sender dbus.Sender, proto string, name string, udp bool) *dbus.Error {
+	dErr := mgr.authorize(sender, actionStartStop)
+	if dErr != nil {
+		return dErr
+	}
 	con, err := dbusutil.NewSystemService()
 	if err != nil {
 		logger.Warningf("get session service failed, err: %v", err)
 		return dbusutil.ToError(err)
 	}
-	mgr.uid, err = con.GetConnUID(string(sender))
+	// state changes even if proxy fails halfway
+	defer mgr.manager.notifyState()
+	uid, err := con.GetConnUID(string(sender))
 	if err != nil {
 		logger.Warningf("get name owner failed, err: %v", err)
 		return dbusutil.ToError(err)
 	}
-	id, err := user.LookupId(strconv.Itoa(int(mgr.uid)))
+	id, err := user.LookupId(strconv.Itoa(int(uid)))
 	if err != nil {
 		return dbusutil.ToError(err)
 	}
@@ -61,9 +76,27 @@ func (mgr *proxyPrv) StartProxy(sender dbus.Sender, proto string, name string, u
 	if err != nil {
 		return dbusutil.ToError(err)
 	}
-	mgr.gid = uint32(gid)
+	pid, err := con.GetConnPID(string(sender))
+	if err != nil {
+		logger.Warningf("get pid of caller failed, err: %v", err)
+		return dbusutil.ToError(err)
+	}
+	// rules of last user are removed before owner changes
 	if mgr.Enabled {
-		_ = mgr.StopProxy()
+		_ = mgr.stopProxy()
+	}
+	// tunnels draining of last run are cut, rules are released before new ones are added
+	mgr.finishDrain(true)
+	mgr.uid = uid
+	mgr.gid = uint32(gid)
+	mgr.session = mgr.manager.sessionOf(pid)
+	// proxies of caller are used if caller has own config
+	changed, err := mgr.manager.loadCallerConfig(mgr.uid, pid)
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+	if changed {
+		mgr.loadConfig()
 	}
 
 	//// already in proxy
@@ -74,15 +107,9 @@ func (mgr *proxyPrv) StartProxy(sender dbus.Sender, proto string, name string, u
 	//mgr.stop = false
 	logger.Debugf("[%s] start proxy, proto [%s] name [%s] udp [%v]", mgr.scope, proto, name, udp)
 	// check if proto is legal
-	var proxyTyp tProxy.ProtoTyp
-	if proto == "socks5" {
-		// never err
-		proxyTyp = tProxy.SOCKS5TCP
-	} else {
-		proxyTyp, err = tProxy.BuildProto(proto)
-		if err != nil {
-			return dbusutil.ToError(err)
-		}
+	proxyTyp, err := buildProxyProto(proto)
+	if err != nil {
+		return dbusutil.ToError(err)
 	}
 	// get proxies
 	proxy, err := mgr.Proxies.GetProxy(proto, name)
@@ -90,30 +117,47 @@ func (mgr *proxyPrv) StartProxy(sender dbus.Sender, proto string, name string, u
 		logger.Warningf("[%s] get proxy failed, err: %v", mgr.scope, err)
 		return dbusutil.ToError(err)
 	}
+	proxy, err = mgr.resolveSecret(proxy)
+	if err != nil {
+		logger.Warningf("[%s] get password of proxy failed, err: %v", mgr.scope, err)
+		return dbusutil.ToError(err)
+	}
 	// save proxy
+	mgr.proxyLock.Lock()
 	mgr.Proxy = proxy
+	mgr.proto = proto
+	mgr.proxyLock.Unlock()
+	// first tunnel dont wait for resolving proxy server
+	tProxy.PreResolve(proxy)
+	// invalid bypass rule should not block proxy
+	_ = mgr.loadBypass()
+	mgr.loadAppProxies()
+	mgr.loadBalanced()
+	// pac failed should not block proxy, proxy of scope is used
+	_ = mgr.loadPAC()
+	// tunnels are relayed by epoll if daemon started it
+	mgr.handlerMgr.SetRelay(mgr.manager.relay)
+	// handlers missed closing are dropped while proxy runs
+	mgr.handlerMgr.StartCollect(0)
 	logger.Debugf("[%s] get proxy success, proxy: %v", mgr.scope, proxy)
 	// tcp module
-	listen, err := mgr.listen()
+	listeners, err := mgr.listen()
 	if err != nil {
 		return dbusutil.ToError(err)
 	}
 	// save tcp handler
-	mgr.tcpHandler = listen
+	mgr.tcpHandlers = listeners
+	atomic.StoreInt32(&mgr.listenBroken, 0)
 	logger.Debugf("[%s] proxy [%s] listen tcp success at port %v", mgr.scope, proto, mgr.Proxies.TPort)
 	// in case blocks DBus-return, use goroutine
-	go mgr.accept(proxyTyp, proxy, listen)
+	for _, listen := range listeners {
+		go mgr.accept(proxyTyp, listen)
+	}
 
 	// udp module
-	if udp && proto == "sock5" {
-		// listen packet conn
-		packetConn, err := mgr.listenPacket()
-		if err != nil {
-			return dbusutil.ToError(err)
-		}
-		logger.Debugf("[%s] proxy [%s] listen udp success at port %v", mgr.scope, proto, mgr.Proxies.TPort)
-		// start proxy udp
-		go mgr.readMsgUDP(proxyTyp, proxy, packetConn)
+	err = mgr.startUdp(proxyTyp, proxy, udp)
+	if err != nil {
+		return dbusutil.ToError(err)
 	}
 
 	// mark enable
@@ -125,8 +169,22 @@ func (mgr *proxyPrv) StartProxy(sender dbus.Sender, proto string, name string, u
 		return dbusutil.ToError(err)
 	}
 
+	// user has not logged in captive portal yet
+	if mgr.manager.linkState().portal() {
+		mgr.pauseInterception()
+	}
+
+	// namespaces are found at once instead of next period
+	if mgr.Proxies.NetNS {
+		go mgr.syncNetNS()
+	}
+
+	// fake ip and proxy dns are optional, dns is redirected by iptables
+	if !mgr.useDNSProxy() || mgr.bpfMode() {
+		return nil
+	}
 	go func() {
-		err := mgr.dnsProxy.startDNSProxy()
+		err := mgr.dnsProxy.startDNSProxy(proxyTyp, proxy)
 		if err != nil {
 			logger.Warningf("start dns proxy failed: %v", err)
 		}
@@ -135,9 +193,83 @@ func (mgr *proxyPrv) StartProxy(sender dbus.Sender, proto string, name string, u
 	return nil
 }
 
+// listen udp and start relaying if proto carries udp
+func (mgr *proxyPrv) startUdp(proxyTyp tProxy.ProtoTyp, proxy config.Proxy, udp bool) error {
+	if udp && mgr.redirectMode() {
+		logger.Warningf("[%s] udp can not be proxied in redirect mode", mgr.scope)
+		udp = false
+	}
+	if udp && mgr.bpfMode() {
+		logger.Warningf("[%s] udp can not be proxied by bpf backend", mgr.scope)
+		udp = false
+	}
+	// socks5 relays udp by udp associate, masque by CONNECT-UDP, other protos can not carry udp
+	if udp && (proxyTyp == tProxy.SOCKS5TCP || proxyTyp == tProxy.MASQUETCP) {
+		// listen packet conn
+		packetConns, err := mgr.listenPacket()
+		if err != nil {
+			return err
+		}
+		// save udp handler
+		mgr.udpHandlers = packetConns
+		logger.Debugf("[%s] proxy [%s] listen udp success at port %v", mgr.scope, proxyTyp, mgr.Proxies.TPort)
+		// udp proto according to tcp proto
+		udpTyp := tProxy.SOCKS5UDP
+		if proxyTyp == tProxy.MASQUETCP {
+			udpTyp = tProxy.MASQUEUDP
+		}
+		// flows bypassed and of masque use one tunnel per flow, packages of one flow go to the same worker,
+		// so order is kept and the first package creates the only tunnel of flow
+		flowPool := tProxy.NewUdpWorkerPool(0, 0, func(pkg tProxy.UdpPackage) {
+			mgr.proxyUdp(udpTyp, mgr.activeProxy(), pkg.LAddr, pkg.RAddr, pkg.Data)
+		})
+		mgr.udpLock.Lock()
+		mgr.udpFlowPool = flowPool
+		mgr.udpLock.Unlock()
+		// socks5 udp relay knows remote of each package, so client endpoint can share one relay as full cone nat,
+		// connect-udp of masque is bound to one remote, still use one tunnel per flow
+		var udpPool *tProxy.UdpWorkerPool
+		if proxyTyp != tProxy.MASQUETCP {
+			natTable := tProxy.NewUdpNatTable(mgr.scope, proxy)
+			natTable.SetAssociateHook(func(err error) {
+				mgr.checkKillSwitch(tProxy.SOCKS5UDP, err)
+			})
+			natTable.SetSlotHook(mgr.acquireUdpTunnel)
+			// packages are relayed by fixed workers, package of one client endpoint by the same worker
+			udpPool = tProxy.NewUdpWorkerPool(0, 0, func(pkg tProxy.UdpPackage) {
+				mgr.relayUdp(natTable, pkg.LAddr, pkg.RAddr, pkg.Data)
+			})
+			mgr.udpLock.Lock()
+			mgr.udpNat = natTable
+			mgr.udpPool = udpPool
+			mgr.udpLock.Unlock()
+		}
+		// start proxy udp, listeners of both families share nat sessions
+		for _, packetConn := range packetConns {
+			go mgr.readMsgUDP(udpPool, flowPool, packetConn)
+		}
+	}
+	return nil
+}
+
 // stop proxy
-func (mgr *proxyPrv) StopProxy() *dbus.Error {
-	if !mgr.Enabled {
+func (mgr *proxyPrv) StopProxy(sender dbus.Sender) *dbus.Error {
+	dErr := mgr.authorize(sender, actionStartStop)
+	if dErr != nil {
+		return dErr
+	}
+	return mgr.stopProxy()
+}
+
+// stop proxy, called by daemon itself without authorization
+func (mgr *proxyPrv) stopProxy() *dbus.Error {
+	// stop by dbus call and by session end may come together, only one runs,
+	// and sync of namespaces running sees proxy stopped before rules are released
+	unlock := mgr.manager.lockRules()
+	enabled := mgr.Enabled
+	mgr.Enabled = false
+	unlock()
+	if !enabled {
 		return nil
 	}
 	//if mgr.stop {
@@ -145,24 +277,46 @@ func (mgr *proxyPrv) StopProxy() *dbus.Error {
 	//	return nil
 	//}
 	//mgr.stop = true
-	logger.Debugf("[%s] stop proxy, enable: %v, proxy: %v", mgr.scope, mgr.Enabled, mgr.Proxy)
-	// stop to break accept and read message
-	if mgr.tcpHandler != nil {
-		err := mgr.tcpHandler.Close()
+	logger.Debugf("[%s] stop proxy, proxy: %v", mgr.scope, mgr.Proxy)
+	defer mgr.manager.notifyState()
+	// new connection is not redirected before listener closes
+	draining := mgr.startDrain()
+	// stop to break accept, established tunnels are not affected
+	for _, listen := range mgr.tcpHandlers {
+		err := listen.Close()
 		if err != nil {
 			logger.Warningf("[%s] stop proxy tcp handler failed, err: %v", mgr.scope, err)
 		}
 	}
-	if mgr.udpHandler != nil {
-		err := mgr.udpHandler.Close()
+	mgr.tcpHandlers = nil
+	// let established tunnels finish, packages of udp tunnels still come from udp handler
+	if draining {
+		mgr.drainAsync()
+		return nil
+	}
+	return mgr.releaseProxy()
+}
+
+// close udp handlers and tunnels left, then release rules and dns proxy
+func (mgr *proxyPrv) releaseProxy() *dbus.Error {
+	for _, packetConn := range mgr.udpHandlers {
+		err := packetConn.Close()
 		if err != nil {
 			logger.Warningf("[%s] stop proxy udp handler failed, err: %v", mgr.scope, err)
 		}
 	}
+	mgr.udpHandlers = nil
+	_ = mgr.cutTunnels()
 
-	mgr.Enabled = false
+	// proxy is stopped, traffic should not be dropped any more
+	err := mgr.releaseKillSwitch()
+	if err != nil {
+		logger.Warningf("release kill switch failed, err: %v", err)
+	}
+	// rules removed by pause are restored, so that release finds them
+	mgr.resumeInterception()
 
-	err := mgr.stopRedirect()
+	err = mgr.stopRedirect()
 	if err != nil {
 		logger.Warningf("stop redirect failed, err: %v", err)
 		return dbusutil.ToError(err)
@@ -182,6 +336,9 @@ func (mgr *proxyPrv) attachBackUser() error {
 	pathSl := []string{"/sys", "fs", "cgroup", "unified", "user.slice", "user-" + strconv.Itoa(int(mgr.uid)) + ".slice", "cgroup.procs"}
 	path := strings.Join(pathSl, "/")
 	logger.Debugf("attach back cgroup user is %s", path)
+	if mgr.controller == nil {
+		return nil
+	}
 	ctl := mgr.controller.GetControlPath()
 	if _, err := os.Stat(ctl); err != nil {
 		logger.Warningf("attach back file not exist, err: %v", err)
@@ -215,7 +372,11 @@ func (mgr *proxyPrv) attachBackUser() error {
 }
 
 // set proxy
-func (mgr *proxyPrv) AddProxy(proto string, name string, jsonProxy []byte) *dbus.Error {
+func (mgr *proxyPrv) AddProxy(sender dbus.Sender, proto string, name string, jsonProxy []byte) *dbus.Error {
+	dErr := mgr.authorize(sender, actionConfig)
+	if dErr != nil {
+		return dErr
+	}
 	proxy, err := UnMarshalProxy(jsonProxy)
 	if err != nil {
 		logger.Warningf("[%s] unmarshal proxy message failed, err: %v", mgr.scope, err)
@@ -227,8 +388,14 @@ func (mgr *proxyPrv) AddProxy(proto string, name string, jsonProxy []byte) *dbus
 }
 
 // set proxies
-func (mgr *proxyPrv) SetProxies(proxies config.ScopeProxies) *dbus.Error {
+func (mgr *proxyPrv) SetProxies(sender dbus.Sender, proxies config.ScopeProxies) *dbus.Error {
+	dErr := mgr.authorize(sender, actionConfig)
+	if dErr != nil {
+		return dErr
+	}
 	mgr.Proxies = proxies
+	mgr.updateTunnelLimit()
+	_ = mgr.loadBypass()
 	err := mgr.writeConfig()
 	if err != nil {
 		logger.Warningf("[%s] write config failed, err: %v", mgr.scope, err)
@@ -237,7 +404,11 @@ func (mgr *proxyPrv) SetProxies(proxies config.ScopeProxies) *dbus.Error {
 	return nil
 }
 
-func (mgr *proxyPrv) ClearProxy() *dbus.Error {
+func (mgr *proxyPrv) ClearProxy(sender dbus.Sender) *dbus.Error {
+	dErr := mgr.authorize(sender, actionConfig)
+	if dErr != nil {
+		return dErr
+	}
 	mgr.Proxies.Proxies = nil
 	err := mgr.writeConfig()
 	if err != nil {
@@ -247,13 +418,50 @@ func (mgr *proxyPrv) ClearProxy() *dbus.Error {
 	return nil
 }
 
-// set tcp opt listen
-func (mgr *proxyPrv) listen() (net.Listener, error) {
+// networks of listeners enabled by config, such as tcp4 and tcp6
+func (mgr *proxyPrv) listenNetworks(proto string) []string {
+	var networks []string
+	if !mgr.Proxies.DisableIPv4 {
+		networks = append(networks, proto+"4")
+	}
+	if !mgr.Proxies.DisableIPv6 {
+		networks = append(networks, proto+"6")
+	}
+	return networks
+}
+
+// check if listen failed as family is not supported by host, such as ipv6 is disabled by kernel
+func familyUnsupported(err error) bool {
+	return errors.Is(err, syscall.EAFNOSUPPORT) || errors.Is(err, syscall.EADDRNOTAVAIL)
+}
+
+// set tcp opt listen, listen each family enabled
+func (mgr *proxyPrv) listen() ([]net.Listener, error) {
+	var listeners []net.Listener
+	for _, network := range mgr.listenNetworks("tcp") {
+		l, err := mgr.listenTcp(network)
+		if err != nil && network == "tcp6" && familyUnsupported(err) && len(listeners) != 0 {
+			logger.Warningf("[%s] ipv6 is not supported, listen ipv4 only, err: %v", mgr.scope, err)
+			continue
+		}
+		if err != nil {
+			for _, listen := range listeners {
+				_ = listen.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// set tcp opt listen of one family
+func (mgr *proxyPrv) listenTcp(network string) (net.Listener, error) {
 	// get proxies
 	tp := strconv.Itoa(mgr.Proxies.TPort)
-	l, err := net.Listen("tcp", ":"+tp)
+	l, err := net.Listen(network, ":"+tp)
 	if err != nil {
-		logger.Warningf("[%s] listen port failed, err: %v", mgr.scope, err)
+		logger.Warningf("[%s] listen %s port failed, err: %v", mgr.scope, network, err)
 		return nil, err
 	}
 	// convert to tcp listener
@@ -262,36 +470,56 @@ func (mgr *proxyPrv) listen() (net.Listener, error) {
 		logger.Warningf("[%s] listener is not tcp listener type", mgr.scope)
 		return nil, err
 	}
-	// get file
-	file, err := tl.File()
+	// get raw conn, option is set on fd of listener, no fd is duplicated
+	rawConn, err := tl.SyscallConn()
 	if err != nil {
-		logger.Warningf("[%s] tcp listener get file failed, err: %v", err)
+		logger.Warningf("[%s] tcp listener get raw conn failed, err: %v", mgr.scope, err)
 		return nil, err
 	}
-	defer file.Close()
 	// set transparent
-	err = com.SetSockOptTrn(int(file.Fd()))
-	if err != nil {
-		logger.Warningf("[%s] set fd opt transparent failed, err: %v", mgr.scope, err)
-		return nil, err
+	var optErr error
+	err = rawConn.Control(func(fd uintptr) {
+		optErr = com.SetSockOptTrn(int(fd))
+	})
+	if err == nil {
+		err = optErr
 	}
-	// set non block
-	err = syscall.SetNonblock(int(file.Fd()), true)
 	if err != nil {
-		logger.Warningf("[%s] set non block failed, err: %v", mgr.scope, err)
+		logger.Warningf("[%s] set fd opt transparent failed, err: %v", mgr.scope, err)
+		_ = l.Close()
 		return nil, err
 	}
 
 	return l, nil
 }
 
-// set udp opt listen
-func (mgr *proxyPrv) listenPacket() (net.PacketConn, error) {
+// set udp opt listen, listen each family enabled
+func (mgr *proxyPrv) listenPacket() ([]net.PacketConn, error) {
+	var packetConns []net.PacketConn
+	for _, network := range mgr.listenNetworks("udp") {
+		l, err := mgr.listenUdp(network)
+		if err != nil && network == "udp6" && familyUnsupported(err) && len(packetConns) != 0 {
+			logger.Warningf("[%s] ipv6 is not supported, listen udp of ipv4 only, err: %v", mgr.scope, err)
+			continue
+		}
+		if err != nil {
+			for _, packetConn := range packetConns {
+				_ = packetConn.Close()
+			}
+			return nil, err
+		}
+		packetConns = append(packetConns, l)
+	}
+	return packetConns, nil
+}
+
+// set udp opt listen of one family
+func (mgr *proxyPrv) listenUdp(network string) (net.PacketConn, error) {
 	// get proxies
 	tp := strconv.Itoa(mgr.Proxies.TPort)
-	l, err := net.ListenPacket("udp", ":"+tp)
+	l, err := net.ListenPacket(network, ":"+tp)
 	if err != nil {
-		logger.Warningf("[%s] listen udp package port failed, err: %v", mgr.scope, err)
+		logger.Warningf("[%s] listen %s package port failed, err: %v", mgr.scope, network, err)
 		return nil, err
 	}
 	// ip_transparent
@@ -303,13 +531,14 @@ func (mgr *proxyPrv) listenPacket() (net.PacketConn, error) {
 	err = com.SetConnOptTrn(conn)
 	if err != nil {
 		logger.Warningf("set conn opt transparent failed, err: %v", err)
+		_ = l.Close()
 		return nil, err
 	}
 	return l, nil
 }
 
 // proxy tcp
-func (mgr *proxyPrv) accept(proxyTyp tProxy.ProtoTyp, proxy config.Proxy, listen net.Listener) {
+func (mgr *proxyPrv) accept(proxyTyp tProxy.ProtoTyp, listen net.Listener) {
 	if listen == nil {
 		logger.Warningf("[%s] tcp listener is nil", mgr.scope)
 		return
@@ -326,18 +555,67 @@ func (mgr *proxyPrv) accept(proxyTyp tProxy.ProtoTyp, proxy config.Proxy, listen
 				break
 			}
 			logger.Warningf("[%s] accept socket failed, err: %v", proxyTyp, err)
+			// reopened by watchdog
+			if mgr.ownsListener(listen) {
+				atomic.StoreInt32(&mgr.listenBroken, 1)
+			}
 			break
 		}
-		// proxy tcp
-		go mgr.proxyTcp(proxyTyp, proxy, lConn)
+		// wait here if tunnels are full, connection over limit is closed
+		slot, ok := mgr.acquireTunnel()
+		if !ok {
+			_ = lConn.Close()
+			continue
+		}
+		// proxy tcp, proxy may be replaced by reloaded config
+		go mgr.proxyTcp(proxyTyp, mgr.activeProxy(), lConn, slot)
 	}
-	logger.Debugf("[%s] stop proxy, prepare close handler", mgr.scope)
-	mgr.handlerMgr.CloseTypHandler(proxyTyp)
-	mgr.tcpHandler = nil
+	// handlers are closed after drain when stop proxy
+	logger.Debugf("[%s] stop proxy tcp", mgr.scope)
+}
+
+// reopen listener if accept failed while proxy is enabled, return true if reopened
+func (mgr *proxyPrv) healListener() (bool, error) {
+	if !mgr.Enabled || atomic.LoadInt32(&mgr.listenBroken) == 0 {
+		return false, nil
+	}
+	mgr.proxyLock.Lock()
+	proto := mgr.proto
+	mgr.proxyLock.Unlock()
+	proxyTyp, err := buildProxyProto(proto)
+	if err != nil {
+		return false, err
+	}
+	// listeners of all families are reopened, accept of the healthy one breaks when it is closed
+	old := mgr.tcpHandlers
+	mgr.tcpHandlers = nil
+	for _, listen := range old {
+		_ = listen.Close()
+	}
+	listeners, err := mgr.listen()
+	if err != nil {
+		return false, err
+	}
+	mgr.tcpHandlers = listeners
+	atomic.StoreInt32(&mgr.listenBroken, 0)
+	for _, listen := range listeners {
+		go mgr.accept(proxyTyp, listen)
+	}
+	return true, nil
+}
+
+// check if listener is one of current tcp listeners
+func (mgr *proxyPrv) ownsListener(listen net.Listener) bool {
+	for _, elem := range mgr.tcpHandlers {
+		if elem == listen {
+			return true
+		}
+	}
+	return false
 }
 
 // read udp message
-func (mgr *proxyPrv) readMsgUDP(proxyTyp tProxy.ProtoTyp, proxy config.Proxy, listen net.PacketConn) {
+func (mgr *proxyPrv) readMsgUDP(udpPool *tProxy.UdpWorkerPool, flowPool *tProxy.UdpWorkerPool, listen net.PacketConn) {
 	if listen == nil {
 		logger.Warningf("[%s] tcp listener is nil", mgr.scope)
 		return
@@ -350,12 +628,13 @@ func (mgr *proxyPrv) readMsgUDP(proxyTyp tProxy.ProtoTyp, proxy config.Proxy, li
 	}
 	defer conn.Close()
 
+	// buffers are reused, data of each package is copied out in its own size
+	buf := make([]byte, 65535)
+	oob := make([]byte, 1024)
 	// start accept until stop
 	for {
 		// read origin addr
-		buf := make([]byte, 512)
-		oob := make([]byte, 1024)
-		_, oobNum, _, lAddr, err := conn.ReadMsgUDP(buf, oob)
+		n, oobNum, _, lAddr, err := conn.ReadMsgUDP(buf, oob)
 		if err != nil {
 			if !mgr.Enabled {
 				logger.Debugf("[%s] stop proxy udp break", mgr.scope)
@@ -375,20 +654,71 @@ func (mgr *proxyPrv) readMsgUDP(proxyTyp tProxy.ProtoTyp, proxy config.Proxy, li
 			IP:   rBaseAddr.IP,
 			Port: rBaseAddr.Port,
 		}
+		// package sent to listener directly is dropped
+		if mgr.isListenerAddr(rAddr) {
+			logger.Debugf("[%s] drop udp package from [%s] to listener", mgr.scope, lAddr)
+			continue
+		}
+		data := make([]byte, n)
+		copy(data, buf[:n])
+		pkg := tProxy.UdpPackage{LAddr: lAddr, RAddr: rAddr, Data: data}
 		// proxy udp
-		go mgr.proxyUdp(proxy, lAddr, rAddr, buf)
+		if udpPool != nil && !mgr.isBypass(rAddr) {
+			udpPool.Submit(pkg)
+			continue
+		}
+		flowPool.Submit(pkg)
 	}
-	logger.Debugf("[%s] stop proxy, prepare close handler", mgr.scope)
-	mgr.handlerMgr.CloseTypHandler(proxyTyp)
+	// handlers are closed after drain when stop proxy
+	logger.Debugf("[%s] stop proxy udp", mgr.scope)
 }
 
 // for t-proxy
-func (mgr *proxyPrv) proxyTcp(proxyTyp tProxy.ProtoTyp, proxy config.Proxy, lConn net.Conn) {
+func (mgr *proxyPrv) proxyTcp(proxyTyp tProxy.ProtoTyp, proxy config.Proxy, lConn net.Conn, slot tunnelSlot) {
+	// slot is given back when tunnel closes, or at once if no tunnel is created
+	tracked := false
+	defer func() {
+		if !tracked {
+			slot.release()
+		}
+	}()
 	// request is redirect by t-proxy, output -> pre-routing
 	// at that time, the actual remote addr is conn`s local addr, the actual local addr is conn`s remote addr
 	// can use conn as fake remote conn, to connect with actual local connection
 	lAddr := lConn.RemoteAddr()
 	rAddr := lConn.LocalAddr()
+	// conn redirected by bpf is accepted at listener addr, origin destination is kept in bpf map
+	if mgr.bpfMode() {
+		dst, err := mgr.bpfOriginalDst(lConn)
+		if err != nil {
+			logger.Warningf("[%s] get origin destination from bpf failed, err: %v", mgr.scope, err)
+			_ = lConn.Close()
+			return
+		}
+		rAddr = dst
+	} else if mgr.redirectMode() {
+		// conn redirected by nat is accepted at listener addr, origin destination is kept by conntrack
+		dst, err := mgr.originalDst(lConn)
+		if err != nil {
+			logger.Warningf("[%s] get origin destination failed, err: %v", mgr.scope, err)
+			_ = lConn.Close()
+			return
+		}
+		rAddr = dst
+	}
+
+	// app with own proxy, socket of app is connected to listener when redirected by bpf
+	peer := rAddr
+	if mgr.bpfMode() {
+		peer = lConn.LocalAddr()
+	}
+	// connection to listener directly is rejected, daemon is not open proxy
+	owner, err := mgr.guardConn(lAddr, rAddr, peer)
+	if err != nil {
+		logger.Warningf("[%s] reject connection from [%s], err: %v", mgr.scope, lAddr, err)
+		_ = lConn.Close()
+		return
+	}
 
 	realRAddr := rAddr
 	switch addr := rAddr.(type) {
@@ -405,9 +735,45 @@ func (mgr *proxyPrv) proxyTcp(proxyTyp tProxy.ProtoTyp, proxy config.Proxy, lCon
 		}
 	}
 
+	// remote is raw ip, try to sniff domain from first packet
+	if tcpAddr, ok := rAddr.(*net.TCPAddr); ok && realRAddr == rAddr && (mgr.Proxies.SniffDomain || mgr.Proxies.PAC != "" || mgr.Proxies.WPAD) {
+		var domain string
+		lConn, domain = tProxy.SniffDomain(lConn)
+		if domain != "" && net.ParseIP(domain) == nil {
+			realRAddr = tProxy.NewDomainAddr("tcp", domain, tcpAddr.Port)
+		}
+	}
+
+	if owner == nil {
+		owner = mgr.connOwner(lAddr, peer)
+	}
+	if app, ok := mgr.appProxyOf(owner); ok {
+		proxyTyp, proxy = app.proxyTyp, app.proxy
+	} else if chosen, _, ok := mgr.pacProxyOf(realRAddr); ok {
+		proxyTyp, proxy = chosen.proxyTyp, chosen.proxy
+	} else if chosen, ok := mgr.balancedProxy(); ok {
+		proxyTyp, proxy = chosen.proxyTyp, chosen.proxy
+	}
+
+	// bypass destination connect directly
+	if mgr.isBypass(realRAddr) {
+		logger.Debugf("[%s] remote [%s] match bypass rule, connect directly", mgr.scope, realRAddr)
+		proxyTyp = tProxy.NoneProto
+	}
+	if slot.direct && proxyTyp != tProxy.NoneProto {
+		logger.Debugf("[%s] tunnels are full, remote [%s] connect directly", mgr.scope, realRAddr)
+		proxyTyp = tProxy.NoneProto
+	}
+
+	event := newConnEvent(owner, realRAddr, proxyTyp, proxy)
+	event.id = mgr.nextConnID()
+	event.release = slot.release
+	event.src = lAddr.String()
+	connLog := logging.New("proxy/dbus").With("conn", event.id, "scope", mgr.scope, "proto", proxyTyp,
+		"exe", event.exe, "destination", realRAddr)
+
 	// print local -> remote
-	logger.Infof("[%s] tcp request capture by proxy successfully, "+
-		"local[%s] -> remote [%s](%s)", proxyTyp, lAddr.String(), rAddr.String(), realRAddr)
+	connLog.Infof("tcp request capture by proxy successfully, local [%s] -> remote [%s]", lAddr.String(), rAddr.String())
 
 	// make key to mark this connection
 	key := tProxy.HandlerKey{
@@ -416,53 +782,133 @@ func (mgr *proxyPrv) proxyTcp(proxyTyp tProxy.ProtoTyp, proxy config.Proxy, lCon
 	}
 	// create new handler
 	handler := tProxy.NewHandler(proxyTyp, mgr.scope, key, proxy, lAddr, realRAddr, lConn)
+	handler.AddLogFields("conn", event.id, "exe", event.exe)
 	// create tunnel between proxy server and dst server
-	err := handler.Tunnel()
+	event.start = time.Now()
+	err = handler.Tunnel()
+	mgr.checkKillSwitch(proxyTyp, err)
 	if err != nil {
-		logger.Warningf("[%s] create tunnel failed, err: %v", proxyTyp, err)
+		connLog.Warningf("create tunnel failed, err: %v", err)
+		mgr.recordHandshakeFailure(event)
+		mgr.tunnelFailed(event, err)
 		handler.Close()
 		return
 	}
+	mgr.trackTunnel(handler, event)
+	tracked = true
+	mgr.trackLatency(handler, event)
 	// add handler to map
 	handler.AddMgr(mgr.handlerMgr)
 	// begin communication
 	handler.Communicate()
 }
 
-func (mgr *proxyPrv) proxyUdp(proxy config.Proxy, lAddr net.Addr, rAddr net.Addr, buf []byte) {
-	// make a fake udp dial to cheat socket
-	lConn, err := com.MegaDial("udp", rAddr, lAddr)
+// relay udp by nat session of client endpoint
+func (mgr *proxyPrv) relayUdp(natTable *tProxy.UdpNatTable, lAddr *net.UDPAddr, rAddr *net.UDPAddr, buf []byte) {
+	// kill switch is checked by result of udp associate
+	err := natTable.Relay(lAddr, rAddr, buf)
 	if err != nil {
-		logger.Warningf("fake dial udp rAddr to lAddr failed, err: %v", err)
-		return
+		logger.Warningf("[%s] relay udp failed, local [%s] -> remote [%s], err: %v", tProxy.SOCKS5UDP, lAddr, rAddr, err)
+	}
+}
+
+// packages of flow queued while tunnel is created, dropped if more
+const udpPendingLen = 64
+
+// relay package of flow, package of new flow creates tunnel in background, so that worker never waits handshake
+func (mgr *proxyPrv) proxyUdp(proxyTyp tProxy.ProtoTyp, proxy config.Proxy, lAddr net.Addr, rAddr net.Addr, buf []byte) {
+	// bypass destination connect directly
+	if mgr.isBypass(rAddr) {
+		proxyTyp = tProxy.NoneProto
 	}
 	// make key to mark this connection
 	key := tProxy.HandlerKey{
 		SrcAddr: lAddr.String(),
 		DstAddr: rAddr.String(),
 	}
+	// masque tunnel carries raw udp payload, socks5 relay carries addr of remote
+	payload := buf
+	if proxyTyp == tProxy.SOCKS5UDP {
+		payload = com.MarshalPackage(com.DataPackage{Addr: rAddr, Data: buf}, "udp")
+	}
+	mgr.udpPendingLock.Lock()
+	// package waits in order while tunnel is created, dropped as network may drop it anyway
+	if pending, ok := mgr.udpPending[key]; ok {
+		if len(pending) < udpPendingLen {
+			mgr.udpPending[key] = append(pending, payload)
+		}
+		mgr.udpPendingLock.Unlock()
+		return
+	}
+	// packages queued before fake socket of flow is bound use tunnel created by the first one
+	if handler, ok := mgr.handlerMgr.AcquireHandler(proxyTyp, key); ok {
+		mgr.udpPendingLock.Unlock()
+		err := handler.WriteRemote(payload)
+		mgr.handlerMgr.ReleaseHandler(proxyTyp, key, handler)
+		if err != nil {
+			logger.Debugf("[%s] write udp package to tunnel failed, err: %v", proxyTyp, err)
+		}
+		return
+	}
+	mgr.udpPending[key] = [][]byte{payload}
+	mgr.udpPendingLock.Unlock()
+	go mgr.tunnelUdp(proxyTyp, proxy, key, lAddr, rAddr)
+}
+
+// create tunnel of flow, then send packages queued in order
+func (mgr *proxyPrv) tunnelUdp(proxyTyp tProxy.ProtoTyp, proxy config.Proxy, key tProxy.HandlerKey, lAddr net.Addr, rAddr net.Addr) {
+	// packages queued are dropped if tunnel is not created
+	defer func() {
+		mgr.udpPendingLock.Lock()
+		delete(mgr.udpPending, key)
+		mgr.udpPendingLock.Unlock()
+	}()
+	// flow takes slot as tcp connection does, packages keep queued while waiting
+	slot, ok := mgr.acquireTunnel()
+	if !ok {
+		return
+	}
+	if slot.direct && proxyTyp != tProxy.NoneProto {
+		logger.Debugf("[%s] tunnels are full, remote [%s] connect directly", mgr.scope, rAddr)
+		proxyTyp = tProxy.NoneProto
+	}
+	// make a fake udp dial to cheat socket
+	lConn, err := com.MegaDial("udp", rAddr, lAddr)
+	if err != nil {
+		logger.Warningf("fake dial udp rAddr to lAddr failed, err: %v", err)
+		slot.release()
+		return
+	}
 	// create new handler
-	handler := tProxy.NewHandler(tProxy.SOCKS5UDP, mgr.scope, key, proxy, lAddr, rAddr, lConn)
+	handler := tProxy.NewHandler(proxyTyp, mgr.scope, key, proxy, lAddr, rAddr, lConn)
 	// create tunnel between proxy server and dst server
 	err = handler.Tunnel()
+	mgr.checkKillSwitch(proxyTyp, err)
 	if err != nil {
-		logger.Warningf("[%s] create tunnel failed, err: %v", tProxy.SOCKS5UDP, err)
+		logger.Warningf("[%s] create tunnel failed, err: %v", proxyTyp, err)
 		handler.Close()
+		slot.release()
 		return
 	}
+	// slot is given back when flow expires
+	handler.OnClose(func(sent int64, received int64) {
+		slot.release()
+	})
+	// handler is added and packages queued are sent under lock, so that package comes later
+	// is sent by handler after them
+	mgr.udpPendingLock.Lock()
+	defer mgr.udpPendingLock.Unlock()
+	pending := mgr.udpPending[key]
+	delete(mgr.udpPending, key)
 	// add handler to map
 	handler.AddMgr(mgr.handlerMgr)
 	// begin communication
 	handler.Communicate()
-	// write first buf to rAddr
-	pkgData := com.DataPackage{
-		Addr: rAddr,
-		Data: buf,
-	}
-	// write first udp to remote
-	err = handler.WriteRemote(com.MarshalPackage(pkgData, "udp"))
-	if err != nil {
-		handler.Close()
-		return
+	for _, payload := range pending {
+		err = handler.WriteRemote(payload)
+		if err != nil {
+			handler.Close()
+			return
+		}
 	}
 }
@@ -5,19 +5,60 @@
 package DBus
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	com "github.com/linuxdeepin/deepin-network-proxy/com"
+	config "github.com/linuxdeepin/deepin-network-proxy/config"
+	resolver "github.com/linuxdeepin/deepin-network-proxy/resolver"
+	tProxy "github.com/linuxdeepin/deepin-network-proxy/tproxy"
 	"github.com/miekg/dns"
 )
 
+const (
+	// system resolver, used to answer query which is not A or AAAA
+	resolvConfPath = "/etc/resolv.conf"
+	// remote dns server used when proxy dns
+	defaultRemoteDNS = "8.8.8.8:53"
+	// timeout of query through proxy
+	proxyDNSTimeout = 5 * time.Second
+)
+
+// check if dns query of proxy app should be answered by fake ip
+func (mgr *proxyPrv) useFakeIP() bool {
+	return mgr.Proxies.UseFakeIP && mgr.Proxies.DNSPort != 0
+}
+
+// check if dns query of proxy app should be intercepted by dns server
+func (mgr *proxyPrv) useDNSProxy() bool {
+	intercept := mgr.Proxies.UseFakeIP || mgr.Proxies.ProxyDNS || len(mgr.Proxies.DNSUpstreams) != 0
+	return intercept && mgr.Proxies.DNSPort != 0
+}
+
 type proxyDNS struct {
 	prv    *proxyPrv
 	server *dns.Server
+	// query over tcp is redirected too, same port as server
+	tcpServer *dns.Server
 
+	// lock fake ip pool and server
+	lock  sync.Mutex
 	fIP   fakeIP
 	cache *fakeIPCache
+
+	// proxy used to forward query
+	proxyTyp tProxy.ProtoTyp
+	proxy    config.Proxy
+	// sequence to make unique handler key
+	seq uint64
+	// encrypted upstream resolver, nil means use system resolver
+	resolver *resolver.Resolver
 }
 
 func newProxyDNS(prv *proxyPrv) *proxyDNS {
@@ -25,13 +66,8 @@ func newProxyDNS(prv *proxyPrv) *proxyDNS {
 		prv: prv,
 	}
 
-	p.fIP = newFakeIP(net.IP{192, 168, 135, 0}, 24)
-	p.cache = newFakeIPCache()
-
-	p.server = &dns.Server{
-		Net:     "udp",
-		Handler: p,
-	}
+	p.fIP, _ = parseFakeIP(defaultFakeIPRange)
+	p.cache = newFakeIPCache(p.fIP.size())
 
 	return p
 }
@@ -39,6 +75,9 @@ func newProxyDNS(prv *proxyPrv) *proxyDNS {
 func (p *proxyDNS) resolveDomain(domain string) net.IP {
 	domain = strings.TrimRight(domain, ".")
 
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
 	i, ok := p.cache.GetByDomain(domain)
 	if ok {
 		return i
@@ -52,7 +91,90 @@ func (p *proxyDNS) resolveDomain(domain string) net.IP {
 }
 
 func (p *proxyDNS) getDomainFromFakeIP(ip net.IP) (string, bool) {
-	return p.cache.GetByIP(ip)
+	p.lock.Lock()
+	cache := p.cache
+	// ip is not fake, no need to search cache
+	if p.server == nil || !p.prv.useFakeIP() || !p.fIP.contains(ip) {
+		p.lock.Unlock()
+		return "", false
+	}
+	p.lock.Unlock()
+	return cache.GetByIP(ip)
+}
+
+// forward query to system resolver, query such as MX TXT cant be answered by fake ip
+func (p *proxyDNS) forwardQuery(r *dns.Msg) (*dns.Msg, error) {
+	conf, err := dns.ClientConfigFromFile(resolvConfPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(conf.Servers) == 0 {
+		return nil, fmt.Errorf("no name server in %s", resolvConfPath)
+	}
+	client := &dns.Client{Dialer: com.NewDialer(0)}
+	resp, _, err := client.Exchange(r, net.JoinHostPort(conf.Servers[0], conf.Port))
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// forward query to remote dns server through proxy,
+// dns over tcp is used so that query can be forwarded by any proxy proto
+func (p *proxyDNS) forwardQueryByProxy(r *dns.Msg) (*dns.Msg, error) {
+	p.lock.Lock()
+	proxyTyp := p.proxyTyp
+	proxy := p.proxy
+	p.lock.Unlock()
+
+	remote := p.prv.Proxies.RemoteDNS
+	if remote == "" {
+		remote = defaultRemoteDNS
+	}
+	rAddr, err := net.ResolveTCPAddr("tcp", remote)
+	if err != nil {
+		return nil, err
+	}
+	// pipe between dns client and proxy handler
+	lConn, pConn := net.Pipe()
+	key := tProxy.HandlerKey{
+		SrcAddr: "dns-" + strconv.FormatUint(atomic.AddUint64(&p.seq, 1), 10),
+		DstAddr: rAddr.String(),
+	}
+	handler := tProxy.NewHandler(proxyTyp, p.prv.scope, key, proxy, lConn.LocalAddr(), rAddr, pConn)
+	if handler == nil {
+		_ = lConn.Close()
+		_ = pConn.Close()
+		return nil, errors.New("proxy proto dont support dns")
+	}
+	err = handler.Tunnel()
+	if err != nil {
+		handler.Close()
+		_ = lConn.Close()
+		return nil, err
+	}
+	handler.AddMgr(p.prv.handlerMgr)
+	handler.Communicate()
+	// close pipe to release handler
+	defer lConn.Close()
+
+	conn := &dns.Conn{Conn: lConn}
+	_ = conn.SetDeadline(time.Now().Add(proxyDNSTimeout))
+	err = conn.WriteMsg(r)
+	if err != nil {
+		return nil, err
+	}
+	return conn.ReadMsg()
+}
+
+// check if all question can be answered by fake ip
+func isFakeIPQuery(r *dns.Msg) bool {
+	for _, q := range r.Question {
+		if q.Qtype != dns.TypeA && q.Qtype != dns.TypeAAAA {
+			return false
+		}
+	}
+	return true
 }
 
 func (p *proxyDNS) parseQuery(m *dns.Msg) {
@@ -65,12 +187,37 @@ func (p *proxyDNS) parseQuery(m *dns.Msg) {
 				m.Answer = append(m.Answer, rr)
 			}
 		case dns.TypeAAAA:
+			// answer empty, so that app fallback to ipv4 fake ip
 			logger.Debugf("Query AAAA for %s", q.Name)
 		}
 	}
 }
 
 func (p *proxyDNS) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	if r.Opcode == dns.OpcodeQuery && (!p.prv.useFakeIP() || !isFakeIPQuery(r)) {
+		var resp *dns.Msg
+		var err error
+		p.lock.Lock()
+		upstream := p.resolver
+		p.lock.Unlock()
+		if p.prv.Proxies.ProxyDNS {
+			resp, err = p.forwardQueryByProxy(r)
+		} else if upstream != nil {
+			resp, err = upstream.Exchange(r)
+		} else {
+			resp, err = p.forwardQuery(r)
+		}
+		if err == nil {
+			_ = w.WriteMsg(resp)
+			return
+		}
+		logger.Warningf("forward dns query failed, err: %v", err)
+		m := &dns.Msg{}
+		m.SetRcode(r, dns.RcodeServerFailure)
+		_ = w.WriteMsg(m)
+		return
+	}
+
 	m := &dns.Msg{}
 	m.SetReply(r)
 	m.Compress = false
@@ -83,13 +230,82 @@ func (p *proxyDNS) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 	w.WriteMsg(m)
 }
 
-func (p *proxyDNS) startDNSProxy() error {
-	p.server.Addr = fmt.Sprintf("127.0.0.1:%d", p.prv.Proxies.DNSPort)
-	logger.Info("dns listen addr:", p.server.Addr)
+func (p *proxyDNS) startDNSProxy(proxyTyp tProxy.ProtoTyp, proxy config.Proxy) error {
+	// fake ip range may be changed in config
+	fIP, err := parseFakeIP(p.prv.Proxies.FakeIPRange)
+	if err != nil {
+		if p.prv.Proxies.FakeIPRange != "" {
+			logger.Warningf("fake ip range is invalid, use default range %s, err: %v", defaultFakeIPRange, err)
+		}
+		fIP, _ = parseFakeIP(defaultFakeIPRange)
+	}
+
+	// upstream may be changed in config
+	var upstream *resolver.Resolver
+	if len(p.prv.Proxies.DNSUpstreams) != 0 {
+		upstream, err = resolver.NewResolver(p.prv.Proxies.DNSUpstreams)
+		if err != nil {
+			logger.Warningf("create dns resolver failed, use system resolver, err: %v", err)
+		}
+	}
 
-	return p.server.ListenAndServe()
+	p.lock.Lock()
+	p.resolver = upstream
+	p.proxyTyp = proxyTyp
+	p.proxy = proxy
+	if fIP.start != p.fIP.start || fIP.end != p.fIP.end {
+		p.fIP = fIP
+		p.cache = newFakeIPCache(fIP.size())
+	}
+	// server cant be reused after shutdown
+	server := &dns.Server{
+		Addr:    fmt.Sprintf("127.0.0.1:%d", p.prv.Proxies.DNSPort),
+		Net:     "udp",
+		Handler: p,
+	}
+	p.server = server
+	tcpServer := &dns.Server{
+		Addr:    server.Addr,
+		Net:     "tcp",
+		Handler: p,
+	}
+	p.tcpServer = tcpServer
+	p.lock.Unlock()
+
+	go func() {
+		err := tcpServer.ListenAndServe()
+		if err != nil {
+			logger.Warningf("dns listen tcp failed, err: %v", err)
+		}
+	}()
+	logger.Info("dns listen addr:", server.Addr)
+	return server.ListenAndServe()
+}
+
+// addr dns proxy listens, empty if not running
+func (p *proxyDNS) listenAddr() string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.server == nil {
+		return ""
+	}
+	return p.server.Addr
 }
 
 func (p *proxyDNS) stopDNSProxy() error {
-	return p.server.Shutdown()
+	p.lock.Lock()
+	server := p.server
+	tcpServer := p.tcpServer
+	p.server = nil
+	p.tcpServer = nil
+	p.lock.Unlock()
+	// fake ip is not used
+	if server == nil {
+		return nil
+	}
+	if tcpServer != nil {
+		// tcp may fail to listen, udp still works
+		_ = tcpServer.Shutdown()
+	}
+	return server.Shutdown()
 }
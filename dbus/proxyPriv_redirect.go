@@ -0,0 +1,62 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"errors"
+	"net"
+	"strconv"
+
+	com "github.com/linuxdeepin/deepin-network-proxy/com"
+	newIptables "github.com/linuxdeepin/deepin-network-proxy/new_iptables"
+)
+
+// redirect mode is used when kernel lacks tproxy target,
+// tcp is redirected to listener in nat table, and origin destination is got by SO_ORIGINAL_DST.
+// udp can not be intercepted in this mode, as origin destination of udp is lost after nat.
+
+// check if intercept by nat redirect
+func (mgr *proxyPrv) redirectMode() bool {
+	return mgr.manager != nil && mgr.manager.isRedirectMode()
+}
+
+// table of scope chain
+func (mgr *proxyPrv) mainTable() string {
+	if mgr.manager == nil {
+		return "mangle"
+	}
+	return mgr.manager.mainTable()
+}
+
+// conntrack mark is meaningless without mark rule
+func (mgr *proxyPrv) useConnMark() bool {
+	return mgr.Proxies.ConnMark && !mgr.redirectMode()
+}
+
+// iptables -t nat -A App -j REDIRECT -p tcp --to-ports $TPort
+func (mgr *proxyPrv) redirectRule() *newIptables.CompleteRule {
+	return &newIptables.CompleteRule{
+		Action: newIptables.REDIRECT,
+		BaseSl: []newIptables.BaseRule{
+			{
+				Match: "p",
+				Param: "tcp",
+			},
+			{
+				Match: "-to-ports",
+				Param: strconv.Itoa(mgr.Proxies.TPort),
+			},
+		},
+	}
+}
+
+// get origin destination of conn redirected by nat
+func (mgr *proxyPrv) originalDst(lConn net.Conn) (net.Addr, error) {
+	tcpConn, ok := lConn.(*net.TCPConn)
+	if !ok {
+		return nil, errors.New("conn is not tcp conn")
+	}
+	return com.GetTcpRemoteAddr(tcpConn)
+}
@@ -0,0 +1,130 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	com "github.com/linuxdeepin/deepin-network-proxy/com"
+	config "github.com/linuxdeepin/deepin-network-proxy/config"
+	define "github.com/linuxdeepin/deepin-network-proxy/define"
+)
+
+// proxy used by new tunnels
+func (mgr *proxyPrv) activeProxy() config.Proxy {
+	mgr.proxyLock.Lock()
+	defer mgr.proxyLock.Unlock()
+	return mgr.Proxy
+}
+
+// apply changes of reloaded config, only changed parts are touched, established tunnels are kept
+func (mgr *proxyPrv) applyConfig(proxies config.ScopeProxies, diff config.ScopeDiff) error {
+	old := mgr.Proxies
+	mgr.Proxies = proxies
+	// invalid bypass rule rejects the whole change
+	if diff.Bypass {
+		err := mgr.loadBypass()
+		if err != nil {
+			mgr.Proxies = old
+			_ = mgr.loadBypass()
+			return err
+		}
+	}
+	mgr.updateTunnelLimit()
+	// procs of programs are moved in or out at once if proxy is running
+	for _, exe := range diff.AddedPrograms {
+		mgr.addTarget(exe)
+	}
+	for _, exe := range diff.RemovedPrograms {
+		mgr.removeTarget(exe)
+	}
+	if diff.MatchSpecs && mgr.Enabled && mgr.controller != nil {
+		err := mgr.controller.ClearMatchers()
+		if err != nil {
+			logger.Warningf("[%s] clear matchers failed, err: %v", mgr.scope, err)
+		}
+		mgr.loadMatchers()
+	}
+	if diff.Interfaces && mgr.Enabled && !mgr.bpfMode() {
+		err := mgr.rebuildScopeRules()
+		if err != nil {
+			logger.Warningf("[%s] rebuild rules of interfaces failed, err: %v", mgr.scope, err)
+		}
+	}
+	if mgr.Enabled {
+		mgr.proxyLock.Lock()
+		key := config.ProxyKey(mgr.proto, mgr.Proxy.Name)
+		mgr.proxyLock.Unlock()
+		// tunnels of other proxies are not touched
+		if com.MegaExist(diff.Proxies, key) {
+			mgr.switchProxy()
+		}
+	}
+	if (diff.AppProxies || len(diff.Proxies) != 0) && mgr.Enabled {
+		mgr.loadAppProxies()
+	}
+	if (len(diff.Proxies) != 0 || old.Strategy != proxies.Strategy) && mgr.Enabled {
+		mgr.loadBalanced()
+	}
+	if diff.PAC && mgr.Enabled {
+		_ = mgr.loadPAC()
+	} else if len(diff.Proxies) != 0 && mgr.Enabled {
+		mgr.loadPACProxies()
+	}
+	if len(diff.Restart) != 0 && mgr.Enabled {
+		logger.Warningf("[%s] %v changed, take effect after proxy restarts", mgr.scope, diff.Restart)
+	}
+	return nil
+}
+
+// new tunnels dial proxy of reloaded config, if current proxy is modified
+func (mgr *proxyPrv) switchProxy() {
+	mgr.proxyLock.Lock()
+	proto, name := mgr.proto, mgr.Proxy.Name
+	mgr.proxyLock.Unlock()
+	mgr.switchProxyTo(proto, name)
+}
+
+// new tunnels dial proxy of name, handlers of proto are created when proxy starts
+func (mgr *proxyPrv) switchProxyTo(proto string, name string) {
+	if !mgr.Enabled {
+		return
+	}
+	mgr.proxyLock.Lock()
+	running := mgr.proto
+	mgr.proxyLock.Unlock()
+	if proto != running {
+		logger.Warningf("[%s] proto of proxy %s differs from %s in use, take effect after proxy restarts", mgr.scope, config.ProxyKey(proto, name), running)
+		return
+	}
+	proxy, err := mgr.Proxies.GetProxy(proto, name)
+	if err != nil {
+		logger.Warningf("[%s] proxy %s is removed from config, keep using it until proxy restarts", mgr.scope, config.ProxyKey(proto, name))
+		return
+	}
+	proxy, err = mgr.resolveSecret(proxy)
+	if err != nil {
+		logger.Warningf("[%s] get password of proxy %s failed, keep using old one, err: %v", mgr.scope, config.ProxyKey(proto, name), err)
+		return
+	}
+	mgr.proxyLock.Lock()
+	server := mgr.Proxy.Server
+	mgr.Proxy = proxy
+	mgr.proxyLock.Unlock()
+	// exemption of proxy server follows new server
+	if mgr.scope == define.Global && server != proxy.Server && !mgr.bpfMode() {
+		err = mgr.rebuildScopeRules()
+		if err != nil {
+			logger.Warningf("[%s] rebuild exemption of proxy server failed, err: %v", mgr.scope, err)
+		}
+	}
+	if udpNat := mgr.natTable(); udpNat != nil {
+		udpNat.SetProxy(proxy)
+	}
+	// dns proxy dials with proxy it started with
+	if mgr.useDNSProxy() {
+		logger.Debugf("[%s] dns proxy keeps old proxy until proxy restarts", mgr.scope)
+	}
+	logger.Infof("[%s] proxy %s is reloaded, new tunnels use %s:%d", mgr.scope, config.ProxyKey(proto, name), proxy.Server, proxy.Port)
+	mgr.manager.notifyState()
+}
@@ -7,15 +7,41 @@ package DBus
 import (
 	"strconv"
 
+	"github.com/godbus/dbus"
 	route "github.com/linuxdeepin/deepin-network-proxy/ip_route"
 )
 
+// fwmark of scope, tproxy port is used before mark is allocated
+func (mgr *proxyPrv) fwmark() string {
+	if mgr.manager != nil && mgr.manager.markAllocator != nil {
+		if mark, ok := mgr.manager.markAllocator.Get(mgr.scope); ok {
+			return strconv.FormatUint(uint64(mark), 10)
+		}
+	}
+	return strconv.Itoa(mgr.Proxies.TPort)
+}
+
+// get fwmark of scope, 0 if proxy is not started
+func (mgr *proxyPrv) GetMark() (uint32, *dbus.Error) {
+	if mgr.manager == nil || mgr.manager.markAllocator == nil {
+		return 0, nil
+	}
+	mark, _ := mgr.manager.markAllocator.Get(mgr.scope)
+	return mark, nil
+}
+
+// allocate fwmark which is not used by other tools
+func (mgr *proxyPrv) allocMark() error {
+	_, err := mgr.manager.markAllocator.Alloc(mgr.scope, uint32(mgr.Proxies.TPort))
+	return err
+}
+
 // create ip rule
 func (mgr *proxyPrv) createIpRule() error {
 	action := route.RuleAction{}
 	selector := route.RuleSelector{
 		// fwmark 8080
-		Fwmark: strconv.Itoa(mgr.Proxies.TPort),
+		Fwmark: mgr.fwmark(),
 	}
 	// ip rule add fwmark 8080 table 100
 	rule, err := mgr.manager.mainRoute.CreateRule(action, selector)
@@ -23,15 +49,33 @@ func (mgr *proxyPrv) createIpRule() error {
 		return err
 	}
 	mgr.ipRule = rule
+	// ip -6 rule add fwmark 8080 table 100, ipv6 is optional
+	if mgr.manager.mainRoute6 != nil && !mgr.Proxies.DisableIPv6 {
+		rule, err = mgr.manager.mainRoute6.CreateRule(action, selector)
+		if err != nil {
+			logger.Warningf("[%s] create ipv6 rule failed, err: %v", mgr.scope, err)
+			return nil
+		}
+		mgr.ipRule6 = rule
+	}
 	return nil
 }
 
 // release ip rule
 func (mgr *proxyPrv) releaseIpRule() error {
-	buf, err := mgr.ipRule.Remove()
-	if err != nil {
-		logger.Warning("[%s] release rule failed, out: %s, err: %v", mgr.scope, string(buf), err)
-		return err
+	var lastErr error
+	for _, rule := range []*route.Rule{mgr.ipRule, mgr.ipRule6} {
+		if rule == nil {
+			continue
+		}
+		buf, err := rule.Remove()
+		if err != nil {
+			logger.Warningf("[%s] release rule failed, out: %s, err: %v", mgr.scope, string(buf), err)
+			lastErr = err
+		}
+	}
+	if lastErr != nil {
+		return lastErr
 	}
 	logger.Debugf("[%s] release rule success", mgr.scope)
 	return nil
@@ -0,0 +1,91 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+
+	"github.com/godbus/dbus"
+	config "github.com/linuxdeepin/deepin-network-proxy/config"
+	secret "github.com/linuxdeepin/deepin-network-proxy/secret"
+	"github.com/linuxdeepin/go-lib/dbusutil"
+)
+
+// proxy with secret-id gets password from keyring of user who starts proxy,
+// password fetched is only kept in memory and never written back to config.
+
+// fill password of proxy from keyring
+func (mgr *proxyPrv) resolveSecret(proxy config.Proxy) (config.Proxy, error) {
+	return resolveSecretOf(mgr.uid, mgr.gid, proxy)
+}
+
+// fill password of proxy from keyring of uid
+func resolveSecretOf(uid uint32, gid uint32, proxy config.Proxy) (config.Proxy, error) {
+	if proxy.SecretID == "" {
+		return proxy, nil
+	}
+	password, err := secret.NewStore(uid, gid).Lookup(proxy.SecretID)
+	if err == secret.ErrNotFound {
+		return proxy, fmt.Errorf("secret %s of proxy %s not found in keyring of uid %d", proxy.SecretID, proxy.Name, uid)
+	}
+	if err != nil {
+		return proxy, err
+	}
+	proxy.Password = password
+	return proxy, nil
+}
+
+// move plaintext passwords of scope to keyring of caller, count of proxies migrated is returned
+func (mgr *proxyPrv) MigrateSecrets(sender dbus.Sender) (int32, *dbus.Error) {
+	dErr := mgr.authorize(sender, actionConfig)
+	if dErr != nil {
+		return 0, dErr
+	}
+	uid, gid, err := mgr.callerIDs(sender)
+	if err != nil {
+		return 0, dbusutil.ToError(err)
+	}
+	store := secret.NewStore(uid, gid)
+	count, migrateErr := mgr.Proxies.MigratePasswords(mgr.scope, store.Store)
+	if migrateErr != nil {
+		logger.Warningf("[%s] migrate passwords failed, err: %v", mgr.scope, migrateErr)
+	}
+	// proxies migrated are saved even some failed
+	if count != 0 {
+		err = mgr.writeConfig()
+		if err != nil {
+			return int32(count), dbusutil.ToError(err)
+		}
+		logger.Infof("[%s] %d passwords are moved to keyring of uid %d", mgr.scope, count, uid)
+	}
+	if migrateErr != nil {
+		return int32(count), dbusutil.ToError(migrateErr)
+	}
+	return int32(count), nil
+}
+
+// uid and gid of caller, whose keyring is used
+func (mgr *proxyPrv) callerIDs(sender dbus.Sender) (uint32, uint32, error) {
+	con, err := dbusutil.NewSystemService()
+	if err != nil {
+		return 0, 0, err
+	}
+	uid, err := con.GetConnUID(string(sender))
+	if err != nil {
+		logger.Warningf("[%s] get uid of caller failed, err: %v", mgr.scope, err)
+		return 0, 0, err
+	}
+	u, err := user.LookupId(strconv.Itoa(int(uid)))
+	if err != nil {
+		return 0, 0, err
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uid, uint32(gid), nil
+}
@@ -0,0 +1,44 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+
+	config "github.com/linuxdeepin/deepin-network-proxy/config"
+	define "github.com/linuxdeepin/deepin-network-proxy/define"
+	tProxy "github.com/linuxdeepin/deepin-network-proxy/tproxy"
+)
+
+func TestStartUdp(t *testing.T) {
+	mgr := initProxyPrv(define.App, define.AppPriority)
+	mgr.Proxies.DisableIPv6 = true
+	proxy := config.Proxy{Server: "127.0.0.1", Port: 1080}
+
+	// socks5 started by StartProxy with udp listens udp and relays by nat
+	err := mgr.startUdp(tProxy.SOCKS5TCP, proxy, true)
+	if errors.Is(err, syscall.EPERM) {
+		t.Skip("ip transparent needs CAP_NET_ADMIN")
+	}
+	if err != nil {
+		t.Fatalf("start udp failed, err: %v", err)
+	}
+	if len(mgr.udpHandlers) != 1 || mgr.natTable() == nil {
+		t.Errorf("udp of socks5 is not started, listeners: %d", len(mgr.udpHandlers))
+	}
+	for _, packetConn := range mgr.udpHandlers {
+		_ = packetConn.Close()
+	}
+	mgr.udpHandlers = nil
+	_ = mgr.cutTunnels()
+
+	// http can not carry udp
+	err = mgr.startUdp(tProxy.HTTP, proxy, true)
+	if err != nil || len(mgr.udpHandlers) != 0 {
+		t.Errorf("udp of http should not be started, err: %v", err)
+	}
+}
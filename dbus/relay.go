@@ -0,0 +1,43 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	config "github.com/linuxdeepin/deepin-network-proxy/config"
+	tProxy "github.com/linuxdeepin/deepin-network-proxy/tproxy"
+)
+
+// scopes share one epoll relay, engine is chosen at start and goroutines copy data if it fails
+
+// start epoll relay if config chooses it
+func (m *Manager) startRelay() {
+	if m.config == nil || m.config.RelayEngine != config.RelayEpoll || m.relay != nil {
+		return
+	}
+	relay, err := tProxy.NewEpollRelay()
+	if err != nil {
+		logger.Warningf("[relay] start epoll relay failed, goroutines copy data, err: %v", err)
+		return
+	}
+	m.relay = relay
+	logger.Infof("[relay] epoll relay started")
+}
+
+// stop epoll relay, tunnels left are closed
+func (m *Manager) stopRelay() {
+	if m.relay == nil {
+		return
+	}
+	m.relay.Close()
+}
+
+// tunnels relayed by engine, goroutine engine is not counted
+func (m *Manager) relayTunnels() map[string]float64 {
+	tunnels := make(map[string]float64)
+	if m.relay != nil {
+		tunnels[config.RelayEpoll] = float64(m.relay.Count())
+	}
+	return tunnels
+}
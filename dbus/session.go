@@ -0,0 +1,110 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"github.com/godbus/dbus"
+)
+
+// several users may log in at the same time, proxy of scope belongs to user who starts it.
+// logind session of caller is recorded when proxy starts, rules and cgroups of scope are removed when the session ends,
+// caller not in any session such as user service is tracked by its logind user, which ends with last session of user.
+// user logged in gets system proxy published.
+const (
+	logindName    = "org.freedesktop.login1"
+	logindPath    = "/org/freedesktop/login1"
+	logindManager = logindName + ".Manager"
+)
+
+// logind session of pid, or logind user if pid is not in any session, empty if not found
+func (m *Manager) sessionOf(pid uint32) dbus.ObjectPath {
+	if m.sysService == nil {
+		return ""
+	}
+	obj := m.sysService.Conn().Object(logindName, logindPath)
+	var path dbus.ObjectPath
+	err := obj.Call(logindManager+".GetSessionByPID", 0, pid).Store(&path)
+	if err == nil {
+		return path
+	}
+	err = obj.Call(logindManager+".GetUserByPID", 0, pid).Store(&path)
+	if err != nil {
+		logger.Debugf("[session] pid %d has no logind session or user, err: %v", pid, err)
+		return ""
+	}
+	return path
+}
+
+// watch sessions and users removed by logind
+func (m *Manager) startWatchSessions() {
+	if m.sysService == nil {
+		return
+	}
+	conn := m.sysService.Conn()
+	match := "type='signal',sender='" + logindName + "',path='" + logindPath + "',interface='" + logindManager + "'"
+	err := conn.BusObject().Call("org.freedesktop.DBus.AddMatch", 0, match).Err
+	if err != nil {
+		logger.Warningf("[session] add match of logind failed, err: %v", err)
+	}
+	ch := make(chan *dbus.Signal, 10)
+	conn.Signal(ch)
+	stop := make(chan bool)
+	m.sessionStop = stop
+	go func() {
+		defer func() {
+			conn.RemoveSignal(ch)
+			_ = conn.BusObject().Call("org.freedesktop.DBus.RemoveMatch", 0, match).Err
+		}()
+		for {
+			select {
+			case sig := <-ch:
+				if sig == nil || sig.Path != logindPath || len(sig.Body) < 2 {
+					continue
+				}
+				// UserNew(u uid, o path)
+				if sig.Name == logindManager+".UserNew" {
+					if uid, ok := sig.Body[0].(uint32); ok {
+						m.publishToNewUser(uid)
+					}
+					continue
+				}
+				// SessionRemoved(s id, o path), UserRemoved(u uid, o path)
+				if sig.Name != logindManager+".SessionRemoved" && sig.Name != logindManager+".UserRemoved" {
+					continue
+				}
+				path, ok := sig.Body[1].(dbus.ObjectPath)
+				if !ok {
+					continue
+				}
+				for _, handler := range m.handler {
+					handler.endSession(path)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// stop watching logind
+func (m *Manager) stopWatchSessions() {
+	if m.sessionStop == nil {
+		return
+	}
+	close(m.sessionStop)
+	m.sessionStop = nil
+}
+
+// stop proxy started in session which ends
+func (mgr *proxyPrv) endSession(path dbus.ObjectPath) {
+	if !mgr.Enabled || mgr.session == "" || mgr.session != path {
+		return
+	}
+	logger.Infof("[%s] %s of uid %d ends, stop proxy", mgr.scope, path, mgr.uid)
+	dErr := mgr.stopProxy()
+	if dErr != nil {
+		logger.Warningf("[%s] stop proxy of ended session failed, err: %v", mgr.scope, dErr)
+	}
+}
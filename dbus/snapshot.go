@@ -0,0 +1,65 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/godbus/dbus"
+	newIptables "github.com/linuxdeepin/deepin-network-proxy/new_iptables"
+	"github.com/linuxdeepin/go-lib/dbusutil"
+)
+
+// marshal rule tree installed by daemon as json
+func (m *Manager) saveRuleSnapshot() (string, error) {
+	if m.iptablesMgr == nil {
+		return "", errors.New("iptables is not initialized")
+	}
+	buf, err := json.Marshal(m.iptablesMgr)
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// install rule tree from snapshot, only used to replicate rules for debugging,
+// rules are journaled so that they are removed at next start
+func (m *Manager) loadRuleSnapshot(data string) error {
+	if m.iptablesMgr != nil {
+		return errors.New("proxy is started, stop daemon before load snapshot")
+	}
+	iptablesMgr := newIptables.NewManager()
+	err := json.Unmarshal([]byte(data), iptablesMgr)
+	if err != nil {
+		return err
+	}
+	iptablesMgr.SetJournal(newIptables.NewJournal(newIptables.DefaultJournalPath))
+	return iptablesMgr.Commit(true)
+}
+
+// export rule tree installed by daemon as json
+func (mgr *proxyPrv) SaveRuleSnapshot() (string, *dbus.Error) {
+	data, err := mgr.manager.saveRuleSnapshot()
+	if err != nil {
+		logger.Warningf("[%s] save rule snapshot failed, err: %v", mgr.scope, err)
+		return "", dbusutil.ToError(err)
+	}
+	return data, nil
+}
+
+// install rule tree exported by SaveRuleSnapshot
+func (mgr *proxyPrv) LoadRuleSnapshot(sender dbus.Sender, data string) *dbus.Error {
+	dErr := mgr.authorize(sender, actionConfig)
+	if dErr != nil {
+		return dErr
+	}
+	err := mgr.manager.loadRuleSnapshot(data)
+	if err != nil {
+		logger.Warningf("[%s] load rule snapshot failed, err: %v", mgr.scope, err)
+		return dbusutil.ToError(err)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"time"
+
+	"github.com/godbus/dbus"
+	stats "github.com/linuxdeepin/deepin-network-proxy/stats"
+	"github.com/linuxdeepin/go-lib/dbusutil"
+)
+
+// interval to write traffic counters to file
+const statsFlushInterval = time.Minute
+
+// write traffic counters periodically
+func (m *Manager) startFlushStats() {
+	if m.stats == nil || m.statsStop != nil {
+		return
+	}
+	recorder := m.stats
+	stop := make(chan bool)
+	m.statsStop = stop
+	go func() {
+		ticker := time.NewTicker(statsFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				err := recorder.Flush()
+				if err != nil {
+					logger.Warningf("[stats] write stats failed, err: %v", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// stop writing periodically and write counters left
+func (m *Manager) stopFlushStats() {
+	if m.statsStop == nil {
+		return
+	}
+	close(m.statsStop)
+	m.statsStop = nil
+	err := m.stats.Flush()
+	if err != nil {
+		logger.Warningf("[stats] write stats failed, err: %v", err)
+	}
+}
+
+// recorder of traffic, nil if stats is disabled
+func (m *Manager) statsRecorder() *stats.Recorder {
+	if m == nil || m.config == nil || !m.config.Stats {
+		return nil
+	}
+	return m.stats
+}
+
+// traffic of apps and proxies in period, period is day, week, month or all
+func (c *Control) GetAppStats(period string) ([]stats.Entry, []stats.Entry, *dbus.Error) {
+	if c.manager.stats == nil {
+		return []stats.Entry{}, []stats.Entry{}, nil
+	}
+	apps, proxies, err := c.manager.stats.Query(period, time.Now())
+	if err != nil {
+		return nil, nil, dbusutil.ToError(err)
+	}
+	return apps, proxies, nil
+}
+
+// drop all traffic counted
+func (c *Control) ResetStats(sender dbus.Sender) *dbus.Error {
+	err := c.manager.authorize(sender, actionConfig)
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+	if c.manager.stats == nil {
+		return nil
+	}
+	c.manager.stats.Reset()
+	err = c.manager.stats.Flush()
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"os/user"
+	"strconv"
+
+	"github.com/godbus/dbus"
+	sysProxy "github.com/linuxdeepin/deepin-network-proxy/sysproxy"
+)
+
+// when system-proxy is enabled, proxy of active profile is published to gsettings of users and environment drop-in,
+// and withdrawn when disabled or profile sets no proxy. settings never published are not touched,
+// so that proxy set by user is kept. users logged in later get gsettings when logind creates them.
+
+// publish proxy of active profile, gsettings are set in background as one command per key
+func (m *Manager) publishSystemProxy() {
+	var settings sysProxy.Settings
+	if cfg := m.config; cfg != nil && cfg.SystemProxy {
+		proto, proxy, whitelist := cfg.ActiveProfileProxy()
+		if published, ok := sysProxy.FromProxy(proto, proxy.Server, proxy.Port, whitelist); ok {
+			settings = published
+		}
+	}
+	go func() {
+		m.sysProxyLock.Lock()
+		defer m.sysProxyLock.Unlock()
+		// published before daemon restarts if drop-in exists
+		if !settings.Enabled() && !m.sysProxy.Enabled() && !sysProxy.EnvExists(sysProxy.EnvPath) {
+			return
+		}
+		m.sysProxy = settings
+		err := sysProxy.WriteEnv(sysProxy.EnvPath, settings)
+		if err != nil {
+			logger.Warningf("[sysproxy] write environment drop-in failed, err: %v", err)
+		}
+		for _, uid := range m.loggedInUsers() {
+			m.publishToUser(uid, settings)
+		}
+		if settings.Enabled() {
+			logger.Infof("[sysproxy] system proxy published, proxy: %s", settings.URL())
+		} else {
+			logger.Infof("[sysproxy] system proxy withdrawn")
+		}
+	}()
+}
+
+// set gsettings of user logged in after proxy published
+func (m *Manager) publishToNewUser(uid uint32) {
+	go func() {
+		m.sysProxyLock.Lock()
+		defer m.sysProxyLock.Unlock()
+		if !m.sysProxy.Enabled() {
+			return
+		}
+		m.publishToUser(uid, m.sysProxy)
+	}()
+}
+
+// set gsettings of one user, system users without session bus fail and are ignored
+func (m *Manager) publishToUser(uid uint32, settings sysProxy.Settings) {
+	u, err := user.LookupId(strconv.Itoa(int(uid)))
+	if err != nil {
+		logger.Debugf("[sysproxy] look up uid %d failed, err: %v", uid, err)
+		return
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return
+	}
+	err = sysProxy.SetGSettings(uid, uint32(gid), settings)
+	if err != nil {
+		logger.Warningf("[sysproxy] set gsettings of uid %d failed, err: %v", uid, err)
+	}
+}
+
+// uid of users logind knows
+func (m *Manager) loggedInUsers() []uint32 {
+	if m.sysService == nil {
+		return nil
+	}
+	var users []struct {
+		Uid  uint32
+		Name string
+		Path dbus.ObjectPath
+	}
+	err := m.sysService.Conn().Object(logindName, logindPath).Call(logindManager+".ListUsers", 0).Store(&users)
+	if err != nil {
+		logger.Debugf("[sysproxy] list users of logind failed, err: %v", err)
+		return nil
+	}
+	var uids []uint32
+	for _, elem := range users {
+		uids = append(uids, elem.Uid)
+	}
+	return uids
+}
@@ -62,3 +62,14 @@ func parseDesktopPath(app string) (string, error) {
 	}
 	return table, nil
 }
+
+// programs without the one removed
+func removeProgram(programs []string, program string) []string {
+	var result []string
+	for _, elem := range programs {
+		if elem != program {
+			result = append(result, elem)
+		}
+	}
+	return result
+}
@@ -0,0 +1,110 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package DBus
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/godbus/dbus"
+	com "github.com/linuxdeepin/deepin-network-proxy/com"
+)
+
+// daemon may be half broken silently, apps are redirected to proxy but nothing accepts them.
+// watchdog checks health periodically, broken listener is reopened, otherwise daemon exits after failures in a row,
+// rules are removed on exit and systemd restarts daemon. systemd is pinged only while daemon is healthy,
+// so that daemon stuck in watchdog itself is killed by systemd.
+
+const (
+	// interval of health check, shorter if systemd watchdog timeout requires
+	healthInterval = 10 * time.Second
+	// checks failed in a row before exit
+	healthMaxFailures = 3
+	// time to wait for reply of own dbus service
+	dbusPingTimeout = 5 * time.Second
+)
+
+// check health periodically
+func (m *Manager) startWatchdog() {
+	interval := healthInterval
+	// ping twice in timeout of systemd
+	timeout := com.SdWatchdogTimeout()
+	if timeout != 0 && timeout/2 < interval {
+		interval = timeout / 2
+	}
+	stop := make(chan bool)
+	m.watchdogStop = stop
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		var failures int
+		for {
+			select {
+			case <-ticker.C:
+				err := m.checkHealth()
+				if err == nil {
+					failures = 0
+					_ = com.SdNotify(com.SdWatchdog)
+					continue
+				}
+				failures++
+				logger.Warningf("[watchdog] daemon is unhealthy, failures: %d, err: %v", failures, err)
+				if failures < healthMaxFailures {
+					continue
+				}
+				logger.Errorf("[watchdog] daemon can not heal itself, exit to be restarted, err: %v", err)
+				m.Shutdown()
+				os.Exit(1)
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// stop checking health
+func (m *Manager) stopWatchdog() {
+	if m.watchdogStop == nil {
+		return
+	}
+	close(m.watchdogStop)
+	m.watchdogStop = nil
+}
+
+// check dbus loop, listeners and iptables rules, broken listener is reopened
+func (m *Manager) checkHealth() error {
+	err := m.pingDBus()
+	if err != nil {
+		return fmt.Errorf("dbus service not responding: %v", err)
+	}
+	for _, handler := range m.handler {
+		healed, err := handler.healListener()
+		if err != nil {
+			return fmt.Errorf("reopen listener of %s failed: %v", handler.getScope(), err)
+		}
+		if healed {
+			logger.Infof("[watchdog] listener of %s closed unexpectedly, reopened", handler.getScope())
+		}
+	}
+	failures := atomic.LoadInt32(&m.reconcileFailures)
+	if failures >= healthMaxFailures {
+		return fmt.Errorf("reconcile iptables rules failed %d times in a row", failures)
+	}
+	return nil
+}
+
+// call own service through bus, reply is sent only if dbus loop of daemon is alive
+func (m *Manager) pingDBus() error {
+	obj := m.sysService.Conn().Object(BusServiceName, dbus.ObjectPath(BusPath))
+	call := obj.Go("org.freedesktop.DBus.Peer.Ping", 0, make(chan *dbus.Call, 1))
+	select {
+	case <-call.Done:
+		return call.Err
+	case <-time.After(dbusPingTimeout):
+		return fmt.Errorf("no reply in %v", dbusPingTimeout)
+	}
+}
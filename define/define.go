@@ -15,6 +15,7 @@ type Scope string
 
 const (
 	Main   Scope = "Main"
+	Direct Scope = "Direct"
 	App    Scope = "App"
 	Global Scope = "Global"
 )
@@ -23,6 +24,8 @@ func (s Scope) String() string {
 	switch s {
 	case Main:
 		return "Main"
+	case Direct:
+		return "Direct"
 	case App:
 		return "App"
 	case Global:
@@ -55,6 +58,7 @@ type Priority int
 // proxy priority
 const (
 	MainPriority Priority = iota
+	DirectPriority
 	AppPriority
 	GlobalPriority
 )
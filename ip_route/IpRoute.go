@@ -5,8 +5,13 @@
 package IpRoute
 
 import (
-	"os/exec"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 )
 
 type action int
@@ -86,20 +91,112 @@ type Route struct {
 	Info RouteInfoSpec
 
 	// rules
-	rules []*Rule
+	rulesLock sync.Mutex
+	rules     []*Rule
+}
+
+// family of route, ipv6 route has ipv6 prefix such as ::/0
+func (r *Route) family() uint8 {
+	dst, _, err := parsePrefix(r.Node.Prefix)
+	if err != nil {
+		return syscall.AF_INET
+	}
+	return ipFamily(dst)
 }
 
 // do action
 func (r *Route) action(action action) ([]byte, error) {
-	// do action
-	args := []string{"ip route", action.String(), r.Node.String(), r.Info.String()}
-	// if table is not default
-	if r.table != "" {
-		args = append(args, "table", r.table)
+	body, err := r.marshal()
+	if err != nil {
+		return nil, err
 	}
-	cmd := exec.Command("/bin/sh", "-c", strings.Join(args, " "))
-	logger.Debugf("[%s] begin to run command %v", r.table, cmd)
-	return cmd.CombinedOutput()
+	logger.Debugf("[%s] begin to %s route %s %s", r.table, action, r.Node.String(), r.Info.String())
+	msgType := uint16(syscall.RTM_NEWROUTE)
+	flags := uint16(syscall.NLM_F_CREATE | syscall.NLM_F_EXCL)
+	if action == del {
+		msgType = syscall.RTM_DELROUTE
+		flags = 0
+	}
+	_, err = nlRequest(msgType, flags, body)
+	return nil, err
+}
+
+// make rtmsg   local default dev lo table 100
+func (r *Route) marshal() ([]byte, error) {
+	table, err := parseTable(r.table)
+	if err != nil {
+		return nil, err
+	}
+	typ, err := parseRtType(r.Node.Type)
+	if err != nil {
+		return nil, err
+	}
+	proto, err := parseRtProto(r.Node.Proto)
+	if err != nil {
+		return nil, err
+	}
+	dst, dstLen, err := parsePrefix(r.Node.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	hdr := rtHdr{
+		Family: ipFamily(dst),
+		DstLen: uint8(dstLen),
+		Table:  hdrTable(table),
+		Proto:  proto,
+		Type:   typ,
+	}
+	attrs := []rtAttr{u32Attr(rtaTable, table)}
+	// default route of ipv6 has no dst attr, the same as ipv4
+	if dst != nil && dstLen != 0 {
+		attrs = append(attrs, rtAttr{typ: syscall.RTA_DST, data: dst})
+	}
+	// scope is the same as ip route when not set
+	switch {
+	case r.Node.Scope != "":
+		hdr.Scope, err = parseRtScope(r.Node.Scope)
+		if err != nil {
+			return nil, err
+		}
+	case typ == syscall.RTN_LOCAL:
+		hdr.Scope = syscall.RT_SCOPE_HOST
+	case r.Info.Via == "" && r.Info.Dev != "":
+		hdr.Scope = syscall.RT_SCOPE_LINK
+	}
+	if r.Node.Metric != "" {
+		metric, err := strconv.ParseUint(r.Node.Metric, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, u32Attr(syscall.RTA_PRIORITY, uint32(metric)))
+	}
+	if r.Info.Via != "" {
+		via := net.ParseIP(r.Info.Via)
+		if via == nil {
+			return nil, fmt.Errorf("invalid gateway %s", r.Info.Via)
+		}
+		if hdr.Family == syscall.AF_INET {
+			via = via.To4()
+		}
+		attrs = append(attrs, rtAttr{typ: syscall.RTA_GATEWAY, data: via})
+	}
+	if r.Info.Dev != "" {
+		ifc, err := net.InterfaceByName(r.Info.Dev)
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, u32Attr(syscall.RTA_OIF, uint32(ifc.Index)))
+	}
+	if r.Info.Mtu != "" {
+		mtu, err := strconv.ParseUint(r.Info.Mtu, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		// mtu is nested in metrics
+		metrics := marshalAttrs([]rtAttr{u32Attr(rtaxMtu, uint32(mtu))})
+		attrs = append(attrs, rtAttr{typ: syscall.RTA_METRICS, data: metrics})
+	}
+	return marshalBody(hdr, attrs), nil
 }
 
 // creat
@@ -117,7 +214,7 @@ func (r *Route) create() error {
 // remove route
 func (r *Route) Remove() error {
 	// del rules first
-	for _, rule := range r.rules {
+	for _, rule := range r.ruleSl() {
 		buf, err := rule.Remove()
 		if err != nil {
 			logger.Warningf("[%s] remove rule failed, out: %s, err: %v", r.table, string(buf), err)
@@ -147,9 +244,31 @@ func (r *Route) CreateRule(ruleAction RuleAction, selector RuleSelector) (*Rule,
 		return nil, err
 	}
 	logger.Debugf("[%s] create rule success", r.table)
+	r.rulesLock.Lock()
+	r.rules = append(r.rules, rule)
+	r.rulesLock.Unlock()
 	return rule, nil
 }
 
+// copy of rules pointing to route
+func (r *Route) ruleSl() []*Rule {
+	r.rulesLock.Lock()
+	defer r.rulesLock.Unlock()
+	return append([]*Rule(nil), r.rules...)
+}
+
+// forget rule removed
+func (r *Route) dropRule(rule *Rule) {
+	r.rulesLock.Lock()
+	defer r.rulesLock.Unlock()
+	for index, elem := range r.rules {
+		if elem == rule {
+			r.rules = append(r.rules[:index], r.rules[index+1:]...)
+			return
+		}
+	}
+}
+
 // rule selector
 type RuleSelector struct {
 	Mark       bool   // not !
@@ -222,13 +341,113 @@ type Rule struct {
 
 // action
 func (rule *Rule) action(action action) ([]byte, error) {
-	args := []string{"ip rule", action.String(), rule.ruleSelector.String(), rule.ruleAction.String()}
+	body, err := rule.marshal()
+	if err != nil {
+		return nil, err
+	}
+	logger.Debugf("[rule] begin to %s rule %s %s", action, rule.ruleSelector.String(), rule.ruleAction.String())
+	msgType := uint16(syscall.RTM_NEWRULE)
+	flags := uint16(syscall.NLM_F_CREATE | syscall.NLM_F_EXCL)
+	if action == del {
+		msgType = syscall.RTM_DELRULE
+		flags = 0
+	}
+	_, err = nlRequest(msgType, flags, body)
+	return nil, err
+}
+
+// make fib_rule_hdr   fwmark 8080 table 100
+func (rule *Rule) marshal() ([]byte, error) {
+	table := uint32(syscall.RT_TABLE_MAIN)
 	if rule.route != nil {
-		args = append(args, "table", rule.route.table)
+		var err error
+		table, err = parseTable(rule.route.table)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if rule.ruleAction.Nat != "" || rule.ruleAction.Realms != "" {
+		return nil, errors.New("nat and realms is not supported")
+	}
+	selector := rule.ruleSelector
+	src, srcLen, err := parsePrefix(selector.SrcPrefix)
+	if err != nil {
+		return nil, err
+	}
+	dst, dstLen, err := parsePrefix(selector.DestPrefix)
+	if err != nil {
+		return nil, err
+	}
+	family := ipFamily(src)
+	switch {
+	case src == nil && dst != nil:
+		family = ipFamily(dst)
+	case src == nil && rule.route != nil:
+		// fwmark rule has no prefix, the same family as route it points to
+		family = rule.route.family()
+	}
+	hdr := rtHdr{
+		Family: family,
+		DstLen: uint8(dstLen),
+		SrcLen: uint8(srcLen),
+		Table:  hdrTable(table),
+		Type:   frActToTbl,
+	}
+	if selector.Mark {
+		hdr.Flags |= fibRuleInvert
 	}
-	cmd := exec.Command("/bin/sh", "-c", strings.Join(args, " "))
-	logger.Debugf("[rule] run command %v", cmd)
-	return cmd.CombinedOutput()
+	attrs := []rtAttr{u32Attr(fraTable, table)}
+	if src != nil {
+		attrs = append(attrs, rtAttr{typ: fraSrc, data: src})
+	}
+	if dst != nil {
+		attrs = append(attrs, rtAttr{typ: fraDst, data: dst})
+	}
+	if selector.Fwmark != "" {
+		// mark or mark/mask
+		sl := strings.SplitN(selector.Fwmark, "/", 2)
+		mark, err := strconv.ParseUint(sl[0], 0, 32)
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, u32Attr(fraFwmark, uint32(mark)))
+		if len(sl) == 2 {
+			mask, err := strconv.ParseUint(sl[1], 0, 32)
+			if err != nil {
+				return nil, err
+			}
+			attrs = append(attrs, u32Attr(fraFwmask, uint32(mask)))
+		}
+	}
+	if selector.IpProto != "" {
+		proto, err := parseIpProto(selector.IpProto)
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, u8Attr(fraIpProto, proto))
+	}
+	if selector.SPort != "" {
+		attr, err := portAttr(fraSportRange, selector.SPort)
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, attr)
+	}
+	if selector.DPort != "" {
+		attr, err := portAttr(fraDportRange, selector.DPort)
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, attr)
+	}
+	if rule.ruleAction.Proto != "" {
+		proto, err := parseRtProto(rule.ruleAction.Proto)
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, u8Attr(fraProtocol, proto))
+	}
+	return marshalBody(hdr, attrs), nil
 }
 
 // creat
@@ -241,6 +460,10 @@ func (rule *Rule) create() ([]byte, error) {
 }
 
 func (rule *Rule) Remove() ([]byte, error) {
+	// forget rule first, so that reconcile never re-creates it
+	if rule.route != nil {
+		rule.route.dropRule(rule)
+	}
 	buf, err := rule.action(del)
 	if err != nil {
 		return buf, err
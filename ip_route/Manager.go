@@ -4,9 +4,9 @@
 
 package IpRoute
 
-import "github.com/linuxdeepin/go-lib/log"
+import logging "github.com/linuxdeepin/deepin-network-proxy/logging"
 
-var logger *log.Logger
+var logger *logging.Logger
 
 type Manager struct {
 	routes map[string]*Route
@@ -36,5 +36,5 @@ func (m *Manager) CreateRoute(name string, node RouteNodeSpec, info RouteInfoSpe
 }
 
 func init() {
-	logger = log.NewLogger("proxy/iproute")
+	logger = logging.New("proxy/iproute")
 }
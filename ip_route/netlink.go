@@ -0,0 +1,464 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package IpRoute
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// from linux/include/uapi/linux/fib_rules.h
+const (
+	fraDst        = 1
+	fraSrc        = 2
+	fraFwmark     = 10
+	fraTable      = 15
+	fraFwmask     = 16
+	fraProtocol   = 21
+	fraIpProto    = 22
+	fraSportRange = 23
+	fraDportRange = 24
+
+	frActToTbl    = 1
+	fibRuleInvert = 2
+)
+
+// from linux/include/uapi/linux/rtnetlink.h
+const (
+	rtaTable  = 15
+	rtaxMtu   = 2
+	rtTableLo = 252 // table id larger than 255 is set by attr
+)
+
+// header of rule and route message, fib_rule_hdr and rtmsg have the same layout
+type rtHdr struct {
+	Family uint8
+	DstLen uint8
+	SrcLen uint8
+	Tos    uint8
+	Table  uint8
+	Proto  uint8 // res1 of fib_rule_hdr
+	Scope  uint8 // res2 of fib_rule_hdr
+	Type   uint8 // action of fib_rule_hdr
+	Flags  uint32
+}
+
+// route attr
+type rtAttr struct {
+	typ  uint16
+	data []byte
+}
+
+func u8Attr(typ uint16, val uint8) rtAttr {
+	return rtAttr{typ: typ, data: []byte{val}}
+}
+
+func u32Attr(typ uint16, val uint32) rtAttr {
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, val)
+	return rtAttr{typ: typ, data: data}
+}
+
+// port range, struct fib_rule_port_range
+func portAttr(typ uint16, port string) (rtAttr, error) {
+	sl := strings.SplitN(port, "-", 2)
+	start, err := strconv.ParseUint(sl[0], 10, 16)
+	if err != nil {
+		return rtAttr{}, err
+	}
+	end := start
+	if len(sl) == 2 {
+		end, err = strconv.ParseUint(sl[1], 10, 16)
+		if err != nil {
+			return rtAttr{}, err
+		}
+	}
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint16(data, uint16(start))
+	binary.LittleEndian.PutUint16(data[2:], uint16(end))
+	return rtAttr{typ: typ, data: data}, nil
+}
+
+// marshal attrs, each attr is aligned to 4 bytes
+func marshalAttrs(attrs []rtAttr) []byte {
+	buf := bytes.NewBuffer(nil)
+	for _, attr := range attrs {
+		length := syscall.SizeofRtAttr + len(attr.data)
+		_ = binary.Write(buf, binary.LittleEndian, syscall.RtAttr{Len: uint16(length), Type: attr.typ})
+		buf.Write(attr.data)
+		buf.Write(make([]byte, rtaAlign(length)-length))
+	}
+	return buf.Bytes()
+}
+
+func rtaAlign(length int) int {
+	return (length + syscall.RTA_ALIGNTO - 1) & ^(syscall.RTA_ALIGNTO - 1)
+}
+
+// marshal message body
+func marshalBody(hdr rtHdr, attrs []rtAttr) []byte {
+	buf := bytes.NewBuffer(nil)
+	_ = binary.Write(buf, binary.LittleEndian, hdr)
+	buf.Write(marshalAttrs(attrs))
+	return buf.Bytes()
+}
+
+// parse table name, local main default or num
+func parseTable(table string) (uint32, error) {
+	switch table {
+	case "", "main":
+		return syscall.RT_TABLE_MAIN, nil
+	case "local":
+		return syscall.RT_TABLE_LOCAL, nil
+	case "default":
+		return syscall.RT_TABLE_DEFAULT, nil
+	}
+	id, err := strconv.ParseUint(table, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid table %s", table)
+	}
+	return uint32(id), nil
+}
+
+// table id in header, large id is only kept in attr
+func hdrTable(id uint32) uint8 {
+	if id > 255 {
+		return rtTableLo
+	}
+	return uint8(id)
+}
+
+// parse prefix, default or ip or cidr, return ip and prefix length
+func parsePrefix(prefix string) (net.IP, int, error) {
+	if prefix == "" || prefix == "default" || prefix == "all" {
+		return nil, 0, nil
+	}
+	if !strings.Contains(prefix, "/") {
+		ip := net.ParseIP(prefix)
+		if ip == nil {
+			return nil, 0, fmt.Errorf("invalid prefix %s", prefix)
+		}
+		if ip.To4() != nil {
+			return ip.To4(), 32, nil
+		}
+		return ip, 128, nil
+	}
+	_, ipNet, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return nil, 0, err
+	}
+	ones, _ := ipNet.Mask.Size()
+	if ip := ipNet.IP.To4(); ip != nil {
+		return ip, ones, nil
+	}
+	return ipNet.IP, ones, nil
+}
+
+// family of ip, ipv4 is default
+func ipFamily(ip net.IP) uint8 {
+	if ip != nil && ip.To4() == nil {
+		return syscall.AF_INET6
+	}
+	return syscall.AF_INET
+}
+
+// parse ip proto name or num
+func parseIpProto(proto string) (uint8, error) {
+	switch proto {
+	case "tcp":
+		return syscall.IPPROTO_TCP, nil
+	case "udp":
+		return syscall.IPPROTO_UDP, nil
+	case "icmp":
+		return syscall.IPPROTO_ICMP, nil
+	}
+	num, err := strconv.ParseUint(proto, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ip proto %s", proto)
+	}
+	return uint8(num), nil
+}
+
+// parse route proto name or num
+func parseRtProto(proto string) (uint8, error) {
+	switch proto {
+	case "":
+		return syscall.RTPROT_BOOT, nil
+	case "kernel":
+		return syscall.RTPROT_KERNEL, nil
+	case "boot":
+		return syscall.RTPROT_BOOT, nil
+	case "static":
+		return syscall.RTPROT_STATIC, nil
+	}
+	num, err := strconv.ParseUint(proto, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid route proto %s", proto)
+	}
+	return uint8(num), nil
+}
+
+// parse route type
+func parseRtType(typ string) (uint8, error) {
+	switch typ {
+	case "", "unicast":
+		return syscall.RTN_UNICAST, nil
+	case "local":
+		return syscall.RTN_LOCAL, nil
+	case "broadcast":
+		return syscall.RTN_BROADCAST, nil
+	case "blackhole":
+		return syscall.RTN_BLACKHOLE, nil
+	case "unreachable":
+		return syscall.RTN_UNREACHABLE, nil
+	case "prohibit":
+		return syscall.RTN_PROHIBIT, nil
+	}
+	return 0, fmt.Errorf("invalid route type %s", typ)
+}
+
+// parse route scope
+func parseRtScope(scope string) (uint8, error) {
+	switch scope {
+	case "global":
+		return syscall.RT_SCOPE_UNIVERSE, nil
+	case "link":
+		return syscall.RT_SCOPE_LINK, nil
+	case "host":
+		return syscall.RT_SCOPE_HOST, nil
+	}
+	return 0, fmt.Errorf("invalid route scope %s", scope)
+}
+
+// send request to kernel by netlink route socket, return reply messages of dump
+func nlRequest(msgType uint16, flags uint16, body []byte) ([]syscall.NetlinkMessage, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.Close(fd)
+	kAddr := &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}
+	err = syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK})
+	if err != nil {
+		return nil, err
+	}
+	nlMsg := syscall.NlMsghdr{
+		Len:   syscall.NLMSG_HDRLEN + uint32(len(body)),
+		Type:  msgType,
+		Flags: syscall.NLM_F_REQUEST | syscall.NLM_F_ACK | flags,
+		Seq:   1,
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, nlMsg.Len))
+	_ = binary.Write(buf, binary.LittleEndian, nlMsg)
+	buf.Write(body)
+	err = syscall.Sendto(fd, buf.Bytes(), 0, kAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	var replies []syscall.NetlinkMessage
+	rBuf := make([]byte, 65536)
+	for {
+		n, _, err := syscall.Recvfrom(fd, rBuf, 0)
+		if err != nil {
+			return nil, err
+		}
+		msgs, err := syscall.ParseNetlinkMessage(rBuf[:n])
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range msgs {
+			switch msg.Header.Type {
+			case syscall.NLMSG_DONE:
+				return replies, nil
+			case syscall.NLMSG_ERROR:
+				if len(msg.Data) < 4 {
+					return nil, errors.New("invalid netlink error message")
+				}
+				// errno 0 is ack
+				errno := int32(binary.LittleEndian.Uint32(msg.Data[:4]))
+				if errno != 0 {
+					return nil, syscall.Errno(-errno)
+				}
+				return replies, nil
+			default:
+				replies = append(replies, msg)
+			}
+		}
+	}
+}
+
+// parse header and attrs of dumped rule or route message
+func parseBody(data []byte) (rtHdr, []rtAttr, bool) {
+	var hdr rtHdr
+	hdrLen := binary.Size(hdr)
+	if len(data) < hdrLen {
+		return hdr, nil, false
+	}
+	_ = binary.Read(bytes.NewReader(data[:hdrLen]), binary.LittleEndian, &hdr)
+	var attrs []rtAttr
+	buf := data[hdrLen:]
+	for len(buf) >= syscall.SizeofRtAttr {
+		length := int(binary.LittleEndian.Uint16(buf[:2]))
+		if length < syscall.SizeofRtAttr || length > len(buf) {
+			break
+		}
+		attrs = append(attrs, rtAttr{
+			typ:  binary.LittleEndian.Uint16(buf[2:4]),
+			data: buf[syscall.SizeofRtAttr:length],
+		})
+		if rtaAlign(length) >= len(buf) {
+			break
+		}
+		buf = buf[rtaAlign(length):]
+	}
+	return hdr, attrs, true
+}
+
+// table of dumped rule or route message, table attr overrides header
+func msgTable(hdr rtHdr, attrs []rtAttr) uint32 {
+	table := uint32(hdr.Table)
+	for _, attr := range attrs {
+		// fra_table and rta_table has the same value
+		if attr.typ == rtaTable && len(attr.data) >= 4 {
+			table = binary.LittleEndian.Uint32(attr.data)
+		}
+	}
+	return table
+}
+
+// keep attrs which identify route, kernel refuses to delete route with cache info and so on
+func routeKeyAttrs(attrs []rtAttr) []rtAttr {
+	var keys []rtAttr
+	for _, attr := range attrs {
+		switch attr.typ {
+		case syscall.RTA_DST, syscall.RTA_GATEWAY, syscall.RTA_OIF, syscall.RTA_PRIORITY, rtaTable:
+			keys = append(keys, attr)
+		}
+	}
+	return keys
+}
+
+// delete all rules and routes pointing to table, which are left when daemon crashed
+func Recover(table string) error {
+	id, err := parseTable(table)
+	if err != nil {
+		return err
+	}
+	count := 0
+	for _, typ := range [][2]uint16{{syscall.RTM_GETRULE, syscall.RTM_DELRULE}, {syscall.RTM_GETROUTE, syscall.RTM_DELROUTE}} {
+		for _, family := range []uint8{syscall.AF_INET, syscall.AF_INET6} {
+			msgs, err := nlRequest(typ[0], syscall.NLM_F_DUMP, marshalBody(rtHdr{Family: family}, nil))
+			if err != nil {
+				logger.Warningf("[%s] dump left rules and routes failed, err: %v", table, err)
+				return err
+			}
+			for _, msg := range msgs {
+				hdr, attrs, ok := parseBody(msg.Data)
+				if !ok || msgTable(hdr, attrs) != id {
+					continue
+				}
+				// some kernel dont fill family in dump
+				if hdr.Family == syscall.AF_UNSPEC {
+					hdr.Family = family
+				}
+				// dumped rule is a valid delete request
+				if typ[1] == syscall.RTM_DELROUTE {
+					attrs = routeKeyAttrs(attrs)
+				}
+				_, err = nlRequest(typ[1], 0, marshalBody(hdr, attrs))
+				if err != nil {
+					logger.Warningf("[%s] delete left rule or route failed, err: %v", table, err)
+					continue
+				}
+				count++
+			}
+		}
+	}
+	if count != 0 {
+		logger.Infof("[%s] recover left rules and routes success, count: %d", table, count)
+	}
+	return nil
+}
+
+// fwmarks used by all ip rules, include rules of other tools such as vpn
+func UsedMarks() ([]uint32, error) {
+	var marks []uint32
+	for _, family := range []uint8{syscall.AF_INET, syscall.AF_INET6} {
+		msgs, err := nlRequest(syscall.RTM_GETRULE, syscall.NLM_F_DUMP, marshalBody(rtHdr{Family: family}, nil))
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range msgs {
+			_, attrs, ok := parseBody(msg.Data)
+			if !ok {
+				continue
+			}
+			for _, attr := range attrs {
+				if attr.typ == fraFwmark && len(attr.data) >= 4 {
+					marks = append(marks, binary.LittleEndian.Uint32(attr.data))
+				}
+			}
+		}
+	}
+	return marks, nil
+}
+
+// check if rule or route of request body exists in kernel, dumped message has more attrs than request,
+// such as priority of rule and cache info of route, so only attrs of request are compared
+func nlExists(getType uint16, body []byte) (bool, error) {
+	want, wantAttrs, ok := parseBody(body)
+	if !ok {
+		return false, errors.New("invalid rule or route message")
+	}
+	table := msgTable(want, wantAttrs)
+	msgs, err := nlRequest(getType, syscall.NLM_F_DUMP, marshalBody(rtHdr{Family: want.Family}, nil))
+	if err != nil {
+		return false, err
+	}
+	for _, msg := range msgs {
+		hdr, attrs, ok := parseBody(msg.Data)
+		if !ok || msgTable(hdr, attrs) != table {
+			continue
+		}
+		// some kernel dont fill family in dump
+		if hdr.Family == syscall.AF_UNSPEC {
+			hdr.Family = want.Family
+		}
+		if hdr.Family != want.Family || hdr.DstLen != want.DstLen || hdr.SrcLen != want.SrcLen || hdr.Type != want.Type {
+			continue
+		}
+		if getType == syscall.RTM_GETRULE && hdr.Flags&fibRuleInvert != want.Flags&fibRuleInvert {
+			continue
+		}
+		if hasAttrs(attrs, wantAttrs) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// check if attrs contain all wanted attrs with the same data
+func hasAttrs(attrs []rtAttr, wanted []rtAttr) bool {
+	for _, want := range wanted {
+		found := false
+		for _, attr := range attrs {
+			if attr.typ == want.typ && bytes.Equal(attr.data, want.data) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,99 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package IpRoute
+
+import (
+	"encoding/binary"
+	"syscall"
+	"testing"
+)
+
+func TestRuleMarshal(t *testing.T) {
+	rule := &Rule{
+		route:        &Route{table: "100"},
+		ruleSelector: RuleSelector{Fwmark: "8080", DPort: "80-90"},
+	}
+	body, err := rule.marshal()
+	if err != nil {
+		t.Fatalf("marshal rule failed, err: %v", err)
+	}
+	// header, table, fwmark, dport range, all are 4 bytes aligned
+	if len(body) != 12+8+8+8 {
+		t.Fatalf("rule length incorrect, len: %d", len(body))
+	}
+	if body[0] != syscall.AF_INET || body[4] != 100 || body[7] != frActToTbl {
+		t.Errorf("rule header incorrect, header: %v", body[:12])
+	}
+	hdr, attrs, ok := parseBody(body)
+	if table := msgTable(hdr, attrs); !ok || table != 100 {
+		t.Errorf("table of rule incorrect, table: %d", msgTable(hdr, attrs))
+	}
+	if mark := binary.LittleEndian.Uint32(body[24:28]); mark != 8080 {
+		t.Errorf("fwmark of rule incorrect, mark: %d", mark)
+	}
+	_, err = (&Rule{ruleSelector: RuleSelector{Fwmark: "mark"}}).marshal()
+	if err == nil {
+		t.Error("marshal rule with invalid fwmark should fail")
+	}
+}
+
+func TestRouteMarshal(t *testing.T) {
+	route := &Route{
+		table: "1000",
+		Node:  RouteNodeSpec{Type: "local", Prefix: "10.0.0.0/8"},
+	}
+	body, err := route.marshal()
+	if err != nil {
+		t.Fatalf("marshal route failed, err: %v", err)
+	}
+	// large table id is only kept in attr
+	if body[1] != 8 || body[4] != rtTableLo || body[6] != syscall.RT_SCOPE_HOST || body[7] != syscall.RTN_LOCAL {
+		t.Errorf("route header incorrect, header: %v", body[:12])
+	}
+	hdr, attrs, ok := parseBody(body)
+	if table := msgTable(hdr, attrs); !ok || table != 1000 {
+		t.Errorf("table of route incorrect, table: %d", msgTable(hdr, attrs))
+	}
+}
+
+func TestIpv6Marshal(t *testing.T) {
+	route := &Route{
+		table: "100",
+		Node:  RouteNodeSpec{Type: "local", Prefix: "::/0"},
+	}
+	body, err := route.marshal()
+	if err != nil {
+		t.Fatalf("marshal route failed, err: %v", err)
+	}
+	// default route has no dst attr
+	hdr, attrs, _ := parseBody(body)
+	if hdr.Family != syscall.AF_INET6 || hdr.DstLen != 0 || len(attrs) != 1 {
+		t.Errorf("ipv6 route incorrect, header: %v, attrs: %v", hdr, attrs)
+	}
+	// fwmark rule has the same family as route
+	rule := &Rule{route: route, ruleSelector: RuleSelector{Fwmark: "8080"}}
+	body, err = rule.marshal()
+	if err != nil {
+		t.Fatalf("marshal rule failed, err: %v", err)
+	}
+	if body[0] != syscall.AF_INET6 {
+		t.Errorf("family of ipv6 rule incorrect, family: %d", body[0])
+	}
+	rule.route = &Route{table: "100", Node: RouteNodeSpec{Type: "local", Prefix: "default"}}
+	body, _ = rule.marshal()
+	if body[0] != syscall.AF_INET {
+		t.Errorf("family of ipv4 rule incorrect, family: %d", body[0])
+	}
+}
+
+func TestHasAttrs(t *testing.T) {
+	dumped := []rtAttr{u32Attr(fraTable, 100), u32Attr(fraFwmark, 8080), u32Attr(fraFwmask, 0xffffffff)}
+	if !hasAttrs(dumped, []rtAttr{u32Attr(fraTable, 100), u32Attr(fraFwmark, 8080)}) {
+		t.Error("dumped rule should contain attrs of request")
+	}
+	if hasAttrs(dumped, []rtAttr{u32Attr(fraFwmark, 8090)}) {
+		t.Error("dumped rule should not contain other fwmark")
+	}
+}
@@ -0,0 +1,72 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package IpRoute
+
+import (
+	"syscall"
+)
+
+// rules and routes may be flushed by other tools such as vpn and network manager,
+// reconcile checks them against kernel and re-creates the missing ones
+
+// check if route exists in kernel
+func (r *Route) exists() (bool, error) {
+	body, err := r.marshal()
+	if err != nil {
+		return false, err
+	}
+	return nlExists(syscall.RTM_GETROUTE, body)
+}
+
+// check if rule exists in kernel
+func (rule *Rule) exists() (bool, error) {
+	body, err := rule.marshal()
+	if err != nil {
+		return false, err
+	}
+	return nlExists(syscall.RTM_GETRULE, body)
+}
+
+// re-create missing route and rules pointing to it, return count of repaired
+func (r *Route) Reconcile() (int, error) {
+	var count int
+	ok, err := r.exists()
+	if err != nil {
+		logger.Warningf("[%s] check route failed, err: %v", r.table, err)
+		return 0, err
+	}
+	if !ok {
+		err = r.create()
+		if err != nil {
+			return 0, err
+		}
+		count++
+	}
+	var lastErr error
+	// rule removing waits, so that rule removed is never re-created
+	r.rulesLock.Lock()
+	defer r.rulesLock.Unlock()
+	for _, rule := range r.rules {
+		ok, err = rule.exists()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if ok {
+			continue
+		}
+		_, err = rule.create()
+		if err != nil {
+			logger.Warningf("[%s] reconcile rule %s failed, err: %v", r.table, rule.ruleSelector.String(), err)
+			lastErr = err
+			continue
+		}
+		count++
+	}
+	if count != 0 {
+		logger.Infof("[%s] reconcile route success, repaired: %d", r.table, count)
+	}
+	return count, lastErr
+}
@@ -0,0 +1,130 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Logging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// lines are sent by native protocol of journald, fields of logger become journal fields,
+// so that journalctl -u deepin-network-proxy CONNECTION_ID=7 shows lines of one connection.
+// https://systemd.io/JOURNAL_NATIVE_PROTOCOL/
+
+// socket of journald native protocol
+const journalSocket = "/run/systemd/journal/socket"
+
+// identifier of lines in journal
+const SyslogIdentifier = "deepin-network-proxy"
+
+// journal fields of well known logger fields, others are upper case of key
+var journalFields = map[string]string{
+	"conn": "CONNECTION_ID",
+	"exe":  "APP_EXE",
+}
+
+// syslog priority of level
+func (l Level) syslogPriority() int {
+	switch l {
+	case LevelDebug:
+		return 7
+	case LevelInfo:
+		return 6
+	case LevelWarning:
+		return 4
+	default:
+		return 3
+	}
+}
+
+type journalWriter struct {
+	conn *net.UnixConn
+	addr *net.UnixAddr
+}
+
+// journal is used only if stderr of daemon is connected to journal by systemd
+func openJournal() *journalWriter {
+	if !stderrIsJournal() {
+		return nil
+	}
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Net: "unixgram"})
+	if err != nil {
+		return nil
+	}
+	return &journalWriter{
+		conn: conn,
+		addr: &net.UnixAddr{Name: journalSocket, Net: "unixgram"},
+	}
+}
+
+// JOURNAL_STREAM is device and inode of stream journal gives to stderr
+func stderrIsJournal() bool {
+	stream := os.Getenv("JOURNAL_STREAM")
+	if stream == "" {
+		return false
+	}
+	var st syscall.Stat_t
+	err := syscall.Fstat(int(os.Stderr.Fd()), &st)
+	if err != nil {
+		return false
+	}
+	return stream == fmt.Sprintf("%d:%d", st.Dev, st.Ino)
+}
+
+func (j *journalWriter) send(l *Logger, lv Level, msg string) error {
+	_, err := j.conn.WriteToUnix(l.journalEntry(lv, msg), j.addr)
+	return err
+}
+
+// datagram of native protocol
+func (l *Logger) journalEntry(lv Level, msg string) []byte {
+	var buf bytes.Buffer
+	appendJournalField(&buf, "MESSAGE", msg)
+	appendJournalField(&buf, "PRIORITY", fmt.Sprint(lv.syslogPriority()))
+	appendJournalField(&buf, "SYSLOG_IDENTIFIER", SyslogIdentifier)
+	appendJournalField(&buf, "MODULE", l.module)
+	for _, f := range l.fields {
+		appendJournalField(&buf, journalFieldName(f.key), fmt.Sprint(textOf(f.value)))
+	}
+	return buf.Bytes()
+}
+
+// name of field should be upper case letters, digits and underscore, not starts with underscore
+func journalFieldName(key string) string {
+	if name, ok := journalFields[key]; ok {
+		return name
+	}
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+	return strings.TrimLeft(name, "_")
+}
+
+// value with new line is written with its length
+func appendJournalField(buf *bytes.Buffer, name string, value string) {
+	buf.WriteString(name)
+	if !strings.Contains(value, "\n") {
+		buf.WriteByte('=')
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+		return
+	}
+	buf.WriteByte('\n')
+	_ = binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
@@ -0,0 +1,93 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Logging
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// when proxy is down, every connection of every app warns the same, journal is flooded.
+// lines of the same level, module and format over burst in window are dropped,
+// count of dropped lines is written as one summary line when window ends.
+
+const (
+	limitWindow = 10 * time.Second
+	limitBurst  = 5
+)
+
+type limitKey struct {
+	level  Level
+	module string
+	format string
+}
+
+// lines of key in current window
+type limitState struct {
+	start   time.Time
+	count   int
+	dropped int
+	timer   *time.Timer
+}
+
+var (
+	limitLock sync.Mutex
+	limits    = make(map[limitKey]*limitState)
+
+	// lines dropped since daemon started
+	suppressed uint64
+)
+
+// count of lines dropped since daemon started
+func Suppressed() uint64 {
+	return atomic.LoadUint64(&suppressed)
+}
+
+// check if line of key can be written, summary is scheduled at end of window when first line dropped
+func allow(key limitKey, now time.Time) bool {
+	limitLock.Lock()
+	defer limitLock.Unlock()
+	state, ok := limits[key]
+	if !ok || now.Sub(state.start) >= limitWindow {
+		if ok && state.dropped > 0 {
+			// timer has not fired yet, summary is written now
+			state.timer.Stop()
+			writeSummary(key, state.dropped, now)
+		}
+		limits[key] = &limitState{start: now, count: 1}
+		return true
+	}
+	state.count++
+	if state.count <= limitBurst {
+		return true
+	}
+	if state.dropped == 0 {
+		state.timer = time.AfterFunc(state.start.Add(limitWindow).Sub(now), func() {
+			flushLimit(key)
+		})
+	}
+	state.dropped++
+	atomic.AddUint64(&suppressed, 1)
+	return false
+}
+
+// write summary of key if lines dropped, and start a new window
+func flushLimit(key limitKey) {
+	limitLock.Lock()
+	defer limitLock.Unlock()
+	state, ok := limits[key]
+	if !ok || state.dropped == 0 {
+		return
+	}
+	writeSummary(key, state.dropped, time.Now())
+	delete(limits, key)
+}
+
+func writeSummary(key limitKey, dropped int, now time.Time) {
+	summary := &Logger{module: key.module, fields: []field{{key: "repeated", value: dropped}}}
+	emit(summary, now, key.level, fmt.Sprintf("message repeated %d times: %s", dropped, key.format))
+}
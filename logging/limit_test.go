@@ -0,0 +1,53 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRepeatedLinesSuppressed(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	defer SetOutput(os.Stderr)
+	SetLevel(LevelInfo)
+
+	before := Suppressed()
+	logger := New("proxy/limit-test")
+	for conn := 0; conn < limitBurst+7; conn++ {
+		logger.With("conn", conn).Warningf("failed to dial proxy server, err: %v", "connection refused")
+	}
+	// other format is not limited
+	logger.Infof("proxy is stopped")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != limitBurst+1 {
+		t.Fatalf("lines got %d, want %d:\n%s", len(lines), limitBurst+1, buf.String())
+	}
+	if Suppressed()-before != 7 {
+		t.Errorf("suppressed got %d, want 7", Suppressed()-before)
+	}
+
+	buf.Reset()
+	flushLimit(limitKey{level: LevelWarning, module: "proxy/limit-test", format: "failed to dial proxy server, err: %v"})
+	var summary map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &summary); err != nil {
+		t.Fatalf("summary is not json, err: %v, line: %s", err, buf.String())
+	}
+	if summary["repeated"] != float64(7) || summary["level"] != "warning" ||
+		!strings.HasPrefix(summary["msg"].(string), "message repeated 7 times") {
+		t.Errorf("summary got %v", summary)
+	}
+
+	// window starts again after summary
+	buf.Reset()
+	logger.Warningf("failed to dial proxy server, err: %v", "connection refused")
+	if buf.Len() == 0 {
+		t.Error("line after summary is dropped")
+	}
+}
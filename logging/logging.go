@@ -0,0 +1,231 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// lines are sent to journald with priority and fields when daemon runs under systemd,
+// otherwise written to stderr as json, one object per line.
+// fields like id of connection, scope, exe and destination are attached once and carried by every line,
+// so that all lines of one connection can be found by id in journal.
+
+type Level int32
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarning
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarning:
+		return "warning"
+	case LevelError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// parse level name
+func ParseLevel(name string) (Level, error) {
+	switch name {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warning":
+		return LevelWarning, nil
+	case "error":
+		return LevelError, nil
+	default:
+		return LevelInfo, fmt.Errorf("log level %s is invalid, should be debug, info, warning or error", name)
+	}
+}
+
+var (
+	level = int32(LevelInfo)
+
+	// json lines go to stderr if journal is not used
+	outLock sync.Mutex
+	output  io.Writer = os.Stderr
+	journal *journalWriter
+)
+
+// current level
+func GetLevel() Level {
+	return Level(atomic.LoadInt32(&level))
+}
+
+// set level of all loggers
+func SetLevel(l Level) {
+	atomic.StoreInt32(&level, int32(l))
+}
+
+// replace writer of json lines and stop sending to journal, used by tests
+func SetOutput(w io.Writer) {
+	outLock.Lock()
+	defer outLock.Unlock()
+	output = w
+	journal = nil
+}
+
+type field struct {
+	key   string
+	value interface{}
+}
+
+// logger of module with fields, safe for concurrent use
+type Logger struct {
+	module string
+	fields []field
+}
+
+func New(module string) *Logger {
+	return &Logger{module: module}
+}
+
+// copy of logger with more fields, kv is key and value in pairs
+func (l *Logger) With(kv ...interface{}) *Logger {
+	fields := make([]field, len(l.fields), len(l.fields)+len(kv)/2)
+	copy(fields, l.fields)
+	for index := 0; index+1 < len(kv); index += 2 {
+		fields = append(fields, field{key: fmt.Sprint(kv[index]), value: kv[index+1]})
+	}
+	return &Logger{module: l.module, fields: fields}
+}
+
+func (l *Logger) Debugf(format string, v ...interface{}) {
+	l.logf(LevelDebug, format, v...)
+}
+
+func (l *Logger) Infof(format string, v ...interface{}) {
+	l.logf(LevelInfo, format, v...)
+}
+
+func (l *Logger) Warningf(format string, v ...interface{}) {
+	l.logf(LevelWarning, format, v...)
+}
+
+func (l *Logger) Errorf(format string, v ...interface{}) {
+	l.logf(LevelError, format, v...)
+}
+
+func (l *Logger) Debug(v ...interface{}) {
+	l.log(LevelDebug, v...)
+}
+
+func (l *Logger) Info(v ...interface{}) {
+	l.log(LevelInfo, v...)
+}
+
+func (l *Logger) Warning(v ...interface{}) {
+	l.log(LevelWarning, v...)
+}
+
+func (l *Logger) Error(v ...interface{}) {
+	l.log(LevelError, v...)
+}
+
+// log error and exit
+func (l *Logger) Fatal(v ...interface{}) {
+	l.log(LevelError, v...)
+	os.Exit(1)
+}
+
+// values are joined by space like fmt.Println, format is the key of rate limit
+func (l *Logger) log(lv Level, v ...interface{}) {
+	if lv < GetLevel() {
+		return
+	}
+	msg := strings.TrimSuffix(fmt.Sprintln(v...), "\n")
+	now := time.Now()
+	if !allow(limitKey{level: lv, module: l.module, format: msg}, now) {
+		return
+	}
+	emit(l, now, lv, msg)
+}
+
+func (l *Logger) logf(lv Level, format string, v ...interface{}) {
+	if lv < GetLevel() {
+		return
+	}
+	now := time.Now()
+	if !allow(limitKey{level: lv, module: l.module, format: format}, now) {
+		return
+	}
+	emit(l, now, lv, fmt.Sprintf(format, v...))
+}
+
+// send line to journal, json line is written if journal is not used or failed
+func emit(l *Logger, now time.Time, lv Level, msg string) {
+	outLock.Lock()
+	defer outLock.Unlock()
+	if journal != nil && journal.send(l, lv, msg) == nil {
+		return
+	}
+	_, _ = output.Write(l.line(now, lv, msg))
+}
+
+// json object of one line, keys keep order of fields
+func (l *Logger) line(now time.Time, lv Level, msg string) []byte {
+	buf := make([]byte, 0, 256)
+	buf = append(buf, '{')
+	buf = appendPair(buf, "time", now.Format(time.RFC3339Nano))
+	buf = append(buf, ',')
+	buf = appendPair(buf, "level", lv.String())
+	buf = append(buf, ',')
+	buf = appendPair(buf, "module", l.module)
+	buf = append(buf, ',')
+	buf = appendPair(buf, "msg", msg)
+	for _, f := range l.fields {
+		buf = append(buf, ',')
+		buf = appendPair(buf, f.key, f.value)
+	}
+	buf = append(buf, '}', '\n')
+	return buf
+}
+
+func appendPair(buf []byte, key string, value interface{}) []byte {
+	data, _ := json.Marshal(key)
+	buf = append(buf, data...)
+	buf = append(buf, ':')
+	data, err := json.Marshal(textOf(value))
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(value))
+	}
+	return append(buf, data...)
+}
+
+// addrs and errors are written as text
+func textOf(value interface{}) interface{} {
+	switch val := value.(type) {
+	case error:
+		return val.Error()
+	case fmt.Stringer:
+		return val.String()
+	default:
+		return value
+	}
+}
+
+func init() {
+	journal = openJournal()
+}
@@ -0,0 +1,84 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLoggerLine(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	defer SetOutput(os.Stderr)
+	SetLevel(LevelInfo)
+
+	base := New("proxy/tproxy").With("scope", "App")
+	conn := base.With("conn", 7, "destination", &net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 443})
+	conn.Infof("tunnel %s", "created")
+	conn.Debugf("dropped at info level")
+	base.Warningf("failed, err: %v", errors.New(`bad "quote"`))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("lines got %d, want 2:\n%s", len(lines), buf.String())
+	}
+	var line map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &line); err != nil {
+		t.Fatalf("line is not json, err: %v, line: %s", err, lines[0])
+	}
+	if line["msg"] != "tunnel created" || line["level"] != "info" || line["scope"] != "App" ||
+		line["conn"] != float64(7) || line["destination"] != "1.2.3.4:443" {
+		t.Errorf("line got %v", line)
+	}
+	// fields keep order
+	if !strings.Contains(lines[0], `"scope":"App","conn":7,"destination":"1.2.3.4:443"}`) {
+		t.Errorf("fields out of order: %s", lines[0])
+	}
+	var baseLine map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[1]), &baseLine); err != nil || baseLine["scope"] != "App" || baseLine["conn"] != nil {
+		t.Errorf("line of base got %s", lines[1])
+	}
+}
+
+func TestSetLevel(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	defer SetOutput(os.Stderr)
+	level, err := ParseLevel("debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetLevel(level)
+	defer SetLevel(LevelInfo)
+	New("proxy/test").Debug("debug", "is", "on")
+	if GetLevel() != LevelDebug || !strings.Contains(buf.String(), `"msg":"debug is on"`) {
+		t.Errorf("level got %v, line %s", GetLevel(), buf.String())
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("invalid level should be rejected")
+	}
+}
+
+func TestJournalEntry(t *testing.T) {
+	logger := New("proxy/tproxy").With("conn", 7, "exe", "/usr/bin/curl", "destination", &net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 443})
+	entry := string(logger.journalEntry(LevelWarning, "dial failed"))
+	for _, want := range []string{"MESSAGE=dial failed\n", "PRIORITY=4\n", "SYSLOG_IDENTIFIER=" + SyslogIdentifier + "\n",
+		"CONNECTION_ID=7\n", "APP_EXE=/usr/bin/curl\n", "DESTINATION=1.2.3.4:443\n"} {
+		if !strings.Contains(entry, want) {
+			t.Errorf("entry has no %q:\n%s", want, entry)
+		}
+	}
+	// value with new line is prefixed by length
+	entry = string(logger.journalEntry(LevelInfo, "a\nb"))
+	if !strings.HasPrefix(entry, "MESSAGE\n\x03\x00\x00\x00\x00\x00\x00\x00a\nb\n") {
+		t.Errorf("entry of multi lines got %q", entry)
+	}
+}
@@ -0,0 +1,287 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	logging "github.com/linuxdeepin/deepin-network-proxy/logging"
+)
+
+// metrics are written in prometheus text format, only counter, gauge and histogram are needed,
+// so that no client library is pulled in.
+// https://prometheus.io/docs/instrumenting/exposition_formats/
+
+var logger *logging.Logger
+
+// metric written at scrape
+type Collector interface {
+	write(w io.Writer)
+}
+
+// collectors written in order of registration
+type Registry struct {
+	lock       sync.Mutex
+	collectors []Collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) Register(collectors ...Collector) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.collectors = append(r.collectors, collectors...)
+}
+
+// write all metrics in text format
+func (r *Registry) Write(w io.Writer) {
+	r.lock.Lock()
+	collectors := append([]Collector{}, r.collectors...)
+	r.lock.Unlock()
+	buf := bufio.NewWriter(w)
+	for _, collector := range collectors {
+		collector.write(buf)
+	}
+	_ = buf.Flush()
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.Write(w)
+}
+
+// serve /metrics at addr until server closed
+func Serve(addr string, r *Registry) (*http.Server, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		err := server.Serve(l)
+		if err != nil && err != http.ErrServerClosed {
+			logger.Warningf("[metrics] serve metrics failed, err: %v", err)
+		}
+	}()
+	return server, nil
+}
+
+// counter with labels
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	lock   sync.Mutex
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	values []string
+	value  float64
+}
+
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	return &CounterVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		series: make(map[string]*counterSeries),
+	}
+}
+
+// add delta to counter of label values, values are in order of labels
+func (c *CounterVec) Add(delta float64, values ...string) {
+	key := strings.Join(values, "\xff")
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	s, ok := c.series[key]
+	if !ok {
+		s = &counterSeries{values: values}
+		c.series[key] = s
+	}
+	s.value += delta
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	writeHeader(w, c.name, c.help, "counter")
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	keys := make([]string, 0, len(c.series))
+	for key := range c.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := c.series[key]
+		writeSample(w, c.name, labelPairs(c.labels, s.values), s.value)
+	}
+}
+
+// histogram with labels, buckets are upper bounds in ascending order
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	lock   sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	values []string
+	counts []uint64 // count of each bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+func NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries),
+	}
+}
+
+// observe value of label values
+func (h *HistogramVec) Observe(value float64, values ...string) {
+	key := strings.Join(values, "\xff")
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{values: values, counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	index := sort.SearchFloat64s(h.buckets, value)
+	if index < len(h.buckets) {
+		s.counts[index]++
+	}
+	s.sum += value
+	s.count++
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	writeHeader(w, h.name, h.help, "histogram")
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := h.series[key]
+		pairs := labelPairs(h.labels, s.values)
+		var cumulative uint64
+		for index, bound := range h.buckets {
+			cumulative += s.counts[index]
+			le := append(pairs, labelPair("le", formatFloat(bound)))
+			writeSample(w, h.name+"_bucket", le, float64(cumulative))
+		}
+		writeSample(w, h.name+"_bucket", append(pairs, labelPair("le", "+Inf")), float64(s.count))
+		writeSample(w, h.name+"_sum", pairs, s.sum)
+		writeSample(w, h.name+"_count", pairs, float64(s.count))
+	}
+}
+
+// gauge or counter read from others at scrape, map[label value]value
+type FuncCollector struct {
+	name  string
+	help  string
+	typ   string
+	label string
+	fn    func() map[string]float64
+}
+
+// gauge of one label read at scrape
+func NewGaugeFunc(name string, help string, label string, fn func() map[string]float64) *FuncCollector {
+	return &FuncCollector{name: name, help: help, typ: "gauge", label: label, fn: fn}
+}
+
+// counter without label read at scrape
+func NewCounterFunc(name string, help string, fn func() float64) *FuncCollector {
+	return &FuncCollector{name: name, help: help, typ: "counter", fn: func() map[string]float64 {
+		return map[string]float64{"": fn()}
+	}}
+}
+
+func (f *FuncCollector) write(w io.Writer) {
+	writeHeader(w, f.name, f.help, f.typ)
+	values := f.fn()
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var pairs []string
+		if f.label != "" {
+			pairs = []string{labelPair(f.label, key)}
+		}
+		writeSample(w, f.name, pairs, values[key])
+	}
+}
+
+func writeHeader(w io.Writer, name string, help string, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
+
+func writeSample(w io.Writer, name string, pairs []string, value float64) {
+	if len(pairs) == 0 {
+		fmt.Fprintf(w, "%s %s\n", name, formatFloat(value))
+		return
+	}
+	fmt.Fprintf(w, "%s{%s} %s\n", name, strings.Join(pairs, ","), formatFloat(value))
+}
+
+func labelPairs(labels []string, values []string) []string {
+	pairs := make([]string, 0, len(labels)+1)
+	for index, label := range labels {
+		value := ""
+		if index < len(values) {
+			value = values[index]
+		}
+		pairs = append(pairs, labelPair(label, value))
+	}
+	return pairs
+}
+
+func labelPair(label string, value string) string {
+	return label + `="` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value) + `"`
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+func init() {
+	logger = logging.New("proxy/metrics")
+}
@@ -0,0 +1,49 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	counter := NewCounterVec("relayed_bytes_total", "Bytes relayed.", "proxy", "direction")
+	counter.Add(10, "http/a", "sent")
+	counter.Add(5, "http/a", "sent")
+	counter.Add(7, `http/"b"`, "received")
+	histogram := NewHistogramVec("handshake_seconds", "Handshake time.", []float64{0.1, 1}, "proxy")
+	histogram.Observe(0.05, "http/a")
+	histogram.Observe(0.5, "http/a")
+	histogram.Observe(3, "http/a")
+	gauge := NewGaugeFunc("active_tunnels", "Tunnels.", "scope", func() map[string]float64 {
+		return map[string]float64{"App": 2}
+	})
+	failures := NewCounterFunc("iptables_failures_total", "Failures.", func() float64 { return 1 })
+
+	registry := NewRegistry()
+	registry.Register(counter, histogram, gauge, failures)
+	var buf bytes.Buffer
+	registry.Write(&buf)
+	out := buf.String()
+	for _, line := range []string{
+		"# TYPE relayed_bytes_total counter",
+		`relayed_bytes_total{proxy="http/a",direction="sent"} 15`,
+		`relayed_bytes_total{proxy="http/\"b\"",direction="received"} 7`,
+		"# TYPE handshake_seconds histogram",
+		`handshake_seconds_bucket{proxy="http/a",le="0.1"} 1`,
+		`handshake_seconds_bucket{proxy="http/a",le="1"} 2`,
+		`handshake_seconds_bucket{proxy="http/a",le="+Inf"} 3`,
+		`handshake_seconds_sum{proxy="http/a"} 3.55`,
+		`handshake_seconds_count{proxy="http/a"} 3`,
+		`active_tunnels{scope="App"} 2`,
+		"iptables_failures_total 1",
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("line %q not found in:\n%s", line, out)
+		}
+	}
+}
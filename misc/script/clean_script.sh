@@ -13,13 +13,20 @@ clear_app_iptables(){
     iptables -t mangle -D PREROUTING -j TPROXY -p tcp --on-port 8090 -m mark --mark 8090
 
     ## del nat rule
-    iptables -t nat -D OUTPUT -j REDIRECT -p udp --dport 53 --to-ports 5353 -m cgroup --path App.slice
+    iptables -t nat -D OUTPUT -j REDIRECT -p udp --dport 53 --to-ports 5353 -m cgroup --path App.slice -m mark ! --mark 57065
+    iptables -t nat -D OUTPUT -j REDIRECT -p tcp --dport 53 --to-ports 5353 -m cgroup --path App.slice -m mark ! --mark 57065
+
+    ## clear app chain of redirect mode
+    iptables -t nat -F App
+    iptables -t nat -D Main -j App -p tcp -m cgroup --path App.slice
+    iptables -t nat -X App
 }
 
 ## clear app ip rule
 clear_app_iprule(){
     ## delete rule
     ip rule del fwmark 8090 table 100
+    ip -6 rule del fwmark 8090 table 100
 }
 
 ## clear app proxy setting
@@ -39,12 +46,18 @@ clear_global_iptables(){
 
     ## del mark rule from output chain
     iptables -t mangle -D PREROUTING -j TPROXY -p tcp --on-port 8080 -m mark --mark 8080
+
+    ## clear global chain of redirect mode
+    iptables -t nat -F Global
+    iptables -t nat -D Main -j Global -p tcp -m cgroup ! --path Global.slice
+    iptables -t nat -X Global
 }
 
 ## clear global ip rule
 clear_global_iprule(){
     ## delete rule
     ip rule del fwmark 8080 table 100
+    ip -6 rule del fwmark 8080 table 100
 }
 
 ## clear global proxy setting
@@ -61,12 +74,18 @@ clear_main_iptables(){
     iptables -t mangle -D OUTPUT -j Main
     ## remove main chain
     iptables -t mangle -X Main
+
+    ## clear main chain of redirect mode
+    iptables -t nat -F Main
+    iptables -t nat -D OUTPUT -j Main
+    iptables -t nat -X Main
 }
 
 ## clear main ip route
 clear_main_route(){
     ## remove ip route
     ip route del local default dev lo table 100
+    ip -6 route del local ::/0 dev lo table 100
 }
 
 ## clear main
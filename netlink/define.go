@@ -12,6 +12,9 @@ const (
 	status       = "status"
 	autoPid      = 0
 	cgroupPrefix = "/sys/fs/cgroup/unified"
+	cgroupProcs  = "cgroup.procs"
+	// one page holds several events
+	recvBufSize = 4096
 )
 
 const (
@@ -33,6 +36,13 @@ type CnMsg struct {
 	Flag uint16
 }
 
+// fork, exec or exit event of process
+type procEvent struct {
+	What uint32
+	Pid  string
+	PPid string // parent of fork event
+}
+
 // ProcEventHeader corresponds to proc_event in cn_proc.h
 type ProcEventHeader struct {
 	What      uint32
@@ -0,0 +1,106 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Netlink
+
+import (
+	"errors"
+	"sync/atomic"
+	"syscall"
+)
+
+// #include <linux/connector.h>
+// #include <linux/cn_proc.h>
+import "C"
+
+// procs service adds latency of dbus and is a hard dependency,
+// listener receives proc events of kernel in process instead
+
+// large receive buffer, in case events of short-lived process are dropped
+const listenerRcvBuf = 1024 * 1024
+
+// timeout of recv, so that close can be checked
+const listenerRecvTimeout = 1 // second
+
+// in process proc event listener
+type ProcListener struct {
+	connector
+	closed int32
+}
+
+// create listener and subscribe proc events
+func NewProcListener() (*ProcListener, error) {
+	l := &ProcListener{}
+	err := l.initSock()
+	if err != nil {
+		return nil, err
+	}
+	// errors of socket options are not fatal
+	_ = syscall.SetsockoptInt(l.sock, syscall.SOL_SOCKET, syscall.SO_RCVBUF, listenerRcvBuf)
+	tv := syscall.Timeval{Sec: listenerRecvTimeout}
+	_ = syscall.SetsockoptTimeval(l.sock, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv)
+	err = l.sendMsg(C.PROC_CN_MCAST_LISTEN)
+	if err != nil {
+		_ = syscall.Close(l.sock)
+		return nil, err
+	}
+	return l, nil
+}
+
+// handlers of proc events, nil handler is skipped
+type ProcHandler struct {
+	OnFork func(ppid string, pid string)
+	OnExec func(msg ProcMessage)
+	OnExit func(pid string)
+}
+
+// receive events until closed, forked pid, exec proc with exe path and exited pid are reported
+func (l *ProcListener) Run(handler ProcHandler) {
+	defer func() {
+		_ = l.sendMsg(C.PROC_CN_MCAST_IGNORE)
+		_ = syscall.Close(l.sock)
+	}()
+	for atomic.LoadInt32(&l.closed) == 0 {
+		events, err := l.recvEvents()
+		if err != nil {
+			if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR) {
+				continue
+			}
+			// events are dropped when buffer is full, keep listening
+			if errors.Is(err, syscall.ENOBUFS) {
+				logger.Warning("proc events overflow, some events are lost")
+				continue
+			}
+			logger.Warningf("recv proc events failed, err: %v", err)
+			return
+		}
+		for _, event := range events {
+			switch event.What {
+			case C.PROC_EVENT_FORK:
+				if handler.OnFork != nil {
+					handler.OnFork(event.PPid, event.Pid)
+				}
+			case C.PROC_EVENT_EXEC:
+				if handler.OnExec == nil {
+					continue
+				}
+				msg, err := getProcMsg(event.Pid)
+				if err != nil {
+					// proc may exit already
+					continue
+				}
+				handler.OnExec(msg)
+			case C.PROC_EVENT_EXIT:
+				if handler.OnExit != nil {
+					handler.OnExit(event.Pid)
+				}
+			}
+		}
+	}
+}
+
+// stop listening, run returns in recv timeout
+func (l *ProcListener) Close() {
+	atomic.StoreInt32(&l.closed, 1)
+}
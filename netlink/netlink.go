@@ -13,15 +13,15 @@ import (
 	"sync"
 	"syscall"
 
+	logging "github.com/linuxdeepin/deepin-network-proxy/logging"
 	"github.com/linuxdeepin/go-lib/dbusutil"
-	"github.com/linuxdeepin/go-lib/log"
 )
 
 // #include <linux/connector.h>
 // #include <linux/cn_proc.h>
 import "C"
 
-var logger *log.Logger
+var logger *logging.Logger
 
 // proc message
 type ProcMessage struct {
@@ -42,9 +42,7 @@ type ProcManager struct {
 	service *dbusutil.Service
 
 	// net_link module
-	sock  int
-	lAddr syscall.Sockaddr
-	kAddr syscall.Sockaddr
+	connector
 
 	//methods *struct {
 	//	ChangeCGroup func() `in:"pid,cgroup" out:"err"`
@@ -100,8 +98,15 @@ func (p *ProcManager) GetInterfaceName() string {
 //	return nil
 //}
 
+// netlink proc connector
+type connector struct {
+	sock  int
+	lAddr syscall.Sockaddr
+	kAddr syscall.Sockaddr
+}
+
 // init sock
-func (p *ProcManager) initSock() error {
+func (p *connector) initSock() error {
 	var err error
 	// create sock
 	p.sock, err = syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM, syscall.NETLINK_CONNECTOR)
@@ -135,7 +140,7 @@ func (p *ProcManager) initSock() error {
 	return nil
 }
 
-func (p *ProcManager) sendMsg(proto uint32) error {
+func (p *connector) sendMsg(proto uint32) error {
 	// message header
 	cnMsg := CnMsg{
 		Id: CbId{
@@ -206,26 +211,53 @@ func (p *ProcManager) loadProc() error {
 }
 
 func (p *ProcManager) listen() error {
-	buf := make([]byte, 1024)
-	// recv message from kernel
-	nLen, _, _, _, err := syscall.Recvmsg(p.sock, buf, nil, 0)
+	events, err := p.recvEvents()
 	if err != nil {
 		logger.Warningf("recv message from kernel failed, err: %v", err)
 		return err
 	}
+	for _, event := range events {
+		switch event.What {
+		// proc exec
+		case C.PROC_EVENT_EXEC:
+			msg, err := getProcMsg(event.Pid)
+			if err != nil {
+				logger.Debugf("Pid [%s] dont include exec path", event.Pid)
+				continue
+			}
+			logger.Debugf("add proc exec, Pid [%s] exe [%s]", event.Pid, msg.ExecPath)
+			p.addProc(event.Pid, msg)
+		// proc exit
+		case C.PROC_EVENT_EXIT:
+			logger.Debugf("del proc exec, Pid [%s]", event.Pid)
+			p.delProc(event.Pid)
+		}
+	}
+	return nil
+}
+
+// recv fork, exec and exit events of process from kernel
+func (p *connector) recvEvents() ([]procEvent, error) {
+	buf := make([]byte, recvBufSize)
+	// recv message from kernel
+	nLen, _, _, _, err := syscall.Recvmsg(p.sock, buf, nil, 0)
+	if err != nil {
+		return nil, err
+	}
 	logger.Debug("success recv message from kernel")
 	// check length
 	if nLen < syscall.NLMSG_HDRLEN {
 		logger.Warning("recv message length is less than hdr len")
-		return errors.New("recv message length is less than hdr len")
+		return nil, errors.New("recv message length is less than hdr len")
 	}
 	// parse netlink message
 	nlMsgSlice, err := syscall.ParseNetlinkMessage(buf[:nLen])
 	if err != nil {
 		logger.Warningf("parse netlink message failed, err: %v", err)
-		return err
+		return nil, err
 	}
 	logger.Debug("parse netlink message success")
+	var events []procEvent
 	// parse message
 	for _, nlMsg := range nlMsgSlice {
 		msg := &CnMsg{}
@@ -243,6 +275,19 @@ func (p *ProcManager) listen() error {
 			continue
 		}
 		switch header.What {
+		// proc fork
+		case C.PROC_EVENT_FORK:
+			event := &ForkProcEvent{}
+			err = binary.Read(bytBuf, binary.LittleEndian, event)
+			if err != nil {
+				logger.Warningf("binary read ForkProcEvent failed, err: %v", err)
+				continue
+			}
+			// new thread is ignored, only new proc is reported
+			if event.ChildPid == event.ChildTGid {
+				events = append(events, procEvent{What: header.What, Pid: strconv.Itoa(int(event.ChildPid)),
+					PPid: strconv.Itoa(int(event.ParentTGid))})
+			}
 		// proc exec
 		case C.PROC_EVENT_EXEC:
 			logger.Debugf("recv message is proc exec, id: %v", C.PROC_EVENT_EXEC)
@@ -254,14 +299,7 @@ func (p *ProcManager) listen() error {
 			}
 			// Pid equal Tgid means new proc is exec
 			if event.ProcPid == event.ProcTGid {
-				pid := strconv.Itoa(int(event.ProcPid))
-				msg, err := getProcMsg(pid)
-				if err != nil {
-					logger.Debugf("Pid [%s] dont include exec path", pid)
-					continue
-				}
-				logger.Debugf("add proc exec, Pid [%s] exe [%s]", pid, msg.ExecPath)
-				p.addProc(pid, msg)
+				events = append(events, procEvent{What: header.What, Pid: strconv.Itoa(int(event.ProcPid))})
 			}
 		// proc exit
 		case C.PROC_EVENT_EXIT:
@@ -276,9 +314,7 @@ func (p *ProcManager) listen() error {
 			// when exit, this is exactly right, when pthread_cancel or pthread_exit is called in main thread,
 			// this result is not correct, but seldom program in this way
 			if event.ProcessPid == event.ProcessTgid {
-				pid := strconv.Itoa(int(event.ProcessPid))
-				logger.Debugf("del proc exec, Pid [%s]", pid)
-				p.delProc(pid)
+				events = append(events, procEvent{What: header.What, Pid: strconv.Itoa(int(event.ProcessPid))})
 			}
 		case C.PROC_EVENT_COMM:
 			logger.Debugf("recv message is proc comm,id :%v", C.PROC_EVENT_COMM)
@@ -293,7 +329,7 @@ func (p *ProcManager) listen() error {
 			continue
 		}
 	}
-	return nil
+	return events, nil
 }
 
 // add proc
@@ -391,5 +427,5 @@ func CreateProcsService() error {
 }
 
 func init() {
-	logger = log.NewLogger("proxy/netlink")
+	logger = logging.New("proxy/netlink")
 }
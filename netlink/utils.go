@@ -5,43 +5,26 @@
 package Netlink
 
 import (
-	"errors"
-	"io/ioutil"
-	"os"
 	"path/filepath"
 
-	com "github.com/ArisAachen/deepin-network-proxy/com"
+	com "github.com/linuxdeepin/deepin-network-proxy/com"
 )
 
 // get proc message
 func getProcMsg(pid string) (ProcMessage, error) {
-	if !com.IsPid(pid) {
-		return ProcMessage{}, errors.New("proc pid not exist")
+	info, err := com.ReadProcInfo(ProcDir, pid, com.ProcExe|com.ProcCGroup|com.ProcStatus)
+	if err != nil {
+		// sometimes /proc/Pid/exe dont is empty link
+		logger.Debugf("[%s] read proc message failed, err: %v", pid, err)
+		return ProcMessage{}, err
 	}
-	// get read proc path
-	exePath := filepath.Join(ProcDir, pid, exe)
-	readExecPath, _ := os.Readlink(exePath)
-	// read cgroups message
-	cgPath := filepath.Join(ProcDir, pid, cgroup)
-	buf, _ := ioutil.ReadFile(cgPath)
-	cgroupPath := com.ParseCGroup2FromBuf(buf)
-	// read ppid message
-	statusPath := filepath.Join(ProcDir, pid, status)
-	buf, _ = ioutil.ReadFile(statusPath)
-	ppid := com.ParsePPidFromBuf(buf)
-
-	// sometimes /proc/Pid/exe dont is empty link
-	if readExecPath == "" {
-		logger.Debugf("[%s] dont contain exe path", exePath)
-		return ProcMessage{}, errors.New("exe path dont exist")
-	}
-	logger.Debugf("Pid [%s], exe [%s]", pid, readExecPath)
+	logger.Debugf("Pid [%s], exe [%s]", pid, info.ExecPath)
 	// proc message
 	msg := ProcMessage{
-		ExecPath:    readExecPath,
-		Cgroup2Path: cgroupPath,
+		ExecPath:    info.ExecPath,
+		Cgroup2Path: filepath.Join(cgroupPrefix, info.CGroup, cgroupProcs),
 		Pid:         pid,
-		PPid:        ppid,
+		PPid:        info.PPid,
 	}
 	return msg, nil
-}
\ No newline at end of file
+}
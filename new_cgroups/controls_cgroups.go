@@ -5,9 +5,9 @@
 package NewCGroups
 
 import (
-	"os"
 	"path/filepath"
 	"reflect"
+	"sync"
 
 	com "github.com/linuxdeepin/deepin-network-proxy/com"
 	define "github.com/linuxdeepin/deepin-network-proxy/define"
@@ -41,17 +41,10 @@ func (ctSl *ControlProcSl) Release() error {
 	return nil
 }
 
-// Attach pid to new cgroups
+// Attach pid to new cgroups, pids are attached at the same time
 func (ctSl *ControlProcSl) Attach(path string) error {
-	for _, ctrl := range *ctSl {
-		err := Attach(ctrl.Pid, path)
-		if err != nil {
-			logger.Warningf("[%s] Attach %s back to new cgroups %s failed, err: %v", ctrl.ExecPath, ctrl.Pid, path, err)
-			return err
-		}
-		logger.Debugf("[%s] Attach %s to new cgroups %s success", ctrl.ExecPath, ctrl.Pid, path)
-	}
-	return nil
+	_, err := attachAll(*ctSl, path)
+	return err
 }
 
 // check if proc already exist
@@ -90,19 +83,21 @@ type Controller struct {
 
 	// current control app message
 	CtlProcMap map[string]ControlProcSl
+
+	// match procs by spec, key is control path
+	matchers map[string]*ProcMatcher
+
+	// counters of fork tracking and audit
+	counters TrackCounters
+
+	// attach time of pids attached by daemon
+	attachTimes map[string]int64
+	timeLock    sync.Mutex
 }
 
 // add control app path
 func (c *Controller) AddCtlAppPath(path string) {
-	ifc, update, err := com.MegaAdd(c.CtlPathSl, path)
-	if err != nil || !update {
-		return
-	}
-	temp, ok := ifc.([]string)
-	if !ok {
-		return
-	}
-	c.CtlPathSl = temp
+	c.CtlPathSl, _ = com.MegaAdd(c.CtlPathSl, path)
 }
 
 // clear app ctl path
@@ -112,15 +107,7 @@ func (c *Controller) ClearCtlAppPath() {
 
 // del app path
 func (c *Controller) DelCtlAppPath(path string) {
-	ifc, update, err := com.MegaDel(c.CtlPathSl, path)
-	if err != nil || !update {
-		return
-	}
-	temp, ok := ifc.([]string)
-	if !ok {
-		return
-	}
-	c.CtlPathSl = temp
+	c.CtlPathSl, _ = com.MegaDel(c.CtlPathSl, path)
 }
 
 // check control app path exist
@@ -172,6 +159,7 @@ func (c *Controller) AddCtrlProc(proc *netlink.ProcMessage) error {
 	if err != nil {
 		return err
 	}
+	c.MarkAttached(proc.Pid)
 	// check if is nil
 	if c.CtlProcMap[proc.ExecPath] == nil {
 		c.CtlProcMap[proc.ExecPath] = []*netlink.ProcMessage{}
@@ -237,10 +225,10 @@ func (c *Controller) ReleaseAll() error {
 			return err
 		}
 	}
-	// remove dir
-	err := os.RemoveAll(c.GetCGroupPath())
+	// remove dir, pids not tracked are moved to parent
+	err := removeCGroup(c.GetCGroupPath())
 	if err != nil {
-		logger.Warning("[%s] remove cgroups path %s failed, err: %v", c.Name, c.GetCGroupPath(), err)
+		logger.Warningf("[%s] remove cgroups path %s failed, err: %v", c.Name, c.GetCGroupPath(), err)
 		return err
 	}
 
@@ -314,6 +302,9 @@ func (c *Controller) MoveIn(path string, inCtSl ControlProcSl) error {
 		if err != nil {
 			return err
 		}
+		for _, ctrl := range inCtSl {
+			c.MarkAttached(ctrl.Pid)
+		}
 		// save
 		c.CtlProcMap[path] = inCtSl
 		logger.Debugf("[%s] Attach all to new cgroups", c.Name)
@@ -322,21 +313,64 @@ func (c *Controller) MoveIn(path string, inCtSl ControlProcSl) error {
 	// change and add
 	for _, ctrl := range inCtSl {
 		// check if already exist
-		if com.MegaExist(ognCtSl, ctrl) {
+		if com.MegaIndexFunc(ognCtSl, func(elem *netlink.ProcMessage) bool { return reflect.DeepEqual(elem, ctrl) }) >= 0 {
 			logger.Debugf("[%s] proc %v already exist in cgroups", c.Name, ctrl)
 			continue
 		}
 		// if not exist, add in
-		err := c.AddCtrlProc(ctrl)
+		err := Attach(ctrl.Pid, c.GetControlPath())
 		if err != nil {
-			logger.Warningf("[%s] add %v to cgroups failed, err: %v", c.Name, err)
+			logger.Warningf("[%s] add %v to cgroups failed, err: %v", c.Name, ctrl, err)
 			return err
 		}
+		c.MarkAttached(ctrl.Pid)
+		// path may be key of matcher, not exe path of proc
+		c.CtlProcMap[path] = append(c.CtlProcMap[path], ctrl)
 	}
 	logger.Debugf("[%s] Attach all to new cgroups", c.Name)
 	return nil
 }
 
+// move procs of many paths in, pids of paths not controlled yet are attached at the same time,
+// procs attached are kept even if others failed
+func (c *Controller) MoveInAll(procsMap map[string]ControlProcSl) error {
+	var first error
+	var fresh ControlProcSl
+	for path, inCtSl := range procsMap {
+		// some procs of path may be attached already
+		if _, ok := c.CtlProcMap[path]; ok {
+			err := c.MoveIn(path, inCtSl)
+			if err != nil && first == nil {
+				first = err
+			}
+			continue
+		}
+		fresh = append(fresh, inCtSl...)
+	}
+	attached, err := attachAll(fresh, c.GetControlPath())
+	if err != nil && first == nil {
+		first = err
+	}
+	for path, inCtSl := range procsMap {
+		if _, ok := c.CtlProcMap[path]; ok {
+			continue
+		}
+		var saved ControlProcSl
+		for _, ctrl := range inCtSl {
+			if !attached[ctrl.Pid] {
+				continue
+			}
+			c.MarkAttached(ctrl.Pid)
+			saved = append(saved, ctrl)
+		}
+		if len(saved) != 0 {
+			c.CtlProcMap[path] = saved
+		}
+	}
+	logger.Debugf("[%s] Attach %d procs of %d paths to new cgroups", c.Name, len(attached), len(procsMap))
+	return first
+}
+
 // move out control procs
 func (c *Controller) MoveOut(path string) ControlProcSl {
 	// check is exist control procs
@@ -359,15 +393,8 @@ func (c *Controller) DelCtlProc(proc *netlink.ProcMessage) error {
 	}
 	procSl := c.CtlProcMap[proc.ExecPath]
 	// delete proc from self
-	ifc, update, err := com.MegaDel(procSl, proc)
-	if err != nil || update {
-		return nil
-	}
-	temp, ok := ifc.(ControlProcSl)
-	if !ok {
-		return nil
-	}
-	c.CtlProcMap[proc.ExecPath] = temp
+	procSl, _ = com.MegaDelFunc(procSl, func(elem *netlink.ProcMessage) bool { return reflect.DeepEqual(*elem, *proc) })
+	c.CtlProcMap[proc.ExecPath] = procSl
 	return nil
 }
 
@@ -378,7 +405,16 @@ func (c *Controller) GetControlPath() string {
 
 // /sys/fs/cgroup/unified/App.slice
 func (c *Controller) GetCGroupPath() string {
-	return filepath.Join(cgroup2Path, c.GetName())
+	return filepath.Join(cgroup2Path, c.GetRelPath())
+}
+
+// path relative to cgroup root, used by iptables cgroup match,
+// App.slice, or system.slice/deepin-proxy.scope/App.slice when delegated
+func (c *Controller) GetRelPath() string {
+	if c.manager == nil {
+		return c.GetName()
+	}
+	return filepath.Join(c.manager.rel, c.GetName())
 }
 
 // App.slice
@@ -10,13 +10,16 @@ import (
 
 	com "github.com/linuxdeepin/deepin-network-proxy/com"
 	define "github.com/linuxdeepin/deepin-network-proxy/define"
-	"github.com/linuxdeepin/go-lib/log"
+	logging "github.com/linuxdeepin/deepin-network-proxy/logging"
 )
 
-var logger *log.Logger
+var logger *logging.Logger
 
 type Manager struct {
 	controllers []*Controller
+
+	// path of delegated subtree relative to cgroup root, empty if cgroups are created under root
+	rel string
 }
 
 // create manager
@@ -61,6 +64,38 @@ func (m *Manager) CreatePriorityController(name define.Scope, uid int, gid int,
 	return controller, nil
 }
 
+// release procs of scope controller back and remove its cgroup
+func (m *Manager) Release(name define.Scope) error {
+	for index, controller := range m.controllers {
+		if controller.Name != name {
+			continue
+		}
+		err := controller.ReleaseAll()
+		if err != nil {
+			return err
+		}
+		m.controllers = append(m.controllers[:index], m.controllers[index+1:]...)
+		return nil
+	}
+	return nil
+}
+
+// release all controllers in reverse order of priority, main controller is the last
+func (m *Manager) ReleaseAll() error {
+	var result error
+	for index := len(m.controllers) - 1; index >= 0; index-- {
+		controller := m.controllers[index]
+		err := controller.ReleaseAll()
+		if err != nil {
+			logger.Warningf("[%s] release controller failed, err: %v", controller.Name, err)
+			result = err
+			continue
+		}
+		m.controllers = append(m.controllers[:index], m.controllers[index+1:]...)
+	}
+	return result
+}
+
 // get controller by control app path
 func (m *Manager) GetControllerByCtlPath(path string) *Controller {
 	// search app name
@@ -105,5 +140,5 @@ func (m *Manager) GetControllerCount() int {
 
 // init
 func init() {
-	logger = log.NewLogger("proxy/cgroup")
+	logger = logging.New("proxy/cgroup")
 }
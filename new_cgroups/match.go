@@ -0,0 +1,141 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package NewCGroups
+
+import (
+	"errors"
+	"os/user"
+	"regexp"
+	"strconv"
+	"strings"
+
+	com "github.com/linuxdeepin/deepin-network-proxy/com"
+	config "github.com/linuxdeepin/deepin-network-proxy/config"
+	netlink "github.com/linuxdeepin/go-dbus-factory/com.deepin.system.procs"
+)
+
+// apps run by interpreter share the same exe path, such as /usr/bin/python3,
+// matcher selects procs by cmdline, user and systemd unit or container as well.
+// procs matched are controlled under key of matcher instead of exe path.
+
+// prefix of matcher key in control paths
+const matchPrefix = "match:"
+
+// compiled match spec
+type ProcMatcher struct {
+	spec    config.MatchSpec
+	cmdline *regexp.Regexp
+	uid     string
+}
+
+// compile match spec, spec without any field is invalid
+func NewProcMatcher(spec config.MatchSpec) (*ProcMatcher, error) {
+	if spec == (config.MatchSpec{}) {
+		return nil, errors.New("match spec is empty")
+	}
+	matcher := &ProcMatcher{spec: spec}
+	if spec.Cmdline != "" {
+		reg, err := regexp.Compile(spec.Cmdline)
+		if err != nil {
+			return nil, err
+		}
+		matcher.cmdline = reg
+	}
+	if spec.User != "" {
+		uid, err := lookupUid(spec.User)
+		if err != nil {
+			return nil, err
+		}
+		matcher.uid = uid
+	}
+	return matcher, nil
+}
+
+// user name or uid to uid
+func lookupUid(name string) (string, error) {
+	if _, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return name, nil
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return "", err
+	}
+	return u.Uid, nil
+}
+
+// key used as control path
+func (m *ProcMatcher) Key() string {
+	return matchPrefix + strings.Join([]string{m.spec.Exec, m.spec.Cmdline, m.spec.User, m.spec.Unit, m.spec.App}, "|")
+}
+
+// check if proc under root matches all fields of spec
+func (m *ProcMatcher) Match(root string, proc *netlink.ProcMessage) bool {
+	if m.spec.Exec != "" && m.spec.Exec != proc.ExecPath {
+		return false
+	}
+	if m.spec.Unit != "" && !strings.Contains(proc.CGroupPath, m.spec.Unit) {
+		return false
+	}
+	if m.spec.App != "" && SandboxAppID(root, proc) != m.spec.App {
+		return false
+	}
+	if m.uid != "" {
+		info, err := com.ReadProcInfo(root, proc.Pid, com.ProcStatus)
+		if err != nil || info.Uid != m.uid {
+			return false
+		}
+	}
+	if m.cmdline != nil {
+		info, err := com.ReadProcInfo(root, proc.Pid, com.ProcCmdline)
+		if err != nil || !m.cmdline.MatchString(info.CmdlineString()) {
+			return false
+		}
+	}
+	return true
+}
+
+// add matcher, procs matched are moved in by sweep
+func (c *Controller) AddMatcher(matcher *ProcMatcher) {
+	if c.matchers == nil {
+		c.matchers = make(map[string]*ProcMatcher)
+	}
+	c.matchers[matcher.Key()] = matcher
+	c.AddCtlAppPath(matcher.Key())
+}
+
+// remove all matchers, procs matched are released
+func (c *Controller) ClearMatchers() error {
+	for key := range c.matchers {
+		err := c.ReleaseToManager(key)
+		if err != nil {
+			return err
+		}
+		delete(c.matchers, key)
+	}
+	return nil
+}
+
+// key of matcher which matches proc, empty if none
+func (c *Controller) matchKey(root string, proc *netlink.ProcMessage) string {
+	for key, matcher := range c.matchers {
+		if matcher.Match(root, proc) {
+			return key
+		}
+	}
+	return ""
+}
+
+// get controller and control path of proc, by exe path first, then matchers
+func (m *Manager) GetControllerByProc(proc *netlink.ProcMessage) (*Controller, string) {
+	if controller := m.GetControllerByCtlPath(proc.ExecPath); controller != nil {
+		return controller, proc.ExecPath
+	}
+	for _, controller := range m.controllers {
+		if key := controller.matchKey(ProcRoot, proc); key != "" {
+			return controller, key
+		}
+	}
+	return nil, ""
+}
@@ -0,0 +1,133 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package NewCGroups
+
+import (
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	com "github.com/linuxdeepin/deepin-network-proxy/com"
+)
+
+// procs in containers and app sandboxes may run in own network namespace, their traffic is not seen by rules of host OUTPUT.
+// namespace is identified by inode of /proc/pid/ns/net, and addrs are read from /proc/pid/net of one proc in it,
+// so that daemon never enters namespace.
+
+// network namespace other than the one daemon runs in
+type NetNS struct {
+	Inode uint64
+	Pid   string   // one proc in namespace
+	Addrs []net.IP // ipv4 addrs except loopback
+}
+
+// namespaces of procs in cgroup of controller
+func (c *Controller) NetNamespaces() ([]NetNS, error) {
+	pids, err := readPids(c.GetControlPath())
+	if err != nil {
+		return nil, err
+	}
+	return findNetNS(ProcRoot, pids)
+}
+
+// namespaces of all procs except procs in cgroup of exclude, exclude may be nil
+func FindNetNamespaces(exclude *Controller) ([]NetNS, error) {
+	pids, err := allPids(ProcRoot)
+	if err != nil {
+		return nil, err
+	}
+	if exclude != nil {
+		excluded, err := readPids(exclude.GetControlPath())
+		if err != nil {
+			return nil, err
+		}
+		for pid := range excluded {
+			delete(pids, pid)
+		}
+	}
+	return findNetNS(ProcRoot, pids)
+}
+
+// pids of all procs under root
+func allPids(root string) (map[string]bool, error) {
+	dirs, err := ioutil.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+	pids := make(map[string]bool)
+	for _, dir := range dirs {
+		if dir.IsDir() && com.IsPid(dir.Name()) {
+			pids[dir.Name()] = true
+		}
+	}
+	return pids, nil
+}
+
+// namespaces of pids except the one of daemon, sorted by inode
+func findNetNS(root string, pids map[string]bool) ([]NetNS, error) {
+	self, err := netnsInode(root, "self")
+	if err != nil {
+		return nil, err
+	}
+	seen := map[uint64]bool{self: true}
+	var result []NetNS
+	for pid := range pids {
+		inode, err := netnsInode(root, pid)
+		// proc may exit or belong to kernel
+		if err != nil || seen[inode] {
+			continue
+		}
+		seen[inode] = true
+		buf, err := ioutil.ReadFile(filepath.Join(root, pid, "net", "fib_trie"))
+		if err != nil {
+			logger.Debugf("read addrs of network namespace of %s failed, err: %v", pid, err)
+			continue
+		}
+		result = append(result, NetNS{Inode: inode, Pid: pid, Addrs: parseFibTrie(string(buf))})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Inode < result[j].Inode
+	})
+	return result, nil
+}
+
+// inode of network namespace of proc
+func netnsInode(root string, pid string) (uint64, error) {
+	var stat syscall.Stat_t
+	err := syscall.Stat(filepath.Join(root, pid, "ns", "net"), &stat)
+	if err != nil {
+		return 0, err
+	}
+	return stat.Ino, nil
+}
+
+// local addrs of fib_trie, addr line is followed by its routes, local table may be listed twice
+//
+//	|-- 172.17.0.2
+//	   /32 host LOCAL
+func parseFibTrie(buf string) []net.IP {
+	var ips []net.IP
+	seen := make(map[string]bool)
+	var last net.IP
+	for _, line := range strings.Split(buf, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "|--" {
+			last = net.ParseIP(fields[1])
+			continue
+		}
+		if len(fields) != 3 || fields[0] != "/32" || fields[1] != "host" || fields[2] != "LOCAL" {
+			continue
+		}
+		if last == nil || last.IsLoopback() || seen[last.String()] {
+			continue
+		}
+		seen[last.String()] = true
+		ips = append(ips, last)
+	}
+	return ips
+}
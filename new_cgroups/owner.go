@@ -0,0 +1,82 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package NewCGroups
+
+import (
+	"errors"
+
+	com "github.com/linuxdeepin/deepin-network-proxy/com"
+	netlink "github.com/linuxdeepin/go-dbus-factory/com.deepin.system.procs"
+)
+
+// connection accepted by proxy is traced back to proc holds the socket,
+// so that apps of one scope can use different proxies.
+
+// proc holds socket
+type SocketOwner struct {
+	Pid      string
+	ExecPath string
+	AppID    string // flatpak app id or snap name, empty if not sandboxed
+}
+
+// find proc in cgroup of controller which holds socket of inode,
+// proc of hint is checked first, one app usually makes many connections
+func (c *Controller) FindSocketOwner(root string, inode uint32, hint string) (*SocketOwner, error) {
+	pids, err := readPids(c.GetControlPath())
+	if err != nil {
+		return nil, err
+	}
+	owner := findSocketPid(root, pids, inode, hint)
+	if owner == "" {
+		return nil, errors.New("owner of socket not found in cgroup")
+	}
+	// cgroup of proc attached by daemon is scope cgroup, origin one is needed by snap name
+	proc := c.CheckCtrlPid(owner)
+	if proc == nil {
+		proc, err = readProc(root, owner)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return socketOwner(root, proc), nil
+}
+
+// find proc in all procs which holds socket of inode, procs of global scope are not in its cgroup
+func FindSocketOwner(root string, inode uint32, hint string) (*SocketOwner, error) {
+	pids, err := allPids(root)
+	if err != nil {
+		return nil, err
+	}
+	owner := findSocketPid(root, pids, inode, hint)
+	if owner == "" {
+		return nil, errors.New("owner of socket not found")
+	}
+	proc, err := readProc(root, owner)
+	if err != nil {
+		return nil, err
+	}
+	return socketOwner(root, proc), nil
+}
+
+// pid holds socket of inode, empty if not found
+func findSocketPid(root string, pids map[string]bool, inode uint32, hint string) string {
+	if hint != "" && pids[hint] && com.ProcHasSocket(root, hint, inode) {
+		return hint
+	}
+	for pid := range pids {
+		if pid != hint && com.ProcHasSocket(root, pid, inode) {
+			return pid
+		}
+	}
+	return ""
+}
+
+func socketOwner(root string, proc *netlink.ProcMessage) *SocketOwner {
+	return &SocketOwner{
+		Pid:      proc.Pid,
+		ExecPath: proc.ExecPath,
+		AppID:    SandboxAppID(root, proc),
+	}
+}
@@ -0,0 +1,103 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package NewCGroups
+
+import (
+	"errors"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	com "github.com/linuxdeepin/deepin-network-proxy/com"
+)
+
+// clock ticks per second of /proc/pid/stat, USER_HZ is 100 on linux
+const userHZ = 100
+
+// proc in cgroup of controller
+type ProxiedProc struct {
+	ExecPath   string
+	Pid        uint32
+	StartTime  int64 // unix seconds
+	AttachTime int64 // unix seconds, 0 if not attached by daemon
+}
+
+// record attach time of pid
+func (c *Controller) MarkAttached(pid string) {
+	c.timeLock.Lock()
+	defer c.timeLock.Unlock()
+	if c.attachTimes == nil {
+		c.attachTimes = make(map[string]int64)
+	}
+	c.attachTimes[pid] = time.Now().Unix()
+}
+
+// list procs currently in cgroup of controller, sorted by pid
+func (c *Controller) ListProcs() ([]ProxiedProc, error) {
+	return c.listProcs(ProcRoot, c.GetControlPath())
+}
+
+func (c *Controller) listProcs(root string, path string) ([]ProxiedProc, error) {
+	pids, err := readPids(path)
+	if err != nil {
+		logger.Warningf("[%s] read cgroups %s failed, err: %v", c.Name, path, err)
+		return nil, err
+	}
+	bootTime, err := readBootTime(root)
+	if err != nil {
+		logger.Warningf("read boot time failed, err: %v", err)
+		return nil, err
+	}
+	c.timeLock.Lock()
+	defer c.timeLock.Unlock()
+	// forget exited procs
+	for pid := range c.attachTimes {
+		if !pids[pid] {
+			delete(c.attachTimes, pid)
+		}
+	}
+	procs := []ProxiedProc{}
+	for pid := range pids {
+		num, err := strconv.ParseUint(pid, 10, 32)
+		if err != nil {
+			continue
+		}
+		info, err := com.ReadProcInfo(root, pid, com.ProcExe)
+		if err != nil {
+			// proc may exit or belong to kernel
+			continue
+		}
+		proc := ProxiedProc{
+			ExecPath:   info.ExecPath,
+			Pid:        uint32(num),
+			AttachTime: c.attachTimes[pid],
+		}
+		stat, err := com.ReadProcInfo(root, pid, com.ProcStat)
+		if err == nil {
+			proc.StartTime = bootTime + int64(stat.StartTicks)/userHZ
+		}
+		procs = append(procs, proc)
+	}
+	sort.Slice(procs, func(i, j int) bool {
+		return procs[i].Pid < procs[j].Pid
+	})
+	return procs, nil
+}
+
+// boot time in unix seconds,   btime 1650000000
+func readBootTime(root string) (int64, error) {
+	lines, err := readLines(filepath.Join(root, "stat"))
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range lines {
+		if strings.HasPrefix(line, "btime ") {
+			return strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(line, "btime ")), 10, 64)
+		}
+	}
+	return 0, errors.New("btime not found")
+}
@@ -0,0 +1,63 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package NewCGroups
+
+import (
+	"path/filepath"
+	"strings"
+
+	netlink "github.com/linuxdeepin/go-dbus-factory/com.deepin.system.procs"
+)
+
+// flatpak app runs in bwrap, exe path is inside /app and never matches host path,
+// snap app exe is under /snap, both are selected by app id instead
+
+// info file in root of flatpak sandbox
+const flatpakInfo = ".flatpak-info"
+
+// flatpak app id or snap name of proc, empty if proc is not sandboxed
+func SandboxAppID(root string, proc *netlink.ProcMessage) string {
+	if id := flatpakAppID(root, proc.Pid); id != "" {
+		return id
+	}
+	return snapName(proc)
+}
+
+// read app id from .flatpak-info, name in Application section
+func flatpakAppID(root string, pid string) string {
+	lines, err := readLines(filepath.Join(root, pid, "root", flatpakInfo))
+	if err != nil {
+		return ""
+	}
+	var section string
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.Trim(line, "[]")
+			continue
+		}
+		if section == "Application" && strings.HasPrefix(line, "name=") {
+			return strings.TrimPrefix(line, "name=")
+		}
+	}
+	return ""
+}
+
+// snap name from cgroup like snap.firefox.firefox-1234.scope, or exe like /snap/firefox/123/usr/lib/firefox
+func snapName(proc *netlink.ProcMessage) string {
+	for _, elem := range strings.Split(proc.CGroupPath, "/") {
+		fields := strings.Split(elem, ".")
+		if len(fields) >= 3 && fields[0] == "snap" {
+			return fields[1]
+		}
+	}
+	if strings.HasPrefix(proc.ExecPath, "/snap/") {
+		fields := strings.Split(strings.TrimPrefix(proc.ExecPath, "/snap/"), "/")
+		if fields[0] != "" && fields[0] != "bin" {
+			return fields[0]
+		}
+	}
+	return ""
+}
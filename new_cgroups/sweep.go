@@ -0,0 +1,121 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package NewCGroups
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	com "github.com/linuxdeepin/deepin-network-proxy/com"
+	netlink "github.com/linuxdeepin/go-dbus-factory/com.deepin.system.procs"
+)
+
+// procs started before app is added to control path are not reported by exec event,
+// they are found by scanning /proc and moved in
+
+// proc file system root
+const ProcRoot = "/proc"
+
+// scan procs whose exe is in paths, all procs if paths is empty, return procs by exe path
+func ScanProcs(root string, paths []string) (map[string]ControlProcSl, error) {
+	dirs, err := ioutil.ReadDir(root)
+	if err != nil {
+		logger.Warningf("read proc dir %s failed, err: %v", root, err)
+		return nil, err
+	}
+	procsMap := make(map[string]ControlProcSl)
+	for _, dir := range dirs {
+		pid := dir.Name()
+		if !dir.IsDir() || !com.IsPid(pid) {
+			continue
+		}
+		proc, err := readProc(root, pid)
+		if err != nil {
+			// proc may exit or belong to kernel
+			continue
+		}
+		if len(paths) != 0 && !com.MegaExist(paths, proc.ExecPath) {
+			continue
+		}
+		procsMap[proc.ExecPath] = append(procsMap[proc.ExecPath], proc)
+	}
+	return procsMap, nil
+}
+
+// read exe, cgroup and parent of proc
+func readProc(root string, pid string) (*netlink.ProcMessage, error) {
+	info, err := com.ReadProcInfo(root, pid, com.ProcExe|com.ProcCGroup|com.ProcStatus)
+	if err != nil {
+		return nil, err
+	}
+	proc := &netlink.ProcMessage{
+		ExecPath: info.ExecPath,
+		Pid:      pid,
+		PPid:     info.PPid,
+	}
+	if info.CGroup != "" {
+		proc.CGroupPath = filepath.Join(cgroup2Path, info.CGroup, procsPath)
+	}
+	return proc, nil
+}
+
+// read file by lines
+func readLines(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+// move running procs of control paths and matchers in, procs already in any controller are skipped
+func (c *Controller) Sweep() error {
+	if len(c.CtlPathSl) == 0 {
+		return nil
+	}
+	// matcher may match any exe
+	var paths []string
+	if len(c.matchers) == 0 {
+		paths = c.CtlPathSl
+	}
+	procsMap, err := ScanProcs(ProcRoot, paths)
+	if err != nil {
+		return err
+	}
+	// procs by control path
+	ctlMap := make(map[string]ControlProcSl)
+	for exe, procSl := range procsMap {
+		for _, proc := range procSl {
+			if c.manager != nil && c.manager.GetControllerByCtrlByPPid(proc.Pid) != nil {
+				continue
+			}
+			key := exe
+			if !c.CheckCtlPathSl(exe) {
+				key = c.matchKey(ProcRoot, proc)
+			}
+			if key == "" {
+				continue
+			}
+			ctlMap[key] = append(ctlMap[key], proc)
+		}
+	}
+	for path, inSl := range ctlMap {
+		err = c.MoveIn(path, inSl)
+		if err != nil {
+			logger.Warningf("[%s] sweep procs %s failed, err: %v", c.Name, path, err)
+			continue
+		}
+		logger.Debugf("[%s] sweep procs %s success, count: %d", c.Name, path, len(inSl))
+	}
+	return nil
+}
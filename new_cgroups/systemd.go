@@ -0,0 +1,101 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package NewCGroups
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/godbus/dbus"
+	systemd1 "github.com/linuxdeepin/go-dbus-factory/org.freedesktop.systemd1"
+)
+
+// systemd owns cgroup hierarchy, cgroups created directly under root may be removed
+// or procs in them moved back when systemd reloads units.
+// in delegation mode, systemd creates a transient scope with Delegate=yes for daemon,
+// controllers are created in this subtree, which systemd will not touch.
+
+// transient scope requested from systemd
+const DelegateUnit = "deepin-proxy.scope"
+
+// daemon itself is moved to leaf, cgroup with children should not have procs
+const supervisorName = "supervisor"
+
+// wait for systemd to move daemon into scope
+const (
+	delegateWaitAttempts = 10
+	delegateWaitDelay    = 100 * time.Millisecond
+)
+
+// request delegated subtree from systemd, controllers created later are put in it
+func (m *Manager) Delegate(conn *dbus.Conn) error {
+	if len(m.controllers) != 0 {
+		return errors.New("delegate should be requested before controllers created")
+	}
+	pid := uint32(os.Getpid())
+	properties := []systemd1.Property{
+		{Name: "Description", Value: dbus.MakeVariant("deepin network proxy cgroups")},
+		{Name: "PIDs", Value: dbus.MakeVariant([]uint32{pid})},
+		{Name: "Delegate", Value: dbus.MakeVariant(true)},
+	}
+	_, err := systemd1.NewManager(conn).StartTransientUnit(0, DelegateUnit, "fail", properties, nil)
+	if err != nil {
+		// scope left by last run is reused if daemon is already in it
+		logger.Debugf("start transient unit %s failed, err: %v", DelegateUnit, err)
+	}
+	var rel string
+	for attempt := 0; attempt < delegateWaitAttempts; attempt++ {
+		rel, err = selfCGroup(ProcRoot)
+		if err == nil && filepath.Base(rel) == DelegateUnit {
+			break
+		}
+		time.Sleep(delegateWaitDelay)
+	}
+	if err != nil {
+		return err
+	}
+	if filepath.Base(rel) != DelegateUnit {
+		return errors.New("daemon is not moved to delegated scope")
+	}
+	// move daemon to leaf
+	leaf := filepath.Join(cgroup2Path, rel, supervisorName)
+	err = os.MkdirAll(leaf, 0755)
+	if err != nil {
+		return err
+	}
+	err = Attach(strconv.Itoa(int(pid)), filepath.Join(leaf, procsPath))
+	if err != nil {
+		return err
+	}
+	m.rel = rel
+	logger.Infof("cgroups are delegated by systemd, path: %s", m.rel)
+	return nil
+}
+
+// cgroup2 path of daemon relative to cgroup root,   0::/system.slice/deepin-proxy.scope
+func selfCGroup(root string) (string, error) {
+	lines, err := readLines(filepath.Join(root, "self", "cgroup"))
+	if err != nil {
+		return "", err
+	}
+	for _, line := range lines {
+		if strings.HasPrefix(line, "0::") {
+			rel := strings.TrimPrefix(line, "0::")
+			// daemon may be moved to leaf already
+			rel = strings.TrimSuffix(rel, "/"+supervisorName)
+			return strings.TrimPrefix(rel, "/"), nil
+		}
+	}
+	return "", errors.New("cgroup2 path not found")
+}
+
+// check if cgroups are delegated by systemd
+func (m *Manager) Delegated() bool {
+	return m.rel != ""
+}
@@ -0,0 +1,59 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package NewCGroups
+
+// target exe is added or removed when proxy is running, running procs are moved at once
+
+// add target exe, procs are moved from controller of lower priority or from origin cgroup,
+// return count of procs controlled
+func (c *Controller) AddTgtExe(path string) (int, error) {
+	if c.CheckCtlPathSl(path) {
+		return len(c.CtlProcMap[path]), nil
+	}
+	// controlled by other controller
+	if c.manager != nil && c.manager.GetControllerByCtlPath(path) != nil {
+		err := c.UpdateFromManager(path)
+		c.AddCtlAppPath(path)
+		return len(c.CtlProcMap[path]), err
+	}
+	c.AddCtlAppPath(path)
+	procsMap, err := ScanProcs(ProcRoot, []string{path})
+	if err != nil {
+		return 0, err
+	}
+	var inSl ControlProcSl
+	for _, proc := range procsMap[path] {
+		// child of other controlled app
+		if c.manager != nil && c.manager.GetControllerByCtrlByPPid(proc.Pid) != nil {
+			continue
+		}
+		inSl = append(inSl, proc)
+	}
+	if len(inSl) == 0 {
+		return 0, nil
+	}
+	err = c.MoveIn(path, inSl)
+	if err != nil {
+		logger.Warningf("[%s] add procs of %s failed, err: %v", c.Name, path, err)
+		return len(c.CtlProcMap[path]), err
+	}
+	logger.Debugf("[%s] add procs of %s success, count: %d", c.Name, path, len(inSl))
+	return len(c.CtlProcMap[path]), nil
+}
+
+// remove target exe, procs are moved to controller of lower priority or back to origin cgroup,
+// return count of procs released
+func (c *Controller) RemoveTgtExe(path string) (int, error) {
+	if !c.CheckCtlPathSl(path) {
+		return 0, nil
+	}
+	count := len(c.CtlProcMap[path])
+	err := c.ReleaseToManager(path)
+	if err != nil {
+		logger.Warningf("[%s] remove procs of %s failed, err: %v", c.Name, path, err)
+		return 0, err
+	}
+	return count, nil
+}
@@ -0,0 +1,185 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package NewCGroups
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	netlink "github.com/linuxdeepin/go-dbus-factory/com.deepin.system.procs"
+)
+
+// children forked by controlled proc inherit cgroup of parent, but may escape later,
+// such as sandbox helpers of browser moved to new scope by systemd.
+// forked children are tracked, and audit re-attaches escaped descendants.
+
+// counters of proc tracking, for debugging
+type TrackCounters struct {
+	Forks      uint64 // forked children tracked
+	Reattached uint64 // escaped procs attached back
+	Exited     uint64 // exited procs dropped by audit
+	Audits     uint64 // audit runs
+}
+
+// copy of counters
+func (c *Controller) Counters() TrackCounters {
+	return TrackCounters{
+		Forks:      atomic.LoadUint64(&c.counters.Forks),
+		Reattached: atomic.LoadUint64(&c.counters.Reattached),
+		Exited:     atomic.LoadUint64(&c.counters.Exited),
+		Audits:     atomic.LoadUint64(&c.counters.Audits),
+	}
+}
+
+// control path which pid is controlled under
+func (c *Controller) procKey(pid string) (string, *netlink.ProcMessage) {
+	for path, procSl := range c.CtlProcMap {
+		if proc := procSl.CheckCtrlPidExist(pid); proc != nil {
+			return path, proc
+		}
+	}
+	return "", nil
+}
+
+// track child forked by controlled parent, return false if parent is not controlled
+func (c *Controller) TrackFork(ppid string, pid string) bool {
+	return c.trackFork(c.GetControlPath(), ppid, pid)
+}
+
+func (c *Controller) trackFork(path string, ppid string, pid string) bool {
+	key, parent := c.procKey(ppid)
+	if parent == nil {
+		return false
+	}
+	if child := c.CheckCtrlPid(pid); child != nil {
+		return true
+	}
+	c.adopt(key, parent, pid)
+	atomic.AddUint64(&c.counters.Forks, 1)
+	// child may be moved out before event arrives
+	exist, err := hasPid(pid, path)
+	if err != nil || exist {
+		return true
+	}
+	if Attach(pid, path) == nil {
+		atomic.AddUint64(&c.counters.Reattached, 1)
+		logger.Debugf("[%s] forked proc %s escaped, attach back", c.Name, pid)
+	}
+	return true
+}
+
+// control child under key of parent, child shares exe and origin cgroup of parent
+func (c *Controller) adopt(key string, parent *netlink.ProcMessage, pid string) {
+	child := &netlink.ProcMessage{
+		ExecPath:   parent.ExecPath,
+		CGroupPath: parent.CGroupPath,
+		Pid:        pid,
+		PPid:       parent.Pid,
+	}
+	c.CtlProcMap[key] = append(c.CtlProcMap[key], child)
+	c.MarkAttached(pid)
+}
+
+// check controlled procs and their descendants against cgroup, return count of procs attached back
+func (c *Controller) Audit() (int, error) {
+	return c.audit(ProcRoot, c.GetControlPath())
+}
+
+func (c *Controller) audit(root string, path string) (int, error) {
+	atomic.AddUint64(&c.counters.Audits, 1)
+	if len(c.CtlProcMap) == 0 {
+		return 0, nil
+	}
+	inCGroup, err := readPids(path)
+	if err != nil {
+		logger.Warningf("[%s] read cgroups %s failed, err: %v", c.Name, path, err)
+		return 0, err
+	}
+	var count int
+	// drop exited procs, attach escaped procs back
+	for key, procSl := range c.CtlProcMap {
+		var alive ControlProcSl
+		for _, proc := range procSl {
+			if _, err := os.Stat(filepath.Join(root, proc.Pid)); os.IsNotExist(err) {
+				atomic.AddUint64(&c.counters.Exited, 1)
+				continue
+			}
+			alive = append(alive, proc)
+			if inCGroup[proc.Pid] {
+				continue
+			}
+			if Attach(proc.Pid, path) == nil {
+				inCGroup[proc.Pid] = true
+				c.MarkAttached(proc.Pid)
+				count++
+			}
+		}
+		c.CtlProcMap[key] = alive
+	}
+	// descendants missed by fork event, such as events lost or dbus source
+	procsMap, err := ScanProcs(root, nil)
+	if err != nil {
+		return count, err
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, procSl := range procsMap {
+			for _, proc := range procSl {
+				if c.CheckCtrlPid(proc.Pid) != nil {
+					continue
+				}
+				key, parent := c.procKey(proc.PPid)
+				if parent == nil {
+					continue
+				}
+				c.adopt(key, parent, proc.Pid)
+				changed = true
+				if inCGroup[proc.Pid] {
+					continue
+				}
+				if Attach(proc.Pid, path) == nil {
+					inCGroup[proc.Pid] = true
+					count++
+				}
+			}
+		}
+	}
+	atomic.AddUint64(&c.counters.Reattached, uint64(count))
+	if count != 0 {
+		logger.Infof("[%s] audit attach escaped procs back, count: %d", c.Name, count)
+	}
+	return count, nil
+}
+
+// pids in cgroup.procs
+func readPids(path string) (map[string]bool, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pids := make(map[string]bool)
+	for _, line := range strings.Split(string(buf), "\n") {
+		if pid := strings.TrimSpace(line); pid != "" {
+			pids[pid] = true
+		}
+	}
+	return pids, nil
+}
+
+// audit all controllers, return count of procs attached back
+func (m *Manager) Audit() int {
+	var count int
+	for _, controller := range m.controllers {
+		num, err := controller.Audit()
+		if err != nil {
+			continue
+		}
+		count += num
+	}
+	return count
+}
@@ -6,26 +6,194 @@ package NewCGroups
 
 import (
 	"errors"
-	"os/exec"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	com "github.com/linuxdeepin/deepin-network-proxy/com"
 )
 
+// cgroup may be busy when migrating, or not ready when just created
+const (
+	attachRetryAttempts = 3
+	attachRetryDelay    = 100 * time.Millisecond
+)
+
+// cgroup is busy until exited procs are reaped
+const (
+	rmdirRetryAttempts = 5
+	rmdirRetryDelay    = 100 * time.Millisecond
+)
+
+// pids attached at the same time, attach of one pid may wait for busy cgroup and read procs back
+const attachWorkers = 8
+
+// count of pids failed to attach
+var attachFailures uint64
+
+// magic of cgroup2 fs, see statfs(2)
+const cgroup2Magic = 0x63677270
+
+// check if cgroup2 is mounted at main path
+func CheckMount() error {
+	var st syscall.Statfs_t
+	err := syscall.Statfs(cgroup2Path, &st)
+	if err != nil {
+		return err
+	}
+	if st.Type != cgroup2Magic {
+		return fmt.Errorf("%s is not cgroup2, fs type is 0x%x", cgroup2Path, st.Type)
+	}
+	return nil
+}
+
+// count of pids failed to attach since daemon started
+func AttachFailures() uint64 {
+	return atomic.LoadUint64(&attachFailures)
+}
+
 // Attach pid to cgroups path
 func Attach(pid string, path string) error {
 	if !com.IsPid(pid) {
 		return errors.New("pid is not num")
 	}
-	args := []string{"echo", pid, ">", path}
-	// echo 12345 > /sys/fs/cgroup/unified/App.slice/cgroup.procs
-	cmd := exec.Command("/bin/sh", "-c", strings.Join(args, " "))
-	logger.Debugf("echo pid %s run command %v", pid, cmd)
-	buf, err := cmd.CombinedOutput()
+	// 12345 > /sys/fs/cgroup/unified/App.slice/cgroup.procs
+	var err error
+	for attempt := 1; attempt <= attachRetryAttempts; attempt++ {
+		err = writePid(pid, path)
+		if err == nil || !errors.Is(err, syscall.EBUSY) && !errors.Is(err, syscall.ENOENT) {
+			break
+		}
+		logger.Debugf("write pid %s to cgroups %s failed, retry after %v, attempt: %d/%d, err: %v",
+			pid, path, attachRetryDelay, attempt, attachRetryAttempts, err)
+		time.Sleep(attachRetryDelay)
+	}
+	if err != nil {
+		atomic.AddUint64(&attachFailures, 1)
+		logger.Warningf("write pid %s to cgroups %s failed, err: %v", pid, path, err)
+		return err
+	}
+	// write may succeed but pid is not moved, such as process exit
+	exist, err := hasPid(pid, path)
 	if err != nil {
-		logger.Warningf("echo pid %s to cgroups %s failed, out: %s,err: %v", pid, path, string(buf), err)
+		logger.Warningf("read cgroups %s failed, err: %v", path, err)
 		return err
 	}
-	logger.Debugf("echo pid %s to cgroups %s success", pid, path)
+	if !exist {
+		atomic.AddUint64(&attachFailures, 1)
+		logger.Warningf("pid %s not in cgroups %s after attach", pid, path)
+		return fmt.Errorf("pid %s not in cgroups %s after attach", pid, path)
+	}
+	logger.Debugf("write pid %s to cgroups %s success", pid, path)
 	return nil
 }
+
+// attach procs to cgroups path by workers, pids attached are returned with first error in order of procs
+func attachAll(procs ControlProcSl, path string) (map[string]bool, error) {
+	errs := make([]error, len(procs))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	workers := attachWorkers
+	if len(procs) < workers {
+		workers = len(procs)
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range jobs {
+				ctrl := procs[index]
+				errs[index] = Attach(ctrl.Pid, path)
+				if errs[index] != nil {
+					logger.Warningf("[%s] Attach %s to new cgroups %s failed, err: %v", ctrl.ExecPath, ctrl.Pid, path, errs[index])
+					continue
+				}
+				logger.Debugf("[%s] Attach %s to new cgroups %s success", ctrl.ExecPath, ctrl.Pid, path)
+			}
+		}()
+	}
+	for index := range procs {
+		jobs <- index
+	}
+	close(jobs)
+	wg.Wait()
+	attached := make(map[string]bool)
+	var first error
+	for index, err := range errs {
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+		attached[procs[index].Pid] = true
+	}
+	return attached, first
+}
+
+// write pid to cgroup.procs
+func writePid(pid string, path string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write([]byte(pid + "\n"))
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// check if pid is in cgroup.procs
+func hasPid(pid string, path string) (bool, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	for _, line := range strings.Split(string(buf), "\n") {
+		if strings.TrimSpace(line) == pid {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// remove cgroup dir, remaining pids are moved to parent cgroup first
+func removeCGroup(dir string) error {
+	path := filepath.Join(dir, procsPath)
+	pids, err := readPids(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	parent := filepath.Join(filepath.Dir(dir), procsPath)
+	for pid := range pids {
+		err = Attach(pid, parent)
+		if err != nil {
+			// proc may exit
+			logger.Debugf("move remaining pid %s to parent cgroups failed, err: %v", pid, err)
+		}
+	}
+	// cgroup dir can only be removed by rmdir, files in it are not real
+	for attempt := 1; attempt <= rmdirRetryAttempts; attempt++ {
+		err = syscall.Rmdir(dir)
+		if err == nil || errors.Is(err, syscall.ENOENT) {
+			logger.Debugf("remove cgroups %s success", dir)
+			return nil
+		}
+		if !errors.Is(err, syscall.EBUSY) {
+			break
+		}
+		time.Sleep(rmdirRetryDelay)
+	}
+	return err
+}
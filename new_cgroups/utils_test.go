@@ -0,0 +1,437 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package NewCGroups
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	config "github.com/linuxdeepin/deepin-network-proxy/config"
+	define "github.com/linuxdeepin/deepin-network-proxy/define"
+	netlink "github.com/linuxdeepin/go-dbus-factory/com.deepin.system.procs"
+)
+
+// fake cgroup tree in temp dir, cgroup.procs is a plain file
+func fakeCGroup(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	slice := filepath.Join(dir, "App.slice")
+	err = os.MkdirAll(slice, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(slice, procsPath)
+	err = ioutil.WriteFile(path, []byte("100\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestAttach(t *testing.T) {
+	path := fakeCGroup(t)
+	err := Attach("12345", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, _ := ioutil.ReadFile(path)
+	if string(buf) != "100\n12345\n" {
+		t.Errorf("pid is not written, content: %q", string(buf))
+	}
+	if exist, _ := hasPid("100", path); !exist {
+		t.Errorf("origin pid should exist")
+	}
+	if err := Attach("abc", path); err == nil {
+		t.Errorf("invalid pid should fail")
+	}
+	// cgroup not exist, retry and fail
+	if err := Attach("12345", filepath.Join(filepath.Dir(path), "none", procsPath)); err == nil {
+		t.Errorf("attach to not exist cgroup should fail")
+	}
+}
+
+func TestAttachAll(t *testing.T) {
+	path := fakeCGroup(t)
+	var procs ControlProcSl
+	for pid := 200; pid < 220; pid++ {
+		procs = append(procs, &netlink.ProcMessage{Pid: strconv.Itoa(pid)})
+	}
+	procs = append(procs, &netlink.ProcMessage{Pid: "abc"})
+	attached, err := attachAll(procs, path)
+	if err == nil {
+		t.Error("invalid pid should fail")
+	}
+	if len(attached) != 20 || attached["abc"] {
+		t.Fatalf("valid pids should be attached, got %v", attached)
+	}
+	for pid := 200; pid < 220; pid++ {
+		if exist, _ := hasPid(strconv.Itoa(pid), path); !exist {
+			t.Errorf("pid %d is not written", pid)
+		}
+	}
+}
+
+func TestScanProcs(t *testing.T) {
+	root, err := ioutil.TempDir("", "proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	// fake proc entries
+	procs := map[string]string{"100": "/usr/bin/foo", "200": "/usr/bin/bar", "300": "/usr/bin/foo (deleted)"}
+	for pid, exe := range procs {
+		dir := filepath.Join(root, pid)
+		_ = os.MkdirAll(dir, 0755)
+		_ = os.Symlink(exe, filepath.Join(dir, "exe"))
+		_ = ioutil.WriteFile(filepath.Join(dir, "cgroup"), []byte("1:name=systemd:/\n0::/user.slice\n"), 0644)
+		_ = ioutil.WriteFile(filepath.Join(dir, "status"), []byte("Name:\tfoo\nPPid:\t1\n"), 0644)
+	}
+	_ = os.MkdirAll(filepath.Join(root, "self"), 0755)
+	procsMap, err := ScanProcs(root, []string{"/usr/bin/foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	procSl := procsMap["/usr/bin/foo"]
+	if len(procsMap) != 1 || len(procSl) != 2 {
+		t.Fatalf("scan procs failed, procs: %v", procsMap)
+	}
+	if procSl[0].PPid != "1" || procSl[0].CGroupPath != filepath.Join(cgroup2Path, "user.slice", procsPath) {
+		t.Errorf("parse proc failed, proc: %v", procSl[0])
+	}
+	all, _ := ScanProcs(root, nil)
+	if len(all) != 2 {
+		t.Errorf("scan all procs failed, procs: %v", all)
+	}
+}
+
+func TestProcMatcher(t *testing.T) {
+	root, err := ioutil.TempDir("", "proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	dir := filepath.Join(root, "100")
+	_ = os.MkdirAll(dir, 0755)
+	_ = ioutil.WriteFile(filepath.Join(dir, "cmdline"), []byte("python3\x00/usr/share/foo/main.py\x00"), 0644)
+	_ = ioutil.WriteFile(filepath.Join(dir, "status"), []byte("Name:\tpython3\nUid:\t1000\t1000\t1000\t1000\n"), 0644)
+	proc := &netlink.ProcMessage{
+		ExecPath:   "/usr/bin/python3",
+		CGroupPath: "/sys/fs/cgroup/unified/user.slice/user-1000.slice/app-foo.service/cgroup.procs",
+		Pid:        "100",
+	}
+	cases := []struct {
+		spec   config.MatchSpec
+		expect bool
+	}{
+		{config.MatchSpec{Exec: "/usr/bin/python3", Cmdline: "foo/main\\.py"}, true},
+		{config.MatchSpec{Cmdline: "bar"}, false},
+		{config.MatchSpec{User: "1000", Unit: "app-foo.service"}, true},
+		{config.MatchSpec{User: "0"}, false},
+		{config.MatchSpec{Unit: "docker-"}, false},
+	}
+	for _, c := range cases {
+		matcher, err := NewProcMatcher(c.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if matcher.Match(root, proc) != c.expect {
+			t.Errorf("match spec %v should be %v", c.spec, c.expect)
+		}
+	}
+	if _, err := NewProcMatcher(config.MatchSpec{}); err == nil {
+		t.Errorf("empty spec should be invalid")
+	}
+}
+
+func TestSandboxAppID(t *testing.T) {
+	root, err := ioutil.TempDir("", "proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	// flatpak sandbox root
+	dir := filepath.Join(root, "100", "root")
+	_ = os.MkdirAll(dir, 0755)
+	_ = ioutil.WriteFile(filepath.Join(dir, flatpakInfo), []byte("[Application]\nname=org.mozilla.firefox\nruntime=runtime/org.freedesktop.Platform\n"), 0644)
+	flatpak := &netlink.ProcMessage{ExecPath: "/app/lib/firefox/firefox", Pid: "100"}
+	if id := SandboxAppID(root, flatpak); id != "org.mozilla.firefox" {
+		t.Errorf("flatpak app id wrong, id: %s", id)
+	}
+	snap := &netlink.ProcMessage{
+		ExecPath:   "/snap/firefox/123/usr/lib/firefox/firefox",
+		CGroupPath: "/sys/fs/cgroup/unified/user.slice/snap.firefox.firefox-1234.scope/cgroup.procs",
+		Pid:        "200",
+	}
+	if id := SandboxAppID(root, snap); id != "firefox" {
+		t.Errorf("snap name wrong, name: %s", id)
+	}
+	snap.CGroupPath = ""
+	if id := SandboxAppID(root, snap); id != "firefox" {
+		t.Errorf("snap name from exe wrong, name: %s", id)
+	}
+	host := &netlink.ProcMessage{ExecPath: "/usr/bin/firefox", Pid: "300"}
+	if id := SandboxAppID(root, host); id != "" {
+		t.Errorf("host proc should have no app id, id: %s", id)
+	}
+}
+
+func TestAudit(t *testing.T) {
+	path := fakeCGroup(t)
+	root, err := ioutil.TempDir("", "proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	// 100 is controlled, 101 and 102 are descendants escaped, 200 is not related
+	parents := map[string]string{"100": "1", "101": "100", "102": "101", "200": "1"}
+	for pid, ppid := range parents {
+		dir := filepath.Join(root, pid)
+		_ = os.MkdirAll(dir, 0755)
+		_ = os.Symlink("/usr/bin/foo", filepath.Join(dir, "exe"))
+		_ = ioutil.WriteFile(filepath.Join(dir, "cgroup"), []byte("0::/user.slice\n"), 0644)
+		_ = ioutil.WriteFile(filepath.Join(dir, "status"), []byte("PPid:\t"+ppid+"\n"), 0644)
+	}
+	controller := &Controller{
+		Name: "app",
+		CtlProcMap: map[string]ControlProcSl{
+			"/usr/bin/foo": {
+				{ExecPath: "/usr/bin/foo", Pid: "100", PPid: "1"},
+				{ExecPath: "/usr/bin/foo", Pid: "999", PPid: "1"},
+			},
+		},
+	}
+	count, err := controller.audit(root, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("audit should attach 2 procs back, count: %d", count)
+	}
+	pids, _ := readPids(path)
+	if !pids["101"] || !pids["102"] || pids["200"] {
+		t.Errorf("escaped descendants are not attached, pids: %v", pids)
+	}
+	if controller.CheckCtrlPid("999") != nil || controller.CheckCtrlPid("102") == nil {
+		t.Errorf("controlled procs wrong, procs: %v", controller.CtlProcMap)
+	}
+	// fork of controlled proc is tracked and attached, fork of others is not
+	if !controller.trackFork(path, "100", "103") || controller.trackFork(path, "200", "201") {
+		t.Errorf("track fork failed")
+	}
+	counters := controller.Counters()
+	if counters.Exited != 1 || counters.Reattached != 3 || counters.Forks != 1 || counters.Audits != 1 {
+		t.Errorf("counters wrong, counters: %+v", counters)
+	}
+}
+
+func TestManagerRelease(t *testing.T) {
+	m := NewManager()
+	for _, name := range []define.Scope{define.Main, define.App, define.Global} {
+		m.controllers = append(m.controllers, &Controller{Name: name, manager: m, CtlProcMap: make(map[string]ControlProcSl)})
+	}
+	// cgroup dir not exist is removed already
+	err := m.Release(define.App)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.GetControllerCount() != 2 || m.CheckControllerExist(define.App, -1) {
+		t.Errorf("app controller should be removed, count: %d", m.GetControllerCount())
+	}
+	err = m.ReleaseAll()
+	if err != nil || m.GetControllerCount() != 0 {
+		t.Errorf("release all failed, count: %d, err: %v", m.GetControllerCount(), err)
+	}
+}
+
+func TestListProcs(t *testing.T) {
+	path := fakeCGroup(t)
+	root, err := ioutil.TempDir("", "proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	_ = ioutil.WriteFile(filepath.Join(root, "stat"), []byte("cpu  1 2 3\nbtime 1600000000\n"), 0644)
+	dir := filepath.Join(root, "100")
+	_ = os.MkdirAll(dir, 0755)
+	_ = os.Symlink("/usr/bin/foo", filepath.Join(dir, "exe"))
+	// comm with space and bracket, starttime is 12345 ticks
+	stat := "100 (foo (bar)) S 1 100 100 0 -1 4194560 1 0 0 0 0 0 0 0 20 0 1 0 12345 1000 10"
+	_ = ioutil.WriteFile(filepath.Join(dir, "stat"), []byte(stat), 0644)
+	controller := &Controller{Name: "app"}
+	controller.MarkAttached("100")
+	controller.MarkAttached("999")
+	procs, err := controller.listProcs(root, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(procs) != 1 {
+		t.Fatalf("list procs failed, procs: %v", procs)
+	}
+	proc := procs[0]
+	if proc.ExecPath != "/usr/bin/foo" || proc.Pid != 100 || proc.StartTime != 1600000123 || proc.AttachTime == 0 {
+		t.Errorf("proc wrong, proc: %+v", proc)
+	}
+	if _, ok := controller.attachTimes["999"]; ok {
+		t.Errorf("attach time of exited proc should be removed")
+	}
+}
+
+func TestSelfCGroup(t *testing.T) {
+	root, err := ioutil.TempDir("", "proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	_ = os.MkdirAll(filepath.Join(root, "self"), 0755)
+	cases := map[string]string{
+		"0::/system.slice/deepin-proxy.scope\n":            "system.slice/deepin-proxy.scope",
+		"0::/system.slice/deepin-proxy.scope/supervisor\n": "system.slice/deepin-proxy.scope",
+	}
+	for content, expect := range cases {
+		_ = ioutil.WriteFile(filepath.Join(root, "self", "cgroup"), []byte("1:name=systemd:/\n"+content), 0644)
+		rel, err := selfCGroup(root)
+		if err != nil || rel != expect {
+			t.Errorf("self cgroup should be %s, rel: %s, err: %v", expect, rel, err)
+		}
+	}
+	m := NewManager()
+	controller := &Controller{Name: "App", manager: m}
+	if controller.GetRelPath() != "App.slice" || m.Delegated() {
+		t.Errorf("rel path wrong, path: %s", controller.GetRelPath())
+	}
+	m.rel = "system.slice/deepin-proxy.scope"
+	if controller.GetCGroupPath() != filepath.Join(cgroup2Path, "system.slice/deepin-proxy.scope/App.slice") {
+		t.Errorf("delegated cgroup path wrong, path: %s", controller.GetCGroupPath())
+	}
+}
+
+func TestWatchCGroup(t *testing.T) {
+	path := fakeCGroup(t)
+	dir := filepath.Dir(path)
+	_ = ioutil.WriteFile(filepath.Join(dir, eventsPath), []byte("populated 1\nfrozen 0\n"), 0644)
+	interval := watchPollInterval
+	watchPollInterval = 10 * time.Millisecond
+	defer func() { watchPollInterval = interval }()
+	changed := make(chan []string, 1)
+	removed := make(chan bool, 1)
+	w, err := watchCGroup(dir, WatchHandler{
+		OnChange: func(add []string, del []string) {
+			changed <- append(add, del...)
+		},
+		OnRemoved: func() {
+			select {
+			case removed <- true:
+			default:
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	// 100 moved out by others, 200 moved in
+	_ = ioutil.WriteFile(path, []byte("200\n"), 0644)
+	select {
+	case pids := <-changed:
+		if len(pids) != 2 || pids[0] != "200" || pids[1] != "100" {
+			t.Errorf("changed pids wrong, pids: %v", pids)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("change is not reported")
+	}
+	_ = os.RemoveAll(dir)
+	select {
+	case <-removed:
+	case <-time.After(time.Second):
+		t.Fatal("remove is not reported")
+	}
+}
+
+func TestTgtExe(t *testing.T) {
+	m := NewManager()
+	controller := &Controller{Name: define.App, manager: m, CtlProcMap: make(map[string]ControlProcSl)}
+	m.controllers = append(m.controllers, controller)
+	// no running proc of exe
+	count, err := controller.AddTgtExe("/usr/bin/not-exist-exe")
+	if err != nil || count != 0 || !controller.CheckCtlPathSl("/usr/bin/not-exist-exe") {
+		t.Errorf("add target failed, count: %d, err: %v", count, err)
+	}
+	count, err = controller.RemoveTgtExe("/usr/bin/not-exist-exe")
+	if err != nil || count != 0 || controller.CheckCtlPathSl("/usr/bin/not-exist-exe") {
+		t.Errorf("remove target failed, count: %d, err: %v", count, err)
+	}
+}
+
+func TestFindSocketOwner(t *testing.T) {
+	root, err := ioutil.TempDir("", "proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	for pid, exe := range map[string]string{"100": "/usr/bin/curl", "200": "/usr/bin/wget"} {
+		dir := filepath.Join(root, pid, "fd")
+		_ = os.MkdirAll(dir, 0755)
+		_ = os.Symlink(exe, filepath.Join(root, pid, "exe"))
+		_ = os.Symlink("socket:["+pid+"0]", filepath.Join(dir, "3"))
+		_ = ioutil.WriteFile(filepath.Join(root, pid, "status"), []byte("PPid:\t1\n"), 0644)
+		_ = ioutil.WriteFile(filepath.Join(root, pid, "cgroup"), []byte("0::/user.slice\n"), 0644)
+	}
+	owner, err := FindSocketOwner(root, 2000, "100")
+	if err != nil || owner.Pid != "200" || owner.ExecPath != "/usr/bin/wget" {
+		t.Errorf("find owner got %+v, err: %v", owner, err)
+	}
+	_, err = FindSocketOwner(root, 3000, "")
+	if err == nil {
+		t.Error("socket without owner should fail")
+	}
+}
+
+func TestFindNetNS(t *testing.T) {
+	root, err := ioutil.TempDir("", "proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	fibTrie := `Main:
+  +-- 0.0.0.0/0 3 0 5
+     |-- 127.0.0.1
+        /32 host LOCAL
+     |-- 172.17.0.2
+        /32 host LOCAL
+     |-- 172.17.255.255
+        /32 link BROADCAST
+Local:
+  +-- 0.0.0.0/0 3 0 5
+     |-- 172.17.0.2
+        /32 host LOCAL
+`
+	for _, pid := range []string{"self", "100", "200", "300"} {
+		_ = os.MkdirAll(filepath.Join(root, pid, "ns"), 0755)
+		_ = os.MkdirAll(filepath.Join(root, pid, "net"), 0755)
+		_ = ioutil.WriteFile(filepath.Join(root, pid, "net", "fib_trie"), []byte(fibTrie), 0644)
+	}
+	// 100 shares namespace with daemon, 300 shares with 200
+	_ = ioutil.WriteFile(filepath.Join(root, "self", "ns", "net"), nil, 0644)
+	_ = ioutil.WriteFile(filepath.Join(root, "200", "ns", "net"), nil, 0644)
+	_ = os.Link(filepath.Join(root, "self", "ns", "net"), filepath.Join(root, "100", "ns", "net"))
+	_ = os.Link(filepath.Join(root, "200", "ns", "net"), filepath.Join(root, "300", "ns", "net"))
+	result, err := findNetNS(root, map[string]bool{"100": true, "200": true, "300": true, "400": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 1 || (result[0].Pid != "200" && result[0].Pid != "300") {
+		t.Fatalf("find network namespaces got %+v", result)
+	}
+	if addrs := result[0].Addrs; len(addrs) != 1 || addrs[0].String() != "172.17.0.2" {
+		t.Errorf("addrs of namespace got %v", addrs)
+	}
+}
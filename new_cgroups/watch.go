@@ -0,0 +1,200 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package NewCGroups
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// other agents like systemd may move procs out of cgroup, or remove cgroup,
+// exec and exit events cant report these changes.
+// cgroup.events is modified when populated changes, and is watched by inotify,
+// cgroup.procs has no inotify event, and is polled.
+
+// name of events file in cgroup dir
+const eventsPath = "cgroup.events"
+
+// interval to poll cgroup.procs, tests can change it
+var watchPollInterval = 5 * time.Second
+
+// handlers of cgroup changes, nil handler is skipped
+type WatchHandler struct {
+	OnChange  func(added []string, removed []string) // pids added and removed by others
+	OnRemoved func()                                 // cgroup dir is removed
+}
+
+// watcher of one cgroup
+type Watcher struct {
+	dir     string
+	handler WatchHandler
+	pids    map[string]bool
+	notify  chan bool
+	stop    chan bool
+	file    *os.File
+}
+
+// watch cgroup of controller
+func (c *Controller) Watch(handler WatchHandler) (*Watcher, error) {
+	return watchCGroup(c.GetCGroupPath(), handler)
+}
+
+func watchCGroup(dir string, handler WatchHandler) (*Watcher, error) {
+	pids, err := readPids(filepath.Join(dir, procsPath))
+	if err != nil {
+		return nil, err
+	}
+	w := &Watcher{
+		dir:     dir,
+		handler: handler,
+		pids:    pids,
+		notify:  make(chan bool, 1),
+		stop:    make(chan bool),
+	}
+	// poll only if inotify is not available
+	err = w.addWatch()
+	if err != nil {
+		logger.Warningf("inotify cgroups %s failed, poll only, err: %v", dir, err)
+	}
+	go w.run()
+	return w, nil
+}
+
+// inotify cgroup.events, file is read by runtime poller, so that close unblocks read
+func (w *Watcher) addWatch() error {
+	fd, err := syscall.InotifyInit1(syscall.IN_NONBLOCK | syscall.IN_CLOEXEC)
+	if err != nil {
+		return err
+	}
+	_, err = syscall.InotifyAddWatch(fd, filepath.Join(w.dir, eventsPath), syscall.IN_MODIFY|syscall.IN_DELETE_SELF)
+	if err != nil {
+		_ = syscall.Close(fd)
+		return err
+	}
+	file := os.NewFile(uintptr(fd), "inotify")
+	w.file = file
+	go w.readEvents(file)
+	return nil
+}
+
+// read inotify events until file closed or watch removed
+func (w *Watcher) readEvents(file *os.File) {
+	buf := make([]byte, syscall.SizeofInotifyEvent*16+syscall.NAME_MAX+1)
+	for {
+		n, err := file.Read(buf)
+		if err != nil {
+			return
+		}
+		var ignored bool
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			if event.Mask&(syscall.IN_DELETE_SELF|syscall.IN_IGNORED) != 0 {
+				ignored = true
+			}
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+		}
+		select {
+		case w.notify <- true:
+		default:
+		}
+		// watch is removed with cgroup
+		if ignored {
+			return
+		}
+	}
+}
+
+// check cgroup when notified or polled
+func (w *Watcher) run() {
+	ticker := time.NewTicker(watchPollInterval)
+	defer ticker.Stop()
+	// file is only touched by this goroutine after created
+	defer w.closeFile()
+	for {
+		select {
+		case <-w.notify:
+			w.check()
+		case <-ticker.C:
+			w.check()
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// compare pids with last check, report removed cgroup
+func (w *Watcher) check() {
+	pids, err := readPids(filepath.Join(w.dir, procsPath))
+	if os.IsNotExist(err) {
+		logger.Warningf("cgroups %s is removed by others", w.dir)
+		if w.handler.OnRemoved != nil {
+			w.handler.OnRemoved()
+		}
+		// cgroup may be created again by handler
+		w.closeFile()
+		pids, err = readPids(filepath.Join(w.dir, procsPath))
+		if err != nil {
+			w.pids = make(map[string]bool)
+			return
+		}
+		_ = w.addWatch()
+	}
+	if err != nil {
+		return
+	}
+	added, removed := diffPids(w.pids, pids)
+	w.pids = pids
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+	logger.Debugf("cgroups %s changed, added: %v, removed: %v", w.dir, added, removed)
+	if w.handler.OnChange != nil {
+		w.handler.OnChange(added, removed)
+	}
+}
+
+// pids added and removed, sorted
+func diffPids(old map[string]bool, cur map[string]bool) ([]string, []string) {
+	var added, removed []string
+	for pid := range cur {
+		if !old[pid] {
+			added = append(added, pid)
+		}
+	}
+	for pid := range old {
+		if !cur[pid] {
+			removed = append(removed, pid)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// fds held by watcher, inotify file only
+func (w *Watcher) Fds() int {
+	if w == nil || w.file == nil {
+		return 0
+	}
+	return 1
+}
+
+// close inotify file
+func (w *Watcher) closeFile() {
+	if w.file == nil {
+		return
+	}
+	_ = w.file.Close()
+	w.file = nil
+}
+
+// stop watching, should be called before cgroup is removed by daemon
+func (w *Watcher) Close() {
+	close(w.stop)
+}
@@ -0,0 +1,152 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package NewIptables
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// firewalld and docker may hold xtables lock at the same time,
+// commands wait for lock by -w, and retry if lock is still busy
+const (
+	xtablesWait       = 5 // second
+	lockRetryAttempts = 3
+	lockRetryDelay    = 500 * time.Millisecond
+	resourceProblem   = 4 // exit code of iptables
+)
+
+// serialize commands of daemon, in case goroutines compete for lock with each other
+var cmdLock sync.Mutex
+
+// backend to apply iptables operations, args are split fields of one command without iptables itself.
+// exec backend is used by default, backend such as netlink can replace it, and tests can fake it.
+type Runner interface {
+	// run one command, like -t mangle -A Main -j App
+	Run(args []string) ([]byte, error)
+	// apply rules in iptables-restore format
+	Restore(data string, noflush bool) ([]byte, error)
+	// dump rules of table in iptables-save format
+	Save(table string) ([]byte, error)
+}
+
+// current backend of ipv4 and ipv6
+var runner Runner = &execRunner{}
+var runner6 Runner = &execRunner{bin: "ip6tables"}
+
+// count of commands failed to change rules
+var cmdFailures uint64
+
+// count of commands failed to change rules since daemon started
+func CommandFailures() uint64 {
+	return atomic.LoadUint64(&cmdFailures)
+}
+
+// replace backend, should be called before any rule is applied
+func SetRunner(r Runner) {
+	runner = r
+}
+
+// replace backend of ipv6, should be called before any rule is applied
+func SetRunner6(r Runner) {
+	runner6 = r
+}
+
+// backend of family
+func familyRunner(ipv6 bool) Runner {
+	if ipv6 {
+		return runner6
+	}
+	return runner
+}
+
+// run iptables or ip6tables binaries, wait for xtables lock
+type execRunner struct {
+	bin string // iptables if empty
+}
+
+// binary of family, suffix is like -save
+func (e *execRunner) binary(suffix string) string {
+	if e.bin == "" {
+		return "iptables" + suffix
+	}
+	return e.bin + suffix
+}
+
+func (e *execRunner) Run(args []string) ([]byte, error) {
+	return runSerial(e.binary(""), append(waitArgs(), args...), "")
+}
+
+func (e *execRunner) Restore(data string, noflush bool) ([]byte, error) {
+	args := waitArgs()
+	if noflush {
+		args = append(args, "--noflush")
+	}
+	return runSerial(e.binary("-restore"), args, data)
+}
+
+func (e *execRunner) Save(table string) ([]byte, error) {
+	return runSerial(e.binary("-save"), []string{"-t", table}, "")
+}
+
+// wait for xtables lock
+func waitArgs() []string {
+	return []string{"-w", strconv.Itoa(xtablesWait)}
+}
+
+// make iptables command line, used by log and dry run
+func iptablesCmd(args ...string) string {
+	return familyCmd(false, args...)
+}
+
+// make iptables or ip6tables command line
+func familyCmd(ipv6 bool, args ...string) string {
+	bin := "iptables"
+	if ipv6 {
+		bin = "ip6tables"
+	}
+	sl := append([]string{bin}, waitArgs()...)
+	sl = append(sl, args...)
+	return strings.Join(sl, " ")
+}
+
+// run binary serially, retry if xtables lock is busy
+func runSerial(name string, args []string, stdin string) ([]byte, error) {
+	cmdLock.Lock()
+	defer cmdLock.Unlock()
+	var buf []byte
+	var err error
+	for attempt := 1; attempt <= lockRetryAttempts; attempt++ {
+		cmd := exec.Command(name, args...)
+		if stdin != "" {
+			cmd.Stdin = strings.NewReader(stdin)
+		}
+		buf, err = cmd.CombinedOutput()
+		if err == nil || !isLockExit(err) && !isLockErr(buf) {
+			return buf, err
+		}
+		logger.Debugf("xtables lock is busy, retry after %v, attempt: %d/%d", lockRetryDelay, attempt, lockRetryAttempts)
+		time.Sleep(lockRetryDelay)
+	}
+	return buf, err
+}
+
+// iptables exits with resource problem when xtables lock is busy
+func isLockExit(err error) bool {
+	var exitErr *exec.ExitError
+	return errors.As(err, &exitErr) && exitErr.ExitCode() == resourceProblem
+}
+
+// check if command failed because xtables lock is held by others, for iptables of old version
+func isLockErr(out []byte) bool {
+	return bytes.Contains(out, []byte("Resource temporarily unavailable")) ||
+		bytes.Contains(out, []byte("holding the xtables lock"))
+}
@@ -0,0 +1,143 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package NewIptables
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/coreos/go-iptables/iptables"
+)
+
+// chain operations are applied by go-iptables, which reports exit status and missing chain or rule
+// as structured error, instead of text of CombinedOutput.
+// go-iptables has no restore and save, and lists rules in other format,
+// so restore, save and listing counters fall back to exec backend.
+
+// chain or rule operated is not in kernel
+var errNotExist = errors.New("chain or rule does not exist")
+
+// run chain operations by go-iptables, others by exec
+type nativeRunner struct {
+	ipt      *iptables.IPTables
+	fallback *execRunner
+}
+
+// create backend of go-iptables, exec backend is returned if iptables can not be found by go-iptables
+func NewRunner() Runner {
+	return newNativeRunner(iptables.ProtocolIPv4, &execRunner{})
+}
+
+// create backend of go-iptables for ip6tables
+func NewRunner6() Runner {
+	return newNativeRunner(iptables.ProtocolIPv6, &execRunner{bin: "ip6tables"})
+}
+
+func newNativeRunner(proto iptables.Protocol, fallback *execRunner) Runner {
+	ipt, err := iptables.New(iptables.IPFamily(proto), iptables.Timeout(xtablesWait))
+	if err != nil {
+		logger.Warningf("init go-iptables of %s failed, use exec backend, err: %v", fallback.binary(""), err)
+		return fallback
+	}
+	return &nativeRunner{ipt: ipt, fallback: fallback}
+}
+
+// args are like -t mangle -I OUTPUT 1 -j Main
+func (n *nativeRunner) Run(args []string) ([]byte, error) {
+	if len(args) < 4 || args[0] != "-t" {
+		return n.fallback.Run(args)
+	}
+	table, operation, chain, spec := args[1], args[2], args[3], args[4:]
+	var run func() error
+	switch operation {
+	case "-" + Append.ToString():
+		run = func() error { return n.ipt.Append(table, chain, spec...) }
+	case "-" + Insert.ToString():
+		pos := 1
+		if len(spec) != 0 {
+			if index, err := strconv.Atoi(spec[0]); err == nil {
+				pos = index
+				spec = spec[1:]
+			}
+		}
+		run = func() error { return n.ipt.Insert(table, chain, pos, spec...) }
+	case "-" + Delete.ToString():
+		run = func() error { return n.ipt.Delete(table, chain, spec...) }
+	case "-" + Check.ToString():
+		run = func() error {
+			exist, err := n.ipt.Exists(table, chain, spec...)
+			if err == nil && !exist {
+				return errNotExist
+			}
+			return err
+		}
+	case "-" + New.ToString():
+		run = func() error { return n.ipt.NewChain(table, chain) }
+	case "-" + Flush.ToString():
+		// go-iptables creates chain when flush, chain not exist is reported as iptables does
+		run = func() error {
+			exist, err := n.ipt.ChainExists(table, chain)
+			if err != nil {
+				return err
+			}
+			if !exist {
+				return fmt.Errorf("flush chain %s failed: %w", chain, errNotExist)
+			}
+			return n.ipt.ClearChain(table, chain)
+		}
+	case "-" + Remove.ToString():
+		run = func() error { return n.ipt.DeleteChain(table, chain) }
+	case "-" + Policy.ToString():
+		if len(spec) != 1 {
+			return n.fallback.Run(args)
+		}
+		run = func() error { return n.ipt.ChangePolicy(table, chain, spec[0]) }
+	default:
+		// listing such as -vnL
+		return n.fallback.Run(args)
+	}
+	return nil, runNative(run)
+}
+
+func (n *nativeRunner) Restore(data string, noflush bool) ([]byte, error) {
+	return n.fallback.Restore(data, noflush)
+}
+
+func (n *nativeRunner) Save(table string) ([]byte, error) {
+	return n.fallback.Save(table)
+}
+
+// run operation serially with exec backend, retry if xtables lock is busy
+func runNative(run func() error) error {
+	cmdLock.Lock()
+	defer cmdLock.Unlock()
+	var err error
+	for attempt := 1; attempt <= lockRetryAttempts; attempt++ {
+		err = run()
+		if err == nil || !isNativeLockErr(err) {
+			return err
+		}
+		logger.Debugf("xtables lock is busy, retry after %v, attempt: %d/%d", lockRetryDelay, attempt, lockRetryAttempts)
+		time.Sleep(lockRetryDelay)
+	}
+	return err
+}
+
+// iptables exits with resource problem when xtables lock is busy
+func isNativeLockErr(err error) bool {
+	var iptErr *iptables.Error
+	return errors.As(err, &iptErr) && iptErr.ExitStatus() == resourceProblem
+}
+
+// check if operation failed because chain or rule does not exist
+func IsNotExist(err error) bool {
+	if errors.Is(err, errNotExist) {
+		return true
+	}
+	var iptErr *iptables.Error
+	return errors.As(err, &iptErr) && iptErr.IsNotExist()
+}
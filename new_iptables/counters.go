@@ -0,0 +1,119 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package NewIptables
+
+import (
+	"strconv"
+	"strings"
+)
+
+// packet and byte counters of one rule
+type RuleCounter struct {
+	Table   string
+	Chain   string
+	Rule    string // rule in iptables -L format, like Main all -- * * 0.0.0.0/0 0.0.0.0/0
+	Packets uint64
+	Bytes   uint64
+}
+
+// parse output of iptables -vnL -x, return counters of rules by chain
+func parseCounters(table string, out string) map[string][]RuleCounter {
+	// Chain OUTPUT (policy ACCEPT 10 packets, 600 bytes)
+	//     pkts      bytes target     prot opt in     out     source               destination
+	//       10      600 Main       all  --  *      *       0.0.0.0/0            0.0.0.0/0            /* deepin-proxy */
+	counters := make(map[string][]RuleCounter)
+	var chain string
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "Chain" && len(fields) > 1 {
+			chain = fields[1]
+			counters[chain] = []RuleCounter{}
+			continue
+		}
+		if chain == "" || len(fields) < 3 {
+			continue
+		}
+		packets, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			// title line
+			continue
+		}
+		bytes, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		counters[chain] = append(counters[chain], RuleCounter{
+			Table:   table,
+			Chain:   chain,
+			Rule:    strings.Join(fields[2:], " "),
+			Packets: packets,
+			Bytes:   bytes,
+		})
+	}
+	return counters
+}
+
+// counters of rules in self create chains, and rules tagged with comment in default chains
+func (t *Table) Counters() ([]RuleCounter, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	var result []RuleCounter
+	// table without rules is not listed
+	if t.rulesCount() == 0 {
+		return result, nil
+	}
+	buf, err := t.runner().Run([]string{"-t", t.Name, "-vnL", "-x"})
+	if err != nil {
+		logger.Warningf("[%s] list counters failed, out: %s, err: %v", t.Name, string(buf), err)
+		return nil, err
+	}
+	counters := parseCounters(t.Name, string(buf))
+	tag := "/* " + t.comment + " */"
+	for _, cName := range tableSl[t.Name] {
+		chain, ok := t.chains[cName]
+		if !ok {
+			continue
+		}
+		result = chain.counters(counters, tag, result)
+	}
+	return result, nil
+}
+
+// add counters of chain and children
+func (c *Chain) counters(counters map[string][]RuleCounter, tag string, result []RuleCounter) []RuleCounter {
+	for _, counter := range counters[c.Name] {
+		// rules of other tools in default chain are ignored
+		if c.parent == nil && (c.table.comment == "" || !strings.Contains(counter.Rule, tag)) {
+			continue
+		}
+		result = append(result, counter)
+	}
+	for _, name := range c.childrenNames() {
+		result = c.children[name].counters(counters, tag, result)
+	}
+	return result
+}
+
+// counters of rules created by manager in all tables
+func (m *Manager) Counters() ([]RuleCounter, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var result []RuleCounter
+	for _, tName := range []string{"raw", "mangle", "nat", "filter"} {
+		table, ok := m.tables[tName]
+		if !ok {
+			continue
+		}
+		counters, err := table.Counters()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, counters...)
+	}
+	return result, nil
+}
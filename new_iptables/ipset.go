@@ -0,0 +1,120 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package NewIptables
+
+import (
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// one rule for each cidr is slow when list is long, cidr is added to ipset instead,
+// and matched by one rule   -m set --match-set App_bypass dst
+
+// max length of ipset name, IPSET_MAXNAMELEN - 1
+const IPSetNameMax = 31
+
+// run ipset, tests can fake it
+var ipsetRun = func(args []string, stdin string) ([]byte, error) {
+	return runSerial("ipset", args, stdin)
+}
+
+// check if ipset binary exist
+func IPSetSupported() bool {
+	_, err := exec.LookPath("ipset")
+	return err == nil
+}
+
+// ipv4 net set
+type IPSet struct {
+	Name    string
+	entries map[string]bool
+}
+
+// create set, set left by last run is flushed and reused
+func CreateIPSet(name string) (*IPSet, error) {
+	if name == "" || len(name) > IPSetNameMax {
+		return nil, fmt.Errorf("ipset name %q length is invalid", name)
+	}
+	data := fmt.Sprintf("create %s hash:net family inet -exist\nflush %s\n", name, name)
+	buf, err := ipsetRun([]string{"restore"}, data)
+	if err != nil {
+		logger.Warningf("[ipset] create set %s failed, out: %s, err: %v", name, string(buf), err)
+		return nil, err
+	}
+	logger.Debugf("[ipset] create set %s success", name)
+	return &IPSet{
+		Name:    name,
+		entries: make(map[string]bool),
+	}, nil
+}
+
+// update set to entries, only changed entries are added or deleted
+func (s *IPSet) Sync(entries []string) error {
+	lines := s.syncLines(entries)
+	if len(lines) == 0 {
+		return nil
+	}
+	buf, err := ipsetRun([]string{"restore"}, strings.Join(lines, "\n")+"\n")
+	if err != nil {
+		logger.Warningf("[ipset] sync set %s failed, out: %s, err: %v", s.Name, string(buf), err)
+		return err
+	}
+	want := make(map[string]bool)
+	for _, entry := range entries {
+		want[entry] = true
+	}
+	s.entries = want
+	logger.Debugf("[ipset] sync set %s success, changed: %d", s.Name, len(lines))
+	return nil
+}
+
+// make ipset restore lines to update set, in stable order
+func (s *IPSet) syncLines(entries []string) []string {
+	want := make(map[string]bool)
+	var lines []string
+	for _, entry := range entries {
+		if want[entry] {
+			continue
+		}
+		want[entry] = true
+		if !s.entries[entry] {
+			lines = append(lines, fmt.Sprintf("add %s %s -exist", s.Name, entry))
+		}
+	}
+	var removed []string
+	for entry := range s.entries {
+		if !want[entry] {
+			removed = append(removed, entry)
+		}
+	}
+	sort.Strings(removed)
+	for _, entry := range removed {
+		lines = append(lines, fmt.Sprintf("del %s %s -exist", s.Name, entry))
+	}
+	return lines
+}
+
+// destroy set, rules match set should be removed first
+func (s *IPSet) Destroy() error {
+	buf, err := ipsetRun([]string{"destroy", s.Name}, "")
+	if err != nil {
+		logger.Warningf("[ipset] destroy set %s failed, out: %s, err: %v", s.Name, string(buf), err)
+		return err
+	}
+	return nil
+}
+
+// make rule   -m set --match-set App_bypass dst
+func SetRule(name string, not bool) ExtendsRule {
+	return ExtendsRule{
+		Match: "m",
+		Elem: ExtendsElem{
+			Match: "set",
+			Base:  BaseRule{Not: not, Match: "match-set", Param: name + " dst"},
+		},
+	}
+}
@@ -6,10 +6,11 @@ package NewIptables
 
 import (
 	"errors"
-	"os/exec"
+	"fmt"
 	"reflect"
 	"strconv"
 	"strings"
+	"sync/atomic"
 
 	com "github.com/linuxdeepin/deepin-network-proxy/com"
 )
@@ -18,31 +19,68 @@ import (
 type Table struct {
 	Name   string // raw mangle nat filter
 	chains map[string]*Chain
+	// rules are applied by ip6tables
+	ipv6 bool
+
+	// batch mode, only update rule tree
+	batch bool
+	// journal of applied rules, may be nil
+	journal *Journal
+	// dry run mode, command lines are recorded instead of running
+	dryRun *[]string
+	// comment tagged to all rules, empty means no tag
+	comment string
+	// undo steps of transaction, nil means not in transaction
+	undo *[]func() error
+	// lock of rule tree, shared by tables of manager
+	lock *treeLock
 }
 
 // run iptables command
 func (t *Table) runCommand(operation Operation, chain *Chain, index int, cpl *CompleteRule) error {
+	// rule tree is applied when commit
+	if t.batch {
+		return nil
+	}
 	// run command
-	args := []string{"iptables", "-t", t.Name, "-" + operation.ToString(), chain.Name}
+	args := []string{"-t", t.Name, "-" + operation.ToString(), chain.Name}
 	// add index
 	if index != 0 && operation == Insert {
 		args = append(args, strconv.Itoa(index))
 	}
 	// add one complete rule
 	if cpl != nil {
-		args = append(args, cpl.String())
+		args = append(args, strings.Fields(cpl.String())...)
+	}
+	line := familyCmd(t.ipv6, args...)
+	if t.dryRun != nil {
+		*t.dryRun = append(*t.dryRun, line)
+		return nil
+	}
+	logger.Debugf("[%s] begin to run begin to run command: %v", t.Name, line)
+	buf, err := t.runner().Run(args)
+	// rule or chain removed by other tool is already as wanted
+	if err != nil && (operation == Delete || operation == Remove) && IsNotExist(err) {
+		logger.Debugf("[%s] chain %s or rule is already removed, err: %v", t.Name, chain.Name, err)
+		err = nil
 	}
-	cmd := exec.Command("/bin/sh", "-c", strings.Join(args, " "))
-	logger.Debugf("[%s] begin to run begin to run command: %v", t.Name, cmd)
-	buf, err := cmd.CombinedOutput()
 	if err != nil {
+		atomic.AddUint64(&cmdFailures, 1)
 		logger.Warningf("[%s] run command failed, out: %s, err:%v", t.Name, string(buf), err)
 		return err
 	}
 	logger.Debugf("[%s] run command success", t.Name)
+	if t.journal != nil {
+		t.journal.record(operation, t.Name, chain.Name, cpl)
+	}
 	return nil
 }
 
+// backend of family of table
+func (t *Table) runner() Runner {
+	return familyRunner(t.ipv6)
+}
+
 // check if chain exist
 func (t *Table) getChain(name string) *Chain {
 	chain, ok := t.chains[name]
@@ -54,6 +92,38 @@ func (t *Table) getChain(name string) *Chain {
 	return chain
 }
 
+// delete rule of chain by index
+func (t *Table) DeleteRule(chain string, index int) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	c := t.getChain(chain)
+	if c == nil {
+		return fmt.Errorf("chain %s not exist", chain)
+	}
+	return c.DelRuleByIndex(index)
+}
+
+// delete rule of chain by match, nil and empty slice are the same
+func (t *Table) DeleteRuleByMatch(chain string, action string, baseSl []BaseRule, extendsSl []ExtendsRule) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	c := t.getChain(chain)
+	if c == nil {
+		return fmt.Errorf("chain %s not exist", chain)
+	}
+	cpl := &CompleteRule{
+		Action:    action,
+		BaseSl:    baseSl,
+		ExtendsSl: extendsSl,
+	}
+	index, exist := c.GetRuleIndex(cpl)
+	if !exist {
+		logger.Debugf("[%s] chain %s dont exist rule %s", t.Name, chain, cpl.String())
+		return nil
+	}
+	return c.DelRuleByIndex(index)
+}
+
 // chain
 type Chain struct {
 	// chain name
@@ -82,6 +152,8 @@ func (c *Chain) indexValid(index int) bool {
 
 // create child chain
 func (c *Chain) CreateChild(name string, index int, cpl *CompleteRule) (*Chain, error) {
+	c.table.lock.Lock()
+	defer c.table.lock.Unlock()
 	// create child
 	child := &Chain{
 		Name:     name,
@@ -106,6 +178,7 @@ func (c *Chain) CreateChild(name string, index int, cpl *CompleteRule) (*Chain,
 	c.table.chains[name] = child
 	// add to child
 	c.children[name] = child
+	c.table.pushUndo(child.Remove)
 	logger.Debugf("[%s] chain %s create child %s success", c.table.Name, c.Name, name)
 	// return handler
 	return child, nil
@@ -113,16 +186,22 @@ func (c *Chain) CreateChild(name string, index int, cpl *CompleteRule) (*Chain,
 
 // current rule count
 func (c *Chain) GetRulesCount() int {
+	c.table.lock.Lock()
+	defer c.table.lock.Unlock()
 	return len(c.cplRuleSl)
 }
 
 // current children chain
 func (c *Chain) GetChildrenCount() int {
+	c.table.lock.Lock()
+	defer c.table.lock.Unlock()
 	return len(c.children)
 }
 
 // current create child index
 func (c *Chain) GetCreateChildIndex(name string) (int, bool) {
+	c.table.lock.Lock()
+	defer c.table.lock.Unlock()
 	// search all rule
 	for index, rule := range c.cplRuleSl {
 		if strings.Contains(rule.String(), strings.Join([]string{"-j", name}, " ")) {
@@ -136,6 +215,8 @@ func (c *Chain) GetCreateChildIndex(name string) (int, bool) {
 
 // remove self
 func (c *Chain) Remove() error {
+	c.table.lock.Lock()
+	defer c.table.lock.Unlock()
 	// delete self from parent first
 	if c.parent != nil {
 		err := c.parent.DelChild(c)
@@ -155,6 +236,8 @@ func (c *Chain) Remove() error {
 
 // clear all chain
 func (c *Chain) Clear() error {
+	c.table.lock.Lock()
+	defer c.table.lock.Unlock()
 	for _, child := range c.children {
 		err := child.Remove()
 		if err != nil {
@@ -177,6 +260,8 @@ func (c *Chain) Clear() error {
 
 // delete child from self
 func (c *Chain) DelChild(child *Chain) error {
+	c.table.lock.Lock()
+	defer c.table.lock.Unlock()
 	var childName string
 	// check if chain exist
 	for name, chain := range c.children {
@@ -206,6 +291,9 @@ func (c *Chain) AddRule(cpl *CompleteRule) error {
 
 // append rule at last
 func (c *Chain) AppendRule(cpl *CompleteRule) error {
+	c.table.lock.Lock()
+	defer c.table.lock.Unlock()
+	cpl = c.table.tag(cpl)
 	// check if already exist
 	if c.ExistRule(cpl) {
 		return nil
@@ -217,11 +305,15 @@ func (c *Chain) AppendRule(cpl *CompleteRule) error {
 		return err
 	}
 	c.cplRuleSl = append(c.cplRuleSl, cpl)
+	c.table.pushUndo(func() error { return c.DelRule(cpl) })
 	return nil
 }
 
 // insert rule
 func (c *Chain) InsertRule(index int, cpl *CompleteRule) error {
+	c.table.lock.Lock()
+	defer c.table.lock.Unlock()
+	cpl = c.table.tag(cpl)
 	if !c.indexValid(index) {
 		logger.Warningf("[%s] chain %s add rule failed, index invalid", c.table.Name, c.Name)
 		return errors.New("index invalid")
@@ -237,24 +329,21 @@ func (c *Chain) InsertRule(index int, cpl *CompleteRule) error {
 		return err
 	}
 	logger.Debugf("[%s] chain %s insert success", c.table.Name, c.Name)
-	ifc, update, err := com.MegaInsert(c.cplRuleSl, cpl, index)
+	c.table.pushUndo(func() error { return c.DelRule(cpl) })
+	temp, err := com.MegaInsert(c.cplRuleSl, cpl, index)
 	if err != nil {
 		logger.Warningf("[%s] inset failed, err: %v", c.table.Name, err)
 		return err
 	}
-	if !update {
-		return nil
-	}
-	temp, ok := ifc.([]*CompleteRule)
-	if !ok {
-		return nil
-	}
 	c.cplRuleSl = temp
 	return nil
 }
 
 // check if rule exist
 func (c *Chain) ExistRule(cpl *CompleteRule) bool {
+	c.table.lock.Lock()
+	defer c.table.lock.Unlock()
+	cpl = c.table.tag(cpl)
 	for _, rule := range c.cplRuleSl {
 		if reflect.DeepEqual(rule, cpl) {
 			logger.Debugf("[%s] chain %s exist rule %s", c.table.Name, c.Name, cpl.String())
@@ -267,41 +356,50 @@ func (c *Chain) ExistRule(cpl *CompleteRule) bool {
 
 // del rule
 func (c *Chain) DelRule(cpl *CompleteRule) error {
+	c.table.lock.Lock()
+	defer c.table.lock.Unlock()
+	cpl = c.table.tag(cpl)
 	// check if rule exist
 	if !c.ExistRule(cpl) {
 		return nil
 	}
+	// position to restore when rollback
+	index, _ := c.GetRuleIndex(cpl)
 	// clear self chain
 	err := c.table.runCommand(Delete, c, 0, cpl)
 	if err != nil {
 		logger.Warningf("[%s] chain %s del failed", c.table.Name, c.Name, err)
 		return err
 	}
+	c.table.pushUndo(func() error { return c.InsertRule(index, cpl) })
 	// delete slice
-	ifc, update, err := com.MegaDel(c.cplRuleSl, cpl)
-	if err != nil {
-		logger.Warningf("[%s] del failed, err: %v", c.table.Name, err)
-		return err
-	}
-	if !update {
-		return nil
-	}
-	temp, ok := ifc.([]*CompleteRule)
-	if !ok {
-		return nil
-	}
-	c.cplRuleSl = temp
+	c.cplRuleSl, _ = com.MegaDelFunc(c.cplRuleSl, func(rule *CompleteRule) bool { return reflect.DeepEqual(rule, cpl) })
 	return nil
 }
 
 // get rule index
 func (c *Chain) GetRuleByIndex(index int) *CompleteRule {
+	c.table.lock.Lock()
+	defer c.table.lock.Unlock()
 	if index >= len(c.cplRuleSl) {
 		return nil
 	}
 	return c.cplRuleSl[index]
 }
 
+// get index of rule which makes the same command
+func (c *Chain) GetRuleIndex(cpl *CompleteRule) (int, bool) {
+	c.table.lock.Lock()
+	defer c.table.lock.Unlock()
+	cpl = c.table.tag(cpl)
+	for index, rule := range c.cplRuleSl {
+		if rule.String() == cpl.String() {
+			return index, true
+		}
+	}
+	return 0, false
+}
+
 // del rule index
 func (c *Chain) DelRuleByIndex(index int) error {
 	rule := c.GetRuleByIndex(index)
@@ -0,0 +1,210 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package NewIptables
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDeleteRule(t *testing.T) {
+	mgr := NewManager()
+	mgr.Init()
+	// batch mode dont exec iptables
+	mgr.Begin()
+	table := mgr.GetTable("filter")
+	chain := mgr.GetChain("filter", "OUTPUT")
+	_ = chain.AppendRule(&CompleteRule{Action: ACCEPT, BaseSl: []BaseRule{{Match: "o", Param: "lo"}}})
+	_ = chain.AppendRule(&CompleteRule{Action: DROP})
+	_ = chain.AppendRule(&CompleteRule{Action: RETURN})
+
+	err := table.DeleteRule("OUTPUT", 1)
+	if err != nil || chain.GetRulesCount() != 2 || chain.GetRuleByIndex(1).Action != RETURN {
+		t.Errorf("delete rule by index failed, err: %v", err)
+	}
+	// empty slice match rule with nil slice
+	err = table.DeleteRuleByMatch("OUTPUT", RETURN, []BaseRule{}, []ExtendsRule{})
+	if err != nil || chain.GetRulesCount() != 1 {
+		t.Errorf("delete rule by match failed, err: %v", err)
+	}
+	err = table.DeleteRule("OUTPUT", 5)
+	if err == nil {
+		t.Error("delete rule by invalid index should fail")
+	}
+}
+
+func TestLockErr(t *testing.T) {
+	out := []byte("Another app is currently holding the xtables lock. Perhaps you want to use the -w option?")
+	if !isLockErr(out) {
+		t.Error("xtables lock error is not detected")
+	}
+	if isLockErr([]byte("iptables: No chain/target/match by that name.")) {
+		t.Error("other error should not be lock error")
+	}
+	if line := iptablesCmd("-t", "mangle", "-F", "Main"); line != "iptables -w 5 -t mangle -F Main" {
+		t.Errorf("iptables command line incorrect, line: %s", line)
+	}
+}
+
+func TestOwnerRule(t *testing.T) {
+	cpl := &CompleteRule{
+		Action:    RETURN,
+		ExtendsSl: []ExtendsRule{OwnerUidRule("1000", false), OwnerGidRule("1000-2000", true)},
+	}
+	if str := cpl.String(); str != "-j RETURN -m owner --uid-owner 1000 -m owner ! --gid-owner 1000-2000" {
+		t.Errorf("owner rule incorrect, rule: %s", str)
+	}
+	rule, err := OwnerUserRule("root", false)
+	if err != nil || rule.String() != "-m owner --uid-owner 0" {
+		t.Errorf("owner user rule incorrect, rule: %s, err: %v", rule.String(), err)
+	}
+	_, err = OwnerGroupRule("no-such-group-for-test", false)
+	if err == nil {
+		t.Error("owner rule of unknown group should fail")
+	}
+}
+
+func TestMarkRule(t *testing.T) {
+	cpl := &CompleteRule{Action: RETURN, ExtendsSl: []ExtendsRule{MarkRule(1024, false), MarkRule(0xdee9, true)}}
+	if str := cpl.String(); str != "-j RETURN -m mark --mark 1024 -m mark ! --mark 57065" {
+		t.Errorf("mark rule incorrect, rule: %s", str)
+	}
+}
+
+func TestSynRule(t *testing.T) {
+	cpl := &CompleteRule{Action: TCPMSS, BaseSl: []BaseRule{{Match: "-set-mss", Param: "1400"}}, ExtendsSl: []ExtendsRule{SynRule()}}
+	if str := cpl.String(); str != "-j TCPMSS --set-mss 1400 -p tcp --tcp-flags SYN,RST SYN" {
+		t.Errorf("syn rule incorrect, rule: %s", str)
+	}
+}
+
+func TestNoParamRule(t *testing.T) {
+	cpl := &CompleteRule{Action: CONNMARK, BaseSl: []BaseRule{{Match: "-save-mark"}}}
+	if str := cpl.String(); str != "-j CONNMARK --save-mark" {
+		t.Errorf("rule without param incorrect, rule: %s", str)
+	}
+}
+
+// record commands instead of exec iptables
+type fakeRunner struct {
+	cmds []string
+}
+
+func (f *fakeRunner) Run(args []string) ([]byte, error) {
+	f.cmds = append(f.cmds, strings.Join(args, " "))
+	return nil, nil
+}
+
+func (f *fakeRunner) Restore(data string, noflush bool) ([]byte, error) {
+	f.cmds = append(f.cmds, data)
+	return nil, nil
+}
+
+func (f *fakeRunner) Save(table string) ([]byte, error) {
+	return nil, nil
+}
+
+func TestRunner(t *testing.T) {
+	fake := &fakeRunner{}
+	SetRunner(fake)
+	defer SetRunner(&execRunner{})
+
+	mgr := NewManager()
+	mgr.Init()
+	chain := mgr.GetChain("mangle", "OUTPUT")
+	_, err := chain.CreateChild("Main", 0, &CompleteRule{Action: "Main"})
+	if err != nil {
+		t.Fatalf("create child failed, err: %v", err)
+	}
+	want := []string{"-t mangle -N Main", "-t mangle -I OUTPUT 1 -j Main"}
+	if strings.Join(fake.cmds, "\n") != strings.Join(want, "\n") {
+		t.Errorf("commands incorrect, commands: %v", fake.cmds)
+	}
+}
+
+func TestRunner6(t *testing.T) {
+	fake, fake6 := &fakeRunner{}, &fakeRunner{}
+	SetRunner(fake)
+	SetRunner6(fake6)
+	defer SetRunner(&execRunner{})
+	defer SetRunner6(&execRunner{bin: "ip6tables"})
+
+	// rules of ipv6 tree are applied by ip6tables only
+	mgr := NewManager6()
+	mgr.Init()
+	chain := mgr.GetChain("mangle", "PREROUTING")
+	err := chain.AppendRule(&CompleteRule{Action: TPROXY, BaseSl: []BaseRule{{Match: "p", Param: "tcp"}}})
+	if err != nil {
+		t.Fatalf("append rule failed, err: %v", err)
+	}
+	if len(fake.cmds) != 0 || strings.Join(fake6.cmds, "\n") != "-t mangle -A PREROUTING -j TPROXY -p tcp" {
+		t.Errorf("commands incorrect, iptables: %v, ip6tables: %v", fake.cmds, fake6.cmds)
+	}
+	if bin := (&execRunner{bin: "ip6tables"}).binary("-save"); bin != "ip6tables-save" {
+		t.Errorf("binary got %s, want ip6tables-save", bin)
+	}
+}
+
+func TestParseMarks(t *testing.T) {
+	out := `*mangle
+-A App -j MARK --set-xmark 0x1f90/0xffffffff
+-A PREROUTING -p tcp -m mark --mark 0x1f90 -j TPROXY --on-port 8080 --on-ip 0.0.0.0 --tproxy-mark 0x0/0x0
+-A OUTPUT -m connmark ! --mark 51820 -j ACCEPT
+COMMIT`
+	marks := parseMarks(out)
+	if len(marks) != 4 || marks[0] != 8080 || marks[1] != 8080 || marks[2] != 0 || marks[3] != 51820 {
+		t.Errorf("parse marks incorrect, marks: %v", marks)
+	}
+}
+
+func TestParseCounters(t *testing.T) {
+	out := `Chain OUTPUT (policy ACCEPT 10 packets, 600 bytes)
+    pkts      bytes target     prot opt in     out     source               destination
+      10      600 Main       all  --  *      *       0.0.0.0/0            0.0.0.0/0            /* deepin-proxy */
+       3      180 Other      all  --  *      *       0.0.0.0/0            0.0.0.0/0
+
+Chain Main (1 references)
+    pkts      bytes target     prot opt in     out     source               destination
+`
+	counters := parseCounters("mangle", out)
+	if len(counters["Main"]) != 0 {
+		t.Errorf("chain Main should have no rule, counters: %v", counters["Main"])
+	}
+	rules := counters["OUTPUT"]
+	if len(rules) != 2 || rules[0].Packets != 10 || rules[0].Bytes != 600 || rules[1].Rule != "Other all -- * * 0.0.0.0/0 0.0.0.0/0" {
+		t.Errorf("parse counters failed, counters: %v", rules)
+	}
+}
+
+func TestIPSetSync(t *testing.T) {
+	var data []string
+	defer func(run func(args []string, stdin string) ([]byte, error)) { ipsetRun = run }(ipsetRun)
+	ipsetRun = func(args []string, stdin string) ([]byte, error) {
+		data = append(data, stdin)
+		return nil, nil
+	}
+	set, err := CreateIPSet("App_bypass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = set.Sync([]string{"10.0.0.0/8", "192.168.0.0/16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := set.syncLines([]string{"10.0.0.0/8", "172.16.0.0/12"})
+	want := []string{"add App_bypass 172.16.0.0/12 -exist", "del App_bypass 192.168.0.0/16 -exist"}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("sync lines wrong, lines: %v", lines)
+	}
+	// nothing changed, ipset is not run
+	count := len(data)
+	_ = set.Sync([]string{"192.168.0.0/16", "10.0.0.0/8"})
+	if len(data) != count {
+		t.Errorf("ipset should not run when set not changed")
+	}
+	if _, err := CreateIPSet("ThisIPSetNameIsLongerThanTheLimit"); err == nil {
+		t.Errorf("long set name should be invalid")
+	}
+}
@@ -0,0 +1,176 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package NewIptables
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// if daemon crashes, rules installed persist and may black-hole traffic,
+// journal persists every chain and rule applied, so that they can be removed at next start
+
+// default journal path, /run is cleared at reboot, so does iptables
+const DefaultJournalPath = "/run/deepin-proxy/rules.json"
+
+// one chain or rule applied
+type journalEntry struct {
+	Table string `json:"table"`
+	Chain string `json:"chain"`
+	Rule  string `json:"rule,omitempty"` // empty means chain is created by self
+}
+
+// journal of applied chains and rules
+type Journal struct {
+	path string
+
+	lock    sync.Mutex
+	entries []journalEntry
+}
+
+func NewJournal(path string) *Journal {
+	return &Journal{
+		path:    path,
+		entries: []journalEntry{},
+	}
+}
+
+// record command run successfully
+func (j *Journal) record(operation Operation, table string, chain string, cpl *CompleteRule) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	switch operation {
+	case Append, Insert:
+		j.entries = append(j.entries, journalEntry{Table: table, Chain: chain, Rule: cpl.String()})
+	case New:
+		j.entries = append(j.entries, journalEntry{Table: table, Chain: chain})
+	case Delete:
+		j.remove(func(entry journalEntry) bool {
+			return entry.Table == table && entry.Chain == chain && entry.Rule == cpl.String()
+		}, true)
+	case Flush:
+		j.remove(func(entry journalEntry) bool {
+			return entry.Table == table && entry.Chain == chain && entry.Rule != ""
+		}, false)
+	case Remove:
+		j.remove(func(entry journalEntry) bool {
+			return entry.Table == table && entry.Chain == chain && entry.Rule == ""
+		}, true)
+	default:
+		return
+	}
+	j.save()
+}
+
+// record all rules of table tree, used after iptables-restore,
+// tree is the whole table applied, entries of table recorded before are replaced
+func (j *Journal) recordTable(t *Table) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.remove(func(entry journalEntry) bool {
+		return entry.Table == t.Name
+	}, false)
+	for _, cName := range tableSl[t.Name] {
+		chain, ok := t.chains[cName]
+		if !ok {
+			continue
+		}
+		j.recordChain(chain)
+	}
+	j.save()
+}
+
+// record chain and children
+func (j *Journal) recordChain(c *Chain) {
+	if c.parent != nil {
+		j.entries = append(j.entries, journalEntry{Table: c.table.Name, Chain: c.Name})
+	}
+	for _, cpl := range c.cplRuleSl {
+		j.entries = append(j.entries, journalEntry{Table: c.table.Name, Chain: c.Name, Rule: cpl.String()})
+	}
+	for _, name := range c.childrenNames() {
+		j.recordChain(c.children[name])
+	}
+}
+
+// remove matched entries, only the last one if once
+func (j *Journal) remove(match func(entry journalEntry) bool, once bool) {
+	for index := len(j.entries) - 1; index >= 0; index-- {
+		if !match(j.entries[index]) {
+			continue
+		}
+		j.entries = append(j.entries[:index], j.entries[index+1:]...)
+		if once {
+			return
+		}
+	}
+}
+
+// write journal to file, replace old one atomically
+func (j *Journal) save() {
+	buf, err := json.Marshal(j.entries)
+	if err != nil {
+		logger.Warningf("[journal] marshal journal failed, err: %v", err)
+		return
+	}
+	err = os.MkdirAll(filepath.Dir(j.path), 0755)
+	if err != nil {
+		logger.Warningf("[journal] create journal dir failed, err: %v", err)
+		return
+	}
+	tmp := j.path + ".tmp"
+	err = ioutil.WriteFile(tmp, buf, 0644)
+	if err != nil {
+		logger.Warningf("[journal] write journal failed, err: %v", err)
+		return
+	}
+	err = os.Rename(tmp, j.path)
+	if err != nil {
+		logger.Warningf("[journal] replace journal failed, err: %v", err)
+	}
+}
+
+// remove chains and rules left by last run, then remove journal
+func Recover(path string) error {
+	buf, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		logger.Warningf("[journal] read journal failed, err: %v", err)
+		return err
+	}
+	var entries []journalEntry
+	err = json.Unmarshal(buf, &entries)
+	if err != nil {
+		logger.Warningf("[journal] unmarshal journal failed, err: %v", err)
+		return err
+	}
+	// reverse order, rule jump to chain is removed before chain
+	for index := len(entries) - 1; index >= 0; index-- {
+		entry := entries[index]
+		var cmds []string
+		if entry.Rule != "" {
+			cmds = []string{"-" + Delete.ToString() + " " + entry.Chain + " " + entry.Rule}
+		} else {
+			cmds = []string{"-" + Flush.ToString() + " " + entry.Chain, "-" + Remove.ToString() + " " + entry.Chain}
+		}
+		for _, cmd := range cmds {
+			args := append([]string{"-t", entry.Table}, strings.Fields(cmd)...)
+			line := iptablesCmd(args...)
+			out, err := runner.Run(args)
+			if err != nil {
+				// rule may be removed already
+				logger.Debugf("[journal] recover command %s failed, out: %s, err: %v", line, string(out), err)
+			}
+		}
+	}
+	logger.Infof("[journal] recover from journal success, entries: %d", len(entries))
+	return os.Remove(path)
+}
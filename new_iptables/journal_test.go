@@ -0,0 +1,65 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package NewIptables
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestJournalRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy", "rules.json")
+	journal := NewJournal(path)
+	jump := &CompleteRule{Action: "Main"}
+	ret := &CompleteRule{Action: RETURN, BaseSl: []BaseRule{{Match: "o", Param: "lo"}}}
+	journal.record(New, "mangle", "Main", nil)
+	journal.record(Insert, "mangle", "OUTPUT", jump)
+	journal.record(Append, "mangle", "Main", ret)
+
+	var entries []journalEntry
+	buf, err := ioutil.ReadFile(path)
+	if err != nil || json.Unmarshal(buf, &entries) != nil || len(entries) != 3 {
+		t.Fatalf("journal is not persisted, err: %v, entries: %v", err, entries)
+	}
+	if entries[2].Chain != "Main" || entries[2].Rule != "-j RETURN -o lo" {
+		t.Errorf("journal entry incorrect, entry: %v", entries[2])
+	}
+
+	// release as chain remove does
+	journal.record(Delete, "mangle", "OUTPUT", jump)
+	journal.record(Flush, "mangle", "Main", nil)
+	journal.record(Remove, "mangle", "Main", nil)
+	if len(journal.entries) != 0 {
+		t.Errorf("journal should be empty after release, entries: %v", journal.entries)
+	}
+}
+
+func TestJournalRecordTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	journal := NewJournal(path)
+	mgr := NewManager()
+	mgr.Init()
+	mgr.SetDryRun(&[]string{})
+	mgr.Begin()
+	output := mgr.GetChain("mangle", "OUTPUT")
+	child, err := output.CreateChild("Main", 0, &CompleteRule{Action: "Main"})
+	if err != nil {
+		t.Fatalf("create child failed, err: %v", err)
+	}
+	_ = child.AppendRule(&CompleteRule{Action: RETURN, BaseSl: []BaseRule{{Match: "o", Param: "lo"}}})
+	journal.record(New, "nat", "App", nil)
+
+	// every commit records the whole table again
+	journal.recordTable(mgr.GetTable("mangle"))
+	journal.recordTable(mgr.GetTable("mangle"))
+	if len(journal.entries) != 4 {
+		t.Errorf("entries of table should be replaced, entries: %v", journal.entries)
+	}
+	if journal.entries[0].Table != "nat" {
+		t.Errorf("entries of other table should be kept, entries: %v", journal.entries)
+	}
+}
@@ -0,0 +1,81 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package NewIptables
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+// rule tree is changed by dbus calls, reconcile ticker and signal handlers at the same time.
+// chain operations call each other, undo steps and transactions call chain operations again,
+// so lock of tree is held by goroutine and taken again by the same goroutine without waiting.
+
+type treeLock struct {
+	lock  sync.Mutex
+	cond  *sync.Cond
+	owner uint64
+	depth int
+}
+
+func newTreeLock() *treeLock {
+	l := &treeLock{}
+	l.cond = sync.NewCond(&l.lock)
+	return l
+}
+
+// lock tree, wait other goroutine releases it
+func (l *treeLock) Lock() {
+	if l == nil {
+		return
+	}
+	id := goroutineID()
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for l.depth != 0 && l.owner != id {
+		l.cond.Wait()
+	}
+	l.owner = id
+	l.depth++
+}
+
+// unlock tree, released when the outermost lock returns
+func (l *treeLock) Unlock() {
+	if l == nil {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.depth--
+	if l.depth == 0 {
+		l.owner = 0
+		l.cond.Broadcast()
+	}
+}
+
+// id of current goroutine, read from header of stack "goroutine 18 [running]:"
+func goroutineID() uint64 {
+	var buf [64]byte
+	n := runtime.Stack(buf[:], false)
+	fields := bytes.Fields(buf[:n])
+	if len(fields) < 2 {
+		return 0
+	}
+	id, _ := strconv.ParseUint(string(fields[1]), 10, 64)
+	return id
+}
+
+// lock rule tree of all tables, chain operations called by holder do not wait,
+// used to keep several operations atomic to other goroutines
+func (m *Manager) Lock() {
+	m.lock.Lock()
+}
+
+// unlock rule tree
+func (m *Manager) Unlock() {
+	m.lock.Unlock()
+}
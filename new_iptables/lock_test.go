@@ -0,0 +1,47 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package NewIptables
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestTreeLock(t *testing.T) {
+	lines := []string{}
+	manager := NewManager()
+	manager.Init()
+	manager.SetDryRun(&lines)
+	output := manager.GetChain("mangle", "OUTPUT")
+
+	// holder runs chain operations without waiting self
+	manager.Lock()
+	child, err := output.CreateChild("App", 0, &CompleteRule{Action: "App"})
+	manager.Unlock()
+	if err != nil {
+		t.Fatalf("create child failed, err: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for index := 0; index < 10; index++ {
+		wg.Add(2)
+		go func(index int) {
+			defer wg.Done()
+			mark := &CompleteRule{Action: MARK, BaseSl: []BaseRule{{Match: "-set-mark", Param: strconv.Itoa(index)}}}
+			_ = manager.Transaction(func() error {
+				return child.AppendRule(mark)
+			})
+		}(index)
+		go func() {
+			defer wg.Done()
+			_ = manager.RestoreString(true)
+		}()
+	}
+	wg.Wait()
+	if count := child.GetRulesCount(); count != 10 {
+		t.Errorf("rules count is %d, want 10", count)
+	}
+}
@@ -4,7 +4,7 @@
 
 package NewIptables
 
-import "github.com/linuxdeepin/go-lib/log"
+import logging "github.com/linuxdeepin/deepin-network-proxy/logging"
 
 /*
 	Iptables module extends
@@ -12,12 +12,12 @@ import "github.com/linuxdeepin/go-lib/log"
 	2. transparent proxy (now support)
 	3. firewall (now support)
 	4. ipv4 (now support)       // iptables    may use nf_tables
-	5. ipv6 (not support yet)   // ip6tables   may use nf_tables
+	5. ipv6 (now support)       // ip6tables   may use nf_tables, manager of ipv6 is created by NewManager6
 */
 
 // https://linux.die.net/man/8/iptables
 
-var logger *log.Logger
+var logger *logging.Logger
 
 var tableSl = map[string][]string{
 	"raw": []string{
@@ -45,16 +45,33 @@ var tableSl = map[string][]string{
 
 type Manager struct {
 	tables map[string]*Table
+	// rules are applied by ip6tables
+	ipv6 bool
+	// lock of rule tree, transactions and chain operations of all tables run under it
+	lock *treeLock
 }
 
 // create manager
 func NewManager() *Manager {
 	manager := &Manager{
 		tables: make(map[string]*Table),
+		lock:   newTreeLock(),
 	}
 	return manager
 }
 
+// create manager of ip6tables, rules of ipv6 are kept in their own tree
+func NewManager6() *Manager {
+	manager := NewManager()
+	manager.ipv6 = true
+	return manager
+}
+
+// backend of family of manager
+func (m *Manager) runner() Runner {
+	return familyRunner(m.ipv6)
+}
+
 // init table
 func (m *Manager) Init() {
 	logger.Debug("init manager")
@@ -62,30 +79,67 @@ func (m *Manager) Init() {
 	for tName, cNameSl := range tableSl {
 		// create tables to manager
 		table := &Table{
-			Name:   tName,
-			chains: make(map[string]*Chain),
+			Name: tName,
+			ipv6: m.ipv6,
+			lock: m.lock,
 		}
 		// create chain to table
-		for _, cName := range cNameSl {
-			// default chain dont need to create
-			chain := &Chain{
-				Name:      cName,
-				table:     table,
-				children:  make(map[string]*Chain),
-				cplRuleSl: []*CompleteRule{},
-			}
-			// add chain to table
-			table.chains[cName] = chain
-			logger.Debugf("[%s] add default chain %s", tName, cName)
-		}
+		table.resetChains(cNameSl)
 		// add table to manager
 		m.tables[tName] = table
 	}
 	return
 }
 
+// drop all chains and rules in tree, only default chains are left
+func (t *Table) resetChains(cNameSl []string) {
+	t.chains = make(map[string]*Chain)
+	for _, cName := range cNameSl {
+		// default chain dont need to create
+		chain := &Chain{
+			Name:      cName,
+			table:     t,
+			children:  make(map[string]*Chain),
+			cplRuleSl: []*CompleteRule{},
+		}
+		// add chain to table
+		t.chains[cName] = chain
+		logger.Debugf("[%s] add default chain %s", t.Name, cName)
+	}
+}
+
+// persist applied rules to journal
+func (m *Manager) SetJournal(journal *Journal) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, table := range m.tables {
+		table.journal = journal
+	}
+}
+
+// record command lines to lines instead of running them, used to preview rules
+func (m *Manager) SetDryRun(lines *[]string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, table := range m.tables {
+		table.dryRun = lines
+	}
+}
+
+// get table
+func (m *Manager) GetTable(tName string) *Table {
+	table, ok := m.tables[tName]
+	if !ok {
+		logger.Warningf("[%s] get table %s not exist", "manager", tName)
+		return nil
+	}
+	return table
+}
+
 // get chain, usually use to get default chain
 func (m *Manager) GetChain(tName string, cName string) *Chain {
+	m.lock.Lock()
+	defer m.lock.Unlock()
 	// get table
 	table, ok := m.tables[tName]
 	if !ok {
@@ -104,5 +158,5 @@ func (m *Manager) GetChain(tName string, cName string) *Chain {
 
 // init
 func init() {
-	logger = log.NewLogger("proxy/iptables")
+	logger = logging.New("proxy/iptables")
 }
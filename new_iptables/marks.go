@@ -0,0 +1,46 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package NewIptables
+
+import (
+	"strconv"
+	"strings"
+)
+
+// marks set or matched by rules of table, include rules of other tools
+func UsedMarks(table string) ([]uint32, error) {
+	buf, err := runner.Save(table)
+	if err != nil {
+		logger.Warningf("[%s] run iptables-save failed, err: %v", table, err)
+		return nil, err
+	}
+	return parseMarks(string(buf)), nil
+}
+
+// parse marks from output of iptables-save
+// -A OUTPUT -j MARK --set-xmark 0x1f90/0xffffffff
+// -A PREROUTING -m mark --mark 0x1f90 -j TPROXY --on-port 8080
+func parseMarks(out string) []uint32 {
+	var marks []uint32
+	fields := strings.Fields(out)
+	for index, field := range fields {
+		if index+1 >= len(fields) {
+			break
+		}
+		switch field {
+		case "--set-mark", "--set-xmark", "--mark", "--tproxy-mark":
+		default:
+			continue
+		}
+		// value/mask
+		value := strings.SplitN(fields[index+1], "/", 2)[0]
+		mark, err := strconv.ParseUint(value, 0, 32)
+		if err != nil {
+			continue
+		}
+		marks = append(marks, uint32(mark))
+	}
+	return marks
+}
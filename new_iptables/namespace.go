@@ -0,0 +1,194 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package NewIptables
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// chains of other services may have the same name, rules of daemon are tagged with comment,
+// so that chain owner can be checked, and orphan rules left by daemon can be found reliably
+
+// comment tagged to rules of daemon
+const Comment = "deepin-proxy"
+
+// max length of chain name, XT_EXTENSION_MAXNAMELEN - 1
+const ChainNameMax = 28
+
+// tag all rules added later with comment
+func (m *Manager) SetComment(comment string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, table := range m.tables {
+		table.comment = comment
+	}
+}
+
+// -m comment --comment deepin-proxy
+func CommentRule(comment string) ExtendsRule {
+	return ExtendsRule{
+		Match: "m",
+		Elem: ExtendsElem{
+			Match: "comment",
+			Base:  BaseRule{Match: "comment", Param: comment},
+		},
+	}
+}
+
+// copy rule with comment, rule already tagged is returned directly
+func (t *Table) tag(cpl *CompleteRule) *CompleteRule {
+	if t.comment == "" || cpl == nil {
+		return cpl
+	}
+	tag := CommentRule(t.comment)
+	if count := len(cpl.ExtendsSl); count != 0 && cpl.ExtendsSl[count-1] == tag {
+		return cpl
+	}
+	extendsSl := append([]ExtendsRule{}, cpl.ExtendsSl...)
+	return &CompleteRule{
+		Action:    cpl.Action,
+		BaseSl:    cpl.BaseSl,
+		ExtendsSl: append(extendsSl, tag),
+	}
+}
+
+// check chain name
+func CheckChainName(name string) error {
+	if name == "" || len(name) > ChainNameMax {
+		return fmt.Errorf("chain name %q length is invalid", name)
+	}
+	if strings.ContainsAny(name, " !") || strings.HasPrefix(name, "-") {
+		return fmt.Errorf("chain name %q is invalid", name)
+	}
+	return nil
+}
+
+// check if chain exists in kernel and is created by owner of comment,
+// chain is owned if any rule jumps to it or in it is tagged
+func ChainOwner(table string, chain string, comment string) (bool, bool, error) {
+	buf, err := runner.Save(table)
+	if err != nil {
+		logger.Warningf("[%s] run iptables-save failed, err: %v", table, err)
+		return false, false, err
+	}
+	exist, owned := chainOwner(parseSave(string(buf)), chain, comment)
+	return exist, owned, nil
+}
+
+func chainOwner(live map[string][]string, chain string, comment string) (bool, bool) {
+	if _, ok := live[chain]; !ok {
+		return false, false
+	}
+	tag := CommentRule(comment)
+	tagStr := tag.String()
+	for name, rules := range live {
+		for _, rule := range rules {
+			if !strings.Contains(rule, tagStr) {
+				continue
+			}
+			if name == chain || jumpTarget(rule) == chain {
+				return true, true
+			}
+		}
+	}
+	return true, false
+}
+
+// target of rule from iptables-save
+func jumpTarget(rule string) string {
+	fields := strings.Fields(rule)
+	for index, field := range fields {
+		if (field == "-j" || field == "-g") && index+1 < len(fields) {
+			return fields[index+1]
+		}
+	}
+	return ""
+}
+
+// commands to remove rules tagged with comment and chains owned by comment, left when daemon crashed
+func orphanCommands(live map[string][]string, comment string) [][]string {
+	tag := CommentRule(comment)
+	tagStr := tag.String()
+	var cmds [][]string
+	owned := make(map[string]bool)
+	for name, rules := range live {
+		for _, rule := range rules {
+			if !strings.Contains(rule, tagStr) {
+				continue
+			}
+			if target := jumpTarget(rule); target != "" {
+				if _, ok := live[target]; ok && !isDefaultTarget(target) {
+					owned[target] = true
+				}
+			}
+			// rule in owned chain is flushed with chain
+			cmds = append(cmds, append([]string{"-" + Delete.ToString(), name}, strings.Fields(rule)...))
+		}
+	}
+	var chains []string
+	for name := range owned {
+		chains = append(chains, name)
+	}
+	sort.Strings(chains)
+	// all chains are flushed before removed, as owned chains may jump to each other
+	for _, name := range chains {
+		cmds = append(cmds, []string{"-" + Flush.ToString(), name})
+	}
+	for _, name := range chains {
+		cmds = append(cmds, []string{"-" + Remove.ToString(), name})
+	}
+	return cmds
+}
+
+// builtin target and default chain are never removed
+func isDefaultTarget(target string) bool {
+	switch target {
+	case ACCEPT, DROP, RETURN, QUEUE, REDIRECT, TPROXY, MARK, CONNMARK, TCPMSS, "REJECT", "DNAT", "SNAT", "MASQUERADE", "LOG":
+		return true
+	}
+	for _, chains := range tableSl {
+		for _, chain := range chains {
+			if chain == target {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// remove orphan rules and chains tagged with comment, return count of commands succeed
+func CleanOrphans(table string, comment string) (int, error) {
+	return cleanOrphans(false, table, comment)
+}
+
+// remove orphan rules and chains of ip6tables tagged with comment, rules of ipv6 have no journal
+func CleanOrphans6(table string, comment string) (int, error) {
+	return cleanOrphans(true, table, comment)
+}
+
+func cleanOrphans(ipv6 bool, table string, comment string) (int, error) {
+	buf, err := familyRunner(ipv6).Save(table)
+	if err != nil {
+		logger.Warningf("[%s] run iptables-save failed, err: %v", table, err)
+		return 0, err
+	}
+	count := 0
+	for _, cmd := range orphanCommands(parseSave(string(buf)), comment) {
+		args := append([]string{"-t", table}, cmd...)
+		out, err := familyRunner(ipv6).Run(args)
+		if err != nil {
+			// rule in chain may be flushed already
+			logger.Debugf("[%s] clean orphan %s failed, out: %s, err: %v", table, familyCmd(ipv6, args...), string(out), err)
+			continue
+		}
+		count++
+	}
+	if count != 0 {
+		logger.Infof("[%s] clean orphan rules and chains success, count: %d", table, count)
+	}
+	return count, nil
+}
@@ -0,0 +1,70 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package NewIptables
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTagRule(t *testing.T) {
+	table := &Table{Name: "mangle", comment: Comment}
+	cpl := &CompleteRule{Action: RETURN, BaseSl: []BaseRule{{Match: "o", Param: "lo"}}}
+	tagged := table.tag(cpl)
+	if tagged.String() != "-j RETURN -o lo -m comment --comment deepin-proxy" {
+		t.Errorf("tag rule failed, rule: %s", tagged.String())
+	}
+	if len(cpl.ExtendsSl) != 0 {
+		t.Errorf("origin rule should not be changed, rule: %s", cpl.String())
+	}
+	if again := table.tag(tagged); !reflect.DeepEqual(again, tagged) {
+		t.Errorf("tag should be idempotent, rule: %s", again.String())
+	}
+}
+
+func TestCheckChainName(t *testing.T) {
+	for _, name := range []string{"Main", "deepin_App"} {
+		if err := CheckChainName(name); err != nil {
+			t.Errorf("chain name %s should be valid, err: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "-App", "my App", "!App", "ThisChainNameIsLongerThanTheLimit"} {
+		if err := CheckChainName(name); err == nil {
+			t.Errorf("chain name %q should be invalid", name)
+		}
+	}
+}
+
+func TestOrphanCommands(t *testing.T) {
+	out := `*mangle
+:OUTPUT ACCEPT [0:0]
+:Main - [0:0]
+:App - [0:0]
+:Other - [0:0]
+-A OUTPUT -m comment --comment deepin-proxy -j Main
+-A OUTPUT -j Other
+-A Main -p tcp -m cgroup --path App.slice -m comment --comment deepin-proxy -j App
+-A App -j MARK --set-xmark 0x1f9a/0xffffffff
+COMMIT
+`
+	live := parseSave(out)
+	if exist, owned := chainOwner(live, "Main", Comment); !exist || !owned {
+		t.Errorf("chain Main should be owned")
+	}
+	if exist, owned := chainOwner(live, "Other", Comment); !exist || owned {
+		t.Errorf("chain Other should not be owned")
+	}
+	if exist, _ := chainOwner(live, "Global", Comment); exist {
+		t.Errorf("chain Global should not exist")
+	}
+	cmds := orphanCommands(live, Comment)
+	if len(cmds) != 6 {
+		t.Fatalf("orphan commands count wrong, commands: %v", cmds)
+	}
+	want := [][]string{{"-F", "App"}, {"-F", "Main"}, {"-X", "App"}, {"-X", "Main"}}
+	if !reflect.DeepEqual(cmds[2:], want) {
+		t.Errorf("orphan chains commands wrong, commands: %v", cmds[2:])
+	}
+}
@@ -0,0 +1,31 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package NewIptables
+
+import "strings"
+
+// temp chain to probe target, removed after probe
+const probeChain = "Probe"
+
+// check if kernel supports target of rule, by appending rule to a temp chain
+func ProbeRule(table string, cpl *CompleteRule) bool {
+	buf, err := runner.Run([]string{"-t", table, "-" + New.ToString(), probeChain})
+	if err != nil {
+		logger.Warningf("[%s] create probe chain failed, out: %s, err: %v", table, string(buf), err)
+		return false
+	}
+	// remove temp chain whatever probe result is
+	defer func() {
+		_, _ = runner.Run([]string{"-t", table, "-" + Flush.ToString(), probeChain})
+		_, _ = runner.Run([]string{"-t", table, "-" + Remove.ToString(), probeChain})
+	}()
+	args := append([]string{"-t", table, "-" + Append.ToString(), probeChain}, strings.Fields(cpl.String())...)
+	buf, err = runner.Run(args)
+	if err != nil {
+		logger.Debugf("[%s] probe rule %s failed, out: %s, err: %v", table, cpl.String(), string(buf), err)
+		return false
+	}
+	return true
+}
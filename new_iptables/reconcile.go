@@ -0,0 +1,127 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package NewIptables
+
+import (
+	"strings"
+)
+
+// rules recorded in memory may drift from kernel, if other tool such as firewall flushes chains,
+// reconcile reads live rules and re-applies the missing ones
+
+// read live chains and rules of table from iptables-save, map[chain][]rule
+func (t *Table) save() (map[string][]string, error) {
+	buf, err := t.runner().Save(t.Name)
+	if err != nil {
+		logger.Warningf("[%s] run iptables-save failed, err: %v", t.Name, err)
+		return nil, err
+	}
+	return parseSave(string(buf)), nil
+}
+
+// parse output of iptables-save
+func parseSave(out string) map[string][]string {
+	/*
+		*mangle
+		:PREROUTING ACCEPT [0:0]
+		:Main - [0:0]
+		-A OUTPUT -j Main
+		COMMIT
+	*/
+	live := make(map[string][]string)
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(fields[0], ":"):
+			name := strings.TrimPrefix(fields[0], ":")
+			if _, ok := live[name]; !ok {
+				live[name] = []string{}
+			}
+		case fields[0] == "-A" && len(fields) > 1:
+			live[fields[1]] = append(live[fields[1]], strings.Join(fields[2:], " "))
+		}
+	}
+	return live
+}
+
+// check if rule exist in kernel, iptables normalizes rule so text of rule is not compared directly
+func (t *Table) checkRule(chain *Chain, cpl *CompleteRule) bool {
+	args := append([]string{"-t", t.Name, "-" + Check.ToString(), chain.Name}, strings.Fields(cpl.String())...)
+	_, err := t.runner().Run(args)
+	return err == nil
+}
+
+// re-apply missing chains and rules of table, return count of repaired chains and rules
+func (t *Table) Reconcile() (int, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	live, err := t.save()
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	for _, chain := range t.chains {
+		// children are reconciled by parent
+		if chain.parent != nil {
+			continue
+		}
+		count += chain.reconcile(live)
+	}
+	if count != 0 {
+		logger.Infof("[%s] reconcile table success, repaired: %d", t.Name, count)
+	}
+	return count, nil
+}
+
+// re-apply missing chain and rules, children first as rule may jump to child
+func (c *Chain) reconcile(live map[string][]string) int {
+	var count int
+	if _, ok := live[c.Name]; !ok {
+		err := c.table.runCommand(New, c, 0, nil)
+		if err != nil {
+			logger.Warningf("[%s] reconcile chain %s failed, err: %v", c.table.Name, c.Name, err)
+			return count
+		}
+		count++
+	}
+	for _, child := range c.children {
+		count += child.reconcile(live)
+	}
+	// chain is flushed or recreated, all rules are missing
+	flushed := len(live[c.Name]) == 0
+	for index, cpl := range c.cplRuleSl {
+		if !flushed && c.table.checkRule(c, cpl) {
+			continue
+		}
+		// keep the same position as recorded
+		err := c.table.runCommand(Insert, c, index+1, cpl)
+		if err != nil {
+			logger.Warningf("[%s] reconcile chain %s rule %s failed, err: %v", c.table.Name, c.Name, cpl.String(), err)
+			continue
+		}
+		count++
+	}
+	return count
+}
+
+// reconcile all tables, return count of repaired chains and rules, and last error of tables failed
+func (m *Manager) Reconcile() (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var count int
+	var lastErr error
+	for _, table := range m.tables {
+		repaired, err := table.Reconcile()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		count += repaired
+	}
+	return count, lastErr
+}
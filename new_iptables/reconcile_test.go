@@ -0,0 +1,30 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package NewIptables
+
+import "testing"
+
+func TestParseSave(t *testing.T) {
+	out := `# Generated by iptables-save
+*mangle
+:PREROUTING ACCEPT [0:0]
+:OUTPUT ACCEPT [0:0]
+:Main - [0:0]
+-A OUTPUT -j Main
+-A Main -o lo -j RETURN
+-A Main -m cgroup --path main.slice -j RETURN
+COMMIT
+`
+	live := parseSave(out)
+	if len(live) != 3 {
+		t.Errorf("parse chains failed, chains: %v", live)
+	}
+	if rules, ok := live["PREROUTING"]; !ok || len(rules) != 0 {
+		t.Errorf("empty chain should exist, rules: %v", rules)
+	}
+	if rules := live["Main"]; len(rules) != 2 || rules[0] != "-o lo -j RETURN" {
+		t.Errorf("parse rules failed, rules: %v", rules)
+	}
+}
@@ -0,0 +1,152 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package NewIptables
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// apply rules one exec per rule is slow, and other tool may change rules between two exec.
+// in batch mode, chain operations only update rule tree in memory,
+// commit serializes the tree into iptables-restore format and applies it in one invocation.
+
+// start batch mode, commands are not executed until commit
+func (m *Manager) Begin() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, table := range m.tables {
+		table.batch = true
+	}
+}
+
+// apply rule tree by iptables-restore and stop batch mode,
+// without noflush, all rules of table not in tree are removed,
+// with noflush, self create chains are flushed and refilled, rules of default chains are appended,
+// so default chains should not contain rules of tree before commit
+func (m *Manager) Commit(noflush bool) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, table := range m.tables {
+		table.batch = false
+	}
+	data := m.RestoreString(noflush)
+	if data == "" {
+		return nil
+	}
+	logger.Debugf("[manager] begin to run iptables-restore, data:\n%s", data)
+	buf, err := m.runner().Restore(data, noflush)
+	if err != nil {
+		atomic.AddUint64(&cmdFailures, 1)
+		logger.Warningf("[manager] run iptables-restore failed, out: %s, err: %v", string(buf), err)
+		return errors.New(strings.TrimSpace(string(buf)))
+	}
+	logger.Debug("[manager] run iptables-restore success")
+	for _, table := range m.tables {
+		if table.journal != nil && table.restoreLines(noflush) != nil {
+			table.journal.recordTable(table)
+		}
+	}
+	return nil
+}
+
+// stop batch mode without applying, or drop tree failed to apply,
+// batch mode builds tree from empty, so tables are reset to default chains as kernel has no rules of tree
+func (m *Manager) Rollback() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for tName, table := range m.tables {
+		table.batch = false
+		table.resetChains(tableSl[tName])
+	}
+}
+
+// serialize all tables into iptables-restore format, tables without rules are ignored
+func (m *Manager) RestoreString(noflush bool) string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var sl []string
+	// keep tables in stable order
+	for _, tName := range []string{"raw", "mangle", "nat", "filter"} {
+		table, ok := m.tables[tName]
+		if !ok {
+			continue
+		}
+		sl = append(sl, table.restoreLines(noflush)...)
+	}
+	if len(sl) == 0 {
+		return ""
+	}
+	return strings.Join(sl, "\n") + "\n"
+}
+
+// serialize table
+func (t *Table) restoreLines(noflush bool) []string {
+	/*
+		*mangle
+		:OUTPUT ACCEPT [0:0]
+		:Main - [0:0]
+		-A OUTPUT -j Main
+		-A Main -o lo -j RETURN
+		COMMIT
+	*/
+	// table without rules and self create chains is not touched
+	if len(t.chains) == len(tableSl[t.Name]) && t.rulesCount() == 0 {
+		return nil
+	}
+	var chains, rules []string
+	// default chains first, children follow parent
+	for _, cName := range tableSl[t.Name] {
+		chain, ok := t.chains[cName]
+		if !ok {
+			continue
+		}
+		chain.restoreLines(noflush, &chains, &rules)
+	}
+	sl := []string{"*" + t.Name}
+	sl = append(sl, chains...)
+	sl = append(sl, rules...)
+	sl = append(sl, "COMMIT")
+	return sl
+}
+
+// count of rules in all chains
+func (t *Table) rulesCount() int {
+	var count int
+	for _, chain := range t.chains {
+		count += len(chain.cplRuleSl)
+	}
+	return count
+}
+
+// serialize chain and children, declaration of self create chain flushes it
+func (c *Chain) restoreLines(noflush bool, chains *[]string, rules *[]string) {
+	if c.parent != nil {
+		*chains = append(*chains, fmt.Sprintf(":%s - [0:0]", c.Name))
+	} else if !noflush {
+		// default chain is flushed only without noflush, policy keeps default
+		*chains = append(*chains, fmt.Sprintf(":%s ACCEPT [0:0]", c.Name))
+	}
+	for _, cpl := range c.cplRuleSl {
+		*rules = append(*rules, fmt.Sprintf("-A %s %s", c.Name, cpl.String()))
+	}
+	// sort children by name, in case declaration order changes every time
+	for _, name := range c.childrenNames() {
+		c.children[name].restoreLines(noflush, chains, rules)
+	}
+}
+
+// names of children in order
+func (c *Chain) childrenNames() []string {
+	names := make([]string, 0, len(c.children))
+	for name := range c.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,55 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package NewIptables
+
+import "testing"
+
+func TestRestoreString(t *testing.T) {
+	mgr := NewManager()
+	mgr.Init()
+	mgr.Begin()
+	output := mgr.GetChain("mangle", "OUTPUT")
+	main, err := output.CreateChild("Main", 0, &CompleteRule{Action: "Main"})
+	if err != nil {
+		t.Fatalf("create child in batch mode failed, err: %v", err)
+	}
+	err = main.AppendRule(&CompleteRule{Action: RETURN, BaseSl: []BaseRule{{Match: "o", Param: "lo"}}})
+	if err != nil {
+		t.Fatalf("append rule in batch mode failed, err: %v", err)
+	}
+	want := "*mangle\n:Main - [0:0]\n-A OUTPUT -j Main\n-A Main -j RETURN -o lo\nCOMMIT\n"
+	if data := mgr.RestoreString(true); data != want {
+		t.Errorf("restore string with noflush incorrect, data:\n%s", data)
+	}
+	// default chains are declared without noflush
+	want = "*mangle\n:PREROUTING ACCEPT [0:0]\n:INPUT ACCEPT [0:0]\n:FORWARD ACCEPT [0:0]\n:OUTPUT ACCEPT [0:0]\n" +
+		":Main - [0:0]\n:POSTROUTING ACCEPT [0:0]\n-A OUTPUT -j Main\n-A Main -j RETURN -o lo\nCOMMIT\n"
+	if data := mgr.RestoreString(false); data != want {
+		t.Errorf("restore string incorrect, data:\n%s", data)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	lines := []string{}
+	mgr := NewManager()
+	mgr.Init()
+	mgr.SetDryRun(&lines)
+	mgr.Begin()
+	output := mgr.GetChain("mangle", "OUTPUT")
+	_, err := output.CreateChild("Main", 0, &CompleteRule{Action: "Main"})
+	if err != nil {
+		t.Fatalf("create child in batch mode failed, err: %v", err)
+	}
+	mgr.Rollback()
+	if data := mgr.RestoreString(true); data != "" {
+		t.Errorf("tree should be dropped, data:\n%s", data)
+	}
+	// commands run at once after batch mode stops
+	output = mgr.GetChain("mangle", "OUTPUT")
+	err = output.AppendRule(&CompleteRule{Action: RETURN, BaseSl: []BaseRule{{Match: "o", Param: "lo"}}})
+	if err != nil || len(lines) != 1 {
+		t.Errorf("command should run after rollback, err: %v, commands: %v", err, lines)
+	}
+}
@@ -4,7 +4,11 @@
 
 package NewIptables
 
-import "strings"
+import (
+	"os/user"
+	"strconv"
+	"strings"
+)
 
 // define operation
 type Operation int
@@ -17,6 +21,7 @@ const (
 	Remove
 	Policy
 	Flush
+	Check
 )
 
 func (a Operation) ToString() string {
@@ -35,6 +40,8 @@ func (a Operation) ToString() string {
 		return "P"
 	case Flush:
 		return "F"
+	case Check:
+		return "C"
 	default:
 		return ""
 	}
@@ -49,13 +56,15 @@ const (
 	REDIRECT = "REDIRECT"
 	TPROXY   = "TPROXY"
 	MARK     = "MARK"
+	CONNMARK = "CONNMARK"
+	TCPMSS   = "TCPMSS"
 )
 
 // base rule
 type BaseRule struct {
-	Not   bool   // !
-	Match string // -s
-	Param string // 1111.2222.3333.4444
+	Not   bool   `json:"not,omitempty"`   // !
+	Match string `json:"match"`           // -s
+	Param string `json:"param,omitempty"` // 1111.2222.3333.4444
 }
 
 // make string  -s 1111.2222.3333.4444
@@ -65,14 +74,18 @@ func (bs *BaseRule) String() string {
 	if bs.Not {
 		sl = append(sl, "!")
 	}
-	sl = append(sl, "-"+bs.Match, bs.Param)
+	sl = append(sl, "-"+bs.Match)
+	// some target option has no param, like --save-mark
+	if bs.Param != "" {
+		sl = append(sl, bs.Param)
+	}
 	return strings.Join(sl, " ")
 }
 
 // extends elem
 type ExtendsElem struct {
-	Match string   // mark
-	Base  BaseRule // --mark 1
+	Match string   `json:"match"` // mark
+	Base  BaseRule `json:"base"`  // --mark 1
 }
 
 // make string    mark --mark 1
@@ -87,8 +100,8 @@ func (elem *ExtendsElem) String() string {
 
 // extends rule
 type ExtendsRule struct {
-	Match string      // -m
-	Elem  ExtendsElem // mark --mark 1
+	Match string      `json:"match"` // -m
+	Elem  ExtendsElem `json:"elem"`  // mark --mark 1
 }
 
 // make string   -m mark --mark 1
@@ -99,9 +112,9 @@ func (ex *ExtendsRule) String() string {
 
 // one complete rule
 type CompleteRule struct {
-	Action    string
-	BaseSl    []BaseRule
-	ExtendsSl []ExtendsRule
+	Action    string        `json:"action"`
+	BaseSl    []BaseRule    `json:"base,omitempty"`
+	ExtendsSl []ExtendsRule `json:"extends,omitempty"`
 }
 
 // make string        -j ACCEPT -s 1111.2222.3333.4444 -m mark --mark 1
@@ -118,3 +131,67 @@ func (cpl *CompleteRule) String() string {
 	}
 	return strings.Join(sl, " ")
 }
+
+// owner match is only valid in OUTPUT and POSTROUTING chain, as only local process owns socket
+
+// make rule   -m owner --uid-owner 1000, uid can be user name, id or id range like 1000-2000
+func OwnerUidRule(uid string, not bool) ExtendsRule {
+	return ExtendsRule{
+		Match: "m",
+		Elem: ExtendsElem{
+			Match: "owner",
+			Base:  BaseRule{Not: not, Match: "uid-owner", Param: uid},
+		},
+	}
+}
+
+// make rule   -m owner --gid-owner 1000, gid can be group name, id or id range like 1000-2000
+func OwnerGidRule(gid string, not bool) ExtendsRule {
+	return ExtendsRule{
+		Match: "m",
+		Elem: ExtendsElem{
+			Match: "owner",
+			Base:  BaseRule{Not: not, Match: "gid-owner", Param: gid},
+		},
+	}
+}
+
+// make uid owner rule of user name, user is checked so that iptables wont fail later
+func OwnerUserRule(name string, not bool) (ExtendsRule, error) {
+	usr, err := user.Lookup(name)
+	if err != nil {
+		return ExtendsRule{}, err
+	}
+	return OwnerUidRule(usr.Uid, not), nil
+}
+
+// make gid owner rule of group name
+func OwnerGroupRule(name string, not bool) (ExtendsRule, error) {
+	grp, err := user.LookupGroup(name)
+	if err != nil {
+		return ExtendsRule{}, err
+	}
+	return OwnerGidRule(grp.Gid, not), nil
+}
+
+// make rule   -m mark --mark 1024
+func MarkRule(mark uint32, not bool) ExtendsRule {
+	return ExtendsRule{
+		Match: "m",
+		Elem: ExtendsElem{
+			Match: "mark",
+			Base:  BaseRule{Not: not, Match: "mark", Param: strconv.FormatUint(uint64(mark), 10)},
+		},
+	}
+}
+
+// make rule   -p tcp --tcp-flags SYN,RST SYN, matches syn and syn ack
+func SynRule() ExtendsRule {
+	return ExtendsRule{
+		Match: "p",
+		Elem: ExtendsElem{
+			Match: "tcp",
+			Base:  BaseRule{Match: "tcp-flags", Param: "SYN,RST SYN"},
+		},
+	}
+}
@@ -0,0 +1,149 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package NewIptables
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// rule tree can be exported as json, so that support tool can capture rules installed by daemon,
+// and replicate them on another machine for debugging
+
+// chain of table in json, parent is empty for default chain
+type chainJSON struct {
+	Name   string          `json:"name"`
+	Parent string          `json:"parent,omitempty"`
+	Rules  []*CompleteRule `json:"rules"`
+}
+
+// table in json, parent chain is always before children
+type tableJSON struct {
+	Name   string      `json:"name"`
+	Chains []chainJSON `json:"chains"`
+}
+
+// marshal table with full chain tree
+func (t *Table) MarshalJSON() ([]byte, error) {
+	tj := tableJSON{
+		Name:   t.Name,
+		Chains: []chainJSON{},
+	}
+	for _, cName := range tableSl[t.Name] {
+		chain, ok := t.chains[cName]
+		if !ok {
+			continue
+		}
+		chain.marshalJSON(&tj.Chains)
+	}
+	return json.Marshal(tj)
+}
+
+// add chain and children
+func (c *Chain) marshalJSON(chains *[]chainJSON) {
+	cj := chainJSON{
+		Name:  c.Name,
+		Rules: c.cplRuleSl,
+	}
+	if cj.Rules == nil {
+		cj.Rules = []*CompleteRule{}
+	}
+	if c.parent != nil {
+		cj.Parent = c.parent.Name
+	}
+	*chains = append(*chains, cj)
+	for _, name := range c.childrenNames() {
+		c.children[name].marshalJSON(chains)
+	}
+}
+
+// unmarshal table, rebuild chain tree, chains not in json are removed
+func (t *Table) UnmarshalJSON(data []byte) error {
+	var tj tableJSON
+	err := json.Unmarshal(data, &tj)
+	if err != nil {
+		return err
+	}
+	if _, ok := tableSl[tj.Name]; !ok {
+		return fmt.Errorf("table %s not support", tj.Name)
+	}
+	t.Name = tj.Name
+	t.chains = make(map[string]*Chain)
+	// default chains always exist
+	for _, cName := range tableSl[t.Name] {
+		t.chains[cName] = &Chain{
+			Name:      cName,
+			table:     t,
+			children:  make(map[string]*Chain),
+			cplRuleSl: []*CompleteRule{},
+		}
+	}
+	for _, cj := range tj.Chains {
+		chain, ok := t.chains[cj.Name]
+		if cj.Parent != "" {
+			if ok {
+				return fmt.Errorf("chain %s of table %s is duplicated", cj.Name, t.Name)
+			}
+			parent, exist := t.chains[cj.Parent]
+			if !exist {
+				return fmt.Errorf("parent %s of chain %s not exist", cj.Parent, cj.Name)
+			}
+			chain = &Chain{
+				Name:     cj.Name,
+				table:    t,
+				parent:   parent,
+				children: make(map[string]*Chain),
+			}
+			parent.children[cj.Name] = chain
+			t.chains[cj.Name] = chain
+		} else if !ok {
+			return fmt.Errorf("chain %s of table %s has no parent", cj.Name, t.Name)
+		}
+		chain.cplRuleSl = []*CompleteRule{}
+		for _, cpl := range cj.Rules {
+			if cpl == nil {
+				continue
+			}
+			chain.cplRuleSl = append(chain.cplRuleSl, cpl)
+		}
+	}
+	return nil
+}
+
+// marshal all tables in stable order
+func (m *Manager) MarshalJSON() ([]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	tables := []*Table{}
+	for _, tName := range []string{"raw", "mangle", "nat", "filter"} {
+		table, ok := m.tables[tName]
+		if !ok {
+			continue
+		}
+		tables = append(tables, table)
+	}
+	return json.Marshal(tables)
+}
+
+// unmarshal all tables, tables not in json are empty
+func (m *Manager) UnmarshalJSON(data []byte) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var tables []*Table
+	err := json.Unmarshal(data, &tables)
+	if err != nil {
+		return err
+	}
+	m.tables = make(map[string]*Table)
+	m.Init()
+	for _, table := range tables {
+		if table == nil {
+			continue
+		}
+		table.lock = m.lock
+		m.tables[table.Name] = table
+	}
+	return nil
+}
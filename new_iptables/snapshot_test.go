@@ -0,0 +1,50 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package NewIptables
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	lines := []string{}
+	manager := NewManager()
+	manager.Init()
+	manager.SetDryRun(&lines)
+	output := manager.GetChain("mangle", "OUTPUT")
+	main, err := output.CreateChild("Main", 0, &CompleteRule{Action: "Main"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = main.CreateChild("App", 0, &CompleteRule{
+		Action:    "App",
+		BaseSl:    []BaseRule{{Match: "p", Param: "tcp"}},
+		ExtendsSl: []ExtendsRule{{Match: "m", Elem: ExtendsElem{Match: "cgroup", Base: BaseRule{Match: "path", Param: "App.slice"}}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := json.Marshal(manager)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewManager()
+	err = json.Unmarshal(buf, loaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.RestoreString(true) != manager.RestoreString(true) {
+		t.Errorf("snapshot not the same, want:\n%s\ngot:\n%s", manager.RestoreString(true), loaded.RestoreString(true))
+	}
+	if chain := loaded.GetChain("mangle", "App"); chain == nil || chain.parent.Name != "Main" {
+		t.Errorf("chain tree not rebuilt")
+	}
+	table := &Table{}
+	err = json.Unmarshal([]byte(`{"name":"mangle","chains":[{"name":"App","parent":"Main","rules":[]}]}`), table)
+	if err == nil {
+		t.Errorf("chain without parent should fail")
+	}
+}
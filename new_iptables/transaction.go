@@ -0,0 +1,46 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package NewIptables
+
+// setup of one scope touches several tables, like mangle scope chain, mangle PREROUTING and nat OUTPUT,
+// if one step fails, rules added before stay in kernel and break traffic.
+// in transaction, every chain operation records how to undo it, all steps are undone if transaction fails.
+
+// record undo step if table is in transaction
+func (t *Table) pushUndo(step func() error) {
+	if t.undo == nil {
+		return
+	}
+	*t.undo = append(*t.undo, step)
+}
+
+// run fn in transaction, chains and rules changed by fn are restored if fn fails,
+// chains removed by fn are not restored, transaction can not be nested.
+// rule tree is locked until transaction ends, so transactions run one at a time,
+// and operations of other goroutines are not recorded as steps of transaction
+func (m *Manager) Transaction(fn func() error) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var undo []func() error
+	for _, table := range m.tables {
+		table.undo = &undo
+	}
+	err := fn()
+	// stop recording, undo steps should not be recorded again
+	for _, table := range m.tables {
+		table.undo = nil
+	}
+	if err == nil {
+		return nil
+	}
+	logger.Warningf("[manager] transaction failed, begin to rollback, steps: %d, err: %v", len(undo), err)
+	for index := len(undo) - 1; index >= 0; index-- {
+		rbErr := undo[index]()
+		if rbErr != nil {
+			logger.Warningf("[manager] rollback step %d failed, err: %v", index, rbErr)
+		}
+	}
+	return err
+}
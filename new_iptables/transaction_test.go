@@ -0,0 +1,51 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package NewIptables
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTransaction(t *testing.T) {
+	lines := []string{}
+	manager := NewManager()
+	manager.Init()
+	manager.SetDryRun(&lines)
+	output := manager.GetChain("mangle", "OUTPUT")
+	nat := manager.GetChain("nat", "OUTPUT")
+	dns := &CompleteRule{Action: REDIRECT, BaseSl: []BaseRule{{Match: "p", Param: "udp"}}}
+	err := manager.Transaction(func() error {
+		child, err := output.CreateChild("App", 0, &CompleteRule{Action: "App"})
+		if err != nil {
+			return err
+		}
+		err = child.AppendRule(&CompleteRule{Action: MARK, BaseSl: []BaseRule{{Match: "-set-mark", Param: "1"}}})
+		if err != nil {
+			return err
+		}
+		err = nat.AppendRule(dns)
+		if err != nil {
+			return err
+		}
+		return errors.New("failed")
+	})
+	if err == nil {
+		t.Fatal("transaction should fail")
+	}
+	if output.GetRulesCount() != 0 || output.GetChildrenCount() != 0 || nat.GetRulesCount() != 0 {
+		t.Errorf("transaction should be rollback, commands: %v", lines)
+	}
+	if manager.GetTable("mangle").undo != nil {
+		t.Errorf("transaction should be stopped")
+	}
+	// succeed transaction keeps rules
+	err = manager.Transaction(func() error {
+		return nat.AppendRule(dns)
+	})
+	if err != nil || nat.GetRulesCount() != 1 {
+		t.Errorf("transaction should succeed, err: %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+/*
+#include <errno.h>
+#include <stdio.h>
+#include <string.h>
+#include <unistd.h>
+#include <sys/prctl.h>
+#include <sys/syscall.h>
+#include <linux/capability.h>
+
+#ifndef CAP_BPF
+#define CAP_BPF 39
+#endif
+
+// capabilities are per thread, go runtime starts threads before any go code runs,
+// so they are dropped in constructor when process has only one thread,
+// all threads and commands executed later such as iptables inherit the reduced set.
+
+// capabilities daemon needs after start
+static const int keep_caps[] = {
+	CAP_NET_ADMIN,        // iptables, ip rule, tproxy socket, proc connector, cgroup bpf
+	CAP_NET_RAW,          // iptables, transparent socket
+	CAP_NET_BIND_SERVICE, // dns proxy on low port
+	CAP_DAC_OVERRIDE,     // config of caller in home
+	CAP_CHOWN,            // cgroups owned by caller
+	CAP_SETUID,           // keyring is read as caller
+	CAP_SETGID,
+	CAP_SYS_PTRACE,       // exe of procs of other users
+	CAP_SYS_RESOURCE,     // memlock of bpf maps
+	CAP_BPF,              // load bpf programs
+};
+
+// result read by go, errno of failed step
+int caps_dropped = 0;
+int caps_errno = 0;
+
+static int last_cap(void) {
+	int last = CAP_LAST_CAP;
+	FILE *file = fopen("/proc/sys/kernel/cap_last_cap", "r");
+	if (file == NULL) {
+		return last;
+	}
+	if (fscanf(file, "%d", &last) != 1) {
+		last = CAP_LAST_CAP;
+	}
+	fclose(file);
+	return last;
+}
+
+static int keep_cap(int cap, int last) {
+	// kernel without CAP_BPF loads bpf by CAP_SYS_ADMIN
+	if (cap == CAP_SYS_ADMIN && last < CAP_BPF) {
+		return 1;
+	}
+	for (unsigned i = 0; i < sizeof(keep_caps) / sizeof(keep_caps[0]); i++) {
+		if (keep_caps[i] == cap) {
+			return 1;
+		}
+	}
+	return 0;
+}
+
+// -keep-caps is checked in cmdline, flags are not parsed yet
+static int keep_all(void) {
+	char buf[4096];
+	FILE *file = fopen("/proc/self/cmdline", "r");
+	if (file == NULL) {
+		return 0;
+	}
+	size_t n = fread(buf, 1, sizeof(buf) - 1, file);
+	fclose(file);
+	buf[n] = '\0';
+	for (size_t pos = 0; pos < n; pos += strlen(buf + pos) + 1) {
+		if (strcmp(buf + pos, "-keep-caps") == 0 || strcmp(buf + pos, "--keep-caps") == 0) {
+			return 1;
+		}
+	}
+	return 0;
+}
+
+__attribute__((constructor)) static void drop_caps(void) {
+	// commands of user such as import run without privilege
+	if (geteuid() != 0 || keep_all()) {
+		return;
+	}
+	int last = last_cap();
+	// bounding set first, CAP_SETPCAP is needed
+	for (int cap = 0; cap <= last; cap++) {
+		if (keep_cap(cap, last)) {
+			continue;
+		}
+		if (prctl(PR_CAPBSET_DROP, cap, 0, 0, 0) != 0) {
+			caps_errno = errno;
+			return;
+		}
+	}
+	// capabilities can only be removed from current sets
+	struct __user_cap_header_struct header = {_LINUX_CAPABILITY_VERSION_3, 0};
+	struct __user_cap_data_struct data[_LINUX_CAPABILITY_U32S_3];
+	if (syscall(SYS_capget, &header, data) != 0) {
+		caps_errno = errno;
+		return;
+	}
+	for (int cap = 0; cap <= last; cap++) {
+		if (keep_cap(cap, last)) {
+			continue;
+		}
+		data[CAP_TO_INDEX(cap)].permitted &= ~CAP_TO_MASK(cap);
+		data[CAP_TO_INDEX(cap)].effective &= ~CAP_TO_MASK(cap);
+		data[CAP_TO_INDEX(cap)].inheritable &= ~CAP_TO_MASK(cap);
+	}
+	if (syscall(SYS_capset, &header, data) != 0) {
+		caps_errno = errno;
+		return;
+	}
+	caps_dropped = 1;
+}
+*/
+import "C"
+
+import (
+	"flag"
+	"syscall"
+
+	logging "github.com/linuxdeepin/deepin-network-proxy/logging"
+)
+
+// checked by constructor before flags are parsed
+var keepCaps = flag.Bool("keep-caps", false, "keep all capabilities of root, for debugging")
+
+// report result of dropping capabilities at start
+func logCaps(logger *logging.Logger) {
+	if *keepCaps {
+		logger.Info("all capabilities are kept")
+		return
+	}
+	if C.caps_errno != 0 {
+		logger.Warningf("drop capabilities failed, err: %v", syscall.Errno(C.caps_errno))
+		return
+	}
+	if C.caps_dropped != 0 {
+		logger.Info("capabilities not needed are dropped")
+	}
+}
@@ -0,0 +1,95 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/godbus/dbus"
+	proxyDBus "github.com/linuxdeepin/deepin-network-proxy/dbus"
+)
+
+// dde-proxy import [-scope App] [-file subscription.txt] [uri...]
+// dde-proxy import [-scope App] -format clash|v2ray [-rules] -file config.yaml
+// proxies are imported by running daemon, so that config is written by daemon, "-" as file reads stdin
+func runImport(args []string) int {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	scope := flags.String("scope", "App", "scope proxies are imported to, App or Global")
+	file := flags.String("file", "", "subscription or uri list file, - means stdin")
+	format := flags.String("format", "", "file is config of other client, clash or v2ray")
+	withRules := flags.Bool("rules", false, "convert direct rules of client config to bypass rules")
+	_ = flags.Parse(args)
+	if *format != "" && (*file == "" || flags.NArg() != 0) {
+		fmt.Fprintln(os.Stderr, "usage: dde-proxy import [-scope App] -format clash|v2ray [-rules] -file path")
+		return 2
+	}
+
+	// base64 subscription can not be mixed with uris, so they are imported separately
+	var inputs []string
+	if flags.NArg() != 0 {
+		inputs = append(inputs, strings.Join(flags.Args(), "\n"))
+	}
+	if *file != "" {
+		var buf []byte
+		var err error
+		if *file == "-" {
+			buf, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			buf, err = ioutil.ReadFile(*file)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "read %s failed, err: %v\n", *file, err)
+			return 1
+		}
+		inputs = append(inputs, string(buf))
+	}
+	if len(inputs) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: dde-proxy import [-scope App] [-file path] [uri...]")
+		return 2
+	}
+
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "connect system bus failed, err: %v\n", err)
+		return 1
+	}
+	obj := conn.Object(proxyDBus.BusServiceName, dbus.ObjectPath(proxyDBus.BusPath+"/"+*scope))
+	if *format != "" {
+		var keys, unsupported []string
+		err = obj.Call(proxyDBus.BusInterface+"."+*scope+".ImportClientConfig", 0, *format, inputs[0], *withRules).Store(&keys, &unsupported)
+		for _, msg := range unsupported {
+			fmt.Fprintf(os.Stderr, "unsupported: %s\n", msg)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "import failed, err: %v\n", err)
+			return 1
+		}
+		for _, key := range keys {
+			fmt.Println(key)
+		}
+		return 0
+	}
+	code := 0
+	for _, input := range inputs {
+		var keys, skipped []string
+		err = obj.Call(proxyDBus.BusInterface+"."+*scope+".ImportProxyURI", 0, input).Store(&keys, &skipped)
+		for _, msg := range skipped {
+			fmt.Fprintf(os.Stderr, "skipped: %s\n", msg)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "import failed, err: %v\n", err)
+			code = 1
+			continue
+		}
+		for _, key := range keys {
+			fmt.Println(key)
+		}
+	}
+	return code
+}
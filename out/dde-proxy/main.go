@@ -5,20 +5,74 @@
 package main
 
 import (
+	"flag"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	com "github.com/linuxdeepin/deepin-network-proxy/com"
 	proxyDBus "github.com/linuxdeepin/deepin-network-proxy/dbus"
-	"github.com/linuxdeepin/go-lib/log"
+	route "github.com/linuxdeepin/deepin-network-proxy/ip_route"
+	logging "github.com/linuxdeepin/deepin-network-proxy/logging"
+	newIptables "github.com/linuxdeepin/deepin-network-proxy/new_iptables"
 )
 
+var cleanup = flag.Bool("cleanup", false, "remove iptables rules and ip rules left by last run and exit")
+var procSource = flag.String("proc-source", proxyDBus.ProcSourceDBus, "source of proc events, dbus or netlink")
+var cgroupMode = flag.String("cgroup-mode", proxyDBus.CGroupModeDirect, "create cgroups directly or under subtree delegated by systemd, direct or systemd")
+var seccomp = flag.Bool("seccomp", true, "restrict syscalls of daemon after init, -seccomp=false for debugging")
+
 func main() {
-	logger := log.NewLogger("proxy")
+	flag.Parse()
+	// import proxies by running daemon
+	if flag.Arg(0) == "import" {
+		os.Exit(runImport(flag.Args()[1:]))
+	}
+	// switch profile of running daemon
+	if flag.Arg(0) == "profile" {
+		os.Exit(runProfile(flag.Args()[1:]))
+	}
+	logger := logging.New("proxy")
+	logCaps(logger)
+	// lookup inside libraries is sent as traffic of daemon too
+	net.DefaultResolver = com.SelfResolver
+	// apply rules by go-iptables, exec iptables if not available
+	newIptables.SetRunner(newIptables.NewRunner())
+	newIptables.SetRunner6(newIptables.NewRunner6())
+	// remove stale rules only
+	if *cleanup {
+		err := newIptables.Recover(newIptables.DefaultJournalPath)
+		if err != nil {
+			logger.Warningf("cleanup failed, err: %v", err)
+		}
+		err = route.Recover(proxyDBus.RouteTable)
+		if err != nil {
+			logger.Warningf("cleanup route failed, err: %v", err)
+		}
+		proxyDBus.CleanOrphans()
+		return
+	}
 	manager := proxyDBus.NewManager()
-	err := manager.Init()
+	err := manager.SetProcSource(*procSource)
+	if err != nil {
+		logger.Warningf("set proc source failed, err: %v", err)
+		return
+	}
+	err = manager.SetCGroupMode(*cgroupMode)
+	if err != nil {
+		logger.Warningf("set cgroup mode failed, err: %v", err)
+		return
+	}
+	err = manager.Init()
 	if err != nil {
 		logger.Warningf("manager init failed, err: %v", err)
 		return
 	}
 	// load config
 	_ = manager.LoadConfig()
+	// each tunnel holds fds, raise limit before any is accepted
+	manager.RaiseFdLimit()
 	//if err != nil {
 	//	log.Fatal(err)
 	//}
@@ -28,6 +82,22 @@ func main() {
 		logger.Warningf("manager export failed, err: %v", err)
 		return
 	}
+	// relay parses data from network, restrict syscalls after init
+	if *seccomp {
+		err = com.ApplySeccomp()
+		if err != nil {
+			logger.Warningf("apply seccomp filter failed, err: %v", err)
+		}
+	}
+	// release cgroups and rules before exit
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigCh
+		logger.Infof("receive signal %v, shutdown", sig)
+		manager.Shutdown()
+		os.Exit(0)
+	}()
 	// wait
 	manager.Wait()
 }
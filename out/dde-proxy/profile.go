@@ -0,0 +1,52 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/godbus/dbus"
+	proxyDBus "github.com/linuxdeepin/deepin-network-proxy/dbus"
+)
+
+// dde-proxy profile          list profiles, active one is marked by *
+// dde-proxy profile office   activate profile office
+func runProfile(args []string) int {
+	if len(args) > 1 {
+		fmt.Fprintln(os.Stderr, "usage: dde-proxy profile [name]")
+		return 2
+	}
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "connect system bus failed, err: %v\n", err)
+		return 1
+	}
+	// profiles are shared by scopes, any scope object works
+	obj := conn.Object(proxyDBus.BusServiceName, dbus.ObjectPath(proxyDBus.BusPath+"/App"))
+	if len(args) == 1 {
+		err = obj.Call(proxyDBus.BusInterface+".App.ActivateProfile", 0, args[0]).Err
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "activate profile %s failed, err: %v\n", args[0], err)
+			return 1
+		}
+		return 0
+	}
+	var names []string
+	var active string
+	err = obj.Call(proxyDBus.BusInterface+".App.ListProfiles", 0).Store(&names, &active)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "list profiles failed, err: %v\n", err)
+		return 1
+	}
+	for _, name := range names {
+		mark := " "
+		if name == active {
+			mark = "*"
+		}
+		fmt.Printf("%s %s\n", mark, name)
+	}
+	return 0
+}
@@ -0,0 +1,271 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	proxyDBus "github.com/linuxdeepin/deepin-network-proxy/dbus"
+	tProxy "github.com/linuxdeepin/deepin-network-proxy/tproxy"
+)
+
+// deepin-proxy-ctl status
+func (c *client) status() int {
+	var state proxyDBus.ProxyState
+	err := c.callJson(&state, "GetProxyState")
+	if err != nil {
+		return fail("get state", err)
+	}
+	if *jsonOutput {
+		return 0
+	}
+	if state.ActiveProfile != "" {
+		fmt.Printf("profile: %s\n", state.ActiveProfile)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SCOPE\tSTATE\tPROXY\tSERVER\tUID")
+	for _, scope := range state.Scopes {
+		if !scope.Enabled {
+			fmt.Fprintf(w, "%s\tstopped\t-\t-\t-\n", scope.Scope)
+			continue
+		}
+		proxy := scope.Proxy
+		if scope.PAC {
+			proxy += " (pac)"
+		}
+		fmt.Fprintf(w, "%s\trunning\t%s\t%s:%d\t%d\n", scope.Scope, proxy, scope.Server, scope.Port, scope.Uid)
+	}
+	_ = w.Flush()
+	return 0
+}
+
+// deepin-proxy-ctl start App http/office
+func (c *client) start(args []string) int {
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: deepin-proxy-ctl start <scope> <proto/name>")
+		return 2
+	}
+	err := c.control().Call(proxyDBus.BusInterface+".StartProxy", 0, args[0], args[1]).Err
+	if err != nil {
+		return fail("start proxy of "+args[0], err)
+	}
+	return 0
+}
+
+// deepin-proxy-ctl stop App
+func (c *client) stop(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: deepin-proxy-ctl stop <scope>")
+		return 2
+	}
+	err := c.control().Call(proxyDBus.BusInterface+".StopProxy", 0, args[0]).Err
+	if err != nil {
+		return fail("stop proxy of "+args[0], err)
+	}
+	return 0
+}
+
+// deepin-proxy-ctl apps add /usr/bin/git
+// deepin-proxy-ctl -scope Global apps add /usr/bin/apt, apps of global are ignored instead
+// deepin-proxy-ctl -scope Direct apps add /usr/bin/remmina, apps are never proxied by any scope
+func (c *client) apps(args []string) int {
+	if len(args) < 2 || (args[0] != "add" && args[0] != "remove") {
+		fmt.Fprintln(os.Stderr, "usage: deepin-proxy-ctl [-scope App|Global|Direct] apps add|remove <app>...")
+		return 2
+	}
+	methods := map[string][2]string{
+		"App":    {"AddProxyApps", "DelProxyApps"},
+		"Global": {"IgnoreProxyApps", "UnIgnoreProxyApps"},
+		"Direct": {"AddDirectApps", "DelDirectApps"},
+	}
+	pair, ok := methods[*appScope]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "scope %s not found\n", *appScope)
+		return 2
+	}
+	method := pair[0]
+	if args[0] == "remove" {
+		method = pair[1]
+	}
+	// direct scope has no object, apps are kept by control
+	obj, iface := c.scope(*appScope), proxyDBus.BusInterface+"."+*appScope
+	if *appScope == "Direct" {
+		obj, iface = c.control(), proxyDBus.BusInterface
+	}
+	err := obj.Call(iface+"."+method, 0, args[1:]).Err
+	if err != nil {
+		return fail(args[0]+" apps", err)
+	}
+	return 0
+}
+
+// deepin-proxy-ctl proxies list App, active proxy is marked by *
+// deepin-proxy-ctl proxies test App
+// deepin-proxy-ctl proxies probe http/office App
+func (c *client) proxies(args []string) int {
+	if len(args) != 0 && args[0] == "probe" {
+		return c.probe(args[1:])
+	}
+	if len(args) == 0 || len(args) > 2 {
+		fmt.Fprintln(os.Stderr, "usage: deepin-proxy-ctl proxies list|test [scope]")
+		return 2
+	}
+	scope := scopeArg(args[1:])
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	switch args[0] {
+	case "list":
+		var infos []proxyDBus.ProxyInfo
+		err := c.callJson(&infos, "ListProxies", scope)
+		if err != nil {
+			return fail("list proxies", err)
+		}
+		if *jsonOutput {
+			return 0
+		}
+		fmt.Fprintln(w, " \tPROXY\tSERVER\tPORT")
+		for _, info := range infos {
+			mark := " "
+			if info.Active {
+				mark = "*"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", mark, info.Key, info.Server, info.Port)
+		}
+	case "test":
+		var results []proxyDBus.ProxyTest
+		err := c.callJson(&results, "TestProxies", scope)
+		if err != nil {
+			return fail("test proxies", err)
+		}
+		code := 0
+		for _, result := range results {
+			if !result.Reachable {
+				code = 1
+			}
+		}
+		if *jsonOutput {
+			return code
+		}
+		fmt.Fprintln(w, "PROXY\tADDR\tRESULT")
+		for _, result := range results {
+			if result.Reachable {
+				fmt.Fprintf(w, "%s\t%s\t%d ms\n", result.Key, result.Addr, result.Latency)
+			} else {
+				fmt.Fprintf(w, "%s\t%s\t%s\n", result.Key, result.Addr, result.Error)
+			}
+		}
+		_ = w.Flush()
+		return code
+	default:
+		fmt.Fprintln(os.Stderr, "usage: deepin-proxy-ctl proxies list|test [scope]")
+		return 2
+	}
+	_ = w.Flush()
+	return 0
+}
+
+// test one proxy stage by stage, exit with 1 if any stage fails
+func (c *client) probe(args []string) int {
+	if len(args) == 0 || len(args) > 2 {
+		fmt.Fprintln(os.Stderr, "usage: deepin-proxy-ctl proxies probe <proto/name> [scope]")
+		return 2
+	}
+	scope := scopeArg(args[1:])
+	var report tProxy.ProbeReport
+	err := c.scope(scope).Call(proxyDBus.BusInterface+"."+scope+".TestProxy", 0, args[0]).Store(&report)
+	if err != nil {
+		return fail("test proxy "+args[0], err)
+	}
+	code := 0
+	if !report.Passed {
+		code = 1
+	}
+	if *jsonOutput {
+		buf, err := json.Marshal(report)
+		if err != nil {
+			return fail("marshal report", err)
+		}
+		fmt.Println(string(buf))
+		return code
+	}
+	fmt.Printf("proxy: %s (%s)\n", report.Proxy, report.Addr)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "STAGE\tRESULT\tLATENCY")
+	for _, stage := range report.Stages {
+		switch {
+		case stage.Skipped:
+			fmt.Fprintf(w, "%s\tskipped\t-\n", stage.Name)
+		case stage.Passed:
+			fmt.Fprintf(w, "%s\tok\t%d ms\n", stage.Name, stage.Latency)
+		default:
+			fmt.Fprintf(w, "%s\t%s\t%d ms\n", stage.Name, stage.Error, stage.Latency)
+		}
+	}
+	_ = w.Flush()
+	return code
+}
+
+// deepin-proxy-ctl doctor, exit with 1 if any check fails
+func (c *client) doctor() int {
+	var report proxyDBus.DoctorReport
+	err := c.callJson(&report, "Doctor")
+	if err != nil {
+		return fail("doctor", err)
+	}
+	code := 0
+	if !report.Passed {
+		code = 1
+	}
+	if *jsonOutput {
+		return code
+	}
+	for _, item := range report.Items {
+		result := "PASS"
+		if !item.Passed {
+			result = "FAIL"
+		}
+		check := item.Check
+		if item.Scope != "" {
+			check = item.Scope + "/" + check
+		}
+		fmt.Printf("[%s] %s: %s\n", result, check, item.Detail)
+		if item.Hint != "" {
+			fmt.Printf("       hint: %s\n", item.Hint)
+		}
+	}
+	return code
+}
+
+// deepin-proxy-ctl explain www.deepin.org:443 /usr/bin/firefox
+func (c *client) explain(args []string) int {
+	if len(args) == 0 || len(args) > 2 {
+		fmt.Fprintln(os.Stderr, "usage: deepin-proxy-ctl explain <host:port> [app]")
+		return 2
+	}
+	var app string
+	if len(args) == 2 {
+		app = args[1]
+	}
+	var exp proxyDBus.Explanation
+	err := c.callJson(&exp, "Explain", args[0], app)
+	if err != nil {
+		return fail("explain", err)
+	}
+	if *jsonOutput {
+		return 0
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "verdict:\t%s\n", exp.Verdict)
+	fmt.Fprintf(w, "scope:\t%s\n", exp.Scope)
+	fmt.Fprintf(w, "stage:\t%s\n", exp.Stage)
+	fmt.Fprintf(w, "rule:\t%s\n", exp.Rule)
+	if exp.Proxy != "" {
+		fmt.Fprintf(w, "proxy:\t%s\n", exp.Proxy)
+	}
+	_ = w.Flush()
+	return 0
+}
@@ -0,0 +1,143 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/godbus/dbus"
+	proxyDBus "github.com/linuxdeepin/deepin-network-proxy/dbus"
+)
+
+// table is redrawn every refresh, json mode prints one line per signal instead
+const connRefresh = time.Second
+
+// connection open now
+type connRow struct {
+	ID          uint64
+	Exe         string
+	Destination string
+	Proxy       string
+	Start       time.Time
+}
+
+// one signal of connection in json mode
+type connLine struct {
+	Event       string
+	ID          uint64
+	Exe         string
+	Destination string
+	Proxy       string
+	Sent        uint64 `json:",omitempty"`
+	Received    uint64 `json:",omitempty"`
+	Reason      string `json:",omitempty"`
+}
+
+// deepin-proxy-ctl connections App, until interrupted
+func (c *client) connections(args []string) int {
+	if len(args) > 1 {
+		fmt.Fprintln(os.Stderr, "usage: deepin-proxy-ctl connections [scope]")
+		return 2
+	}
+	scope := scopeArg(args)
+	iface := proxyDBus.BusInterface + "." + scope
+	path := dbus.ObjectPath(proxyDBus.BusPath + "/" + scope)
+	match := "type='signal',sender='" + proxyDBus.BusServiceName + "',path='" + string(path) + "',interface='" + iface + "'"
+	err := c.conn.BusObject().Call("org.freedesktop.DBus.AddMatch", 0, match).Err
+	if err != nil {
+		return fail("add match", err)
+	}
+	ch := make(chan *dbus.Signal, 64)
+	c.conn.Signal(ch)
+	// signals are only emitted while someone watches
+	err = c.scope(scope).Call(iface+".WatchConnections", 0).Err
+	if err != nil {
+		return fail("watch connections", err)
+	}
+	defer func() {
+		_ = c.scope(scope).Call(iface+".UnwatchConnections", 0).Err
+	}()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	ticker := time.NewTicker(connRefresh)
+	defer ticker.Stop()
+	rows := make(map[uint64]connRow)
+	encoder := json.NewEncoder(os.Stdout)
+	for {
+		select {
+		case sig := <-ch:
+			if sig == nil || sig.Path != path {
+				continue
+			}
+			line, ok := parseConnSignal(sig, iface)
+			if !ok {
+				continue
+			}
+			if *jsonOutput {
+				_ = encoder.Encode(line)
+				continue
+			}
+			switch line.Event {
+			case "NewProxiedConnection":
+				rows[line.ID] = connRow{ID: line.ID, Exe: line.Exe, Destination: line.Destination, Proxy: line.Proxy, Start: time.Now()}
+			case "ConnectionClosed":
+				delete(rows, line.ID)
+			}
+		case <-ticker.C:
+			if !*jsonOutput {
+				drawConnections(rows)
+			}
+		case <-sigCh:
+			return 0
+		}
+	}
+}
+
+// connection signal of scope, false if not connection signal
+func parseConnSignal(sig *dbus.Signal, iface string) (connLine, bool) {
+	var line connLine
+	var err error
+	switch sig.Name {
+	case iface + ".NewProxiedConnection":
+		err = dbus.Store(sig.Body, &line.ID, &line.Exe, &line.Destination, &line.Proxy)
+		line.Event = "NewProxiedConnection"
+	case iface + ".ConnectionClosed":
+		err = dbus.Store(sig.Body, &line.ID, &line.Exe, &line.Destination, &line.Proxy, &line.Sent, &line.Received)
+		line.Event = "ConnectionClosed"
+	case iface + ".HandshakeFailed":
+		err = dbus.Store(sig.Body, &line.Exe, &line.Destination, &line.Proxy, &line.Reason)
+		line.Event = "HandshakeFailed"
+	default:
+		return line, false
+	}
+	return line, err == nil
+}
+
+// clear screen and draw connections open, oldest first
+func drawConnections(rows map[uint64]connRow) {
+	sorted := make([]connRow, 0, len(rows))
+	for _, row := range rows {
+		sorted = append(sorted, row)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].ID < sorted[j].ID
+	})
+	fmt.Print("\033[H\033[2J")
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "connections: %d\n", len(sorted))
+	fmt.Fprintln(w, "ID\tEXE\tDESTINATION\tPROXY\tAGE")
+	for _, row := range sorted {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", row.ID, row.Exe, row.Destination, row.Proxy, time.Since(row.Start).Truncate(time.Second))
+	}
+	_ = w.Flush()
+}
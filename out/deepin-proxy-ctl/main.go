@@ -0,0 +1,117 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/godbus/dbus"
+	proxyDBus "github.com/linuxdeepin/deepin-network-proxy/dbus"
+)
+
+// command line client of daemon, everything is done by dbus api, so that polkit checks caller the same way as desktop.
+// output is table for human, -json prints what daemon returns for scripts.
+
+const usage = `usage: deepin-proxy-ctl [-json] <command> [args]
+  status                          state of scopes
+  start <scope> <proto/name>      start proxy of scope
+  stop <scope>                    stop proxy of scope
+  apps add|remove <app>...        apps proxied by App scope, ignored by Global with -scope Global,
+                                  or never proxied with -scope Direct
+  proxies list|test [scope]       proxies of scope, test dials their servers
+  proxies probe <proto/name> [scope]
+                                  test proxy stage by stage, from dns to tunnel
+  connections [scope]             live table of proxied tcp connections
+  explain <host:port> [app]       how connection of app is handled, without connecting
+  doctor                          self check of the whole stack`
+
+var jsonOutput = flag.Bool("json", false, "print json instead of table")
+var appScope = flag.String("scope", "App", "scope of apps command, App, Global or Direct")
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, usage)
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "connect system bus failed, err: %v\n", err)
+		os.Exit(1)
+	}
+	c := &client{conn: conn}
+	args := flag.Args()[1:]
+	var code int
+	switch flag.Arg(0) {
+	case "status":
+		code = c.status()
+	case "start":
+		code = c.start(args)
+	case "stop":
+		code = c.stop(args)
+	case "apps":
+		code = c.apps(args)
+	case "proxies":
+		code = c.proxies(args)
+	case "connections":
+		code = c.connections(args)
+	case "explain":
+		code = c.explain(args)
+	case "doctor":
+		code = c.doctor()
+	default:
+		flag.Usage()
+		code = 2
+	}
+	os.Exit(code)
+}
+
+// connection to system bus
+type client struct {
+	conn *dbus.Conn
+}
+
+// control object drives all scopes
+func (c *client) control() dbus.BusObject {
+	return c.conn.Object(proxyDBus.BusServiceName, dbus.ObjectPath(proxyDBus.BusPath))
+}
+
+// object of scope, App or Global
+func (c *client) scope(scope string) dbus.BusObject {
+	return c.conn.Object(proxyDBus.BusServiceName, dbus.ObjectPath(proxyDBus.BusPath+"/"+scope))
+}
+
+// call method of control returns json, decode it into v, raw json is printed in json mode
+func (c *client) callJson(v interface{}, method string, args ...interface{}) error {
+	var buf string
+	err := c.control().Call(proxyDBus.BusInterface+"."+method, 0, args...).Store(&buf)
+	if err != nil {
+		return err
+	}
+	if *jsonOutput {
+		fmt.Println(buf)
+	}
+	return json.Unmarshal([]byte(buf), v)
+}
+
+// print error of command, return exit code
+func fail(what string, err error) int {
+	fmt.Fprintf(os.Stderr, "%s failed, err: %v\n", what, err)
+	return 1
+}
+
+// scope of optional first arg, App by default
+func scopeArg(args []string) string {
+	if len(args) != 0 {
+		return args[0]
+	}
+	return "App"
+}
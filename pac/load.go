@@ -0,0 +1,71 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package PAC
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	com "github.com/linuxdeepin/deepin-network-proxy/com"
+)
+
+// timeout of downloading pac file
+const loadTimeout = 10 * time.Second
+
+// pac file larger than this is rejected
+const maxSize = 1 << 20
+
+// read pac file from http url, file url or local path
+func Load(location string) ([]byte, error) {
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		return download(location)
+	}
+	path := strings.TrimPrefix(location, "file://")
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) > maxSize {
+		return nil, fmt.Errorf("pac file %s is larger than %d bytes", path, maxSize)
+	}
+	return buf, nil
+}
+
+// download pac file, daemon is not proxied
+func download(url string) ([]byte, error) {
+	client := &http.Client{
+		Timeout:   loadTimeout,
+		Transport: &http.Transport{DialContext: com.NewDialer(loadTimeout).DialContext},
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download pac %s failed, status: %s", url, resp.Status)
+	}
+	buf, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) > maxSize {
+		return nil, fmt.Errorf("pac %s is larger than %d bytes", url, maxSize)
+	}
+	return buf, nil
+}
+
+// load and compile pac script
+func LoadScript(location string) (*Script, error) {
+	buf, err := Load(location)
+	if err != nil {
+		return nil, err
+	}
+	return Compile(string(buf))
+}
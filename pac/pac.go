@@ -0,0 +1,138 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package PAC
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dop251/goja"
+	com "github.com/linuxdeepin/deepin-network-proxy/com"
+	logging "github.com/linuxdeepin/deepin-network-proxy/logging"
+)
+
+// proxy auto-config script, FindProxyForURL is evaluated by embedded js engine,
+// dns functions are provided by go, the others are plain js like browsers do.
+// https://developer.mozilla.org/en-US/docs/Web/HTTP/Proxy_servers_and_tunneling/Proxy_Auto-Configuration_PAC_file
+
+var logger *logging.Logger
+
+// script runs too long is interrupted, dns functions may be called several times
+const evalTimeout = 5 * time.Second
+
+// timeout of dnsResolve
+const resolveTimeout = 2 * time.Second
+
+// results are cached by url, cache is dropped when full
+const cacheSize = 1024
+
+var errTimeout = errors.New("evaluate pac script timeout")
+
+// compiled pac script, safe for concurrent use
+type Script struct {
+	lock  sync.Mutex
+	vm    *goja.Runtime
+	find  goja.Callable
+	cache map[string]string
+}
+
+// compile pac script, script should define FindProxyForURL
+func Compile(src string) (*Script, error) {
+	vm := goja.New()
+	_ = vm.Set("dnsResolve", dnsResolve)
+	_ = vm.Set("myIpAddress", myIpAddress)
+	_ = vm.Set("alert", func(msg string) { logger.Debugf("[pac] alert: %s", msg) })
+	_, err := vm.RunString(utilsJS)
+	if err != nil {
+		return nil, fmt.Errorf("load pac utils failed, err: %v", err)
+	}
+	script := &Script{vm: vm, cache: make(map[string]string)}
+	timer := time.AfterFunc(evalTimeout, func() { vm.Interrupt(errTimeout) })
+	_, err = vm.RunString(src)
+	timer.Stop()
+	vm.ClearInterrupt()
+	if err != nil {
+		return nil, fmt.Errorf("run pac script failed, err: %v", err)
+	}
+	find, ok := goja.AssertFunction(vm.Get("FindProxyForURL"))
+	if !ok {
+		return nil, errors.New("FindProxyForURL is not defined in pac script")
+	}
+	script.find = find
+	return script, nil
+}
+
+// result of FindProxyForURL, like "PROXY 10.0.0.1:8080; DIRECT"
+func (s *Script) FindProxy(url string, host string) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if result, ok := s.cache[url]; ok {
+		return result, nil
+	}
+	timer := time.AfterFunc(evalTimeout, func() { s.vm.Interrupt(errTimeout) })
+	value, err := s.find(goja.Undefined(), s.vm.ToValue(url), s.vm.ToValue(host))
+	timer.Stop()
+	s.vm.ClearInterrupt()
+	if err != nil {
+		return "", err
+	}
+	result := value.String()
+	if len(s.cache) >= cacheSize {
+		s.cache = make(map[string]string)
+	}
+	s.cache[url] = result
+	return result, nil
+}
+
+// url passed to FindProxyForURL, path is unknown as traffic is not decrypted
+func URLOf(host string, port int) string {
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	switch port {
+	case 443:
+		return "https://" + host + "/"
+	case 80:
+		return "http://" + host + "/"
+	default:
+		return "http://" + host + ":" + strconv.Itoa(port) + "/"
+	}
+}
+
+// first ipv4 addr of host, null if not resolvable
+func dnsResolve(host string) interface{} {
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	addrs, err := com.SelfResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if ip := addr.IP.To4(); ip != nil {
+			return ip.String()
+		}
+	}
+	return nil
+}
+
+// addr of default route, no packet is sent by connecting udp socket
+func myIpAddress() string {
+	conn, err := com.NewDialer(0).Dial("udp4", "8.8.8.8:53")
+	if err != nil {
+		return "127.0.0.1"
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String()
+}
+
+func init() {
+	logger = logging.New("proxy/pac")
+}
@@ -0,0 +1,142 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package PAC
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testPAC = `
+function FindProxyForURL(url, host) {
+	if (isPlainHostName(host) || dnsDomainIs(host, ".corp.example.com")) {
+		return "DIRECT";
+	}
+	if (isInNet(host, "10.0.0.0", "255.0.0.0")) {
+		return "DIRECT";
+	}
+	if (shExpMatch(url, "https://*.example.org/*")) {
+		return "SOCKS5 10.0.0.2:1080; DIRECT";
+	}
+	if (localHostOrDomainIs(host, "www.example.net") && dnsDomainLevels(host) == 2) {
+		return "SOCKS 10.0.0.3";
+	}
+	return "PROXY 10.0.0.1:8080; DIRECT";
+}
+`
+
+func TestScript_FindProxy(t *testing.T) {
+	script, err := Compile(testPAC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]string{
+		"intranet":                 "DIRECT",
+		"git.corp.example.com":     "DIRECT",
+		"10.1.2.3":                 "DIRECT",
+		"www.example.org":          "SOCKS5 10.0.0.2:1080; DIRECT",
+		"www.example.net":          "SOCKS 10.0.0.3",
+		"www.example.com":          "PROXY 10.0.0.1:8080; DIRECT",
+		"www.example.org.evil.com": "PROXY 10.0.0.1:8080; DIRECT",
+	}
+	for host, want := range cases {
+		got, err := script.FindProxy(URLOf(host, 443), host)
+		if err != nil || got != want {
+			t.Errorf("find proxy of %s got %q, err: %v", host, got, err)
+		}
+	}
+	// result is cached by url
+	if _, ok := script.cache[URLOf("intranet", 443)]; !ok {
+		t.Error("result should be cached")
+	}
+
+	_, err = Compile("function foo() {}")
+	if err == nil {
+		t.Error("script without FindProxyForURL should fail")
+	}
+	_, err = Compile("function FindProxyForURL(url, host) {")
+	if err == nil {
+		t.Error("script with syntax error should fail")
+	}
+}
+
+func TestTimeFunctions(t *testing.T) {
+	script, err := Compile(`function FindProxyForURL(url, host) {
+		return [weekdayRange("SUN", "SAT"), weekdayRange("FOO"), timeRange(0, 24), dateRange(1, 31),
+			dateRange("JAN", "DEC"), dateRange(1995, 1996), timeRange(0, 0, 0, 23, 59, 59, "GMT")].join(",");
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := script.FindProxy("http://a/", "a")
+	if err != nil || got != "true,false,true,true,true,false,true" {
+		t.Errorf("time functions got %s, err: %v", got, err)
+	}
+}
+
+func TestParseResult(t *testing.T) {
+	directives, err := ParseResult("PROXY 10.0.0.1:8080; socks [fd00::1]:1081;HTTPS proxy.example.com; DIRECT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Directive{
+		{Type: Proxy, Host: "10.0.0.1", Port: 8080},
+		{Type: Socks, Host: "fd00::1", Port: 1081},
+		{Type: HTTPS, Host: "proxy.example.com", Port: 443},
+		{Type: Direct},
+	}
+	if !reflect.DeepEqual(directives, want) {
+		t.Errorf("parse result got %+v", directives)
+	}
+	if directives[1].String() != "SOCKS [fd00::1]:1081" {
+		t.Errorf("directive string got %s", directives[1])
+	}
+	directives, err = ParseResult("")
+	if err != nil || len(directives) != 1 || directives[0].Type != Direct {
+		t.Errorf("empty result should be direct, got %v, err: %v", directives, err)
+	}
+	for _, result := range []string{"FTP 1.1.1.1:21", "PROXY", "PROXY 1.1.1.1:0"} {
+		if _, err := ParseResult(result); err == nil {
+			t.Errorf("result %q should fail", result)
+		}
+	}
+}
+
+func TestURLOf(t *testing.T) {
+	cases := map[string]string{
+		URLOf("a.com", 443):   "https://a.com/",
+		URLOf("a.com", 80):    "http://a.com/",
+		URLOf("a.com", 8080):  "http://a.com:8080/",
+		URLOf("fd00::1", 443): "https://[fd00::1]/",
+	}
+	for got, want := range cases {
+		if got != want {
+			t.Errorf("url got %s, want %s", got, want)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "proxy.pac")
+	_ = ioutil.WriteFile(path, []byte(testPAC), 0644)
+	for _, location := range []string{path, "file://" + path} {
+		script, err := LoadScript(location)
+		if err != nil || script == nil {
+			t.Errorf("load %s failed, err: %v", location, err)
+		}
+	}
+	_, err = Load(filepath.Join(dir, "none.pac"))
+	if err == nil {
+		t.Error("load not exist file should fail")
+	}
+}
@@ -0,0 +1,83 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package PAC
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// types of pac result
+const (
+	Direct = "DIRECT"
+	Proxy  = "PROXY"
+	HTTP   = "HTTP"
+	HTTPS  = "HTTPS"
+	Socks  = "SOCKS"
+	Socks4 = "SOCKS4"
+	Socks5 = "SOCKS5"
+)
+
+// default port of proxy type
+var defaultPorts = map[string]int{
+	Proxy:  80,
+	HTTP:   80,
+	HTTPS:  443,
+	Socks:  1080,
+	Socks4: 1080,
+	Socks5: 1080,
+}
+
+// one choice of pac result, host is empty if direct
+type Directive struct {
+	Type string
+	Host string
+	Port int
+}
+
+func (d Directive) String() string {
+	if d.Type == Direct {
+		return Direct
+	}
+	return d.Type + " " + net.JoinHostPort(d.Host, strconv.Itoa(d.Port))
+}
+
+// parse "PROXY 10.0.0.1:8080; SOCKS5 10.0.0.2; DIRECT", empty result means direct
+func ParseResult(result string) ([]Directive, error) {
+	var directives []Directive
+	for _, item := range strings.Split(result, ";") {
+		fields := strings.Fields(item)
+		if len(fields) == 0 {
+			continue
+		}
+		typ := strings.ToUpper(fields[0])
+		if typ == Direct {
+			directives = append(directives, Directive{Type: Direct})
+			continue
+		}
+		port, ok := defaultPorts[typ]
+		if !ok {
+			return nil, fmt.Errorf("pac result %q has unknown type %s", result, fields[0])
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("pac result %q has no proxy of %s", result, fields[0])
+		}
+		host := fields[1]
+		if h, p, err := net.SplitHostPort(fields[1]); err == nil {
+			host = h
+			port, err = strconv.Atoi(p)
+			if err != nil || port <= 0 || port > 65535 {
+				return nil, fmt.Errorf("pac result %q has invalid port %s", result, p)
+			}
+		}
+		directives = append(directives, Directive{Type: typ, Host: strings.Trim(host, "[]"), Port: port})
+	}
+	if len(directives) == 0 {
+		directives = append(directives, Directive{Type: Direct})
+	}
+	return directives, nil
+}
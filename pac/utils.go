@@ -0,0 +1,151 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package PAC
+
+// pac functions not depend on dns, the same behavior as browsers
+const utilsJS = `
+function dnsDomainIs(host, domain) {
+	return host.length >= domain.length && host.substring(host.length - domain.length) == domain;
+}
+
+function dnsDomainLevels(host) {
+	return host.split('.').length - 1;
+}
+
+function isPlainHostName(host) {
+	return host.indexOf('.') < 0;
+}
+
+function localHostOrDomainIs(host, hostdom) {
+	return host == hostdom || hostdom.lastIndexOf(host + '.', 0) == 0;
+}
+
+function isResolvable(host) {
+	return dnsResolve(host) != null;
+}
+
+function convert_addr(ipchars) {
+	var bytes = ipchars.split('.');
+	return ((bytes[0] & 0xff) << 24) | ((bytes[1] & 0xff) << 16) | ((bytes[2] & 0xff) << 8) | (bytes[3] & 0xff);
+}
+
+function isInNet(ipaddr, pattern, maskstr) {
+	var test = /^(\d{1,3})\.(\d{1,3})\.(\d{1,3})\.(\d{1,3})$/.exec(ipaddr);
+	if (test == null) {
+		ipaddr = dnsResolve(ipaddr);
+		if (ipaddr == null) {
+			return false;
+		}
+	} else if (test[1] > 255 || test[2] > 255 || test[3] > 255 || test[4] > 255) {
+		return false;
+	}
+	var host = convert_addr(ipaddr);
+	var pat = convert_addr(pattern);
+	var mask = convert_addr(maskstr);
+	return (host & mask) == (pat & mask);
+}
+
+function shExpMatch(url, pattern) {
+	pattern = pattern.replace(/[.+^$()|{}\[\]\\]/g, '\\$&').replace(/\*/g, '.*').replace(/\?/g, '.');
+	return new RegExp('^' + pattern + '$').test(url);
+}
+
+var wdays = {SUN: 0, MON: 1, TUE: 2, WED: 3, THU: 4, FRI: 5, SAT: 6};
+var months = {JAN: 0, FEB: 1, MAR: 2, APR: 3, MAY: 4, JUN: 5, JUL: 6, AUG: 7, SEP: 8, OCT: 9, NOV: 10, DEC: 11};
+
+// last argument GMT means utc time, returns [args, gmt]
+function splitGMT(args) {
+	args = Array.prototype.slice.call(args);
+	if (args.length != 0 && args[args.length - 1] == 'GMT') {
+		return [args.slice(0, args.length - 1), true];
+	}
+	return [args, false];
+}
+
+// range may wrap, like FRI to MON
+function inRange(begin, end, value) {
+	if (begin <= end) {
+		return begin <= value && value <= end;
+	}
+	return value >= begin || value <= end;
+}
+
+function weekdayRange() {
+	var split = splitGMT(arguments), args = split[0];
+	var date = new Date();
+	var wday = split[1] ? date.getUTCDay() : date.getDay();
+	if (args.length < 1 || args.length > 2) {
+		return false;
+	}
+	var begin = wdays[args[0]], end = wdays[args[args.length - 1]];
+	if (begin == undefined || end == undefined) {
+		return false;
+	}
+	return inRange(begin, end, wday);
+}
+
+// arguments are day 1-31, month name or year, begin and end have the same fields
+function dateRange() {
+	var split = splitGMT(arguments), args = split[0], gmt = split[1];
+	var date = new Date();
+	var now = [gmt ? date.getUTCDate() : date.getDate(), gmt ? date.getUTCMonth() : date.getMonth(), gmt ? date.getUTCFullYear() : date.getFullYear()];
+	function kind(arg) {
+		if (arg in months) {
+			return 1;
+		}
+		return parseInt(arg, 10) > 31 ? 2 : 0;
+	}
+	function value(arg) {
+		return arg in months ? months[arg] : parseInt(arg, 10);
+	}
+	if (args.length == 1) {
+		return now[kind(args[0])] == value(args[0]);
+	}
+	if (args.length == 0 || args.length % 2 != 0 || args.length > 6) {
+		return false;
+	}
+	var half = args.length / 2, kinds = [];
+	for (var i = 0; i < half; i++) {
+		kinds.push(kind(args[i]));
+	}
+	// compare year, month and day in order
+	function key(get) {
+		var k = 0;
+		for (var j = 2; j >= 0; j--) {
+			var index = kinds.indexOf(j);
+			k = k * 100 + (index < 0 ? 0 : get(index));
+		}
+		return k;
+	}
+	var begin = key(function (index) { return value(args[index]); });
+	var end = key(function (index) { return value(args[index + half]); });
+	var current = key(function (index) { return now[kinds[index]]; });
+	return inRange(begin, end, current);
+}
+
+// timeRange(hour), (hour1, hour2), (hour1, min1, hour2, min2), (hour1, min1, sec1, hour2, min2, sec2)
+function timeRange() {
+	var split = splitGMT(arguments), args = split[0], gmt = split[1];
+	var date = new Date();
+	var hour = gmt ? date.getUTCHours() : date.getHours();
+	var now = hour * 3600 + (gmt ? date.getUTCMinutes() : date.getMinutes()) * 60 + (gmt ? date.getUTCSeconds() : date.getSeconds());
+	var a = [];
+	for (var i = 0; i < args.length; i++) {
+		a.push(parseInt(args[i], 10));
+	}
+	switch (a.length) {
+	case 1:
+		return hour == a[0];
+	case 2:
+		return inRange(a[0] * 3600, a[1] * 3600 - 1, now);
+	case 4:
+		return inRange(a[0] * 3600 + a[1] * 60, a[2] * 3600 + a[3] * 60 - 1, now);
+	case 6:
+		return inRange(a[0] * 3600 + a[1] * 60 + a[2], a[3] * 3600 + a[4] * 60 + a[5], now);
+	default:
+		return false;
+	}
+}
+`
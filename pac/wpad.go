@@ -0,0 +1,81 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package PAC
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	com "github.com/linuxdeepin/deepin-network-proxy/com"
+)
+
+// web proxy auto-discovery, url from dhcp option 252 is tried first,
+// then wpad.<domain>/wpad.dat of search domains from most specific one,
+// devolution stops at second level domain, in case wpad of public suffix is used.
+
+// resolv.conf written by NetworkManager or systemd-resolved
+const ResolvConfPath = "/etc/resolv.conf"
+
+// domains of search and domain lines
+func SearchDomains(path string) []string {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	var domains []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || (fields[0] != "search" && fields[0] != "domain") {
+			continue
+		}
+		for _, domain := range fields[1:] {
+			domain = strings.Trim(domain, ".")
+			if domain != "" && !com.MegaExist(domains, domain) {
+				domains = append(domains, domain)
+			}
+		}
+	}
+	return domains
+}
+
+// urls to try in order, corp.example.com -> wpad.corp.example.com, wpad.example.com
+func Candidates(dhcpURL string, domains []string) []string {
+	var urls []string
+	if dhcpURL != "" {
+		urls = append(urls, dhcpURL)
+	}
+	for _, domain := range domains {
+		labels := strings.Split(strings.ToLower(domain), ".")
+		for index := 0; len(labels)-index >= 2; index++ {
+			url := "http://wpad." + strings.Join(labels[index:], ".") + "/wpad.dat"
+			if !com.MegaExist(urls, url) {
+				urls = append(urls, url)
+			}
+		}
+	}
+	return urls
+}
+
+// load first script available, url of script is returned
+func Discover(urls []string) (*Script, string, error) {
+	if len(urls) == 0 {
+		return nil, "", errors.New("no wpad url from dhcp or search domains")
+	}
+	var lastErr error
+	for _, url := range urls {
+		script, err := LoadScript(url)
+		if err == nil {
+			return script, url, nil
+		}
+		logger.Debugf("[wpad] try %s failed, err: %v", url, err)
+		lastErr = err
+	}
+	return nil, "", fmt.Errorf("no wpad script found in %d urls, last err: %v", len(urls), lastErr)
+}
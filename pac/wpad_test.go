@@ -0,0 +1,68 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package PAC
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSearchDomains(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wpad")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "resolv.conf")
+	_ = ioutil.WriteFile(path, []byte("# generated\nnameserver 127.0.0.53\nsearch corp.example.com. lab.example.com\ndomain corp.example.com\n"), 0644)
+	domains := SearchDomains(path)
+	if !reflect.DeepEqual(domains, []string{"corp.example.com", "lab.example.com"}) {
+		t.Errorf("search domains got %v", domains)
+	}
+	if domains := SearchDomains(filepath.Join(dir, "none")); domains != nil {
+		t.Errorf("not exist file should have no domains, got %v", domains)
+	}
+}
+
+func TestCandidates(t *testing.T) {
+	urls := Candidates("http://10.0.0.1/proxy.pac", []string{"a.corp.example.com", "Example.com", "local"})
+	want := []string{
+		"http://10.0.0.1/proxy.pac",
+		"http://wpad.a.corp.example.com/wpad.dat",
+		"http://wpad.corp.example.com/wpad.dat",
+		"http://wpad.example.com/wpad.dat",
+	}
+	if !reflect.DeepEqual(urls, want) {
+		t.Errorf("candidates got %v", urls)
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/wpad.dat" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(testPAC))
+	}))
+	defer server.Close()
+	script, url, err := Discover([]string{server.URL + "/proxy.pac", server.URL + "/wpad.dat"})
+	if err != nil || script == nil || url != server.URL+"/wpad.dat" {
+		t.Errorf("discover got %s, err: %v", url, err)
+	}
+	_, _, err = Discover([]string{server.URL + "/proxy.pac"})
+	if err == nil {
+		t.Error("discover without script should fail")
+	}
+	_, _, err = Discover(nil)
+	if err == nil {
+		t.Error("discover without url should fail")
+	}
+}
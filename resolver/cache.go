@@ -0,0 +1,143 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Resolver
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/groupcache/lru"
+	"github.com/miekg/dns"
+)
+
+const (
+	cacheMaxSize = 4096
+	// ttl of response which has no record, such as NXDOMAIN without SOA
+	negativeTTL = 60
+	// max ttl, in case upstream return too large ttl
+	maxTTL = 3600
+)
+
+// cached response
+type cacheItem struct {
+	msg    *dns.Msg
+	expire time.Time
+	store  time.Time
+}
+
+type cache struct {
+	lru  *lru.Cache
+	lock sync.Mutex
+	// now func, replaced in test
+	now func() time.Time
+}
+
+func newCache(size int) *cache {
+	return &cache{
+		lru: lru.New(size),
+		now: time.Now,
+	}
+}
+
+// cache key is first question, query with multi question is never cached
+func cacheKey(msg *dns.Msg) (string, bool) {
+	if len(msg.Question) != 1 {
+		return "", false
+	}
+	q := msg.Question[0]
+	return strings.ToLower(q.Name) + "/" + strconv.Itoa(int(q.Qtype)) + "/" + strconv.Itoa(int(q.Qclass)), true
+}
+
+// min ttl of all records, opt record is ignored
+func msgTTL(msg *dns.Msg) uint32 {
+	var ttl uint32 = maxTTL
+	found := false
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype == dns.TypeOPT {
+				continue
+			}
+			found = true
+			// negative cache ttl of soa, RFC 2308
+			if soa, ok := rr.(*dns.SOA); ok && soa.Minttl < soa.Hdr.Ttl {
+				if soa.Minttl < ttl {
+					ttl = soa.Minttl
+				}
+				continue
+			}
+			if rr.Header().Ttl < ttl {
+				ttl = rr.Header().Ttl
+			}
+		}
+	}
+	if !found {
+		return negativeTTL
+	}
+	return ttl
+}
+
+// add response to cache, only success and NXDOMAIN response is cached
+func (c *cache) add(query *dns.Msg, resp *dns.Msg) {
+	key, ok := cacheKey(query)
+	if !ok || resp.Truncated {
+		return
+	}
+	if resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError {
+		return
+	}
+	ttl := msgTTL(resp)
+	if ttl == 0 {
+		return
+	}
+	now := c.now()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.lru.Add(key, &cacheItem{
+		msg:    resp.Copy(),
+		expire: now.Add(time.Duration(ttl) * time.Second),
+		store:  now,
+	})
+}
+
+// get response from cache, ttl of records is reduced by elapsed time
+func (c *cache) get(query *dns.Msg) (*dns.Msg, bool) {
+	key, ok := cacheKey(query)
+	if !ok {
+		return nil, false
+	}
+	now := c.now()
+	c.lock.Lock()
+	value, ok := c.lru.Get(key)
+	if !ok {
+		c.lock.Unlock()
+		return nil, false
+	}
+	item := value.(*cacheItem)
+	if !now.Before(item.expire) {
+		c.lru.Remove(key)
+		c.lock.Unlock()
+		return nil, false
+	}
+	msg := item.msg.Copy()
+	c.lock.Unlock()
+
+	elapsed := uint32(now.Sub(item.store) / time.Second)
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype == dns.TypeOPT {
+				continue
+			}
+			if rr.Header().Ttl > elapsed {
+				rr.Header().Ttl -= elapsed
+			} else {
+				rr.Header().Ttl = 0
+			}
+		}
+	}
+	msg.Id = query.Id
+	return msg, true
+}
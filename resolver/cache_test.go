@@ -0,0 +1,52 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Resolver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestCache(t *testing.T) {
+	now := time.Now()
+	c := newCache(16)
+	c.now = func() time.Time { return now }
+
+	query := &dns.Msg{}
+	query.SetQuestion("www.deepin.org.", dns.TypeA)
+	resp := &dns.Msg{}
+	resp.SetReply(query)
+	rr, _ := dns.NewRR("www.deepin.org. 60 IN A 1.2.3.4")
+	resp.Answer = append(resp.Answer, rr)
+	c.add(query, resp)
+
+	// ttl reduced by elapsed time
+	now = now.Add(20 * time.Second)
+	query.Id = 100
+	msg, ok := c.get(query)
+	if !ok {
+		t.Fatal("response should be cached")
+	}
+	if msg.Id != 100 || msg.Answer[0].Header().Ttl != 40 {
+		t.Errorf("cached response is incorrect, id: %v, ttl: %v", msg.Id, msg.Answer[0].Header().Ttl)
+	}
+
+	// expired
+	now = now.Add(40 * time.Second)
+	_, ok = c.get(query)
+	if ok {
+		t.Error("response should be expired")
+	}
+
+	// server failure is never cached
+	resp.Rcode = dns.RcodeServerFailure
+	c.add(query, resp)
+	_, ok = c.get(query)
+	if ok {
+		t.Error("server failure should not be cached")
+	}
+}
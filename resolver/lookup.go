@@ -0,0 +1,138 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Resolver
+
+import (
+	"context"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	com "github.com/linuxdeepin/deepin-network-proxy/com"
+	"github.com/miekg/dns"
+)
+
+// address of host is looked up by nameservers of system with ttl, which resolver of go does not report.
+// host not found by nameservers, such as name in /etc/hosts, is looked up by resolver of go.
+
+const (
+	// nameservers of system
+	resolvConfPath = "/etc/resolv.conf"
+	// ttl of address looked up by resolver of go
+	fallbackTTL = 60 * time.Second
+)
+
+// resolver of nameservers of system, shared by lookups in process,
+// built again only when resolv.conf is modified
+var sysResolver struct {
+	lock    sync.Mutex
+	modTime time.Time
+	r       *Resolver
+}
+
+// resolver of nameservers of system, nil if no nameserver
+func systemResolver() *Resolver {
+	info, err := os.Stat(resolvConfPath)
+	if err != nil {
+		return nil
+	}
+	sysResolver.lock.Lock()
+	defer sysResolver.lock.Unlock()
+	if sysResolver.r != nil && info.ModTime().Equal(sysResolver.modTime) {
+		return sysResolver.r
+	}
+	sysResolver.modTime = info.ModTime()
+	sysResolver.r = nil
+	conf, err := dns.ClientConfigFromFile(resolvConfPath)
+	if err != nil || len(conf.Servers) == 0 {
+		return nil
+	}
+	var addrs []string
+	for _, server := range conf.Servers {
+		addrs = append(addrs, net.JoinHostPort(server, conf.Port))
+	}
+	r, err := NewResolver(addrs)
+	if err != nil {
+		logger.Debugf("create resolver of nameservers %v failed, err: %v", addrs, err)
+		return nil
+	}
+	sysResolver.r = r
+	return r
+}
+
+// look up ipv4 and ipv6 address of host, with min ttl of records, until ctx is done
+func LookupIP(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+	if resolver := systemResolver(); resolver != nil {
+		ips, ttl, err := resolver.lookupIP(ctx, host)
+		if err == nil && len(ips) != 0 {
+			return ips, ttl, nil
+		}
+		logger.Debugf("look up %s by nameservers found no address, err: %v", host, err)
+		if ctx.Err() != nil {
+			return nil, 0, ctx.Err()
+		}
+	}
+	addrs, err := com.SelfResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, 0, err
+	}
+	ips := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		ips = append(ips, addr.IP)
+	}
+	return ips, fallbackTTL, nil
+}
+
+// query a and aaaa of host at the same time
+func (r *Resolver) lookupIP(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+	qtypes := []uint16{dns.TypeA, dns.TypeAAAA}
+	resps := make([]*dns.Msg, len(qtypes))
+	errs := make([]error, len(qtypes))
+	done := make(chan int, len(qtypes))
+	for index, qtype := range qtypes {
+		go func(index int, qtype uint16) {
+			msg := &dns.Msg{}
+			msg.SetQuestion(dns.Fqdn(host), qtype)
+			resps[index], errs[index] = r.ExchangeContext(ctx, msg)
+			done <- index
+		}(index, qtype)
+	}
+	for range qtypes {
+		<-done
+	}
+	ips, ttl := addrsOf(resps...)
+	if len(ips) == 0 {
+		for _, err := range errs {
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+	return ips, ttl, nil
+}
+
+// address records in answer of responses, and min ttl of answers, cname chain expires too
+func addrsOf(resps ...*dns.Msg) ([]net.IP, time.Duration) {
+	var ips []net.IP
+	var ttl uint32 = maxTTL
+	for _, resp := range resps {
+		if resp == nil || resp.Rcode != dns.RcodeSuccess {
+			continue
+		}
+		for _, rr := range resp.Answer {
+			switch record := rr.(type) {
+			case *dns.A:
+				ips = append(ips, record.A)
+			case *dns.AAAA:
+				ips = append(ips, record.AAAA)
+			}
+			if rr.Header().Ttl < ttl {
+				ttl = rr.Header().Ttl
+			}
+		}
+	}
+	return ips, time.Duration(ttl) * time.Second
+}
@@ -0,0 +1,50 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Resolver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestAddrsOf(t *testing.T) {
+	reply := func(qtype uint16, records ...string) *dns.Msg {
+		query := &dns.Msg{}
+		query.SetQuestion("proxy.deepin.org.", qtype)
+		resp := &dns.Msg{}
+		resp.SetReply(query)
+		for _, record := range records {
+			rr, _ := dns.NewRR(record)
+			resp.Answer = append(resp.Answer, rr)
+		}
+		return resp
+	}
+	resp4 := reply(dns.TypeA,
+		"proxy.deepin.org. 30 IN CNAME edge.deepin.org.",
+		"edge.deepin.org. 120 IN A 1.2.3.4",
+		"edge.deepin.org. 120 IN A 1.2.3.5")
+	resp6 := reply(dns.TypeAAAA, "proxy.deepin.org. 90 IN AAAA 2001:db8::1")
+	ips, ttl := addrsOf(resp4, resp6)
+	if len(ips) != 3 || !ips[0].Equal([]byte{1, 2, 3, 4}) || ips[2].String() != "2001:db8::1" {
+		t.Errorf("addrs are %v, want 1.2.3.4 1.2.3.5 2001:db8::1", ips)
+	}
+	// cname expires with addresses
+	if ttl != 30*time.Second {
+		t.Errorf("ttl is %v, want 30s", ttl)
+	}
+
+	// failed response has no address
+	failed := reply(dns.TypeA, "proxy.deepin.org. 30 IN A 1.2.3.6")
+	failed.Rcode = dns.RcodeServerFailure
+	resp4 = reply(dns.TypeA,
+		"proxy.deepin.org. 120 IN A 1.2.3.4",
+		"proxy.deepin.org. 120 IN A 1.2.3.5")
+	ips, ttl = addrsOf(resp4, nil, failed)
+	if len(ips) != 2 || ttl != 120*time.Second {
+		t.Errorf("addrs are %v, ttl %v, want 2 addrs of 120s", ips, ttl)
+	}
+}
@@ -0,0 +1,124 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	logging "github.com/linuxdeepin/deepin-network-proxy/logging"
+	"github.com/miekg/dns"
+)
+
+var logger *logging.Logger
+
+// timeout of one query to upstream
+const queryTimeout = 5 * time.Second
+
+// upstream dns server
+type Upstream interface {
+	// send query and wait for response, until ctx is done
+	ExchangeContext(ctx context.Context, msg *dns.Msg) (*dns.Msg, error)
+	// address of upstream
+	String() string
+}
+
+/*
+upstream address format
+
+	https://1.1.1.1/dns-query   dns over https, RFC 8484
+	tls://1.1.1.1:853           dns over tls, RFC 7858, port default 853
+	tls://dns.google@8.8.8.8    server name and ip can be set both, in case resolve upstream name by plain dns
+	udp://8.8.8.8:53            plain dns, port default 53
+	8.8.8.8                     same as udp
+*/
+func NewUpstream(addr string) (Upstream, error) {
+	if !strings.Contains(addr, "://") {
+		addr = "udp://" + addr
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "https":
+		return newDoHUpstream(u)
+	case "tls":
+		return newDoTUpstream(u)
+	case "udp", "tcp":
+		return newPlainUpstream(u.Scheme, withDefaultPort(u.Host, "53")), nil
+	default:
+		return nil, fmt.Errorf("upstream scheme is invalid, scheme: %s", u.Scheme)
+	}
+}
+
+// add default port if host has no port
+func withDefaultPort(host string, port string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), port)
+}
+
+// resolver forward query to upstreams in order, response is cached according to ttl
+type Resolver struct {
+	upstreams []Upstream
+	cache     *cache
+}
+
+func NewResolver(addrs []string) (*Resolver, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("resolver has no upstream")
+	}
+	resolver := &Resolver{
+		cache: newCache(cacheMaxSize),
+	}
+	for _, addr := range addrs {
+		upstream, err := NewUpstream(addr)
+		if err != nil {
+			logger.Warningf("parse upstream %s failed, err: %v", addr, err)
+			return nil, err
+		}
+		resolver.upstreams = append(resolver.upstreams, upstream)
+	}
+	return resolver, nil
+}
+
+// answer query from cache or upstreams
+func (r *Resolver) Exchange(msg *dns.Msg) (*dns.Msg, error) {
+	return r.ExchangeContext(context.Background(), msg)
+}
+
+// answer query from cache or upstreams, until ctx is done
+func (r *Resolver) ExchangeContext(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	if resp, ok := r.cache.get(msg); ok {
+		return resp, nil
+	}
+	var lastErr error
+	for _, upstream := range r.upstreams {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		resp, err := upstream.ExchangeContext(ctx, msg)
+		if err != nil {
+			logger.Debugf("query upstream %s failed, err: %v", upstream, err)
+			lastErr = err
+			continue
+		}
+		resp.Id = msg.Id
+		r.cache.add(msg, resp)
+		return resp, nil
+	}
+	return nil, fmt.Errorf("all upstreams failed, last err: %v", lastErr)
+}
+
+func init() {
+	logger = logging.New("proxy/resolver")
+}
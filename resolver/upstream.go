@@ -0,0 +1,172 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Resolver
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	com "github.com/linuxdeepin/deepin-network-proxy/com"
+	"github.com/miekg/dns"
+)
+
+// plain dns over udp or tcp
+type plainUpstream struct {
+	addr   string
+	client *dns.Client
+}
+
+func newPlainUpstream(network string, addr string) *plainUpstream {
+	return &plainUpstream{
+		addr: addr,
+		client: &dns.Client{
+			Net:     network,
+			Timeout: queryTimeout,
+			Dialer:  com.NewDialer(queryTimeout),
+		},
+	}
+}
+
+func (up *plainUpstream) ExchangeContext(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	resp, _, err := up.client.ExchangeContext(ctx, msg, up.addr)
+	if err != nil {
+		return nil, err
+	}
+	// response is truncated, retry by tcp
+	if resp.Truncated && up.client.Net == "udp" {
+		client := &dns.Client{Net: "tcp", Timeout: queryTimeout, Dialer: com.NewDialer(queryTimeout)}
+		resp, _, err = client.ExchangeContext(ctx, msg, up.addr)
+	}
+	return resp, err
+}
+
+func (up *plainUpstream) String() string {
+	return up.client.Net + "://" + up.addr
+}
+
+// split user info as server ip, tls://dns.google@8.8.8.8 -> dns.google 8.8.8.8
+func splitServerAddr(u *url.URL) (name string, host string) {
+	if u.User == nil {
+		return u.Hostname(), u.Host
+	}
+	return u.User.Username(), u.Host
+}
+
+// dns over tls
+type dotUpstream struct {
+	addr   string
+	client *dns.Client
+}
+
+func newDoTUpstream(u *url.URL) (*dotUpstream, error) {
+	name, host := splitServerAddr(u)
+	if host == "" {
+		return nil, fmt.Errorf("dot upstream has no host, url: %s", u)
+	}
+	return &dotUpstream{
+		addr: withDefaultPort(host, "853"),
+		client: &dns.Client{
+			Net:     "tcp-tls",
+			Timeout: queryTimeout,
+			Dialer:  com.NewDialer(queryTimeout),
+			TLSConfig: &tls.Config{
+				ServerName: strings.Trim(name, "[]"),
+			},
+		},
+	}, nil
+}
+
+func (up *dotUpstream) ExchangeContext(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	resp, _, err := up.client.ExchangeContext(ctx, msg, up.addr)
+	return resp, err
+}
+
+func (up *dotUpstream) String() string {
+	return "tls://" + up.addr
+}
+
+// dns over https
+type dohUpstream struct {
+	url    string
+	client *http.Client
+}
+
+func newDoHUpstream(u *url.URL) (*dohUpstream, error) {
+	name, host := splitServerAddr(u)
+	if host == "" {
+		return nil, fmt.Errorf("doh upstream has no host, url: %s", u)
+	}
+	dialAddr := withDefaultPort(host, "443")
+	// request url dont contain server ip
+	reqUrl := *u
+	reqUrl.User = nil
+	reqUrl.Host = name
+	if port := u.Port(); port != "" {
+		reqUrl.Host = net.JoinHostPort(name, port)
+	}
+	dialer := com.NewDialer(0)
+	transport := &http.Transport{
+		// always dial server ip if set, so that upstream name is never resolved by plain dns
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, dialAddr)
+		},
+		TLSClientConfig: &tls.Config{
+			ServerName: strings.Trim(name, "[]"),
+		},
+		ForceAttemptHTTP2: true,
+	}
+	return &dohUpstream{
+		url: reqUrl.String(),
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   queryTimeout,
+		},
+	}, nil
+}
+
+func (up *dohUpstream) ExchangeContext(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	// RFC 8484 suggest id 0 for cache friendly
+	query := msg.Copy()
+	query.Id = 0
+	buf, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, up.url, bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := up.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("doh response error, status code: %v", resp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	answer := &dns.Msg{}
+	err = answer.Unpack(body)
+	if err != nil {
+		return nil, err
+	}
+	return answer, nil
+}
+
+func (up *dohUpstream) String() string {
+	return up.url
+}
@@ -36,7 +36,10 @@ export GOPATH=/usr/share/gocode
 %config %{_sysconfdir}/deepin/deepin-proxy/*
 %{_datadir}/dbus-1/system.d/*
 %{_datadir}/dbus-1/system-services/*
+%{_unitdir}/deepin-network-proxy.service
+%{_datadir}/polkit-1/actions/com.deepin.system.proxy.policy
 %{_libexecdir}/deepin-daemon/*
+%{_bindir}/deepin-proxy-ctl
 
 %changelog
 # let's skip this for now
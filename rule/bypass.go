@@ -0,0 +1,236 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Rule
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+/*
+	bypass rule format
+	  10.0.0.0/8        cidr
+	  192.168.1.1       single ip
+	  baidu.com         domain and all sub domain
+	  port:22           single port
+	  port:8000-9000    port range
+	  geoip:CN          ip located in country, need geoip database
+*/
+
+// prefix of port rule
+const portPrefix = "port:"
+
+// port range, include begin and end
+type portRange struct {
+	begin int
+	end   int
+}
+
+// destinations dont use proxy
+type Bypass struct {
+	cidrSl   []*net.IPNet
+	domainSl []string
+	portSl   []portRange
+
+	// iso country code
+	countrySl []string
+	geoIP     *GeoIP
+}
+
+// parse rules to bypass, rule is invalid will return error
+func NewBypass(rules []string) (*Bypass, error) {
+	bypass := &Bypass{}
+	for _, rule := range rules {
+		err := bypass.add(rule)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return bypass, nil
+}
+
+// check if rule is valid
+func CheckRule(rule string) error {
+	return (&Bypass{}).add(rule)
+}
+
+// add one rule
+func (b *Bypass) add(rule string) error {
+	rule = strings.TrimSpace(rule)
+	if rule == "" {
+		return fmt.Errorf("bypass rule is empty")
+	}
+	// port range
+	if strings.HasPrefix(rule, portPrefix) {
+		rg, err := parsePortRange(strings.TrimPrefix(rule, portPrefix))
+		if err != nil {
+			return err
+		}
+		b.portSl = append(b.portSl, rg)
+		return nil
+	}
+	// country
+	if strings.HasPrefix(rule, geoIPPrefix) {
+		country := strings.ToUpper(strings.TrimPrefix(rule, geoIPPrefix))
+		if len(country) != 2 {
+			return fmt.Errorf("country code is invalid, rule: %s", rule)
+		}
+		b.countrySl = append(b.countrySl, country)
+		return nil
+	}
+	// cidr
+	if strings.Contains(rule, "/") {
+		_, ipNet, err := net.ParseCIDR(rule)
+		if err != nil {
+			return err
+		}
+		b.cidrSl = append(b.cidrSl, ipNet)
+		return nil
+	}
+	// single ip
+	if ip := net.ParseIP(rule); ip != nil {
+		bits := net.IPv6len * 8
+		if ip.To4() != nil {
+			ip = ip.To4()
+			bits = net.IPv4len * 8
+		}
+		b.cidrSl = append(b.cidrSl, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		return nil
+	}
+	// domain suffix, *.baidu.com and .baidu.com is same as baidu.com
+	domain := strings.ToLower(strings.TrimLeft(rule, "*."))
+	if domain == "" {
+		return fmt.Errorf("bypass rule is invalid, rule: %s", rule)
+	}
+	b.domainSl = append(b.domainSl, strings.TrimRight(domain, "."))
+	return nil
+}
+
+// check if has geoip rule, geoip database is only needed at that time
+func (b *Bypass) NeedGeoIP() bool {
+	return len(b.countrySl) != 0
+}
+
+// ipv4 cidr rules, can be matched by kernel without proxy
+func (b *Bypass) IPv4CIDRs() []string {
+	var sl []string
+	for _, ipNet := range b.cidrSl {
+		if ipNet.IP.To4() == nil {
+			continue
+		}
+		sl = append(sl, ipNet.String())
+	}
+	return sl
+}
+
+// set geoip database used by geoip rule
+func (b *Bypass) SetGeoIP(geoIP *GeoIP) {
+	b.geoIP = geoIP
+}
+
+// parse port range, such as 22 or 8000-9000
+func parsePortRange(param string) (portRange, error) {
+	sl := strings.SplitN(param, "-", 2)
+	begin, err := strconv.Atoi(sl[0])
+	if err != nil {
+		return portRange{}, fmt.Errorf("port is invalid, port: %s", param)
+	}
+	end := begin
+	if len(sl) == 2 {
+		end, err = strconv.Atoi(sl[1])
+		if err != nil {
+			return portRange{}, fmt.Errorf("port is invalid, port: %s", param)
+		}
+	}
+	if begin <= 0 || end > 65535 || begin > end {
+		return portRange{}, fmt.Errorf("port range is invalid, port: %s", param)
+	}
+	return portRange{begin: begin, end: end}, nil
+}
+
+// port rule matched, empty if none
+func (b *Bypass) matchPort(port int) string {
+	for _, rg := range b.portSl {
+		if port >= rg.begin && port <= rg.end {
+			return rg.String()
+		}
+	}
+	return ""
+}
+
+// port rule, such as port:22 or port:8000-9000
+func (rg portRange) String() string {
+	if rg.begin == rg.end {
+		return portPrefix + strconv.Itoa(rg.begin)
+	}
+	return portPrefix + strconv.Itoa(rg.begin) + "-" + strconv.Itoa(rg.end)
+}
+
+// cidr rule, single ip is written without mask
+func cidrRule(ipNet *net.IPNet) string {
+	ones, bits := ipNet.Mask.Size()
+	if ones == bits {
+		return ipNet.IP.String()
+	}
+	return ipNet.String()
+}
+
+// check if ip or port match bypass rule
+func (b *Bypass) MatchIP(ip net.IP, port int) bool {
+	return b.MatchedIP(ip, port) != ""
+}
+
+// bypass rule matched by ip or port, empty if none
+func (b *Bypass) MatchedIP(ip net.IP, port int) string {
+	if b == nil {
+		return ""
+	}
+	if matched := b.matchPort(port); matched != "" {
+		return matched
+	}
+	for _, ipNet := range b.cidrSl {
+		if ipNet.Contains(ip) {
+			return cidrRule(ipNet)
+		}
+	}
+	// geoip lookup is slow, check at last
+	if len(b.countrySl) != 0 && b.geoIP != nil {
+		country := b.geoIP.Country(ip)
+		for _, elem := range b.countrySl {
+			if elem == country {
+				return geoIPPrefix + elem
+			}
+		}
+	}
+	return ""
+}
+
+// check if domain or port match bypass rule
+func (b *Bypass) MatchDomain(domain string, port int) bool {
+	return b.MatchedDomain(domain, port) != ""
+}
+
+// bypass rule matched by domain or port, empty if none
+func (b *Bypass) MatchedDomain(domain string, port int) string {
+	if b == nil {
+		return ""
+	}
+	if matched := b.matchPort(port); matched != "" {
+		return matched
+	}
+	// domain may be ip literal
+	if ip := net.ParseIP(domain); ip != nil {
+		return b.MatchedIP(ip, port)
+	}
+	domain = strings.TrimRight(strings.ToLower(domain), ".")
+	for _, suffix := range b.domainSl {
+		if domain == suffix || strings.HasSuffix(domain, "."+suffix) {
+			return suffix
+		}
+	}
+	return ""
+}
@@ -0,0 +1,81 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Rule
+
+import (
+	"net"
+	"testing"
+)
+
+func TestBypass(t *testing.T) {
+	bypass, err := NewBypass([]string{"10.0.0.0/8", "192.168.1.1", "*.deepin.org", "port:22", "port:8000-9000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipCases := []struct {
+		ip     string
+		port   int
+		expect bool
+	}{
+		{"10.1.2.3", 443, true},
+		{"192.168.1.1", 443, true},
+		{"192.168.1.2", 443, false},
+		{"1.1.1.1", 22, true},
+		{"1.1.1.1", 8080, true},
+		{"1.1.1.1", 9001, false},
+	}
+	for _, c := range ipCases {
+		if bypass.MatchIP(net.ParseIP(c.ip), c.port) != c.expect {
+			t.Errorf("match ip %s:%d should be %v", c.ip, c.port, c.expect)
+		}
+	}
+	domainCases := []struct {
+		domain string
+		expect bool
+	}{
+		{"deepin.org", true},
+		{"www.Deepin.org.", true},
+		{"notdeepin.org", false},
+		{"10.0.0.1", true},
+	}
+	for _, c := range domainCases {
+		if bypass.MatchDomain(c.domain, 443) != c.expect {
+			t.Errorf("match domain %s should be %v", c.domain, c.expect)
+		}
+	}
+	if cidrs := bypass.IPv4CIDRs(); len(cidrs) != 2 || cidrs[1] != "192.168.1.1/32" {
+		t.Errorf("ipv4 cidr wrong, cidrs: %v", cidrs)
+	}
+	// invalid rule
+	for _, rule := range []string{"port:0", "port:9000-8000", "10.0.0.0/33", ""} {
+		if CheckRule(rule) == nil {
+			t.Errorf("rule %q should be invalid", rule)
+		}
+	}
+}
+
+func TestBypassMatched(t *testing.T) {
+	bypass, err := NewBypass([]string{"10.0.0.0/8", "192.168.1.1", "*.deepin.org", "port:22", "port:8000-9000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		dst    string
+		port   int
+		expect string
+	}{
+		{"10.1.2.3", 443, "10.0.0.0/8"},
+		{"192.168.1.1", 443, "192.168.1.1"},
+		{"www.Deepin.org", 443, "deepin.org"},
+		{"1.1.1.1", 22, "port:22"},
+		{"example.com", 8080, "port:8000-9000"},
+		{"example.com", 443, ""},
+	}
+	for _, c := range cases {
+		if matched := bypass.MatchedDomain(c.dst, c.port); matched != c.expect {
+			t.Errorf("rule matched by %s:%d should be %q, got %q", c.dst, c.port, c.expect, matched)
+		}
+	}
+}
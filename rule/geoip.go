@@ -0,0 +1,67 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Rule
+
+import (
+	"net"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// default geoip database, provided by geoip database package
+const DefaultGeoIPPath = "/usr/share/GeoIP/GeoLite2-Country.mmdb"
+
+// prefix of geoip rule, such as geoip:CN
+const geoIPPrefix = "geoip:"
+
+// country record of maxmind database
+type countryRecord struct {
+	Country struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+// maxmind format geoip database reader
+type GeoIP struct {
+	reader *maxminddb.Reader
+}
+
+// open geoip database
+func OpenGeoIP(path string) (*GeoIP, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &GeoIP{reader: reader}, nil
+}
+
+// get iso country code of ip, return empty if not found
+func (g *GeoIP) Country(ip net.IP) string {
+	if g == nil || ip == nil {
+		return ""
+	}
+	var record countryRecord
+	err := g.reader.Lookup(ip, &record)
+	if err != nil {
+		logger.Debugf("lookup geoip of %s failed, err: %v", ip, err)
+		return ""
+	}
+	if record.Country.IsoCode != "" {
+		return strings.ToUpper(record.Country.IsoCode)
+	}
+	return strings.ToUpper(record.RegisteredCountry.IsoCode)
+}
+
+// close database
+func (g *GeoIP) Close() error {
+	if g == nil {
+		return nil
+	}
+	return g.reader.Close()
+}
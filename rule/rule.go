@@ -0,0 +1,13 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Rule
+
+import logging "github.com/linuxdeepin/deepin-network-proxy/logging"
+
+var logger *logging.Logger
+
+func init() {
+	logger = logging.New("proxy/rule")
+}
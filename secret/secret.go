@@ -0,0 +1,81 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Secret
+
+import (
+	"errors"
+	"fmt"
+)
+
+/*
+	credentials of proxy are stored in keyring of user instead of proxy.yaml,
+	config references secret by secret-id, item in keyring is found by attributes:
+	  application: deepin-network-proxy
+	  id:          App/sock5/sock5_1
+	daemon runs as root, keyring is on session bus of user who starts proxy,
+	org.freedesktop.secrets is used first, secret-tool of libsecret is fallback.
+*/
+
+// application attribute of all items
+const Application = "deepin-network-proxy"
+
+// secret not found in keyring
+var ErrNotFound = errors.New("secret not found")
+
+// keyring of one user
+type Store interface {
+	// password of secret id
+	Lookup(id string) (string, error)
+	// create or replace secret id
+	Store(id string, label string, password string) error
+}
+
+// attributes of item in keyring
+func Attributes(id string) map[string]string {
+	return map[string]string{
+		"application": Application,
+		"id":          id,
+	}
+}
+
+// keyring of user, secret service and secret-tool are tried in order
+func NewStore(uid uint32, gid uint32) Store {
+	return &fallbackStore{
+		stores: []Store{
+			newServiceStore(uid, gid),
+			newToolStore(uid, gid),
+		},
+	}
+}
+
+// try stores in order, secret not found is not retried by next store,
+// next store is only tried when keyring can not be accessed
+type fallbackStore struct {
+	stores []Store
+}
+
+func (s *fallbackStore) Lookup(id string) (string, error) {
+	var errs []error
+	for _, store := range s.stores {
+		password, err := store.Lookup(id)
+		if err == nil || err == ErrNotFound {
+			return password, err
+		}
+		errs = append(errs, err)
+	}
+	return "", fmt.Errorf("lookup secret %s failed, err: %v", id, errs)
+}
+
+func (s *fallbackStore) Store(id string, label string, password string) error {
+	var errs []error
+	for _, store := range s.stores {
+		err := store.Store(id, label, password)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	return fmt.Errorf("store secret %s failed, err: %v", id, errs)
+}
@@ -0,0 +1,60 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Secret
+
+import (
+	"errors"
+	"testing"
+)
+
+type fakeStore struct {
+	secrets map[string]string
+	err     error
+}
+
+func (s *fakeStore) Lookup(id string) (string, error) {
+	if s.err != nil {
+		return "", s.err
+	}
+	password, ok := s.secrets[id]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return password, nil
+}
+
+func (s *fakeStore) Store(id string, label string, password string) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.secrets[id] = password
+	return nil
+}
+
+func TestFallbackStore(t *testing.T) {
+	broken := &fakeStore{err: errors.New("session bus not found")}
+	tool := &fakeStore{secrets: map[string]string{"App/http/a": "pa"}}
+	store := &fallbackStore{stores: []Store{broken, tool}}
+	password, err := store.Lookup("App/http/a")
+	if err != nil || password != "pa" {
+		t.Errorf("lookup got %s, err: %v", password, err)
+	}
+	err = store.Store("App/http/b", "proxy http/b of App", "pb")
+	if err != nil || tool.secrets["App/http/b"] != "pb" {
+		t.Errorf("store got %v, err: %v", tool.secrets, err)
+	}
+
+	// not found is not retried by next store
+	service := &fakeStore{secrets: map[string]string{}}
+	store = &fallbackStore{stores: []Store{service, tool}}
+	if _, err = store.Lookup("App/http/a"); err != ErrNotFound {
+		t.Errorf("lookup should be not found, err: %v", err)
+	}
+
+	store = &fallbackStore{stores: []Store{broken, broken}}
+	if _, err = store.Lookup("App/http/a"); err == nil || err == ErrNotFound {
+		t.Errorf("lookup should fail, err: %v", err)
+	}
+}
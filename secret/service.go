@@ -0,0 +1,263 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Secret
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/godbus/dbus"
+)
+
+// https://specifications.freedesktop.org/secret-service/latest/
+
+const (
+	serviceName      = "org.freedesktop.secrets"
+	servicePath      = "/org/freedesktop/secrets"
+	serviceInterface = "org.freedesktop.Secret.Service"
+	collectionIface  = "org.freedesktop.Secret.Collection"
+	itemInterface    = "org.freedesktop.Secret.Item"
+	promptInterface  = "org.freedesktop.Secret.Prompt"
+	defaultAlias     = "/org/freedesktop/secrets/aliases/default"
+)
+
+// user is asked to unlock keyring, dbus call of caller times out after 25 seconds
+const promptTimeout = 20 * time.Second
+
+// secret transferred by session, value is plain text in plain session
+type secretValue struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+// keyring of org.freedesktop.secrets on session bus of user
+type serviceStore struct {
+	uid uint32
+	gid uint32
+}
+
+func newServiceStore(uid uint32, gid uint32) *serviceStore {
+	return &serviceStore{uid: uid, gid: gid}
+}
+
+func (s *serviceStore) Lookup(id string) (string, error) {
+	sess, err := s.open()
+	if err != nil {
+		return "", err
+	}
+	defer sess.close()
+	var unlocked, locked []dbus.ObjectPath
+	err = sess.service().Call(serviceInterface+".SearchItems", 0, Attributes(id)).Store(&unlocked, &locked)
+	if err != nil {
+		return "", err
+	}
+	if len(unlocked) == 0 && len(locked) == 0 {
+		return "", ErrNotFound
+	}
+	item := firstOf(unlocked, locked)
+	if len(unlocked) == 0 {
+		err = sess.unlock(item)
+		if err != nil {
+			return "", err
+		}
+	}
+	var secret secretValue
+	err = sess.conn.Object(serviceName, item).Call(itemInterface+".GetSecret", 0, sess.path).Store(&secret)
+	if err != nil {
+		return "", err
+	}
+	return string(secret.Value), nil
+}
+
+func (s *serviceStore) Store(id string, label string, password string) error {
+	sess, err := s.open()
+	if err != nil {
+		return err
+	}
+	defer sess.close()
+	collection := dbus.ObjectPath(defaultAlias)
+	var alias dbus.ObjectPath
+	err = sess.service().Call(serviceInterface+".ReadAlias", 0, "default").Store(&alias)
+	if err == nil && alias != "/" {
+		collection = alias
+	}
+	err = sess.unlock(collection)
+	if err != nil {
+		return err
+	}
+	props := map[string]dbus.Variant{
+		itemInterface + ".Label":      dbus.MakeVariant(label),
+		itemInterface + ".Attributes": dbus.MakeVariant(Attributes(id)),
+	}
+	secret := secretValue{
+		Session:     sess.path,
+		Value:       []byte(password),
+		ContentType: "text/plain",
+	}
+	var item, prompt dbus.ObjectPath
+	err = sess.conn.Object(serviceName, collection).Call(collectionIface+".CreateItem", 0, props, secret, true).Store(&item, &prompt)
+	if err != nil {
+		return err
+	}
+	return sess.prompt(prompt)
+}
+
+// connection to secret service with plain session
+type serviceSession struct {
+	conn *dbus.Conn
+	path dbus.ObjectPath
+}
+
+// open plain session, secret is protected by unix socket of session bus
+func (s *serviceStore) open() (*serviceSession, error) {
+	conn, err := dialUserBus(s.uid, s.gid)
+	if err != nil {
+		return nil, err
+	}
+	sess := &serviceSession{conn: conn}
+	var output dbus.Variant
+	err = sess.service().Call(serviceInterface+".OpenSession", 0, "plain", dbus.MakeVariant("")).Store(&output, &sess.path)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return sess, nil
+}
+
+func (sess *serviceSession) close() {
+	_ = sess.conn.Object(serviceName, sess.path).Call("org.freedesktop.Secret.Session.Close", 0).Err
+	_ = sess.conn.Close()
+}
+
+func (sess *serviceSession) service() dbus.BusObject {
+	return sess.conn.Object(serviceName, servicePath)
+}
+
+// unlock item or collection, user may be prompted for password of keyring
+func (sess *serviceSession) unlock(object dbus.ObjectPath) error {
+	var unlocked []dbus.ObjectPath
+	var prompt dbus.ObjectPath
+	err := sess.service().Call(serviceInterface+".Unlock", 0, []dbus.ObjectPath{object}).Store(&unlocked, &prompt)
+	if err != nil {
+		return err
+	}
+	if len(unlocked) != 0 {
+		return nil
+	}
+	return sess.prompt(prompt)
+}
+
+// wait for prompt completed, "/" means no prompt is needed
+func (sess *serviceSession) prompt(prompt dbus.ObjectPath) error {
+	if prompt == "" || prompt == "/" {
+		return nil
+	}
+	rule := fmt.Sprintf("type='signal',interface='%s',member='Completed',path='%s'", promptInterface, prompt)
+	err := sess.conn.BusObject().Call("org.freedesktop.DBus.AddMatch", 0, rule).Err
+	if err != nil {
+		return err
+	}
+	signals := make(chan *dbus.Signal, 4)
+	sess.conn.Signal(signals)
+	defer sess.conn.RemoveSignal(signals)
+	err = sess.conn.Object(serviceName, prompt).Call(promptInterface+".Prompt", 0, "").Err
+	if err != nil {
+		return err
+	}
+	timer := time.NewTimer(promptTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case sig := <-signals:
+			if sig == nil || sig.Path != prompt || sig.Name != promptInterface+".Completed" {
+				continue
+			}
+			if dismissed, _ := sig.Body[0].(bool); dismissed {
+				return errors.New("prompt of keyring is dismissed")
+			}
+			return nil
+		case <-timer.C:
+			_ = sess.conn.Object(serviceName, prompt).Call(promptInterface+".Dismiss", 0).Err
+			return errors.New("prompt of keyring timeout")
+		}
+	}
+}
+
+func firstOf(lists ...[]dbus.ObjectPath) dbus.ObjectPath {
+	for _, list := range lists {
+		if len(list) != 0 {
+			return list[0]
+		}
+	}
+	return ""
+}
+
+// session bus of user only accepts its owner, peer credentials of socket are taken when connecting,
+// so euid of thread is switched to user during connect. credentials are per thread on linux,
+// raw syscall switches current thread only, thread is dropped if credentials can not be restored.
+func dialUserBus(uid uint32, gid uint32) (*dbus.Conn, error) {
+	addr := fmt.Sprintf("unix:path=/run/user/%d/bus", uid)
+	type result struct {
+		conn *dbus.Conn
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		runtime.LockOSThread()
+		conn, err := dialAs(addr, uid, gid)
+		if restoreCreds() == nil {
+			runtime.UnlockOSThread()
+		}
+		ch <- result{conn: conn, err: err}
+	}()
+	res := <-ch
+	if res.err != nil {
+		return nil, res.err
+	}
+	conn := res.conn
+	err := conn.Auth([]dbus.Auth{dbus.AuthExternal(strconv.Itoa(int(uid)))})
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	err = conn.Hello()
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// connect with effective uid and gid of user
+func dialAs(addr string, uid uint32, gid uint32) (*dbus.Conn, error) {
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SETRESGID, ^uintptr(0), uintptr(gid), ^uintptr(0))
+	if errno != 0 {
+		return nil, fmt.Errorf("set egid %d failed, err: %v", gid, errno)
+	}
+	_, _, errno = syscall.RawSyscall(syscall.SYS_SETRESUID, ^uintptr(0), uintptr(uid), ^uintptr(0))
+	if errno != 0 {
+		return nil, fmt.Errorf("set euid %d failed, err: %v", uid, errno)
+	}
+	return dbus.Dial(addr)
+}
+
+// restore euid and egid of daemon, saved ids are kept
+func restoreCreds() error {
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SETRESUID, ^uintptr(0), uintptr(syscall.Getuid()), ^uintptr(0))
+	if errno != 0 {
+		return errno
+	}
+	_, _, errno = syscall.RawSyscall(syscall.SYS_SETRESGID, ^uintptr(0), uintptr(syscall.Getgid()), ^uintptr(0))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
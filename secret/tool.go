@@ -0,0 +1,94 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Secret
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// secret-tool of libsecret, run as user with session bus of user
+const secretTool = "secret-tool"
+
+// keyring accessed by secret-tool, used when session bus can not be connected directly
+type toolStore struct {
+	uid uint32
+	gid uint32
+}
+
+func newToolStore(uid uint32, gid uint32) *toolStore {
+	return &toolStore{uid: uid, gid: gid}
+}
+
+// secret-tool lookup application deepin-network-proxy id App/http/http_1
+func (s *toolStore) Lookup(id string) (string, error) {
+	cmd, err := s.command(append([]string{"lookup"}, attributeArgs(id)...)...)
+	if err != nil {
+		return "", err
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err != nil {
+		// exit with 1 and print nothing if not found
+		if _, ok := err.(*exec.ExitError); ok && stderr.Len() == 0 {
+			return "", ErrNotFound
+		}
+		return "", fmt.Errorf("%s lookup failed, err: %v, stderr: %s", secretTool, err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSuffix(stdout.String(), "\n"), nil
+}
+
+// secret-tool store --label=label application deepin-network-proxy id App/http/http_1, password is read from stdin
+func (s *toolStore) Store(id string, label string, password string) error {
+	args := append([]string{"store", "--label=" + label}, attributeArgs(id)...)
+	cmd, err := s.command(args...)
+	if err != nil {
+		return err
+	}
+	var stderr bytes.Buffer
+	cmd.Stdin = strings.NewReader(password)
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err != nil {
+		return fmt.Errorf("%s store failed, err: %v, stderr: %s", secretTool, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// command run as user, environment only contains what is needed to find session bus
+func (s *toolStore) command(args ...string) (*exec.Cmd, error) {
+	path, err := exec.LookPath(secretTool)
+	if err != nil {
+		return nil, err
+	}
+	home := "/"
+	if u, err := user.LookupId(strconv.Itoa(int(s.uid))); err == nil {
+		home = u.HomeDir
+	}
+	runtimeDir := fmt.Sprintf("/run/user/%d", s.uid)
+	cmd := exec.Command(path, args...)
+	cmd.Env = []string{
+		"HOME=" + home,
+		"XDG_RUNTIME_DIR=" + runtimeDir,
+		"DBUS_SESSION_BUS_ADDRESS=unix:path=" + runtimeDir + "/bus",
+	}
+	cmd.Dir = home
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: &syscall.Credential{Uid: s.uid, Gid: s.gid},
+	}
+	return cmd, nil
+}
+
+// attributes in fixed order
+func attributeArgs(id string) []string {
+	return []string{"application", Application, "id", id}
+}
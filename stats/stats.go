@@ -0,0 +1,266 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Stats
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	logging "github.com/linuxdeepin/deepin-network-proxy/logging"
+)
+
+// traffic through proxy is counted by day per app and per proxy,
+// days older than retention are dropped, counters are flushed to file periodically,
+// so that usage survives daemon restarts and reboots.
+
+var logger *logging.Logger
+
+// default stats path, kept across reboot
+const DefaultPath = "/var/lib/deepin-proxy/stats.json"
+
+// days kept in file
+const retentionDays = 90
+
+// layout of day key
+const dayLayout = "2006-01-02"
+
+// name of app whose owner is unknown
+const UnknownApp = "unknown"
+
+// counters of app or proxy
+type Counter struct {
+	Sent        uint64 `json:"sent"`
+	Received    uint64 `json:"received"`
+	Connections uint64 `json:"connections"`
+	Failures    uint64 `json:"failures"`
+}
+
+func (c *Counter) add(delta Counter) {
+	c.Sent += delta.Sent
+	c.Received += delta.Received
+	c.Connections += delta.Connections
+	c.Failures += delta.Failures
+}
+
+// counters of one app or proxy in period, flat for dbus
+type Entry struct {
+	Name        string
+	Sent        uint64
+	Received    uint64
+	Connections uint64
+	Failures    uint64
+}
+
+// counters of one day
+type day struct {
+	Apps    map[string]*Counter `json:"apps"`
+	Proxies map[string]*Counter `json:"proxies"`
+}
+
+func newDay() *day {
+	return &day{
+		Apps:    make(map[string]*Counter),
+		Proxies: make(map[string]*Counter),
+	}
+}
+
+// counters by day, safe for concurrent use
+type Recorder struct {
+	path string
+
+	lock  sync.Mutex
+	days  map[string]*day
+	dirty bool
+}
+
+func NewRecorder(path string) *Recorder {
+	return &Recorder{
+		path: path,
+		days: make(map[string]*day),
+	}
+}
+
+// load counters saved last time, missing file means no traffic yet
+func (r *Recorder) Load() error {
+	buf, err := ioutil.ReadFile(r.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		logger.Warningf("[stats] read stats failed, err: %v", err)
+		return err
+	}
+	days := make(map[string]*day)
+	err = json.Unmarshal(buf, &days)
+	if err != nil {
+		logger.Warningf("[stats] unmarshal stats failed, err: %v", err)
+		return err
+	}
+	for key, d := range days {
+		if d == nil {
+			d = newDay()
+		}
+		if d.Apps == nil {
+			d.Apps = make(map[string]*Counter)
+		}
+		if d.Proxies == nil {
+			d.Proxies = make(map[string]*Counter)
+		}
+		days[key] = d
+	}
+	r.lock.Lock()
+	r.days = days
+	r.lock.Unlock()
+	return nil
+}
+
+// add delta to counters of app and proxy at day of now, empty app is unknown
+func (r *Recorder) Add(now time.Time, app string, proxy string, delta Counter) {
+	if app == "" {
+		app = UnknownApp
+	}
+	key := now.Format(dayLayout)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	d, ok := r.days[key]
+	if !ok {
+		d = newDay()
+		r.days[key] = d
+		r.prune(now)
+	}
+	addTo(d.Apps, app, delta)
+	addTo(d.Proxies, proxy, delta)
+	r.dirty = true
+}
+
+func addTo(counters map[string]*Counter, name string, delta Counter) {
+	counter, ok := counters[name]
+	if !ok {
+		counter = &Counter{}
+		counters[name] = counter
+	}
+	counter.add(delta)
+}
+
+// drop days out of retention
+func (r *Recorder) prune(now time.Time) {
+	oldest := now.AddDate(0, 0, -retentionDays).Format(dayLayout)
+	for key := range r.days {
+		if key < oldest {
+			delete(r.days, key)
+		}
+	}
+}
+
+// days of period ending at now, 0 means all days kept
+func periodDays(period string) (int, error) {
+	switch period {
+	case "day":
+		return 1, nil
+	case "week":
+		return 7, nil
+	case "month":
+		return 30, nil
+	case "all":
+		return 0, nil
+	default:
+		return 0, fmt.Errorf("period %s is invalid, should be day, week, month or all", period)
+	}
+}
+
+// counters of apps and proxies in period, sorted by bytes
+func (r *Recorder) Query(period string, now time.Time) ([]Entry, []Entry, error) {
+	count, err := periodDays(period)
+	if err != nil {
+		return nil, nil, err
+	}
+	oldest := ""
+	if count > 0 {
+		oldest = now.AddDate(0, 0, 1-count).Format(dayLayout)
+	}
+	apps := make(map[string]*Counter)
+	proxies := make(map[string]*Counter)
+	r.lock.Lock()
+	for key, d := range r.days {
+		if key < oldest {
+			continue
+		}
+		for name, counter := range d.Apps {
+			addTo(apps, name, *counter)
+		}
+		for name, counter := range d.Proxies {
+			addTo(proxies, name, *counter)
+		}
+	}
+	r.lock.Unlock()
+	return sortEntries(apps), sortEntries(proxies), nil
+}
+
+func sortEntries(counters map[string]*Counter) []Entry {
+	entries := make([]Entry, 0, len(counters))
+	for name, counter := range counters {
+		entries = append(entries, Entry{
+			Name:        name,
+			Sent:        counter.Sent,
+			Received:    counter.Received,
+			Connections: counter.Connections,
+			Failures:    counter.Failures,
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Sent+a.Received != b.Sent+b.Received {
+			return a.Sent+a.Received > b.Sent+b.Received
+		}
+		return a.Name < b.Name
+	})
+	return entries
+}
+
+// drop all counters
+func (r *Recorder) Reset() {
+	r.lock.Lock()
+	r.days = make(map[string]*day)
+	r.dirty = true
+	r.lock.Unlock()
+}
+
+// write counters to file if changed, replace old one atomically
+func (r *Recorder) Flush() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !r.dirty {
+		return nil
+	}
+	buf, err := json.Marshal(r.days)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(r.path), 0755)
+	if err != nil {
+		return err
+	}
+	tmp := r.path + ".tmp"
+	err = ioutil.WriteFile(tmp, buf, 0644)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp, r.path)
+	if err != nil {
+		return err
+	}
+	r.dirty = false
+	return nil
+}
+
+func init() {
+	logger = logging.New("proxy/stats")
+}
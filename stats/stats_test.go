@@ -0,0 +1,85 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package Stats
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecorderQuery(t *testing.T) {
+	r := NewRecorder(filepath.Join(t.TempDir(), "stats.json"))
+	now := time.Date(2022, 5, 20, 12, 0, 0, 0, time.Local)
+	r.Add(now, "/usr/bin/firefox", "http/office", Counter{Connections: 1, Sent: 100, Received: 1000})
+	r.Add(now, "/usr/bin/firefox", "http/office", Counter{Failures: 1})
+	r.Add(now, "", "socks5-tcp/home", Counter{Connections: 1, Sent: 10, Received: 10})
+	r.Add(now.AddDate(0, 0, -3), "/usr/bin/git", "http/office", Counter{Connections: 2, Sent: 5000})
+
+	apps, proxies, err := r.Query("day", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apps) != 2 || apps[0].Name != "/usr/bin/firefox" || apps[1].Name != UnknownApp {
+		t.Fatalf("apps of day got %+v", apps)
+	}
+	if apps[0].Connections != 1 || apps[0].Failures != 1 || apps[0].Received != 1000 {
+		t.Errorf("counter of firefox got %+v", apps[0])
+	}
+	if len(proxies) != 2 || proxies[0].Name != "http/office" {
+		t.Errorf("proxies of day got %+v", proxies)
+	}
+
+	apps, proxies, err = r.Query("week", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apps) != 3 || apps[0].Name != "/usr/bin/git" {
+		t.Errorf("apps of week got %+v", apps)
+	}
+	if proxies[0].Name != "http/office" || proxies[0].Connections != 3 || proxies[0].Sent != 5100 {
+		t.Errorf("proxies of week got %+v", proxies)
+	}
+
+	if _, _, err = r.Query("year", now); err == nil {
+		t.Error("invalid period should be rejected")
+	}
+}
+
+func TestRecorderPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lib", "stats.json")
+	now := time.Now()
+	r := NewRecorder(path)
+	r.Add(now, "/usr/bin/curl", "http/office", Counter{Connections: 1, Sent: 42})
+	if err := r.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := NewRecorder(path)
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	apps, _, _ := loaded.Query("all", now)
+	if len(apps) != 1 || apps[0].Name != "/usr/bin/curl" || apps[0].Sent != 42 {
+		t.Errorf("loaded apps got %+v", apps)
+	}
+
+	loaded.Reset()
+	apps, _, _ = loaded.Query("all", now)
+	if len(apps) != 0 {
+		t.Errorf("apps after reset got %+v", apps)
+	}
+}
+
+func TestRecorderPrune(t *testing.T) {
+	r := NewRecorder(filepath.Join(t.TempDir(), "stats.json"))
+	now := time.Date(2022, 5, 20, 12, 0, 0, 0, time.Local)
+	r.Add(now.AddDate(0, 0, -retentionDays-1), "/usr/bin/old", "http/office", Counter{Connections: 1})
+	r.Add(now, "/usr/bin/new", "http/office", Counter{Connections: 1})
+	apps, _, _ := r.Query("all", now)
+	if len(apps) != 1 || apps[0].Name != "/usr/bin/new" {
+		t.Errorf("apps after prune got %+v", apps)
+	}
+}
@@ -0,0 +1,113 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package SysProxy
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// gsettings of glib, run as user with session bus of user, written to dconf of user
+const gsettingsTool = "gsettings"
+
+// schema of system proxy, deepin control center reads the same one
+const proxySchema = "org.gnome.system.proxy"
+
+// one key of gsettings, value is gvariant text
+type gsetting struct {
+	schema string
+	key    string
+	value  string
+}
+
+// keys set for settings, hosts of other protos are cleared so that stale proxy is not used
+func (s Settings) gsettings() []gsetting {
+	if !s.Enabled() {
+		return []gsetting{{proxySchema, "mode", quote("none")}}
+	}
+	keys := []gsetting{
+		{proxySchema, "mode", quote("manual")},
+		{proxySchema, "ignore-hosts", quoteList(s.ignoreHosts(true))},
+	}
+	hostOf := func(socks bool) (string, string) {
+		if socks == s.socks() {
+			return quote(s.Host), strconv.Itoa(s.Port)
+		}
+		return quote(""), "0"
+	}
+	for _, schema := range []string{"http", "https"} {
+		host, port := hostOf(false)
+		keys = append(keys, gsetting{proxySchema + "." + schema, "host", host}, gsetting{proxySchema + "." + schema, "port", port})
+	}
+	host, port := hostOf(true)
+	keys = append(keys, gsetting{proxySchema + ".socks", "host", host}, gsetting{proxySchema + ".socks", "port", port})
+	return keys
+}
+
+// string of gvariant text format
+func quote(str string) string {
+	str = strings.ReplaceAll(str, `\`, `\\`)
+	return "'" + strings.ReplaceAll(str, "'", `\'`) + "'"
+}
+
+// string array of gvariant text format
+func quoteList(strs []string) string {
+	var quoted []string
+	for _, str := range strs {
+		quoted = append(quoted, quote(str))
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// set system proxy of user, apps never see manual mode with old hosts
+func SetGSettings(uid uint32, gid uint32, s Settings) error {
+	keys := s.gsettings()
+	// mode is set last when enabled, hosts are ready when apps react to mode
+	if s.Enabled() {
+		keys = append(append([]gsetting{}, keys[1:]...), keys[0])
+	}
+	for _, elem := range keys {
+		cmd, err := command(uid, gid, "set", elem.schema, elem.key, elem.value)
+		if err != nil {
+			return err
+		}
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		err = cmd.Run()
+		if err != nil {
+			return fmt.Errorf("%s set %s %s failed, err: %v, stderr: %s", gsettingsTool, elem.schema, elem.key, err, strings.TrimSpace(stderr.String()))
+		}
+	}
+	return nil
+}
+
+// command run as user, environment only contains what is needed to find session bus
+func command(uid uint32, gid uint32, args ...string) (*exec.Cmd, error) {
+	path, err := exec.LookPath(gsettingsTool)
+	if err != nil {
+		return nil, err
+	}
+	home := "/"
+	if u, err := user.LookupId(strconv.Itoa(int(uid))); err == nil {
+		home = u.HomeDir
+	}
+	runtimeDir := fmt.Sprintf("/run/user/%d", uid)
+	cmd := exec.Command(path, args...)
+	cmd.Env = []string{
+		"HOME=" + home,
+		"XDG_RUNTIME_DIR=" + runtimeDir,
+		"DBUS_SESSION_BUS_ADDRESS=unix:path=" + runtimeDir + "/bus",
+	}
+	cmd.Dir = home
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: &syscall.Credential{Uid: uid, Gid: gid},
+	}
+	return cmd, nil
+}
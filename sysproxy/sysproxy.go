@@ -0,0 +1,149 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package SysProxy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+/*
+	proxy of active profile is published to desktop, so that apps read proxy settings themselves
+	go the same way as traffic proxied transparently.
+	  org.gnome.system.proxy of users logged in, set by gsettings run as user
+	  environment.d drop-in, read by systemd user manager when session starts
+	credentials are never published, drop-in is readable by all users.
+	bypass rules become ignored hosts, port and geoip rules can not be expressed and are skipped.
+*/
+
+// drop-in of environment for user sessions
+const EnvPath = "/etc/environment.d/90-deepin-network-proxy.conf"
+
+// hosts never proxied, the same as default of gnome
+var defaultIgnore = []string{"localhost", "127.0.0.0/8", "::1"}
+
+// proxy published, empty host means no proxy
+type Settings struct {
+	Scheme string // http socks4 socks5
+	Host   string
+	Port   int
+	Ignore []string // ip, cidr and domain with all sub domains
+}
+
+// settings of proxy, false if proto can not be used by apps
+func FromProxy(proto string, server string, port int, whitelist []string) (Settings, bool) {
+	var scheme string
+	switch proto {
+	case "http":
+		scheme = "http"
+	case "sock4":
+		scheme = "socks4"
+	case "sock5", "socks5":
+		scheme = "socks5"
+	default:
+		return Settings{}, false
+	}
+	if server == "" || port <= 0 {
+		return Settings{}, false
+	}
+	settings := Settings{Scheme: scheme, Host: server, Port: port}
+	for _, rule := range whitelist {
+		if strings.HasPrefix(rule, "port:") || strings.HasPrefix(rule, "geoip:") || rule == "" {
+			continue
+		}
+		settings.Ignore = append(settings.Ignore, rule)
+	}
+	return settings, true
+}
+
+// check if proxy is set
+func (s Settings) Enabled() bool {
+	return s.Host != ""
+}
+
+// check if proxy is socks
+func (s Settings) socks() bool {
+	return s.Scheme == "socks4" || s.Scheme == "socks5"
+}
+
+// url of proxy, like http://10.0.0.1:8080
+func (s Settings) URL() string {
+	return s.Scheme + "://" + net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+}
+
+// hosts not proxied, cidr and ip are kept, domain matches itself and all sub domains
+func (s Settings) ignoreHosts(wildcard bool) []string {
+	hosts := append([]string{}, defaultIgnore...)
+	for _, rule := range s.Ignore {
+		hosts = append(hosts, rule)
+		_, _, err := net.ParseCIDR(rule)
+		if wildcard && err != nil && net.ParseIP(rule) == nil {
+			hosts = append(hosts, "*."+rule)
+		}
+	}
+	return hosts
+}
+
+// content of environment drop-in, both lower and upper case are set as tools read either
+func (s Settings) EnvDropIn() string {
+	if !s.Enabled() {
+		return ""
+	}
+	var lines []string
+	set := func(key string, value string) {
+		lines = append(lines, key+"="+value, strings.ToUpper(key)+"="+value)
+	}
+	if s.socks() {
+		set("all_proxy", s.URL())
+	} else {
+		set("http_proxy", s.URL())
+		set("https_proxy", s.URL())
+	}
+	// no_proxy matches sub domains by suffix
+	set("no_proxy", strings.Join(s.ignoreHosts(false), ","))
+	return "# generated by deepin-network-proxy, do not edit\n" + strings.Join(lines, "\n") + "\n"
+}
+
+// write environment drop-in, file is replaced at once so session never reads half of it
+func WriteEnv(path string, s Settings) error {
+	if !s.Enabled() {
+		return RemoveEnv(path)
+	}
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, []byte(s.EnvDropIn()), 0644)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp, path)
+	if err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("replace %s failed: %v", path, err)
+	}
+	return nil
+}
+
+// remove environment drop-in, not exist is not error
+func RemoveEnv(path string) error {
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// check if drop-in is written, settings are published before daemon restarts
+func EnvExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
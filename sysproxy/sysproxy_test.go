@@ -0,0 +1,84 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package SysProxy
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFromProxy(t *testing.T) {
+	settings, ok := FromProxy("http", "10.0.0.1", 8080, []string{"10.0.0.0/8", "port:22", "geoip:CN", "example.com"})
+	if !ok || settings.URL() != "http://10.0.0.1:8080" {
+		t.Fatalf("settings of http got %+v, ok: %v", settings, ok)
+	}
+	if len(settings.Ignore) != 2 {
+		t.Errorf("port and geoip rules should be skipped, got %v", settings.Ignore)
+	}
+	settings, ok = FromProxy("sock5", "::1", 1080, nil)
+	if !ok || settings.URL() != "socks5://[::1]:1080" {
+		t.Errorf("settings of sock5 got %+v, ok: %v", settings, ok)
+	}
+	if _, ok = FromProxy("masque", "10.0.0.1", 443, nil); ok {
+		t.Error("masque can not be used by apps")
+	}
+}
+
+func TestEnvDropIn(t *testing.T) {
+	settings, _ := FromProxy("http", "10.0.0.1", 8080, []string{"example.com"})
+	env := settings.EnvDropIn()
+	for _, line := range []string{"http_proxy=http://10.0.0.1:8080", "HTTPS_PROXY=http://10.0.0.1:8080", "no_proxy=localhost,127.0.0.0/8,::1,example.com"} {
+		if !strings.Contains(env, line+"\n") {
+			t.Errorf("drop-in should contain %s, got:\n%s", line, env)
+		}
+	}
+	settings, _ = FromProxy("sock5", "10.0.0.1", 1080, nil)
+	if env = settings.EnvDropIn(); !strings.Contains(env, "ALL_PROXY=socks5://10.0.0.1:1080\n") || strings.Contains(env, "http_proxy") {
+		t.Errorf("drop-in of socks got:\n%s", env)
+	}
+
+	path := filepath.Join(t.TempDir(), "environment.d", "proxy.conf")
+	err := WriteEnv(path, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := ioutil.ReadFile(path)
+	if err != nil || string(buf) != env {
+		t.Errorf("drop-in written got %q, err: %v", buf, err)
+	}
+	// no proxy removes drop-in
+	err = WriteEnv(path, Settings{})
+	if err != nil || EnvExists(path) {
+		t.Errorf("drop-in should be removed, err: %v", err)
+	}
+}
+
+func TestGSettings(t *testing.T) {
+	settings, _ := FromProxy("http", "10.0.0.1", 8080, []string{"example.com", "10.0.0.0/8"})
+	want := map[string]string{
+		"org.gnome.system.proxy mode":         "'manual'",
+		"org.gnome.system.proxy ignore-hosts": "['localhost', '127.0.0.0/8', '::1', 'example.com', '*.example.com', '10.0.0.0/8']",
+		"org.gnome.system.proxy.https host":   "'10.0.0.1'",
+		"org.gnome.system.proxy.https port":   "8080",
+		"org.gnome.system.proxy.socks host":   "''",
+	}
+	got := make(map[string]string)
+	for _, elem := range settings.gsettings() {
+		got[elem.schema+" "+elem.key] = elem.value
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("%s got %s, want %s", key, got[key], value)
+		}
+	}
+	if keys := (Settings{}).gsettings(); len(keys) != 1 || keys[0].value != "'none'" {
+		t.Errorf("no proxy should only set mode none, got %v", keys)
+	}
+	if quote(`it's \`) != `'it\'s \\'` {
+		t.Errorf("quote got %s", quote(`it's \`))
+	}
+}
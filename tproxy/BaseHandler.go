@@ -8,13 +8,14 @@ import (
 	"fmt"
 	"net"
 	"sync"
+	"time"
 
 	config "github.com/linuxdeepin/deepin-network-proxy/config"
 	define "github.com/linuxdeepin/deepin-network-proxy/define"
-	"github.com/linuxdeepin/go-lib/log"
+	logging "github.com/linuxdeepin/deepin-network-proxy/logging"
 )
 
-var logger *log.Logger
+var logger *logging.Logger
 
 // handler module
 
@@ -33,6 +34,13 @@ type BaseHandler interface {
 	ReadRemote([]byte) error
 	ReadLocal([]byte) error
 	Communicate()
+	// called once when both directions finish, with bytes sent to and received from remote
+	OnClose(fn func(sent int64, received int64))
+	// called once when first byte is received from remote
+	OnFirstByte(fn func())
+
+	// attach fields like id of connection to log lines
+	AddLogFields(kv ...interface{})
 }
 
 // proto
@@ -44,6 +52,8 @@ const (
 	SOCKS4    ProtoTyp = "socks4"
 	SOCKS5TCP ProtoTyp = "socks5-tcp"
 	SOCKS5UDP ProtoTyp = "socks5-udp"
+	MASQUETCP ProtoTyp = "masque-tcp"
+	MASQUEUDP ProtoTyp = "masque-udp"
 )
 
 func BuildProto(proto string) (ProtoTyp, error) {
@@ -58,6 +68,10 @@ func BuildProto(proto string) (ProtoTyp, error) {
 		return SOCKS5TCP, nil
 	case "socks5-udp":
 		return SOCKS5UDP, nil
+	case "masque", "masque-tcp":
+		return MASQUETCP, nil
+	case "masque-udp":
+		return MASQUEUDP, nil
 	default:
 		return NoneProto, fmt.Errorf("scope is invalid, scope: %v", proto)
 	}
@@ -75,6 +89,10 @@ func (Typ ProtoTyp) String() string {
 		return "socks5-tcp"
 	case SOCKS5UDP:
 		return "socks5-udp"
+	case MASQUETCP:
+		return "masque-tcp"
+	case MASQUEUDP:
+		return "masque-udp"
 	default:
 		return "unknown-proto"
 	}
@@ -102,90 +120,126 @@ type HandlerKey struct {
 // manager all handler
 type HandlerMgr struct {
 	handlerLock sync.Mutex
-	handlerMap  map[ProtoTyp]map[HandlerKey]BaseHandler // handlerMap sync.Map map[http udp]map[HandlerKey]BaseHandler
+	// handlers by proto and endpoints
+	conns *ConnTable
 
 	// scope [global,app]
 	scope define.Scope
 	// chan to stop accept
 	stop chan bool
+
+	// recent latency by proxy
+	latencyLock sync.Mutex
+	handshakes  map[string]*latencyRing
+	firstBytes  map[string]*latencyRing
+
+	// relays tcp tunnels instead of goroutines if not nil
+	relay *EpollRelay
 }
 
 func NewHandlerMgr(scope define.Scope) *HandlerMgr {
 	return &HandlerMgr{
 		scope:      scope,
-		handlerMap: make(map[ProtoTyp]map[HandlerKey]BaseHandler),
+		conns:      NewConnTable(),
 		stop:       make(chan bool),
+		handshakes: make(map[string]*latencyRing),
+		firstBytes: make(map[string]*latencyRing),
 	}
 }
 
-// add handler to mgr
-func (mgr *HandlerMgr) AddHandler(typ ProtoTyp, key HandlerKey, base BaseHandler) {
-	// add lock
+// set epoll relay shared by scopes, nil means goroutines copy data
+func (mgr *HandlerMgr) SetRelay(relay *EpollRelay) {
 	mgr.handlerLock.Lock()
 	defer mgr.handlerLock.Unlock()
-	// check if handler already exist
-	baseMap, ok := mgr.handlerMap[typ]
-	if !ok {
-		baseMap = make(map[HandlerKey]BaseHandler)
-		mgr.handlerMap[typ] = baseMap
-	}
-	_, ok = baseMap[key]
-	if ok {
-		// if exist already, should ignore
-		logger.Debugf("[%s] key has already in map, type: %v, key: %v", mgr.scope, typ, key)
-		return
-	}
-	// add handler
-	baseMap[key] = base
+	mgr.relay = relay
+}
+
+// epoll relay of tunnels, nil if not enabled
+func (mgr *HandlerMgr) Relay() *EpollRelay {
+	mgr.handlerLock.Lock()
+	defer mgr.handlerLock.Unlock()
+	return mgr.relay
+}
+
+// add handler to mgr
+func (mgr *HandlerMgr) AddHandler(typ ProtoTyp, key HandlerKey, base BaseHandler) {
+	mgr.conns.Add(typ, key, base)
 	logger.Debugf("[%s] handler add to manager success, type: %v, key: %v", mgr.scope, typ, key)
 }
 
+// get handler and hold reference, release it after use
+func (mgr *HandlerMgr) AcquireHandler(typ ProtoTyp, key HandlerKey) (BaseHandler, bool) {
+	return mgr.conns.Acquire(typ, key)
+}
+
+// release reference of handler acquired
+func (mgr *HandlerMgr) ReleaseHandler(typ ProtoTyp, key HandlerKey, base BaseHandler) {
+	mgr.conns.Release(typ, key, base)
+}
+
 // close and remove base handler
 func (mgr *HandlerMgr) CloseBaseHandler(typ ProtoTyp, key HandlerKey) {
-	mgr.handlerLock.Lock()
-	defer mgr.handlerLock.Unlock()
-	baseMap, ok := mgr.handlerMap[typ]
-	if !ok {
-		logger.Debugf("[%s] delete base map dont exist in map", mgr.scope)
+	if !mgr.conns.Remove(typ, key, nil) {
+		logger.Debugf("[%s] delete key dont exist in map, key: %v", mgr.scope, key)
 		return
 	}
-	base, ok := baseMap[key]
-	if !ok {
-		logger.Debugf("[%s] delete key dont exist in base map, key: %v", mgr.scope, key)
-		return
-	}
-	// close and delete
-	base.Close()
-	delete(baseMap, key)
 	logger.Debugf("[%s] delete key successfully, key: %v", mgr.scope, key)
 }
 
+// close and remove handler itself, handler of the same key added later is kept
+func (mgr *HandlerMgr) removeHandler(typ ProtoTyp, key HandlerKey, base BaseHandler) {
+	if mgr.conns.Remove(typ, key, base) {
+		logger.Debugf("[%s] delete key successfully, key: %v", mgr.scope, key)
+	}
+}
+
 // close handler according to proto
 func (mgr *HandlerMgr) CloseTypHandler(typ ProtoTyp) {
-	mgr.handlerLock.Lock()
-	defer mgr.handlerLock.Unlock()
-	baseMap, ok := mgr.handlerMap[typ]
-	if !ok {
-		return
-	}
-	// close handler
-	for _, base := range baseMap {
-		base.Close()
-	}
-	// delete proto handler
-	delete(mgr.handlerMap, typ)
+	mgr.conns.RemoveTyp(typ)
+}
+
+// count of handler in all proto
+func (mgr *HandlerMgr) Count() int {
+	return mgr.conns.Count()
+}
+
+// count of handler alive, udp without packages for idle is not counted
+func (mgr *HandlerMgr) LiveCount(idle time.Duration) int {
+	return mgr.conns.LiveCount(time.Now(), idle)
 }
 
 // close all handler
 func (mgr *HandlerMgr) CloseAll() {
-	for proto, _ := range mgr.handlerMap {
+	for _, proto := range mgr.conns.Typs() {
 		mgr.CloseTypHandler(proto)
 	}
 }
 
+// handlers in table, oldest first
+func (mgr *HandlerMgr) Dump() []ConnEntry {
+	return mgr.conns.Dump()
+}
+
+// dead handlers dropped by collecting, total
+func (mgr *HandlerMgr) Collected() uint64 {
+	return mgr.conns.Collected()
+}
+
+// collect dead handlers periodically, zero interval uses default
+func (mgr *HandlerMgr) StartCollect(interval time.Duration) {
+	mgr.conns.StartCollect(interval)
+}
+
+// stop collecting dead handlers
+func (mgr *HandlerMgr) StopCollect() {
+	mgr.conns.StopCollect()
+}
+
 func NewHandler(proto ProtoTyp, scope define.Scope, key HandlerKey, proxy config.Proxy, lAddr net.Addr, rAddr net.Addr, lConn net.Conn) BaseHandler {
 	// search proto
 	switch proto {
+	case NoneProto:
+		return NewDirectHandler(scope, key, proxy, lAddr, rAddr, lConn)
 	case HTTP:
 		return NewHttpHandler(scope, key, proxy, lAddr, rAddr, lConn)
 	case SOCKS4:
@@ -194,6 +248,10 @@ func NewHandler(proto ProtoTyp, scope define.Scope, key HandlerKey, proxy config
 		return NewTcpSock5Handler(scope, key, proxy, lAddr, rAddr, lConn)
 	case SOCKS5UDP:
 		return NewUdpSock5Handler(scope, key, proxy, lAddr, rAddr, lConn)
+	case MASQUETCP:
+		return NewMasqueTcpHandler(scope, key, proxy, lAddr, rAddr, lConn)
+	case MASQUEUDP:
+		return NewMasqueUdpHandler(scope, key, proxy, lAddr, rAddr, lConn)
 	default:
 		logger.Warningf("unknown proto type: %v", proto)
 	}
@@ -201,5 +259,5 @@ func NewHandler(proto ProtoTyp, scope define.Scope, key HandlerKey, proxy config
 }
 
 func init() {
-	logger = log.NewLogger("proxy/tproxy")
+	logger = logging.New("proxy/tproxy")
 }
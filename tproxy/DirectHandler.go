@@ -0,0 +1,49 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package TProxy
+
+import (
+	"net"
+	"time"
+
+	com "github.com/linuxdeepin/deepin-network-proxy/com"
+	config "github.com/linuxdeepin/deepin-network-proxy/config"
+	define "github.com/linuxdeepin/deepin-network-proxy/define"
+)
+
+// direct handler connect remote server without proxy, used by bypass destination
+type DirectHandler struct {
+	handlerPrv
+}
+
+func NewDirectHandler(scope define.Scope, key HandlerKey, proxy config.Proxy, lAddr net.Addr, rAddr net.Addr, lConn net.Conn) *DirectHandler {
+	// create new handler
+	handler := &DirectHandler{
+		handlerPrv: createHandlerPrv(NoneProto, scope, key, proxy, lAddr, rAddr, lConn),
+	}
+	// add self to private parent
+	handler.saveParent(handler)
+	return handler
+}
+
+// dial remote server directly
+func (handler *DirectHandler) Tunnel() error {
+	network := "tcp"
+	if _, ok := handler.rAddr.(*net.UDPAddr); ok {
+		network = "udp"
+	} else if addr, ok := handler.rAddr.(*DomainAddr); ok {
+		network = addr.Network()
+	}
+	rConn, err := com.NewDialer(3*time.Second).Dial(network, handler.rAddr.String())
+	if err != nil {
+		handler.log.Warningf("failed to dial remote server, err: %v", err)
+		return err
+	}
+	handler.log.Infof("direct: tunnel create success, [%s] -> [%s]",
+		handler.lAddr.String(), handler.rAddr.String())
+	// save rConn handler
+	handler.rConn = rConn
+	return nil
+}
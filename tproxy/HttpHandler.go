@@ -31,14 +31,21 @@ func NewHttpHandler(scope define.Scope, key HandlerKey, proxy config.Proxy, lAdd
 	return handler
 }
 
-// create tunnel between proxy and server
+// create tunnel between proxy and server, retry if failed transiently
 func (handler *HttpHandler) Tunnel() error {
+	return handler.retryTunnel(handler.tunnel)
+}
+
+// create tunnel between proxy and server once
+func (handler *HttpHandler) tunnel() error {
 	// dial proxy server
 	rConn, err := handler.dialProxy()
 	if err != nil {
-		logger.Warningf("[http] failed to dial proxy server, err: %v", err)
+		handler.log.Warningf("failed to dial proxy server, err: %v", err)
 		return err
 	}
+	// save rConn handler, released by retry if tunnel failed
+	handler.rConn = rConn
 	// check type
 	//tcpAddr, ok := handler.rAddr.(*net.TCPAddr)
 	//if !ok {
@@ -65,23 +72,23 @@ func (handler *HttpHandler) Tunnel() error {
 		req.Header.Add("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(authMsg)))
 	}
 	// send connect request to rConn to create tunnel
-	logger.Infof("[http] req is %v", req)
+	handler.log.Infof("req is %v", req)
 	err = req.Write(rConn)
 	if err != nil {
-		logger.Warningf("[http] write http tunnel request failed, err: %v", err)
+		handler.log.Warningf("write http tunnel request failed, err: %v", err)
 		return err
 	}
-	logger.Info("[http] write req success")
+	handler.log.Infof("write req success")
 	// read response
 	reader := bufio.NewReader(rConn)
 	resp, err := http.ReadResponse(reader, req)
 	if err != nil {
-		logger.Warningf("[http] read response failed, err: %v", err)
+		handler.log.Warningf("read response failed, err: %v", err)
 		return err
 	} else {
-		logger.Info("[http] read response success")
+		handler.log.Infof("read response success")
 	}
-	logger.Debug(resp.Status)
+	handler.log.Debugf("%v", resp.Status)
 	// close body
 	defer resp.Body.Close()
 	// check if connect success
@@ -89,9 +96,7 @@ func (handler *HttpHandler) Tunnel() error {
 		return fmt.Errorf("proxy response error, status code: %v, message: %s",
 			resp.StatusCode, resp.Status)
 	}
-	logger.Infof("[http] proxy: tunnel create success, [%s] -> [%s] -> [%s]",
+	handler.log.Infof("proxy: tunnel create success, [%s] -> [%s] -> [%s]",
 		handler.lAddr.String(), rConn.RemoteAddr(), handler.rAddr.String())
-	// save rConn handler
-	handler.rConn = rConn
 	return nil
 }
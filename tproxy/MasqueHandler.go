@@ -0,0 +1,457 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package TProxy
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	config "github.com/linuxdeepin/deepin-network-proxy/config"
+	define "github.com/linuxdeepin/deepin-network-proxy/define"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/quic-go/quicvarint"
+)
+
+// masque proxy is experimental,
+// tcp flow is tunneled by http/3 CONNECT (RFC 9114 section 4.4),
+// udp flow is tunneled by CONNECT-UDP (RFC 9298), payload is carried by http datagrams (RFC 9297).
+// tunnels to the same server share one quic connection, each tunnel is one request stream.
+// CONNECT-IP (RFC 9484) is not supported, it carries ip packets which need a tun device,
+// while transparent proxy relays connections and flows.
+
+const (
+	// default uri template of CONNECT-UDP
+	masqueUdpPath = "/.well-known/masque/udp/%s/%s/"
+	// quic connection without tunnels is kept for this long, so that next tunnel skips handshake
+	masqueIdleTimeout = 30 * time.Second
+)
+
+// quic connection shared by tunnels to one server
+type masqueSession struct {
+	key   string
+	qConn quic.Connection
+	cConn *http3.ClientConn
+	// tunnels on connection, connection closes when idle timer fires without tunnels
+	refs int
+	idle *time.Timer
+}
+
+// shared quic connections, keyed by server and dial options
+type masqueSessionPool struct {
+	lock     sync.Mutex
+	sessions map[string]*masqueSession
+	// connection to one server is dialed by one tunnel at a time
+	dialLocks map[string]*sync.Mutex
+}
+
+var masquePool = &masqueSessionPool{
+	sessions:  make(map[string]*masqueSession),
+	dialLocks: make(map[string]*sync.Mutex),
+}
+
+func (pool *masqueSessionPool) dialLock(key string) *sync.Mutex {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	lock, ok := pool.dialLocks[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		pool.dialLocks[key] = lock
+	}
+	return lock
+}
+
+// get connection alive and hold it, nil if none
+func (pool *masqueSessionPool) get(key string) *masqueSession {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	session, ok := pool.sessions[key]
+	if !ok {
+		return nil
+	}
+	if session.qConn.Context().Err() != nil {
+		delete(pool.sessions, key)
+		return nil
+	}
+	session.refs++
+	if session.idle != nil {
+		session.idle.Stop()
+		session.idle = nil
+	}
+	return session
+}
+
+// save connection dialed and hold it, dropped from pool once closed
+func (pool *masqueSessionPool) put(key string, qConn quic.Connection, cConn *http3.ClientConn) *masqueSession {
+	session := &masqueSession{key: key, qConn: qConn, cConn: cConn, refs: 1}
+	pool.lock.Lock()
+	pool.sessions[key] = session
+	pool.lock.Unlock()
+	go func() {
+		<-qConn.Context().Done()
+		pool.lock.Lock()
+		defer pool.lock.Unlock()
+		if pool.sessions[key] == session {
+			delete(pool.sessions, key)
+		}
+	}()
+	return session
+}
+
+// release connection held, closed if no tunnel uses it after idle timeout
+func (pool *masqueSessionPool) release(session *masqueSession) {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	session.refs--
+	if session.refs > 0 {
+		return
+	}
+	session.idle = time.AfterFunc(masqueIdleTimeout, func() {
+		pool.lock.Lock()
+		if session.refs > 0 {
+			pool.lock.Unlock()
+			return
+		}
+		if pool.sessions[session.key] == session {
+			delete(pool.sessions, session.key)
+		}
+		pool.lock.Unlock()
+		_ = session.qConn.CloseWithError(0, "")
+	})
+}
+
+// key of shared connection, tunnels with different socket options dont share
+func masqueKey(proxy config.Proxy) string {
+	return fmt.Sprintf("%s|%+v", net.JoinHostPort(proxy.Server, strconv.Itoa(proxy.Port)), proxy.Dial)
+}
+
+// get shared connection to masque proxy server, dial if none, release it when tunnel closes
+func (pr *handlerPrv) acquireMasque() (*masqueSession, error) {
+	key := masqueKey(pr.proxy)
+	dialLock := masquePool.dialLock(key)
+	dialLock.Lock()
+	defer dialLock.Unlock()
+	if session := masquePool.get(key); session != nil {
+		return session, nil
+	}
+	qConn, cConn, err := pr.dialMasque()
+	if err != nil {
+		return nil, err
+	}
+	return masquePool.put(key, qConn, cConn), nil
+}
+
+// dial masque proxy server, return quic connection and http/3 client connection,
+// datagrams are enabled as connection is shared by tcp and udp tunnels
+func (pr *handlerPrv) dialMasque() (quic.Connection, *http3.ClientConn, error) {
+	proxy := pr.proxy
+	if proxy.Port == 0 {
+		proxy.Port = 443
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tlsConf := &tls.Config{
+		ServerName: proxy.Server,
+		NextProtos: []string{http3.NextProtoH3},
+	}
+	quicConf := &quic.Config{
+		EnableDatagrams: true,
+		KeepAlivePeriod: 15 * time.Second,
+	}
+	rAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(proxy.Server, strconv.Itoa(proxy.Port)))
+	if err != nil {
+		pr.log.Warningf("resolve proxy server failed, err: %v", err)
+		return nil, nil, &unreachableErr{err: err}
+	}
+	// udp socket is created here so that it is marked as dial of daemon, closed with quic connection
+	lc := net.ListenConfig{Control: dialOpt(proxy).Control}
+	pConn, err := lc.ListenPacket(ctx, "udp", "")
+	if err != nil {
+		pr.log.Warningf("create udp socket failed, err: %v", err)
+		return nil, nil, err
+	}
+	qConn, err := quic.Dial(ctx, pConn, rAddr, tlsConf, quicConf)
+	if err != nil {
+		_ = pConn.Close()
+		pr.log.Warningf("dial proxy server failed, err: %v", err)
+		return nil, nil, &unreachableErr{err: err}
+	}
+	go func() {
+		<-qConn.Context().Done()
+		_ = pConn.Close()
+	}()
+	pr.log.Infof("dial proxy server success, local [%s] -> remote [%s]", qConn.LocalAddr(), qConn.RemoteAddr())
+	transport := &http3.Transport{
+		EnableDatagrams: true,
+	}
+	return qConn, transport.NewClientConn(qConn), nil
+}
+
+// send request on new stream, return stream if proxy accept
+func (pr *handlerPrv) openMasqueStream(cConn *http3.ClientConn, req *http.Request) (str http3.RequestStream, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	str, err = cConn.OpenRequestStream(ctx)
+	if err != nil {
+		pr.log.Warningf("open request stream failed, err: %v", err)
+		return nil, err
+	}
+	// stream not returned is closed, or it stays open on shared connection
+	defer func() {
+		if err != nil {
+			str.CancelRead(0)
+			_ = str.Close()
+			str = nil
+		}
+	}()
+	// check if need auth
+	if pr.proxy.UserName != "" && pr.proxy.Password != "" {
+		authMsg := pr.proxy.UserName + ":" + pr.proxy.Password
+		req.Header.Add("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(authMsg)))
+	}
+	err = str.SendRequestHeader(req)
+	if err != nil {
+		pr.log.Warningf("send request header failed, err: %v", err)
+		return str, err
+	}
+	resp, err := str.ReadResponse()
+	if err != nil {
+		pr.log.Warningf("read response failed, err: %v", err)
+		return str, err
+	}
+	// any 2xx means tunnel is created
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return str, fmt.Errorf("proxy response error, status code: %v, message: %s",
+			resp.StatusCode, resp.Status)
+	}
+	return str, nil
+}
+
+// split remote addr to host and port
+func splitMasqueAddr(addr net.Addr) (string, int, error) {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return addr.IP.String(), addr.Port, nil
+	case *net.UDPAddr:
+		return addr.IP.String(), addr.Port, nil
+	case *DomainAddr:
+		return addr.Domain, addr.Port, nil
+	default:
+		return "", 0, fmt.Errorf("unsupported addr type: %T", addr)
+	}
+}
+
+// stream of masque tunnel, use as remote conn
+type masqueConn struct {
+	http3.Stream
+	session   *masqueSession
+	closeOnce sync.Once
+}
+
+func (conn *masqueConn) LocalAddr() net.Addr {
+	return conn.session.qConn.LocalAddr()
+}
+
+func (conn *masqueConn) RemoteAddr() net.Addr {
+	return conn.session.qConn.RemoteAddr()
+}
+
+// close stream, quic connection is shared and released
+func (conn *masqueConn) Close() error {
+	var err error
+	conn.closeOnce.Do(func() {
+		conn.Stream.CancelRead(0)
+		err = conn.Stream.Close()
+		masquePool.release(conn.session)
+	})
+	return err
+}
+
+// datagram of masque udp tunnel, each read and write is one udp package
+type masqueDatagramConn struct {
+	masqueConn
+}
+
+// read udp package, ignore datagram with unknown context id
+func (conn *masqueDatagramConn) Read(buf []byte) (int, error) {
+	for {
+		data, err := conn.ReceiveDatagram(context.Background())
+		if err != nil {
+			return 0, err
+		}
+		ctxId, n, err := quicvarint.Parse(data)
+		if err != nil {
+			logger.Debugf("[%s] parse datagram context id failed, err: %v", MASQUEUDP, err)
+			continue
+		}
+		// context id 0 carries udp payload
+		if ctxId != 0 {
+			continue
+		}
+		return copy(buf, data[n:]), nil
+	}
+}
+
+// write udp package with context id 0
+func (conn *masqueDatagramConn) Write(buf []byte) (int, error) {
+	data := make([]byte, 0, len(buf)+1)
+	data = quicvarint.Append(data, 0)
+	data = append(data, buf...)
+	err := conn.SendDatagram(data)
+	if err != nil {
+		return 0, err
+	}
+	return len(buf), nil
+}
+
+// tcp handler over masque proxy
+type MasqueTcpHandler struct {
+	handlerPrv
+}
+
+func NewMasqueTcpHandler(scope define.Scope, key HandlerKey, proxy config.Proxy, lAddr net.Addr, rAddr net.Addr, lConn net.Conn) *MasqueTcpHandler {
+	// create new handler
+	handler := &MasqueTcpHandler{
+		handlerPrv: createHandlerPrv(MASQUETCP, scope, key, proxy, lAddr, rAddr, lConn),
+	}
+	// add self to private parent
+	handler.saveParent(handler)
+	return handler
+}
+
+// create tunnel between proxy and server, retry if failed transiently
+func (handler *MasqueTcpHandler) Tunnel() error {
+	return handler.retryTunnel(handler.tunnel)
+}
+
+// create tunnel between proxy and server once
+func (handler *MasqueTcpHandler) tunnel() error {
+	host, port, err := splitMasqueAddr(handler.rAddr)
+	if err != nil {
+		handler.log.Warningf("tunnel addr is invalid, err: %v", err)
+		return err
+	}
+	// dial proxy server or use connection to it
+	session, err := handler.acquireMasque()
+	if err != nil {
+		handler.log.Warningf("failed to dial proxy server, err: %v", err)
+		return err
+	}
+	target := net.JoinHostPort(host, strconv.Itoa(port))
+	req := &http.Request{
+		Method: http.MethodConnect,
+		Host:   target,
+		URL: &url.URL{
+			Host: target,
+		},
+		Header: http.Header{},
+	}
+	str, err := handler.openMasqueStream(session.cConn, req)
+	if err != nil {
+		masquePool.release(session)
+		return err
+	}
+	handler.log.Infof("proxy: tunnel create success, [%s] -> [%s] -> [%s]",
+		handler.lAddr.String(), session.qConn.RemoteAddr(), handler.rAddr.String())
+	// save rConn handler
+	handler.rConn = &masqueConn{
+		Stream:  str,
+		session: session,
+	}
+	return nil
+}
+
+// udp handler over masque proxy
+type MasqueUdpHandler struct {
+	handlerPrv
+}
+
+func NewMasqueUdpHandler(scope define.Scope, key HandlerKey, proxy config.Proxy, lAddr net.Addr, rAddr net.Addr, lConn net.Conn) *MasqueUdpHandler {
+	// create new handler
+	handler := &MasqueUdpHandler{
+		handlerPrv: createHandlerPrv(MASQUEUDP, scope, key, proxy, lAddr, rAddr, lConn),
+	}
+	// add self to private parent
+	handler.saveParent(handler)
+	return handler
+}
+
+// create tunnel between proxy and server, retry if failed transiently
+func (handler *MasqueUdpHandler) Tunnel() error {
+	return handler.retryTunnel(handler.tunnel)
+}
+
+// create tunnel between proxy and server once
+func (handler *MasqueUdpHandler) tunnel() error {
+	host, port, err := splitMasqueAddr(handler.rAddr)
+	if err != nil {
+		handler.log.Warningf("tunnel addr is invalid, err: %v", err)
+		return err
+	}
+	// dial proxy server or use connection to it
+	session, err := handler.acquireMasque()
+	if err != nil {
+		handler.log.Warningf("failed to dial proxy server, err: %v", err)
+		return err
+	}
+	// CONNECT-UDP need extended connect and http datagrams, check server settings first
+	select {
+	case <-session.cConn.ReceivedSettings():
+	case <-time.After(3 * time.Second):
+		masquePool.release(session)
+		return errors.New("wait proxy server settings timeout")
+	}
+	settings := session.cConn.Settings()
+	if !settings.EnableExtendedConnect || !settings.EnableDatagrams {
+		masquePool.release(session)
+		return errors.New("proxy server dont support CONNECT-UDP")
+	}
+	proxyPort := handler.proxy.Port
+	if proxyPort == 0 {
+		proxyPort = 443
+	}
+	proxyHost := net.JoinHostPort(handler.proxy.Server, strconv.Itoa(proxyPort))
+	// ipv6 colons must be percent-encoded in path
+	target := strings.ReplaceAll(host, ":", "%3A")
+	req := &http.Request{
+		Method: http.MethodConnect,
+		Proto:  "connect-udp",
+		Host:   proxyHost,
+		URL: &url.URL{
+			Scheme:  "https",
+			Host:    proxyHost,
+			Path:    fmt.Sprintf(masqueUdpPath, host, strconv.Itoa(port)),
+			RawPath: fmt.Sprintf(masqueUdpPath, target, strconv.Itoa(port)),
+		},
+		Header: http.Header{
+			"Capsule-Protocol": []string{"?1"},
+		},
+	}
+	str, err := handler.openMasqueStream(session.cConn, req)
+	if err != nil {
+		masquePool.release(session)
+		return err
+	}
+	handler.log.Infof("proxy: tunnel create success, [%s] -> [%s] -> [%s]",
+		handler.lAddr.String(), session.qConn.RemoteAddr(), handler.rAddr.String())
+	// save rConn handler
+	handler.rConn = &masqueDatagramConn{
+		masqueConn: masqueConn{
+			Stream:  str,
+			session: session,
+		},
+	}
+	return nil
+}
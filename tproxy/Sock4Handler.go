@@ -30,29 +30,44 @@ func NewSock4Handler(scope define.Scope, key HandlerKey, proxy config.Proxy, lAd
 	return handler
 }
 
+// create tunnel between proxy and server, retry if failed transiently
 func (handler *Sock4Handler) Tunnel() error {
+	return handler.retryTunnel(handler.tunnel)
+}
+
+// create tunnel between proxy and server once
+func (handler *Sock4Handler) tunnel() error {
 	// dial proxy server
 	rConn, err := handler.dialProxy()
 	if err != nil {
-		logger.Warningf("[sock4] failed to dial proxy server, err: %v", err)
+		handler.log.Warningf("failed to dial proxy server, err: %v", err)
 		return err
 	}
+	// save rConn handler, released by retry if tunnel failed
+	handler.rConn = rConn
 	// check type
 	var port uint16
 	var ip net.IP
 	dominname := ""
 	switch addr := handler.rAddr.(type) {
 	case *net.TCPAddr:
+		port = uint16(addr.Port)
 		ip = addr.IP
 	case *DomainAddr:
 		port = uint16(addr.Port)
 		ip = net.IPv4(0x00, 0x00, 0x00, 0x01)
 		dominname = addr.Domain
 	default:
-		logger.Warning("[sock4] tunnel addr type is not tcp")
+		handler.log.Warningf("tunnel addr type is not tcp")
 		return errors.New("type is not tcp")
 	}
 
+	// sock4 carries ipv4 only
+	if ip.To4() == nil {
+		handler.log.Warningf("sock4 can not connect ipv6 destination %s", ip)
+		return errors.New("ipv6 destination is not supported by sock4")
+	}
+
 	// sock4 dont support password auth
 	auth := auth{
 		user: handler.proxy.UserName,
@@ -91,10 +106,10 @@ func (handler *Sock4Handler) Tunnel() error {
 	}
 
 	// request proxy connect rConn server
-	logger.Debugf("[sock4] send connect request, buf: %v", buf.Bytes())
+	handler.log.Debugf("send connect request, buf: %v", buf.Bytes())
 	_, err = rConn.Write(buf.Bytes())
 	if err != nil {
-		logger.Warningf("[sock4] send connect request failed, err: %v", err)
+		handler.log.Warningf("send connect request failed, err: %v", err)
 		return err
 	}
 
@@ -102,7 +117,7 @@ func (handler *Sock4Handler) Tunnel() error {
 	tmp := buf.Bytes()
 	_, err = io.ReadFull(rConn, tmp[0:2])
 	if err != nil {
-		logger.Warningf("[sock4] connect response failed, err: %v", err)
+		handler.log.Warningf("connect response failed, err: %v", err)
 		return err
 	}
 	/*
@@ -115,21 +130,19 @@ func (handler *Sock4Handler) Tunnel() error {
 	*/
 	// 0   0x5A
 	if tmp[0] != 0 || tmp[1] != 90 {
-		logger.Warningf("[sock4] proto is invalid, sock type: %v, code: %v", tmp[0], tmp[1])
+		handler.log.Warningf("proto is invalid, sock type: %v, code: %v", tmp[0], tmp[1])
 		return fmt.Errorf("sock4 proto is invalid, sock type: %v, code: %v", tmp[0], tmp[1])
 	}
 
 	// port and ip
 	_, err = io.ReadFull(rConn, tmp[0:6])
 	if err != nil {
-		logger.Warningf("[sock4] connect response failed, err: %v", err)
+		handler.log.Warningf("connect response failed, err: %v", err)
 		return err
 	}
 
-	logger.Debugf("[sock4] port and ip: %v", tmp[0:6])
-	logger.Debugf("[sock4] proxy: tunnel create success, [%s] -> [%s] -> [%s]",
+	handler.log.Debugf("port and ip: %v", tmp[0:6])
+	handler.log.Debugf("proxy: tunnel create success, [%s] -> [%s] -> [%s]",
 		handler.lConn.RemoteAddr(), rConn.RemoteAddr(), handler.rAddr.String())
-	// save rConn handler
-	handler.rConn = rConn
 	return nil
 }
@@ -29,27 +29,35 @@ func NewTcpSock5Handler(scope define.Scope, key HandlerKey, proxy config.Proxy,
 	return handler
 }
 
-// create tunnel between proxy and server
+// create tunnel between proxy and server, retry if failed transiently
 func (handler *TcpSock5Handler) Tunnel() error {
+	return handler.retryTunnel(handler.tunnel)
+}
+
+// create tunnel between proxy and server once
+func (handler *TcpSock5Handler) tunnel() error {
 	// dial proxy server
 	rConn, err := handler.dialProxy()
 	if err != nil {
-		logger.Warningf("[%s] failed to dial proxy server, err: %v", handler.typ, err)
+		handler.log.Warningf("failed to dial proxy server, err: %v", err)
 		return err
 	}
+	// save rConn handler, released by retry if tunnel failed
+	handler.rConn = rConn
 	// check type
 	var port uint16
 	var ip net.IP
 	dominname := ""
 	switch addr := handler.rAddr.(type) {
 	case *net.TCPAddr:
+		port = uint16(addr.Port)
 		ip = addr.IP
 	case *DomainAddr:
 		port = uint16(addr.Port)
 		ip = net.IPv4(0x00, 0x00, 0x00, 0x01)
 		dominname = addr.Domain
 	default:
-		logger.Warningf("[%s] tunnel addr type is not tcp", handler.typ)
+		handler.log.Warningf("tunnel addr type is not tcp")
 		return errors.New("type is not tcp")
 	}
 	// auth message
@@ -78,7 +86,7 @@ func (handler *TcpSock5Handler) Tunnel() error {
 	// sock5 hand shake
 	_, err = rConn.Write(buf)
 	if err != nil {
-		logger.Warningf("[%s] hand shake request failed, err: %v", handler.typ, err)
+		handler.log.Warningf("hand shake request failed, err: %v", err)
 		return err
 	}
 	/*
@@ -91,16 +99,16 @@ func (handler *TcpSock5Handler) Tunnel() error {
 	*/
 	_, err = rConn.Read(buf)
 	if err != nil {
-		logger.Warningf("[%s] hand shake response failed, err: %v", handler.typ, err)
+		handler.log.Warningf("hand shake response failed, err: %v", err)
 		return err
 	}
-	logger.Debugf("[%s] hand shake response success message auth method: %v", handler.typ, buf[1])
+	handler.log.Debugf("hand shake response success message auth method: %v", buf[1])
 	if buf[0] != 5 || (buf[1] != 0 && buf[1] != 2) {
 		return fmt.Errorf("sock5 proto is invalid, sock type: %v, method: %v", buf[0], buf[1])
 	}
 	// check if server need auth
 	if buf[1] == 2 {
-		logger.Debugf("[%s] proxy need auth, start authenticating...", handler.typ)
+		handler.log.Debugf("proxy need auth, start authenticating...")
 		/*
 		    sock5 auth request
 		  +----+------+----------+------+----------+
@@ -118,21 +126,21 @@ func (handler *TcpSock5Handler) Tunnel() error {
 		// write auth message to writer
 		_, err = rConn.Write(buf)
 		if err != nil {
-			logger.Warningf("[%s] auth request failed, err: %v", handler.typ, err)
+			handler.log.Warningf("auth request failed, err: %v", err)
 			return err
 		}
 		buf = make([]byte, 32)
 		_, err = rConn.Read(buf)
 		if err != nil {
-			logger.Warningf("[%s] auth response failed, err: %v", handler.typ, err)
+			handler.log.Warningf("auth response failed, err: %v", err)
 			return err
 		}
 		// RFC1929 user/pass auth should return 1, but some sock5 return 5
 		if buf[0] != 5 && buf[0] != 1 {
-			logger.Warningf("[%s] auth response incorrect code, code: %v", handler.typ, buf[0])
+			handler.log.Warningf("auth response incorrect code, code: %v", buf[0])
 			return fmt.Errorf("incorrect sock5 auth response, code: %v", buf[0])
 		}
-		logger.Debugf("[%s] auth success, code: %v", handler.typ, buf[0])
+		handler.log.Debugf("auth success, code: %v", buf[0])
 	}
 	/*
 			sock5 connect request
@@ -149,7 +157,8 @@ func (handler *TcpSock5Handler) Tunnel() error {
 	buf[2] = 0 // reserved
 	// add tcpAddr
 	if dominname == "" {
-		if len(ip) == net.IPv4len && ip.To4() != nil {
+		// ipv4 accepted by any listener is sent as ipv4, even if it is 16 bytes
+		if ip.To4() != nil {
 			buf[3] = 1
 			buf = append(buf, ip.To4()...)
 		} else if ip.To16() != nil {
@@ -174,30 +183,30 @@ func (handler *TcpSock5Handler) Tunnel() error {
 	binary.BigEndian.PutUint16(portByte, port)
 	buf = append(buf, portByte...)
 	// request proxy connect rConn server
-	logger.Debugf("[%s] send connect request, buf: %v", handler.typ, buf)
+	handler.log.Debugf("send connect request, buf: %v", buf)
 	_, err = rConn.Write(buf)
 	if err != nil {
-		logger.Warningf("[%s] send connect request failed, err: %v", handler.typ, err)
+		handler.log.Warningf("send connect request failed, err: %v", err)
 		return err
 	}
-	logger.Debugf("[%s] request successfully", handler.typ)
+	handler.log.Debugf("request successfully")
 
 	// resp
 	// VER REP RSV
 	_, err = io.ReadFull(rConn, buf[0:3])
 	if err != nil {
-		logger.Warningf("[%s] connect response failed, err: %v", handler.typ, err)
+		handler.log.Warningf("connect response failed, err: %v", err)
 		return err
 	}
 	if buf[0] != 5 || buf[1] != 0 {
-		logger.Warningf("[%s] connect response failed, version: %v, code: %v", handler.typ, buf[0], buf[1])
+		handler.log.Warningf("connect response failed, version: %v, code: %v", buf[0], buf[1])
 		return fmt.Errorf("incorrect sock5 connect reponse, version: %v, code: %v", buf[0], buf[1])
 	}
 
 	// ATYPE
 	_, err = io.ReadFull(rConn, buf[0:1])
 	if err != nil {
-		logger.Warningf("[%s] connect response failed, err: %v", handler.typ, err)
+		handler.log.Warningf("connect response failed, err: %v", err)
 		return err
 	}
 
@@ -211,7 +220,7 @@ func (handler *TcpSock5Handler) Tunnel() error {
 	case 3:
 		_, err = io.ReadFull(rConn, buf[0:1])
 		if err != nil {
-			logger.Warningf("[%s] connect response failed, err: %v", handler.typ, err)
+			handler.log.Warningf("connect response failed, err: %v", err)
 			return err
 		}
 		addrLen = int(buf[0])
@@ -225,20 +234,18 @@ func (handler *TcpSock5Handler) Tunnel() error {
 
 	_, err = io.ReadFull(rConn, buf[0:addrLen])
 	if err != nil {
-		logger.Warningf("[%s] connect response failed, err: %v", handler.typ, err)
+		handler.log.Warningf("connect response failed, err: %v", err)
 		return err
 	}
 
 	// PORT
 	_, err = io.ReadFull(rConn, buf[0:2])
 	if err != nil {
-		logger.Warningf("[%s] connect response failed, err: %v", handler.typ, err)
+		handler.log.Warningf("connect response failed, err: %v", err)
 		return err
 	}
 
-	logger.Debugf("[%s] proxy: tunnel create success, [%s] -> [%s] -> [%s]",
-		handler.typ, handler.lAddr.String(), rConn.RemoteAddr(), handler.rAddr.String())
-	// save rConn handler
-	handler.rConn = rConn
+	handler.log.Debugf("proxy: tunnel create success, [%s] -> [%s] -> [%s]",
+		handler.lAddr.String(), rConn.RemoteAddr(), handler.rAddr.String())
 	return nil
 }
@@ -11,6 +11,8 @@ import (
 	"io"
 	"net"
 	"strconv"
+	"sync"
+	"sync/atomic"
 
 	com "github.com/linuxdeepin/deepin-network-proxy/com"
 	config "github.com/linuxdeepin/deepin-network-proxy/config"
@@ -49,12 +51,16 @@ func (handler *UdpSock5Handler) Read(buf []byte) (int, error) {
 	data := make([]byte, 512)
 	n, err := handler.rConn.Read(data)
 	if err != nil {
-		logger.Warningf("read remote failed, err: %v", err)
+		handler.log.Warningf("read remote failed, err: %v", err)
 		return n, err
 	}
-	pkgData := com.UnMarshalPackage(data)
-	copy(buf, pkgData.Data)
-	return n, nil
+	pkgData, err := com.UnMarshalPackage(data[:n])
+	if err != nil {
+		handler.log.Warningf("unmarshal remote package failed, err: %v", err)
+		return 0, err
+	}
+	handler.touch()
+	return copy(buf, pkgData.Data), nil
 }
 
 // rewrite write remote
@@ -70,40 +76,58 @@ func (handler *UdpSock5Handler) Write(buf []byte) (int, error) {
 	if err != nil {
 		return 0, err
 	}
+	handler.touch()
 	return len(buf), nil
 }
 
 // rewrite communication
 func (handler *UdpSock5Handler) Communicate() {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go handler.waitClosed(&wg)
 	// local -> remote
 	go func() {
-		logger.Debugf("[%s] begin copy data, local [%s] -> remote [%s]", handler.typ, handler.lAddr.String(), handler.rAddr.String())
-		_, err := io.Copy(handler.lConn, handler)
+		defer wg.Done()
+		handler.log.Debugf("begin copy data, local [%s] -> remote [%s]", handler.lAddr.String(), handler.rAddr.String())
+		n, err := io.Copy(handler.lConn, handler)
+		atomic.AddInt64(&handler.received, n)
 		if err != nil {
-			logger.Debugf("[%s] stop copy data, local [%s] -x- remote [%s], reason: %v",
-				handler.typ, handler.lAddr.String(), handler.rAddr.String(), err)
+			handler.log.Debugf("stop copy data, local [%s] -x- remote [%s], reason: %v",
+				handler.lAddr.String(), handler.rAddr.String(), err)
 		}
 		handler.Remove()
 	}()
 
 	// remote -> local
 	go func() {
-		logger.Debugf("[%s] begin copy data, remote [%s] -> local [%s]", handler.typ, handler.rAddr.String(), handler.lAddr.String())
-		_, err := io.Copy(handler, handler.lConn)
+		defer wg.Done()
+		handler.log.Debugf("begin copy data, remote [%s] -> local [%s]", handler.rAddr.String(), handler.lAddr.String())
+		n, err := io.Copy(handler, handler.lConn)
+		atomic.AddInt64(&handler.sent, n)
 		if err != nil {
-			logger.Debugf("[%s] stop copy data, remote [%s] -x- local [%s], reason: %v",
-				handler.typ, handler.rAddr.String(), handler.lAddr.String(), err)
+			handler.log.Debugf("stop copy data, remote [%s] -x- local [%s], reason: %v",
+				handler.rAddr.String(), handler.lAddr.String(), err)
 		}
 		handler.Remove()
 	}()
 }
 
-// create tunnel between proxy and server
+// create tunnel between proxy and server, retry if failed transiently
 func (handler *UdpSock5Handler) Tunnel() error {
+	return handler.retryTunnel(handler.tunnel)
+}
+
+// create tunnel between proxy and server once
+func (handler *UdpSock5Handler) tunnel() error {
+	// close tcp connection left by last failed attempt
+	if handler.rTcpConn != nil {
+		_ = handler.rTcpConn.Close()
+		handler.rTcpConn = nil
+	}
 	// dial proxy server
 	rTcpConn, err := handler.dialProxy()
 	if err != nil {
-		logger.Warningf("[udp] failed to dial proxy server, err: %v", err)
+		handler.log.Warningf("failed to dial proxy server, err: %v", err)
 		return err
 	}
 	// save tcp connection
@@ -114,13 +138,17 @@ func (handler *UdpSock5Handler) Tunnel() error {
 	dominname := ""
 	switch addr := handler.rAddr.(type) {
 	case *net.TCPAddr:
+		port = uint16(addr.Port)
+		ip = addr.IP
+	case *net.UDPAddr:
+		port = uint16(addr.Port)
 		ip = addr.IP
 	case *DomainAddr:
 		port = uint16(addr.Port)
 		ip = net.IPv4(0x00, 0x00, 0x00, 0x01)
 		dominname = addr.Domain
 	default:
-		logger.Warning("[udp] tunnel addr type is not udp")
+		handler.log.Warningf("tunnel addr type is not udp")
 		return errors.New("type is not udp")
 	}
 
@@ -150,7 +178,7 @@ func (handler *UdpSock5Handler) Tunnel() error {
 	// sock5 hand shake
 	_, err = rTcpConn.Write(buf)
 	if err != nil {
-		logger.Warningf("[udp] sock5 hand shake request failed, err: %v", err)
+		handler.log.Warningf("sock5 hand shake request failed, err: %v", err)
 		return err
 	}
 	/*
@@ -163,10 +191,10 @@ func (handler *UdpSock5Handler) Tunnel() error {
 	*/
 	_, err = rTcpConn.Read(buf)
 	if err != nil {
-		logger.Warningf("[udp] sock5 hand shake response failed, err: %v", err)
+		handler.log.Warningf("sock5 hand shake response failed, err: %v", err)
 		return err
 	}
-	logger.Debugf("[udp] sock5 hand shake response success message auth method: %v", buf[1])
+	handler.log.Debugf("sock5 hand shake response success message auth method: %v", buf[1])
 	if buf[0] != 5 || (buf[1] != 0 && buf[1] != 2) {
 		return fmt.Errorf("sock5 proto is invalid, sock type: %v, method: %v", buf[0], buf[1])
 	}
@@ -189,21 +217,21 @@ func (handler *UdpSock5Handler) Tunnel() error {
 		// write auth message to writer
 		_, err = rTcpConn.Write(buf)
 		if err != nil {
-			logger.Warningf("[udp] sock5 auth request failed, err: %v", err)
+			handler.log.Warningf("sock5 auth request failed, err: %v", err)
 			return err
 		}
 		buf = make([]byte, 32)
 		_, err = rTcpConn.Read(buf)
 		if err != nil {
-			logger.Warningf("[udp] sock5 auth response failed, err: %v", err)
+			handler.log.Warningf("sock5 auth response failed, err: %v", err)
 			return err
 		}
 		// RFC1929 user/pass auth should return 1, but some sock5 return 5
 		if buf[0] != 5 && buf[0] != 1 {
-			logger.Warningf("[udp] sock5 auth response incorrect code, code: %v", buf[0])
+			handler.log.Warningf("sock5 auth response incorrect code, code: %v", buf[0])
 			return fmt.Errorf("incorrect sock5 auth response, code: %v", buf[0])
 		}
-		logger.Debugf("[udp] sock5 auth success, code: %v", buf[0])
+		handler.log.Debugf("sock5 auth success, code: %v", buf[0])
 	}
 	/*
 			sock5 connect request
@@ -220,7 +248,7 @@ func (handler *UdpSock5Handler) Tunnel() error {
 	buf[2] = 0 // reserved
 	// add addr
 	if dominname == "" {
-		if len(ip) == net.IPv4len && ip.To4() != nil {
+		if ip.To4() != nil {
 			buf[3] = 1
 			buf = append(buf, ip.To4()...)
 		} else if ip.To16() != nil {
@@ -237,38 +265,35 @@ func (handler *UdpSock5Handler) Tunnel() error {
 		buf = append(buf, byte(len(dominname)))
 		buf = append(buf, []byte(dominname)...)
 	}
-	// convert port 2 byte
-	if port == 0 {
-		port = 80
-	}
+	// convert port 2 byte, zero port of udp associate means client port is unknown
 	portByte := make([]byte, 2)
 	binary.BigEndian.PutUint16(portByte, port)
 	buf = append(buf, portByte...)
 	// request proxy connect rTcpConn server
-	logger.Debugf("[udp] sock5 send connect request, buf: %v", buf)
+	handler.log.Debugf("sock5 send connect request, buf: %v", buf)
 	_, err = rTcpConn.Write(buf)
 	if err != nil {
-		logger.Warningf("[udp] sock5 send connect request failed, err: %v", err)
+		handler.log.Warningf("sock5 send connect request failed, err: %v", err)
 		return err
 	}
-	logger.Debugf("[udp] sock5 request successfully")
+	handler.log.Debugf("sock5 request successfully")
 
 	// resp
 	// VER REP RSV
 	_, err = io.ReadFull(rTcpConn, buf[0:3])
 	if err != nil {
-		logger.Warningf("[udp] sock5 connect response failed, err: %v", err)
+		handler.log.Warningf("sock5 connect response failed, err: %v", err)
 		return err
 	}
 	if buf[0] != 5 || buf[1] != 0 {
-		logger.Warningf("[udp] sock5 connect response failed, version: %v, code: %v", buf[0], buf[1])
+		handler.log.Warningf("sock5 connect response failed, version: %v, code: %v", buf[0], buf[1])
 		return fmt.Errorf("[udp] incorrect sock5 connect reponse, version: %v, code: %v", buf[0], buf[1])
 	}
 
 	// ATYPE
 	_, err = io.ReadFull(rTcpConn, buf[0:1])
 	if err != nil {
-		logger.Warningf("[%s] connect response failed, err: %v", handler.typ, err)
+		handler.log.Warningf("connect response failed, err: %v", err)
 		return err
 	}
 
@@ -283,7 +308,7 @@ func (handler *UdpSock5Handler) Tunnel() error {
 	case 3:
 		_, err = io.ReadFull(rTcpConn, buf[0:1])
 		if err != nil {
-			logger.Warningf("[%s] connect response failed, err: %v", handler.typ, err)
+			handler.log.Warningf("connect response failed, err: %v", err)
 			return err
 		}
 		isDomainname = true
@@ -295,14 +320,14 @@ func (handler *UdpSock5Handler) Tunnel() error {
 	ip = make([]byte, addrLen)
 	_, err = io.ReadFull(rTcpConn, ip)
 	if err != nil {
-		logger.Warningf("[%s] connect response failed, err: %v", handler.typ, err)
+		handler.log.Warningf("connect response failed, err: %v", err)
 		return err
 	}
 
 	// PORT
 	_, err = io.ReadFull(rTcpConn, buf[0:2])
 	if err != nil {
-		logger.Warningf("[%s] connect response failed, err: %v", handler.typ, err)
+		handler.log.Warningf("connect response failed, err: %v", err)
 		return err
 	}
 	port = binary.BigEndian.Uint16(buf[0:2])
@@ -321,14 +346,22 @@ func (handler *UdpSock5Handler) Tunnel() error {
 		}
 	}
 
+	// some server reply unspecified addr, which means relay is on proxy server
+	if udpServer.IP.IsUnspecified() {
+		if tcpAddr, ok := rTcpConn.RemoteAddr().(*net.TCPAddr); ok {
+			udpServer.IP = tcpAddr.IP
+		}
+	}
+
 	// dial rTcpConn udp server
-	udpConn, err := net.Dial("udp", udpServer.String())
+	dialer := net.Dialer{Control: dialOpt(handler.proxy).Control}
+	udpConn, err := dialer.Dial("udp", udpServer.String())
 	if err != nil {
-		logger.Warningf("[udp] dial rTcpConn udp failed, err: %v", err)
+		handler.log.Warningf("dial rTcpConn udp failed, err: %v", err)
 		return err
 	}
 
-	logger.Debugf("[udp] sock5 proxy: tunnel create success, [%s] -> [%s] -> [%s]",
+	handler.log.Debugf("sock5 proxy: tunnel create success, [%s] -> [%s] -> [%s]",
 		handler.lAddr.String(), udpServer.String(), handler.rAddr.String())
 	// save rTcpConn handler
 	handler.rConn = udpConn
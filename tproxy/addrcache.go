@@ -0,0 +1,214 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package TProxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	config "github.com/linuxdeepin/deepin-network-proxy/config"
+	resolver "github.com/linuxdeepin/deepin-network-proxy/resolver"
+)
+
+// addresses of proxy servers are cached by ttl of records, so that tunnels neither wait for
+// nor leak a dns query each. address used near expiry is refreshed in background, expired address
+// is still used while refreshing or if refresh failed for a while, in case dns is down but proxy is up.
+// pinned addresses of proxy are used without resolving. address failed to connect is tried after
+// others until it is connected again or penalty passes.
+
+const (
+	// refresh in background when ttl left is less than this
+	addrRefreshAhead = 10 * time.Second
+	// min ttl, in case server returns zero ttl
+	addrMinTTL = 5 * time.Second
+	// expired address is used this long if refresh failed
+	addrStaleTTL = 10 * time.Minute
+	// address failed to connect is tried last during this
+	addrFailPenalty = 30 * time.Second
+	// timeout of looking up server
+	addrLookupTimeout = 5 * time.Second
+)
+
+// look up address of host with ttl
+type lookupFunc func(ctx context.Context, host string) ([]net.IP, time.Duration, error)
+
+// cached address of server
+type serverAddr struct {
+	ips    []net.IP
+	expire time.Time
+	err    error
+	// closed when lookup in flight finishes, nil if none
+	inflight chan struct{}
+}
+
+// cache of proxy server address
+type addrCache struct {
+	lock    sync.Mutex
+	servers map[string]*serverAddr
+	// time address failed to connect, by ip
+	failed map[string]time.Time
+
+	lookup lookupFunc
+	// now func, replaced in test
+	now func() time.Time
+}
+
+func newAddrCache(lookup lookupFunc) *addrCache {
+	return &addrCache{
+		servers: make(map[string]*serverAddr),
+		failed:  make(map[string]time.Time),
+		lookup:  lookup,
+		now:     time.Now,
+	}
+}
+
+// address of proxy servers shared by scopes
+var proxyAddrs = newAddrCache(resolver.LookupIP)
+
+// resolve server of proxy in background, so that first tunnel dont wait
+func PreResolve(proxy config.Proxy) {
+	if len(proxy.Dial.Addrs) != 0 || net.ParseIP(proxy.Server) != nil {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), addrLookupTimeout)
+		defer cancel()
+		_, err := proxyAddrs.resolve(ctx, proxy.Server, nil)
+		if err != nil {
+			logger.Warningf("resolve proxy server %s failed, err: %v", proxy.Server, err)
+		}
+	}()
+}
+
+// address of server to dial in order, pinned address is used without resolving
+func (c *addrCache) resolve(ctx context.Context, server string, pinned []string) ([]net.IP, error) {
+	if len(pinned) != 0 {
+		var ips []net.IP
+		for _, addr := range pinned {
+			if ip := net.ParseIP(addr); ip != nil {
+				ips = append(ips, ip)
+			}
+		}
+		if len(ips) == 0 {
+			return nil, errors.New("pinned address of proxy server is invalid")
+		}
+		return c.order(ips), nil
+	}
+	// literal ip dont need resolve
+	if ip := net.ParseIP(server); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	now := c.now()
+	c.lock.Lock()
+	entry, ok := c.servers[server]
+	if !ok {
+		entry = &serverAddr{}
+		c.servers[server] = entry
+	}
+	if entry.ips != nil && now.Before(entry.expire.Add(addrStaleTTL)) {
+		// refresh before expired, stale address is used meanwhile
+		if entry.expire.Sub(now) < addrRefreshAhead {
+			c.startLookup(server, entry)
+		}
+		ips := entry.ips
+		c.lock.Unlock()
+		return c.order(ips), nil
+	}
+	done := c.startLookup(server, entry)
+	c.lock.Unlock()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	c.lock.Lock()
+	ips, err := entry.ips, entry.err
+	c.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return c.order(ips), nil
+}
+
+// look up server unless lookup is in flight, return chan closed when it finishes, lock is held
+func (c *addrCache) startLookup(server string, entry *serverAddr) chan struct{} {
+	if entry.inflight != nil {
+		return entry.inflight
+	}
+	done := make(chan struct{})
+	entry.inflight = done
+	go func() {
+		defer close(done)
+		ctx, cancel := context.WithTimeout(context.Background(), addrLookupTimeout)
+		defer cancel()
+		ips, ttl, err := c.lookup(ctx, server)
+		if err == nil && len(ips) == 0 {
+			err = errors.New("proxy server has no address")
+		}
+		if ttl < addrMinTTL {
+			ttl = addrMinTTL
+		}
+		now := c.now()
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		entry.inflight = nil
+		entry.err = err
+		if err != nil {
+			// address last resolved is kept until stale
+			logger.Warningf("look up proxy server %s failed, err: %v", server, err)
+			return
+		}
+		entry.ips = ips
+		entry.expire = now.Add(ttl)
+		logger.Debugf("proxy server %s resolved to %v, ttl %v", server, ips, ttl)
+	}()
+	return done
+}
+
+// ipv6 and ipv4 alternately, address failed recently is tried last
+func (c *addrCache) order(ips []net.IP) []net.IP {
+	addrs := make([]net.IPAddr, 0, len(ips))
+	for _, ip := range ips {
+		addrs = append(addrs, net.IPAddr{IP: ip})
+	}
+	interleaved := interleaveAddrs(addrs)
+	now := c.now()
+	ordered := make([]net.IP, 0, len(interleaved))
+	var failed []net.IP
+	c.lock.Lock()
+	for _, ip := range interleaved {
+		if at, ok := c.failed[ip.String()]; ok && now.Sub(at) < addrFailPenalty {
+			failed = append(failed, ip)
+			continue
+		}
+		ordered = append(ordered, ip)
+	}
+	c.lock.Unlock()
+	return append(ordered, failed...)
+}
+
+// address failed to connect, nil cache is ignored
+func (c *addrCache) markFailed(ip net.IP) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.failed[ip.String()] = c.now()
+}
+
+// address connected, it is not tried last any more
+func (c *addrCache) markConnected(ip net.IP) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.failed, ip.String())
+}
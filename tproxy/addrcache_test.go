@@ -0,0 +1,148 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package TProxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lookup counts queries, answers addresses set or error
+type fakeLookup struct {
+	lock    sync.Mutex
+	queries int
+	ips     []net.IP
+	ttl     time.Duration
+	err     error
+}
+
+func (f *fakeLookup) lookup(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.queries++
+	return f.ips, f.ttl, f.err
+}
+
+func (f *fakeLookup) count() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.queries
+}
+
+// clock moved by test, read by lookup in background
+type fakeClock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Add(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestAddrCacheTTL(t *testing.T) {
+	fake := &fakeLookup{ips: []net.IP{net.ParseIP("1.2.3.4"), net.ParseIP("2001:db8::1")}, ttl: time.Minute}
+	cache := newAddrCache(fake.lookup)
+	clock := &fakeClock{now: time.Now()}
+	cache.now = clock.Now
+	ctx := context.Background()
+
+	ips, err := cache.resolve(ctx, "proxy.deepin.org", nil)
+	if err != nil || len(ips) != 2 || ips[0].String() != "2001:db8::1" {
+		t.Fatalf("addrs are %v, err: %v, want ipv6 first", ips, err)
+	}
+	// cached within ttl
+	clock.Add(30 * time.Second)
+	_, _ = cache.resolve(ctx, "proxy.deepin.org", nil)
+	if count := fake.count(); count != 1 {
+		t.Errorf("queries are %d within ttl, want 1", count)
+	}
+
+	// refreshed in background near expiry, cached address returns at once
+	fake.lock.Lock()
+	fake.ips = []net.IP{net.ParseIP("1.2.3.5")}
+	fake.lock.Unlock()
+	clock.Add(25 * time.Second)
+	ips, _ = cache.resolve(ctx, "proxy.deepin.org", nil)
+	if len(ips) != 2 {
+		t.Errorf("addrs are %v while refreshing, want cached", ips)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		ips, _ = cache.resolve(ctx, "proxy.deepin.org", nil)
+		if len(ips) == 1 && ips[0].String() == "1.2.3.5" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("addrs are %v, not refreshed", ips)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// stale address is used if refresh failed
+	fake.lock.Lock()
+	fake.err = errors.New("dns is down")
+	fake.lock.Unlock()
+	clock.Add(2 * time.Minute)
+	ips, err = cache.resolve(ctx, "proxy.deepin.org", nil)
+	if err != nil || len(ips) != 1 {
+		t.Errorf("addrs are %v, err: %v, want stale addrs", ips, err)
+	}
+	// too stale
+	clock.Add(addrStaleTTL)
+	_, err = cache.resolve(ctx, "proxy.deepin.org", nil)
+	if err == nil {
+		t.Error("stale addrs are used after stale ttl")
+	}
+}
+
+func TestAddrCachePinned(t *testing.T) {
+	fake := &fakeLookup{err: errors.New("should not resolve")}
+	cache := newAddrCache(fake.lookup)
+	ips, err := cache.resolve(context.Background(), "proxy.deepin.org", []string{"1.2.3.4", "1.2.3.5"})
+	if err != nil || len(ips) != 2 || fake.count() != 0 {
+		t.Errorf("addrs are %v, err: %v, queries: %d, want pinned", ips, err, fake.count())
+	}
+	ips, err = cache.resolve(context.Background(), "1.2.3.6", nil)
+	if err != nil || len(ips) != 1 || fake.count() != 0 {
+		t.Errorf("addrs are %v, err: %v, queries: %d, want literal", ips, err, fake.count())
+	}
+}
+
+func TestAddrCacheFailover(t *testing.T) {
+	cache := newAddrCache(nil)
+	clock := &fakeClock{now: time.Now()}
+	cache.now = clock.Now
+	pinned := []string{"1.2.3.4", "1.2.3.5"}
+
+	cache.markFailed(net.ParseIP("1.2.3.4"))
+	ips, _ := cache.resolve(context.Background(), "", pinned)
+	if ips[0].String() != "1.2.3.5" {
+		t.Errorf("addrs are %v, want failed one last", ips)
+	}
+	// failed long ago
+	clock.Add(addrFailPenalty)
+	ips, _ = cache.resolve(context.Background(), "", pinned)
+	if ips[0].String() != "1.2.3.4" {
+		t.Errorf("addrs are %v after penalty, want config order", ips)
+	}
+	cache.markFailed(net.ParseIP("1.2.3.4"))
+	cache.markConnected(net.ParseIP("1.2.3.4"))
+	ips, _ = cache.resolve(context.Background(), "", pinned)
+	if ips[0].String() != "1.2.3.4" {
+		t.Errorf("addrs are %v after connected, want config order", ips)
+	}
+}
@@ -0,0 +1,326 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package TProxy
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// handlers of scope are kept in one table by proto and endpoints, lookup is O(1).
+// owner of tunnel holds first reference, others using handler hold more until released,
+// handler is closed when owner removes it, and entry is dropped when last reference is released.
+// close paths may be missed, such as udp flows never end and handlers closed without removing self,
+// so entries of closed handlers, idle udp and references leaked are collected periodically.
+
+const (
+	// interval of collecting dead entries
+	connCollectInterval = time.Minute
+	// udp tunnel without packages for this long is dead, tcp is closed by peers or keepalive
+	udpIdleTimeout = 3 * time.Minute
+	// entry removed by owner but never released is dropped after this
+	connReleaseTimeout = 10 * time.Minute
+)
+
+// key of table
+type connKey struct {
+	typ ProtoTyp
+	key HandlerKey
+}
+
+// state of handler reported to table, handlers with handlerPrv implement it
+type connState interface {
+	lastActive() time.Time
+	isClosed() bool
+	isUdp() bool
+}
+
+type connEntry struct {
+	handler BaseHandler
+	refs    int32
+	added   time.Time
+	// owner removed entry, lookup fails and entry is dropped when released
+	removing bool
+	removed  time.Time
+}
+
+// last activity of entry, time added if handler dont report
+func (entry *connEntry) active() time.Time {
+	if state, ok := entry.handler.(connState); ok {
+		return state.lastActive()
+	}
+	return entry.added
+}
+
+// handler closed without removing entry
+func (entry *connEntry) closed() bool {
+	state, ok := entry.handler.(connState)
+	return ok && state.isClosed()
+}
+
+// udp tunnel has no end, it is dead once idle long enough
+func (entry *connEntry) idle(now time.Time) bool {
+	state, ok := entry.handler.(connState)
+	return ok && state.isUdp() && now.Sub(entry.active()) >= udpIdleTimeout
+}
+
+// entry of table, flat for dbus
+type ConnEntry struct {
+	Proto    string
+	Src      string
+	Dst      string
+	Refs     int32
+	Age      uint32 // seconds since added
+	Idle     uint32 // seconds since last activity
+	Removing bool   // removed by owner, wait references released
+}
+
+// table of handlers
+type ConnTable struct {
+	lock    sync.Mutex
+	entries map[connKey]*connEntry
+	// entries dropped by collecting, total
+	collected uint64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewConnTable() *ConnTable {
+	return &ConnTable{
+		entries: make(map[connKey]*connEntry),
+	}
+}
+
+// add handler with reference of owner, handler of the same key left by missed close path is replaced
+func (table *ConnTable) Add(typ ProtoTyp, key HandlerKey, base BaseHandler) {
+	table.lock.Lock()
+	old, ok := table.entries[connKey{typ: typ, key: key}]
+	table.entries[connKey{typ: typ, key: key}] = &connEntry{handler: base, refs: 1, added: time.Now()}
+	table.lock.Unlock()
+	// endpoints are in use again, old tunnel is dead
+	if ok && old.handler != base {
+		logger.Debugf("handler replaced in table, type: %v, key: %v", typ, key)
+		old.handler.Close()
+	}
+}
+
+// get handler and hold reference, release it after use
+func (table *ConnTable) Acquire(typ ProtoTyp, key HandlerKey) (BaseHandler, bool) {
+	table.lock.Lock()
+	defer table.lock.Unlock()
+	entry, ok := table.entries[connKey{typ: typ, key: key}]
+	if !ok || entry.removing {
+		return nil, false
+	}
+	entry.refs++
+	return entry.handler, true
+}
+
+// release reference acquired, entry removed by owner is dropped when last reference released
+func (table *ConnTable) Release(typ ProtoTyp, key HandlerKey, base BaseHandler) {
+	table.lock.Lock()
+	defer table.lock.Unlock()
+	table.release(connKey{typ: typ, key: key}, base)
+}
+
+// handler is checked, entry may be replaced by handler of the same key
+func (table *ConnTable) release(ck connKey, base BaseHandler) {
+	entry, ok := table.entries[ck]
+	if !ok || entry.handler != base {
+		return
+	}
+	entry.refs--
+	if entry.refs <= 0 {
+		delete(table.entries, ck)
+	}
+}
+
+// close handler and drop reference of owner, remove twice is ignored,
+// nil base removes handler of key whatever it is
+func (table *ConnTable) Remove(typ ProtoTyp, key HandlerKey, base BaseHandler) bool {
+	ck := connKey{typ: typ, key: key}
+	table.lock.Lock()
+	entry, ok := table.entries[ck]
+	if !ok || entry.removing || (base != nil && entry.handler != base) {
+		table.lock.Unlock()
+		return false
+	}
+	entry.removing = true
+	entry.removed = time.Now()
+	table.release(ck, entry.handler)
+	table.lock.Unlock()
+	entry.handler.Close()
+	return true
+}
+
+// close and drop handlers of proto, references held are ignored
+func (table *ConnTable) RemoveTyp(typ ProtoTyp) {
+	var handlers []BaseHandler
+	table.lock.Lock()
+	for ck, entry := range table.entries {
+		if ck.typ != typ {
+			continue
+		}
+		if !entry.removing {
+			handlers = append(handlers, entry.handler)
+		}
+		delete(table.entries, ck)
+	}
+	table.lock.Unlock()
+	for _, base := range handlers {
+		base.Close()
+	}
+}
+
+// protos of handlers in table
+func (table *ConnTable) Typs() []ProtoTyp {
+	table.lock.Lock()
+	defer table.lock.Unlock()
+	seen := make(map[ProtoTyp]bool)
+	var typs []ProtoTyp
+	for ck := range table.entries {
+		if !seen[ck.typ] {
+			seen[ck.typ] = true
+			typs = append(typs, ck.typ)
+		}
+	}
+	return typs
+}
+
+// count of handlers not removed
+func (table *ConnTable) Count() int {
+	table.lock.Lock()
+	defer table.lock.Unlock()
+	var count int
+	for _, entry := range table.entries {
+		if !entry.removing {
+			count++
+		}
+	}
+	return count
+}
+
+// count of handlers alive, closed handlers and udp without packages for idle are not counted
+func (table *ConnTable) LiveCount(now time.Time, idle time.Duration) int {
+	table.lock.Lock()
+	defer table.lock.Unlock()
+	var count int
+	for _, entry := range table.entries {
+		if entry.removing || entry.closed() {
+			continue
+		}
+		if state, ok := entry.handler.(connState); ok && state.isUdp() && now.Sub(entry.active()) >= idle {
+			continue
+		}
+		count++
+	}
+	return count
+}
+
+// total entries dropped by collecting
+func (table *ConnTable) Collected() uint64 {
+	table.lock.Lock()
+	defer table.lock.Unlock()
+	return table.collected
+}
+
+// entries of table, oldest first
+func (table *ConnTable) Dump() []ConnEntry {
+	now := time.Now()
+	table.lock.Lock()
+	dump := make([]ConnEntry, 0, len(table.entries))
+	for ck, entry := range table.entries {
+		dump = append(dump, ConnEntry{
+			Proto:    ck.typ.String(),
+			Src:      ck.key.SrcAddr,
+			Dst:      ck.key.DstAddr,
+			Refs:     entry.refs,
+			Age:      uint32(now.Sub(entry.added).Seconds()),
+			Idle:     uint32(now.Sub(entry.active()).Seconds()),
+			Removing: entry.removing,
+		})
+	}
+	table.lock.Unlock()
+	sort.Slice(dump, func(i, j int) bool { return dump[i].Age > dump[j].Age })
+	return dump
+}
+
+// drop dead entries once, return count dropped
+func (table *ConnTable) Collect(now time.Time) int {
+	var handlers []BaseHandler
+	var count int
+	table.lock.Lock()
+	for ck, entry := range table.entries {
+		switch {
+		case entry.removing:
+			// reference acquired but never released
+			if now.Sub(entry.removed) < connReleaseTimeout {
+				continue
+			}
+		case entry.closed():
+		case entry.idle(now):
+			handlers = append(handlers, entry.handler)
+		default:
+			continue
+		}
+		delete(table.entries, ck)
+		count++
+	}
+	table.collected += uint64(count)
+	table.lock.Unlock()
+	for _, base := range handlers {
+		base.Close()
+	}
+	return count
+}
+
+// collect periodically until stopped
+func (table *ConnTable) StartCollect(interval time.Duration) {
+	if interval <= 0 {
+		interval = connCollectInterval
+	}
+	table.lock.Lock()
+	if table.stop != nil {
+		table.lock.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	table.stop = stop
+	table.done = done
+	table.lock.Unlock()
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				if count := table.Collect(now); count > 0 {
+					logger.Debugf("dead handlers collected: %d", count)
+				}
+			}
+		}
+	}()
+}
+
+// stop collecting and wait it exits
+func (table *ConnTable) StopCollect() {
+	table.lock.Lock()
+	stop, done := table.stop, table.done
+	table.stop = nil
+	table.done = nil
+	table.lock.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+}
@@ -0,0 +1,147 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package TProxy
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	config "github.com/linuxdeepin/deepin-network-proxy/config"
+	define "github.com/linuxdeepin/deepin-network-proxy/define"
+)
+
+func newTableHandler(t *testing.T, port int) (*DirectHandler, HandlerKey) {
+	local, lConn := net.Pipe()
+	t.Cleanup(func() { _ = local.Close() })
+	lAddr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: port}
+	rAddr := &net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 80}
+	key := HandlerKey{SrcAddr: lAddr.String(), DstAddr: rAddr.String()}
+	return NewDirectHandler(define.App, key, config.Proxy{}, lAddr, rAddr, lConn), key
+}
+
+func TestConnTableReference(t *testing.T) {
+	table := NewConnTable()
+	handler, key := newTableHandler(t, 40000)
+	table.Add(NoneProto, key, handler)
+
+	got, ok := table.Acquire(NoneProto, key)
+	if !ok || got != handler {
+		t.Fatalf("acquire handler failed")
+	}
+	if !table.Remove(NoneProto, key, handler) {
+		t.Fatalf("remove handler failed")
+	}
+	if !handler.isClosed() {
+		t.Errorf("handler removed is not closed")
+	}
+	if table.Remove(NoneProto, key, handler) {
+		t.Errorf("handler removed twice")
+	}
+	if count := table.Count(); count != 0 {
+		t.Errorf("count is %d after removed, want 0", count)
+	}
+	// reference acquired keeps entry
+	if dump := table.Dump(); len(dump) != 1 || !dump[0].Removing || dump[0].Refs != 1 {
+		t.Fatalf("dump is %+v, want one removing entry", dump)
+	}
+	if _, ok := table.Acquire(NoneProto, key); ok {
+		t.Errorf("removed handler can be acquired")
+	}
+	table.Release(NoneProto, key, handler)
+	if dump := table.Dump(); len(dump) != 0 {
+		t.Errorf("dump is %+v after released, want empty", dump)
+	}
+}
+
+func TestConnTableReplace(t *testing.T) {
+	table := NewConnTable()
+	old, key := newTableHandler(t, 40000)
+	handler, _ := newTableHandler(t, 40000)
+	table.Add(NoneProto, key, old)
+	table.Add(NoneProto, key, handler)
+	if !old.isClosed() {
+		t.Errorf("handler replaced is not closed")
+	}
+	// old handler removes self late
+	if table.Remove(NoneProto, key, old) {
+		t.Errorf("old handler removes handler replacing it")
+	}
+	if got, ok := table.Acquire(NoneProto, key); !ok || got != handler {
+		t.Errorf("handler replacing is lost")
+	}
+}
+
+func TestConnTableCollect(t *testing.T) {
+	table := NewConnTable()
+	now := time.Now()
+
+	// closed without removing self
+	closed, closedKey := newTableHandler(t, 40000)
+	table.Add(NoneProto, closedKey, closed)
+	closed.Close()
+
+	// udp without packages
+	lAddr := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 40001}
+	rAddr := &net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 53}
+	idleKey := HandlerKey{SrcAddr: lAddr.String(), DstAddr: rAddr.String()}
+	idle := NewMasqueUdpHandler(define.App, idleKey, config.Proxy{}, lAddr, rAddr, nil)
+	table.Add(MASQUEUDP, idleKey, idle)
+	atomic.StoreInt64(&idle.active, now.Add(-udpIdleTimeout).UnixNano())
+
+	// tcp alive however long idle
+	alive, aliveKey := newTableHandler(t, 40002)
+	table.Add(NoneProto, aliveKey, alive)
+	atomic.StoreInt64(&alive.active, now.Add(-time.Hour).UnixNano())
+
+	// reference leaked
+	leaked, leakedKey := newTableHandler(t, 40003)
+	table.Add(NoneProto, leakedKey, leaked)
+	table.Acquire(NoneProto, leakedKey)
+	table.Remove(NoneProto, leakedKey, leaked)
+
+	// idle udp and closed handler dont hold drain
+	if count := table.LiveCount(now, time.Minute); count != 1 {
+		t.Errorf("live count is %d, want 1", count)
+	}
+	if count := table.Collect(now); count != 2 {
+		t.Errorf("collected %d, want 2", count)
+	}
+	if !idle.isClosed() {
+		t.Errorf("idle udp handler is not closed")
+	}
+	if count := table.Collect(time.Now().Add(connReleaseTimeout)); count != 1 {
+		t.Errorf("collected %d after release timeout, want 1", count)
+	}
+	if count := table.Count(); count != 1 {
+		t.Errorf("count is %d, want 1", count)
+	}
+	if collected := table.Collected(); collected != 3 {
+		t.Errorf("collected total is %d, want 3", collected)
+	}
+}
+
+func TestConnTableBounded(t *testing.T) {
+	table := NewConnTable()
+	// close paths of all handlers are missed
+	for index := 0; index < 100; index++ {
+		handler, key := newTableHandler(t, 41000+index)
+		table.Add(NoneProto, key, handler)
+		handler.Close()
+	}
+	table.StartCollect(10 * time.Millisecond)
+	defer table.StopCollect()
+	deadline := time.Now().Add(time.Second)
+	for len(table.Dump()) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("entries left: %d", len(table.Dump()))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if collected := table.Collected(); collected != 100 {
+		t.Errorf("collected total is %d, want 100", collected)
+	}
+}
@@ -0,0 +1,16 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+//go:build !iouring
+// +build !iouring
+
+package TProxy
+
+import "io"
+
+// copy one direction of tunnel, io.Copy splices between tcp conns.
+// build with iouring tag to try io_uring instead
+func relayCopy(dst io.Writer, src io.Reader) (int64, error) {
+	return io.Copy(dst, src)
+}
@@ -0,0 +1,89 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+//go:build iouring
+// +build iouring
+
+package TProxy
+
+import (
+	"io"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// experiment, build with GO_BUILD_FLAGS="-tags iouring" to copy tcp tunnels by io_uring.
+// readiness still comes from go netpoller, io_uring only performs recv and send,
+// so close and deadline of conns work as before. compare with benchmarks of copy_uring_test.go.
+
+// size of buffer of one direction
+const uringBufSize = 32 * 1024
+
+// copy one direction of tunnel, io.Copy is used if conns are not plain tcp or ring fails
+func relayCopy(dst io.Writer, src io.Reader) (int64, error) {
+	dstConn, ok := dst.(*net.TCPConn)
+	if !ok {
+		return io.Copy(dst, src)
+	}
+	srcConn, ok := src.(*net.TCPConn)
+	if !ok {
+		return io.Copy(dst, src)
+	}
+	ring, err := newUring(uringEntries)
+	if err != nil {
+		logger.Debugf("create io_uring failed, io.Copy is used, err: %v", err)
+		return io.Copy(dst, src)
+	}
+	defer ring.close()
+	return uringCopy(ring, dstConn, srcConn)
+}
+
+// copy until eof of src
+func uringCopy(ring *uring, dst *net.TCPConn, src *net.TCPConn) (int64, error) {
+	srcRaw, err := src.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	dstRaw, err := dst.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	buf := make([]byte, uringBufSize)
+	var written int64
+	for {
+		var n int
+		var opErr error
+		// callback returns false to wait for readiness in netpoller
+		err = srcRaw.Read(func(fd uintptr) bool {
+			n, opErr = ring.recv(int(fd), buf)
+			return opErr != unix.EAGAIN
+		})
+		if err != nil {
+			return written, err
+		}
+		if opErr != nil {
+			return written, opErr
+		}
+		if n == 0 {
+			return written, nil
+		}
+		data := buf[:n]
+		for len(data) > 0 {
+			var m int
+			err = dstRaw.Write(func(fd uintptr) bool {
+				m, opErr = ring.send(int(fd), data)
+				return opErr != unix.EAGAIN
+			})
+			if err != nil {
+				return written, err
+			}
+			if opErr != nil {
+				return written, opErr
+			}
+			data = data[m:]
+			written += int64(m)
+		}
+	}
+}
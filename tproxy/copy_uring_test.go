@@ -0,0 +1,117 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+//go:build iouring
+// +build iouring
+
+package TProxy
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// compare copy of tunnel, run with:
+// go test -tags iouring -run xxx -bench Copy ./tproxy
+// MB/s is throughput, cpu-ns/op is cpu time of whole process per chunk, lower is better
+
+// size of chunk written per op
+const benchChunkSize = 64 * 1024
+
+func TestUringCopy(t *testing.T) {
+	app, src := tcpPair(t)
+	dst, server := tcpPair(t)
+	payload := bytes.Repeat([]byte("deepin"), 50000)
+	go func() {
+		_, _ = app.Write(payload)
+		_ = app.Close()
+	}()
+	done := make(chan error, 1)
+	go func() {
+		n, err := relayCopy(dst, src)
+		if err == nil && n != int64(len(payload)) {
+			err = io.ErrShortWrite
+		}
+		_ = dst.Close()
+		done <- err
+	}()
+	got, err := io.ReadAll(server)
+	if err != nil || !bytes.Equal(got, payload) {
+		t.Fatalf("server got %d bytes of %d, err: %v", len(got), len(payload), err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("copy by io_uring failed, err: %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("copy by io_uring does not return after eof")
+	}
+}
+
+// plain buffer copy, conns are hidden so io.Copy can not splice
+func BenchmarkCopyUserspace(b *testing.B) {
+	benchCopy(b, func(dst net.Conn, src net.Conn) (int64, error) {
+		return io.Copy(struct{ io.Writer }{dst}, struct{ io.Reader }{src})
+	})
+}
+
+// io.Copy between tcp conns, splice through pipe in kernel
+func BenchmarkCopySplice(b *testing.B) {
+	benchCopy(b, func(dst net.Conn, src net.Conn) (int64, error) {
+		return io.Copy(dst, src)
+	})
+}
+
+func BenchmarkCopyUring(b *testing.B) {
+	benchCopy(b, func(dst net.Conn, src net.Conn) (int64, error) {
+		return relayCopy(dst, src)
+	})
+}
+
+// copy b.N chunks from app to server through copy fn
+func benchCopy(b *testing.B, copyFn func(dst net.Conn, src net.Conn) (int64, error)) {
+	app, src := tcpPair(b)
+	dst, server := tcpPair(b)
+	defer src.Close()
+	defer dst.Close()
+	go func() {
+		chunk := make([]byte, benchChunkSize)
+		for i := 0; i < b.N; i++ {
+			if _, err := app.Write(chunk); err != nil {
+				break
+			}
+		}
+		_ = app.Close()
+	}()
+	received := make(chan int64, 1)
+	go func() {
+		n, _ := io.Copy(io.Discard, server)
+		received <- n
+	}()
+	b.SetBytes(benchChunkSize)
+	b.ResetTimer()
+	start := processCPU()
+	_, err := copyFn(dst, src)
+	if err != nil {
+		b.Fatalf("copy failed, err: %v", err)
+	}
+	_ = dst.(*net.TCPConn).CloseWrite()
+	if n := <-received; n != int64(b.N)*benchChunkSize {
+		b.Fatalf("server got %d bytes, want %d", n, int64(b.N)*benchChunkSize)
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(processCPU()-start)/float64(b.N), "cpu-ns/op")
+}
+
+// user and system cpu time of process
+func processCPU() time.Duration {
+	var usage syscall.Rusage
+	_ = syscall.Getrusage(syscall.RUSAGE_SELF, &usage)
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+}
@@ -0,0 +1,147 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package TProxy
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"time"
+
+	com "github.com/linuxdeepin/deepin-network-proxy/com"
+	config "github.com/linuxdeepin/deepin-network-proxy/config"
+)
+
+// delay between two connection attempts, recommended by RFC 8305 section 5
+const connAttemptDelay = 250 * time.Millisecond
+
+// dial result of one connection attempt
+type dialResult struct {
+	ip   net.IP
+	conn net.Conn
+	err  error
+}
+
+// socket options of connections to proxy server
+func dialOpt(proxy config.Proxy) com.DialOpt {
+	return com.DialOpt{
+		Device:            proxy.Dial.Device,
+		Mark:              proxy.Dial.Mark,
+		MSS:               proxy.Dial.MSS,
+		KeepAliveIdle:     proxy.Dial.KeepAliveIdle,
+		KeepAliveInterval: proxy.Dial.KeepAliveInterval,
+		KeepAliveCount:    proxy.Dial.KeepAliveCount,
+		FastOpen:          proxy.Dial.FastOpen,
+		SndBuf:            proxy.Dial.SndBuf,
+		RcvBuf:            proxy.Dial.RcvBuf,
+	}
+}
+
+// dial server, if server resolves to both ipv6 and ipv4 address, race them as RFC 8305 describes,
+// so that a broken ipv6 path wont block connection to proxy server.
+// address is cached by ttl, pinned address is used instead of resolving server
+func dialDualStack(server string, pinned []string, port int, timeout time.Duration, opt com.DialOpt) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	ipSl, err := proxyAddrs.resolve(ctx, server, pinned)
+	if err != nil {
+		return nil, err
+	}
+	return raceDial(ctx, ipSl, port, opt, proxyAddrs)
+}
+
+// sort address, ipv6 and ipv4 appear alternately, ipv6 first
+func interleaveAddrs(addrs []net.IPAddr) []net.IP {
+	var ip6Sl, ip4Sl []net.IP
+	for _, addr := range addrs {
+		if addr.IP.To4() != nil {
+			ip4Sl = append(ip4Sl, addr.IP)
+		} else {
+			ip6Sl = append(ip6Sl, addr.IP)
+		}
+	}
+	ipSl := make([]net.IP, 0, len(addrs))
+	for index := 0; index < len(ip6Sl) || index < len(ip4Sl); index++ {
+		if index < len(ip6Sl) {
+			ipSl = append(ipSl, ip6Sl[index])
+		}
+		if index < len(ip4Sl) {
+			ipSl = append(ipSl, ip4Sl[index])
+		}
+	}
+	return ipSl
+}
+
+// start connection attempts one by one, next attempt starts when last one failed or attempt delay elapsed,
+// return the first success connection and close the others. result of address is marked in cache if not nil
+func raceDial(ctx context.Context, ipSl []net.IP, port int, opt com.DialOpt, addrs *addrCache) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan dialResult)
+	var next, pending int
+	// start next connection attempt
+	start := func() {
+		ip := ipSl[next]
+		addr := net.JoinHostPort(ip.String(), strconv.Itoa(port))
+		next++
+		pending++
+		go func() {
+			conn, err := opt.Dialer(0).DialContext(ctx, "tcp", addr)
+			select {
+			case results <- dialResult{ip: ip, conn: conn, err: err}:
+			case <-ctx.Done():
+				// another attempt has won
+				if conn != nil {
+					_ = conn.Close()
+				}
+			}
+		}()
+	}
+	// reset timer, drain channel in case timer already fired
+	resetTimer := func(timer *time.Timer) {
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(connAttemptDelay)
+	}
+
+	start()
+	timer := time.NewTimer(connAttemptDelay)
+	defer timer.Stop()
+	var firstErr error
+	for pending > 0 {
+		select {
+		case result := <-results:
+			pending--
+			if result.err == nil {
+				addrs.markConnected(result.ip)
+				return result.conn, nil
+			}
+			addrs.markFailed(result.ip)
+			if firstErr == nil {
+				firstErr = result.err
+			}
+			logger.Debugf("connection attempt failed, err: %v", result.err)
+			// last attempt failed, start next one at once
+			if next < len(ipSl) {
+				start()
+				resetTimer(timer)
+			}
+		case <-timer.C:
+			// attempt delay elapsed, start next one without waiting
+			if next < len(ipSl) {
+				start()
+				timer.Reset(connAttemptDelay)
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return nil, firstErr
+}
@@ -0,0 +1,439 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package TProxy
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/sys/unix"
+)
+
+// optional data plane, tunnels are relayed by one thread polling non-blocking sockets with epoll,
+// instead of two goroutines and two buffers per tunnel. handshake with proxy server still runs in go net,
+// fds of both conns are taken over when communicate begins. buffer is borrowed only while data
+// waits to be written, so idle tunnels hold no memory except sockets.
+// tunnels whose conns are not plain tcp, like sniffed conn or masque stream, are copied by goroutines.
+
+// size of buffer borrowed by one direction
+const epollBufSize = 16 * 1024
+
+// events returned by one wait
+const epollMaxEvents = 256
+
+var epollBufPool = sync.Pool{
+	New: func() interface{} {
+		return make([]byte, epollBufSize)
+	},
+}
+
+type EpollRelay struct {
+	epfd   int
+	wakeFd int // eventfd wakes loop when pairs are added or closed
+
+	// ops from other goroutines, handled by loop
+	lock    sync.Mutex
+	adding  []*epollPair
+	closing []*epollPair
+	stopped bool
+	done    chan struct{}
+
+	// pairs by fd, used by loop only
+	pairs map[int]*epollPair
+	count int32
+}
+
+// one direction of tunnel, data read from src waits in buf until written to dst
+type epollFlow struct {
+	src, dst   int
+	buf        []byte
+	start, end int
+	eof        bool // src has no more data
+	shut       bool // write of dst is shut down after eof
+	bytes      int64
+}
+
+// tunnel taken over by relay
+type epollPair struct {
+	relay *EpollRelay
+	pr    *handlerPrv
+	lFd   int
+	rFd   int
+	up    epollFlow // local -> remote
+	down  epollFlow // remote -> local
+
+	// events registered of local and remote
+	lEvents uint32
+	rEvents uint32
+	// first byte from remote reported
+	firstByte bool
+
+	// close requested by handler
+	closing int32
+	// fds closed by loop
+	finished bool
+}
+
+// create epoll relay and start its loop
+func NewEpollRelay() (*EpollRelay, error) {
+	epfd, err := unix.EpollCreate1(unix.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	wakeFd, err := unix.Eventfd(0, unix.EFD_NONBLOCK|unix.EFD_CLOEXEC)
+	if err != nil {
+		_ = unix.Close(epfd)
+		return nil, err
+	}
+	err = unix.EpollCtl(epfd, unix.EPOLL_CTL_ADD, wakeFd, &unix.EpollEvent{Events: unix.EPOLLIN, Fd: int32(wakeFd)})
+	if err != nil {
+		_ = unix.Close(wakeFd)
+		_ = unix.Close(epfd)
+		return nil, err
+	}
+	relay := &EpollRelay{
+		epfd:   epfd,
+		wakeFd: wakeFd,
+		done:   make(chan struct{}),
+		pairs:  make(map[int]*epollPair),
+	}
+	go relay.loop()
+	return relay, nil
+}
+
+// count of tunnels relayed
+func (relay *EpollRelay) Count() int {
+	return int(atomic.LoadInt32(&relay.count))
+}
+
+// stop loop, tunnels relayed are closed
+func (relay *EpollRelay) Close() {
+	relay.lock.Lock()
+	if relay.stopped {
+		relay.lock.Unlock()
+		return
+	}
+	relay.stopped = true
+	relay.lock.Unlock()
+	relay.wake()
+	<-relay.done
+}
+
+// take over conns of handler, false if conns are not plain tcp or relay is stopped
+func (relay *EpollRelay) take(pr *handlerPrv) bool {
+	lFd, err := detachFd(pr.lConn)
+	if err != nil {
+		return false
+	}
+	rFd, err := detachFd(pr.rConn)
+	if err != nil {
+		_ = unix.Close(lFd)
+		return false
+	}
+	pair := &epollPair{
+		relay: relay,
+		pr:    pr,
+		lFd:   lFd,
+		rFd:   rFd,
+		up:    epollFlow{src: lFd, dst: rFd},
+		down:  epollFlow{src: rFd, dst: lFd},
+	}
+	relay.lock.Lock()
+	if relay.stopped {
+		relay.lock.Unlock()
+		_ = unix.Close(lFd)
+		_ = unix.Close(rFd)
+		return false
+	}
+	relay.adding = append(relay.adding, pair)
+	relay.lock.Unlock()
+	// sockets are owned by dup fds now, close of conns does not affect tunnel
+	_ = pr.lConn.Close()
+	_ = pr.rConn.Close()
+	pr.setEpollPair(pair)
+	relay.wake()
+	return true
+}
+
+// close tunnel, can be called more than once
+func (pair *epollPair) close() {
+	if !atomic.CompareAndSwapInt32(&pair.closing, 0, 1) {
+		return
+	}
+	relay := pair.relay
+	relay.lock.Lock()
+	relay.closing = append(relay.closing, pair)
+	relay.lock.Unlock()
+	relay.wake()
+}
+
+// wake loop to handle ops
+func (relay *EpollRelay) wake() {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], 1)
+	_, _ = unix.Write(relay.wakeFd, buf[:])
+}
+
+// poll and relay until stopped
+func (relay *EpollRelay) loop() {
+	// data plane is one thread
+	runtime.LockOSThread()
+	defer close(relay.done)
+	events := make([]unix.EpollEvent, epollMaxEvents)
+	for {
+		n, err := unix.EpollWait(relay.epfd, events, -1)
+		if err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			logger.Warningf("epoll wait failed, relay stops, err: %v", err)
+			relay.shutdown()
+			return
+		}
+		for index := 0; index < n; index++ {
+			fd := int(events[index].Fd)
+			if fd == relay.wakeFd {
+				if relay.handleOps() {
+					relay.shutdown()
+					return
+				}
+				continue
+			}
+			pair, ok := relay.pairs[fd]
+			if !ok {
+				continue
+			}
+			relay.handle(pair, events[index].Events)
+		}
+	}
+}
+
+// add and close pairs requested, true if relay is stopped
+func (relay *EpollRelay) handleOps() bool {
+	var buf [8]byte
+	_, _ = unix.Read(relay.wakeFd, buf[:])
+	relay.lock.Lock()
+	adding, closing, stopped := relay.adding, relay.closing, relay.stopped
+	relay.adding, relay.closing = nil, nil
+	relay.lock.Unlock()
+	for _, pair := range adding {
+		relay.add(pair)
+	}
+	for _, pair := range closing {
+		relay.finish(pair, nil)
+	}
+	return stopped
+}
+
+// register fds of pair and relay data already received
+func (relay *EpollRelay) add(pair *epollPair) {
+	atomic.AddInt32(&relay.count, 1)
+	relay.pairs[pair.lFd] = pair
+	relay.pairs[pair.rFd] = pair
+	for _, fd := range []int{pair.lFd, pair.rFd} {
+		err := unix.EpollCtl(relay.epfd, unix.EPOLL_CTL_ADD, fd, &unix.EpollEvent{Events: unix.EPOLLIN, Fd: int32(fd)})
+		if err != nil {
+			relay.finish(pair, err)
+			return
+		}
+	}
+	pair.lEvents, pair.rEvents = unix.EPOLLIN, unix.EPOLLIN
+	relay.handle(pair, 0)
+}
+
+// relay both directions as far as sockets allow, then wait for events they need
+func (relay *EpollRelay) handle(pair *epollPair, events uint32) {
+	if pair.finished {
+		return
+	}
+	err := pair.pump(&pair.up)
+	if err == nil {
+		err = pair.pump(&pair.down)
+	}
+	if err != nil {
+		relay.finish(pair, err)
+		return
+	}
+	if pair.up.shut && pair.down.shut {
+		relay.finish(pair, nil)
+		return
+	}
+	// peer is gone, data left can not be delivered
+	if events&(unix.EPOLLERR|unix.EPOLLHUP) != 0 {
+		relay.finish(pair, errors.New("connection hang up"))
+		return
+	}
+	err = relay.update(pair.lFd, &pair.lEvents, pair.up.wantRead(), pair.down.wantWrite())
+	if err == nil {
+		err = relay.update(pair.rFd, &pair.rEvents, pair.down.wantRead(), pair.up.wantWrite())
+	}
+	if err != nil {
+		relay.finish(pair, err)
+	}
+}
+
+// modify events of fd if changed
+func (relay *EpollRelay) update(fd int, current *uint32, read bool, write bool) error {
+	var events uint32
+	if read {
+		events |= unix.EPOLLIN
+	}
+	if write {
+		events |= unix.EPOLLOUT
+	}
+	if events == *current {
+		return nil
+	}
+	*current = events
+	return unix.EpollCtl(relay.epfd, unix.EPOLL_CTL_MOD, fd, &unix.EpollEvent{Events: events, Fd: int32(fd)})
+}
+
+// close fds of pair and report to handler
+func (relay *EpollRelay) finish(pair *epollPair, err error) {
+	if pair.finished {
+		return
+	}
+	pair.finished = true
+	atomic.StoreInt32(&pair.closing, 1)
+	for _, fd := range []int{pair.lFd, pair.rFd} {
+		if relay.pairs[fd] == pair {
+			delete(relay.pairs, fd)
+			_ = unix.EpollCtl(relay.epfd, unix.EPOLL_CTL_DEL, fd, nil)
+		}
+		_ = unix.Close(fd)
+	}
+	pair.up.release()
+	pair.down.release()
+	atomic.AddInt32(&relay.count, -1)
+	// handler takes lock of manager, loop should not wait for it
+	go pair.pr.epollClosed(pair.up.bytes, pair.down.bytes, err)
+}
+
+// close all pairs and fds of relay
+func (relay *EpollRelay) shutdown() {
+	for _, pair := range relay.pairs {
+		relay.finish(pair, errors.New("relay stopped"))
+	}
+	relay.lock.Lock()
+	adding := relay.adding
+	relay.adding, relay.closing = nil, nil
+	relay.stopped = true
+	relay.lock.Unlock()
+	for _, pair := range adding {
+		// count is increased when pair is added
+		atomic.AddInt32(&relay.count, 1)
+		relay.finish(pair, errors.New("relay stopped"))
+	}
+	_ = unix.Close(relay.wakeFd)
+	_ = unix.Close(relay.epfd)
+}
+
+// move data from src to dst until either would block
+func (pair *epollPair) pump(flow *epollFlow) error {
+	for {
+		for flow.start < flow.end {
+			n, err := unix.Write(flow.dst, flow.buf[flow.start:flow.end])
+			if err == unix.EINTR {
+				continue
+			}
+			if err == unix.EAGAIN {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			flow.start += n
+		}
+		if flow.eof {
+			// half close is passed on, other direction goes on
+			if !flow.shut {
+				flow.shut = true
+				flow.release()
+				_ = unix.Shutdown(flow.dst, unix.SHUT_WR)
+			}
+			return nil
+		}
+		if flow.buf == nil {
+			flow.buf = epollBufPool.Get().([]byte)
+		}
+		n, err := unix.Read(flow.src, flow.buf)
+		if err == unix.EINTR {
+			continue
+		}
+		if err == unix.EAGAIN {
+			// nothing waits to be written, buffer is given back while idle
+			flow.release()
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			flow.eof = true
+			continue
+		}
+		flow.start, flow.end = 0, n
+		flow.bytes += int64(n)
+		if flow == &pair.down && !pair.firstByte {
+			pair.firstByte = true
+			if pair.pr.onFirstByte != nil {
+				pair.pr.onFirstByte()
+			}
+		}
+	}
+}
+
+// src should be polled for read
+func (flow *epollFlow) wantRead() bool {
+	return !flow.eof && flow.start == flow.end
+}
+
+// dst should be polled for write
+func (flow *epollFlow) wantWrite() bool {
+	return flow.start < flow.end
+}
+
+// give buffer back to pool
+func (flow *epollFlow) release() {
+	if flow.buf == nil {
+		return
+	}
+	epollBufPool.Put(flow.buf)
+	flow.buf = nil
+	flow.start, flow.end = 0, 0
+}
+
+// dup fd of plain tcp conn, fd is non-blocking as go net sets
+func detachFd(conn net.Conn) (int, error) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return -1, errors.New("conn is not plain tcp")
+	}
+	raw, err := tcpConn.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+	fd := -1
+	var dupErr error
+	err = raw.Control(func(s uintptr) {
+		fd, dupErr = unix.FcntlInt(s, unix.F_DUPFD_CLOEXEC, 0)
+	})
+	if err != nil {
+		return -1, err
+	}
+	if dupErr != nil {
+		return -1, dupErr
+	}
+	err = unix.SetNonblock(fd, true)
+	if err != nil {
+		_ = unix.Close(fd)
+		return -1, err
+	}
+	return fd, nil
+}
@@ -0,0 +1,125 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package TProxy
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	config "github.com/linuxdeepin/deepin-network-proxy/config"
+	define "github.com/linuxdeepin/deepin-network-proxy/define"
+)
+
+// connected tcp conns on loopback
+func tcpPair(t testing.TB) (net.Conn, net.Conn) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed, err: %v", err)
+	}
+	defer listener.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := listener.Accept()
+		accepted <- conn
+	}()
+	dialed, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial failed, err: %v", err)
+	}
+	return dialed, <-accepted
+}
+
+func TestEpollRelay(t *testing.T) {
+	relay, err := NewEpollRelay()
+	if err != nil {
+		t.Fatalf("create epoll relay failed, err: %v", err)
+	}
+	defer relay.Close()
+	app, lConn := tcpPair(t)
+	rConn, server := tcpPair(t)
+	lAddr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 40000}
+	rAddr := &net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 80}
+	key := HandlerKey{SrcAddr: lAddr.String(), DstAddr: rAddr.String()}
+	handler := NewDirectHandler(define.App, key, config.Proxy{}, lAddr, rAddr, lConn)
+	handler.rConn = rConn
+	mgr := NewHandlerMgr(define.App)
+	mgr.SetRelay(relay)
+	handler.AddMgr(mgr)
+
+	type result struct{ sent, received int64 }
+	closed := make(chan result, 1)
+	handler.OnClose(func(sent int64, received int64) {
+		closed <- result{sent, received}
+	})
+	firstByte := make(chan bool, 1)
+	handler.OnFirstByte(func() {
+		firstByte <- true
+	})
+	handler.Communicate()
+
+	// larger than buffer of relay
+	upload := make([]byte, 3*epollBufSize+1)
+	go func() {
+		_, _ = app.Write(upload)
+		// half close is passed to server, reply still comes back
+		_ = app.(*net.TCPConn).CloseWrite()
+	}()
+	got, err := io.ReadAll(server)
+	if err != nil || len(got) != len(upload) {
+		t.Fatalf("read at server got %d bytes, err: %v", len(got), err)
+	}
+	_, _ = server.Write([]byte("world!"))
+	_ = server.Close()
+	got, err = io.ReadAll(app)
+	if err != nil || string(got) != "world!" {
+		t.Fatalf("read at app got %q, err: %v", got, err)
+	}
+	select {
+	case <-firstByte:
+	default:
+		t.Error("on first byte is not called")
+	}
+
+	select {
+	case res := <-closed:
+		if res.sent != int64(len(upload)) || res.received != 6 {
+			t.Errorf("bytes got sent %d received %d, want %d and 6", res.sent, res.received, len(upload))
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("on close is not called")
+	}
+	if mgr.Count() != 0 || relay.Count() != 0 {
+		t.Errorf("tunnel should be removed, handlers %d relayed %d", mgr.Count(), relay.Count())
+	}
+}
+
+func TestEpollRelayClose(t *testing.T) {
+	relay, err := NewEpollRelay()
+	if err != nil {
+		t.Fatalf("create epoll relay failed, err: %v", err)
+	}
+	defer relay.Close()
+	app, lConn := tcpPair(t)
+	rConn, server := tcpPair(t)
+	defer server.Close()
+	lAddr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 40001}
+	rAddr := &net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 80}
+	key := HandlerKey{SrcAddr: lAddr.String(), DstAddr: rAddr.String()}
+	handler := NewDirectHandler(define.App, key, config.Proxy{}, lAddr, rAddr, lConn)
+	handler.rConn = rConn
+	mgr := NewHandlerMgr(define.App)
+	mgr.SetRelay(relay)
+	handler.AddMgr(mgr)
+	handler.Communicate()
+
+	// tunnel closed by manager closes sockets taken by relay
+	mgr.CloseAll()
+	_ = app.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err := app.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("app should read eof after tunnel closed, err: %v", err)
+	}
+}
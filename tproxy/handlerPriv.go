@@ -8,16 +8,20 @@ import (
 	"errors"
 	"io"
 	"net"
-	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	config "github.com/linuxdeepin/deepin-network-proxy/config"
 	define "github.com/linuxdeepin/deepin-network-proxy/define"
+	logging "github.com/linuxdeepin/deepin-network-proxy/logging"
 )
 
 // handler private, data of handler
 
+// size of buffer of first read from remote
+const firstReadSize = 32 * 1024
+
 type handlerPrv struct {
 	typ ProtoTyp
 
@@ -39,6 +43,23 @@ type handlerPrv struct {
 	// delete mark, in case if delete twice, not use this time
 	deleted bool
 	lock    sync.Mutex
+
+	// bytes relayed, reported when tunnel closed
+	sent     int64
+	received int64
+	onClose  func(sent int64, received int64)
+	// called when first byte is received from remote
+	onFirstByte func()
+
+	// lines of this connection carry its fields
+	log *logging.Logger
+
+	// tunnel taken over by epoll relay, conns are closed then
+	epoll *epollPair
+
+	// unix nano of last activity and if closed, read by connection table
+	active int64
+	closed int32
 }
 
 // new handler private
@@ -59,9 +80,61 @@ func createHandlerPrv(typ ProtoTyp, scope define.Scope, key HandlerKey, proxy co
 
 		// delete mark
 		deleted: false,
+
+		log: logging.New("proxy/tproxy").With("scope", scope, "proto", typ, "local", lAddr, "destination", rAddr),
+
+		active: time.Now().UnixNano(),
 	}
 }
 
+// record activity of tunnel
+func (pr *handlerPrv) touch() {
+	atomic.StoreInt64(&pr.active, time.Now().UnixNano())
+}
+
+// last activity of tunnel
+func (pr *handlerPrv) lastActive() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&pr.active))
+}
+
+// if handler has been closed
+func (pr *handlerPrv) isClosed() bool {
+	return atomic.LoadInt32(&pr.closed) != 0
+}
+
+// udp tunnel never ends by itself
+func (pr *handlerPrv) isUdp() bool {
+	if pr.typ == SOCKS5UDP || pr.typ == MASQUEUDP {
+		return true
+	}
+	_, ok := pr.lConn.(*net.UDPConn)
+	return ok
+}
+
+// conn of udp tunnel records activity of packages, idle udp tunnel is collected by table
+type activeConn struct {
+	net.Conn
+	pr *handlerPrv
+}
+
+func (conn *activeConn) Read(buf []byte) (int, error) {
+	n, err := conn.Conn.Read(buf)
+	if n > 0 {
+		conn.pr.touch()
+	}
+	return n, err
+}
+
+func (conn *activeConn) Write(buf []byte) (int, error) {
+	conn.pr.touch()
+	return conn.Conn.Write(buf)
+}
+
+// attach fields to lines of this connection, kv is key and value in pairs
+func (pr *handlerPrv) AddLogFields(kv ...interface{}) {
+	pr.log = pr.log.With(kv...)
+}
+
 // save parent
 func (pr *handlerPrv) saveParent(parent BaseHandler) {
 	pr.parent = parent
@@ -71,7 +144,7 @@ func (pr *handlerPrv) saveParent(parent BaseHandler) {
 func (pr *handlerPrv) AddMgr(mgr *HandlerMgr) {
 	// check parent
 	if pr.parent == nil {
-		logger.Warningf("handler private has no parent")
+		pr.log.Warningf("handler private has no parent")
 	}
 	// add private manager
 	pr.mgr = mgr
@@ -85,16 +158,39 @@ func (pr *handlerPrv) dialProxy() (net.Conn, error) {
 	if proxy.Port == 0 {
 		proxy.Port = 80
 	}
-	server := proxy.Server + ":" + strconv.Itoa(proxy.Port)
-	conn, err := net.DialTimeout("tcp", server, 3*time.Second)
+	// race ipv6 and ipv4 address if server has both
+	conn, err := dialDualStack(proxy.Server, proxy.Dial.Addrs, proxy.Port, 3*time.Second, dialOpt(proxy))
 	if err != nil {
-		logger.Warningf("[%s] dial proxy server failed, err: %v", pr.typ, err)
-		return nil, err
+		pr.log.Warningf("dial proxy server failed, err: %v", err)
+		return nil, &unreachableErr{err: err}
 	}
-	logger.Infof("[%s] dial proxy server success, local [%s] -> remote [%s]", pr.typ, conn.LocalAddr(), conn.RemoteAddr())
+	// go disables nagle after connect, restore it if configured
+	if tcpConn, ok := conn.(*net.TCPConn); ok && proxy.Dial.Nagle {
+		_ = tcpConn.SetNoDelay(false)
+	}
+	pr.log.Infof("dial proxy server success, local [%s] -> remote [%s]", conn.LocalAddr(), conn.RemoteAddr())
 	return conn, nil
 }
 
+// proxy server cant be reached, such as proxy server is down or network is broken
+type unreachableErr struct {
+	err error
+}
+
+func (e *unreachableErr) Error() string {
+	return "proxy server unreachable: " + e.err.Error()
+}
+
+func (e *unreachableErr) Unwrap() error {
+	return e.err
+}
+
+// check if tunnel failed because proxy server cant be reached
+func IsUnreachable(err error) bool {
+	var unreachable *unreachableErr
+	return errors.As(err, &unreachable)
+}
+
 // read and write
 
 func (pr *handlerPrv) WriteRemote(buf []byte) error {
@@ -103,7 +199,7 @@ func (pr *handlerPrv) WriteRemote(buf []byte) error {
 	}
 	_, err := pr.rConn.Write(buf)
 	if err != nil {
-		logger.Warningf("write remote failed, err: %v", err)
+		pr.log.Warningf("write remote failed, err: %v", err)
 		return err
 	}
 	return nil
@@ -115,7 +211,7 @@ func (pr *handlerPrv) WriteLocal(buf []byte) error {
 	}
 	_, err := pr.lConn.Write(buf)
 	if err != nil {
-		logger.Warningf("write remote failed, err: %v", err)
+		pr.log.Warningf("write remote failed, err: %v", err)
 		return err
 	}
 	return nil
@@ -127,7 +223,7 @@ func (pr *handlerPrv) ReadRemote(buf []byte) error {
 	}
 	_, err := pr.rConn.Read(buf)
 	if err != nil {
-		logger.Warningf("write remote failed, err: %v", err)
+		pr.log.Warningf("write remote failed, err: %v", err)
 		return err
 	}
 	return nil
@@ -139,19 +235,76 @@ func (pr *handlerPrv) ReadLocal(buf []byte) error {
 	}
 	_, err := pr.lConn.Read(buf)
 	if err != nil {
-		logger.Warningf("write remote failed, err: %v", err)
+		pr.log.Warningf("write remote failed, err: %v", err)
 		return err
 	}
 	return nil
 }
 
+// set callback of tunnel closed, must be set before communicate
+func (pr *handlerPrv) OnClose(fn func(sent int64, received int64)) {
+	pr.onClose = fn
+}
+
+// set callback of first byte received from remote, must be set before communicate
+func (pr *handlerPrv) OnFirstByte(fn func()) {
+	pr.onFirstByte = fn
+}
+
+// relay first read of remote alone and report it, the rest is copied by relayCopy which may splice
+func (pr *handlerPrv) relayFirstRead() (int64, error) {
+	if pr.onFirstByte == nil {
+		return 0, nil
+	}
+	buf := make([]byte, firstReadSize)
+	n, err := pr.rConn.Read(buf)
+	if n > 0 {
+		pr.touch()
+		pr.onFirstByte()
+		written, werr := pr.lConn.Write(buf[:n])
+		if werr != nil {
+			return int64(written), werr
+		}
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	return int64(n), err
+}
+
+// wait both directions finish and report bytes relayed
+func (pr *handlerPrv) waitClosed(wg *sync.WaitGroup) {
+	wg.Wait()
+	if pr.onClose != nil {
+		pr.onClose(atomic.LoadInt64(&pr.sent), atomic.LoadInt64(&pr.received))
+	}
+}
+
 // communicate lConn and rConn
 func (pr *handlerPrv) Communicate() {
+	// epoll relay takes over plain tcp tunnels if enabled
+	if pr.mgr != nil {
+		if relay := pr.mgr.Relay(); relay != nil && relay.take(pr) {
+			pr.log.Infof("begin relay data by epoll, local [%s] <-> remote [%s]", pr.lAddr.String(), pr.rAddr.String())
+			return
+		}
+	}
+	pr.touch()
+	// tcp ends with peers, activity of packages is only recorded for udp
+	lConn := pr.lConn
+	if pr.isUdp() {
+		lConn = &activeConn{Conn: lConn, pr: pr}
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go pr.waitClosed(&wg)
 	go func() {
-		logger.Infof("[%s] begin copy data, remote [%s] -> local [%s]", pr.typ, pr.rAddr.String(), pr.lAddr.String())
-		_, err := io.Copy(pr.rConn, pr.lConn)
+		defer wg.Done()
+		pr.log.Infof("begin copy data, remote [%s] -> local [%s]", pr.rAddr.String(), pr.lAddr.String())
+		n, err := relayCopy(pr.rConn, lConn)
+		atomic.AddInt64(&pr.sent, n)
 		if err != nil {
-			logger.Infof("[%s] stop copy data, remote [%s] -x- local [%s], reason: %v", pr.typ, pr.rAddr.String(), pr.lAddr.String(), err)
+			pr.log.Infof("stop copy data, remote [%s] -x- local [%s], reason: %v", pr.rAddr.String(), pr.lAddr.String(), err)
 		}
 		// mark deleted, but not actually deleted at this time, only set a mark
 		if pr.isDeleted() {
@@ -162,10 +315,17 @@ func (pr *handlerPrv) Communicate() {
 		pr.Remove()
 	}()
 	go func() {
-		logger.Infof("[%s] begin copy data, local [%s] -> remote [%s]", pr.typ, pr.lAddr.String(), pr.rAddr.String())
-		_, err := io.Copy(pr.lConn, pr.rConn)
+		defer wg.Done()
+		pr.log.Infof("begin copy data, local [%s] -> remote [%s]", pr.lAddr.String(), pr.rAddr.String())
+		n, err := pr.relayFirstRead()
+		if err == nil {
+			var rest int64
+			rest, err = relayCopy(lConn, pr.rConn)
+			n += rest
+		}
+		atomic.AddInt64(&pr.received, n)
 		if err != nil {
-			logger.Infof("[%s] stop copy data, local [%s] -x- remote [%s], reason: %v", pr.typ, pr.lAddr.String(), pr.rAddr.String(), err)
+			pr.log.Infof("stop copy data, local [%s] -x- remote [%s], reason: %v", pr.lAddr.String(), pr.rAddr.String(), err)
 		}
 		// mark deleted, but not actually deleted at this time, only set a mark
 		if pr.isDeleted() {
@@ -177,6 +337,30 @@ func (pr *handlerPrv) Communicate() {
 	}()
 }
 
+// save pair of epoll relay, tunnel is closed by it
+func (pr *handlerPrv) setEpollPair(pair *epollPair) {
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+	pr.epoll = pair
+}
+
+// called when epoll relay closed tunnel, with bytes sent to and received from remote
+func (pr *handlerPrv) epollClosed(sent int64, received int64, err error) {
+	pr.touch()
+	atomic.AddInt64(&pr.sent, sent)
+	atomic.AddInt64(&pr.received, received)
+	if err != nil {
+		pr.log.Infof("stop relay data by epoll, local [%s] -x- remote [%s], reason: %v", pr.lAddr.String(), pr.rAddr.String(), err)
+	}
+	if !pr.isDeleted() {
+		pr.setDeleted(true)
+		pr.Remove()
+	}
+	if pr.onClose != nil {
+		pr.onClose(atomic.LoadInt64(&pr.sent), atomic.LoadInt64(&pr.received))
+	}
+}
+
 // mark deleted, not used this time
 func (pr *handlerPrv) setDeleted(deleted bool) {
 	pr.lock.Lock()
@@ -194,16 +378,23 @@ func (pr *handlerPrv) isDeleted() bool {
 
 // close handler
 func (pr *handlerPrv) Close() {
+	atomic.StoreInt32(&pr.closed, 1)
+	pr.lock.Lock()
+	pair := pr.epoll
+	pr.lock.Unlock()
+	if pair != nil {
+		pair.close()
+	}
 	if pr.lConn != nil {
 		_ = pr.lConn.Close()
 	}
 	if pr.rConn != nil {
 		_ = pr.rConn.Close()
 	}
-	logger.Debugf("[%s] proxy has successfully closed, local [%s] -> remote [%s]", pr.typ, pr.lAddr.String(), pr.rAddr.String())
+	pr.log.Debugf("proxy has successfully closed, local [%s] -> remote [%s]", pr.lAddr.String(), pr.rAddr.String())
 }
 
 // close and delete handler from manager
 func (pr *handlerPrv) Remove() {
-	pr.mgr.CloseBaseHandler(pr.typ, pr.key)
+	pr.mgr.removeHandler(pr.typ, pr.key, pr.parent)
 }
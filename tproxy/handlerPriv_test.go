@@ -0,0 +1,67 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package TProxy
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	config "github.com/linuxdeepin/deepin-network-proxy/config"
+	define "github.com/linuxdeepin/deepin-network-proxy/define"
+)
+
+func TestOnCloseReportsBytes(t *testing.T) {
+	app, lConn := net.Pipe()
+	rConn, server := net.Pipe()
+	lAddr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 40000}
+	rAddr := &net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 80}
+	key := HandlerKey{SrcAddr: lAddr.String(), DstAddr: rAddr.String()}
+	handler := NewDirectHandler(define.App, key, config.Proxy{}, lAddr, rAddr, lConn)
+	handler.rConn = rConn
+	handler.AddMgr(NewHandlerMgr(define.App))
+
+	type result struct{ sent, received int64 }
+	closed := make(chan result, 1)
+	handler.OnClose(func(sent int64, received int64) {
+		closed <- result{sent, received}
+	})
+	firstByte := make(chan bool, 1)
+	handler.OnFirstByte(func() {
+		firstByte <- true
+	})
+	handler.Communicate()
+
+	go func() {
+		_, _ = app.Write([]byte("hello"))
+	}()
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(server, buf); err != nil {
+		t.Fatalf("read at server failed, err: %v", err)
+	}
+	go func() {
+		_, _ = server.Write([]byte("world!"))
+	}()
+	buf = make([]byte, 6)
+	if _, err := io.ReadFull(app, buf); err != nil {
+		t.Fatalf("read at app failed, err: %v", err)
+	}
+	_ = app.Close()
+	select {
+	case <-firstByte:
+	default:
+		t.Error("on first byte is not called")
+	}
+
+	select {
+	case res := <-closed:
+		if res.sent != 5 || res.received != 6 {
+			t.Errorf("bytes got sent %d received %d, want 5 and 6", res.sent, res.received)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("on close is not called")
+	}
+}
@@ -35,14 +35,14 @@ func (handler *HttpHandlerEProxy) Tunnel() error {
 	br := bufio.NewReader(handler.lConn)
 	lReq, err := http.ReadRequest(br)
 	if err != nil {
-		logger.Warning(err)
+		handler.log.Warningf("%v", err)
 		return err
 	}
 
 	// dial proxy server
 	rConn, err := handler.dialProxy()
 	if err != nil {
-		logger.Warningf("[http] failed to dial proxy server, err: %v", err)
+		handler.log.Warningf("failed to dial proxy server, err: %v", err)
 		return err
 	}
 	// auth
@@ -53,7 +53,7 @@ func (handler *HttpHandlerEProxy) Tunnel() error {
 	if lReq.Method == http.MethodConnect {
 		_, err = handler.lConn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
 		if err != nil {
-			logger.Warningf("[http] write 200 failed, err: %v", err)
+			handler.log.Warningf("write 200 failed, err: %v", err)
 			return err
 		}
 	}
@@ -74,23 +74,23 @@ func (handler *HttpHandlerEProxy) Tunnel() error {
 	}
 
 	// send connect request to rConn to create tunnel
-	logger.Infof("[http] req is %v", req)
+	handler.log.Infof("req is %v", req)
 	err = req.Write(rConn)
 	if err != nil {
-		logger.Warningf("[http] write http tunnel request failed, err: %v", err)
+		handler.log.Warningf("write http tunnel request failed, err: %v", err)
 		return err
 	}
-	logger.Info("[http] write req success")
+	handler.log.Infof("write req success")
 	// read response
 	reader := bufio.NewReader(rConn)
 	resp, err := http.ReadResponse(reader, req)
 	if err != nil {
-		logger.Warningf("[http] read response failed, err: %v", err)
+		handler.log.Warningf("read response failed, err: %v", err)
 		return err
 	} else {
-		logger.Info("[http] read response success")
+		handler.log.Infof("read response success")
 	}
-	logger.Debug(resp.Status)
+	handler.log.Debugf("%v", resp.Status)
 	// close body
 	defer resp.Body.Close()
 	// check if connect success
@@ -98,7 +98,7 @@ func (handler *HttpHandlerEProxy) Tunnel() error {
 		return fmt.Errorf("proxy response error, status code: %v, message: %s",
 			resp.StatusCode, resp.Status)
 	}
-	logger.Infof("[http] proxy: tunnel create success, [%s] -> [%s] -> [%s]",
+	handler.log.Infof("proxy: tunnel create success, [%s] -> [%s] -> [%s]",
 		handler.lAddr.String(), rConn.RemoteAddr(), handler.rAddr.String())
 	// save rConn handler
 	handler.rConn = rConn
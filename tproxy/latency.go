@@ -0,0 +1,157 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package TProxy
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// latency of recent tunnels is kept per proxy, old samples are dropped,
+// so that percentiles follow network changes and least latency strategy chooses by them.
+
+// samples kept of each proxy
+const latencySamples = 128
+
+// failed handshake is counted as this latency, so that unreachable proxy is not chosen
+const handshakeFailurePenalty = 10 * time.Second
+
+// percentiles of proxy in milliseconds, flat for dbus
+type LatencyStats struct {
+	Proxy        string
+	Handshakes   uint32 // samples of handshake
+	HandshakeP50 uint32
+	HandshakeP90 uint32
+	HandshakeP99 uint32
+	FirstBytes   uint32 // samples of time to first byte
+	FirstByteP50 uint32
+	FirstByteP90 uint32
+	FirstByteP99 uint32
+}
+
+// ring of recent samples
+type latencyRing struct {
+	values []time.Duration
+	next   int
+}
+
+func (r *latencyRing) add(d time.Duration) {
+	if len(r.values) < latencySamples {
+		r.values = append(r.values, d)
+		return
+	}
+	r.values[r.next] = d
+	r.next = (r.next + 1) % latencySamples
+}
+
+// nearest rank percentiles in milliseconds
+func (r *latencyRing) percentiles(ps ...float64) []uint32 {
+	result := make([]uint32, len(ps))
+	if r == nil || len(r.values) == 0 {
+		return result
+	}
+	sorted := append([]time.Duration{}, r.values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	for index, p := range ps {
+		rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+		if rank < 0 {
+			rank = 0
+		}
+		result[index] = uint32(sorted[rank].Milliseconds())
+	}
+	return result
+}
+
+func (r *latencyRing) count() uint32 {
+	if r == nil {
+		return 0
+	}
+	return uint32(len(r.values))
+}
+
+func addSample(rings map[string]*latencyRing, proxy string, d time.Duration) {
+	ring, ok := rings[proxy]
+	if !ok {
+		ring = &latencyRing{}
+		rings[proxy] = ring
+	}
+	ring.add(d)
+}
+
+// record time to create tunnel through proxy
+func (mgr *HandlerMgr) RecordHandshake(proxy string, d time.Duration) {
+	mgr.latencyLock.Lock()
+	defer mgr.latencyLock.Unlock()
+	addSample(mgr.handshakes, proxy, d)
+}
+
+// record failed handshake as penalty
+func (mgr *HandlerMgr) RecordHandshakeFailure(proxy string, d time.Duration) {
+	if d < handshakeFailurePenalty {
+		d = handshakeFailurePenalty
+	}
+	mgr.RecordHandshake(proxy, d)
+}
+
+// record time from tunnel started to first byte received
+func (mgr *HandlerMgr) RecordFirstByte(proxy string, d time.Duration) {
+	mgr.latencyLock.Lock()
+	defer mgr.latencyLock.Unlock()
+	addSample(mgr.firstBytes, proxy, d)
+}
+
+// percentiles of all proxies sorted by name
+func (mgr *HandlerMgr) Latency() []LatencyStats {
+	mgr.latencyLock.Lock()
+	defer mgr.latencyLock.Unlock()
+	names := make(map[string]bool)
+	for name := range mgr.handshakes {
+		names[name] = true
+	}
+	for name := range mgr.firstBytes {
+		names[name] = true
+	}
+	result := make([]LatencyStats, 0, len(names))
+	for name := range names {
+		handshake := mgr.handshakes[name].percentiles(0.5, 0.9, 0.99)
+		firstByte := mgr.firstBytes[name].percentiles(0.5, 0.9, 0.99)
+		result = append(result, LatencyStats{
+			Proxy:        name,
+			Handshakes:   mgr.handshakes[name].count(),
+			HandshakeP50: handshake[0],
+			HandshakeP90: handshake[1],
+			HandshakeP99: handshake[2],
+			FirstBytes:   mgr.firstBytes[name].count(),
+			FirstByteP50: firstByte[0],
+			FirstByteP90: firstByte[1],
+			FirstByteP99: firstByte[2],
+		})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Proxy < result[j].Proxy })
+	return result
+}
+
+// proxy of least median handshake latency, proxy without samples is chosen first so that all are measured
+func (mgr *HandlerMgr) LeastLatency(proxies []string) (string, bool) {
+	if len(proxies) == 0 {
+		return "", false
+	}
+	mgr.latencyLock.Lock()
+	defer mgr.latencyLock.Unlock()
+	best := ""
+	var bestLatency uint32
+	for _, proxy := range proxies {
+		ring := mgr.handshakes[proxy]
+		if ring.count() == 0 {
+			return proxy, true
+		}
+		median := ring.percentiles(0.5)[0]
+		if best == "" || median < bestLatency {
+			best, bestLatency = proxy, median
+		}
+	}
+	return best, true
+}
@@ -0,0 +1,65 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package TProxy
+
+import (
+	"testing"
+	"time"
+
+	define "github.com/linuxdeepin/deepin-network-proxy/define"
+)
+
+func TestLatencyPercentiles(t *testing.T) {
+	mgr := NewHandlerMgr(define.App)
+	for ms := 1; ms <= 100; ms++ {
+		mgr.RecordHandshake("http/office", time.Duration(ms)*time.Millisecond)
+	}
+	mgr.RecordFirstByte("http/office", 30*time.Millisecond)
+	mgr.RecordHandshakeFailure("socks5-tcp/home", time.Millisecond)
+
+	stats := mgr.Latency()
+	if len(stats) != 2 || stats[0].Proxy != "http/office" {
+		t.Fatalf("latency got %+v", stats)
+	}
+	office := stats[0]
+	if office.Handshakes != 100 || office.HandshakeP50 != 50 || office.HandshakeP90 != 90 || office.HandshakeP99 != 99 {
+		t.Errorf("handshake of office got %+v", office)
+	}
+	if office.FirstBytes != 1 || office.FirstByteP50 != 30 || office.FirstByteP99 != 30 {
+		t.Errorf("first byte of office got %+v", office)
+	}
+	if stats[1].HandshakeP50 != uint32(handshakeFailurePenalty.Milliseconds()) {
+		t.Errorf("failure should count as penalty, got %+v", stats[1])
+	}
+}
+
+func TestLatencyRingDropsOld(t *testing.T) {
+	mgr := NewHandlerMgr(define.App)
+	for index := 0; index < latencySamples; index++ {
+		mgr.RecordHandshake("http/office", time.Second)
+	}
+	for index := 0; index < latencySamples; index++ {
+		mgr.RecordHandshake("http/office", 10*time.Millisecond)
+	}
+	stats := mgr.Latency()
+	if stats[0].Handshakes != latencySamples || stats[0].HandshakeP99 != 10 {
+		t.Errorf("old samples should be dropped, got %+v", stats[0])
+	}
+}
+
+func TestLeastLatency(t *testing.T) {
+	mgr := NewHandlerMgr(define.App)
+	if _, ok := mgr.LeastLatency(nil); ok {
+		t.Error("no proxy should not be chosen")
+	}
+	mgr.RecordHandshake("http/a", 200*time.Millisecond)
+	mgr.RecordHandshake("http/b", 50*time.Millisecond)
+	if proxy, _ := mgr.LeastLatency([]string{"http/a", "http/b", "http/c"}); proxy != "http/c" {
+		t.Errorf("proxy without samples should be chosen first, got %s", proxy)
+	}
+	if proxy, _ := mgr.LeastLatency([]string{"http/a", "http/b"}); proxy != "http/b" {
+		t.Errorf("least latency got %s, want http/b", proxy)
+	}
+}
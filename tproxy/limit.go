@@ -0,0 +1,128 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package TProxy
+
+import (
+	"sync"
+	"time"
+)
+
+// tunnels of scope and of all scopes are limited, so that one app opening connections in loop
+// can not exhaust fd and memory of daemon. slot is taken before tunnel is created and given back when it closes.
+// max can be changed at any time, tunnels over new max keep running and no new one starts until they close.
+
+// slots of tunnels, zero max means unlimited but slots in use are still counted
+type TunnelLimiter struct {
+	lock  sync.Mutex
+	max   int
+	inUse int
+	// closed and replaced when slot is given back, wakes waiters
+	freed chan struct{}
+}
+
+func NewTunnelLimiter(max int) *TunnelLimiter {
+	return &TunnelLimiter{
+		max:   max,
+		freed: make(chan struct{}),
+	}
+}
+
+// change max, waiters are woken if limit is raised
+func (l *TunnelLimiter) SetMax(max int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if max == l.max {
+		return
+	}
+	l.max = max
+	l.wake()
+}
+
+// max of slots, zero means unlimited
+func (l *TunnelLimiter) Max() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.max
+}
+
+// slots in use
+func (l *TunnelLimiter) InUse() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.inUse
+}
+
+// take slot if free, chan to wait on is returned if not
+func (l *TunnelLimiter) tryAcquire() (bool, chan struct{}) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.max > 0 && l.inUse >= l.max {
+		return false, l.freed
+	}
+	l.inUse++
+	return true, nil
+}
+
+// give back slot
+func (l *TunnelLimiter) release() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.inUse--
+	l.wake()
+}
+
+// should be called with lock held
+func (l *TunnelLimiter) wake() {
+	close(l.freed)
+	l.freed = make(chan struct{})
+}
+
+// take one slot of each limiter, wait until timeout if any is full, zero timeout does not wait.
+// nil limiter is unlimited. release returned gives back all slots, and can be called more than once.
+func AcquireTunnel(timeout time.Duration, limiters ...*TunnelLimiter) (func(), bool) {
+	deadline := time.Now().Add(timeout)
+	for {
+		release, wait := tryAcquireAll(limiters)
+		if wait == nil {
+			return release, true
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, false
+		}
+		timer := time.NewTimer(remaining)
+		select {
+		case <-wait:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// take slots of all limiters or none of them
+func tryAcquireAll(limiters []*TunnelLimiter) (func(), chan struct{}) {
+	var taken []*TunnelLimiter
+	for _, limiter := range limiters {
+		if limiter == nil {
+			continue
+		}
+		ok, wait := limiter.tryAcquire()
+		if !ok {
+			for _, elem := range taken {
+				elem.release()
+			}
+			return nil, wait
+		}
+		taken = append(taken, limiter)
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			for _, limiter := range taken {
+				limiter.release()
+			}
+		})
+	}, nil
+}
@@ -0,0 +1,61 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package TProxy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTunnelLimiter(t *testing.T) {
+	scope := NewTunnelLimiter(2)
+	overall := NewTunnelLimiter(1)
+	release, ok := AcquireTunnel(0, scope, overall)
+	if !ok {
+		t.Fatal("first tunnel should take slot")
+	}
+	// overall is full, slot of scope taken before is given back
+	if _, ok := AcquireTunnel(0, scope, overall); ok {
+		t.Fatal("tunnel over overall limit should not take slot")
+	}
+	if scope.InUse() != 1 || overall.InUse() != 1 {
+		t.Fatalf("slots in use got %d %d", scope.InUse(), overall.InUse())
+	}
+	release()
+	release()
+	if scope.InUse() != 0 || overall.InUse() != 0 {
+		t.Fatalf("slots should be given back once, got %d %d", scope.InUse(), overall.InUse())
+	}
+
+	// nil limiter is unlimited
+	if _, ok := AcquireTunnel(0, nil, NewTunnelLimiter(0)); !ok {
+		t.Error("unlimited tunnel should take slot")
+	}
+}
+
+func TestTunnelLimiterQueue(t *testing.T) {
+	limiter := NewTunnelLimiter(1)
+	release, _ := AcquireTunnel(0, limiter)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		release()
+	}()
+	if _, ok := AcquireTunnel(time.Second, limiter); !ok {
+		t.Fatal("queued tunnel should take slot given back")
+	}
+	start := time.Now()
+	if _, ok := AcquireTunnel(20*time.Millisecond, limiter); ok || time.Since(start) < 20*time.Millisecond {
+		t.Fatal("queued tunnel should time out")
+	}
+
+	// raised limit wakes waiters
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		limiter.SetMax(2)
+	}()
+	if _, ok := AcquireTunnel(time.Second, limiter); !ok {
+		t.Fatal("tunnel should take slot after limit raised")
+	}
+}
@@ -0,0 +1,420 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package TProxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	com "github.com/linuxdeepin/deepin-network-proxy/com"
+	config "github.com/linuxdeepin/deepin-network-proxy/config"
+)
+
+// proxy is tested stage by stage, so that user knows whether name of server, network, proto, password or upstream is wrong.
+// tunnel handlers do all stages in one exchange, so proto is spoken again here.
+// stages after failed one are not run and marked skipped.
+
+// stages of test, in order
+const (
+	StageDNS       = "dns"
+	StageConnect   = "connect"
+	StageHandshake = "handshake"
+	StageAuth      = "auth"
+	StageProbe     = "probe"
+)
+
+// host tunneled to in probe stage, plain http so that any response proves tunnel works
+const ProbeTarget = "www.deepin.org:80"
+
+// deadline of whole test
+const probeTimeout = 10 * time.Second
+
+// result of one stage, flat for dbus
+type ProbeStage struct {
+	Name    string
+	Passed  bool
+	Skipped bool   // not needed by proto, or stage before failed
+	Latency uint32 // milliseconds
+	Error   string
+}
+
+// result of all stages
+type ProbeReport struct {
+	Proxy       string
+	Addr        string
+	Passed      bool
+	FailedStage string // empty if passed
+	Stages      []ProbeStage
+}
+
+// state shared by stages of one test
+type prober struct {
+	proxy  config.Proxy
+	host   string // target
+	port   uint16
+	addrs  []net.IPAddr
+	conn   net.Conn
+	reader *bufio.Reader
+	ctx    context.Context
+	report ProbeReport
+
+	// answers of handshake checked by later stages
+	status int  // http
+	method byte // sock5
+	code   byte // sock4
+}
+
+// test proxy of proto by tunneling to target, target is host:port
+func ProbeProxy(proto ProtoTyp, proxy config.Proxy, target string) (ProbeReport, error) {
+	switch proto {
+	case HTTP, SOCKS4, SOCKS5TCP, SOCKS5UDP:
+	default:
+		return ProbeReport{}, fmt.Errorf("staged test of %s is not supported", proto)
+	}
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return ProbeReport{}, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return ProbeReport{}, fmt.Errorf("port of target %s is invalid", target)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+	p := &prober{
+		proxy: proxy,
+		host:  host,
+		port:  uint16(port),
+		ctx:   ctx,
+		report: ProbeReport{
+			Addr: net.JoinHostPort(proxy.Server, strconv.Itoa(proxy.Port)),
+		},
+	}
+	defer func() {
+		if p.conn != nil {
+			_ = p.conn.Close()
+		}
+	}()
+	p.stage(StageDNS, p.resolve)
+	p.stage(StageConnect, p.connect)
+	switch proto {
+	case HTTP:
+		p.stage(StageHandshake, p.httpConnect)
+		p.stage(StageAuth, p.httpAuth)
+		p.stage(StageProbe, p.httpProbe)
+	case SOCKS4:
+		p.stage(StageHandshake, p.sock4Request)
+		p.stage(StageAuth, p.sock4Auth)
+		p.stage(StageProbe, p.sock4Probe)
+	default:
+		p.stage(StageHandshake, p.sock5Greet)
+		p.stage(StageAuth, p.sock5Auth)
+		p.stage(StageProbe, p.sock5Probe)
+	}
+	p.report.Passed = p.report.FailedStage == ""
+	return p.report, nil
+}
+
+// run stage unless one before failed, fn returns true if stage is not needed
+func (p *prober) stage(name string, fn func() (bool, error)) {
+	stage := ProbeStage{Name: name}
+	if p.report.FailedStage != "" {
+		stage.Skipped = true
+		p.report.Stages = append(p.report.Stages, stage)
+		return
+	}
+	start := time.Now()
+	skip, err := fn()
+	stage.Latency = uint32(time.Since(start).Milliseconds())
+	switch {
+	case err != nil:
+		stage.Error = err.Error()
+		p.report.FailedStage = name
+	case skip:
+		stage.Skipped = true
+	default:
+		stage.Passed = true
+	}
+	p.report.Stages = append(p.report.Stages, stage)
+}
+
+// resolve server of proxy, literal ip and pinned address need not
+func (p *prober) resolve() (bool, error) {
+	if len(p.proxy.Dial.Addrs) != 0 {
+		for _, addr := range p.proxy.Dial.Addrs {
+			if ip := net.ParseIP(addr); ip != nil {
+				p.addrs = append(p.addrs, net.IPAddr{IP: ip})
+			}
+		}
+		return true, nil
+	}
+	if net.ParseIP(p.proxy.Server) != nil {
+		return true, nil
+	}
+	addrs, err := com.SelfResolver.LookupIPAddr(p.ctx, p.proxy.Server)
+	if err != nil {
+		return false, err
+	}
+	if len(addrs) == 0 {
+		return false, errors.New("proxy server has no address")
+	}
+	p.addrs = addrs
+	return false, nil
+}
+
+// dial server of proxy the same way as tunnels
+func (p *prober) connect() (bool, error) {
+	var conn net.Conn
+	var err error
+	if p.addrs == nil {
+		conn, err = dialOpt(p.proxy).Dialer(0).DialContext(p.ctx, "tcp", p.report.Addr)
+	} else {
+		conn, err = raceDial(p.ctx, interleaveAddrs(p.addrs), p.proxy.Port, dialOpt(p.proxy), nil)
+	}
+	if err != nil {
+		return false, err
+	}
+	p.report.Addr = conn.RemoteAddr().String()
+	deadline, _ := p.ctx.Deadline()
+	_ = conn.SetDeadline(deadline)
+	p.conn = conn
+	p.reader = bufio.NewReader(conn)
+	return false, nil
+}
+
+// send head request through tunnel, any http response proves tunnel works
+func (p *prober) headRequest() error {
+	target := net.JoinHostPort(p.host, strconv.Itoa(int(p.port)))
+	req := &http.Request{
+		Method: http.MethodHead,
+		Host:   p.host,
+		URL: &url.URL{
+			Scheme: "http",
+			Host:   target,
+			Path:   "/",
+		},
+		Header: http.Header{},
+		Close:  true,
+	}
+	err := req.Write(p.conn)
+	if err != nil {
+		return err
+	}
+	resp, err := http.ReadResponse(p.reader, req)
+	if err != nil {
+		return fmt.Errorf("no http response of %s through tunnel, err: %v", target, err)
+	}
+	_ = resp.Body.Close()
+	return nil
+}
+
+// port of target in network order
+func (p *prober) portBytes() []byte {
+	portByte := make([]byte, 2)
+	binary.BigEndian.PutUint16(portByte, p.port)
+	return portByte
+}
+
+// credentials of proxy are set
+func (p *prober) hasAuth() bool {
+	return p.proxy.UserName != "" && p.proxy.Password != ""
+}
+
+// error of credentials refused by proxy
+func (p *prober) authError() error {
+	if p.proxy.UserName != "" {
+		return errors.New("wrong username or password")
+	}
+	return errors.New("proxy requires username and password")
+}
+
+// http: CONNECT to target, response is checked by auth and probe
+func (p *prober) httpConnect() (bool, error) {
+	target := net.JoinHostPort(p.host, strconv.Itoa(int(p.port)))
+	req := &http.Request{
+		Method: http.MethodConnect,
+		Host:   target,
+		URL: &url.URL{
+			Host: target,
+		},
+		Header: http.Header{},
+	}
+	if p.hasAuth() {
+		authMsg := p.proxy.UserName + ":" + p.proxy.Password
+		req.Header.Add("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(authMsg)))
+	}
+	err := req.Write(p.conn)
+	if err != nil {
+		return false, err
+	}
+	resp, err := http.ReadResponse(p.reader, req)
+	if err != nil {
+		return false, fmt.Errorf("read response failed, err: %v", err)
+	}
+	_ = resp.Body.Close()
+	p.status = resp.StatusCode
+	return false, nil
+}
+
+// http: 407 means credentials are refused
+func (p *prober) httpAuth() (bool, error) {
+	if p.status == http.StatusProxyAuthRequired {
+		return false, p.authError()
+	}
+	return !p.hasAuth(), nil
+}
+
+// http: tunnel is created if 200
+func (p *prober) httpProbe() (bool, error) {
+	if p.status != http.StatusOK {
+		return false, fmt.Errorf("proxy refused tunnel, status code: %d", p.status)
+	}
+	return false, p.headRequest()
+}
+
+// sock5: greeting offers password method if credentials are set
+func (p *prober) sock5Greet() (bool, error) {
+	buf := []byte{5, 1, 0}
+	if p.hasAuth() {
+		buf = []byte{5, 2, 0, 2}
+	}
+	_, err := p.conn.Write(buf)
+	if err != nil {
+		return false, err
+	}
+	_, err = io.ReadFull(p.reader, buf[:2])
+	if err != nil {
+		return false, fmt.Errorf("read greeting failed, err: %v", err)
+	}
+	if buf[0] != 5 {
+		return false, fmt.Errorf("server is not sock5, version: %v", buf[0])
+	}
+	if buf[1] != 0 && buf[1] != 2 && buf[1] != 0xff {
+		return false, fmt.Errorf("auth method %v is not supported", buf[1])
+	}
+	p.method = buf[1]
+	return false, nil
+}
+
+// sock5: username and password of RFC1929
+func (p *prober) sock5Auth() (bool, error) {
+	switch {
+	case p.method == 0:
+		return true, nil
+	case p.method == 0xff || !p.hasAuth():
+		return false, p.authError()
+	}
+	buf := []byte{1, byte(len(p.proxy.UserName))}
+	buf = append(buf, p.proxy.UserName...)
+	buf = append(buf, byte(len(p.proxy.Password)))
+	buf = append(buf, p.proxy.Password...)
+	_, err := p.conn.Write(buf)
+	if err != nil {
+		return false, err
+	}
+	_, err = io.ReadFull(p.reader, buf[:2])
+	if err != nil {
+		return false, fmt.Errorf("read auth response failed, err: %v", err)
+	}
+	if buf[1] != 0 {
+		return false, p.authError()
+	}
+	return false, nil
+}
+
+// sock5: CONNECT to domain of target, server resolves it
+func (p *prober) sock5Probe() (bool, error) {
+	if len(p.host) > 255 {
+		return false, errors.New("domain name out of max length")
+	}
+	buf := []byte{5, 1, 0, 3, byte(len(p.host))}
+	buf = append(buf, p.host...)
+	buf = append(buf, p.portBytes()...)
+	_, err := p.conn.Write(buf)
+	if err != nil {
+		return false, err
+	}
+	// VER REP RSV ATYP
+	head := make([]byte, 4)
+	_, err = io.ReadFull(p.reader, head)
+	if err != nil {
+		return false, fmt.Errorf("read connect response failed, err: %v", err)
+	}
+	if head[1] != 0 {
+		return false, fmt.Errorf("proxy refused tunnel, reply: %v", head[1])
+	}
+	// bound address and port
+	var size int
+	switch head[3] {
+	case 1:
+		size = net.IPv4len + 2
+	case 4:
+		size = net.IPv6len + 2
+	case 3:
+		length, err := p.reader.ReadByte()
+		if err != nil {
+			return false, err
+		}
+		size = int(length) + 2
+	default:
+		return false, fmt.Errorf("address type %v of reply is invalid", head[3])
+	}
+	_, err = io.ReadFull(p.reader, make([]byte, size))
+	if err != nil {
+		return false, err
+	}
+	return false, p.headRequest()
+}
+
+// sock4: request of sock4a carries domain of target, proxy only answers once
+func (p *prober) sock4Request() (bool, error) {
+	buf := []byte{4, 1}
+	buf = append(buf, p.portBytes()...)
+	buf = append(buf, 0, 0, 0, 1)
+	buf = append(buf, p.proxy.UserName...)
+	buf = append(buf, 0)
+	buf = append(buf, p.host...)
+	buf = append(buf, 0)
+	_, err := p.conn.Write(buf)
+	if err != nil {
+		return false, err
+	}
+	// VN CD DSTPORT DSTIP
+	_, err = io.ReadFull(p.reader, buf[:8])
+	if err != nil {
+		return false, fmt.Errorf("read response failed, err: %v", err)
+	}
+	if buf[0] != 0 {
+		return false, fmt.Errorf("server is not sock4, version: %v", buf[0])
+	}
+	p.code = buf[1]
+	return false, nil
+}
+
+// sock4: 92 and 93 mean user id is refused by identd
+func (p *prober) sock4Auth() (bool, error) {
+	if p.code == 92 || p.code == 93 {
+		return false, fmt.Errorf("user id is refused, code: %v", p.code)
+	}
+	return p.proxy.UserName == "", nil
+}
+
+// sock4: tunnel is created if 90
+func (p *prober) sock4Probe() (bool, error) {
+	if p.code != 90 {
+		return false, fmt.Errorf("proxy refused tunnel, code: %v", p.code)
+	}
+	return false, p.headRequest()
+}
@@ -0,0 +1,185 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package TProxy
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	config "github.com/linuxdeepin/deepin-network-proxy/config"
+)
+
+// serve each connection of listener by fn in background
+func serveFake(t *testing.T, fn func(conn net.Conn, reader *bufio.Reader)) config.Proxy {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				fn(conn, bufio.NewReader(conn))
+			}()
+		}
+	}()
+	addr := l.Addr().(*net.TCPAddr)
+	return config.Proxy{Server: "127.0.0.1", Port: addr.Port, UserName: "user"}
+}
+
+// answer head request sent through tunnel
+func answerHead(conn net.Conn, reader *bufio.Reader) {
+	req, err := http.ReadRequest(reader)
+	if err != nil {
+		return
+	}
+	_ = req.Body.Close()
+	_, _ = io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+}
+
+// stages of report by name
+func stagesOf(report ProbeReport) map[string]ProbeStage {
+	stages := make(map[string]ProbeStage)
+	for _, stage := range report.Stages {
+		stages[stage.Name] = stage
+	}
+	return stages
+}
+
+func TestProbeHttp(t *testing.T) {
+	want := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:secret"))
+	proxy := serveFake(t, func(conn net.Conn, reader *bufio.Reader) {
+		req, err := http.ReadRequest(reader)
+		if err != nil {
+			return
+		}
+		if req.Method != http.MethodConnect || req.Host != "example.test:80" {
+			_, _ = io.WriteString(conn, "HTTP/1.1 400 Bad Request\r\n\r\n")
+			return
+		}
+		if req.Header.Get("Proxy-Authorization") != want {
+			_, _ = io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+			return
+		}
+		_, _ = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		answerHead(conn, reader)
+	})
+
+	proxy.Password = "secret"
+	report, err := ProbeProxy(HTTP, proxy, "example.test:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Passed || report.FailedStage != "" || len(report.Stages) != 5 {
+		t.Fatalf("probe should pass, report: %+v", report)
+	}
+	stages := stagesOf(report)
+	if !stages[StageDNS].Skipped || !stages[StageAuth].Passed || !stages[StageProbe].Passed {
+		t.Errorf("stages are wrong: %+v", report.Stages)
+	}
+
+	proxy.Password = "wrong"
+	report, _ = ProbeProxy(HTTP, proxy, "example.test:80")
+	if report.Passed || report.FailedStage != StageAuth {
+		t.Fatalf("probe should fail at auth, report: %+v", report)
+	}
+	stages = stagesOf(report)
+	if stages[StageAuth].Error != "wrong username or password" || !stages[StageProbe].Skipped {
+		t.Errorf("stages are wrong: %+v", report.Stages)
+	}
+}
+
+func TestProbeSock5(t *testing.T) {
+	proxy := serveFake(t, func(conn net.Conn, reader *bufio.Reader) {
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(reader, buf); err != nil || buf[0] != 5 || buf[3] != 2 {
+			return
+		}
+		_, _ = conn.Write([]byte{5, 2})
+		// VER ULEN UNAME PLEN PASSWD
+		head := make([]byte, 2)
+		if _, err := io.ReadFull(reader, head); err != nil {
+			return
+		}
+		user := make([]byte, head[1]+1)
+		if _, err := io.ReadFull(reader, user); err != nil {
+			return
+		}
+		password := make([]byte, user[len(user)-1])
+		if _, err := io.ReadFull(reader, password); err != nil {
+			return
+		}
+		if string(user[:len(user)-1]) != "user" || string(password) != "secret" {
+			_, _ = conn.Write([]byte{1, 1})
+			return
+		}
+		_, _ = conn.Write([]byte{1, 0})
+		// VER CMD RSV ATYP LEN DOMAIN PORT
+		if _, err := io.ReadFull(reader, buf[:4]); err != nil || buf[3] != 3 {
+			return
+		}
+		length, _ := reader.ReadByte()
+		domain := make([]byte, int(length)+2)
+		if _, err := io.ReadFull(reader, domain); err != nil {
+			return
+		}
+		if string(domain[:length]) != "example.test" {
+			_, _ = conn.Write([]byte{5, 4, 0, 1, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		_, _ = conn.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 80})
+		answerHead(conn, reader)
+	})
+
+	proxy.Password = "secret"
+	report, err := ProbeProxy(SOCKS5TCP, proxy, "example.test:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Passed {
+		t.Fatalf("probe should pass, report: %+v", report)
+	}
+
+	proxy.Password = "wrong"
+	report, _ = ProbeProxy(SOCKS5TCP, proxy, "example.test:80")
+	if report.FailedStage != StageAuth || stagesOf(report)[StageAuth].Error != "wrong username or password" {
+		t.Fatalf("probe should fail at auth, report: %+v", report)
+	}
+
+	proxy.Password = "secret"
+	report, _ = ProbeProxy(SOCKS5TCP, proxy, "other.test:80")
+	if report.FailedStage != StageProbe || !stagesOf(report)[StageAuth].Passed {
+		t.Fatalf("probe should fail at probe, report: %+v", report)
+	}
+}
+
+func TestProbeConnectFailed(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	_ = l.Close()
+	report, err := ProbeProxy(HTTP, config.Proxy{Server: "127.0.0.1", Port: port}, "example.test:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.FailedStage != StageConnect || len(report.Stages) != 5 || !report.Stages[4].Skipped {
+		t.Errorf("probe should fail at connect, report: %+v", report)
+	}
+	_, err = ProbeProxy(MASQUETCP, config.Proxy{Server: "127.0.0.1", Port: port}, "example.test:80")
+	if err == nil {
+		t.Error("masque should not be supported")
+	}
+}
@@ -0,0 +1,106 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package TProxy
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+
+	config "github.com/linuxdeepin/deepin-network-proxy/config"
+)
+
+// default retry policy, used by fields not set, tunnel is never retried if attempts is not set
+const (
+	defaultRetryBackoff    = 200  // millisecond
+	defaultRetryMaxBackoff = 2000 // millisecond
+	defaultRetryJitter     = 0.2
+)
+
+// retry policy with all fields filled
+type retryPolicy struct {
+	attempts   int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	jitter     float64
+}
+
+// fill field not set of policy with default value, 0 set is kept
+func fillRetryPolicy(policy config.RetryPolicy) retryPolicy {
+	filled := retryPolicy{
+		attempts:   1,
+		backoff:    defaultRetryBackoff * time.Millisecond,
+		maxBackoff: defaultRetryMaxBackoff * time.Millisecond,
+		jitter:     defaultRetryJitter,
+	}
+	if policy.Attempts != nil && *policy.Attempts > 1 {
+		filled.attempts = *policy.Attempts
+	}
+	if policy.Backoff != nil && *policy.Backoff >= 0 {
+		filled.backoff = time.Duration(*policy.Backoff) * time.Millisecond
+	}
+	if policy.MaxBackoff != nil && *policy.MaxBackoff >= 0 {
+		filled.maxBackoff = time.Duration(*policy.MaxBackoff) * time.Millisecond
+	}
+	if policy.Jitter != nil && *policy.Jitter >= 0 && *policy.Jitter <= 1 {
+		filled.jitter = *policy.Jitter
+	}
+	return filled
+}
+
+// backoff before next retry, attempt is count of failed attempts
+func retryBackoff(policy retryPolicy, attempt int) time.Duration {
+	backoff := policy.backoff
+	maxBackoff := policy.maxBackoff
+	for index := 1; index < attempt && backoff < maxBackoff; index++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	// random in [backoff*(1-jitter), backoff*(1+jitter)], in case all connections retry at the same time
+	jitter := (rand.Float64()*2 - 1) * policy.jitter
+	return backoff + time.Duration(float64(backoff)*jitter)
+}
+
+// check if error may disappear after a while, such as proxy server restarting
+func isTransientErr(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// run tunnel until success, retry only when error is transient
+func (pr *handlerPrv) retryTunnel(tunnel func() error) error {
+	policy := fillRetryPolicy(pr.proxy.Retry)
+	for attempt := 1; ; attempt++ {
+		err := tunnel()
+		if err == nil {
+			return nil
+		}
+		// release remote connection of failed attempt
+		if pr.rConn != nil {
+			_ = pr.rConn.Close()
+			pr.rConn = nil
+		}
+		if attempt >= policy.attempts || !isTransientErr(err) {
+			return err
+		}
+		backoff := retryBackoff(policy, attempt)
+		pr.log.Infof("create tunnel failed, retry after %v, attempt: %d/%d, err: %v",
+			backoff, attempt, policy.attempts, err)
+		time.Sleep(backoff)
+	}
+}
@@ -0,0 +1,34 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package TProxy
+
+import (
+	"testing"
+	"time"
+
+	config "github.com/linuxdeepin/deepin-network-proxy/config"
+)
+
+func TestFillRetryPolicy(t *testing.T) {
+	// policy not set keeps never retrying
+	if policy := fillRetryPolicy(config.RetryPolicy{}); policy.attempts != 1 {
+		t.Fatalf("attempts of zero value policy got %d, want 1", policy.attempts)
+	}
+
+	attempts, backoff, jitter := 3, 100, 0.0
+	policy := fillRetryPolicy(config.RetryPolicy{Attempts: &attempts, Backoff: &backoff, Jitter: &jitter})
+	if policy.attempts != 3 || policy.backoff != 100*time.Millisecond {
+		t.Fatalf("fields set should be kept, got %+v", policy)
+	}
+	if policy.maxBackoff != defaultRetryMaxBackoff*time.Millisecond {
+		t.Fatalf("max backoff not set should be default, got %v", policy.maxBackoff)
+	}
+	// jitter of 0 is off, backoff is exact
+	for attempt, want := range []time.Duration{100, 200, 400} {
+		if got := retryBackoff(policy, attempt+1); got != want*time.Millisecond {
+			t.Errorf("backoff of attempt %d got %v, want %v", attempt+1, got, want*time.Millisecond)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package TProxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// max bytes peeked from connection
+	sniffBufSize = 8192
+	// max time to wait for first packet, server-first proto such as smtp never send it
+	sniffTimeout = 300 * time.Millisecond
+)
+
+// http method, used to check if data is http request
+var sniffHttpMethods = map[string]bool{
+	"GET":     true,
+	"POST":    true,
+	"PUT":     true,
+	"HEAD":    true,
+	"DELETE":  true,
+	"OPTIONS": true,
+	"PATCH":   true,
+	"TRACE":   true,
+}
+
+// conn with sniffed data, sniffed data is replayed before reading from conn
+type sniffConn struct {
+	net.Conn
+	buf []byte
+}
+
+func (conn *sniffConn) Read(buf []byte) (int, error) {
+	if len(conn.buf) > 0 {
+		n := copy(buf, conn.buf)
+		conn.buf = conn.buf[n:]
+		return n, nil
+	}
+	return conn.Conn.Read(buf)
+}
+
+// sniff domain from first packet of connection, support tls sni and http host,
+// return conn which should be used instead of origin conn
+func SniffDomain(conn net.Conn) (net.Conn, string) {
+	buf := make([]byte, sniffBufSize)
+	_ = conn.SetReadDeadline(time.Now().Add(sniffTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+	var n int
+	var domain string
+	for n < len(buf) {
+		cnt, err := conn.Read(buf[n:])
+		n += cnt
+		var more bool
+		domain, more = sniff(buf[:n])
+		if !more || err != nil {
+			break
+		}
+	}
+	logger.Debugf("sniff domain [%s] from [%s]", domain, conn.RemoteAddr())
+	return &sniffConn{Conn: conn, buf: buf[:n]}, domain
+}
+
+// sniff domain from data, more means data is incomplete
+func sniff(data []byte) (domain string, more bool) {
+	if len(data) == 0 {
+		return "", true
+	}
+	// tls handshake record
+	if data[0] == 0x16 {
+		return sniffTls(data)
+	}
+	return sniffHttp(data)
+}
+
+/*
+tls record and client hello
++------+---------+--------+----------+---------+---------+--------+------------+-------------+---------+-------------+------------+
+| TYPE | VERSION | LENGTH | HS TYPE  | HS LEN  | VERSION | RANDOM | SESSION ID | CIPHER SUIT | COMPRESS| EXTENSIONS  |    ...     |
++------+---------+--------+----------+---------+---------+--------+------------+-------------+---------+-------------+------------+
+|  1   |    2    |   2    |    1     |    3    |    2    |   32   |  1 + var   |   2 + var   | 1 + var |   2 + var   |            |
++------+---------+--------+----------+---------+---------+--------+------------+-------------+---------+-------------+------------+
+*/
+func sniffTls(data []byte) (string, bool) {
+	if len(data) < 5 {
+		return "", true
+	}
+	recLen := int(binary.BigEndian.Uint16(data[3:5]))
+	if len(data) < 5+recLen {
+		return "", true
+	}
+	hs := data[5 : 5+recLen]
+	// client hello
+	if len(hs) < 4 || hs[0] != 1 {
+		return "", false
+	}
+	hsLen := int(hs[1])<<16 | int(hs[2])<<8 | int(hs[3])
+	body := hs[4:]
+	if len(body) > hsLen {
+		body = body[:hsLen]
+	}
+	// skip version and random
+	pos := 34
+	// skip session id
+	if pos+1 > len(body) {
+		return "", false
+	}
+	pos += 1 + int(body[pos])
+	// skip cipher suites
+	if pos+2 > len(body) {
+		return "", false
+	}
+	pos += 2 + int(binary.BigEndian.Uint16(body[pos:]))
+	// skip compression methods
+	if pos+1 > len(body) {
+		return "", false
+	}
+	pos += 1 + int(body[pos])
+	// extensions
+	if pos+2 > len(body) {
+		return "", false
+	}
+	extEnd := pos + 2 + int(binary.BigEndian.Uint16(body[pos:]))
+	if extEnd > len(body) {
+		extEnd = len(body)
+	}
+	pos += 2
+	for pos+4 <= extEnd {
+		extTyp := binary.BigEndian.Uint16(body[pos:])
+		extLen := int(binary.BigEndian.Uint16(body[pos+2:]))
+		pos += 4
+		if pos+extLen > extEnd {
+			break
+		}
+		// server name extension
+		if extTyp == 0 {
+			return parseServerName(body[pos : pos+extLen]), false
+		}
+		pos += extLen
+	}
+	return "", false
+}
+
+// parse server name extension, return the first host name
+func parseServerName(ext []byte) string {
+	if len(ext) < 2 {
+		return ""
+	}
+	listEnd := 2 + int(binary.BigEndian.Uint16(ext))
+	if listEnd > len(ext) {
+		listEnd = len(ext)
+	}
+	pos := 2
+	for pos+3 <= listEnd {
+		nameTyp := ext[pos]
+		nameLen := int(binary.BigEndian.Uint16(ext[pos+1:]))
+		pos += 3
+		if pos+nameLen > listEnd {
+			break
+		}
+		// host name
+		if nameTyp == 0 {
+			return string(ext[pos : pos+nameLen])
+		}
+		pos += nameLen
+	}
+	return ""
+}
+
+// sniff host header from http request
+func sniffHttp(data []byte) (string, bool) {
+	// check method first
+	index := bytes.IndexByte(data, ' ')
+	if index < 0 {
+		return "", len(data) < 8
+	}
+	if !sniffHttpMethods[string(data[:index])] {
+		return "", false
+	}
+	header := data
+	end := bytes.Index(data, []byte("\r\n\r\n"))
+	if end >= 0 {
+		header = data[:end]
+	}
+	lines := bytes.Split(header, []byte("\r\n"))
+	// last line may be incomplete if header not end
+	if end < 0 {
+		lines = lines[:len(lines)-1]
+	}
+	for index := 1; index < len(lines); index++ {
+		line := lines[index]
+		colon := bytes.IndexByte(line, ':')
+		if colon <= 0 || !strings.EqualFold(string(bytes.TrimSpace(line[:colon])), "host") {
+			continue
+		}
+		host := strings.TrimSpace(string(line[colon+1:]))
+		// remove port
+		if hostname, _, err := net.SplitHostPort(host); err == nil {
+			host = hostname
+		}
+		return host, false
+	}
+	// header is incomplete
+	return "", end < 0
+}
@@ -0,0 +1,50 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package TProxy
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestSniffTls(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		conn := tls.Client(client, &tls.Config{ServerName: "www.deepin.org"})
+		_ = conn.Handshake()
+		_ = client.Close()
+	}()
+	conn, domain := SniffDomain(server)
+	if domain != "www.deepin.org" {
+		t.Errorf("sniff tls domain failed, domain: %s", domain)
+	}
+	// sniffed data should be replayed
+	buf := make([]byte, 1)
+	_, err := io.ReadFull(conn, buf)
+	if err != nil || buf[0] != 0x16 {
+		t.Errorf("replay sniffed data failed, err: %v, data: %v", err, buf)
+	}
+}
+
+func TestSniffHttp(t *testing.T) {
+	data := []byte("GET / HTTP/1.1\r\nUser-Agent: curl\r\nHost: www.deepin.org:8080\r\n\r\n")
+	domain, more := sniff(data)
+	if domain != "www.deepin.org" || more {
+		t.Errorf("sniff http domain failed, domain: %s, more: %v", domain, more)
+	}
+	// incomplete header
+	_, more = sniff(data[:20])
+	if !more {
+		t.Error("incomplete http header should need more data")
+	}
+	// unknown proto
+	domain, more = sniff([]byte("SSH-2.0-OpenSSH_8.4\r\n"))
+	if domain != "" || more {
+		t.Errorf("unknown proto should not be sniffed, domain: %s, more: %v", domain, more)
+	}
+}
@@ -0,0 +1,345 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package TProxy
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	com "github.com/linuxdeepin/deepin-network-proxy/com"
+	config "github.com/linuxdeepin/deepin-network-proxy/config"
+	define "github.com/linuxdeepin/deepin-network-proxy/define"
+)
+
+const (
+	// session is closed if no package is relayed in this period
+	udpNatTimeout = 60 * time.Second
+	// packages of session queued while udp associate is in progress, dropped if more
+	udpPendingLen = 64
+)
+
+// full cone nat session table of socks5 udp, key is source endpoint of client.
+// all packages from one client endpoint share one udp associate, whatever the remote is,
+// and package from any remote is sent back to client, which stun and game traffic expect.
+// udp associate of new session is created in its own goroutine, packages of session are queued
+// until it is ready, so that worker relaying packages never waits handshake with proxy.
+type UdpNatTable struct {
+	scope define.Scope
+	proxy config.Proxy
+	// called with result of each udp associate
+	onAssociate func(err error)
+	// take slot of tunnel limiters for new session, release is called when session expires
+	acquire func() (release func(), ok bool)
+
+	lock     sync.Mutex
+	sessions map[string]*udpNatSession
+}
+
+// session of one client endpoint
+type udpNatSession struct {
+	table *UdpNatTable
+	lAddr *net.UDPAddr
+
+	lock sync.Mutex
+	// nil until udp associate is created, packages are queued in pending before it
+	handler *UdpSock5Handler
+	pending [][]byte
+	release func()              // slot of tunnel limiters held by session
+	lConns  map[string]net.Conn // fake conn from remote to client, key is remote addr
+	active  time.Time
+	closed  bool
+}
+
+func NewUdpNatTable(scope define.Scope, proxy config.Proxy) *UdpNatTable {
+	return &UdpNatTable{
+		scope:    scope,
+		proxy:    proxy,
+		sessions: make(map[string]*udpNatSession),
+	}
+}
+
+// relay package from client to remote, session is created for new client endpoint
+func (table *UdpNatTable) Relay(lAddr *net.UDPAddr, rAddr *net.UDPAddr, data []byte) error {
+	return table.getSession(lAddr).writeRemote(rAddr, data)
+}
+
+// set func called with result of each udp associate, such as checking kill switch
+func (table *UdpNatTable) SetAssociateHook(hook func(err error)) {
+	table.lock.Lock()
+	defer table.lock.Unlock()
+	table.onAssociate = hook
+}
+
+// set func taking slot of tunnel for each session, session is closed if no slot
+func (table *UdpNatTable) SetSlotHook(acquire func() (release func(), ok bool)) {
+	table.lock.Lock()
+	defer table.lock.Unlock()
+	table.acquire = acquire
+}
+
+// replace proxy server, sessions already associated keep the old one
+func (table *UdpNatTable) SetProxy(proxy config.Proxy) {
+	table.lock.Lock()
+	defer table.lock.Unlock()
+	table.proxy = proxy
+}
+
+// count of session
+func (table *UdpNatTable) Count() int {
+	table.lock.Lock()
+	defer table.lock.Unlock()
+	return len(table.sessions)
+}
+
+// count of session relayed package in last idle period
+func (table *UdpNatTable) LiveCount(idle time.Duration) int {
+	table.lock.Lock()
+	sessions := make([]*udpNatSession, 0, len(table.sessions))
+	for _, session := range table.sessions {
+		sessions = append(sessions, session)
+	}
+	table.lock.Unlock()
+	var count int
+	for _, session := range sessions {
+		session.lock.Lock()
+		if time.Since(session.active) < idle {
+			count++
+		}
+		session.lock.Unlock()
+	}
+	return count
+}
+
+// close all session
+func (table *UdpNatTable) Close() {
+	table.lock.Lock()
+	sessions := table.sessions
+	table.sessions = make(map[string]*udpNatSession)
+	table.lock.Unlock()
+	for _, session := range sessions {
+		session.close()
+	}
+}
+
+// get session of client endpoint, session created starts udp associate in background
+func (table *UdpNatTable) getSession(lAddr *net.UDPAddr) *udpNatSession {
+	key := lAddr.String()
+	table.lock.Lock()
+	defer table.lock.Unlock()
+	if session, ok := table.sessions[key]; ok {
+		return session
+	}
+	session := &udpNatSession{
+		table:  table,
+		lAddr:  lAddr,
+		lConns: make(map[string]net.Conn),
+		active: time.Now(),
+	}
+	table.sessions[key] = session
+	go session.associate(table.proxy, table.acquire, table.onAssociate)
+	return session
+}
+
+// create udp associate, packages queued are sent in order once it is created
+func (session *udpNatSession) associate(proxy config.Proxy, acquire func() (func(), bool), hook func(err error)) {
+	table := session.table
+	// slot may be waited in queue, packages keep queued meanwhile
+	if acquire != nil {
+		release, ok := acquire()
+		if !ok {
+			logger.Warningf("[%s] udp nat session has no free tunnel, local [%s]", table.scope, session.lAddr)
+			session.close()
+			return
+		}
+		session.lock.Lock()
+		if session.closed {
+			session.lock.Unlock()
+			release()
+			return
+		}
+		session.release = release
+		session.lock.Unlock()
+	}
+	// client source is unknown to proxy, associate with unspecified addr
+	rAddr := &net.UDPAddr{IP: net.IPv4zero}
+	handler := NewUdpSock5Handler(table.scope, HandlerKey{SrcAddr: session.lAddr.String()}, proxy, session.lAddr, rAddr, nil)
+	err := handler.Tunnel()
+	if hook != nil {
+		hook(err)
+	}
+	if err != nil {
+		logger.Warningf("[%s] udp nat session associate failed, local [%s], err: %v", table.scope, session.lAddr, err)
+		handler.Close()
+		session.close()
+		return
+	}
+	session.lock.Lock()
+	if session.closed {
+		session.lock.Unlock()
+		handler.Close()
+		return
+	}
+	for _, msg := range session.pending {
+		err = handler.WriteRemote(msg)
+		if err != nil {
+			logger.Debugf("[%s] write udp package queued failed, err: %v", table.scope, err)
+		}
+	}
+	session.pending = nil
+	session.handler = handler
+	session.lock.Unlock()
+	logger.Debugf("[%s] udp nat session create success, local [%s]", table.scope, session.lAddr)
+
+	go session.readRemote(handler)
+	// udp associate terminates when tcp connection closes
+	go func() {
+		_, _ = io.Copy(io.Discard, handler.rTcpConn)
+		session.close()
+	}()
+}
+
+// remove session from table
+func (table *UdpNatTable) remove(session *udpNatSession) {
+	table.lock.Lock()
+	defer table.lock.Unlock()
+	key := session.lAddr.String()
+	if table.sessions[key] == session {
+		delete(table.sessions, key)
+	}
+}
+
+// check if no package relayed for a long time
+func (session *udpNatSession) idle() bool {
+	session.lock.Lock()
+	defer session.lock.Unlock()
+	return time.Since(session.active) >= udpNatTimeout
+}
+
+// write package to remote through udp relay of proxy, queued if udp associate is in progress
+func (session *udpNatSession) writeRemote(rAddr *net.UDPAddr, data []byte) error {
+	pkgData := com.DataPackage{
+		Addr: rAddr,
+		Data: data,
+	}
+	msg := com.MarshalPackage(pkgData, "udp")
+	session.lock.Lock()
+	session.active = time.Now()
+	if session.closed {
+		session.lock.Unlock()
+		return errors.New("session is closed")
+	}
+	handler := session.handler
+	if handler == nil {
+		// package is dropped as network may drop it anyway
+		if len(session.pending) < udpPendingLen {
+			session.pending = append(session.pending, msg)
+		}
+		session.lock.Unlock()
+		return nil
+	}
+	session.lock.Unlock()
+	return handler.WriteRemote(msg)
+}
+
+// write package to client, source addr of package is remote addr
+func (session *udpNatSession) writeLocal(rAddr *net.UDPAddr, data []byte) error {
+	session.lock.Lock()
+	if session.closed {
+		session.lock.Unlock()
+		return errors.New("session is closed")
+	}
+	session.active = time.Now()
+	key := rAddr.String()
+	lConn, ok := session.lConns[key]
+	if !ok {
+		// make a fake udp dial to cheat socket
+		var err error
+		lConn, err = com.MegaDial("udp", rAddr, session.lAddr)
+		if err != nil {
+			session.lock.Unlock()
+			logger.Warningf("fake dial udp rAddr to lAddr failed, err: %v", err)
+			return err
+		}
+		session.lConns[key] = lConn
+	}
+	session.lock.Unlock()
+	_, err := lConn.Write(data)
+	return err
+}
+
+// read package from udp relay of proxy, dispatch to client by remote addr
+func (session *udpNatSession) readRemote(handler *UdpSock5Handler) {
+	defer session.close()
+	rConn := handler.rConn
+	buf := make([]byte, 65535)
+	for {
+		_ = rConn.SetReadDeadline(time.Now().Add(udpNatTimeout))
+		n, err := rConn.Read(buf)
+		if err != nil {
+			// client may still send package without any reply
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() && !session.idle() {
+				continue
+			}
+			logger.Debugf("[%s] udp nat session stop, local [%s], reason: %v", session.table.scope, session.lAddr, err)
+			return
+		}
+		rAddr, data, err := parseUdpPackage(buf[:n])
+		if err != nil {
+			logger.Debugf("[%s] drop invalid udp package, err: %v", session.table.scope, err)
+			continue
+		}
+		err = session.writeLocal(rAddr, data)
+		if err != nil {
+			logger.Debugf("[%s] write udp package to local failed, err: %v", session.table.scope, err)
+		}
+	}
+}
+
+// close session and all fake conn
+func (session *udpNatSession) close() {
+	session.lock.Lock()
+	if session.closed {
+		session.lock.Unlock()
+		return
+	}
+	session.closed = true
+	lConns := session.lConns
+	session.lConns = nil
+	session.pending = nil
+	handler := session.handler
+	release := session.release
+	session.release = nil
+	session.lock.Unlock()
+
+	session.table.remove(session)
+	if release != nil {
+		release()
+	}
+	// udp associate in progress is closed when it finishes
+	if handler != nil {
+		handler.Close()
+	}
+	for _, lConn := range lConns {
+		_ = lConn.Close()
+	}
+}
+
+// parse socks5 udp package, return source addr and data
+func parseUdpPackage(msg []byte) (*net.UDPAddr, []byte, error) {
+	pkg, err := com.UnMarshalPackage(msg)
+	if err != nil {
+		return nil, nil, err
+	}
+	// source of reply is always ip, domain can not be written back to client
+	addr, ok := pkg.Addr.(*net.UDPAddr)
+	if !ok {
+		return nil, nil, errors.New("addr type is not ip")
+	}
+	return addr, pkg.Data, nil
+}
@@ -0,0 +1,135 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package TProxy
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	com "github.com/linuxdeepin/deepin-network-proxy/com"
+	config "github.com/linuxdeepin/deepin-network-proxy/config"
+	define "github.com/linuxdeepin/deepin-network-proxy/define"
+)
+
+func TestParseUdpPackage(t *testing.T) {
+	for _, ip := range []string{"1.2.3.4", "2001:db8::1"} {
+		addr := &net.UDPAddr{IP: net.ParseIP(ip), Port: 3478}
+		msg := com.MarshalPackage(com.DataPackage{Addr: addr, Data: []byte("stun")}, "udp")
+		rAddr, data, err := parseUdpPackage(msg)
+		if err != nil {
+			t.Fatalf("parse udp package failed, err: %v", err)
+		}
+		if !rAddr.IP.Equal(addr.IP) || rAddr.Port != addr.Port || !bytes.Equal(data, []byte("stun")) {
+			t.Errorf("parse udp package incorrect, addr: %v, data: %s", rAddr, data)
+		}
+	}
+	// fragment is dropped
+	_, _, err := parseUdpPackage([]byte{0, 0, 1, 1, 1, 2, 3, 4, 0, 80})
+	if err == nil {
+		t.Error("fragment package should be dropped")
+	}
+	_, _, err = parseUdpPackage([]byte{0, 0, 0, 1, 1})
+	if err == nil {
+		t.Error("short package should be dropped")
+	}
+}
+
+func TestUdpNatPending(t *testing.T) {
+	relay, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer relay.Close()
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	// socks5 server answers udp associate slowly
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 64)
+		_, _ = conn.Read(buf)
+		_, _ = conn.Write([]byte{5, 0})
+		_, _ = conn.Read(buf)
+		time.Sleep(200 * time.Millisecond)
+		port := relay.LocalAddr().(*net.UDPAddr).Port
+		_, _ = conn.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, byte(port >> 8), byte(port)})
+		_, _ = conn.Read(buf)
+	}()
+
+	proxy := config.Proxy{Server: "127.0.0.1", Port: listener.Addr().(*net.TCPAddr).Port}
+	table := NewUdpNatTable(define.App, proxy)
+	defer table.Close()
+	associated := make(chan error, 1)
+	table.SetAssociateHook(func(err error) {
+		associated <- err
+	})
+	lAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000}
+	rAddr := &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 3478}
+	// worker relaying packages does not wait udp associate
+	start := time.Now()
+	for index := 0; index < 3; index++ {
+		err = table.Relay(lAddr, rAddr, []byte{byte(index)})
+		if err != nil {
+			t.Fatalf("relay failed, err: %v", err)
+		}
+	}
+	if cost := time.Since(start); cost > 100*time.Millisecond {
+		t.Errorf("relay waits udp associate, cost: %v", cost)
+	}
+	if err = <-associated; err != nil {
+		t.Fatalf("udp associate failed, err: %v", err)
+	}
+	// packages queued are sent in order
+	buf := make([]byte, 1024)
+	for index := 0; index < 3; index++ {
+		_ = relay.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := relay.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("read relay failed, err: %v", err)
+		}
+		_, data, err := parseUdpPackage(buf[:n])
+		if err != nil || len(data) != 1 || data[0] != byte(index) {
+			t.Fatalf("package %d got %v, err: %v", index, data, err)
+		}
+	}
+}
+
+func TestUdpNatSlot(t *testing.T) {
+	limiter := NewTunnelLimiter(1)
+	table := NewUdpNatTable(define.App, config.Proxy{Server: "127.0.0.1", Port: 1})
+	table.SetSlotHook(func() (func(), bool) {
+		return AcquireTunnel(0, limiter)
+	})
+	lAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40001}
+	rAddr := &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 3478}
+	// session which fails to associate gives slot back
+	_ = table.Relay(lAddr, rAddr, []byte("stun"))
+	deadline := time.Now().Add(2 * time.Second)
+	for table.Count() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if table.Count() != 0 || limiter.InUse() != 0 {
+		t.Fatalf("session failed should be removed and give slot back, sessions: %d, in use: %d", table.Count(), limiter.InUse())
+	}
+	// session without free slot is closed
+	release, _ := AcquireTunnel(0, limiter)
+	defer release()
+	_ = table.Relay(lAddr, rAddr, []byte("stun"))
+	deadline = time.Now().Add(2 * time.Second)
+	for table.Count() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if table.Count() != 0 || limiter.InUse() != 1 {
+		t.Errorf("session over limit should be closed, sessions: %d, in use: %d", table.Count(), limiter.InUse())
+	}
+}
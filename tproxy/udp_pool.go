@@ -0,0 +1,122 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package TProxy
+
+import (
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// datagrams are relayed by fixed workers instead of one goroutine per datagram, so voip and games
+// sending thousands of packages per second do not spawn goroutines without bound.
+// packages of one client endpoint always go to the same worker, order of them is kept and nat session
+// of endpoint is used by one worker at a time. queue of worker is bounded, package is dropped when
+// queue is full, as network may drop it anyway.
+
+// packages queued per worker if not set
+const udpQueueLen = 256
+
+// package read from transparent listener
+type UdpPackage struct {
+	LAddr *net.UDPAddr
+	RAddr *net.UDPAddr
+	Data  []byte
+}
+
+type UdpWorkerPool struct {
+	queues []chan UdpPackage
+	relay  func(pkg UdpPackage)
+
+	// closed when pool stops, queues are never closed so submit after stop is safe
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+
+	dropped uint64
+}
+
+// workers of zero means count of cpu, queue len of zero means default.
+// relay is called in worker and should not block, handshake of new session runs in its own goroutine,
+// otherwise it delays packages of other endpoints on the same worker.
+func NewUdpWorkerPool(workers int, queueLen int, relay func(pkg UdpPackage)) *UdpWorkerPool {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if queueLen <= 0 {
+		queueLen = udpQueueLen
+	}
+	pool := &UdpWorkerPool{
+		queues: make([]chan UdpPackage, workers),
+		relay:  relay,
+		done:   make(chan struct{}),
+	}
+	for index := range pool.queues {
+		queue := make(chan UdpPackage, queueLen)
+		pool.queues[index] = queue
+		pool.wg.Add(1)
+		go pool.work(queue)
+	}
+	return pool
+}
+
+// queue package to worker of client endpoint without blocking reader, false if dropped
+func (pool *UdpWorkerPool) Submit(pkg UdpPackage) bool {
+	queue := pool.queues[udpWorkerIndex(pkg.LAddr, len(pool.queues))]
+	select {
+	case <-pool.done:
+		return false
+	default:
+	}
+	select {
+	case queue <- pkg:
+		return true
+	default:
+		atomic.AddUint64(&pool.dropped, 1)
+		return false
+	}
+}
+
+// count of packages dropped because queue is full
+func (pool *UdpWorkerPool) Dropped() uint64 {
+	return atomic.LoadUint64(&pool.dropped)
+}
+
+// stop workers and wait for them, packages still queued are dropped
+func (pool *UdpWorkerPool) Close() {
+	pool.closeOnce.Do(func() {
+		close(pool.done)
+	})
+	pool.wg.Wait()
+}
+
+// relay packages of queue until pool stops
+func (pool *UdpWorkerPool) work(queue chan UdpPackage) {
+	defer pool.wg.Done()
+	for {
+		select {
+		case <-pool.done:
+			return
+		case pkg := <-queue:
+			pool.relay(pkg)
+		}
+	}
+}
+
+// worker of client endpoint, fnv-1a of ip and port without allocation
+func udpWorkerIndex(addr *net.UDPAddr, workers int) int {
+	if addr == nil || workers <= 1 {
+		return 0
+	}
+	hash := uint32(2166136261)
+	for _, b := range addr.IP.To16() {
+		hash ^= uint32(b)
+		hash *= 16777619
+	}
+	hash ^= uint32(addr.Port)
+	hash *= 16777619
+	return int(hash % uint32(workers))
+}
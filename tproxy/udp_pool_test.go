@@ -0,0 +1,106 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package TProxy
+
+import (
+	"net"
+	"sync"
+	"testing"
+)
+
+func TestUdpWorkerPool(t *testing.T) {
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	got := make(map[string][]byte)
+	pool := NewUdpWorkerPool(4, 64, func(pkg UdpPackage) {
+		lock.Lock()
+		got[pkg.LAddr.String()] = append(got[pkg.LAddr.String()], pkg.Data[0])
+		lock.Unlock()
+		wg.Done()
+	})
+	defer pool.Close()
+	for port := 1000; port < 1008; port++ {
+		lAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+		for seq := 0; seq < 16; seq++ {
+			wg.Add(1)
+			if !pool.Submit(UdpPackage{LAddr: lAddr, Data: []byte{byte(seq)}}) {
+				t.Fatal("package should be queued")
+			}
+		}
+	}
+	wg.Wait()
+	// packages of one endpoint keep their order
+	for lAddr, seqs := range got {
+		for index, seq := range seqs {
+			if int(seq) != index {
+				t.Fatalf("packages of [%s] out of order: %v", lAddr, seqs)
+			}
+		}
+	}
+}
+
+func TestUdpWorkerPoolFull(t *testing.T) {
+	block := make(chan struct{})
+	pool := NewUdpWorkerPool(1, 1, func(pkg UdpPackage) {
+		<-block
+	})
+	lAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1000}
+	// first is taken by worker, second waits in queue, others are dropped
+	for i := 0; i < 4; i++ {
+		pool.Submit(UdpPackage{LAddr: lAddr})
+	}
+	if pool.Dropped() == 0 {
+		t.Error("package should be dropped when queue is full")
+	}
+	close(block)
+	pool.Close()
+	if pool.Submit(UdpPackage{LAddr: lAddr}) {
+		t.Error("package should not be queued after close")
+	}
+}
+
+// compare pool with goroutine per package, run with -benchmem
+func BenchmarkUdpWorkerPool(b *testing.B) {
+	var wg sync.WaitGroup
+	pool := NewUdpWorkerPool(0, 0, func(pkg UdpPackage) {
+		wg.Done()
+	})
+	defer pool.Close()
+	lAddrs := benchUdpAddrs()
+	data := make([]byte, 160)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		wg.Add(1)
+		// dropped package is counted as relayed, as reader goes on
+		if !pool.Submit(UdpPackage{LAddr: lAddrs[i%len(lAddrs)], Data: data}) {
+			wg.Done()
+		}
+	}
+	wg.Wait()
+}
+
+func BenchmarkUdpGoroutinePerPackage(b *testing.B) {
+	var wg sync.WaitGroup
+	relay := func(pkg UdpPackage) {
+		wg.Done()
+	}
+	lAddrs := benchUdpAddrs()
+	data := make([]byte, 160)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		wg.Add(1)
+		go relay(UdpPackage{LAddr: lAddrs[i%len(lAddrs)], Data: data})
+	}
+	wg.Wait()
+}
+
+// client endpoints of benchmark
+func benchUdpAddrs() []*net.UDPAddr {
+	var lAddrs []*net.UDPAddr
+	for port := 40000; port < 40064; port++ {
+		lAddrs = append(lAddrs, &net.UDPAddr{IP: net.IPv4(192, 168, 1, 2), Port: port})
+	}
+	return lAddrs
+}
@@ -0,0 +1,219 @@
+// SPDX-FileCopyrightText: 2022 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+//go:build iouring
+// +build iouring
+
+package TProxy
+
+import (
+	"runtime"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// minimal io_uring, one operation is submitted and waited at a time.
+// recv and send are available since kernel 5.6
+
+// entries of ring, only one is in flight
+const uringEntries = 2
+
+// from linux/io_uring.h
+const (
+	uringOffSqRing     = 0
+	uringOffCqRing     = 0x8000000
+	uringOffSqes       = 0x10000000
+	uringEnterGetEvent = 1 << 0
+	uringOpSend        = 26
+	uringOpRecv        = 27
+)
+
+type uringSqringOffsets struct {
+	head        uint32
+	tail        uint32
+	ringMask    uint32
+	ringEntries uint32
+	flags       uint32
+	dropped     uint32
+	array       uint32
+	resv1       uint32
+	userAddr    uint64
+}
+
+type uringCqringOffsets struct {
+	head        uint32
+	tail        uint32
+	ringMask    uint32
+	ringEntries uint32
+	overflow    uint32
+	cqes        uint32
+	flags       uint32
+	resv1       uint32
+	userAddr    uint64
+}
+
+type uringParams struct {
+	sqEntries    uint32
+	cqEntries    uint32
+	flags        uint32
+	sqThreadCpu  uint32
+	sqThreadIdle uint32
+	features     uint32
+	wqFd         uint32
+	resv         [3]uint32
+	sqOff        uringSqringOffsets
+	cqOff        uringCqringOffsets
+}
+
+// submission queue entry
+type uringSqe struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	opFlags     uint32
+	userData    uint64
+	bufIndex    uint16
+	personality uint16
+	spliceFdIn  int32
+	addr3       uint64
+	pad         uint64
+}
+
+// completion queue entry
+type uringCqe struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+type uring struct {
+	fd     int
+	sqRing []byte
+	cqRing []byte
+	sqes   []byte
+
+	sqHead  *uint32
+	sqTail  *uint32
+	sqMask  uint32
+	sqArray unsafe.Pointer
+	cqHead  *uint32
+	cqTail  *uint32
+	cqMask  uint32
+	cqes    unsafe.Pointer
+}
+
+// set up ring and map its queues
+func newUring(entries uint32) (*uring, error) {
+	var params uringParams
+	fd, _, errno := unix.Syscall(unix.SYS_IO_URING_SETUP, uintptr(entries), uintptr(unsafe.Pointer(&params)), 0)
+	if errno != 0 {
+		return nil, errno
+	}
+	ring := &uring{fd: int(fd)}
+	var err error
+	sqSize := int(params.sqOff.array + params.sqEntries*4)
+	ring.sqRing, err = unix.Mmap(ring.fd, uringOffSqRing, sqSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		ring.close()
+		return nil, err
+	}
+	cqSize := int(params.cqOff.cqes + params.cqEntries*uint32(unsafe.Sizeof(uringCqe{})))
+	ring.cqRing, err = unix.Mmap(ring.fd, uringOffCqRing, cqSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		ring.close()
+		return nil, err
+	}
+	sqesSize := int(params.sqEntries * uint32(unsafe.Sizeof(uringSqe{})))
+	ring.sqes, err = unix.Mmap(ring.fd, uringOffSqes, sqesSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		ring.close()
+		return nil, err
+	}
+	sq := unsafe.Pointer(&ring.sqRing[0])
+	ring.sqHead = (*uint32)(uringPtr(sq, uintptr(params.sqOff.head)))
+	ring.sqTail = (*uint32)(uringPtr(sq, uintptr(params.sqOff.tail)))
+	ring.sqMask = *(*uint32)(uringPtr(sq, uintptr(params.sqOff.ringMask)))
+	ring.sqArray = uringPtr(sq, uintptr(params.sqOff.array))
+	cq := unsafe.Pointer(&ring.cqRing[0])
+	ring.cqHead = (*uint32)(uringPtr(cq, uintptr(params.cqOff.head)))
+	ring.cqTail = (*uint32)(uringPtr(cq, uintptr(params.cqOff.tail)))
+	ring.cqMask = *(*uint32)(uringPtr(cq, uintptr(params.cqOff.ringMask)))
+	ring.cqes = uringPtr(cq, uintptr(params.cqOff.cqes))
+	return ring, nil
+}
+
+// pointer at offset of mapped memory
+func uringPtr(base unsafe.Pointer, off uintptr) unsafe.Pointer {
+	return unsafe.Pointer(uintptr(base) + off)
+}
+
+// unmap queues and close ring
+func (ring *uring) close() {
+	for _, mem := range [][]byte{ring.sqRing, ring.cqRing, ring.sqes} {
+		if mem != nil {
+			_ = unix.Munmap(mem)
+		}
+	}
+	ring.sqRing, ring.cqRing, ring.sqes = nil, nil, nil
+	_ = unix.Close(ring.fd)
+}
+
+// recv without waiting, EAGAIN if no data
+func (ring *uring) recv(fd int, buf []byte) (int, error) {
+	return ring.do(uringOpRecv, fd, buf, unix.MSG_DONTWAIT)
+}
+
+// send without waiting, EAGAIN if buffer of socket is full
+func (ring *uring) send(fd int, buf []byte) (int, error) {
+	return ring.do(uringOpSend, fd, buf, unix.MSG_DONTWAIT|unix.MSG_NOSIGNAL)
+}
+
+// submit one operation and wait for its completion
+func (ring *uring) do(opcode uint8, fd int, buf []byte, flags uint32) (int, error) {
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	tail := atomic.LoadUint32(ring.sqTail)
+	index := tail & ring.sqMask
+	sqe := (*uringSqe)(unsafe.Pointer(&ring.sqes[uintptr(index)*unsafe.Sizeof(uringSqe{})]))
+	*sqe = uringSqe{
+		opcode:  opcode,
+		fd:      int32(fd),
+		addr:    uint64(uintptr(unsafe.Pointer(&buf[0]))),
+		len:     uint32(len(buf)),
+		opFlags: flags,
+	}
+	*(*uint32)(uringPtr(ring.sqArray, uintptr(index*4))) = index
+	atomic.StoreUint32(ring.sqTail, tail+1)
+	for {
+		// entry not consumed by kernel yet is submitted again after interrupted
+		toSubmit := tail + 1 - atomic.LoadUint32(ring.sqHead)
+		_, _, errno := unix.Syscall6(unix.SYS_IO_URING_ENTER, uintptr(ring.fd), uintptr(toSubmit), 1, uringEnterGetEvent, 0, 0)
+		if errno == unix.EINTR {
+			continue
+		}
+		if errno != 0 {
+			return 0, errno
+		}
+		head := atomic.LoadUint32(ring.cqHead)
+		if head == atomic.LoadUint32(ring.cqTail) {
+			continue
+		}
+		cqe := (*uringCqe)(uringPtr(ring.cqes, uintptr(head&ring.cqMask)*unsafe.Sizeof(uringCqe{})))
+		res := cqe.res
+		atomic.StoreUint32(ring.cqHead, head+1)
+		// kernel is done with buffer
+		runtime.KeepAlive(buf)
+		if res < 0 {
+			return 0, unix.Errno(-res)
+		}
+		return int(res), nil
+	}
+}